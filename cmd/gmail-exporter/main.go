@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	// Time zone data for --timezone on systems without it, such as Windows
+	_ "time/tzdata"
 
 	"github.com/octasoft-ltd/gmail-exporter/internal/cli"
 )
@@ -20,6 +22,6 @@ func main() {
 
 	if err := cli.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
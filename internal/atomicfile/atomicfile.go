@@ -0,0 +1,170 @@
+package atomicfile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TempSuffix marks files still being written. They are hidden and removed
+// on failure, but a crash can leave them behind.
+const TempSuffix = ".partial"
+
+// BackupSuffix is appended to the previous version kept by WriteFileBackup
+const BackupSuffix = ".bak"
+
+// Options controls how Write commits a file
+type Options struct {
+	// Sync flushes the file and its directory to disk, so the new content
+	// survives a crash or power loss rather than only a killed process
+	Sync bool
+	// Backup keeps the previous version of the file next to it
+	Backup bool
+}
+
+// Write streams a file through write into a temporary file in the same
+// directory and renames it over path once complete. Readers see either the
+// old or the new content, never a partial write.
+func Write(path string, perm os.FileMode, opts Options, write func(io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*"+TempSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if err := writeTemp(tmp, perm, opts.Sync, write); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if opts.Backup {
+		backup(path)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	if opts.Sync {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WriteFile writes data to path atomically and durably
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return Write(path, perm, Options{Sync: true}, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteFileBackup is WriteFile that also keeps the previous version as
+// path.bak, for state files that ReadFile can fall back on
+func WriteFileBackup(path string, data []byte, perm os.FileMode) error {
+	return Write(path, perm, Options{Sync: true, Backup: true}, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// ReadFile reads path and hands its content to parse. When parse fails, for
+// instance on a file truncated by an older version or a full disk, the
+// backup left by WriteFileBackup is tried instead. Errors from reading a
+// missing file are returned unwrapped so os.IsNotExist works on them.
+func ReadFile(path string, parse func([]byte) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	parseErr := parse(data)
+	if parseErr == nil {
+		return nil
+	}
+
+	backupPath := path + BackupSuffix
+	backupData, err := os.ReadFile(backupPath)
+	if err != nil {
+		return parseErr
+	}
+	if err := parse(backupData); err != nil {
+		return parseErr
+	}
+
+	logrus.WithError(parseErr).WithField("backup", backupPath).Warn("File is corrupt, using the previous version")
+	return nil
+}
+
+// IsTemp reports whether a file name belongs to an unfinished write
+func IsTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, TempSuffix)
+}
+
+// writeTemp fills and closes the temporary file
+func writeTemp(tmp *os.File, perm os.FileMode, sync bool, write func(io.Writer) error) error {
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if sync {
+		if err := tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("failed to sync temporary file: %w", err)
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	return nil
+}
+
+// backup keeps the current version of path as path.bak. It is a hard link
+// where possible, so path itself never goes missing.
+func backup(path string) {
+	backupPath := path + BackupSuffix
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	_ = os.Remove(backupPath)
+	if err := os.Link(path, backupPath); err == nil {
+		return
+	}
+
+	// Filesystems without hard links get a copy
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = os.WriteFile(backupPath, data, 0o600)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("path", path).Debug("Failed to keep backup")
+	}
+}
+
+// syncDir flushes a directory entry so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,130 @@
+package atomicfile
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	if err := WriteFile(path, []byte("one"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := WriteFile(path, []byte("two"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "two" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("permissions = %o, want 600", info.Mode().Perm())
+	}
+
+	// No temporary or backup files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want 1", len(entries))
+	}
+}
+
+func TestWrite_Failure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	if err := WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed write leaves the previous content and no temporary file
+	err := Write(path, 0o600, Options{}, func(w io.Writer) error {
+		_, _ = w.Write([]byte("partial"))
+		return errors.New("disk full")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "old" {
+		t.Errorf("content = %q, want old", data)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if IsTemp(e.Name()) {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestReadFile_Backup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	var got map[string]int
+	parse := func(data []byte) error {
+		got = nil
+		return json.Unmarshal(data, &got)
+	}
+
+	// A missing file is reported as such
+	if err := ReadFile(path, parse); !os.IsNotExist(err) {
+		t.Errorf("ReadFile() error = %v, want not exist", err)
+	}
+
+	if err := WriteFileBackup(path, []byte(`{"n":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileBackup(path, []byte(`{"n":2}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReadFile(path, parse); err != nil || got["n"] != 2 {
+		t.Fatalf("ReadFile() = %v, %v", got, err)
+	}
+
+	// A truncated file falls back on the previous version
+	if err := os.WriteFile(path, []byte(`{"n":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadFile(path, parse); err != nil || got["n"] != 1 {
+		t.Errorf("ReadFile() = %v, %v, want backup", got, err)
+	}
+
+	// Without a usable backup the parse error is returned
+	if err := os.WriteFile(path+BackupSuffix, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadFile(path, parse); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestIsTemp(t *testing.T) {
+	tests := map[string]bool{
+		".state.json.123" + TempSuffix: true,
+		"state.json":                   false,
+		"state" + TempSuffix:           false,
+		".hidden":                      false,
+	}
+	for name, want := range tests {
+		if got := IsTemp(name); got != want {
+			t.Errorf("IsTemp(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
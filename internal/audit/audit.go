@@ -0,0 +1,457 @@
+// Package audit keeps an append-only log of the changes gmail-exporter makes
+// to mailboxes, so that "who deleted what, when" can be answered afterwards.
+// Each line of the log is a JSON Entry holding the hash of the entry before
+// it, optionally signed with an Ed25519 key, so that editing, removing or
+// inserting entries afterwards is detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+)
+
+// DefaultFileName is the audit log kept in the ~/.gmail-exporter directory
+const DefaultFileName = "audit.jsonl"
+
+// Audited actions
+const (
+	ActionImport  = "import"
+	ActionArchive = "archive"
+	ActionDelete  = "delete"
+	ActionLabel   = "label"
+)
+
+// UnknownOperator is recorded when the operator could not be determined
+const UnknownOperator = "unknown"
+
+// Entry is one change to a mailbox
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Operator is the account the change was made with, as authorized by
+	// the token
+	Operator string `json:"operator"`
+	// Account is the mailbox changed, when it is not the operator's own
+	Account    string   `json:"account,omitempty"`
+	Action     string   `json:"action"`
+	MessageIDs []string `json:"message_ids"`
+	// AddLabels and RemoveLabels are the label IDs a label action changed
+	AddLabels    []string `json:"add_labels,omitempty"`
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+	// Source is the file imported or the filter file of a cleanup
+	Source string `json:"source,omitempty"`
+	Host   string `json:"host,omitempty"`
+
+	// PrevHash is the Hash of the entry before, and Hash the SHA-256 of
+	// this entry's JSON without Hash and Signature. Signature is the Ed25519
+	// signature of Hash by the key KeyID identifies. Fields added later must
+	// be omitempty to keep the hashes of older entries.
+	PrevHash  string `json:"prev_hash,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	KeyID     string `json:"key_id,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// tailSize is how much of the end of an audit log is read to find the hash
+// of its last entry
+const tailSize = 1 << 20
+
+// Log appends entries to an audit log file. A nil Log records nothing.
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	operator string
+	host     string
+	// last is the hash of the last entry, which the next entry chains to,
+	// as of when the file was size bytes long. Another process appending
+	// changes the size, and the hash is read again.
+	last  string
+	size  int64
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// Open opens the audit log at path for appending, creating it and its
+// directory if needed. Entries are recorded as made by operator and, when
+// signingKeyFile is set, signed with its Ed25519 key.
+func Open(path, operator, signingKeyFile string) (*Log, error) {
+	var key ed25519.PrivateKey
+	if signingKeyFile != "" {
+		var err error
+		if key, err = signing.LoadPrivateKey(signingKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load audit signing key: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	if operator == "" {
+		operator = UnknownOperator
+	}
+	log := &Log{file: file, operator: operator, host: host, size: -1, key: key}
+	if key != nil {
+		log.keyID = signing.KeyID(key.Public().(ed25519.PublicKey))
+	}
+	return log, nil
+}
+
+// lastHash returns the hash of the last readable entry of an audit log of
+// size bytes, or "" when there is none
+func lastHash(file *os.File, size int64) (string, error) {
+	offset := max(size-tailSize, 0)
+	tail := make([]byte, size-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	lines := bytes.Split(tail, []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var entry Entry
+		if json.Unmarshal(bytes.TrimSpace(lines[i]), &entry) == nil {
+			return entry.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// Record appends entry to the log, filling in the time, operator and host
+// when unset, and chains it to the entry before. The file is locked while
+// the entry before is read and the entry written, so processes sharing the
+// log keep one chain. Each entry is written and synced before Record
+// returns.
+func (l *Log) Record(entry Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.Operator == "" {
+		entry.Operator = l.operator
+	}
+	if entry.Host == "" {
+		entry.Host = l.host
+	}
+	entry.KeyID = l.keyID
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := lockFile(l.file); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer unlockFile(l.file)
+
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if info.Size() != l.size {
+		if l.last, err = lastHash(l.file, info.Size()); err != nil {
+			return err
+		}
+	}
+
+	entry.PrevHash = l.last
+	hash, err := entry.hash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	if l.key != nil {
+		entry.Signature = signing.Sign(l.key, []byte(hash))
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to audit log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to append to audit log: %w", err)
+	}
+	l.last = hash
+	l.size = info.Size() + int64(len(line)) + 1
+	return nil
+}
+
+// hash returns the SHA-256 of the entry's JSON without Hash and Signature
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	e.Signature = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	return signing.Hash(data), nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Operator returns the email address of the account service is authorized
+// for, or UnknownOperator when the profile cannot be read
+func Operator(service *gmail.Service) string {
+	profile, err := service.Users.GetProfile("me").Do()
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get account profile; auditing changes as an unknown operator")
+		return UnknownOperator
+	}
+	return profile.EmailAddress
+}
+
+// Load reads the entries of an audit log in the order they were recorded.
+// Unreadable lines, such as one cut short by a crash, are skipped.
+func Load(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			logrus.WithError(err).WithField("line", line).Warn("Skipping unreadable audit log entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Problem is an audit log entry that fails verification
+type Problem struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// Verification is the outcome of verifying an audit log
+type Verification struct {
+	Entries int `json:"entries"`
+	// Unchained counts the entries recorded before hash chaining, which
+	// cannot be verified
+	Unchained int `json:"unchained"`
+	Signed    int `json:"signed"`
+	// Head is the hash of the last entry. Recording it elsewhere detects a
+	// log cut short after it.
+	Head     string    `json:"head"`
+	Problems []Problem `json:"problems"`
+}
+
+// VerifyOptions control how strictly an audit log is verified
+type VerifyOptions struct {
+	// PublicKey, when set, must have signed every entry, so entries recorded
+	// before hash chaining fail too
+	PublicKey ed25519.PublicKey
+	// RequireChain rejects entries recorded before hash chaining, which
+	// stripping the hashes from an entry makes it look like
+	RequireChain bool
+}
+
+// Verify checks that every entry of the audit log at path is unchanged and
+// follows the entry before it
+func Verify(path string, options VerifyOptions) (*Verification, error) {
+	key := options.PublicKey
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	result := &Verification{Problems: []Problem{}}
+	report := func(line int, reason string) {
+		result.Problems = append(result.Problems, Problem{Line: line, Reason: reason})
+	}
+
+	// prev is the hash the next entry must chain to; unknown is set after
+	// an unreadable line, whose hash cannot be known
+	prev, unknown := "", false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		result.Entries++
+
+		var entry Entry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			report(line, "unreadable entry")
+			prev, unknown = "", true
+			continue
+		}
+
+		if entry.Hash == "" {
+			switch {
+			case prev != "" || unknown || options.RequireChain:
+				report(line, "entry is not chained")
+			case key != nil:
+				report(line, "entry is not signed")
+			}
+			if prev == "" && !unknown {
+				result.Unchained++
+			}
+			continue
+		}
+		if hash, err := entry.hash(); err != nil || hash != entry.Hash {
+			report(line, "entry was modified")
+		}
+		if !unknown && entry.PrevHash != prev {
+			report(line, "entry does not follow the entry before it (entries removed, inserted or reordered)")
+		}
+		if entry.Signature != "" {
+			result.Signed++
+		}
+		if key != nil {
+			switch {
+			case entry.Signature == "":
+				report(line, "entry is not signed")
+			case signing.Verify(key, []byte(entry.Hash), entry.Signature) != nil:
+				report(line, "signature does not match the public key")
+			}
+		}
+		prev, unknown = entry.Hash, false
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	result.Head = prev
+	return result, nil
+}
+
+// Filter selects audit entries. Empty fields match every entry.
+type Filter struct {
+	Action    string
+	Operator  string
+	MessageID string
+	Since     time.Time
+	Until     time.Time
+}
+
+// Match reports whether entry is selected by the filter. Operators are
+// compared case-insensitively.
+func (f Filter) Match(entry Entry) bool {
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Operator != "" && !strings.EqualFold(entry.Operator, f.Operator) {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.MessageID == "" {
+		return true
+	}
+	for _, id := range entry.MessageIDs {
+		if id == f.MessageID {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the entries matched by filter
+func Select(entries []Entry, filter Filter) []Entry {
+	selected := []Entry{}
+	for _, entry := range entries {
+		if filter.Match(entry) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// WriteJSON writes entries as indented JSON
+func WriteJSON(w io.Writer, entries []Entry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// WriteText writes a table of entries
+func WriteText(w io.Writer, entries []Entry) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "No audit entries")
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tOPERATOR\tACTION\tMESSAGES\tDETAILS\tSOURCE")
+	for _, entry := range entries {
+		operator := entry.Operator
+		if entry.Account != "" && entry.Account != entry.Operator {
+			operator += " (" + entry.Account + ")"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			operator,
+			entry.Action,
+			strings.Join(entry.MessageIDs, ","),
+			labelChanges(entry),
+			entry.Source,
+		)
+	}
+	return table.Flush()
+}
+
+// labelChanges describes the labels added and removed by an entry
+func labelChanges(entry Entry) string {
+	var changes []string
+	for _, label := range entry.AddLabels {
+		changes = append(changes, "+"+label)
+	}
+	for _, label := range entry.RemoveLabels {
+		changes = append(changes, "-"+label)
+	}
+	if len(changes) == 0 {
+		return "-"
+	}
+	return strings.Join(changes, " ")
+}
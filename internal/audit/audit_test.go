@@ -0,0 +1,356 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLog_RecordAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", DefaultFileName)
+
+	log, err := Open(path, "admin@example.com", "")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := log.Record(Entry{Action: ActionDelete, MessageIDs: []string{"m1"}, Source: "processed_emails.json"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopening appends, and a line cut short by a crash is skipped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	file.WriteString(`{"action":"del` + "\n")
+	file.Close()
+
+	log, err = Open(path, "", "")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := log.Record(Entry{Action: ActionImport, MessageIDs: []string{"m2"}}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	log.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected the two complete entries, got %+v", entries)
+	}
+	if entries[0].Operator != "admin@example.com" || entries[0].Action != ActionDelete || entries[0].MessageIDs[0] != "m1" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("Expected the time to be filled in")
+	}
+	if entries[1].Operator != UnknownOperator {
+		t.Errorf("Expected an unknown operator, got %q", entries[1].Operator)
+	}
+}
+
+func TestLog_Nil(t *testing.T) {
+	var log *Log
+	if err := log.Record(Entry{Action: ActionArchive}); err != nil {
+		t.Errorf("Record() on a nil log = %v, want nil", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Errorf("Close() on a nil log = %v, want nil", err)
+	}
+}
+
+func TestLog_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+
+	// Two processes appending to one log each chain to the other's entries
+	first, err := Open(path, "a@example.com", "")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer first.Close()
+	second, err := Open(path, "b@example.com", "")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer second.Close()
+
+	var wg sync.WaitGroup
+	for _, log := range []*Log{first, second, first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := log.Record(Entry{Action: ActionDelete, MessageIDs: []string{"m"}}); err != nil {
+					t.Errorf("Record failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := Verify(path, VerifyOptions{RequireChain: true})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if result.Entries != 40 || len(result.Problems) != 0 {
+		t.Errorf("Expected 40 chained entries, got %d with problems %+v", result.Entries, result.Problems)
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry := Entry{Time: at, Operator: "Admin@example.com", Action: ActionDelete, MessageIDs: []string{"m1", "m2"}}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"action", Filter{Action: ActionDelete}, true},
+		{"other action", Filter{Action: ActionArchive}, false},
+		{"operator case-insensitive", Filter{Operator: "admin@EXAMPLE.com"}, true},
+		{"other operator", Filter{Operator: "someone@example.com"}, false},
+		{"message", Filter{MessageID: "m2"}, true},
+		{"other message", Filter{MessageID: "m3"}, false},
+		{"since", Filter{Since: at}, true},
+		{"since later", Filter{Since: at.Add(time.Second)}, false},
+		{"until later", Filter{Until: at.Add(time.Second)}, true},
+		{"until", Filter{Until: at}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(entry); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	entries := []Entry{
+		{Operator: "admin@example.com", Action: ActionLabel, MessageIDs: []string{"m1"}, AddLabels: []string{"Label_1"}, RemoveLabels: []string{"INBOX"}},
+		{Operator: "app 1234", Account: "user@example.com", Action: ActionImport, MessageIDs: []string{"m2"}, Source: "a.eml"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, entries); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	for _, want := range []string{"+Label_1 -INBOX", "app 1234 (user@example.com)", "a.eml"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := WriteText(&buf, nil); err != nil || !strings.Contains(buf.String(), "No audit entries") {
+		t.Errorf("WriteText(nil) = %q, %v", buf.String(), err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "signing.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry recorded before hash chaining, then three chained ones
+	// written across two runs
+	path := filepath.Join(dir, DefaultFileName)
+	legacy := `{"time":"2024-01-01T00:00:00Z","operator":"a@example.com","action":"delete","message_ids":["m0"]}` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, ids := range [][]string{{"m1", "m2"}, {"m3"}} {
+		log, err := Open(path, "a@example.com", keyFile)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		for _, id := range ids {
+			if err := log.Record(Entry{Action: ActionDelete, MessageIDs: []string{id}}); err != nil {
+				t.Fatalf("Record failed: %v", err)
+			}
+		}
+		log.Close()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	tests := []struct {
+		name    string
+		lines   []string
+		key     ed25519.PublicKey
+		reasons []string
+	}{
+		{"intact", lines, nil, nil},
+		{"intact and signed", lines[1:], public, nil},
+		{"legacy entry with key", lines, public, []string{"not signed"}},
+		{"other key", lines, otherPublic, []string{"not signed", "signature does not match", "signature does not match", "signature does not match"}},
+		{"modified", []string{lines[0], lines[1], strings.Replace(lines[2], `"m2"`, `"m9"`, 1), lines[3]}, nil, []string{"entry was modified"}},
+		{"removed", []string{lines[0], lines[1], lines[3]}, nil, []string{"does not follow"}},
+		{"reordered", []string{lines[0], lines[2], lines[1], lines[3]}, nil, []string{"does not follow", "does not follow", "does not follow"}},
+		{"unchained insert", []string{lines[0], lines[1], legacy, lines[2], lines[3]}, nil, []string{"not chained"}},
+		{"cut short", []string{lines[0], lines[1], lines[2][:20] + "\n", lines[3]}, nil, []string{"unreadable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), DefaultFileName)
+			if err := os.WriteFile(tampered, []byte(strings.Join(tt.lines, "")), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := Verify(tampered, VerifyOptions{PublicKey: tt.key})
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if len(result.Problems) != len(tt.reasons) {
+				t.Fatalf("Verify() problems = %+v, want %v", result.Problems, tt.reasons)
+			}
+			for i, reason := range tt.reasons {
+				if !strings.Contains(result.Problems[i].Reason, reason) {
+					t.Errorf("Problem %d = %+v, want %q", i, result.Problems[i], reason)
+				}
+			}
+			wantUnchained := 0
+			if tt.lines[0] == legacy {
+				wantUnchained = 1
+			}
+			if result.Unchained != wantUnchained {
+				t.Errorf("Unchained = %d, want %d", result.Unchained, wantUnchained)
+			}
+		})
+	}
+
+	result, err := Verify(path, VerifyOptions{PublicKey: public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 4 || result.Signed != 3 || result.Head != entries[3].Hash {
+		t.Errorf("Verify() = %+v, want 4 entries, 3 signed and the last hash as head", result)
+	}
+	if entries[1].PrevHash != "" || entries[2].PrevHash != entries[1].Hash || entries[3].PrevHash != entries[2].Hash {
+		t.Errorf("Expected the entries to be chained across runs: %+v", entries)
+	}
+}
+
+func TestVerify_StrippedChain(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "signing.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, DefaultFileName)
+	log, err := Open(path, "a@example.com", keyFile)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if err := log.Record(Entry{Action: ActionDelete, MessageIDs: []string{id}}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	log.Close()
+
+	// Remove the chain and signature from every entry, and edit one, so
+	// that the log looks recorded before hash chaining
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stripped bytes.Buffer
+	for _, entry := range entries {
+		entry.PrevHash, entry.Hash, entry.KeyID, entry.Signature = "", "", "", ""
+		entry.MessageIDs = []string{"m9"}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stripped.Write(append(data, '\n'))
+	}
+	if err := os.WriteFile(path, stripped.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options VerifyOptions
+		reason  string
+	}{
+		{"public key", VerifyOptions{PublicKey: public}, "not signed"},
+		{"require chain", VerifyOptions{RequireChain: true}, "not chained"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Verify(path, tt.options)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if len(result.Problems) != 2 {
+				t.Fatalf("Verify() problems = %+v, want both entries", result.Problems)
+			}
+			for _, problem := range result.Problems {
+				if !strings.Contains(problem.Reason, tt.reason) {
+					t.Errorf("Problem = %+v, want %q", problem, tt.reason)
+				}
+			}
+		})
+	}
+
+	if result, err := Verify(path, VerifyOptions{}); err != nil || len(result.Problems) != 0 || result.Unchained != 2 {
+		t.Errorf("Expected a lenient verification to count the entries as unchained, got %+v, %v", result, err)
+	}
+}
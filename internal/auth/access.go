@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Access is the level of mailbox access a token grants. A profile can hold
+// a token per level so that each command uses the least privileged token
+// that is enough for it.
+type Access string
+
+// Access levels, from least to most privileged
+const (
+	// AccessReadonly reads messages and labels: export, list, labels, sync
+	// and diff
+	AccessReadonly Access = "readonly"
+	// AccessModify also imports, archives and relabels: import and cleanup
+	// --action archive or label
+	AccessModify Access = "modify"
+	// AccessFull also deletes permanently: cleanup --action delete. The
+	// token file itself holds the full access token.
+	AccessFull Access = "full"
+	// AccessSettings changes the mailbox settings, such as signatures:
+	// settings import. It is outside the hierarchy, as no other scope grants
+	// it and it grants nothing else.
+	AccessSettings Access = "settings"
+)
+
+// accessLevels lists the access levels from least to most privileged
+var accessLevels = []Access{AccessReadonly, AccessModify, AccessFull}
+
+// accessScopes are the OAuth scopes requested for each access level
+var accessScopes = map[Access]string{
+	AccessReadonly: gmail.GmailReadonlyScope,
+	AccessModify:   gmail.GmailModifyScope,
+	AccessFull:     gmailScope,
+	AccessSettings: gmail.GmailSettingsBasicScope,
+}
+
+// Elevate, when set, is called when a command needs more access than the
+// profile's tokens grant, to obtain a token for access in tokenFile, such
+// as by asking the user to log in again. Without it such commands fail.
+var Elevate func(access Access, credentialsFile, tokenFile string) error
+
+// ParseAccess parses an access level name
+func ParseAccess(name string) (Access, error) {
+	for _, access := range append(accessLevels, AccessSettings) {
+		if strings.EqualFold(name, string(access)) {
+			return access, nil
+		}
+	}
+	return "", fmt.Errorf("invalid access level: %s (valid: readonly, modify, full, settings)", name)
+}
+
+// Scope returns the OAuth scope requested for the access level
+func (a Access) Scope() string {
+	return accessScopes[a]
+}
+
+// covers reports whether a token with access a is enough for required
+func (a Access) covers(required Access) bool {
+	return a.rank() >= required.rank()
+}
+
+// rank orders the access levels
+func (a Access) rank() int {
+	for i, access := range accessLevels {
+		if access == a {
+			return i
+		}
+	}
+	return len(accessLevels) - 1
+}
+
+// TokenFileFor returns the file of the access token of the profile whose
+// token file is tokenFile: tokenFile itself for full access, otherwise a
+// sibling such as token.readonly.json
+func TokenFileFor(tokenFile string, access Access) string {
+	if access == AccessFull || access == "" || IsSecretURI(tokenFile) {
+		return tokenFile
+	}
+	ext := filepath.Ext(tokenFile)
+	return strings.TrimSuffix(tokenFile, ext) + "." + string(access) + ext
+}
+
+// SelectToken returns the token file, and its access level, of the least
+// privileged token of the profile that grants required. ok is false when
+// no such token exists.
+func SelectToken(tokenFile string, required Access) (path string, access Access, ok bool) {
+	levels := accessLevels
+	if required == AccessSettings {
+		levels = []Access{AccessSettings}
+	}
+	for _, access := range levels {
+		if !access.covers(required) {
+			continue
+		}
+		path := TokenFileFor(tokenFile, access)
+		if _, err := os.Stat(path); err == nil {
+			return path, access, true
+		}
+	}
+	return "", "", false
+}
+
+// hasTokenFile reports whether the profile has a token file of any access
+// level
+func hasTokenFile(tokenFile string) bool {
+	_, _, ok := SelectToken(tokenFile, AccessReadonly)
+	return ok
+}
+
+// resolveTokenFile returns the token file a command needing required access
+// uses: the least privileged token that grants it, a new token from Elevate
+// when only less privileged ones exist, or tokenFile when the profile has
+// none (or its token comes from the environment or a secret backend). Only
+// a settings token grants settings access, so it is always asked for.
+func resolveTokenFile(credentialsFile, tokenFile string, required Access) (string, error) {
+	if required == "" || tokenInEnv() || IsSecretURI(tokenFile) {
+		return tokenFile, nil
+	}
+	if path, _, ok := SelectToken(tokenFile, required); ok {
+		return path, nil
+	}
+	if !hasTokenFile(tokenFile) && required != AccessSettings {
+		return tokenFile, nil
+	}
+
+	elevated := TokenFileFor(tokenFile, required)
+	if Elevate == nil {
+		return "", fmt.Errorf("%w: this needs %s access, which no token of the profile grants; run 'gmail-exporter auth login --scope %s'",
+			ErrNotAuthenticated, required, required)
+	}
+	if err := Elevate(required, credentialsFile, elevated); err != nil {
+		return "", fmt.Errorf("%w: %s access was not granted: %w", ErrNotAuthenticated, required, err)
+	}
+	return elevated, nil
+}
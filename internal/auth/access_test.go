@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenFileFor(t *testing.T) {
+	tests := []struct {
+		access Access
+		want   string
+	}{
+		{AccessReadonly, "/home/me/token.readonly.json"},
+		{AccessModify, "/home/me/token.modify.json"},
+		{AccessFull, "/home/me/token.json"},
+	}
+
+	for _, tt := range tests {
+		if got := TokenFileFor("/home/me/token.json", tt.access); got != tt.want {
+			t.Errorf("TokenFileFor(%s) = %s, want %s", tt.access, got, tt.want)
+		}
+	}
+	if got := TokenFileFor("vault://secret/gmail#token", AccessReadonly); got != "vault://secret/gmail#token" {
+		t.Errorf("Expected a secret URI to be kept, got %s", got)
+	}
+}
+
+func TestSelectToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token.json")
+	write := func(access Access) {
+		if err := os.WriteFile(TokenFileFor(tokenFile, access), []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, ok := SelectToken(tokenFile, AccessReadonly); ok {
+		t.Error("Expected no token without token files")
+	}
+
+	write(AccessReadonly)
+	write(AccessFull)
+	tests := []struct {
+		required Access
+		want     Access
+	}{
+		{AccessReadonly, AccessReadonly},
+		{AccessModify, AccessFull},
+		{AccessFull, AccessFull},
+	}
+	for _, tt := range tests {
+		path, access, ok := SelectToken(tokenFile, tt.required)
+		if !ok || access != tt.want || path != TokenFileFor(tokenFile, tt.want) {
+			t.Errorf("SelectToken(%s) = %s, %s, %v, want the %s token", tt.required, path, access, ok, tt.want)
+		}
+	}
+
+	// Only a settings token grants settings access
+	if _, _, ok := SelectToken(tokenFile, AccessSettings); ok {
+		t.Error("Expected the full token not to grant settings access")
+	}
+	write(AccessSettings)
+	if path, access, ok := SelectToken(tokenFile, AccessSettings); !ok || access != AccessSettings || path != filepath.Join(dir, "token.settings.json") {
+		t.Errorf("SelectToken(settings) = %s, %s, %v, want the settings token", path, access, ok)
+	}
+	if _, access, _ := SelectToken(tokenFile, AccessReadonly); access != AccessReadonly {
+		t.Errorf("Expected the settings token not to grant readonly access, got %s", access)
+	}
+}
+
+func TestResolveTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token.json")
+	defer func() { Elevate = nil }()
+
+	// A profile without tokens fails later, as before
+	Elevate = nil
+	if path, err := resolveTokenFile("credentials.json", tokenFile, AccessModify); err != nil || path != tokenFile {
+		t.Errorf("resolveTokenFile() = %s, %v, want %s", path, err, tokenFile)
+	}
+	// but settings access needs its own token even then
+	if _, err := resolveTokenFile("credentials.json", tokenFile, AccessSettings); !errors.Is(err, ErrNotAuthenticated) {
+		t.Errorf("Expected a not authenticated error without a settings token, got %v", err)
+	}
+
+	if err := os.WriteFile(TokenFileFor(tokenFile, AccessReadonly), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveTokenFile("credentials.json", tokenFile, AccessModify); !errors.Is(err, ErrNotAuthenticated) {
+		t.Errorf("Expected a not authenticated error without elevation, got %v", err)
+	}
+
+	var elevated string
+	Elevate = func(access Access, credentialsFile, path string) error {
+		elevated = path
+		return nil
+	}
+	path, err := resolveTokenFile("credentials.json", tokenFile, AccessModify)
+	if err != nil || path != TokenFileFor(tokenFile, AccessModify) || elevated != path {
+		t.Errorf("Expected elevation to the modify token, got %s, %v (elevated %s)", path, err, elevated)
+	}
+
+	elevated = ""
+	if path, err := resolveTokenFile("credentials.json", tokenFile, AccessReadonly); err != nil || elevated != "" || path != TokenFileFor(tokenFile, AccessReadonly) {
+		t.Errorf("Expected the readonly token without elevation, got %s, %v", path, err)
+	}
+
+	Elevate = func(Access, string, string) error { return errors.New("declined") }
+	if _, err := resolveTokenFile("credentials.json", tokenFile, AccessFull); !errors.Is(err, ErrNotAuthenticated) {
+		t.Errorf("Expected a declined elevation to be a not authenticated error, got %v", err)
+	}
+}
+
+func TestParseAccess(t *testing.T) {
+	if access, err := ParseAccess("ReadOnly"); err != nil || access != AccessReadonly {
+		t.Errorf("ParseAccess() = %s, %v", access, err)
+	}
+	if access, err := ParseAccess("settings"); err != nil || access != AccessSettings {
+		t.Errorf("ParseAccess(settings) = %s, %v", access, err)
+	}
+	if _, err := ParseAccess("write"); err == nil {
+		t.Error("Expected an unknown access level to be rejected")
+	}
+}
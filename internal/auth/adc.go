@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+)
+
+// Authentication modes
+const (
+	// ModeAuto uses the OAuth token when one is available and falls back to
+	// Application Default Credentials on GCP or when
+	// GOOGLE_APPLICATION_CREDENTIALS is set
+	ModeAuto = "auto"
+	// ModeOAuth uses the credentials and token files from auth login
+	ModeOAuth = "oauth"
+	// ModeADC uses Application Default Credentials, such as the GCE/GKE
+	// metadata server or workload identity
+	ModeADC = "adc"
+)
+
+// onGCE reports whether the metadata server is reachable; a variable so tests
+// can stub it
+var onGCE = metadata.OnGCE
+
+// ValidateMode checks an authentication mode name
+func ValidateMode(mode string) error {
+	switch mode {
+	case "", ModeAuto, ModeOAuth, ModeADC:
+		return nil
+	default:
+		return fmt.Errorf("invalid auth mode: %s (valid: auto, oauth, adc)", mode)
+	}
+}
+
+// ResolveMode returns the mode to use for mode, deciding between OAuth and ADC
+// when mode is auto. A token from the environment or a secret backend, or an
+// existing token file, always selects OAuth.
+func ResolveMode(mode, tokenFile string) string {
+	if mode != "" && mode != ModeAuto {
+		return mode
+	}
+
+	if tokenInEnv() || IsSecretURI(tokenFile) {
+		return ModeOAuth
+	}
+	if hasTokenFile(tokenFile) {
+		return ModeOAuth
+	}
+	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" || onGCE() {
+		return ModeADC
+	}
+
+	return ModeOAuth
+}
+
+// NewGmailService returns a Gmail service authenticated according to mode,
+// with the least privileged OAuth token of the profile that grants access.
+// The authenticator is nil when Application Default Credentials are used.
+func NewGmailService(mode string, access Access, credentialsFile, tokenFile string) (*Authenticator, *gmail.Service, error) {
+	if ResolveMode(mode, tokenFile) == ModeADC {
+		service, err := adcGmailService()
+		return nil, service, err
+	}
+
+	tokenFile, err := resolveTokenFile(credentialsFile, tokenFile, access)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authenticator, err := NewScopedAuthenticator(credentialsFile, tokenFile, access)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create authenticator: %w", err)
+	}
+
+	service, err := authenticator.GetGmailService()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authenticator, service, nil
+}
+
+// adcGmailService returns a Gmail service using Application Default
+// Credentials. On GCE and GKE the instance or node pool must have been granted
+// the Gmail scope.
+func adcGmailService() (*gmail.Service, error) {
+	ctx := httpclient.Context(context.Background())
+
+	credentials, err := google.FindDefaultCredentials(ctx, gmailScope)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to find application default credentials: %w", ErrNotAuthenticated, err)
+	}
+
+	service, err := newGmailService(ctx, oauth2.NewClient(ctx, credentials.TokenSource))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Gmail service: %w", err)
+	}
+
+	return service, nil
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveMode(t *testing.T) {
+	tempDir := t.TempDir()
+	tokenFile := filepath.Join(tempDir, "token.json")
+
+	gce := false
+	original := onGCE
+	onGCE = func() bool { return gce }
+	t.Cleanup(func() { onGCE = original })
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv(TokenEnvVar, "")
+
+	if got := ResolveMode(ModeAuto, tokenFile); got != ModeOAuth {
+		t.Errorf("no credentials: ResolveMode() = %s, want oauth", got)
+	}
+
+	gce = true
+	if got := ResolveMode("", tokenFile); got != ModeADC {
+		t.Errorf("on GCE: ResolveMode() = %s, want adc", got)
+	}
+
+	// An existing token wins over the metadata server
+	if err := os.WriteFile(tokenFile, []byte(`{}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := ResolveMode(ModeAuto, tokenFile); got != ModeOAuth {
+		t.Errorf("with token file: ResolveMode() = %s, want oauth", got)
+	}
+
+	// An explicit mode is never overridden
+	if got := ResolveMode(ModeADC, tokenFile); got != ModeADC {
+		t.Errorf("explicit adc: ResolveMode() = %s, want adc", got)
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	for _, mode := range []string{"", ModeAuto, ModeOAuth, ModeADC} {
+		if err := ValidateMode(mode); err != nil {
+			t.Errorf("ValidateMode(%q) error = %v", mode, err)
+		}
+	}
+	if err := ValidateMode("browser"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestNewGmailService_ADC(t *testing.T) {
+	credentials := filepath.Join(t.TempDir(), "adc.json")
+	data := `{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"r"}`
+	if err := os.WriteFile(credentials, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentials)
+
+	authenticator, service, err := NewGmailService(ModeADC, AccessReadonly, "missing.json", "missing-token.json")
+	if err != nil {
+		t.Fatalf("NewGmailService() error = %v", err)
+	}
+	if authenticator != nil || service == nil {
+		t.Errorf("NewGmailService() = %v, %v", authenticator, service)
+	}
+}
@@ -3,26 +3,65 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
 )
 
+// ErrNotAuthenticated marks errors caused by a missing, invalid or expired token
+var ErrNotAuthenticated = errors.New("not authenticated")
+
 // Authenticator handles Gmail API authentication
 type Authenticator struct {
 	credentialsFile string
 	tokenFile       string
 	config          *oauth2.Config
+
+	// envToken is a token supplied through the environment or a secret
+	// backend, named by tokenOrigin. It takes the place of the token file and
+	// refreshed tokens are kept in memory only.
+	envToken    *oauth2.Token
+	tokenOrigin string
+
+	// source is the token source of the clients built by GetClient, which
+	// Reauthenticate points at a newly refreshed token
+	source *resettableTokenSource
+}
+
+// resettableTokenSource is a token source whose underlying source can be
+// replaced while clients use it
+type resettableTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+}
+
+// Token implements oauth2.TokenSource
+func (s *resettableTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	source := s.source
+	s.mu.Unlock()
+	return source.Token()
+}
+
+// reset replaces the underlying source
+func (s *resettableTokenSource) reset(source oauth2.TokenSource) {
+	s.mu.Lock()
+	s.source = source
+	s.mu.Unlock()
 }
 
 // Status represents the authentication status
@@ -30,34 +69,91 @@ type Status struct {
 	Status      string     `json:"status"`
 	TokenExpiry *time.Time `json:"token_expiry,omitempty"`
 	Email       string     `json:"email,omitempty"`
+	Source      string     `json:"source,omitempty"`
+	// Origin is the environment variable or secret URI of a supplied token
+	Origin string `json:"origin,omitempty"`
 }
 
-// NewAuthenticator creates a new authenticator instance
+// NewAuthenticator creates a new authenticator instance for the full access
+// token in tokenFile
 func NewAuthenticator(credentialsFile, tokenFile string) (*Authenticator, error) {
-	// Read credentials file
-	b, err := os.ReadFile(credentialsFile)
+	return NewScopedAuthenticator(credentialsFile, tokenFile, AccessFull)
+}
+
+// NewScopedAuthenticator creates an authenticator whose login requests
+// access, for the token in tokenFile (see TokenFileFor)
+func NewScopedAuthenticator(credentialsFile, tokenFile string, access Access) (*Authenticator, error) {
+	// A token from the environment or a secret backend may carry its own
+	// OAuth client
+	var envToken *oauth2.Token
+	var envClient *oauth2.Config
+	data, tokenOrigin, err := EnvTokenData()
 	if err != nil {
-		return nil, fmt.Errorf("unable to read client secret file: %w", err)
+		return nil, err
+	}
+	if data == nil && IsSecretURI(tokenFile) {
+		data, err = FetchSecret(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		tokenOrigin = tokenFile
+	}
+	if data != nil {
+		envToken, envClient, err = ParseToken(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", tokenOrigin, err)
+		}
 	}
 
-	// Parse credentials and create OAuth config
-	config, err := google.ConfigFromJSON(b, "https://mail.google.com/")
+	// Read credentials from the environment, a secret backend or the
+	// credentials file
+	b, credentialsOrigin, err := credentialsSecret.read()
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
+		return nil, err
+	}
+	if b == nil {
+		b, err = readSecretOrFile(credentialsFile)
+		if err != nil && !(os.IsNotExist(err) && envClient != nil) {
+			return nil, fmt.Errorf("unable to read client secret file: %w", err)
+		}
+	} else {
+		logrus.WithField("source", credentialsOrigin).Debug("Using credentials from environment")
+	}
+
+	config := envClient
+	if err == nil {
+		// Parse credentials and create OAuth config
+		config, err = google.ConfigFromJSON(b, gmailScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
+		}
 	}
 
 	// Set redirect URI to localhost for better UX
 	config.RedirectURL = "http://localhost:8080/callback"
+	config.Scopes = []string{access.Scope()}
+
+	if envToken != nil {
+		logrus.WithField("source", tokenOrigin).Debug("Using supplied token")
+	}
 
 	return &Authenticator{
 		credentialsFile: credentialsFile,
 		tokenFile:       tokenFile,
 		config:          config,
+		envToken:        envToken,
+		tokenOrigin:     tokenOrigin,
 	}, nil
 }
 
 // Authenticate performs the OAuth 2.0 authentication flow
 func (a *Authenticator) Authenticate() error {
+	// A supplied token needs no interactive step, only a working refresh
+	if a.envToken != nil {
+		logrus.WithField("source", a.tokenOrigin).Info("Using supplied token")
+		return a.RefreshToken()
+	}
+
 	// Check if we already have a valid token
 	token, err := a.loadToken()
 	if err == nil && token.Valid() {
@@ -157,7 +253,7 @@ func (a *Authenticator) authenticateWithLocalServer() (*oauth2.Token, error) {
 	fmt.Println()
 
 	// Try to open browser automatically
-	if err := openBrowser(authURL); err != nil {
+	if err := OpenBrowser(authURL); err != nil {
 		logrus.WithError(err).Warn("Failed to open browser automatically")
 	}
 
@@ -184,7 +280,7 @@ func (a *Authenticator) authenticateWithLocalServer() (*oauth2.Token, error) {
 	}
 
 	// Exchange code for token
-	token, err := a.config.Exchange(context.TODO(), authCode)
+	token, err := a.config.Exchange(httpclient.Context(context.Background()), authCode)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve token from web: %w", err)
 	}
@@ -215,7 +311,7 @@ func (a *Authenticator) authenticateManually() error {
 		return fmt.Errorf("unable to read authorization code: %w", err)
 	}
 
-	token, err := a.config.Exchange(context.TODO(), authCode)
+	token, err := a.config.Exchange(httpclient.Context(context.Background()), authCode)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve token from web: %w", err)
 	}
@@ -229,8 +325,8 @@ func (a *Authenticator) authenticateManually() error {
 	return nil
 }
 
-// openBrowser opens the specified URL in the default browser
-func openBrowser(url string) error {
+// OpenBrowser opens the specified URL in the default browser
+func OpenBrowser(url string) error {
 	var cmd string
 	var args []string
 
@@ -255,7 +351,7 @@ func (a *Authenticator) RefreshToken() error {
 	}
 
 	// Create a token source that will refresh the token
-	tokenSource := a.config.TokenSource(context.Background(), token)
+	tokenSource := a.config.TokenSource(httpclient.Context(context.Background()), token)
 	newToken, err := tokenSource.Token()
 	if err != nil {
 		return fmt.Errorf("unable to refresh token: %w", err)
@@ -277,9 +373,23 @@ func (a *Authenticator) GetStatus() (*Status, error) {
 		return &Status{Status: "not_authenticated"}, nil
 	}
 
-	status := &Status{
-		TokenExpiry: &token.Expiry,
+	status := &Status{Source: "file"}
+	if a.envToken != nil {
+		status.Source = "env"
+		if IsSecretURI(a.tokenOrigin) {
+			status.Source = "secret_backend"
+		}
+		status.Origin = a.tokenOrigin
+
+		// A supplied token often holds only a refresh token
+		if !token.Valid() {
+			if refreshed, err := a.config.TokenSource(httpclient.Context(context.Background()), token).Token(); err == nil {
+				token = refreshed
+				a.envToken = refreshed
+			}
+		}
 	}
+	status.TokenExpiry = &token.Expiry
 
 	if token.Valid() {
 		status.Status = "authenticated"
@@ -299,13 +409,13 @@ func (a *Authenticator) GetStatus() (*Status, error) {
 func (a *Authenticator) GetClient() (*http.Client, error) {
 	token, err := a.loadToken()
 	if err != nil {
-		return nil, fmt.Errorf("unable to load token: %w", err)
+		return nil, fmt.Errorf("%w: unable to load token: %w", ErrNotAuthenticated, err)
 	}
 
 	if !token.Valid() {
 		// Try to refresh the token
 		if err := a.RefreshToken(); err != nil {
-			return nil, fmt.Errorf("token expired and refresh failed: %w", err)
+			return nil, fmt.Errorf("%w: token expired and refresh failed: %w", ErrNotAuthenticated, err)
 		}
 		// Reload the refreshed token
 		token, err = a.loadToken()
@@ -314,7 +424,47 @@ func (a *Authenticator) GetClient() (*http.Client, error) {
 		}
 	}
 
-	return a.config.Client(context.Background(), token), nil
+	ctx := httpclient.Context(context.Background())
+	if a.source == nil {
+		a.source = &resettableTokenSource{}
+	}
+	a.source.reset(a.config.TokenSource(ctx, token))
+
+	// Unlike oauth2.NewClient, the transport must not cache the token
+	// itself, so that a reset source takes effect
+	base := httpclient.Client()
+	return &http.Client{
+		Transport: &oauth2.Transport{Base: base.Transport, Source: a.source},
+		Timeout:   base.Timeout,
+	}, nil
+}
+
+// Reauthenticate refreshes the token even though it has not expired, for
+// when the API rejects it mid-run, saves it and switches the clients built
+// by GetClient over to it
+func (a *Authenticator) Reauthenticate() error {
+	token, err := a.loadToken()
+	if err != nil {
+		return fmt.Errorf("%w: unable to load token: %w", ErrNotAuthenticated, err)
+	}
+	if token.RefreshToken == "" {
+		return fmt.Errorf("%w: the token has no refresh token", ErrNotAuthenticated)
+	}
+
+	ctx := httpclient.Context(context.Background())
+	refreshed, err := a.config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		return fmt.Errorf("%w: token refresh failed: %w", ErrNotAuthenticated, err)
+	}
+	if err := a.saveToken(refreshed); err != nil {
+		return fmt.Errorf("unable to save refreshed token: %w", err)
+	}
+
+	if a.source != nil {
+		a.source.reset(a.config.TokenSource(ctx, refreshed))
+	}
+	logrus.Info("Gmail rejected the token; refreshed it and resuming")
+	return nil
 }
 
 // GetGmailService returns an authenticated Gmail service
@@ -324,7 +474,7 @@ func (a *Authenticator) GetGmailService() (*gmail.Service, error) {
 		return nil, err
 	}
 
-	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	service, err := newGmailService(context.Background(), client)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create Gmail service: %w", err)
 	}
@@ -332,8 +482,13 @@ func (a *Authenticator) GetGmailService() (*gmail.Service, error) {
 	return service, nil
 }
 
-// loadToken loads the token from file
+// loadToken loads the token from the environment or from file
 func (a *Authenticator) loadToken() (*oauth2.Token, error) {
+	if a.envToken != nil {
+		token := *a.envToken
+		return &token, nil
+	}
+
 	f, err := os.Open(a.tokenFile)
 	if err != nil {
 		return nil, err
@@ -345,26 +500,31 @@ func (a *Authenticator) loadToken() (*oauth2.Token, error) {
 	return token, err
 }
 
-// saveToken saves the token to file
+// saveToken saves the token to file, or in memory when it came from the
+// environment
 func (a *Authenticator) saveToken(token *oauth2.Token) error {
+	if a.envToken != nil {
+		a.envToken = token
+		return nil
+	}
+
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(a.tokenFile), 0o700); err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(a.tokenFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	data, err := json.Marshal(token)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return json.NewEncoder(f).Encode(token)
+	return atomicfile.WriteFile(a.tokenFile, append(data, '\n'), 0o600)
 }
 
 // getUserEmail gets the authenticated user's email address
 func (a *Authenticator) getUserEmail(token *oauth2.Token) (string, error) {
-	client := a.config.Client(context.Background(), token)
-	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	client := a.config.Client(httpclient.Context(context.Background()), token)
+	service, err := newGmailService(context.Background(), client)
 	if err != nil {
 		return "", err
 	}
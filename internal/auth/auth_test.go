@@ -2,6 +2,9 @@ package auth
 
 import (
 	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -432,3 +435,60 @@ func TestAuthenticator_TokenFilePermissions(t *testing.T) {
 		t.Errorf("Expected file permissions %v, got %v", expectedMode, fileInfo.Mode().Perm())
 	}
 }
+
+func TestAuthenticator_Reauthenticate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token": "fresh", "token_type": "Bearer", "expires_in": 3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	credentials := `{"installed": {"client_id": "id", "client_secret": "secret", "redirect_uris": ["http://localhost"], "token_uri": "` + server.URL + `/token"}}`
+	if err := os.WriteFile(credentialsFile, []byte(credentials), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token.json")
+	token, _ := json.Marshal(&oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)})
+	if err := os.WriteFile(tokenFile, token, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := NewAuthenticator(credentialsFile, tokenFile)
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	client, err := authenticator.GetClient()
+	if err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+	authorization := func() string {
+		response, err := client.Get(server.URL + "/api")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return string(body)
+	}
+
+	if got := authorization(); got != "Bearer old" {
+		t.Fatalf("Expected the loaded token to be used, got %q", got)
+	}
+	if err := authenticator.Reauthenticate(); err != nil {
+		t.Fatalf("Reauthenticate() error = %v", err)
+	}
+	if got := authorization(); got != "Bearer fresh" {
+		t.Errorf("Expected the existing client to use the refreshed token, got %q", got)
+	}
+
+	saved, err := authenticator.loadToken()
+	if err != nil || saved.AccessToken != "fresh" || saved.RefreshToken != "refresh" {
+		t.Errorf("Expected the refreshed token to be saved with its refresh token, got %+v (%v)", saved, err)
+	}
+}
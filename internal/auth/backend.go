@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+)
+
+// Secret backend URI schemes accepted in place of the credentials, token and
+// service account key paths. Secrets are fetched when first needed and kept
+// in memory only; they are never written to disk.
+//
+//	gsm://PROJECT/SECRET[/VERSION]   Google Secret Manager (default version: latest)
+//	vault://PATH[#FIELD]             HashiCorp Vault KV v1 or v2 at VAULT_ADDR
+const (
+	SchemeGSM   = "gsm"
+	SchemeVault = "vault"
+)
+
+// secretFetchTimeout bounds a request to a secret backend
+const secretFetchTimeout = 30 * time.Second
+
+// secretManagerURL is the Secret Manager API; a variable so tests can stub it
+var secretManagerURL = "https://secretmanager.googleapis.com/v1/"
+
+// gsmClient returns the HTTP client for Secret Manager, authenticated with
+// Application Default Credentials; a variable so tests can stub it
+var gsmClient = func(ctx context.Context) (*http.Client, error) {
+	return google.DefaultClient(httpclient.Context(ctx), "https://www.googleapis.com/auth/cloud-platform")
+}
+
+// fetchedSecrets caches secrets by URI so each is fetched once per process
+var (
+	fetchedMu      sync.Mutex
+	fetchedSecrets = make(map[string][]byte)
+)
+
+// IsSecretURI reports whether path names a secret in a secret backend
+// rather than a file
+func IsSecretURI(path string) bool {
+	return strings.HasPrefix(path, SchemeGSM+"://") || strings.HasPrefix(path, SchemeVault+"://")
+}
+
+// readSecretOrFile returns the contents of a secret URI or of a file
+func readSecretOrFile(path string) ([]byte, error) {
+	if IsSecretURI(path) {
+		return FetchSecret(path)
+	}
+	return os.ReadFile(path)
+}
+
+// FetchSecret returns the secret named by a gsm:// or vault:// URI
+func FetchSecret(uri string) ([]byte, error) {
+	fetchedMu.Lock()
+	defer fetchedMu.Unlock()
+
+	if data, ok := fetchedSecrets[uri]; ok {
+		return data, nil
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret URI %s: %w", uri, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+
+	var data []byte
+	switch parsed.Scheme {
+	case SchemeGSM:
+		data, err = fetchGSM(ctx, parsed)
+	case SchemeVault:
+		data, err = fetchVault(ctx, parsed)
+	default:
+		err = fmt.Errorf("unsupported secret backend %q (valid: gsm, vault)", parsed.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch secret %s: %w", uri, err)
+	}
+
+	fetchedSecrets[uri] = data
+	return data, nil
+}
+
+// fetchGSM accesses a Google Secret Manager secret version
+func fetchGSM(ctx context.Context, uri *url.URL) ([]byte, error) {
+	parts := strings.Split(strings.Trim(uri.Path, "/"), "/")
+	if uri.Host == "" || parts[0] == "" || len(parts) > 2 {
+		return nil, fmt.Errorf("expected gsm://PROJECT/SECRET[/VERSION]")
+	}
+	version := "latest"
+	if len(parts) == 2 {
+		version = parts[1]
+	}
+
+	client, err := gsmClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to find application default credentials: %w", ErrNotAuthenticated, err)
+	}
+
+	endpoint := fmt.Sprintf("%sprojects/%s/secrets/%s/versions/%s:access",
+		secretManagerURL, url.PathEscape(uri.Host), url.PathEscape(parts[0]), url.PathEscape(version))
+	var response struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getJSON(ctx, client, endpoint, nil, &response); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(response.Payload.Data)
+}
+
+// fetchVault reads a field of a Vault KV secret. The field may be omitted
+// when the secret has a single one.
+func fetchVault(ctx context.Context, uri *url.URL) ([]byte, error) {
+	address := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if address == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Trim(uri.Host+uri.Path, "/")
+	if path == "" {
+		return nil, fmt.Errorf("expected vault://PATH[#FIELD]")
+	}
+
+	header := http.Header{"X-Vault-Token": []string{token}}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		header.Set("X-Vault-Namespace", namespace)
+	}
+
+	var response struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := getJSON(ctx, httpclient.Client(), address+"/v1/"+path, header, &response); err != nil {
+		return nil, err
+	}
+
+	// KV version 2 nests the fields under data.data beside data.metadata
+	fields := response.Data
+	if nested, ok := fields["data"].(map[string]any); ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = nested
+		}
+	}
+
+	field := uri.Fragment
+	if field == "" {
+		if len(fields) != 1 {
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("secret has fields %s; select one with #FIELD", strings.Join(names, ", "))
+		}
+		for name := range fields {
+			field = name
+		}
+	}
+
+	switch value := fields[field].(type) {
+	case nil:
+		return nil, fmt.Errorf("secret has no field %q", field)
+	case string:
+		return []byte(value), nil
+	default:
+		// A JSON document stored as structured fields
+		return json.Marshal(value)
+	}
+}
+
+// vaultToken returns VAULT_TOKEN, or the token saved by vault login
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+	return "", fmt.Errorf("%w: VAULT_TOKEN is not set and there is no ~/.vault-token", ErrNotAuthenticated)
+}
+
+// getJSON decodes the JSON response of a GET request
+func getJSON(ctx context.Context, client *http.Client, endpoint string, header http.Header, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		err := fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(body)))
+		if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("%w: %w", ErrNotAuthenticated, err)
+		}
+		return err
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
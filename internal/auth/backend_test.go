@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newVault serves Vault secrets by path, requiring the token "t"
+func newVault(t *testing.T, secrets map[string]string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "t" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		body, ok := secrets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "t")
+}
+
+func TestFetchSecret_Vault(t *testing.T) {
+	newVault(t, map[string]string{
+		"/v1/secret/data/gmail": `{"data":{"data":{"token":"{\"refresh_token\":\"r\"}","credentials":{"installed":{"client_id":"id"}}},"metadata":{"version":3}}}`,
+		"/v1/kv/gmail-token":    `{"data":{"value":"v1 secret"}}`,
+	})
+
+	tests := []struct {
+		uri     string
+		want    string
+		wantErr bool
+	}{
+		{"vault://secret/data/gmail#token", `{"refresh_token":"r"}`, false},
+		{"vault://secret/data/gmail#credentials", `{"installed":{"client_id":"id"}}`, false},
+		{"vault://kv/gmail-token", "v1 secret", false},
+		{"vault://secret/data/gmail", "", true},
+		{"vault://secret/data/gmail#missing", "", true},
+		{"vault://secret/data/absent#token", "", true},
+		{"s3://bucket/token", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			data, err := FetchSecret(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.want {
+				t.Errorf("FetchSecret() = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchSecret_GSM(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		data := base64.StdEncoding.EncodeToString([]byte(testCredentials))
+		w.Write([]byte(`{"name":"n","payload":{"data":"` + data + `"}}`))
+	}))
+	defer server.Close()
+
+	originalURL, originalClient := secretManagerURL, gsmClient
+	defer func() { secretManagerURL, gsmClient = originalURL, originalClient }()
+	secretManagerURL = server.URL + "/v1/"
+	gsmClient = func(context.Context) (*http.Client, error) { return server.Client(), nil }
+
+	a, err := NewAuthenticator("gsm://my-project/gmail-credentials", "gsm://my-project/gmail-token/2")
+	if err == nil {
+		t.Fatal("expected error for a token secret that is not a token")
+	}
+
+	// Credentials from the backend with a token from the environment
+	t.Setenv(TokenEnvVar, `{"refresh_token":"r"}`)
+	a, err = NewAuthenticator("gsm://my-project/gmail-credentials", "token.json")
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if a.config.ClientID != "env-id" {
+		t.Errorf("ClientID = %q, want env-id", a.config.ClientID)
+	}
+	if requested != "/v1/projects/my-project/secrets/gmail-credentials/versions/latest:access" {
+		t.Errorf("requested %s", requested)
+	}
+
+	if _, err := FetchSecret("gsm://my-project"); err == nil {
+		t.Error("expected error for a URI without a secret")
+	}
+}
+
+func TestNewAuthenticator_SecretToken(t *testing.T) {
+	newVault(t, map[string]string{
+		"/v1/secret/data/token": `{"data":{"data":{"token":"{\"client_id\":\"id\",\"client_secret\":\"s\",\"refresh_token\":\"r\"}"},"metadata":{}}}`,
+	})
+
+	uri := "vault://secret/data/token"
+	a, err := NewAuthenticator("missing.json", uri)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if a.tokenOrigin != uri || a.envToken == nil {
+		t.Errorf("expected the token from %s, got %q", uri, a.tokenOrigin)
+	}
+	if mode := ResolveMode(ModeAuto, uri); mode != ModeOAuth {
+		t.Errorf("ResolveMode() = %s, want %s", mode, ModeOAuth)
+	}
+	if err := ImportToken([]byte(`{"refresh_token":"r"}`), "credentials.json", uri); err == nil {
+		t.Error("expected error importing a token into a secret URI")
+	}
+}
@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+)
+
+// Check statuses
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+	CheckSkip = "skip"
+)
+
+// Check is the outcome of one Diagnose check. Fix says what to do about a
+// failure or warning.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// Endpoints used by Diagnose; variables so tests can point them elsewhere
+var (
+	connectivityURLs = []string{"https://oauth2.googleapis.com/", "https://gmail.googleapis.com/"}
+	tokenInfoURL     = "https://oauth2.googleapis.com/tokeninfo"
+)
+
+// doctorTimeout bounds each network check of Diagnose
+const doctorTimeout = 30 * time.Second
+
+// tokenExpiryWarning is how close to its expiry a token that cannot be
+// refreshed is reported
+const tokenExpiryWarning = 24 * time.Hour
+
+// CommandScopes are the OAuth scope sets that allow each command; a token
+// must hold all the scopes of at least one set
+var CommandScopes = []struct {
+	Command string
+	Scopes  [][]string
+}{
+	{"export, list, labels, sync, diff --live", [][]string{{gmail.GmailReadonlyScope}, {gmail.GmailModifyScope}, {gmail.MailGoogleComScope}}},
+	{"import", [][]string{{gmail.GmailInsertScope, gmail.GmailLabelsScope}, {gmail.GmailModifyScope}, {gmail.MailGoogleComScope}}},
+	{"cleanup --action archive/label", [][]string{{gmail.GmailModifyScope}, {gmail.MailGoogleComScope}}},
+	{"cleanup --action delete", [][]string{{gmail.MailGoogleComScope}}},
+}
+
+// Diagnose checks network access to Google, the credentials and token (or
+// Application Default Credentials in ADC mode), the scopes the token was
+// granted and whether the Gmail API is enabled. Checks that depend on a
+// failed one are skipped.
+func Diagnose(mode, credentialsFile, tokenFile string) []Check {
+	checks := []Check{checkConnectivity()}
+
+	var source oauth2.TokenSource
+	if ResolveMode(mode, tokenFile) == ModeADC {
+		var check Check
+		check, source = checkADC()
+		checks = append(checks, check)
+	} else {
+		credentials, authenticator := checkCredentials(credentialsFile, tokenFile)
+		checks = append(checks, credentials)
+		if authenticator == nil {
+			checks = append(checks, Check{Name: "token", Status: CheckSkip, Detail: "needs valid credentials"})
+		} else {
+			var check Check
+			check, source = checkToken(authenticator)
+			checks = append(checks, check)
+		}
+	}
+
+	if source == nil {
+		return append(checks,
+			Check{Name: "scopes", Status: CheckSkip, Detail: "needs a working token"},
+			Check{Name: "gmail_api", Status: CheckSkip, Detail: "needs a working token"})
+	}
+
+	token, err := source.Token()
+	if err != nil {
+		return append(checks,
+			Check{Name: "scopes", Status: CheckFail, Detail: err.Error(), Fix: "Run 'gmail-exporter auth login'"},
+			Check{Name: "gmail_api", Status: CheckSkip, Detail: "needs a working token"})
+	}
+	return append(checks, checkScopes(token), checkGmailAPI(source))
+}
+
+// checkConnectivity checks that Google's OAuth and Gmail API endpoints can be
+// reached through the configured proxy and CA certificates
+func checkConnectivity() Check {
+	check := Check{Name: "connectivity"}
+	client := httpclient.Client()
+	var reached []string
+	for _, endpoint := range connectivityURLs {
+		host := endpoint
+		if parsed, err := url.Parse(endpoint); err == nil {
+			host = parsed.Host
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err == nil {
+			var response *http.Response
+			response, err = client.Do(request)
+			if err == nil {
+				response.Body.Close()
+			}
+		}
+		cancel()
+		if err != nil {
+			check.Status = CheckFail
+			check.Detail = fmt.Sprintf("cannot reach %s: %v", host, err)
+			check.Fix = connectivityFix(err)
+			return check
+		}
+		reached = append(reached, host)
+	}
+
+	check.Status = CheckOK
+	check.Detail = "reached " + strings.Join(reached, ", ")
+	return check
+}
+
+// connectivityFix suggests what to do about a connection error
+func connectivityFix(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var verifyErr *tls.CertificateVerificationError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &unknownAuthority), errors.As(err, &verifyErr):
+		return "A proxy or firewall is intercepting TLS; add its CA certificate to ca_certs in the config file"
+	case strings.Contains(err.Error(), "proxyconnect"):
+		return "The proxy refused the connection; check proxy_url in the config file or HTTPS_PROXY"
+	case errors.As(err, &dnsErr):
+		return "The host name did not resolve; check DNS, or set proxy_url if the network requires a proxy"
+	default:
+		return "Check that this host can make HTTPS connections to Google, or set proxy_url if the network requires a proxy"
+	}
+}
+
+// checkCredentials checks that the OAuth client credentials, and a token
+// supplied through the environment or a secret backend, can be read and
+// parsed, returning an authenticator for the token check when they can
+func checkCredentials(credentialsFile, tokenFile string) (Check, *Authenticator) {
+	check := Check{Name: "credentials"}
+	authenticator, err := NewAuthenticator(credentialsFile, tokenFile)
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = err.Error()
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			check.Fix = "Download an OAuth client ID (Desktop app) from the Google Cloud Console and run 'gmail-exporter auth setup -c FILE'"
+		case isServiceAccountKey(credentialsFile):
+			check.Fix = "This is a service account key; pass it to export with --service-account-key, or use an OAuth client ID (Desktop app) as the credentials file"
+		default:
+			check.Fix = "Download the OAuth client ID JSON again from the Google Cloud Console and run 'gmail-exporter auth setup -c FILE'"
+		}
+		return check, nil
+	}
+
+	check.Status = CheckOK
+	check.Detail = "OAuth client " + authenticator.config.ClientID
+	if origin := credentialsOrigin(credentialsFile); origin != "" {
+		check.Detail += " from " + origin
+	}
+	return check, authenticator
+}
+
+// credentialsOrigin names where the credentials were read from
+func credentialsOrigin(credentialsFile string) string {
+	if credentialsSecret.set() {
+		return "the environment"
+	}
+	if _, err := os.Stat(credentialsFile); err == nil || IsSecretURI(credentialsFile) {
+		return credentialsFile
+	}
+	return ""
+}
+
+// isServiceAccountKey reports whether path holds a service account key
+func isServiceAccountKey(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var key struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(data, &key) == nil && key.Type == "service_account"
+}
+
+// checkToken checks that the token loads and can be refreshed, without
+// saving the refreshed token
+func checkToken(a *Authenticator) (Check, oauth2.TokenSource) {
+	check := Check{Name: "token"}
+	token, err := a.loadToken()
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = fmt.Sprintf("unable to load token: %v", err)
+		if errors.Is(err, os.ErrNotExist) {
+			check.Detail = "no token at " + a.tokenFile
+		}
+		check.Fix = "Run 'gmail-exporter auth login', or 'gmail-exporter auth import-token' on hosts without a browser"
+		return check, nil
+	}
+
+	source := a.config.TokenSource(httpclient.Context(context.Background()), token)
+	if token.RefreshToken == "" {
+		if !token.Valid() {
+			check.Status = CheckFail
+			check.Detail = "the token expired at " + token.Expiry.Local().Format(time.RFC3339) + " and has no refresh token"
+			check.Fix = "Run 'gmail-exporter auth login'"
+			return check, nil
+		}
+		check.Status = CheckOK
+		check.Detail = "valid until " + token.Expiry.Local().Format(time.RFC3339)
+		if !token.Expiry.IsZero() && time.Until(token.Expiry) < tokenExpiryWarning {
+			check.Status = CheckWarn
+			check.Detail += "; it has no refresh token and stops working then"
+			check.Fix = "Run 'gmail-exporter auth login' for a token that can be refreshed"
+		}
+		return check, source
+	}
+
+	// Refresh even a valid token, to check that it still can be
+	ctx, cancel := context.WithTimeout(httpclient.Context(context.Background()), doctorTimeout)
+	defer cancel()
+	refreshed, err := a.config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = fmt.Sprintf("unable to refresh token: %v", err)
+		check.Fix = refreshFix(err)
+		return check, nil
+	}
+
+	check.Status = CheckOK
+	check.Detail = "refreshed; the new access token is valid until " + refreshed.Expiry.Local().Format(time.RFC3339)
+	if a.envToken != nil {
+		check.Detail = "from " + a.tokenOrigin + ", " + check.Detail
+	}
+	return check, oauth2.StaticTokenSource(refreshed)
+}
+
+// refreshFix suggests what to do about a failed token refresh
+func refreshFix(err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return "Check connectivity to oauth2.googleapis.com, then run 'gmail-exporter auth doctor' again"
+	}
+	switch retrieveErr.ErrorCode {
+	case "invalid_grant":
+		return "The token was revoked or has expired; apps in testing mode get tokens that expire after 7 days. " +
+			"Run 'gmail-exporter auth login', and publish the OAuth consent screen to stop weekly expiry"
+	case "invalid_client", "unauthorized_client":
+		return "The token was issued to a different OAuth client than the credentials file, or the client was deleted; " +
+			"run 'gmail-exporter auth login' with the current credentials"
+	default:
+		return "Run 'gmail-exporter auth login'"
+	}
+}
+
+// checkADC checks that Application Default Credentials can be found and
+// produce a token
+func checkADC() (Check, oauth2.TokenSource) {
+	check := Check{Name: "application_default_credentials"}
+	ctx, cancel := context.WithTimeout(httpclient.Context(context.Background()), doctorTimeout)
+	defer cancel()
+
+	credentials, err := google.FindDefaultCredentials(ctx, gmailScope)
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = err.Error()
+		check.Fix = "Set GOOGLE_APPLICATION_CREDENTIALS or run on GCP with a service account granted the Gmail scope; use --auth-mode oauth for a user token"
+		return check, nil
+	}
+	token, err := credentials.TokenSource.Token()
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = fmt.Sprintf("unable to get a token: %v", err)
+		check.Fix = "Grant the instance or node pool the https://mail.google.com/ scope, or check the key in GOOGLE_APPLICATION_CREDENTIALS"
+		return check, nil
+	}
+
+	check.Status = CheckOK
+	check.Detail = "found"
+	if credentials.ProjectID != "" {
+		check.Detail += " for project " + credentials.ProjectID
+	}
+	return check, oauth2.StaticTokenSource(token)
+}
+
+// checkScopes checks the scopes granted to the token against the scopes
+// each command needs
+func checkScopes(token *oauth2.Token) Check {
+	check := Check{Name: "scopes"}
+	granted, err := grantedScopes(token.AccessToken)
+	if err != nil {
+		check.Status = CheckWarn
+		check.Detail = fmt.Sprintf("unable to look up the granted scopes: %v", err)
+		return check
+	}
+
+	var allowed, denied []string
+	for _, command := range CommandScopes {
+		if slices.ContainsFunc(command.Scopes, func(set []string) bool {
+			return !slices.ContainsFunc(set, func(scope string) bool { return !slices.Contains(granted, scope) })
+		}) {
+			allowed = append(allowed, command.Command)
+		} else {
+			denied = append(denied, command.Command)
+		}
+	}
+
+	check.Detail = "granted " + strings.Join(granted, " ")
+	switch {
+	case len(denied) == 0:
+		check.Status = CheckOK
+	case len(allowed) == 0:
+		check.Status = CheckFail
+		check.Detail += "; no command is allowed"
+		check.Fix = "Run 'gmail-exporter auth login' and grant access to Gmail"
+	default:
+		check.Status = CheckWarn
+		check.Detail += "; not enough for " + strings.Join(denied, "; ")
+		check.Fix = "Run 'gmail-exporter auth login' for a token with full Gmail access if you need these commands"
+	}
+	return check
+}
+
+// grantedScopes returns the scopes of an access token from Google's token
+// info endpoint
+func grantedScopes(accessToken string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenInfoURL+"?access_token="+url.QueryEscape(accessToken), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpclient.Client().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token info returned %s", response.Status)
+	}
+
+	var info struct {
+		Scope string `json:"scope"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to parse token info: %w", err)
+	}
+	return strings.Fields(info.Scope), nil
+}
+
+// checkGmailAPI checks that the Gmail API accepts the token, which fails
+// when the API is not enabled on the OAuth client's project
+func checkGmailAPI(source oauth2.TokenSource) Check {
+	check := Check{Name: "gmail_api"}
+	ctx, cancel := context.WithTimeout(httpclient.Context(context.Background()), doctorTimeout)
+	defer cancel()
+
+	service, err := newGmailService(ctx, oauth2.NewClient(ctx, source))
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = err.Error()
+		return check
+	}
+
+	profile, err := service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = err.Error()
+		check.Fix = gmailAPIFix(err)
+		return check
+	}
+
+	check.Status = CheckOK
+	check.Detail = fmt.Sprintf("enabled; mailbox %s holds %d messages", profile.EmailAddress, profile.MessagesTotal)
+	return check
+}
+
+// gmailAPIFix suggests what to do about a failed Gmail API call
+func gmailAPIFix(err error) string {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return "Check connectivity to gmail.googleapis.com"
+	}
+
+	disabled := strings.Contains(apiErr.Message, "has not been used") || strings.Contains(apiErr.Message, "is disabled")
+	for _, item := range apiErr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			disabled = true
+		}
+	}
+	switch {
+	case disabled:
+		return "Enable the Gmail API for the OAuth client's project at https://console.cloud.google.com/apis/library/gmail.googleapis.com, wait a few minutes and try again"
+	case apiErr.Code == http.StatusUnauthorized:
+		return "Gmail rejected the token; run 'gmail-exporter auth login'"
+	case apiErr.Code == http.StatusForbidden:
+		return "The token lacks a Gmail scope, or the Workspace admin has blocked API access for this account"
+	default:
+		return "Try again later; Gmail returned an unexpected error"
+	}
+}
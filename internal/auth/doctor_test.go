@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// doctorServer stands in for Google's token, token info and Gmail endpoints.
+// The token endpoint answers with refreshErr when set, and the profile
+// endpoint with the Gmail API disabled error when disabled is set.
+func doctorServer(t *testing.T, refreshErr string, disabled bool) (credentialsFile, tokenFile string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			if refreshErr != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "` + refreshErr + `"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "fresh", "token_type": "Bearer", "expires_in": 3600}`))
+		case "/tokeninfo":
+			_, _ = w.Write([]byte(`{"scope": "https://www.googleapis.com/auth/gmail.readonly"}`))
+		case "/gmail/v1/users/me/profile":
+			if disabled {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"error": {"code": 403, "message": "Gmail API has not been used in project 123 before or it is disabled.",
+					"errors": [{"reason": "accessNotConfigured"}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"emailAddress": "user@example.com", "messagesTotal": 42}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	oldConnectivity, oldTokenInfo, oldGmail := connectivityURLs, tokenInfoURL, gmailEndpoint
+	connectivityURLs = []string{server.URL + "/"}
+	tokenInfoURL = server.URL + "/tokeninfo"
+	gmailEndpoint = server.URL + "/"
+	t.Cleanup(func() { connectivityURLs, tokenInfoURL, gmailEndpoint = oldConnectivity, oldTokenInfo, oldGmail })
+
+	dir := t.TempDir()
+	credentialsFile = filepath.Join(dir, "credentials.json")
+	credentials, _ := json.Marshal(map[string]any{
+		"installed": map[string]any{
+			"client_id":     "client-1",
+			"client_secret": "secret",
+			"auth_uri":      server.URL + "/auth",
+			"token_uri":     server.URL + "/token",
+			"redirect_uris": []string{"http://localhost"},
+		},
+	})
+	if err := os.WriteFile(credentialsFile, credentials, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile = filepath.Join(dir, "token.json")
+	if err := os.WriteFile(tokenFile, []byte(`{"access_token": "old", "refresh_token": "refresh", "expiry": "2020-01-01T00:00:00Z"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return credentialsFile, tokenFile
+}
+
+// statuses maps each check name to its status
+func statuses(checks []Check) map[string]string {
+	result := make(map[string]string)
+	for _, check := range checks {
+		result[check.Name] = check.Status
+	}
+	return result
+}
+
+func findCheck(checks []Check, name string) Check {
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return Check{}
+}
+
+func TestDiagnose(t *testing.T) {
+	credentialsFile, tokenFile := doctorServer(t, "", false)
+	checks := Diagnose(ModeOAuth, credentialsFile, tokenFile)
+
+	want := map[string]string{
+		"connectivity": CheckOK,
+		"credentials":  CheckOK,
+		"token":        CheckOK,
+		"scopes":       CheckWarn,
+		"gmail_api":    CheckOK,
+	}
+	got := statuses(checks)
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("Expected %s check to be %s, got %s (%+v)", name, status, got[name], findCheck(checks, name))
+		}
+	}
+
+	scopes := findCheck(checks, "scopes")
+	if !strings.Contains(scopes.Detail, "import") || strings.Contains(scopes.Detail, "export,") {
+		t.Errorf("Expected a read-only token to be reported as not enough for import only, got %q", scopes.Detail)
+	}
+	if api := findCheck(checks, "gmail_api"); !strings.Contains(api.Detail, "user@example.com") {
+		t.Errorf("Expected the mailbox address, got %q", api.Detail)
+	}
+
+	// The refreshed token is not saved
+	data, err := os.ReadFile(tokenFile)
+	if err != nil || !strings.Contains(string(data), `"old"`) {
+		t.Errorf("Expected the token file to be left alone, got %s", data)
+	}
+}
+
+func TestDiagnose_Failures(t *testing.T) {
+	tests := []struct {
+		name       string
+		refreshErr string
+		disabled   bool
+		missing    bool
+		failed     string
+		fix        string
+		skipped    []string
+	}{
+		{"missing credentials", "", false, true, "credentials", "auth setup", []string{"token", "scopes", "gmail_api"}},
+		{"revoked token", "invalid_grant", false, false, "token", "testing mode", []string{"scopes", "gmail_api"}},
+		{"token of another client", "invalid_client", false, false, "token", "different OAuth client", []string{"scopes", "gmail_api"}},
+		{"Gmail API disabled", "", true, false, "gmail_api", "Enable the Gmail API", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credentialsFile, tokenFile := doctorServer(t, tt.refreshErr, tt.disabled)
+			if tt.missing {
+				credentialsFile += ".missing"
+			}
+			checks := Diagnose(ModeOAuth, credentialsFile, tokenFile)
+
+			check := findCheck(checks, tt.failed)
+			if check.Status != CheckFail || !strings.Contains(check.Fix, tt.fix) {
+				t.Errorf("Expected %s to fail with a fix mentioning %q, got %+v", tt.failed, tt.fix, check)
+			}
+			for _, name := range tt.skipped {
+				if status := statuses(checks)[name]; status != CheckSkip {
+					t.Errorf("Expected %s to be skipped, got %s", name, status)
+				}
+			}
+		})
+	}
+}
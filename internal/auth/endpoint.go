@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// gmailEndpoint and gmailHeaders override the Gmail API endpoint of every
+// Gmail service created by this package and add headers to its requests,
+// to reach the API through a gateway or a mock server (see SetAPIEndpoint
+// and SetAPIHeaders)
+var (
+	gmailEndpoint = ""
+	gmailHeaders  http.Header
+)
+
+// SetAPIEndpoint points the Gmail services created by this package at
+// endpoint instead of https://gmail.googleapis.com/: a corporate API gateway,
+// a DLP proxy, an emulator or a mock server for integration tests and
+// offline demos. An empty endpoint restores the default.
+func SetAPIEndpoint(endpoint string) error {
+	if endpoint == "" {
+		gmailEndpoint = ""
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid API endpoint %q: want an http or https URL", endpoint)
+	}
+	gmailEndpoint = strings.TrimSuffix(endpoint, "/") + "/"
+	return nil
+}
+
+// APIEndpoint returns the Gmail API endpoint set by SetAPIEndpoint, or ""
+func APIEndpoint() string {
+	return gmailEndpoint
+}
+
+// SetAPIHeaders adds headers to every request of the Gmail services created
+// by this package, such as the key or routing header an API gateway wants.
+// A Host header sets the host requested. The Authorization header is the
+// OAuth token's and cannot be set.
+func SetAPIHeaders(headers http.Header) error {
+	for name := range headers {
+		if strings.EqualFold(name, "Authorization") {
+			return fmt.Errorf("the Authorization header of API requests carries the OAuth token and cannot be set")
+		}
+	}
+	gmailHeaders = headers.Clone()
+	return nil
+}
+
+// ParseAPIHeaders parses "Name: value" header lines
+func ParseAPIHeaders(lines []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid API header %q: want \"Name: value\"", line)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// newGmailService returns a Gmail service sending its requests through
+// client to the configured endpoint, with the configured headers
+func newGmailService(ctx context.Context, client *http.Client) (*gmail.Service, error) {
+	if len(gmailHeaders) > 0 {
+		client = &http.Client{
+			Transport:     &headerTransport{base: client.Transport, headers: gmailHeaders},
+			CheckRedirect: client.CheckRedirect,
+			Jar:           client.Jar,
+			Timeout:       client.Timeout,
+		}
+	}
+
+	options := []option.ClientOption{option.WithHTTPClient(client)}
+	if gmailEndpoint != "" {
+		options = append(options, option.WithEndpoint(gmailEndpoint))
+	}
+	service, err := gmail.NewService(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	serviceClients.Store(service, client)
+	return service, nil
+}
+
+// serviceClients maps the Gmail services created by this package to the
+// HTTP clients they send their requests through
+var serviceClients sync.Map
+
+// HTTPClient returns the authorized HTTP client, with the configured
+// headers, of a Gmail service created by this package, or nil for other
+// services. It sends requests the generated client cannot, such as one
+// whose response is read as a stream.
+func HTTPClient(service *gmail.Service) *http.Client {
+	client, ok := serviceClients.Load(service)
+	if !ok {
+		return nil
+	}
+	return client.(*http.Client)
+}
+
+// headerTransport adds headers to the requests sent through base
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+// RoundTrip sends a copy of req with the headers added
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetAPIEndpoint(t *testing.T) {
+	t.Cleanup(func() { _ = SetAPIEndpoint("") })
+
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"http://127.0.0.1:8085", "http://127.0.0.1:8085/", false},
+		{"https://gmail.example.com/", "https://gmail.example.com/", false},
+		{"127.0.0.1:8085", "", true},
+		{"ftp://example.com/", "", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		_ = SetAPIEndpoint("")
+		err := SetAPIEndpoint(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetAPIEndpoint(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+		}
+		if got := APIEndpoint(); got != tt.want {
+			t.Errorf("APIEndpoint() after %q = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestAPIHeaders(t *testing.T) {
+	t.Cleanup(func() {
+		_ = SetAPIEndpoint("")
+		_ = SetAPIHeaders(nil)
+	})
+
+	if _, err := ParseAPIHeaders([]string{"X-Gateway-Key"}); err == nil {
+		t.Error("Expected a header without a value separator to be rejected")
+	}
+	if err := SetAPIHeaders(http.Header{"Authorization": {"Bearer other"}}); err == nil {
+		t.Error("Expected the Authorization header to be rejected")
+	}
+
+	headers, err := ParseAPIHeaders([]string{"X-Gateway-Key: secret", "Host: gmail.internal.example.com"})
+	if err != nil {
+		t.Fatalf("ParseAPIHeaders() error = %v", err)
+	}
+	if err := SetAPIHeaders(headers); err != nil {
+		t.Fatalf("SetAPIHeaders() error = %v", err)
+	}
+
+	var gotKey, gotHost string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, gotHost = r.Header.Get("X-Gateway-Key"), r.Host
+		_, _ = w.Write([]byte(`{"emailAddress":"user@example.com"}`))
+	}))
+	defer server.Close()
+	if err := SetAPIEndpoint(server.URL + "/gateway/"); err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := newGmailService(context.Background(), server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Users.GetProfile("me").Do(); err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if gotKey != "secret" || gotHost != "gmail.internal.example.com" {
+		t.Errorf("Gateway got key %q and host %q", gotKey, gotHost)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// OAuthClient is an additional OAuth client, with its own token for the same
+// mailbox, that API calls are spread across
+type OAuthClient struct {
+	Name            string `json:"name" mapstructure:"name"`
+	CredentialsFile string `json:"credentials_file" mapstructure:"credentials_file"`
+	TokenFile       string `json:"token_file" mapstructure:"token_file"`
+}
+
+// PooledService is a Gmail service authenticated with one client of a pool
+type PooledService struct {
+	Name    string
+	Service *gmail.Service
+}
+
+// ClientPool rotates Gmail API calls across several OAuth clients, so each
+// client's per-project quota carries a share of the load
+type ClientPool struct {
+	services []PooledService
+	next     atomic.Uint64
+}
+
+// NewClientPool creates a pool from the primary service, named "default",
+// and a service for each additional client
+func NewClientPool(primary *gmail.Service, clients []OAuthClient) (*ClientPool, error) {
+	pool := &ClientPool{services: []PooledService{{Name: "default", Service: primary}}}
+
+	seen := map[string]bool{"default": true}
+	for i, client := range clients {
+		if client.Name == "" {
+			client.Name = fmt.Sprintf("client-%d", i+1)
+		}
+		if seen[client.Name] {
+			return nil, fmt.Errorf("duplicate OAuth client name: %s", client.Name)
+		}
+		seen[client.Name] = true
+
+		if client.CredentialsFile == "" || client.TokenFile == "" {
+			return nil, fmt.Errorf("OAuth client %s must set credentials_file and token_file", client.Name)
+		}
+
+		authenticator, err := NewAuthenticator(client.CredentialsFile, client.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create authenticator for OAuth client %s: %w", client.Name, err)
+		}
+		service, err := authenticator.GetGmailService()
+		if err != nil {
+			return nil, fmt.Errorf("OAuth client %s: %w", client.Name, err)
+		}
+
+		pool.services = append(pool.services, PooledService{Name: client.Name, Service: service})
+	}
+
+	return pool, nil
+}
+
+// Next returns the service for the next call, in round-robin order. It is
+// safe to call from multiple goroutines.
+func (p *ClientPool) Next() PooledService {
+	n := p.next.Add(1) - 1
+	return p.services[n%uint64(len(p.services))]
+}
+
+// Len returns the number of clients in the pool
+func (p *ClientPool) Len() int {
+	return len(p.services)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestClientPool_Next(t *testing.T) {
+	pool := &ClientPool{services: []PooledService{
+		{Name: "default", Service: &gmail.Service{}},
+		{Name: "second", Service: &gmail.Service{}},
+		{Name: "third", Service: &gmail.Service{}},
+	}}
+
+	var names []string
+	for i := 0; i < 5; i++ {
+		names = append(names, pool.Next().Name)
+	}
+
+	expected := []string{"default", "second", "third", "default", "second"}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("Expected round-robin order %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestNewClientPool(t *testing.T) {
+	primary := &gmail.Service{}
+
+	pool, err := NewClientPool(primary, nil)
+	if err != nil {
+		t.Fatalf("NewClientPool() error = %v", err)
+	}
+	if pool.Len() != 1 || pool.Next().Service != primary {
+		t.Error("Expected a pool holding only the primary service")
+	}
+
+	tests := []struct {
+		name    string
+		clients []OAuthClient
+	}{
+		{
+			name:    "missing token file",
+			clients: []OAuthClient{{Name: "second", CredentialsFile: "credentials.json"}},
+		},
+		{
+			name:    "duplicate name",
+			clients: []OAuthClient{{Name: "default", CredentialsFile: "credentials.json", TokenFile: "token.json"}},
+		},
+		{
+			name:    "missing credentials",
+			clients: []OAuthClient{{Name: "second", CredentialsFile: "/nonexistent/credentials.json", TokenFile: "token.json"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClientPool(primary, tt.clients); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Environment variables supplying the sensitive files as values, for CI
+// runners and secret managers that inject secrets rather than mount them.
+// The _B64 variables hold the file's JSON in standard base64 and the _FD
+// variables the number of an open file descriptor to read it from (for
+// example "3" with 3<secret.json). Either takes precedence over the
+// configured path.
+const (
+	CredentialsB64EnvVar       = "GMAIL_EXPORTER_CREDENTIALS_B64"
+	CredentialsFDEnvVar        = "GMAIL_EXPORTER_CREDENTIALS_FD"
+	TokenB64EnvVar             = "GMAIL_EXPORTER_TOKEN_B64"
+	TokenFDEnvVar              = "GMAIL_EXPORTER_TOKEN_FD"
+	ServiceAccountKeyB64EnvVar = "GMAIL_EXPORTER_SERVICE_ACCOUNT_KEY_B64"
+	ServiceAccountKeyFDEnvVar  = "GMAIL_EXPORTER_SERVICE_ACCOUNT_KEY_FD"
+)
+
+// envSecret names the variables that may supply one secret
+type envSecret struct {
+	b64Var string
+	fdVar  string
+}
+
+var (
+	credentialsSecret    = envSecret{b64Var: CredentialsB64EnvVar, fdVar: CredentialsFDEnvVar}
+	tokenSecret          = envSecret{b64Var: TokenB64EnvVar, fdVar: TokenFDEnvVar}
+	serviceAccountSecret = envSecret{b64Var: ServiceAccountKeyB64EnvVar, fdVar: ServiceAccountKeyFDEnvVar}
+)
+
+// fdSecrets keeps what was read from each file descriptor, since a pipe can
+// only be read once but several accounts or exporters may need the secret
+var (
+	fdMu      sync.Mutex
+	fdSecrets = make(map[int][]byte)
+)
+
+// set reports whether either variable of the secret is set
+func (s envSecret) set() bool {
+	return os.Getenv(s.b64Var) != "" || os.Getenv(s.fdVar) != ""
+}
+
+// read returns the secret and the variable that supplied it, or nil when
+// neither variable is set
+func (s envSecret) read() ([]byte, string, error) {
+	if value := strings.TrimSpace(os.Getenv(s.b64Var)); value != "" {
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid %s: %w", s.b64Var, err)
+		}
+		return data, s.b64Var, nil
+	}
+
+	if value := strings.TrimSpace(os.Getenv(s.fdVar)); value != "" {
+		fd, err := strconv.Atoi(value)
+		if err != nil || fd < 0 {
+			return nil, "", fmt.Errorf("invalid %s: %q is not a file descriptor", s.fdVar, value)
+		}
+		data, err := readFD(fd)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to read %s: %w", s.fdVar, err)
+		}
+		return data, s.fdVar, nil
+	}
+
+	return nil, "", nil
+}
+
+// readFD reads a file descriptor to the end, once per process
+func readFD(fd int) ([]byte, error) {
+	fdMu.Lock()
+	defer fdMu.Unlock()
+
+	if data, ok := fdSecrets[fd]; ok {
+		return data, nil
+	}
+
+	file := os.NewFile(uintptr(fd), "fd"+strconv.Itoa(fd))
+	if file == nil {
+		return nil, fmt.Errorf("file descriptor %d is not open", fd)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	fdSecrets[fd] = data
+	return data, nil
+}
+
+// ServiceAccountKeyInEnv reports whether the service account key is supplied
+// through the environment
+func ServiceAccountKeyInEnv() bool {
+	return serviceAccountSecret.set()
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const testCredentials = `{"installed":{"client_id":"env-id","client_secret":"secret","auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token","redirect_uris":["http://localhost"]}}`
+
+func TestNewAuthenticator_EnvBase64(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// The credentials file exists but the environment takes precedence
+	credentialsFile := filepath.Join(tempDir, "credentials.json")
+	if err := os.WriteFile(credentialsFile, []byte(`not json`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(CredentialsB64EnvVar, base64.StdEncoding.EncodeToString([]byte(testCredentials)))
+	t.Setenv(TokenB64EnvVar, base64.StdEncoding.EncodeToString([]byte(`{"refresh_token":"r"}`)))
+	t.Setenv(TokenEnvVar, `{"refresh_token":"ignored"}`)
+
+	a, err := NewAuthenticator(credentialsFile, filepath.Join(tempDir, "token.json"))
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if a.config.ClientID != "env-id" {
+		t.Errorf("ClientID = %q, want env-id", a.config.ClientID)
+	}
+	if a.tokenOrigin != TokenB64EnvVar {
+		t.Errorf("tokenOrigin = %q, want %s", a.tokenOrigin, TokenB64EnvVar)
+	}
+	if token, err := a.loadToken(); err != nil || token.RefreshToken != "r" {
+		t.Fatalf("loadToken() = %v, %v", token, err)
+	}
+	if mode := ResolveMode(ModeAuto, filepath.Join(tempDir, "token.json")); mode != ModeOAuth {
+		t.Errorf("ResolveMode() = %s, want %s", mode, ModeOAuth)
+	}
+
+	t.Setenv(CredentialsB64EnvVar, "not base64!")
+	if _, err := NewAuthenticator(credentialsFile, "token.json"); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+}
+
+func TestEnvSecret_FileDescriptor(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.WriteString(`{"refresh_token":"r"}`); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	t.Setenv(TokenFDEnvVar, strconv.Itoa(int(reader.Fd())))
+
+	// A descriptor can be read once; later reads reuse the data
+	for i := 0; i < 2; i++ {
+		data, variable, err := EnvTokenData()
+		if err != nil {
+			t.Fatalf("EnvTokenData() error = %v", err)
+		}
+		if string(data) != `{"refresh_token":"r"}` || variable != TokenFDEnvVar {
+			t.Errorf("EnvTokenData() = %q, %s", data, variable)
+		}
+	}
+
+	t.Setenv(TokenFDEnvVar, "three")
+	if _, _, err := EnvTokenData(); err == nil {
+		t.Error("expected error for an invalid file descriptor")
+	}
+}
+
+func TestNewServiceAccount_Env(t *testing.T) {
+	if _, err := NewServiceAccount(""); err == nil {
+		t.Error("expected error without a key file or environment key")
+	}
+
+	key := `{"type":"service_account","client_email":"sa@example.com","private_key":"key"}`
+	t.Setenv(ServiceAccountKeyB64EnvVar, base64.StdEncoding.EncodeToString([]byte(key)))
+	if !ServiceAccountKeyInEnv() {
+		t.Error("expected the environment key to be detected")
+	}
+
+	// The environment key is used instead of the missing file
+	serviceAccount, err := NewServiceAccount("missing.json")
+	if err != nil {
+		t.Fatalf("NewServiceAccount() error = %v", err)
+	}
+	if string(serviceAccount.keyData) != key {
+		t.Errorf("keyData = %s, want the environment key", serviceAccount.keyData)
+	}
+}
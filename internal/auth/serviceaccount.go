@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+)
+
+// ServiceAccount authenticates as Google Workspace users through a service
+// account with domain-wide delegation
+type ServiceAccount struct {
+	keyFile string
+	keyData []byte
+}
+
+// NewServiceAccount loads a service account JSON key from the environment
+// or, when none is supplied there, from keyFile
+func NewServiceAccount(keyFile string) (*ServiceAccount, error) {
+	data, _, err := serviceAccountSecret.read()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if keyFile == "" {
+			return nil, fmt.Errorf("no service account key: set a key file or %s", ServiceAccountKeyB64EnvVar)
+		}
+		data, err = readSecretOrFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read service account key: %w", err)
+		}
+	}
+
+	// Parse once up front so a bad key fails fast
+	if _, err := google.JWTConfigFromJSON(data); err != nil {
+		return nil, fmt.Errorf("unable to parse service account key: %w", err)
+	}
+
+	return &ServiceAccount{keyFile: keyFile, keyData: data}, nil
+}
+
+// Client returns an HTTP client acting as subject with the given scopes
+func (s *ServiceAccount) Client(subject string, scopes ...string) (*http.Client, error) {
+	config, err := google.JWTConfigFromJSON(s.keyData, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account key: %w", err)
+	}
+	config.Subject = subject
+
+	return config.Client(httpclient.Context(context.Background())), nil
+}
+
+// GetGmailService returns a read-only Gmail service for the mailbox of subject
+func (s *ServiceAccount) GetGmailService(subject string) (*gmail.Service, error) {
+	return s.GmailService(subject, gmail.GmailReadonlyScope)
+}
+
+// GmailService returns a Gmail service for the mailbox of subject with the
+// given scopes, such as gmail.GmailSettingsBasicScope to change its settings
+func (s *ServiceAccount) GmailService(subject string, scopes ...string) (*gmail.Service, error) {
+	client, err := s.Client(subject, scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := newGmailService(context.Background(), client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Gmail service for %s: %w", subject, err)
+	}
+
+	return service, nil
+}
+
+// ListUsers returns the primary email addresses of the active users in a
+// Workspace domain, acting as adminEmail. An empty domain lists every domain
+// of the admin's customer account.
+func (s *ServiceAccount) ListUsers(adminEmail, domain string) ([]string, error) {
+	client, err := s.Client(adminEmail, admin.AdminDirectoryUserReadonlyScope)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := admin.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Directory service: %w", err)
+	}
+
+	call := service.Users.List().MaxResults(500).OrderBy("email")
+	if domain != "" {
+		call = call.Domain(domain)
+	} else {
+		call = call.Customer("my_customer")
+	}
+
+	var users []string
+	err = call.Pages(context.Background(), func(page *admin.Users) error {
+		for _, user := range page.Users {
+			if user.Suspended || user.Archived {
+				continue
+			}
+			users = append(users, strings.ToLower(user.PrimaryEmail))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list domain users: %w", err)
+	}
+
+	sort.Strings(users)
+	return users, nil
+}
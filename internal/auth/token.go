@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// TokenEnvVar supplies a token without an interactive login. Its value is
+// either the token JSON itself or the path of a file holding it, such as a
+// Docker or Kubernetes secret.
+const TokenEnvVar = "GMAIL_EXPORTER_TOKEN_JSON"
+
+// gmailScope is the OAuth scope requested for user tokens
+const gmailScope = "https://mail.google.com/"
+
+// tokenJSON accepts both the token files written by this tool and the
+// authorized_user files written by gcloud, which also carry the OAuth client
+type tokenJSON struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+}
+
+// ParseToken parses token JSON. The OAuth client ID and secret are returned
+// when the JSON includes them.
+func ParseToken(data []byte) (*oauth2.Token, *oauth2.Config, error) {
+	var raw tokenJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse token JSON: %w", err)
+	}
+	if raw.RefreshToken == "" {
+		return nil, nil, errors.New("token JSON has no refresh_token")
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  raw.AccessToken,
+		TokenType:    raw.TokenType,
+		RefreshToken: raw.RefreshToken,
+		Expiry:       raw.Expiry,
+	}
+
+	var client *oauth2.Config
+	if raw.ClientID != "" && raw.ClientSecret != "" {
+		client = &oauth2.Config{
+			ClientID:     raw.ClientID,
+			ClientSecret: raw.ClientSecret,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{gmailScope},
+		}
+	}
+
+	return token, client, nil
+}
+
+// EnvTokenData returns the token supplied through TokenB64EnvVar,
+// TokenFDEnvVar or TokenEnvVar, in that order, with the variable it came
+// from. The data is nil when none is set.
+func EnvTokenData() ([]byte, string, error) {
+	data, variable, err := tokenSecret.read()
+	if err != nil || data != nil {
+		return data, variable, err
+	}
+
+	value := strings.TrimSpace(os.Getenv(TokenEnvVar))
+	if value == "" {
+		return nil, "", nil
+	}
+	if strings.HasPrefix(value, "{") {
+		return []byte(value), TokenEnvVar, nil
+	}
+
+	data, err = os.ReadFile(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read %s file: %w", TokenEnvVar, err)
+	}
+	return data, TokenEnvVar, nil
+}
+
+// tokenInEnv reports whether a token is supplied through the environment
+func tokenInEnv() bool {
+	return os.Getenv(TokenEnvVar) != "" || tokenSecret.set()
+}
+
+// ImportToken validates token JSON and writes it to tokenFile. When the JSON
+// carries the OAuth client and credentialsFile does not exist yet, a matching
+// credentials file is written too, so no other file has to be provided.
+func ImportToken(data []byte, credentialsFile, tokenFile string) error {
+	if IsSecretURI(tokenFile) {
+		return fmt.Errorf("token file %s is a secret URI; store the token in the secret backend instead", tokenFile)
+	}
+
+	token, client, err := ParseToken(data)
+	if err != nil {
+		return err
+	}
+
+	if client != nil && !IsSecretURI(credentialsFile) {
+		if _, err := os.Stat(credentialsFile); os.IsNotExist(err) {
+			if err := writeCredentials(credentialsFile, client); err != nil {
+				return fmt.Errorf("unable to save credentials: %w", err)
+			}
+		}
+	}
+
+	a := &Authenticator{tokenFile: tokenFile}
+	if err := a.saveToken(token); err != nil {
+		return fmt.Errorf("unable to save token: %w", err)
+	}
+
+	return nil
+}
+
+// writeCredentials writes an installed-app credentials file for client
+func writeCredentials(path string, client *oauth2.Config) error {
+	credentials := map[string]any{
+		"installed": map[string]any{
+			"client_id":     client.ClientID,
+			"client_secret": client.ClientSecret,
+			"auth_uri":      google.Endpoint.AuthURL,
+			"token_uri":     google.Endpoint.TokenURL,
+			"redirect_uris": []string{"http://localhost"},
+		},
+	}
+
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantErr    bool
+		wantClient bool
+	}{
+		{"token file", `{"access_token":"a","refresh_token":"r","expiry":"2024-01-01T00:00:00Z"}`, false, false},
+		{"refresh token only", `{"refresh_token":"r"}`, false, false},
+		{"authorized user", `{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"r"}`, false, true},
+		{"no refresh token", `{"access_token":"a"}`, true, false},
+		{"invalid JSON", `not json`, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, client, err := ParseToken([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if token.RefreshToken != "r" {
+				t.Errorf("RefreshToken = %q, want r", token.RefreshToken)
+			}
+			if (client != nil) != tt.wantClient {
+				t.Errorf("client = %v, wantClient %v", client, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator_EnvToken(t *testing.T) {
+	tempDir := t.TempDir()
+	tokenFile := filepath.Join(tempDir, "token.json")
+
+	// The token JSON carries the client, so no credentials file is needed
+	t.Setenv(TokenEnvVar, `{"client_id":"id","client_secret":"secret","refresh_token":"r"}`)
+
+	a, err := NewAuthenticator(filepath.Join(tempDir, "missing.json"), tokenFile)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if a.config.ClientID != "id" {
+		t.Errorf("ClientID = %q, want id", a.config.ClientID)
+	}
+
+	token, err := a.loadToken()
+	if err != nil || token.RefreshToken != "r" {
+		t.Fatalf("loadToken() = %v, %v", token, err)
+	}
+
+	// Refreshed tokens stay in memory rather than touching the token file
+	token.AccessToken = "new"
+	if err := a.saveToken(token); err != nil {
+		t.Fatalf("saveToken() error = %v", err)
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Errorf("token file written for an environment token: %v", err)
+	}
+	if token, _ := a.loadToken(); token.AccessToken != "new" {
+		t.Errorf("AccessToken = %q, want new", token.AccessToken)
+	}
+}
+
+func TestNewAuthenticator_EnvTokenSecretFile(t *testing.T) {
+	tempDir := t.TempDir()
+	secret := filepath.Join(tempDir, "secret")
+	if err := os.WriteFile(secret, []byte(`{"refresh_token":"r"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(TokenEnvVar, secret)
+
+	// Without a client in the token a credentials file is still required
+	if _, err := NewAuthenticator(filepath.Join(tempDir, "missing.json"), "token.json"); err == nil {
+		t.Error("expected error without credentials file")
+	}
+
+	t.Setenv(TokenEnvVar, filepath.Join(tempDir, "absent"))
+	if _, _, err := EnvTokenData(); err == nil {
+		t.Error("expected error for missing secret file")
+	}
+}
+
+func TestImportToken(t *testing.T) {
+	tempDir := t.TempDir()
+	credentialsFile := filepath.Join(tempDir, "config", "credentials.json")
+	tokenFile := filepath.Join(tempDir, "config", "token.json")
+
+	data := []byte(`{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"r"}`)
+	if err := ImportToken(data, credentialsFile, tokenFile); err != nil {
+		t.Fatalf("ImportToken() error = %v", err)
+	}
+
+	a, err := NewAuthenticator(credentialsFile, tokenFile)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if a.config.ClientID != "id" || a.config.ClientSecret != "secret" {
+		t.Errorf("config = %+v", a.config)
+	}
+
+	token, err := a.loadToken()
+	if err != nil || token.RefreshToken != "r" {
+		t.Fatalf("loadToken() = %v, %v", token, err)
+	}
+
+	if err := ImportToken([]byte(`{"access_token":"a"}`), credentialsFile, tokenFile); err == nil {
+		t.Error("expected error for token without refresh_token")
+	}
+}
@@ -0,0 +1,187 @@
+// Package backup stores a finished export in a restic or borg repository, so
+// mail backups get the encryption, deduplication and retention of the
+// standard backup tooling
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Supported backup tools
+const (
+	ToolRestic = "restic"
+	ToolBorg   = "borg"
+)
+
+// DefaultTag tags restic snapshots and prefixes borg archive names
+const DefaultTag = "gmail-exporter"
+
+// borgArchiveLayout is the time layout of borg archive names
+const borgArchiveLayout = "2006-01-02T15:04:05"
+
+// Config selects the repository an export is backed up to. The tool reads
+// the repository password from its usual environment variables, such as
+// RESTIC_PASSWORD_FILE or BORG_PASSCOMMAND, and, when Repository is empty,
+// the repository from RESTIC_REPOSITORY or BORG_REPO.
+type Config struct {
+	Tool       string   `json:"tool" mapstructure:"tool"`
+	Repository string   `json:"repository,omitempty" mapstructure:"repository"`
+	Tags       []string `json:"tags,omitempty" mapstructure:"tags"`
+}
+
+// Result describes the snapshot or archive a backup created
+type Result struct {
+	Tool       string        `json:"tool"`
+	Repository string        `json:"repository,omitempty"`
+	Snapshot   string        `json:"snapshot"`
+	Files      int           `json:"files"`
+	BytesAdded int64         `json:"bytes_added"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Validate checks the backup configuration and that the tool is installed
+func (c Config) Validate() error {
+	if c.Tool != ToolRestic && c.Tool != ToolBorg {
+		return fmt.Errorf("invalid backup tool: %s (valid: %s, %s)", c.Tool, ToolRestic, ToolBorg)
+	}
+	if _, err := exec.LookPath(c.Tool); err != nil {
+		return fmt.Errorf("%s is not installed or not on PATH: %w", c.Tool, err)
+	}
+	return nil
+}
+
+// Run backs up the contents of dir, with paths relative to it, as a new
+// snapshot or archive made at now. The tool's progress and errors are passed
+// through to stderr.
+func Run(ctx context.Context, config Config, dir string, now time.Time) (*Result, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	var args []string
+	switch config.Tool {
+	case ToolRestic:
+		args = resticArgs(config)
+	case ToolBorg:
+		args = borgArgs(config, now)
+	}
+
+	var stdout bytes.Buffer
+	command := exec.CommandContext(ctx, config.Tool, args...)
+	command.Dir = dir
+	command.Stdout = &stdout
+	command.Stderr = os.Stderr
+
+	logrus.WithFields(logrus.Fields{
+		"tool":       config.Tool,
+		"repository": config.Repository,
+		"dir":        dir,
+	}).Info("Backing up export")
+	start := time.Now()
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w", config.Tool, err)
+	}
+
+	result := &Result{Tool: config.Tool, Repository: config.Repository, Duration: time.Since(start)}
+	var err error
+	switch config.Tool {
+	case ToolRestic:
+		err = parseRestic(stdout.Bytes(), result)
+	case ToolBorg:
+		err = parseBorg(stdout.Bytes(), result)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s output: %w", config.Tool, err)
+	}
+	return result, nil
+}
+
+// tags returns the configured tags, or the default tag
+func (c Config) tags() []string {
+	if len(c.Tags) == 0 {
+		return []string{DefaultTag}
+	}
+	return c.Tags
+}
+
+// resticArgs returns the arguments of restic backup
+func resticArgs(config Config) []string {
+	var args []string
+	if config.Repository != "" {
+		args = append(args, "--repo", config.Repository)
+	}
+	args = append(args, "backup", "--json")
+	for _, tag := range config.tags() {
+		args = append(args, "--tag", tag)
+	}
+	return append(args, ".")
+}
+
+// borgArgs returns the arguments of borg create. Borg has no tags, so the
+// first tag prefixes the archive name.
+func borgArgs(config Config, now time.Time) []string {
+	archive := config.tags()[0] + "-" + now.UTC().Format(borgArchiveLayout)
+	return []string{"create", "--json", config.Repository + "::" + archive, "."}
+}
+
+// parseRestic reads the summary message of restic backup --json
+func parseRestic(output []byte, result *Result) error {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var message struct {
+			MessageType         string `json:"message_type"`
+			SnapshotID          string `json:"snapshot_id"`
+			TotalFilesProcessed int    `json:"total_files_processed"`
+			DataAdded           int64  `json:"data_added"`
+		}
+		if err := json.Unmarshal([]byte(line), &message); err != nil || message.MessageType != "summary" {
+			continue
+		}
+		result.Snapshot = message.SnapshotID
+		result.Files = message.TotalFilesProcessed
+		result.BytesAdded = message.DataAdded
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("no backup summary")
+}
+
+// parseBorg reads the JSON document printed by borg create --json
+func parseBorg(output []byte, result *Result) error {
+	var document struct {
+		Archive struct {
+			Name  string `json:"name"`
+			Stats struct {
+				Files            int   `json:"nfiles"`
+				DeduplicatedSize int64 `json:"deduplicated_size"`
+			} `json:"stats"`
+		} `json:"archive"`
+	}
+	if err := json.Unmarshal(output, &document); err != nil {
+		return err
+	}
+	if document.Archive.Name == "" {
+		return fmt.Errorf("no archive in output")
+	}
+	result.Snapshot = document.Archive.Name
+	result.Files = document.Archive.Stats.Files
+	result.BytesAdded = document.Archive.Stats.DeduplicatedSize
+	return nil
+}
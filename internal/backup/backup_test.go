@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTool installs a script named tool on PATH that records its arguments
+// and working directory in args.txt and prints output
+func fakeTool(t *testing.T, tool, output string, exitCode int) (argsFile string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("backup tests use sh scripts")
+	}
+	bin := t.TempDir()
+	argsFile = filepath.Join(bin, "args.txt")
+	script := "#!/bin/sh\n" +
+		"pwd > " + argsFile + "\n" +
+		"echo \"$@\" >> " + argsFile + "\n" +
+		"cat <<'EOF'\n" + output + "\nEOF\n" +
+		"exit " + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func readArgs(t *testing.T, argsFile string) (dir, args string) {
+	t.Helper()
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	return lines[0], lines[1]
+}
+
+func TestRun_Restic(t *testing.T) {
+	output := `{"message_type":"status","percent_done":0.5}
+{"message_type":"summary","files_new":3,"total_files_processed":10,"data_added":2048,"snapshot_id":"4f2a9c1e"}`
+	argsFile := fakeTool(t, ToolRestic, output, 0)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{Tool: ToolRestic, Repository: "sftp:backup@nas:/restic"}
+	result, err := Run(context.Background(), config, dir, time.Now())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Snapshot != "4f2a9c1e" || result.Files != 10 || result.BytesAdded != 2048 {
+		t.Errorf("Run() = %+v", result)
+	}
+
+	workDir, args := readArgs(t, argsFile)
+	if workDir != dir {
+		t.Errorf("Expected restic to run in the export directory, got %s", workDir)
+	}
+	if want := "--repo sftp:backup@nas:/restic backup --json --tag gmail-exporter ."; args != want {
+		t.Errorf("restic args = %q, want %q", args, want)
+	}
+}
+
+func TestRun_Borg(t *testing.T) {
+	output := `{"archive": {"name": "mail-2024-05-01T02:00:00", "stats": {"nfiles": 42, "deduplicated_size": 4096}}}`
+	argsFile := fakeTool(t, ToolBorg, output, 0)
+
+	config := Config{Tool: ToolBorg, Repository: "/srv/borg", Tags: []string{"mail"}}
+	result, err := Run(context.Background(), config, t.TempDir(), time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Snapshot != "mail-2024-05-01T02:00:00" || result.Files != 42 || result.BytesAdded != 4096 {
+		t.Errorf("Run() = %+v", result)
+	}
+
+	if _, args := readArgs(t, argsFile); args != "create --json /srv/borg::mail-2024-05-01T02:00:00 ." {
+		t.Errorf("borg args = %q", args)
+	}
+}
+
+func TestRun_Failures(t *testing.T) {
+	fakeTool(t, ToolRestic, "Fatal: wrong password or no key found", 1)
+	if _, err := Run(context.Background(), Config{Tool: ToolRestic}, t.TempDir(), time.Now()); err == nil {
+		t.Error("Expected an error when restic fails")
+	}
+
+	fakeTool(t, ToolRestic, `{"message_type":"status"}`, 0)
+	if _, err := Run(context.Background(), Config{Tool: ToolRestic}, t.TempDir(), time.Now()); err == nil {
+		t.Error("Expected an error without a backup summary")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	// Only the fake restic is on PATH
+	argsFile := fakeTool(t, ToolRestic, "", 0)
+	t.Setenv("PATH", filepath.Dir(argsFile))
+
+	if err := (Config{Tool: "tar"}).Validate(); err == nil {
+		t.Error("Expected an error for an unsupported tool")
+	}
+	if err := (Config{Tool: ToolBorg}).Validate(); err == nil {
+		t.Error("Expected an error for a tool that is not installed")
+	}
+	if err := (Config{Tool: ToolRestic}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultFileName is the metadata cache file name inside an export directory
+const DefaultFileName = "metadata.db"
+
+var messagesBucket = []byte("messages")
+
+// Metadata holds the cached header fields of a single message
+type Metadata struct {
+	ID       string    `json:"id"`
+	ThreadID string    `json:"thread_id,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Cc       string    `json:"cc,omitempty"`
+	Date     time.Time `json:"date,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Labels   []string  `json:"labels,omitempty"`
+	Cached   time.Time `json:"cached"`
+
+	// MessageID is the RFC 822 Message-ID header
+	MessageID string `json:"message_id,omitempty"`
+
+	// Headers holds other headers requested by the metadata export format,
+	// by the header name as requested
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Store is a metadata cache keyed by Gmail message ID, backed by a bbolt database
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the metadata cache at path
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata cache: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(messagesBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize metadata cache: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// OpenIfExists opens the metadata cache at path if the file exists. A missing
+// file yields a nil store and no error.
+func OpenIfExists(path string) (*Store, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return Open(path)
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put stores the given entries in a single transaction, replacing existing ones
+func (s *Store) Put(entries ...Metadata) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(messagesBucket)
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("failed to marshal metadata for %s: %w", entry.ID, err)
+			}
+			if err := bucket.Put([]byte(entry.ID), data); err != nil {
+				return fmt.Errorf("failed to store metadata for %s: %w", entry.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the cached metadata for a message ID, or nil if it is not cached
+func (s *Store) Get(id string) (*Metadata, error) {
+	var entry *Metadata
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(messagesBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		entry = &Metadata{}
+		return json.Unmarshal(data, entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata for %s: %w", id, err)
+	}
+
+	return entry, nil
+}
+
+// Count returns the number of cached messages
+func (s *Store) Count() (int, error) {
+	var count int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(messagesBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+// ForEach calls fn with every cached entry, stopping at the first error
+func (s *Store) ForEach(fn func(entry Metadata) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(messagesBucket).ForEach(func(key, data []byte) error {
+			var entry Metadata
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("failed to read metadata for %s: %w", key, err)
+			}
+			return fn(entry)
+		})
+	})
+}
+
+// FromMessage extracts cacheable metadata from a Gmail message fetched in
+// full or metadata format
+func FromMessage(message *gmail.Message) Metadata {
+	entry := Metadata{
+		ID:       message.Id,
+		ThreadID: message.ThreadId,
+		Size:     message.SizeEstimate,
+		Labels:   message.LabelIds,
+		Cached:   time.Now(),
+	}
+
+	if message.InternalDate > 0 {
+		entry.Date = time.UnixMilli(message.InternalDate).UTC()
+	}
+
+	if message.Payload == nil {
+		return entry
+	}
+
+	for _, header := range message.Payload.Headers {
+		switch strings.ToLower(header.Name) {
+		case "subject":
+			entry.Subject = header.Value
+		case "from":
+			entry.From = header.Value
+		case "to":
+			entry.To = header.Value
+		case "cc":
+			entry.Cc = header.Value
+		case "message-id":
+			entry.MessageID = header.Value
+		case "date":
+			if entry.Date.IsZero() {
+				if date, err := mail.ParseDate(header.Value); err == nil {
+					entry.Date = date
+				}
+			}
+		}
+	}
+
+	return entry
+}
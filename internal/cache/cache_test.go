@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestStore_PutGet(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), DefaultFileName))
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	defer store.Close()
+
+	entries := []Metadata{
+		{ID: "18c1a2b3c4d5e6f7", Subject: "Hello", From: "alice@example.com", Labels: []string{"INBOX"}},
+		{ID: "18c1a2b3c4d5e6f8", Subject: "World", Size: 2048},
+	}
+	if err := store.Put(entries...); err != nil {
+		t.Fatalf("Failed to put entries: %v", err)
+	}
+
+	got, err := store.Get("18c1a2b3c4d5e6f7")
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if got == nil || got.Subject != "Hello" || got.From != "alice@example.com" || len(got.Labels) != 1 {
+		t.Errorf("Unexpected entry: %+v", got)
+	}
+
+	missing, err := store.Get("missing")
+	if err != nil || missing != nil {
+		t.Errorf("Expected nil for missing entry, got %+v, %v", missing, err)
+	}
+
+	count, err := store.Count()
+	if err != nil || count != 2 {
+		t.Errorf("Expected 2 entries, got %d (%v)", count, err)
+	}
+
+	// Replacing an entry keeps the count stable
+	if err := store.Put(Metadata{ID: "18c1a2b3c4d5e6f8", Subject: "Updated"}); err != nil {
+		t.Fatalf("Failed to replace entry: %v", err)
+	}
+	if got, _ := store.Get("18c1a2b3c4d5e6f8"); got.Subject != "Updated" {
+		t.Errorf("Expected replaced subject, got %q", got.Subject)
+	}
+	if count, _ := store.Count(); count != 2 {
+		t.Errorf("Expected 2 entries after replace, got %d", count)
+	}
+}
+
+func TestOpenIfExists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+
+	store, err := OpenIfExists(path)
+	if err != nil || store != nil {
+		t.Fatalf("Expected nil store for missing file, got %v, %v", store, err)
+	}
+
+	created, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	created.Close()
+
+	store, err = OpenIfExists(path)
+	if err != nil || store == nil {
+		t.Fatalf("Expected store for existing file, got %v, %v", store, err)
+	}
+	store.Close()
+}
+
+func TestFromMessage(t *testing.T) {
+	message := &gmail.Message{
+		Id:           "18c1a2b3c4d5e6f7",
+		ThreadId:     "18c1a2b3c4d5e6f0",
+		SizeEstimate: 4096,
+		LabelIds:     []string{"INBOX", "IMPORTANT"},
+		Payload: &gmail.MessagePart{
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Subject", Value: "Quarterly report"},
+				{Name: "From", Value: "Bob <bob@example.com>"},
+				{Name: "to", Value: "alice@example.com"},
+				{Name: "Date", Value: "Mon, 02 Jan 2023 15:04:05 +0000"},
+			},
+		},
+	}
+
+	entry := FromMessage(message)
+
+	if entry.ID != message.Id || entry.ThreadID != message.ThreadId {
+		t.Errorf("Unexpected IDs: %+v", entry)
+	}
+	if entry.Subject != "Quarterly report" || entry.From != "Bob <bob@example.com>" || entry.To != "alice@example.com" {
+		t.Errorf("Unexpected headers: %+v", entry)
+	}
+	if !entry.Date.Equal(time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected date: %v", entry.Date)
+	}
+	if entry.Size != 4096 || len(entry.Labels) != 2 {
+		t.Errorf("Unexpected size or labels: %+v", entry)
+	}
+
+	// InternalDate takes precedence over the Date header
+	message.InternalDate = time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC).UnixMilli()
+	if entry := FromMessage(message); entry.Date.Day() != 3 {
+		t.Errorf("Expected internal date to be used, got %v", entry.Date)
+	}
+}
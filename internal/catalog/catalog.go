@@ -0,0 +1,390 @@
+// Package catalog builds and queries a catalog of the messages in an export
+// directory, so an export can be browsed by sender, date, label and size
+// without opening its files. The catalog is a SQLite database that other
+// tools, such as sqlite3, can query too.
+package catalog
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+	"github.com/octasoft-ltd/gmail-exporter/internal/sqlitedb"
+)
+
+// FileName is the catalog file name inside an export directory
+const FileName = "catalog.db"
+
+// schema is the catalog's tables: a row per message, its labels a JSON array
+// and its date both as in the message (RFC 3339) and as Unix seconds, which
+// are indexed for date ranges, and the build time in info
+const schema = `CREATE TABLE messages (
+	id         TEXT PRIMARY KEY,
+	file       TEXT NOT NULL,
+	sender     TEXT NOT NULL,
+	recipients TEXT NOT NULL,
+	subject    TEXT NOT NULL,
+	date       TEXT NOT NULL,
+	date_unix  INTEGER NOT NULL,
+	size       INTEGER NOT NULL,
+	labels     TEXT NOT NULL
+);
+CREATE INDEX messages_date ON messages (date_unix, id);
+CREATE TABLE info (key TEXT PRIMARY KEY, value TEXT NOT NULL);`
+
+// Entry is a cataloged message
+type Entry struct {
+	ID      string    `json:"id"`
+	File    string    `json:"file,omitempty"` // relative to the export directory
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Subject string    `json:"subject,omitempty"`
+	Date    time.Time `json:"date"`
+	Size    int64     `json:"size"`
+	Labels  []string  `json:"labels,omitempty"`
+}
+
+// Catalog is the catalog of an export directory, backed by a SQLite
+// database
+type Catalog struct {
+	db *sql.DB
+}
+
+// Path returns the catalog path of an export directory
+func Path(exportDir string) string {
+	return filepath.Join(exportDir, FileName)
+}
+
+// Open opens the catalog at path
+func Open(path string) (*Catalog, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	db, err := sqlitedb.Open(path, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close closes the underlying database
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// Built returns when the catalog was built
+func (c *Catalog) Built() time.Time {
+	var value string
+	var built time.Time
+	if err := c.db.QueryRow(`SELECT value FROM info WHERE key = 'built'`).Scan(&value); err == nil {
+		_ = built.UnmarshalText([]byte(value))
+	}
+	return built
+}
+
+// Build catalogs the messages of an export directory into a new catalog at
+// path, replacing any existing one, and returns the number cataloged.
+// Messages are taken from the exporter's processed_emails.json and metadata
+// cache, and from the headers of eml files neither of them records.
+func Build(exportDir, path string) (int, error) {
+	entries, err := collect(exportDir)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+atomicfile.TempSuffix)
+	_ = os.Remove(tmp)
+	db, err := sqlitedb.Open(tmp, schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create catalog: %w", err)
+	}
+	err = insertEntries(db, entries)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write catalog: %w", err)
+	}
+	return len(entries), nil
+}
+
+// insertEntries writes the entries and the build time in one transaction
+func insertEntries(db *sql.DB, entries []Entry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO messages (id, file, sender, recipients, subject, date, date_unix, size, labels)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, entry := range entries {
+		labels, err := json.Marshal(entry.Labels)
+		if err != nil {
+			return fmt.Errorf("failed to marshal labels of %s: %w", entry.ID, err)
+		}
+		_, err = insert.Exec(entry.ID, entry.File, entry.From, entry.To, entry.Subject,
+			entry.Date.Format(time.RFC3339Nano), entry.Date.Unix(), entry.Size, string(labels))
+		if err != nil {
+			return fmt.Errorf("failed to catalog %s: %w", entry.ID, err)
+		}
+	}
+
+	built, _ := time.Now().UTC().MarshalText()
+	if _, err := tx.Exec(`INSERT INTO info (key, value) VALUES ('built', ?)`, string(built)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// processedRecord is the subset of a processed_emails.json entry that is
+// cataloged
+type processedRecord struct {
+	ID      string    `json:"id"`
+	Subject string    `json:"subject,omitempty"`
+	From    string    `json:"from,omitempty"`
+	Date    time.Time `json:"date,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	File    string    `json:"file,omitempty"`
+	Labels  []string  `json:"labels,omitempty"`
+}
+
+// collect gathers the catalog entries of an export directory
+func collect(exportDir string) ([]Entry, error) {
+	var processed []processedRecord
+	err := atomicfile.ReadFile(filepath.Join(exportDir, exporter.ProcessedEmailsFile), func(data []byte) error {
+		processed = nil
+		return json.Unmarshal(data, &processed)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", exporter.ProcessedEmailsFile, err)
+	}
+
+	byID := make(map[string]*Entry, len(processed))
+	var ids []string
+	add := func(entry Entry) {
+		if _, ok := byID[entry.ID]; !ok {
+			ids = append(ids, entry.ID)
+			byID[entry.ID] = &entry
+		}
+	}
+	for _, record := range processed {
+		add(Entry{
+			ID:      record.ID,
+			File:    record.File,
+			From:    record.From,
+			Subject: record.Subject,
+			Date:    record.Date,
+			Size:    record.Size,
+			Labels:  record.Labels,
+		})
+	}
+
+	// Export files the record does not list, such as those of older exports
+	err = filepath.WalkDir(exportDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != exportDir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || strings.ToLower(filepath.Ext(path)) != ".eml" {
+			return nil
+		}
+		id := strings.TrimSuffix(d.Name(), filepath.Ext(path))
+		if _, ok := byID[id]; ok {
+			return nil
+		}
+
+		entry, err := readHeaders(path)
+		if err != nil {
+			return nil
+		}
+		entry.ID = id
+		if rel, err := filepath.Rel(exportDir, path); err == nil {
+			entry.File = filepath.ToSlash(rel)
+		}
+		add(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan export directory: %w", err)
+	}
+
+	// The metadata cache holds the recipients and labels of every message
+	store, err := cache.OpenIfExists(filepath.Join(exportDir, cache.DefaultFileName))
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		defer store.Close()
+		for _, id := range ids {
+			metadata, err := store.Get(id)
+			if err != nil || metadata == nil {
+				continue
+			}
+			entry := byID[id]
+			if metadata.To != "" {
+				entry.To = metadata.To
+			}
+			if len(metadata.Labels) > 0 {
+				entry.Labels = metadata.Labels
+			}
+			if entry.From == "" {
+				entry.From = metadata.From
+			}
+			if entry.Subject == "" {
+				entry.Subject = metadata.Subject
+			}
+			if entry.Date.IsZero() {
+				entry.Date = metadata.Date
+			}
+			if entry.Size == 0 {
+				entry.Size = metadata.Size
+			}
+		}
+	}
+
+	entries := make([]Entry, 0, len(ids))
+	for _, id := range ids {
+		entry := byID[id]
+		entry.From = mimepart.DecodeHeader(entry.From)
+		entry.To = mimepart.DecodeHeader(entry.To)
+		entry.Subject = mimepart.DecodeHeader(entry.Subject)
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// readHeaders reads the cataloged headers of an eml file without reading
+// its body
+func readHeaders(path string) (Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer file.Close()
+
+	message, err := mail.ReadMessage(bufio.NewReader(file))
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{
+		From:    message.Header.Get("From"),
+		To:      message.Header.Get("To"),
+		Subject: message.Header.Get("Subject"),
+	}
+	if date, err := message.Header.Date(); err == nil {
+		entry.Date = date
+	}
+	if info, err := file.Stat(); err == nil {
+		entry.Size = info.Size()
+		if entry.Date.IsZero() {
+			entry.Date = info.ModTime()
+		}
+	}
+	return entry, nil
+}
+
+// Query selects catalog entries. Text fields match case-insensitively
+// anywhere in the header; zero fields match everything.
+type Query struct {
+	From    string
+	To      string
+	Subject string
+	Label   string
+	After   time.Time // inclusive
+	Before  time.Time // exclusive
+	MinSize int64
+	MaxSize int64
+}
+
+// Matches reports whether entry matches the query
+func (q Query) Matches(entry Entry) bool {
+	switch {
+	case !containsFold(entry.From, q.From),
+		!containsFold(entry.To, q.To),
+		!containsFold(entry.Subject, q.Subject),
+		q.Label != "" && !slices.ContainsFunc(entry.Labels, func(label string) bool { return strings.EqualFold(label, q.Label) }),
+		!q.After.IsZero() && entry.Date.Before(q.After),
+		!q.Before.IsZero() && !entry.Date.Before(q.Before),
+		q.MinSize > 0 && entry.Size < q.MinSize,
+		q.MaxSize > 0 && entry.Size > q.MaxSize:
+		return false
+	}
+	return true
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return substr == "" || strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// Find returns the entries matching the query, oldest first
+func (c *Catalog) Find(q Query) ([]Entry, error) {
+	query := `SELECT id, file, sender, recipients, subject, date, size, labels FROM messages`
+	var where []string
+	var args []any
+	if !q.After.IsZero() {
+		where = append(where, "date_unix >= ?")
+		args = append(args, q.After.Unix())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, "date_unix <= ?")
+		args = append(args, q.Before.Unix())
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY date_unix, id"
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var date, labels string
+		if err := rows.Scan(&entry.ID, &entry.File, &entry.From, &entry.To, &entry.Subject, &date, &entry.Size, &labels); err != nil {
+			return nil, fmt.Errorf("failed to query catalog: %w", err)
+		}
+		if entry.Date, err = time.Parse(time.RFC3339Nano, date); err != nil {
+			return nil, fmt.Errorf("failed to read catalog entry %s: %w", entry.ID, err)
+		}
+		if err := json.Unmarshal([]byte(labels), &entry.Labels); err != nil {
+			return nil, fmt.Errorf("failed to read catalog entry %s: %w", entry.ID, err)
+		}
+		if q.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	return entries, nil
+}
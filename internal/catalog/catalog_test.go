@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+// buildCatalog catalogs an export with two recorded messages, one of them
+// also in the metadata cache, and an eml file the record does not list
+func buildCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	dir := t.TempDir()
+
+	processed := `[
+		{"id": "m1", "file": "m1.eml", "from": "Alice <alice@example.com>", "subject": "Invoice", "date": "2023-01-05T10:00:00Z", "size": 2048},
+		{"id": "m2", "file": "m2.eml", "from": "bob@example.org", "subject": "=?UTF-8?Q?Caf=C3=A9?=", "date": "2023-03-01T09:00:00Z", "size": 10485760}
+	]`
+	if err := os.WriteFile(filepath.Join(dir, exporter.ProcessedEmailsFile), []byte(processed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unrecorded := "From: Alice <ALICE@example.com>\r\nTo: carol@example.com\r\nSubject: Old\r\nDate: Sun, 01 Jan 2022 08:00:00 +0000\r\n\r\nbody\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "m3.eml"), []byte(unrecorded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := cache.Open(filepath.Join(dir, cache.DefaultFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(cache.Metadata{ID: "m1", To: "dave@example.com", Labels: []string{"INBOX", "Label_1"}}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	path := Path(dir)
+	count, err := Build(dir, path)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Build() = %d, want 3", count)
+	}
+
+	cat, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { cat.Close() })
+	return cat
+}
+
+func TestFind(t *testing.T) {
+	cat := buildCatalog(t)
+	if cat.Built().IsZero() {
+		t.Error("Expected the build time to be recorded")
+	}
+
+	date := func(s string) time.Time {
+		parsed, _ := time.Parse("2006-01-02", s)
+		return parsed
+	}
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all, oldest first", Query{}, []string{"m3", "m1", "m2"}},
+		{"sender", Query{From: "alice@example"}, []string{"m3", "m1"}},
+		{"recipient from the metadata cache", Query{To: "dave"}, []string{"m1"}},
+		{"recipient from the headers", Query{To: "carol"}, []string{"m3"}},
+		{"decoded subject", Query{Subject: "café"}, []string{"m2"}},
+		{"label", Query{Label: "label_1"}, []string{"m1"}},
+		{"date range", Query{After: date("2023-01-01"), Before: date("2023-03-01")}, []string{"m1"}},
+		{"minimum size", Query{MinSize: 1024 * 1024}, []string{"m2"}},
+		{"maximum size", Query{MaxSize: 4096}, []string{"m3", "m1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := cat.Find(tt.query)
+			if err != nil {
+				t.Fatalf("Find() error = %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Find() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Find() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	cat := buildCatalog(t)
+	entries, err := cat.Find(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senders, err := GroupBy(entries, GroupSender)
+	if err != nil {
+		t.Fatalf("GroupBy() error = %v", err)
+	}
+	if len(senders) != 2 || senders[0].Key != "alice@example.com" || senders[0].Messages != 2 {
+		t.Errorf("Expected alice's two messages grouped first, got %+v", senders)
+	}
+
+	months, err := GroupBy(entries, GroupMonth)
+	if err != nil {
+		t.Fatalf("GroupBy() error = %v", err)
+	}
+	if len(months) != 3 || months[0].Key != "2023-03" {
+		t.Errorf("Expected months newest first, got %+v", months)
+	}
+
+	if _, err := GroupBy(entries, "domain"); err == nil {
+		t.Error("Expected an error for an invalid grouping")
+	}
+}
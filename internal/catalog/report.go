@@ -0,0 +1,131 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+	"github.com/octasoft-ltd/gmail-exporter/internal/preview"
+)
+
+// Groupings of entries
+const (
+	GroupSender = "sender"
+	GroupLabel  = "label"
+	GroupMonth  = "month"
+)
+
+// Groupings lists the valid groupings
+var Groupings = []string{GroupSender, GroupLabel, GroupMonth}
+
+// Group counts the entries sharing a sender, label or month
+type Group struct {
+	Key      string `json:"key"`
+	Messages int    `json:"messages"`
+	Size     int64  `json:"size"`
+}
+
+// GroupBy groups entries by sender address, label or month, largest groups
+// first. Entries with several labels count toward each.
+func GroupBy(entries []Entry, by string) ([]Group, error) {
+	keys := func(entry Entry) []string {
+		return []string{entry.Date.Format("2006-01")}
+	}
+	switch by {
+	case GroupSender:
+		keys = func(entry Entry) []string { return []string{senderAddress(entry.From)} }
+	case GroupLabel:
+		keys = func(entry Entry) []string {
+			if len(entry.Labels) == 0 {
+				return []string{"(none)"}
+			}
+			return entry.Labels
+		}
+	case GroupMonth:
+	default:
+		return nil, fmt.Errorf("invalid grouping: %s (valid: %s)", by, strings.Join(Groupings, ", "))
+	}
+
+	byKey := make(map[string]*Group)
+	for _, entry := range entries {
+		for _, key := range keys(entry) {
+			group, ok := byKey[key]
+			if !ok {
+				group = &Group{Key: key}
+				byKey[key] = group
+			}
+			group.Messages++
+			group.Size += entry.Size
+		}
+	}
+
+	groups := make([]Group, 0, len(byKey))
+	for _, group := range byKey {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if by == GroupMonth {
+			return groups[i].Key > groups[j].Key
+		}
+		if groups[i].Messages != groups[j].Messages {
+			return groups[i].Messages > groups[j].Messages
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+// senderAddress returns the lower-cased address of a From header
+func senderAddress(from string) string {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(from))
+	}
+	return strings.ToLower(address.Address)
+}
+
+// WriteTable writes entries as a table, followed by their count and size
+func WriteTable(w io.Writer, entries []Entry) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "No matching messages")
+		return err
+	}
+
+	var total int64
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DATE\tFROM\tSUBJECT\tSIZE\tLABELS\tFILE")
+	for _, entry := range entries {
+		total += entry.Size
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Date.Local().Format("2006-01-02 15:04"), preview.Shorten(entry.From, 30), preview.Shorten(entry.Subject, 50),
+			metrics.FormatBytes(entry.Size), strings.Join(entry.Labels, ","), entry.File)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d messages, %s\n", len(entries), metrics.FormatBytes(total))
+	return err
+}
+
+// WriteGroups writes groups as a table
+func WriteGroups(w io.Writer, by string, groups []Group) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "%s\tMESSAGES\tSIZE\n", strings.ToUpper(by))
+	for _, group := range groups {
+		fmt.Fprintf(table, "%s\t%d\t%s\n", group.Key, group.Messages, metrics.FormatBytes(group.Size))
+	}
+	return table.Flush()
+}
+
+// WriteJSON writes value as indented JSON
+func WriteJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
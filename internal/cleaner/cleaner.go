@@ -5,12 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/gmail/v1"
 
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/audit"
 	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/console"
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
 	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
 )
 
@@ -18,16 +26,39 @@ import (
 const (
 	ActionArchive = "archive"
 	ActionDelete  = "delete"
+	ActionLabel   = "label"
 )
 
 // Config represents the cleaner configuration
 type Config struct {
 	CredentialsFile string `json:"credentials_file"`
 	TokenFile       string `json:"token_file"`
-	Action          string `json:"action"` // "archive" or "delete"
+	AuthMode        string `json:"auth_mode"`
+	Action          string `json:"action"` // "archive", "delete" or "label"
 	FilterFile      string `json:"filter_file"`
 	DryRun          bool   `json:"dry_run"`
 	Limit           int    `json:"limit"`
+	MetadataCache   string `json:"metadata_cache"` // default: metadata.db next to the filter file
+
+	// AddLabels and RemoveLabels are the labels the label action adds to and
+	// removes from each email, by name or ID. Added labels are created when
+	// missing, nested ones under their parents.
+	AddLabels    []string `json:"add_labels,omitempty"`
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+
+	// LegalHold refuses cleanup, as does a legal hold placed on the filter
+	// file's export directory
+	LegalHold bool `json:"legal_hold"`
+
+	// AuditLog is the audit log each archived, deleted and labeled email is
+	// recorded in (no audit log when empty), its entries signed with the
+	// Ed25519 key of AuditSigningKey when set
+	AuditLog        string `json:"audit_log,omitempty"`
+	AuditSigningKey string `json:"audit_signing_key,omitempty"`
+
+	// ConfirmDelete is called with the emails about to be deleted before any
+	// delete is issued. Returning an error aborts the cleanup.
+	ConfirmDelete func(emails []ProcessedEmail) error `json:"-"`
 }
 
 // Result represents the cleanup operation result
@@ -39,11 +70,19 @@ type Result struct {
 	Action         string        `json:"action"`
 	DryRun         bool          `json:"dry_run"`
 	Failures       []Failure     `json:"failures,omitempty"`
+
+	// FailedByCategory counts failures by error category (auth, rate_limit, ...)
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+
+	// Cancelled is set when the cleanup was stopped by Cancel before all
+	// emails were processed
+	Cancelled bool `json:"cancelled,omitempty"`
 }
 
 // Failure represents a failed cleanup operation
 type Failure struct {
 	EmailID   string    `json:"email_id"`
+	Category  string    `json:"category"`
 	Error     string    `json:"error"`
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -64,6 +103,15 @@ type Cleaner struct {
 	authenticator *auth.Authenticator
 	gmailService  *gmail.Service
 	metrics       *metrics.Collector
+	labels        *labels.Cache
+	audit         *audit.Log
+
+	// addLabelIDs and removeLabelIDs are the resolved labels of the label
+	// action
+	addLabelIDs    []string
+	removeLabelIDs []string
+
+	cancelled atomic.Bool
 }
 
 // New creates a new cleaner instance
@@ -73,14 +121,8 @@ func New(config *Config) (*Cleaner, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	// Create authenticator
-	authenticator, err := auth.NewAuthenticator(config.CredentialsFile, config.TokenFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create authenticator: %w", err)
-	}
-
 	// Get Gmail service
-	gmailService, err := authenticator.GetGmailService()
+	authenticator, gmailService, err := auth.NewGmailService(config.AuthMode, requiredAccess(config), config.CredentialsFile, config.TokenFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Gmail service: %w", err)
 	}
@@ -93,6 +135,7 @@ func New(config *Config) (*Cleaner, error) {
 		authenticator: authenticator,
 		gmailService:  gmailService,
 		metrics:       metricsCollector,
+		labels:        labels.ForService(gmailService, 0),
 	}, nil
 }
 
@@ -116,12 +159,45 @@ func (c *Cleaner) Cleanup() (*Result, error) {
 
 	logrus.WithField("count", len(processedEmails)).Info("Found processed emails to clean up")
 
+	// Fill in subjects and senders recorded by the export
+	c.enrichProcessedEmails(processedEmails)
+
 	// Apply limit if specified
 	if c.config.Limit > 0 && len(processedEmails) > c.config.Limit {
 		processedEmails = processedEmails[:c.config.Limit]
 		logrus.WithField("limited_count", len(processedEmails)).Info("Limited number of emails to process")
 	}
 
+	// Require confirmation before permanently deleting anything
+	if c.config.Action == ActionDelete && !c.config.DryRun && c.config.ConfirmDelete != nil {
+		if err := c.config.ConfirmDelete(processedEmails); err != nil {
+			return nil, fmt.Errorf("cleanup aborted: %w", err)
+		}
+	}
+
+	if c.config.Action == ActionLabel {
+		if err := c.resolveLabels(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Nothing is changed unless it can be audited
+	if c.config.AuditLog != "" && !c.config.DryRun {
+		auditLog, err := audit.Open(c.config.AuditLog, audit.Operator(c.gmailService), c.config.AuditSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		c.audit = auditLog
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				logrus.WithError(err).Warn("Failed to close audit log")
+			}
+		}()
+	}
+
+	// Set total matched in metrics
+	c.metrics.SetTotalMatched(len(processedEmails))
+
 	// Perform cleanup
 	result, err := c.cleanupEmails(processedEmails)
 	if err != nil {
@@ -133,11 +209,10 @@ func (c *Cleaner) Cleanup() (*Result, error) {
 	result.TotalFound = len(processedEmails)
 	result.Action = c.config.Action
 	result.DryRun = c.config.DryRun
+	result.Cancelled = c.cancelled.Load()
 
-	// Record metrics
-	c.metrics.RecordEmailsProcessed(result.TotalProcessed, result.TotalFailed)
+	// Record metrics (email counts are recorded live as each email completes)
 	c.metrics.RecordDuration(result.Duration)
-	c.metrics.SetTotalMatched(result.TotalFound)
 
 	// Save metrics
 	metricsPath := filepath.Join(filepath.Dir(c.config.FilterFile), "cleanup_metrics.json")
@@ -157,21 +232,94 @@ func (c *Cleaner) Cleanup() (*Result, error) {
 	return result, nil
 }
 
+// Cancel stops the cleanup after the email being processed, and Cleanup
+// returns the result so far with Cancelled set
+func (c *Cleaner) Cancel() {
+	if !c.cancelled.Swap(true) {
+		logrus.Info("Cleanup cancelled; stopping after the current email")
+	}
+}
+
 // loadProcessedEmails loads the list of processed emails from the filter file
 func (c *Cleaner) loadProcessedEmails() ([]ProcessedEmail, error) {
-	data, err := os.ReadFile(c.config.FilterFile)
-	if err != nil {
+	var processedEmails []ProcessedEmail
+	err := atomicfile.ReadFile(c.config.FilterFile, func(data []byte) error {
+		processedEmails = nil
+		if err := json.Unmarshal(data, &processedEmails); err != nil {
+			return fmt.Errorf("failed to parse filter file: %w", err)
+		}
+		return nil
+	})
+	if _, ok := err.(*os.PathError); ok {
 		return nil, fmt.Errorf("failed to read filter file: %w", err)
 	}
-
-	var processedEmails []ProcessedEmail
-	if err := json.Unmarshal(data, &processedEmails); err != nil {
-		return nil, fmt.Errorf("failed to parse filter file: %w", err)
+	if err != nil {
+		return nil, err
 	}
 
 	return processedEmails, nil
 }
 
+// enrichProcessedEmails fills in missing metadata from the export's metadata
+// cache, if one exists
+func (c *Cleaner) enrichProcessedEmails(processedEmails []ProcessedEmail) {
+	cachePath := c.config.MetadataCache
+	if cachePath == "" {
+		cachePath = filepath.Join(filepath.Dir(c.config.FilterFile), cache.DefaultFileName)
+	}
+
+	store, err := cache.OpenIfExists(cachePath)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to open metadata cache")
+		return
+	}
+	if store == nil {
+		return
+	}
+	defer store.Close()
+
+	enriched := EnrichFromCache(processedEmails, store)
+	logrus.WithFields(logrus.Fields{
+		"metadata_cache": cachePath,
+		"enriched":       enriched,
+	}).Debug("Loaded email metadata from cache")
+}
+
+// EnrichFromCache fills in empty Subject, From, Date and Size fields from the
+// metadata cache and returns the number of emails found in the cache
+func EnrichFromCache(processedEmails []ProcessedEmail, store *cache.Store) int {
+	enriched := 0
+
+	for i := range processedEmails {
+		email := &processedEmails[i]
+
+		metadata, err := store.Get(email.ID)
+		if err != nil {
+			logrus.WithError(err).WithField("email_id", email.ID).Debug("Failed to read cached metadata")
+			continue
+		}
+		if metadata == nil {
+			continue
+		}
+
+		if email.Subject == "" {
+			email.Subject = metadata.Subject
+		}
+		if email.From == "" {
+			email.From = metadata.From
+		}
+		if email.Date.IsZero() {
+			email.Date = metadata.Date
+		}
+		if email.Size == 0 {
+			email.Size = metadata.Size
+		}
+		enriched++
+	}
+
+	return enriched
+}
+
 // cleanupEmails performs cleanup on the specified emails
 func (c *Cleaner) cleanupEmails(processedEmails []ProcessedEmail) (*Result, error) {
 	result := &Result{
@@ -181,48 +329,100 @@ func (c *Cleaner) cleanupEmails(processedEmails []ProcessedEmail) (*Result, erro
 	// Process emails with progress indicator
 	total := len(processedEmails)
 	for i, email := range processedEmails {
-		err := c.cleanupSingleEmail(email.ID)
+		if c.cancelled.Load() {
+			break
+		}
+		err := c.cleanupSingleEmail(email)
 
 		if err != nil {
+			category := string(failure.Categorize(err))
 			result.TotalFailed++
+			if result.FailedByCategory == nil {
+				result.FailedByCategory = make(map[string]int)
+			}
+			result.FailedByCategory[category]++
 			result.Failures = append(result.Failures, Failure{
 				EmailID:   email.ID,
+				Category:  category,
 				Error:     err.Error(),
 				Timestamp: time.Now(),
 			})
+			c.metrics.AddFailed(1)
+			c.metrics.RecordFailure(email.ID, category, err.Error())
 			logrus.WithError(err).WithField("email_id", email.ID).Error("Failed to cleanup email")
 		} else {
 			result.TotalProcessed++
+			c.metrics.AddExported(1)
 		}
 
 		// Show progress
 		processed := i + 1
-		fmt.Printf("\rProgress: %d of %d messages %s (%.1f%%)",
-			processed, total, c.getActionVerb(), float64(processed)/float64(total)*100)
+		console.Default().SetStatus(0, fmt.Sprintf("Progress: %d of %d messages %s (%.1f%%)",
+			processed, total, c.getActionVerb(), float64(processed)/float64(total)*100))
 	}
-	fmt.Println() // New line after progress
+	console.Default().Finish()
 
 	return result, nil
 }
 
+// requiredAccess returns the token access the cleanup needs: deleting
+// permanently needs full access, and a dry run only reads
+func requiredAccess(config *Config) auth.Access {
+	switch {
+	case config.DryRun:
+		return auth.AccessReadonly
+	case config.Action == ActionDelete:
+		return auth.AccessFull
+	default:
+		return auth.AccessModify
+	}
+}
+
 // cleanupSingleEmail performs cleanup on a single email
-func (c *Cleaner) cleanupSingleEmail(emailID string) error {
+func (c *Cleaner) cleanupSingleEmail(email ProcessedEmail) error {
 	if c.config.DryRun {
 		logrus.WithFields(logrus.Fields{
-			"email_id": emailID,
+			"email_id": email.ID,
+			"subject":  email.Subject,
+			"from":     email.From,
 			"action":   c.config.Action,
 		}).Info("DRY RUN: Would perform cleanup action")
 		return nil
 	}
 
+	var err error
 	switch c.config.Action {
 	case ActionArchive:
-		return c.archiveEmail(emailID)
+		err = c.archiveEmail(email.ID)
 	case ActionDelete:
-		return c.deleteEmail(emailID)
+		err = c.deleteEmail(email.ID)
+	case ActionLabel:
+		err = c.labelEmail(email.ID)
 	default:
 		return fmt.Errorf("unsupported action: %s", c.config.Action)
 	}
+	if err != nil {
+		return err
+	}
+
+	c.recordAudit(email.ID)
+	return nil
+}
+
+// recordAudit records the action taken on an email in the audit log
+func (c *Cleaner) recordAudit(emailID string) {
+	entry := audit.Entry{
+		Action:     c.config.Action,
+		MessageIDs: []string{emailID},
+		Source:     c.config.FilterFile,
+	}
+	if c.config.Action == ActionLabel {
+		entry.AddLabels = c.addLabelIDs
+		entry.RemoveLabels = c.removeLabelIDs
+	}
+	if err := c.audit.Record(entry); err != nil {
+		logrus.WithError(err).WithField("email_id", emailID).Error("Failed to record cleanup in audit log")
+	}
 }
 
 // archiveEmail archives a single email
@@ -232,7 +432,9 @@ func (c *Cleaner) archiveEmail(emailID string) error {
 		RemoveLabelIds: []string{"INBOX"},
 	}
 
+	start := time.Now()
 	_, err := c.gmailService.Users.Messages.Modify("me", emailID, modifyRequest).Do()
+	c.metrics.RecordAPICall("messages.modify", time.Since(start), err)
 	if err != nil {
 		return fmt.Errorf("failed to archive email: %w", err)
 	}
@@ -240,9 +442,60 @@ func (c *Cleaner) archiveEmail(emailID string) error {
 	return nil
 }
 
+// resolveLabels resolves the labels of the label action, creating missing
+// labels to add. A dry run only reports the labels it would create.
+func (c *Cleaner) resolveLabels() error {
+	var err error
+	if c.removeLabelIDs, err = c.labels.Resolve(c.config.RemoveLabels); err != nil {
+		return fmt.Errorf("failed to resolve labels to remove: %w", err)
+	}
+
+	c.addLabelIDs = nil
+	for _, name := range c.config.AddLabels {
+		if c.config.DryRun {
+			label, err := c.labels.Lookup(name)
+			if err != nil {
+				return err
+			}
+			if label == nil {
+				logrus.WithField("label", name).Info("DRY RUN: Would create label")
+			}
+			continue
+		}
+
+		id, err := c.labels.Ensure(name)
+		if err != nil {
+			return err
+		}
+		c.addLabelIDs = append(c.addLabelIDs, id)
+	}
+
+	return nil
+}
+
+// labelEmail adds and removes the labels of the label action on a single
+// email
+func (c *Cleaner) labelEmail(emailID string) error {
+	modifyRequest := &gmail.ModifyMessageRequest{
+		AddLabelIds:    c.addLabelIDs,
+		RemoveLabelIds: c.removeLabelIDs,
+	}
+
+	start := time.Now()
+	_, err := c.gmailService.Users.Messages.Modify("me", emailID, modifyRequest).Do()
+	c.metrics.RecordAPICall("messages.modify", time.Since(start), err)
+	if err != nil {
+		return fmt.Errorf("failed to label email: %w", err)
+	}
+
+	return nil
+}
+
 // deleteEmail deletes a single email
 func (c *Cleaner) deleteEmail(emailID string) error {
+	start := time.Now()
 	err := c.gmailService.Users.Messages.Delete("me", emailID).Do()
+	c.metrics.RecordAPICall("messages.delete", time.Since(start), err)
 	if err != nil {
 		return fmt.Errorf("failed to delete email: %w", err)
 	}
@@ -257,6 +510,8 @@ func (c *Cleaner) getActionVerb() string {
 		return "archived"
 	case ActionDelete:
 		return "deleted"
+	case ActionLabel:
+		return "labeled"
 	default:
 		return "processed"
 	}
@@ -264,12 +519,20 @@ func (c *Cleaner) getActionVerb() string {
 
 // validateConfig validates the cleaner configuration
 func validateConfig(config *Config) error {
+	if err := auth.ValidateMode(config.AuthMode); err != nil {
+		return err
+	}
+
 	if config.Action == "" {
 		config.Action = ActionArchive // Default action
 	}
 
-	if config.Action != ActionArchive && config.Action != ActionDelete {
-		return fmt.Errorf("action must be '%s' or '%s', got: %s", ActionArchive, ActionDelete, config.Action)
+	if config.Action != ActionArchive && config.Action != ActionDelete && config.Action != ActionLabel {
+		return fmt.Errorf("action must be '%s', '%s' or '%s', got: %s", ActionArchive, ActionDelete, ActionLabel, config.Action)
+	}
+
+	if config.Action == ActionLabel && len(config.AddLabels) == 0 && len(config.RemoveLabels) == 0 {
+		return fmt.Errorf("label action requires labels to add or remove")
 	}
 
 	if config.FilterFile == "" {
@@ -284,5 +547,20 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("limit must be >= 0")
 	}
 
+	// Nothing may be archived or deleted while the export is under legal hold
+	if !config.DryRun {
+		if config.LegalHold {
+			return fmt.Errorf("cleanup is disabled while legal hold is set")
+		}
+		hold, err := custody.CheckHold(filepath.Dir(config.FilterFile))
+		if err != nil {
+			return err
+		}
+		if hold != nil {
+			return fmt.Errorf("export is under legal hold (placed %s by %s); cleanup is refused",
+				hold.PlacedAt.Format(time.RFC3339), hold.Operator)
+		}
+	}
+
 	return nil
 }
@@ -2,10 +2,18 @@ package cleaner
 
 import (
 	"encoding/json"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/audit"
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+	"github.com/octasoft-ltd/gmail-exporter/internal/mockgmail"
 )
 
 func TestNew(t *testing.T) {
@@ -100,6 +108,24 @@ func TestValidateConfig(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "valid config with label",
+			config: &Config{
+				Action:       "label",
+				FilterFile:   validFilterFile,
+				AddLabels:    []string{"Exported/2024"},
+				RemoveLabels: []string{"INBOX"},
+			},
+			expectError: false,
+		},
+		{
+			name: "label action without labels",
+			config: &Config{
+				Action:     "label",
+				FilterFile: validFilterFile,
+			},
+			expectError: true,
+		},
 		{
 			name: "invalid action",
 			config: &Config{
@@ -147,6 +173,28 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateConfig_LegalHold(t *testing.T) {
+	dir := t.TempDir()
+	filterFile := filepath.Join(dir, "processed_emails.json")
+	if err := os.WriteFile(filterFile, []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateConfig(&Config{FilterFile: filterFile, LegalHold: true}); err == nil {
+		t.Error("Expected cleanup to be refused with legal hold set")
+	}
+
+	if err := custody.PlaceHold(dir, custody.Hold{Operator: "counsel"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateConfig(&Config{Action: ActionDelete, FilterFile: filterFile}); err == nil {
+		t.Error("Expected cleanup to be refused for an export under legal hold")
+	}
+	if err := validateConfig(&Config{Action: ActionDelete, FilterFile: filterFile, DryRun: true}); err != nil {
+		t.Errorf("Expected dry run to be allowed under legal hold, got %v", err)
+	}
+}
+
 func TestLoadProcessedEmails(t *testing.T) {
 	// Create temporary directory
 	tempDir, err := os.MkdirTemp("", "cleaner_test")
@@ -266,6 +314,11 @@ func TestGetActionVerb(t *testing.T) {
 			action:   "delete",
 			expected: "deleted",
 		},
+		{
+			name:     "label action",
+			action:   "label",
+			expected: "labeled",
+		},
 		{
 			name:     "unknown action",
 			action:   "unknown",
@@ -288,3 +341,116 @@ func TestGetActionVerb(t *testing.T) {
 		})
 	}
 }
+
+func TestEnrichFromCache(t *testing.T) {
+	store, err := cache.Open(filepath.Join(t.TempDir(), cache.DefaultFileName))
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	defer store.Close()
+
+	date := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	if err := store.Put(cache.Metadata{ID: "18c1a2b3c4d5e6f7", Subject: "Invoice", From: "billing@example.com", Date: date, Size: 1024}); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+
+	emails := []ProcessedEmail{
+		{ID: "18c1a2b3c4d5e6f7", Size: 2048},
+		{ID: "18c1a2b3c4d5e6f8"},
+	}
+
+	if enriched := EnrichFromCache(emails, store); enriched != 1 {
+		t.Errorf("Expected 1 enriched email, got %d", enriched)
+	}
+	if emails[0].Subject != "Invoice" || emails[0].From != "billing@example.com" || !emails[0].Date.Equal(date) {
+		t.Errorf("Unexpected enriched email: %+v", emails[0])
+	}
+	if emails[0].Size != 2048 {
+		t.Errorf("Expected existing size to be kept, got %d", emails[0].Size)
+	}
+	if emails[1].Subject != "" {
+		t.Errorf("Expected uncached email to be unchanged, got %+v", emails[1])
+	}
+}
+
+func TestCleanupEmails_Cancelled(t *testing.T) {
+	cleaner := &Cleaner{
+		config:  &Config{Action: ActionArchive, DryRun: true},
+		metrics: metrics.NewCollector("cleanup"),
+	}
+	cleaner.Cancel()
+
+	result, err := cleaner.cleanupEmails([]ProcessedEmail{{ID: "a"}, {ID: "b"}})
+	if err != nil {
+		t.Fatalf("cleanupEmails() error = %v", err)
+	}
+	if result.TotalProcessed != 0 {
+		t.Errorf("Expected no emails processed after Cancel, got %d", result.TotalProcessed)
+	}
+}
+
+func TestCleanup_AuditLog(t *testing.T) {
+	mailbox := mockgmail.New("")
+	var ids []string
+	for _, subject := range []string{"One", "Two"} {
+		id, err := mailbox.AddMessage([]byte("From: a@example.com\r\nSubject: "+subject+"\r\n\r\nBody\r\n"), "INBOX")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+	if err := auth.SetAPIEndpoint(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auth.SetAPIEndpoint("") })
+	t.Setenv(auth.TokenEnvVar, mockgmail.TokenJSON)
+
+	dir := t.TempDir()
+	filterFile := filepath.Join(dir, "processed.json")
+	data, err := json.Marshal([]ProcessedEmail{{ID: ids[0]}, {ID: ids[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filterFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	auditLog := filepath.Join(dir, "audit.jsonl")
+
+	for _, dryRun := range []bool{true, false} {
+		cl, err := New(&Config{
+			CredentialsFile: filepath.Join(dir, "credentials.json"),
+			TokenFile:       filepath.Join(dir, "token.json"),
+			Action:          ActionDelete,
+			FilterFile:      filterFile,
+			DryRun:          dryRun,
+			AuditLog:        auditLog,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if _, err := cl.Cleanup(); err != nil {
+			t.Fatalf("Cleanup() error = %v", err)
+		}
+	}
+
+	// Only the deletions of the real run are audited
+	entries, err := audit.Load(auditLog)
+	if err != nil {
+		t.Fatalf("audit.Load() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %+v", entries)
+	}
+	for i, entry := range entries {
+		if entry.Operator != mockgmail.DefaultAccount || entry.Action != audit.ActionDelete ||
+			len(entry.MessageIDs) != 1 || entry.MessageIDs[0] != ids[i] || entry.Source != filterFile {
+			t.Errorf("Unexpected audit entry %d: %+v", i, entry)
+		}
+	}
+	if len(mailbox.Messages()) != 0 {
+		t.Errorf("Expected both messages deleted, %d left", len(mailbox.Messages()))
+	}
+}
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+// accountProfile is a named source account defined under "accounts" in the
+// config file
+type accountProfile struct {
+	Name            string `mapstructure:"-"`
+	CredentialsFile string `mapstructure:"credentials_file"`
+	TokenFile       string `mapstructure:"token_file"`
+}
+
+// exportTarget is a single mailbox to export as part of a multi-account run
+type exportTarget struct {
+	Name   string
+	Config *exporter.Config
+}
+
+// AccountResult is the outcome of exporting one account
+type AccountResult struct {
+	Account   string           `json:"account"`
+	OutputDir string           `json:"output_dir"`
+	Result    *exporter.Result `json:"result,omitempty"`
+	Error     string           `json:"error,omitempty"`
+
+	err error
+}
+
+// AccountsSummary is the combined report of a multi-account export
+type AccountsSummary struct {
+	Accounts         []AccountResult `json:"accounts"`
+	TotalMatched     int             `json:"total_matched"`
+	TotalExported    int             `json:"total_exported"`
+	TotalFailed      int             `json:"total_failed"`
+	TotalSize        int64           `json:"total_size"`
+	FailedAccounts   int             `json:"failed_accounts"`
+	FailedByCategory map[string]int  `json:"failed_by_category,omitempty"`
+	Duration         time.Duration   `json:"duration"`
+}
+
+// loadAccountProfiles returns the named profiles from the config file
+func loadAccountProfiles(names []string) ([]accountProfile, error) {
+	var configured map[string]accountProfile
+	if err := viper.UnmarshalKey("accounts", &configured); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts configuration: %w", err)
+	}
+
+	profiles := make([]accountProfile, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		profile, ok := configured[name]
+		if !ok {
+			return nil, fmt.Errorf("account %q is not defined under accounts in the config file", name)
+		}
+		if profile.CredentialsFile == "" || profile.TokenFile == "" {
+			return nil, fmt.Errorf("account %q must set credentials_file and token_file", name)
+		}
+		profile.Name = name
+		profiles = append(profiles, profile)
+	}
+
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no accounts specified")
+	}
+
+	return profiles, nil
+}
+
+// accountTargets builds one export configuration per profile, each writing
+// into its own subdirectory of the base output directory
+func accountTargets(base *exporter.Config, profiles []accountProfile) []exportTarget {
+	targets := make([]exportTarget, 0, len(profiles))
+	for _, profile := range profiles {
+		config := targetConfig(base, profile.Name)
+		config.CredentialsFile = profile.CredentialsFile
+		config.TokenFile = profile.TokenFile
+
+		targets = append(targets, exportTarget{Name: profile.Name, Config: config})
+	}
+	return targets
+}
+
+// targetConfig copies the base export configuration for one target, writing
+// into the target's own subdirectory
+func targetConfig(base *exporter.Config, name string) *exporter.Config {
+	config := *base
+	config.OutputDir = filepath.Join(base.OutputDir, name)
+
+	// Per-target defaults live inside each target's directory
+	config.StateFile = ""
+	config.MetadataCache = ""
+
+	// Each target gets its own Thunderbird folders
+	if base.ThunderbirdDir != "" {
+		config.ThunderbirdDir = filepath.Join(base.ThunderbirdDir, name)
+	}
+
+	// Each target links against its own directory of the previous snapshot
+	if base.LinkDest != "" {
+		config.LinkDest = filepath.Join(base.LinkDest, name)
+	}
+
+	// Additional OAuth client tokens belong to the primary mailbox
+	config.OAuthClients = nil
+
+	// The pause file of the base directory pauses every target
+	if config.PauseFile == "" {
+		config.PauseFile = filepath.Join(base.OutputDir, exporter.PauseFileName)
+	}
+
+	return &config
+}
+
+// exportTargets exports each target, running up to parallel exports at once,
+// and returns the results in target order
+func exportTargets(targets []exportTarget, filterConfig *filters.Config, parallel int) []AccountResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]AccountResult, len(targets))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, target exportTarget) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = runExportTarget(target, filterConfig)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// runExportTarget runs a single account export
+func runExportTarget(target exportTarget, filterConfig *filters.Config) AccountResult {
+	logger := logrus.WithField("account", target.Name)
+	accountResult := AccountResult{Account: target.Name, OutputDir: target.Config.OutputDir}
+
+	// Each export gets its own filter copy so parallel exports share no state
+	accountFilter := *filterConfig
+
+	// Accounts not started before the run was interrupted are left for --resume
+	if exportPauses.isStopped() {
+		accountResult.Error = "not started: export interrupted"
+		accountResult.err = errExportInterrupted
+		return accountResult
+	}
+
+	exp, err := exporter.New(target.Config)
+	if err == nil {
+		exportPauses.add(exp)
+		logger.Info("Starting account export")
+		accountResult.Result, err = exp.Export(&accountFilter)
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Account export failed")
+		accountResult.Error = err.Error()
+		accountResult.err = err
+		return accountResult
+	}
+
+	logger.WithFields(logrus.Fields{
+		"exported": accountResult.Result.TotalExported,
+		"failed":   accountResult.Result.TotalFailed,
+	}).Info("Finished account export")
+
+	return accountResult
+}
+
+// summarizeAccounts combines per-account results into a single report
+func summarizeAccounts(results []AccountResult, duration time.Duration) *AccountsSummary {
+	summary := &AccountsSummary{Accounts: results, Duration: duration}
+
+	addFailures := func(category string, count int) {
+		if summary.FailedByCategory == nil {
+			summary.FailedByCategory = make(map[string]int)
+		}
+		summary.FailedByCategory[category] += count
+	}
+
+	for _, result := range results {
+		if result.err != nil || result.Result == nil {
+			summary.FailedAccounts++
+			addFailures(string(failure.Categorize(result.err)), 1)
+			continue
+		}
+
+		summary.TotalMatched += result.Result.TotalMatched
+		summary.TotalExported += result.Result.TotalExported
+		summary.TotalFailed += result.Result.TotalFailed
+		summary.TotalSize += result.Result.TotalSize
+		for category, count := range result.Result.FailedByCategory {
+			addFailures(category, count)
+		}
+	}
+
+	return summary
+}
+
+// writeAccountsSummary writes the combined report as JSON
+func writeAccountsSummary(path string, summary *AccountsSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal accounts summary: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write accounts summary: %w", err)
+	}
+
+	return nil
+}
+
+// runAccountsExport exports several targets and prints a combined summary
+func runAccountsExport(cmd *cobra.Command, targets []exportTarget, baseOutputDir string, filterConfig *filters.Config) error {
+	parallel, _ := cmd.Flags().GetInt("parallel-accounts")
+
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	logrus.WithFields(logrus.Fields{
+		"accounts": names,
+		"parallel": parallel,
+	}).Info("Starting multi-account export")
+
+	start := time.Now()
+	results := exportTargets(targets, filterConfig, parallel)
+	summary := summarizeAccounts(results, time.Since(start))
+	recordResult(summary)
+
+	summaryPath := filepath.Join(baseOutputDir, "accounts_summary.json")
+	if err := writeAccountsSummary(summaryPath, summary); err != nil {
+		logrus.WithError(err).Warn("Failed to save accounts summary")
+	}
+
+	printAccountsSummary(summary)
+	fmt.Printf("Summary report: %s\n", summaryPath)
+
+	if exportPauses.isStopped() {
+		cmd.SilenceUsage = true
+		return errExportInterrupted
+	}
+
+	if err := runBackup(cmd, baseOutputDir); err != nil {
+		return err
+	}
+	if err := runPostHook(cmd, hookResult{Dir: baseOutputDir, Exported: summary.TotalExported, Failed: summary.TotalFailed}); err != nil {
+		return err
+	}
+
+	return partialFailure(cmd, "account exports or messages",
+		summary.FailedAccounts+summary.TotalFailed, summary.FailedByCategory)
+}
+
+// printAccountsSummary prints a per-account table and the combined totals
+func printAccountsSummary(summary *AccountsSummary) {
+	results := append([]AccountResult(nil), summary.Accounts...)
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Account < results[j].Account })
+
+	fmt.Printf("\n%-32s %10s %10s %8s %10s\n", "ACCOUNT", "MATCHED", "EXPORTED", "FAILED", "SIZE")
+	for _, result := range results {
+		if result.Result == nil {
+			fmt.Printf("%-32s %s\n", truncate(result.Account, 32), "ERROR: "+result.Error)
+			continue
+		}
+		fmt.Printf("%-32s %10d %10d %8d %10s\n", truncate(result.Account, 32),
+			result.Result.TotalMatched, result.Result.TotalExported, result.Result.TotalFailed,
+			formatBytes(result.Result.TotalSize))
+	}
+	fmt.Printf("%-32s %10d %10d %8d %10s\n", "TOTAL",
+		summary.TotalMatched, summary.TotalExported, summary.TotalFailed, formatBytes(summary.TotalSize))
+	fmt.Printf("\nDuration: %s\n", summary.Duration)
+	if summary.FailedAccounts > 0 {
+		fmt.Printf("Failed accounts: %d\n", summary.FailedAccounts)
+	}
+}
+
+// resolveAccountFiles returns the credentials and token files for the
+// --account profile, or the configured defaults when no profile is given
+func resolveAccountFiles(cmd *cobra.Command) (credentialsFile, tokenFile string, err error) {
+	if name, _ := cmd.Flags().GetString("client"); name != "" {
+		client, err := findOAuthClient(name)
+		if err != nil {
+			return "", "", err
+		}
+		return client.CredentialsFile, client.TokenFile, nil
+	}
+
+	account, _ := cmd.Flags().GetString("account")
+	if account == "" {
+		return viper.GetString("credentials_file"), viper.GetString("token_file"), nil
+	}
+
+	profiles, err := loadAccountProfiles([]string{account})
+	if err != nil {
+		return "", "", err
+	}
+
+	return profiles[0].CredentialsFile, profiles[0].TokenFile, nil
+}
+
+// loadOAuthClients returns the additional OAuth clients from the config file
+func loadOAuthClients() ([]auth.OAuthClient, error) {
+	var clients []auth.OAuthClient
+	if err := viper.UnmarshalKey("oauth_clients", &clients); err != nil {
+		return nil, fmt.Errorf("failed to parse oauth_clients configuration: %w", err)
+	}
+	return clients, nil
+}
+
+// findOAuthClient returns the named additional OAuth client
+func findOAuthClient(name string) (*auth.OAuthClient, error) {
+	clients, err := loadOAuthClients()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range clients {
+		if clients[i].Name == name {
+			if clients[i].CredentialsFile == "" || clients[i].TokenFile == "" {
+				return nil, fmt.Errorf("OAuth client %q must set credentials_file and token_file", name)
+			}
+			return &clients[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("OAuth client %q is not defined under oauth_clients in the config file", name)
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+func TestLoadAccountProfiles(t *testing.T) {
+	viper.Set("accounts", map[string]interface{}{
+		"work":       map[string]interface{}{"credentials_file": "work-creds.json", "token_file": "work-token.json"},
+		"personal":   map[string]interface{}{"credentials_file": "personal-creds.json", "token_file": "personal-token.json"},
+		"incomplete": map[string]interface{}{"credentials_file": "creds.json"},
+	})
+	defer viper.Set("accounts", nil)
+
+	profiles, err := loadAccountProfiles([]string{"work", " personal", "work"})
+	if err != nil {
+		t.Fatalf("loadAccountProfiles failed: %v", err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("Expected 2 profiles, got %d", len(profiles))
+	}
+	if profiles[0].Name != "work" || profiles[0].TokenFile != "work-token.json" {
+		t.Errorf("Unexpected first profile: %+v", profiles[0])
+	}
+	if profiles[1].Name != "personal" || profiles[1].CredentialsFile != "personal-creds.json" {
+		t.Errorf("Unexpected second profile: %+v", profiles[1])
+	}
+
+	if _, err := loadAccountProfiles([]string{"missing"}); err == nil {
+		t.Error("Expected error for undefined account")
+	}
+	if _, err := loadAccountProfiles([]string{"incomplete"}); err == nil {
+		t.Error("Expected error for account without token file")
+	}
+	if _, err := loadAccountProfiles(nil); err == nil {
+		t.Error("Expected error when no accounts are given")
+	}
+}
+
+func TestAccountTargets(t *testing.T) {
+	base := &exporter.Config{
+		CredentialsFile: "default-creds.json",
+		OutputDir:       "exports",
+		StateFile:       "state.json",
+		Format:          "eml",
+	}
+	profiles := []accountProfile{
+		{Name: "work", CredentialsFile: "work-creds.json", TokenFile: "work-token.json"},
+		{Name: "personal", CredentialsFile: "personal-creds.json", TokenFile: "personal-token.json"},
+	}
+
+	targets := accountTargets(base, profiles)
+
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	work := targets[0].Config
+	if work.OutputDir != filepath.Join("exports", "work") || work.CredentialsFile != "work-creds.json" {
+		t.Errorf("Unexpected work config: %+v", work)
+	}
+	if work.StateFile != "" || work.Format != "eml" {
+		t.Errorf("Expected per-account state file and inherited format, got %+v", work)
+	}
+	if base.OutputDir != "exports" || base.CredentialsFile != "default-creds.json" {
+		t.Error("Expected base config to be unchanged")
+	}
+}
+
+func TestSummarizeAccounts(t *testing.T) {
+	results := []AccountResult{
+		{Account: "work", Result: &exporter.Result{
+			TotalMatched: 10, TotalExported: 8, TotalFailed: 2, TotalSize: 1000,
+			FailedByCategory: map[string]int{"network": 2},
+		}},
+		{Account: "personal", Result: &exporter.Result{TotalMatched: 5, TotalExported: 5, TotalSize: 500}},
+		{Account: "old", Error: "not authenticated", err: auth.ErrNotAuthenticated},
+	}
+
+	summary := summarizeAccounts(results, 0)
+
+	if summary.TotalMatched != 15 || summary.TotalExported != 13 || summary.TotalFailed != 2 || summary.TotalSize != 1500 {
+		t.Errorf("Unexpected totals: %+v", summary)
+	}
+	if summary.FailedAccounts != 1 {
+		t.Errorf("Expected 1 failed account, got %d", summary.FailedAccounts)
+	}
+	if summary.FailedByCategory["network"] != 2 || summary.FailedByCategory["auth"] != 1 {
+		t.Errorf("Unexpected failure categories: %v", summary.FailedByCategory)
+	}
+
+	path := filepath.Join(t.TempDir(), "nested", "accounts_summary.json")
+	if err := writeAccountsSummary(path, summary); err != nil {
+		t.Fatalf("writeAccountsSummary failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read summary: %v", err)
+	}
+	var loaded AccountsSummary
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Failed to parse summary: %v", err)
+	}
+	if len(loaded.Accounts) != 3 || loaded.Accounts[2].Error != "not authenticated" {
+		t.Errorf("Unexpected saved summary: %+v", loaded)
+	}
+}
+
+func TestFindOAuthClient(t *testing.T) {
+	viper.Set("oauth_clients", []interface{}{
+		map[string]interface{}{"name": "second", "credentials_file": "second-creds.json", "token_file": "second-token.json"},
+		map[string]interface{}{"name": "incomplete", "credentials_file": "creds.json"},
+	})
+	defer viper.Set("oauth_clients", nil)
+
+	client, err := findOAuthClient("second")
+	if err != nil {
+		t.Fatalf("findOAuthClient failed: %v", err)
+	}
+	if client.CredentialsFile != "second-creds.json" || client.TokenFile != "second-token.json" {
+		t.Errorf("Unexpected client: %+v", client)
+	}
+
+	if _, err := findOAuthClient("missing"); err == nil {
+		t.Error("Expected error for undefined client")
+	}
+	if _, err := findOAuthClient("incomplete"); err == nil {
+		t.Error("Expected error for incomplete client")
+	}
+}
+
+func TestTargetConfig_DropsOAuthClients(t *testing.T) {
+	base := &exporter.Config{
+		OutputDir:    "exports",
+		OAuthClients: []auth.OAuthClient{{Name: "second"}},
+	}
+
+	if config := targetConfig(base, "work"); config.OAuthClients != nil {
+		t.Errorf("Expected no OAuth clients for another mailbox, got %v", config.OAuthClients)
+	}
+}
+
+func TestTargetConfig_SharesPauseFile(t *testing.T) {
+	base := &exporter.Config{OutputDir: "exports"}
+
+	config := targetConfig(base, "work")
+	expected := filepath.Join("exports", exporter.PauseFileName)
+	if config.PauseFile != expected {
+		t.Errorf("Expected pause file %s, got %s", expected, config.PauseFile)
+	}
+}
@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/audit"
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log of changes made to mailboxes",
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show who imported, archived, labeled or deleted which messages, and when",
+	Long: `Show the entries of the audit log, which import and cleanup append to for every
+message they import, archive, label or delete: the time, the operator the token
+was issued to, the action, the message IDs and the file they came from. Dry runs
+are not recorded.
+
+The audit log is ~/.gmail-exporter/audit.jsonl unless --audit-log or audit_log in
+the config file says otherwise. It is only ever appended to.
+
+EXAMPLES:
+  gmail-exporter audit show --action delete --since 2024-03-01
+  gmail-exporter audit show --message-id 18e0f3c2a1b4d5e6
+  gmail-exporter audit show --operator admin@example.com --format json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid format: %s (valid: text, json)", format)
+		}
+		last, _ := cmd.Flags().GetInt("last")
+		if last < 0 {
+			return fmt.Errorf("last must be >= 0")
+		}
+
+		filter := audit.Filter{}
+		filter.Action, _ = cmd.Flags().GetString("action")
+		filter.Operator, _ = cmd.Flags().GetString("operator")
+		filter.MessageID, _ = cmd.Flags().GetString("message-id")
+		if since, _ := cmd.Flags().GetString("since"); since != "" {
+			date, err := time.ParseInLocation("2006-01-02", since, time.Local)
+			if err != nil {
+				return fmt.Errorf("invalid since format (use YYYY-MM-DD): %w", err)
+			}
+			filter.Since = date
+		}
+		if until, _ := cmd.Flags().GetString("until"); until != "" {
+			date, err := time.ParseInLocation("2006-01-02", until, time.Local)
+			if err != nil {
+				return fmt.Errorf("invalid until format (use YYYY-MM-DD): %w", err)
+			}
+			// Until includes the whole day
+			filter.Until = date.AddDate(0, 0, 1)
+		}
+
+		path := viper.GetString("audit_log")
+		entries, err := audit.Load(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no audit log at %s: nothing has been imported or cleaned up yet", path)
+			}
+			return err
+		}
+
+		entries = audit.Select(entries, filter)
+		if last > 0 && len(entries) > last {
+			entries = entries[len(entries)-last:]
+		}
+		if format == "json" {
+			return audit.WriteJSON(os.Stdout, entries)
+		}
+		return audit.WriteText(os.Stdout, entries)
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the audit log has not been tampered with",
+	Long: `Check the hash chain of the audit log: every entry holds the hash of the entry
+before it, so an entry edited, removed, inserted or reordered after it was written
+breaks the chain. With --public-key, every entry must also carry a valid Ed25519
+signature by that key, written by runs given --audit-signing-key.
+
+Entries recorded before hash chaining are counted but cannot be checked, unless
+--public-key is given, under which they fail as unsigned. As stripping the hashes
+from an entry makes it look recorded before chaining, use --require-chain for
+logs started with this version to reject such entries. Removing
+entries from the end of the log leaves the chain intact; record the head hash
+printed here somewhere else, such as a ticket or a WORM store, and compare it
+later to detect that.
+
+EXAMPLES:
+  gmail-exporter audit verify
+  gmail-exporter audit verify --require-chain
+  gmail-exporter audit verify --public-key audit-signing.pub --format json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid format: %s (valid: text, json)", format)
+		}
+
+		options := audit.VerifyOptions{}
+		options.RequireChain, _ = cmd.Flags().GetBool("require-chain")
+		if publicKeyFile, _ := cmd.Flags().GetString("public-key"); publicKeyFile != "" {
+			var err error
+			if options.PublicKey, err = signing.LoadPublicKey(publicKeyFile); err != nil {
+				return err
+			}
+		}
+
+		path := viper.GetString("audit_log")
+		result, err := audit.Verify(path, options)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no audit log at %s: nothing has been imported or cleaned up yet", path)
+			}
+			return err
+		}
+
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("File: %s\n", path)
+			fmt.Printf("Entries: %d (%d signed, %d recorded before chaining)\n", result.Entries, result.Signed, result.Unchained)
+			if options.PublicKey != nil {
+				fmt.Printf("Public key: %s\n", signing.KeyID(options.PublicKey))
+			}
+			fmt.Printf("Head hash: %s\n", result.Head)
+			for _, problem := range result.Problems {
+				fmt.Printf("! line %d: %s\n", problem.Line, problem.Reason)
+			}
+		}
+
+		if len(result.Problems) > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d audit log entries failed verification", len(result.Problems))
+		}
+		if format == "text" {
+			fmt.Println("Audit log: intact")
+		}
+		return nil
+	},
+}
+
+func init() {
+	auditShowCmd.Flags().String("action", "", "Show only this action (import, archive, label, delete)")
+	auditShowCmd.Flags().String("operator", "", "Show only changes made by this account")
+	auditShowCmd.Flags().String("message-id", "", "Show only changes to this Gmail message ID")
+	auditShowCmd.Flags().String("since", "", "Show only changes made on or after this date (YYYY-MM-DD)")
+	auditShowCmd.Flags().String("until", "", "Show only changes made on or before this date (YYYY-MM-DD)")
+	auditShowCmd.Flags().Int("last", 0, "Show only the most recent entries (0 = all)")
+	auditShowCmd.Flags().String("format", "text", "Output format (text, json)")
+
+	auditVerifyCmd.Flags().String("public-key", "", "Ed25519 public key (PEM) every entry must be signed with")
+	auditVerifyCmd.Flags().Bool("require-chain", false, "Reject entries without a hash, as if recorded before hash chaining")
+	auditVerifyCmd.Flags().String("format", "text", "Output format (text, json)")
+
+	auditCmd.AddCommand(auditShowCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+}
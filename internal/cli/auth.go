@@ -1,7 +1,9 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -34,6 +36,10 @@ downloaded from Google Cloud Console.`,
 			return fmt.Errorf("credentials file does not exist: %s", credentialsFile)
 		}
 
+		if auth.IsSecretURI(viper.GetString("credentials_file")) {
+			return fmt.Errorf("credentials_file is a secret URI; store the credentials in the secret backend instead")
+		}
+
 		// Create the config directory
 		configDir := filepath.Dir(viper.GetString("credentials_file"))
 		if err := os.MkdirAll(configDir, 0o700); err != nil {
@@ -59,10 +65,12 @@ var authLoginCmd = &cobra.Command{
 	Short: "Authenticate with Gmail API",
 	Long:  `Authenticate with Gmail API using OAuth 2.0 flow.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		credentialsFile := viper.GetString("credentials_file")
-		tokenFile := viper.GetString("token_file")
+		credentialsFile, tokenFile, access, err := resolveScopedFiles(cmd)
+		if err != nil {
+			return err
+		}
 
-		authenticator, err := auth.NewAuthenticator(credentialsFile, tokenFile)
+		authenticator, err := auth.NewScopedAuthenticator(credentialsFile, tokenFile, access)
 		if err != nil {
 			return fmt.Errorf("failed to create authenticator: %w", err)
 		}
@@ -81,8 +89,10 @@ var authRefreshCmd = &cobra.Command{
 	Short: "Refresh authentication token",
 	Long:  `Refresh the authentication token if it has expired.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		credentialsFile := viper.GetString("credentials_file")
-		tokenFile := viper.GetString("token_file")
+		credentialsFile, tokenFile, _, err := resolveScopedFiles(cmd)
+		if err != nil {
+			return err
+		}
 
 		authenticator, err := auth.NewAuthenticator(credentialsFile, tokenFile)
 		if err != nil {
@@ -103,8 +113,19 @@ var authStatusCmd = &cobra.Command{
 	Short: "Check authentication status",
 	Long:  `Check the current authentication status and token validity.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		credentialsFile := viper.GetString("credentials_file")
-		tokenFile := viper.GetString("token_file")
+		credentialsFile, tokenFile, _, err := resolveScopedFiles(cmd)
+		if err != nil {
+			return err
+		}
+
+		mode := viper.GetString("auth_mode")
+		if err := auth.ValidateMode(mode); err != nil {
+			return err
+		}
+		if auth.ResolveMode(mode, tokenFile) == auth.ModeADC {
+			fmt.Println("Authentication Status: application default credentials")
+			return nil
+		}
 
 		authenticator, err := auth.NewAuthenticator(credentialsFile, tokenFile)
 		if err != nil {
@@ -123,17 +144,216 @@ var authStatusCmd = &cobra.Command{
 		if status.Email != "" {
 			fmt.Printf("Authenticated Email: %s\n", status.Email)
 		}
+		if status.Origin != "" {
+			fmt.Printf("Token Source: %s\n", status.Origin)
+		}
+
+		return nil
+	},
+}
+
+var authDoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose authentication problems",
+	Long: `Check everything a command needs to authenticate, and say how to fix what fails:
+
+  connectivity     Google's OAuth and Gmail API endpoints can be reached through the
+                   configured proxy and CA certificates
+  credentials      The OAuth client credentials can be read and parsed
+  token            The token loads and can still be refreshed (the refreshed token is
+                   not saved); tokens that cannot be refreshed warn a day before expiry
+  scopes           The scopes granted to the token allow each command
+  gmail_api        The Gmail API is enabled on the OAuth client's project
+
+With Application Default Credentials the credentials and token checks are replaced by
+one that finds the credentials and gets a token. The command exits with code 4 when
+a check fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credentialsFile, tokenFile, _, err := resolveScopedFiles(cmd)
+		if err != nil {
+			return err
+		}
+
+		mode := viper.GetString("auth_mode")
+		if err := auth.ValidateMode(mode); err != nil {
+			return err
+		}
+
+		checks := auth.Diagnose(mode, credentialsFile, tokenFile)
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(checks); err != nil {
+				return fmt.Errorf("failed to write checks: %w", err)
+			}
+		} else {
+			printChecks(checks)
+		}
+
+		failed := 0
+		for _, check := range checks {
+			if check.Status == auth.CheckFail {
+				failed++
+			}
+		}
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			return &exitError{code: ExitAuthError, err: fmt.Errorf("%d authentication checks failed", failed)}
+		}
+		return nil
+	},
+}
+
+// printChecks prints auth doctor checks with the fix for each problem
+func printChecks(checks []auth.Check) {
+	labels := map[string]string{
+		auth.CheckOK:   " OK ",
+		auth.CheckWarn: "WARN",
+		auth.CheckFail: "FAIL",
+		auth.CheckSkip: "SKIP",
+	}
+	for _, check := range checks {
+		fmt.Printf("[%s] %s", labels[check.Status], check.Name)
+		if check.Detail != "" {
+			fmt.Printf(": %s", check.Detail)
+		}
+		fmt.Println()
+		if check.Fix != "" {
+			fmt.Printf("       Fix: %s\n", check.Fix)
+		}
+	}
+}
+
+var authImportTokenCmd = &cobra.Command{
+	Use:   "import-token [file]",
+	Short: "Import an existing OAuth token",
+	Long: `Import an OAuth token obtained elsewhere, for containers and other
+hosts where neither a browser nor a terminal prompt is available.
+
+The token JSON is read from the given file, from the ` + auth.TokenB64EnvVar + `,
+` + auth.TokenFDEnvVar + ` or ` + auth.TokenEnvVar + ` environment variable (inline JSON
+or a path to a secret file) or from stdin when the file is "-" or omitted. Token files written by this tool and gcloud
+authorized_user files are accepted; the latter also carry the OAuth client,
+so a credentials file is written for them if none exists yet.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credentialsFile, tokenFile, _, err := resolveScopedFiles(cmd)
+		if err != nil {
+			return err
+		}
+
+		data, source, err := readTokenInput(cmd, args)
+		if err != nil {
+			return err
+		}
 
+		if err := auth.ImportToken(data, credentialsFile, tokenFile); err != nil {
+			return fmt.Errorf("failed to import token: %w", err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"source":     source,
+			"token_file": tokenFile,
+		}).Info("Token imported")
+
+		if verify, _ := cmd.Flags().GetBool("verify"); verify {
+			authenticator, err := auth.NewAuthenticator(credentialsFile, tokenFile)
+			if err != nil {
+				return fmt.Errorf("failed to create authenticator: %w", err)
+			}
+			if err := authenticator.RefreshToken(); err != nil {
+				return fmt.Errorf("imported token could not be refreshed: %w", err)
+			}
+		}
+
+		fmt.Printf("Token imported to: %s\n", tokenFile)
 		return nil
 	},
 }
 
+// resolveScopedFiles returns the credentials file of the --account profile
+// and its token file for the --scope access level
+func resolveScopedFiles(cmd *cobra.Command) (credentialsFile, tokenFile string, access auth.Access, err error) {
+	credentialsFile, tokenFile, err = resolveAccountFiles(cmd)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	scope, _ := cmd.Flags().GetString("scope")
+	access, err = auth.ParseAccess(scope)
+	if err != nil {
+		return "", "", "", err
+	}
+	return credentialsFile, auth.TokenFileFor(tokenFile, access), access, nil
+}
+
+// elevateOnTerminal asks the user whether to log in for the access a
+// command needs, refusing when stdin is not interactive
+func elevateOnTerminal(access auth.Access, credentialsFile, tokenFile string) error {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("cannot ask for it on a non-interactive terminal; run 'gmail-exporter auth login --scope %s' first", access)
+	}
+	if err := confirmElevation(os.Stdin, os.Stdout, access); err != nil {
+		return err
+	}
+
+	authenticator, err := auth.NewScopedAuthenticator(credentialsFile, tokenFile, access)
+	if err != nil {
+		return fmt.Errorf("failed to create authenticator: %w", err)
+	}
+	return authenticator.Authenticate()
+}
+
+// readTokenInput reads token JSON for import-token and names where it came from
+func readTokenInput(cmd *cobra.Command, args []string) ([]byte, string, error) {
+	if len(args) == 1 && args[0] != "-" {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		return data, args[0], nil
+	}
+
+	if len(args) == 0 {
+		data, variable, err := auth.EnvTokenData()
+		if err != nil {
+			return nil, "", err
+		}
+		if data != nil {
+			return data, variable, nil
+		}
+	}
+
+	data, err := io.ReadAll(cmd.InOrStdin())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read token from stdin: %w", err)
+	}
+	return data, "stdin", nil
+}
+
 func init() {
+	// Commands needing more access than the tokens grant offer to log in
+	auth.Elevate = elevateOnTerminal
+
 	// Add subcommands
 	authCmd.AddCommand(authSetupCmd)
 	authCmd.AddCommand(authLoginCmd)
 	authCmd.AddCommand(authRefreshCmd)
 	authCmd.AddCommand(authStatusCmd)
+	authCmd.AddCommand(authImportTokenCmd)
+	authCmd.AddCommand(authDoctorCmd)
+
+	// Account profile and token used by login, refresh, status, doctor and
+	// import-token
+	authCmd.PersistentFlags().String("account", "", "Account profile from the accounts section of the config file")
+	authCmd.PersistentFlags().String("scope", string(auth.AccessFull), "Token access level (readonly, modify, full, settings); commands use the least privileged token that is enough")
+	authCmd.PersistentFlags().String("client", "", "Additional OAuth client from the oauth_clients section of the config file")
+
+	// Import-token command flags
+	authImportTokenCmd.Flags().Bool("verify", false, "Refresh the imported token once to check it works")
+
+	// Doctor command flags
+	authDoctorCmd.Flags().Bool("json", false, "Print the checks as JSON")
 
 	// Setup command flags
 	authSetupCmd.Flags().StringP("credentials-file", "c", "", "Path to credentials JSON file from Google Cloud Console")
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/backup"
+)
+
+// buildBackupConfig builds the restic or borg repository an export is backed
+// up to from the backup flags and config section, or nil when the export is
+// not backed up
+func buildBackupConfig(cmd *cobra.Command) (*backup.Config, error) {
+	config := &backup.Config{
+		Tool:       viper.GetString("backup.tool"),
+		Repository: viper.GetString("backup.repository"),
+		Tags:       viper.GetStringSlice("backup.tags"),
+	}
+	if tool, _ := cmd.Flags().GetString("backup-tool"); tool != "" {
+		config.Tool = tool
+	}
+	if repository, _ := cmd.Flags().GetString("backup-repo"); repository != "" {
+		config.Repository = repository
+	}
+	if tags, _ := cmd.Flags().GetStringSlice("backup-tag"); len(tags) > 0 {
+		config.Tags = tags
+	}
+
+	if config.Tool == "" {
+		if config.Repository != "" {
+			return nil, fmt.Errorf("--backup-repo requires --backup-tool")
+		}
+		return nil, nil
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// runBackup backs up the finished export in dir to the configured restic or
+// borg repository, if any. A failed backup fails the command so schedulers
+// notice that the mail did not reach the repository.
+func runBackup(cmd *cobra.Command, dir string) error {
+	config, err := buildBackupConfig(cmd)
+	if err != nil || config == nil {
+		return err
+	}
+
+	result, err := backup.Run(context.Background(), *config, dir, time.Now())
+	if err != nil {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("backup to %s failed: %w", config.Tool, err)
+	}
+
+	fmt.Printf("Backed up to %s %s: %s (%d files, %s added)\n",
+		result.Tool, result.Repository, result.Snapshot, result.Files, formatBytes(result.BytesAdded))
+	return nil
+}
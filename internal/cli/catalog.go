@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/catalog"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Browse an export by sender, date, label and size",
+	Long: `Build and query a catalog of the messages in an export directory. The catalog
+(catalog.db in the export directory) is built from the export's processed_emails.json
+and metadata cache, and from the headers of eml files they do not record, so listing
+an export does not open its files. The catalog is a SQLite database, so tools such
+as sqlite3 can query its messages table too.
+
+EXAMPLES:
+  gmail-exporter catalog build ./exports
+  gmail-exporter catalog list ./exports --from @example.com --after 2023-01-01
+  gmail-exporter catalog list ./exports --larger-than 10MB --sort size
+  gmail-exporter catalog list ./exports --group-by sender --limit 20`,
+}
+
+var catalogBuildCmd = &cobra.Command{
+	Use:   "build EXPORT-DIR",
+	Short: "Build or rebuild the catalog of an export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := catalog.Path(args[0])
+		count, err := catalog.Build(args[0], path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cataloged %d messages in %s\n", count, path)
+		return nil
+	},
+}
+
+var catalogListCmd = &cobra.Command{
+	Use:   "list EXPORT-DIR",
+	Short: "List the cataloged messages matching the flags",
+	Long: `List the messages of an export matching the flags, oldest first. The catalog is
+built first when the export has none; run 'catalog build' after the export changes.
+
+Text flags match case-insensitively anywhere in the header. With --group-by, the
+matching messages are counted by sender address, label or month instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query, err := buildCatalogQuery(cmd)
+		if err != nil {
+			return err
+		}
+
+		path := catalog.Path(args[0])
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			count, err := catalog.Build(args[0], path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Cataloged %d messages in %s\n", count, path)
+		}
+
+		cat, err := catalog.Open(path)
+		if err != nil {
+			return err
+		}
+		defer cat.Close()
+
+		entries, err := cat.Find(query)
+		if err != nil {
+			return err
+		}
+
+		asJSON, _ := cmd.Flags().GetBool("json")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		if groupBy, _ := cmd.Flags().GetString("group-by"); groupBy != "" {
+			groups, err := catalog.GroupBy(entries, groupBy)
+			if err != nil {
+				return err
+			}
+			if limit > 0 && len(groups) > limit {
+				groups = groups[:limit]
+			}
+			if asJSON {
+				return catalog.WriteJSON(os.Stdout, groups)
+			}
+			return catalog.WriteGroups(os.Stdout, groupBy, groups)
+		}
+
+		switch sortBy, _ := cmd.Flags().GetString("sort"); sortBy {
+		case "date":
+		case "size":
+			sort.SliceStable(entries, func(i, j int) bool { return entries[i].Size > entries[j].Size })
+		default:
+			return fmt.Errorf("invalid sort order: %s (valid: date, size)", sortBy)
+		}
+		if reverse, _ := cmd.Flags().GetBool("reverse"); reverse {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		if asJSON {
+			return catalog.WriteJSON(os.Stdout, entries)
+		}
+		return catalog.WriteTable(os.Stdout, entries)
+	},
+}
+
+func init() {
+	catalogCmd.AddCommand(catalogBuildCmd)
+	catalogCmd.AddCommand(catalogListCmd)
+
+	catalogListCmd.Flags().String("from", "", "Sender contains this text")
+	catalogListCmd.Flags().String("to", "", "Recipients contain this text")
+	catalogListCmd.Flags().String("subject", "", "Subject contains this text")
+	catalogListCmd.Flags().String("label", "", "Message has this label (ID, as recorded by the export)")
+	catalogListCmd.Flags().String("after", "", "Messages on or after this date (YYYY-MM-DD)")
+	catalogListCmd.Flags().String("before", "", "Messages before this date (YYYY-MM-DD)")
+	catalogListCmd.Flags().String("larger-than", "", "Messages larger than this (e.g. 5MB)")
+	catalogListCmd.Flags().String("smaller-than", "", "Messages smaller than this (e.g. 100KB)")
+	catalogListCmd.Flags().String("sort", "date", "Sort order (date, size); size lists the largest first")
+	catalogListCmd.Flags().Bool("reverse", false, "Reverse the sort order")
+	catalogListCmd.Flags().String("group-by", "", "Count the matching messages by "+strings.Join(catalog.Groupings, ", ")+" instead of listing them")
+	catalogListCmd.Flags().Int("limit", 0, "List at most this many messages or groups (0 = all)")
+	catalogListCmd.Flags().Bool("json", false, "Print the result as JSON")
+}
+
+// buildCatalogQuery builds a catalog query from the list flags
+func buildCatalogQuery(cmd *cobra.Command) (catalog.Query, error) {
+	var query catalog.Query
+	query.From, _ = cmd.Flags().GetString("from")
+	query.To, _ = cmd.Flags().GetString("to")
+	query.Subject, _ = cmd.Flags().GetString("subject")
+	query.Label, _ = cmd.Flags().GetString("label")
+
+	for flag, target := range map[string]*time.Time{"after": &query.After, "before": &query.Before} {
+		if value, _ := cmd.Flags().GetString(flag); value != "" {
+			date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return query, fmt.Errorf("invalid --%s date %q (expected YYYY-MM-DD)", flag, value)
+			}
+			*target = date
+		}
+	}
+
+	if larger, _ := cmd.Flags().GetString("larger-than"); larger != "" {
+		size, err := filters.ParseSize(larger)
+		if err != nil {
+			return query, fmt.Errorf("invalid --larger-than: %w", err)
+		}
+		query.MinSize = size + 1
+	}
+	if smaller, _ := cmd.Flags().GetString("smaller-than"); smaller != "" {
+		size, err := filters.ParseSize(smaller)
+		if err != nil {
+			return query, fmt.Errorf("invalid --smaller-than: %w", err)
+		}
+		query.MaxSize = size - 1
+	}
+	return query, nil
+}
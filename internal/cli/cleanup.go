@@ -12,12 +12,19 @@ import (
 
 var cleanupCmd = &cobra.Command{
 	Use:   "cleanup",
-	Short: "Archive or delete processed emails from source account",
-	Long: `Archive or delete emails that have been successfully exported/imported.
+	Short: "Archive, delete or label processed emails in the source account",
+	Long: `Archive, delete or label emails that have been successfully exported/imported.
 Use with caution when deleting emails.
 
+--action label adds the --add-labels and removes the --remove-labels, given by
+name or ID, from each email. Labels to add are created when missing; nested
+names such as "Exported/2024" are created under their parent labels.
+
 Use --limit to process only a specific number of messages, which is useful for testing
-the cleanup process with a small number of messages before running a full cleanup.`,
+the cleanup process with a small number of messages before running a full cleanup.
+
+Deleting requires typing a confirmation phrase such as "DELETE 42 MESSAGES" after
+reviewing a sample of the affected emails. Use --yes to skip the prompt in scripts.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Build cleanup configuration from flags
 		cleanupConfig, err := buildCleanupConfig(cmd)
@@ -39,10 +46,18 @@ the cleanup process with a small number of messages before running a full cleanu
 			"limit":       cleanupConfig.Limit,
 		}).Info("Starting email cleanup")
 
+		deadline := startDeadline(cmd, cl.Cancel)
+		defer deadline.stop()
+
 		result, err := cl.Cleanup()
 		if err != nil {
 			return fmt.Errorf("cleanup failed: %w", err)
 		}
+		recordResult(result)
+		if result.Cancelled && deadline.expired() {
+			fmt.Printf("Cleanup stopped after %d of %d emails\n", result.TotalProcessed, result.TotalFound)
+			return deadline.err(cmd, "cleanup", "run it again to process the remaining emails")
+		}
 
 		// Display results
 		if result.DryRun {
@@ -51,29 +66,43 @@ the cleanup process with a small number of messages before running a full cleanu
 			fmt.Printf("Cleanup completed successfully!\n")
 		}
 		fmt.Printf("Total emails found: %d\n", result.TotalFound)
-		fmt.Printf("Total emails %s: %d\n", result.Action+"d", result.TotalProcessed)
+		verb := result.Action + "d"
+		if result.Action == cleaner.ActionLabel {
+			verb = "labeled"
+		}
+		fmt.Printf("Total emails %s: %d\n", verb, result.TotalProcessed)
 		fmt.Printf("Action: %s\n", result.Action)
 		fmt.Printf("Duration: %s\n", result.Duration)
 
 		if result.TotalFailed > 0 {
-			fmt.Printf("Failed operations: %d (see log for details)\n", result.TotalFailed)
+			fmt.Printf("Failed operations: %d (%s; see log for details)\n",
+				result.TotalFailed, formatCategories(result.FailedByCategory))
 		}
 
-		return nil
+		return partialFailure(cmd, "cleanup operations", result.TotalFailed, result.FailedByCategory)
 	},
 }
 
 func init() {
-	cleanupCmd.Flags().String("action", "archive", "Action to perform (archive, delete)")
+	cleanupCmd.Flags().String("action", "archive", "Action to perform (archive, delete, label)")
+	cleanupCmd.Flags().StringSlice("add-labels", nil, "With --action label, labels to add (names or IDs; missing labels are created)")
+	cleanupCmd.Flags().StringSlice("remove-labels", nil, "With --action label, labels to remove (names or IDs, e.g. INBOX)")
 	cleanupCmd.Flags().String("filter-file", "", "File containing list of processed email IDs")
 	cleanupCmd.Flags().Bool("dry-run", false, "Show what would be done without actually doing it")
 	cleanupCmd.Flags().IntP("limit", "l", 0, "Limit the number of messages to process (0 = no limit, useful for testing)")
+	cleanupCmd.Flags().Bool("yes", false, "Skip the interactive confirmation for --action delete")
+	cleanupCmd.Flags().String("metadata-cache", "", "Metadata cache written by export (default: metadata.db next to the filter file)")
+	addTimeoutFlag(cleanupCmd)
 }
 
 func buildCleanupConfig(cmd *cobra.Command) (*cleaner.Config, error) {
 	config := &cleaner.Config{
 		CredentialsFile: viper.GetString("credentials_file"),
 		TokenFile:       viper.GetString("token_file"),
+		AuthMode:        viper.GetString("auth_mode"),
+		LegalHold:       viper.GetBool("legal_hold"),
+		AuditLog:        viper.GetString("audit_log"),
+		AuditSigningKey: viper.GetString("audit_signing_key"),
 	}
 
 	// Get flags
@@ -89,6 +118,18 @@ func buildCleanupConfig(cmd *cobra.Command) (*cleaner.Config, error) {
 	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
 		config.Limit = limit
 	}
+	if addLabels, _ := cmd.Flags().GetStringSlice("add-labels"); len(addLabels) > 0 {
+		config.AddLabels = addLabels
+	}
+	if removeLabels, _ := cmd.Flags().GetStringSlice("remove-labels"); len(removeLabels) > 0 {
+		config.RemoveLabels = removeLabels
+	}
+	if metadataCache, _ := cmd.Flags().GetString("metadata-cache"); metadataCache != "" {
+		config.MetadataCache = metadataCache
+	}
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		config.ConfirmDelete = stdinConfirmDeletion
+	}
 
 	// Validate required fields
 	if config.FilterFile == "" {
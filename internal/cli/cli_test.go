@@ -46,16 +46,30 @@ func TestExportCommandFlags(t *testing.T) {
 		"subject",
 		"includes-words",
 		"excludes-words",
+		"from-any",
+		"to-any",
+		"subject-any",
+		"any",
 		"size-greater-than",
 		"size-less-than",
 		"date-within",
+		"older-than",
 		"date-after",
 		"date-before",
+		"timezone",
 		"has-attachment",
 		"no-attachment",
 		"exclude-chats",
+		"filename",
+		"starred",
+		"unstarred",
+		"important",
+		"not-important",
+		"unread",
+		"read",
 		"labels",
 		"search-scope",
+		"category",
 		"output-dir",
 		"organize-by-labels",
 		"parallel-workers",
@@ -64,6 +78,8 @@ func TestExportCommandFlags(t *testing.T) {
 		"format",
 		"resume",
 		"state-file",
+		"max-qps",
+		"timeout",
 	}
 
 	for _, flagName := range expectedFlags {
@@ -81,6 +97,7 @@ func TestAuthCommandSubcommands(t *testing.T) {
 		"login",
 		"refresh",
 		"status",
+		"doctor",
 	}
 
 	for _, subcommandName := range expectedSubcommands {
@@ -106,6 +123,7 @@ func TestImportCommandFlags(t *testing.T) {
 		"parallel-workers",
 		"preserve-dates",
 		"limit",
+		"timeout",
 	}
 
 	for _, flagName := range expectedFlags {
@@ -129,6 +147,8 @@ func TestCleanupCommandFlags(t *testing.T) {
 		"filter-file",
 		"dry-run",
 		"limit",
+		"yes",
+		"timeout",
 	}
 
 	for _, flagName := range expectedFlags {
@@ -274,6 +294,32 @@ func TestBuildFilterConfig_InvalidDate(t *testing.T) {
 	}
 }
 
+func TestBuildFilterConfig_MessageState(t *testing.T) {
+	cmd := &cobra.Command{}
+	addFilterFlags(cmd)
+	cmd.Flags().Set("starred", "true")
+	cmd.Flags().Set("read", "true")
+
+	config, err := buildFilterConfig(cmd)
+	if err != nil {
+		t.Fatalf("buildFilterConfig failed: %v", err)
+	}
+	if config.IsStarred == nil || !*config.IsStarred {
+		t.Error("Expected IsStarred to be true")
+	}
+	if config.IsUnread == nil || *config.IsUnread {
+		t.Error("Expected IsUnread to be false")
+	}
+	if config.IsImportant != nil {
+		t.Errorf("Expected IsImportant to be unset, got %v", *config.IsImportant)
+	}
+
+	cmd.Flags().Set("unread", "true")
+	if _, err := buildFilterConfig(cmd); err == nil {
+		t.Error("Expected error for --unread with --read")
+	}
+}
+
 func TestFormatBytes(t *testing.T) {
 	tests := []struct {
 		name     string
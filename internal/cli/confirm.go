@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cleaner"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+// confirmSampleSize is the number of emails listed in the deletion prompt
+const confirmSampleSize = 10
+
+// deletionPhrase returns the phrase a user must type to confirm deleting count messages
+func deletionPhrase(count int) string {
+	return fmt.Sprintf("DELETE %d MESSAGES", count)
+}
+
+// confirmDeletion shows the emails about to be deleted and requires the user
+// to type the confirmation phrase. It returns an error if the user declines.
+func confirmDeletion(in io.Reader, out io.Writer, emails []cleaner.ProcessedEmail) error {
+	phrase := deletionPhrase(len(emails))
+
+	fmt.Fprintf(out, "\nWARNING: %d messages will be permanently deleted. This cannot be undone.\n\n", len(emails))
+
+	for i, email := range emails {
+		if i == confirmSampleSize {
+			fmt.Fprintf(out, "  ... and %d more\n", len(emails)-confirmSampleSize)
+			break
+		}
+
+		subject := email.Subject
+		if subject == "" {
+			subject = "(no subject)"
+		}
+		from := email.From
+		if from == "" {
+			from = "(unknown sender)"
+		}
+		fmt.Fprintf(out, "  %s  %-40s  %s\n", email.ID, truncate(from, 40), truncate(subject, 60))
+	}
+
+	fmt.Fprintf(out, "\nType %q to continue: ", phrase)
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	if strings.TrimSpace(answer) != phrase {
+		return fmt.Errorf("deletion not confirmed")
+	}
+
+	return nil
+}
+
+// stdinConfirmDeletion confirms deletion on the terminal, refusing to delete
+// when stdin is not interactive
+func stdinConfirmDeletion(emails []cleaner.ProcessedEmail) error {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("refusing to delete without confirmation on a non-interactive terminal (use --yes)")
+	}
+
+	return confirmDeletion(os.Stdin, os.Stdout, emails)
+}
+
+// confirmElevation asks the user to log in again for the access level a
+// command needs. It returns an error if the user declines.
+func confirmElevation(in io.Reader, out io.Writer, access auth.Access) error {
+	fmt.Fprintf(out, "\nThis command needs %s access to the mailbox, which none of your tokens grant.\n", access)
+	fmt.Fprintf(out, "Log in now to grant it (saved as a separate %s token)? [y/N]: ", access)
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return fmt.Errorf("declined to log in for %s access", access)
+	}
+}
+
+// applySpamTrash widens the search to spam and trash for
+// --include-spam-trash and, when the search covers them, warns that Gmail
+// purges them and asks for confirmation unless --yes or --triage is given
+func applySpamTrash(cmd *cobra.Command, filterConfig *filters.Config) error {
+	if include, _ := cmd.Flags().GetBool("include-spam-trash"); include {
+		if cmd.Flags().Changed("search-scope") && filterConfig.SearchScope != "all_mail" && filterConfig.SearchScope != filters.ScopeAnywhere {
+			return fmt.Errorf("--include-spam-trash cannot be combined with --search-scope %s", filterConfig.SearchScope)
+		}
+		filterConfig.SearchScope = filters.ScopeAnywhere
+	}
+	if !filterConfig.IncludesSpamTrash() {
+		return nil
+	}
+
+	yes, _ := cmd.Flags().GetBool("yes")
+	triage, _ := cmd.Flags().GetBool("triage")
+	if yes || triage {
+		fmt.Fprintln(os.Stderr, spamTrashWarning)
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("refusing to export spam or trash without confirmation on a non-interactive terminal (use --yes)")
+	}
+	return confirmSpamTrash(os.Stdin, os.Stdout)
+}
+
+// spamTrashWarning explains why spam and trash exports cannot wait
+const spamTrashWarning = "WARNING: Gmail permanently deletes messages 30 days after they enter spam or trash. " +
+	"Export them promptly; messages purged during the export are skipped as deleted."
+
+// confirmSpamTrash warns that spam and trash are purged and asks the user to
+// confirm exporting them. It returns an error if the user declines.
+func confirmSpamTrash(in io.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "\n%s\n", spamTrashWarning)
+	fmt.Fprint(out, "Export spam and trash? [y/N]: ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return fmt.Errorf("export of spam and trash not confirmed")
+	}
+}
+
+// truncate shortens s to at most n runes, marking truncation with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cleaner"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+func TestConfirmDeletion(t *testing.T) {
+	emails := make([]cleaner.ProcessedEmail, 12)
+	for i := range emails {
+		emails[i] = cleaner.ProcessedEmail{ID: fmt.Sprintf("18c1a2b3c4d5e6%02d", i), Subject: fmt.Sprintf("Subject %d", i)}
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"exact phrase", "DELETE 12 MESSAGES\n", false},
+		{"phrase without newline", "DELETE 12 MESSAGES", false},
+		{"wrong count", "DELETE 11 MESSAGES\n", true},
+		{"yes is not enough", "yes\n", true},
+		{"empty input", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := confirmDeletion(strings.NewReader(tt.input), &out, emails)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("confirmDeletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			prompt := out.String()
+			if !strings.Contains(prompt, "12 messages will be permanently deleted") {
+				t.Errorf("Expected count in prompt, got:\n%s", prompt)
+			}
+			if !strings.Contains(prompt, "Subject 0") || strings.Contains(prompt, "Subject 10") {
+				t.Errorf("Expected a sample of %d subjects, got:\n%s", confirmSampleSize, prompt)
+			}
+			if !strings.Contains(prompt, "... and 2 more") {
+				t.Errorf("Expected remaining count in prompt, got:\n%s", prompt)
+			}
+		})
+	}
+}
+
+func TestConfirmElevation(t *testing.T) {
+	tests := []struct {
+		answer  string
+		wantErr bool
+	}{
+		{"y\n", false},
+		{"Yes\n", false},
+		{"\n", true},
+		{"no\n", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := confirmElevation(strings.NewReader(tt.answer), &out, auth.AccessModify)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("confirmElevation(%q) error = %v, wantErr %v", tt.answer, err, tt.wantErr)
+		}
+		if !strings.Contains(out.String(), "modify access") {
+			t.Errorf("Expected the prompt to name the access level, got %q", out.String())
+		}
+	}
+}
+
+func TestConfirmSpamTrash(t *testing.T) {
+	var out bytes.Buffer
+	if err := confirmSpamTrash(strings.NewReader("y\n"), &out); err != nil {
+		t.Errorf("confirmSpamTrash() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "30 days") {
+		t.Errorf("Expected the prompt to warn about the 30-day purge, got %q", out.String())
+	}
+	if err := confirmSpamTrash(strings.NewReader("\n"), &out); err == nil {
+		t.Error("Expected an error when the user declines")
+	}
+}
+
+func TestApplySpamTrash(t *testing.T) {
+	newCmd := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("search-scope", "all_mail", "")
+		cmd.Flags().Bool("include-spam-trash", false, "")
+		cmd.Flags().Bool("yes", false, "")
+		cmd.Flags().Bool("triage", false, "")
+		if err := cmd.Flags().Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	config := &filters.Config{SearchScope: "all_mail"}
+	if err := applySpamTrash(newCmd("--include-spam-trash", "--yes"), config); err != nil {
+		t.Fatalf("applySpamTrash() error = %v", err)
+	}
+	if config.SearchScope != filters.ScopeAnywhere {
+		t.Errorf("Expected the anywhere scope, got %q", config.SearchScope)
+	}
+
+	config = &filters.Config{SearchScope: "inbox"}
+	if err := applySpamTrash(newCmd("--include-spam-trash", "--search-scope", "inbox", "--yes"), config); err == nil {
+		t.Error("Expected an error combining --include-spam-trash with another scope")
+	}
+
+	config = &filters.Config{SearchScope: "inbox"}
+	if err := applySpamTrash(newCmd("--search-scope", "inbox"), config); err != nil {
+		t.Errorf("Expected no confirmation outside spam and trash, got %v", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("Expected unchanged string, got %q", got)
+	}
+	if got := truncate("Renée's long subject", 6); got != "Renée…" {
+		t.Errorf("Expected truncated string, got %q", got)
+	}
+}
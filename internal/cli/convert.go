@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/converter"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert EXPORT-DIR",
+	Short: "Convert an existing export to another format without downloading it again",
+	Long: `Convert the eml files of an existing export directory to another format, offline.
+The Gmail API is not used, so a large export does not have to be downloaded again
+just to change its format.
+
+FORMATS:
+  mbox     One mbox file (messages.mbox) per export directory, with the read and
+           starred state in Status and X-Status headers
+  json     One file per message in the Gmail API representation of json exports,
+           including the raw message so that the import command accepts it
+  html     One page per message plus an index.html listing them, newest first;
+           HTML bodies are shown in a sandboxed frame so their scripts do not run
+  maildir  A maildir per export directory, with the seen and flagged state in
+           the file names
+
+Label directories of --organize-by-labels exports are kept. The labels, read and
+starred state recorded in processed_emails.json are carried over where the target
+format can hold them.
+
+EXAMPLES:
+  gmail-exporter convert ./exports --to mbox
+  gmail-exporter convert ./exports --to maildir --output-dir ~/Maildir/gmail`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := &converter.Config{InputDir: args[0]}
+		config.From, _ = cmd.Flags().GetString("from")
+		config.To, _ = cmd.Flags().GetString("to")
+		config.OutputDir, _ = cmd.Flags().GetString("output-dir")
+		if config.OutputDir == "" {
+			config.OutputDir = filepath.Clean(args[0]) + "-" + config.To
+		}
+
+		result, err := converter.Convert(config)
+		if err != nil {
+			return fmt.Errorf("conversion failed: %w", err)
+		}
+		recordResult(result)
+
+		logrus.WithFields(logrus.Fields{
+			"total_found":     result.TotalFound,
+			"total_converted": result.TotalConverted,
+			"total_failed":    result.TotalFailed,
+			"duration":        result.Duration,
+		}).Info("Conversion completed")
+
+		fmt.Printf("Conversion completed!\n")
+		fmt.Printf("Total messages found: %d\n", result.TotalFound)
+		fmt.Printf("Total messages converted: %d\n", result.TotalConverted)
+		fmt.Printf("Duration: %s\n", result.Duration)
+		fmt.Printf("Output directory: %s\n", config.OutputDir)
+
+		if result.TotalFailed > 0 {
+			fmt.Printf("Failed conversions: %d (%s; see log for details)\n",
+				result.TotalFailed, formatCategories(result.FailedByCategory))
+		}
+
+		return partialFailure(cmd, "conversions", result.TotalFailed, result.FailedByCategory)
+	},
+}
+
+func init() {
+	convertCmd.Flags().String("from", converter.FormatEML, "Format of the export to convert ("+strings.Join(converter.SourceFormats, ", ")+")")
+	convertCmd.Flags().String("to", "", "Format to convert to ("+strings.Join(converter.TargetFormats, ", ")+")")
+	convertCmd.Flags().StringP("output-dir", "o", "", "Directory for the converted export (default: EXPORT-DIR-FORMAT next to the export)")
+	if err := convertCmd.MarkFlagRequired("to"); err != nil {
+		logrus.WithError(err).Fatal("Failed to mark to flag as required")
+	}
+}
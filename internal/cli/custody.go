@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+)
+
+var custodyCmd = &cobra.Command{
+	Use:   "custody",
+	Short: "Inspect the chain of custody of legal hold exports",
+	Long:  `Commands for checking the signed custody manifest written by export --legal-hold.`,
+}
+
+var custodyVerifyCmd = &cobra.Command{
+	Use:   "verify EXPORT-DIR",
+	Short: "Verify the custody manifest signatures and export file checksums",
+	Long: `Verify that the custody manifest of a legal hold export was signed with the
+custody key, that its message records still form an unbroken hash chain, and that
+every export file it lists is present and unchanged.
+
+A manifest also signed with --custody-signing-key is checked against the Ed25519
+public key given with --public-key. Given the public key, the custody HMAC key is
+optional, so auditors can verify an export without holding the secret.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+
+		var publicKey ed25519.PublicKey
+		if publicKeyFile, _ := cmd.Flags().GetString("public-key"); publicKeyFile != "" {
+			var err error
+			if publicKey, err = signing.LoadPublicKey(publicKeyFile); err != nil {
+				return err
+			}
+		}
+		keyFile, _ := cmd.Flags().GetString("custody-key-file")
+		key, err := custody.LoadKey(keyFile)
+		if err != nil && publicKey == nil {
+			return err
+		}
+
+		manifest, err := custody.LoadManifest(filepath.Join(dir, custody.ManifestFileName))
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			if err := custody.Verify(manifest, key); err != nil {
+				return fmt.Errorf("custody manifest verification failed: %w", err)
+			}
+			fmt.Printf("Manifest signature: valid (%s)\n", manifest.SignatureAlgorithm)
+		} else {
+			fmt.Println("Manifest signature: not checked (no custody key)")
+		}
+		switch {
+		case publicKey != nil:
+			if err := custody.VerifyEd25519(manifest, publicKey); err != nil {
+				return fmt.Errorf("custody manifest verification failed: %w", err)
+			}
+			fmt.Printf("Ed25519 signature: valid (key %s)\n", signing.KeyID(publicKey))
+		case manifest.Ed25519Signature != "":
+			fmt.Printf("Ed25519 signature: not checked (key %s; pass --public-key)\n", manifest.Ed25519KeyID)
+		}
+		if err := manifest.VerifyChain(); err != nil {
+			return fmt.Errorf("custody manifest verification failed: %w", err)
+		}
+		if manifest.Chained() {
+			fmt.Println("Record chain: intact")
+		}
+
+		fmt.Printf("Account: %s\n", manifest.Account)
+		fmt.Printf("Operator: %s\n", manifest.Operator)
+		fmt.Printf("Query: %s\n", manifest.Query)
+		fmt.Printf("Exported: %s to %s by gmail-exporter %s\n",
+			manifest.StartedAt.Format("2006-01-02 15:04:05 MST"),
+			manifest.CompletedAt.Format("2006-01-02 15:04:05 MST"), manifest.ExporterVersion)
+
+		hold, err := custody.CheckHold(dir)
+		if err != nil {
+			return err
+		}
+		if hold != nil {
+			fmt.Printf("Legal hold: placed %s\n", hold.PlacedAt.Format("2006-01-02 15:04:05 MST"))
+		} else {
+			fmt.Println("Legal hold: not set")
+		}
+
+		mismatched := manifest.VerifyFiles(dir)
+		fmt.Printf("Messages: %d, verified: %d, missing or changed: %d\n",
+			len(manifest.Messages), len(manifest.Messages)-len(mismatched), len(mismatched))
+		for _, record := range mismatched {
+			fmt.Printf("! %s (%s)\n", record.ID, record.File)
+		}
+
+		if len(mismatched) > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d export files are missing or changed", len(mismatched))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	custodyVerifyCmd.Flags().String("custody-key-file", "", "File holding the custody manifest HMAC key (default: $GMAIL_EXPORTER_CUSTODY_KEY)")
+	custodyVerifyCmd.Flags().String("public-key", "", "Ed25519 public key (PEM) to check the manifest's public key signature with")
+
+	custodyCmd.AddCommand(custodyVerifyCmd)
+}
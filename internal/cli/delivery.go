@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/delivery"
+)
+
+var deliveryReportCmd = &cobra.Command{
+	Use:   "delivery-report EXPORT-DIR",
+	Short: "Report who sent mail was delivered to and which deliveries bounced",
+	Long: `Build a deliverability report from an eml export of sent mail: one row per
+recipient of each sent message, marked bounced or delayed when a delivery status
+notification (bounce) for it is found in the same export.
+
+Export the sent mail and the bounces into the same directory, for example with
+--search-scope sent and a second run with --from mailer-daemon. Pass --sender so only
+messages from your address are counted as sent; without it every message that is not
+a bounce is treated as sent mail.
+
+EXAMPLES:
+  gmail-exporter export --search-scope sent --output-dir ./sent-exports
+  gmail-exporter export --from mailer-daemon --output-dir ./sent-exports
+  gmail-exporter delivery-report ./sent-exports --sender support@example.com > delivery.csv
+  gmail-exporter delivery-report ./sent-exports --format json --output delivery.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "csv" && format != "json" {
+			return fmt.Errorf("invalid format: %s (valid: csv, json)", format)
+		}
+
+		sender, _ := cmd.Flags().GetString("sender")
+		report, err := delivery.Build(args[0], sender)
+		if err != nil {
+			return err
+		}
+		recordResult(report)
+
+		var out io.Writer = os.Stdout
+		output, _ := cmd.Flags().GetString("output")
+		if output != "" {
+			file, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create report file: %w", err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		if format == "json" {
+			err = report.WriteJSON(out)
+		} else {
+			err = report.WriteCSV(out)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write delivery report: %w", err)
+		}
+
+		// Keep stdout clean for the report itself
+		fmt.Fprintf(os.Stderr, "Sent messages: %d, recipients: %d (delivered: %d, delayed: %d, bounced: %d)\n",
+			report.Messages, len(report.Records), report.Sent, report.Delayed, report.Bounced)
+		if len(report.Unmatched) > 0 {
+			fmt.Fprintf(os.Stderr, "Bounces with no matching sent message: %d\n", len(report.Unmatched))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	deliveryReportCmd.Flags().String("sender", "", "Only count messages from this address as sent mail")
+	deliveryReportCmd.Flags().String("format", "csv", "Report format (csv, json)")
+	deliveryReportCmd.Flags().StringP("output", "o", "", "Write the report to this file instead of stdout")
+}
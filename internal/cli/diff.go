@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/differ"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff OLD-EXPORT [NEW-EXPORT]",
+	Short: "Compare two export runs, or an export with the live mailbox",
+	Long: `Compare two export directories, or an export directory with the live mailbox
+(--live), and report the messages added, removed, relabeled or changed.
+
+Messages and their checksums are read from each export's processed_emails.json, and
+labels from the same file or from the export's metadata cache. Content changes are
+only reported between exports of the same format, since the live mailbox has no
+export checksums. Use --output to save the delta as JSON, for example to verify a
+backup or detect tampering with a mailbox.
+
+EXAMPLES:
+  gmail-exporter diff ./exports-2024-01 ./exports-2024-02
+  gmail-exporter diff ./exports --live --query "to:me" --output delta.json --exit-code`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		live, _ := cmd.Flags().GetBool("live")
+		if live == (len(args) == 2) {
+			return fmt.Errorf("specify either a second export directory or --live")
+		}
+
+		older, err := differ.LoadExport(args[0])
+		if err != nil {
+			return err
+		}
+
+		var newer *differ.Snapshot
+		if live {
+			newer, err = differ.LoadLive(buildDiffLiveConfig(cmd))
+		} else {
+			newer, err = differ.LoadExport(args[1])
+		}
+		if err != nil {
+			return err
+		}
+
+		delta := differ.Compare(older, newer)
+		recordResult(delta)
+
+		if output, _ := cmd.Flags().GetString("output"); output != "" {
+			if err := delta.Save(output); err != nil {
+				return err
+			}
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(delta); err != nil {
+				return fmt.Errorf("failed to write delta: %w", err)
+			}
+		} else {
+			printDelta(delta)
+		}
+
+		if exitCode, _ := cmd.Flags().GetBool("exit-code"); exitCode && !delta.Empty() {
+			cmd.SilenceUsage = true
+			return &exitError{code: ExitDifferences, err: fmt.Errorf("snapshots differ")}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().Bool("live", false, "Compare the export with the live mailbox instead of another export")
+	diffCmd.Flags().String("query", "", "Gmail search query selecting the live messages to compare (with --live)")
+	diffCmd.Flags().Int("parallel-workers", 4, "Number of parallel label lookups (with --live)")
+	diffCmd.Flags().StringP("output", "o", "", "Write the delta as JSON to this file")
+	diffCmd.Flags().Bool("json", false, "Print the delta as JSON instead of a summary")
+	diffCmd.Flags().Bool("exit-code", false, "Exit with code 2 when the snapshots differ")
+}
+
+func buildDiffLiveConfig(cmd *cobra.Command) *differ.LiveConfig {
+	config := &differ.LiveConfig{
+		CredentialsFile: viper.GetString("credentials_file"),
+		TokenFile:       viper.GetString("token_file"),
+		AuthMode:        viper.GetString("auth_mode"),
+	}
+
+	if query, _ := cmd.Flags().GetString("query"); query != "" {
+		config.Query = query
+	}
+	if parallelWorkers, _ := cmd.Flags().GetInt("parallel-workers"); parallelWorkers > 0 {
+		config.ParallelWorkers = parallelWorkers
+	}
+
+	return config
+}
+
+// printDelta prints a summary of the delta with the affected message IDs
+func printDelta(delta *differ.Delta) {
+	fmt.Printf("Comparing %s with %s\n", delta.Old, delta.New)
+	fmt.Printf("Added: %d, removed: %d, relabeled: %d, content changed: %d, unchanged: %d\n",
+		len(delta.Added), len(delta.Removed), len(delta.LabelsChanged), len(delta.ContentChanged), delta.Unchanged)
+
+	for _, id := range delta.Added {
+		fmt.Printf("+ %s\n", id)
+	}
+	for _, id := range delta.Removed {
+		fmt.Printf("- %s\n", id)
+	}
+	for _, change := range delta.LabelsChanged {
+		var parts []string
+		for _, label := range change.Added {
+			parts = append(parts, "+"+label)
+		}
+		for _, label := range change.Removed {
+			parts = append(parts, "-"+label)
+		}
+		fmt.Printf("~ %s labels %s\n", change.ID, strings.Join(parts, " "))
+	}
+	for _, id := range delta.ContentChanged {
+		fmt.Printf("! %s content changed\n", id)
+	}
+}
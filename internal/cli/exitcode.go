@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+)
+
+// Process exit codes, so wrappers can tell partial failures and
+// authentication problems apart from other errors
+const (
+	ExitSuccess        = 0
+	ExitError          = 1
+	ExitDifferences    = 2 // diff --exit-code found differences
+	ExitPartialFailure = 3
+	ExitAuthError      = 4
+	ExitTimeout        = 5 // --timeout elapsed; progress was saved
+)
+
+// exitError carries a specific process exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+// Error implements the error interface
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// ExitCode returns the process exit code for an error returned by Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+
+	if failure.Categorize(err) == failure.Auth {
+		return ExitAuthError
+	}
+
+	return ExitError
+}
+
+// partialFailure returns an error carrying the partial failure exit code when
+// some messages failed, or nil if none did. Authentication failures take the
+// auth exit code since re-authenticating is the fix.
+func partialFailure(cmd *cobra.Command, operation string, failed int, byCategory map[string]int) error {
+	if failed == 0 {
+		return nil
+	}
+
+	// The command itself ran fine, so usage help is not useful here
+	cmd.SilenceUsage = true
+
+	code := ExitPartialFailure
+	if byCategory[string(failure.Auth)] > 0 {
+		code = ExitAuthError
+	}
+
+	return &exitError{
+		code: code,
+		err:  fmt.Errorf("%d %s failed (%s)", failed, operation, formatCategories(byCategory)),
+	}
+}
+
+// formatCategories renders failure counts by category, largest first
+func formatCategories(byCategory map[string]int) string {
+	categories := make([]string, 0, len(byCategory))
+	for category := range byCategory {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if byCategory[categories[i]] != byCategory[categories[j]] {
+			return byCategory[categories[i]] > byCategory[categories[j]]
+		}
+		return categories[i] < categories[j]
+	})
+
+	parts := make([]string, 0, len(categories))
+	for _, category := range categories {
+		parts = append(parts, fmt.Sprintf("%s: %d", category, byCategory[category]))
+	}
+
+	return strings.Join(parts, ", ")
+}
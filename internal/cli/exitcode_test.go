@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+)
+
+func TestExitCode(t *testing.T) {
+	cmd := &cobra.Command{}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitSuccess},
+		{"generic error", errors.New("boom"), ExitError},
+		{"auth error", fmt.Errorf("failed to create exporter: %w", auth.ErrNotAuthenticated), ExitAuthError},
+		{"partial failure", partialFailure(cmd, "exports", 2, map[string]int{"network": 2}), ExitPartialFailure},
+		{"partial auth failure", partialFailure(cmd, "exports", 3, map[string]int{"network": 2, "auth": 1}), ExitAuthError},
+		{"no failures", partialFailure(cmd, "exports", 0, nil), ExitSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCategories(t *testing.T) {
+	got := formatCategories(map[string]int{"network": 1, "rate_limit": 3, "disk": 1})
+	want := "rate_limit: 3, disk: 1, network: 1"
+	if got != want {
+		t.Errorf("formatCategories() = %q, want %q", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -10,6 +11,9 @@ import (
 
 	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
 	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
+	"github.com/octasoft-ltd/gmail-exporter/internal/simulate"
+	"github.com/octasoft-ltd/gmail-exporter/internal/triage"
 )
 
 var exportCmd = &cobra.Command{
@@ -18,11 +22,36 @@ var exportCmd = &cobra.Command{
 	Long: `Export emails from Gmail based on specified filters.
 Supports all Gmail search operators and additional filtering options.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		// Fill in the flags of the preset not given on the command line
+		if name, _ := cmd.Flags().GetString("preset"); name != "" {
+			preset, err := findPreset(name)
+			if err != nil {
+				return err
+			}
+			if err := preset.apply(cmd.Flags()); err != nil {
+				return err
+			}
+		}
+
+		// Build the filter with the wizard, leaving the export to the user
+		if interactive, _ := cmd.Flags().GetBool("interactive"); interactive {
+			proceed, err := runFilterWizard(cmd)
+			if err != nil {
+				return err
+			}
+			if !proceed {
+				return nil
+			}
+		}
+
 		// Build filter configuration from flags
 		filterConfig, err := buildFilterConfig(cmd)
 		if err != nil {
 			return fmt.Errorf("failed to build filter config: %w", err)
 		}
+		if err := applySpamTrash(cmd, filterConfig); err != nil {
+			return err
+		}
 
 		// Build export configuration
 		exportConfig, err := buildExportConfig(cmd)
@@ -30,11 +59,53 @@ Supports all Gmail search operators and additional filtering options.`,
 			return fmt.Errorf("failed to build export config: %w", err)
 		}
 
+		// Check the backup repository settings before spending hours exporting
+		if _, err := buildBackupConfig(cmd); err != nil {
+			return fmt.Errorf("invalid backup configuration: %w", err)
+		}
+
+		// Write the export into a new snapshot of the backup
+		if snapshotMode, _ := cmd.Flags().GetBool("snapshot"); snapshotMode {
+			if err := startSnapshot(exportConfig, time.Now()); err != nil {
+				return fmt.Errorf("failed to start snapshot: %w", err)
+			}
+		}
+
+		// Pause and resume the exporters on SIGUSR1, and stop them cleanly on
+		// Ctrl+C or SIGTERM
+		stopPause := notifyPause(exportPauses)
+		defer stopPause()
+		stopSignals := notifyStop(exportPauses)
+		defer stopSignals()
+
+		// Stop them the same way once --timeout elapses
+		deadline := startDeadline(cmd, exportPauses.stop)
+		defer deadline.stop()
+
+		// Export every Workspace user into per-user subdirectories
+		targets, err := workspaceTargets(cmd, exportConfig)
+		if err != nil {
+			return fmt.Errorf("failed to resolve Workspace users: %w", err)
+		}
+		if targets != nil {
+			return exportTimedOut(cmd, deadline, runAccountsExport(cmd, targets, exportConfig.OutputDir, filterConfig))
+		}
+
+		// Export several account profiles into per-account subdirectories
+		if accounts, _ := cmd.Flags().GetStringSlice("accounts"); len(accounts) > 0 {
+			profiles, err := loadAccountProfiles(accounts)
+			if err != nil {
+				return fmt.Errorf("failed to load account profiles: %w", err)
+			}
+			return exportTimedOut(cmd, deadline, runAccountsExport(cmd, accountTargets(exportConfig, profiles), exportConfig.OutputDir, filterConfig))
+		}
+
 		// Create exporter
 		exp, err := exporter.New(exportConfig)
 		if err != nil {
 			return fmt.Errorf("failed to create exporter: %w", err)
 		}
+		exportPauses.add(exp)
 
 		// Run export
 		logrus.WithFields(logrus.Fields{
@@ -46,51 +117,170 @@ Supports all Gmail search operators and additional filtering options.`,
 		if err != nil {
 			return fmt.Errorf("export failed: %w", err)
 		}
+		recordResult(result)
+
+		if result.Cancelled {
+			cmd.SilenceUsage = true
+			fmt.Printf("Export interrupted after %d emails; progress saved in %s\n", result.TotalExported, exportConfig.OutputDir)
+			return exportTimedOut(cmd, deadline, errExportInterrupted)
+		}
 
 		// Display results
-		fmt.Printf("Export completed successfully!\n")
+		if result.BudgetReached != "" {
+			fmt.Printf("Export stopped at its %s budget; progress saved, run it again with --resume to continue\n", result.BudgetReached)
+		} else {
+			fmt.Printf("Export completed successfully!\n")
+		}
 		fmt.Printf("Total emails matched: %d\n", result.TotalMatched)
 		fmt.Printf("Total emails exported: %d\n", result.TotalExported)
+		if exportConfig.LinkDest != "" {
+			fmt.Printf("Linked from the previous snapshot: %d\n", result.TotalLinked)
+		}
+		if result.BudgetReached != "" {
+			fmt.Printf("Remaining emails: %d\n", result.TotalRemaining)
+		}
 		fmt.Printf("Total size: %s\n", formatBytes(result.TotalSize))
 		fmt.Printf("Duration: %s\n", result.Duration)
 		fmt.Printf("Output directory: %s\n", exportConfig.OutputDir)
+		if exportConfig.QuotaBudget > 0 {
+			fmt.Printf("Quota units used: %d (daily budget %d)\n", result.QuotaUnits, exportConfig.QuotaBudget)
+		} else {
+			fmt.Printf("Quota units used: %d\n", result.QuotaUnits)
+		}
+
+		if exportConfig.Format == exporter.FormatMetadata {
+			fmt.Printf("Message metadata: %s\n", filepath.Join(exportConfig.OutputDir, exporter.MetadataFileName+"."+exportConfig.MetadataFormat))
+		}
+		if exportConfig.Triage {
+			fmt.Printf("Triage report: %s\n", filepath.Join(exportConfig.OutputDir, triage.ReportFileName))
+		}
+		if exportConfig.ThunderbirdDir != "" {
+			fmt.Printf("Thunderbird folders: %s\n", exportConfig.ThunderbirdDir)
+		}
 
 		if result.TotalFailed > 0 {
-			fmt.Printf("Failed exports: %d (see log for details)\n", result.TotalFailed)
+			fmt.Printf("Failed exports: %d (%s; see log for details)\n",
+				result.TotalFailed, formatCategories(result.FailedByCategory))
+		}
+		if count := result.SkippedByReason[exporter.SkipReasonSize]; count > 0 {
+			fmt.Printf("Skipped (outside exact size bounds): %d\n", count)
+		}
+		if count := result.SkippedByReason[exporter.SkipReasonLabelFilter]; count > 0 {
+			fmt.Printf("Skipped (excluded by label filters): %d\n", count)
+		}
+		if count := result.SkippedByReason[exporter.SkipReasonLabelQuota]; count > 0 {
+			fmt.Printf("Skipped (per-label cap reached): %d\n", count)
+		}
+		if count := result.SkippedByReason[exporter.SkipReasonTooLarge]; count > 0 {
+			fmt.Printf("Skipped (larger than --skip-larger-than): %d (listed in skipped.json)\n", count)
+		}
+		if result.TotalDeleted > 0 {
+			fmt.Printf("Skipped (deleted since the search): %d\n", result.TotalDeleted)
+		}
+		if result.SearchRefreshes > 0 {
+			fmt.Printf("Searched again for missed emails: %d times\n", result.SearchRefreshes)
+		}
+		if exportConfig.NotmuchTags {
+			fmt.Printf("Notmuch tags: %s\n", filepath.Join(exportConfig.OutputDir, exporter.NotmuchTagsFileName))
+		}
+		if result.HookFailures > 0 {
+			fmt.Printf("Hook failures: %d (see log for details)\n", result.HookFailures)
+		}
+
+		if err := runBackup(cmd, exportConfig.OutputDir); err != nil {
+			return err
+		}
+		if err := runPostHook(cmd, hookResult{Dir: exportConfig.OutputDir, Exported: result.TotalExported, Failed: result.TotalFailed}); err != nil {
+			return err
 		}
 
-		return nil
+		return partialFailure(cmd, "exports", result.TotalFailed, result.FailedByCategory)
 	},
 }
 
 func init() {
-	// Filter flags
-	exportCmd.Flags().String("to", "", "Recipient email address")
-	exportCmd.Flags().String("from", "", "Sender email address")
-	exportCmd.Flags().String("subject", "", "Subject contains text")
-	exportCmd.Flags().String("includes-words", "", "Email body contains words (space-separated)")
-	exportCmd.Flags().String("excludes-words", "", "Email body excludes words (space-separated)")
-	exportCmd.Flags().String("size-greater-than", "", "Email size greater than (e.g., 5MB)")
-	exportCmd.Flags().String("size-less-than", "", "Email size less than (e.g., 10MB)")
-	exportCmd.Flags().String("date-within", "", "Date within period (e.g., 30d, 1w, 6m)")
-	exportCmd.Flags().String("date-after", "", "After specific date (YYYY-MM-DD)")
-	exportCmd.Flags().String("date-before", "", "Before specific date (YYYY-MM-DD)")
-	exportCmd.Flags().Bool("has-attachment", false, "Has attachments")
-	exportCmd.Flags().Bool("no-attachment", false, "No attachments")
-	exportCmd.Flags().Bool("exclude-chats", true, "Exclude chat messages")
-	exportCmd.Flags().String("labels", "", "Specific labels (comma-separated)")
-	exportCmd.Flags().String("search-scope", "all_mail", "Search scope (all_mail, inbox, sent, drafts, spam, trash)")
+	addFilterFlags(exportCmd)
+	exportCmd.Flags().Bool("exact-size", false, "Enforce size filters exactly on the downloaded message size (skipping messages outside the bounds)")
 
 	// Export configuration flags
+	exportCmd.Flags().Bool("interactive", false, "Build the filter with a wizard showing how many messages match after each answer, then print it as flags and a preset")
+	exportCmd.Flags().String("preset", "", "Apply a named preset of export flags from the config file (see 'preset list')")
 	exportCmd.Flags().StringP("output-dir", "o", "", "Output directory for exported emails")
 	exportCmd.Flags().Bool("organize-by-labels", false, "Organize exported emails by labels in folder structure")
-	exportCmd.Flags().Int("parallel-workers", 0, "Number of parallel workers (0 = use config default)")
+	exportCmd.Flags().StringSlice("only-labels", nil, "With --organize-by-labels, export only messages with these labels (names or IDs)")
+	exportCmd.Flags().StringSlice("skip-labels", nil, "With --organize-by-labels, leave out these labels (names or IDs, e.g. CATEGORY_PROMOTIONS)")
+	exportCmd.Flags().Int("max-per-label", 0, "With --organize-by-labels, export at most this many messages per label (0 = no cap)")
+	exportCmd.Flags().Bool("triage", false, "Spam and phishing triage: export the spam folder (unless --search-scope is set) and write triage_report.json with SPF/DKIM/DMARC results, sender IPs and URLs")
+	exportCmd.Flags().Bool("legal-hold", false, "Record a signed chain-of-custody manifest and place a legal hold that blocks cleanup")
+	exportCmd.Flags().String("operator", "", "Operator identity recorded in the custody manifest (default: local user name)")
+	exportCmd.Flags().String("custody-key-file", "", "File holding the custody manifest HMAC key (default: $GMAIL_EXPORTER_CUSTODY_KEY)")
+	exportCmd.Flags().String("custody-signing-key", "", "Ed25519 private key (PEM) also signing the custody manifest, checkable without the HMAC key")
+	exportCmd.Flags().Bool("label-dir-names", false, "With --organize-by-labels, name label directories after label names instead of IDs")
+	exportCmd.Flags().String("transliterate", "", "With --label-dir-names, how to write non-ASCII label names (none, ascii) [default: none]")
+	exportCmd.Flags().Int("max-path-length", 0, "Longest output path in characters; label directory names are shortened to fit (0 = 259 on Windows, otherwise no limit)")
+	exportCmd.Flags().String("label-strategy", "", "With --organize-by-labels, how to store messages with several labels (first, copy, hardlink, index) [default: first]")
+	exportCmd.Flags().Int("parallel-workers", 0, "Number of parallel workers (0 = auto, based on CPUs, API latency and --max-qps)")
+	exportCmd.Flags().Bool("adaptive-workers", false, "Ramp concurrency up until Gmail reports quota errors, then back off (--parallel-workers caps it)")
+	exportCmd.Flags().String("run-window", "", "Only export messages within this daily local time range, e.g. 22:00-06:00, sleeping outside it")
+	exportCmd.Flags().Int("quota-budget", 0, "Gmail API quota units the export may use per day; API calls pause once used up (0 = no budget)")
+	exportCmd.Flags().Float64("max-qps", 0, "Maximum Gmail API calls per second (0 = no client-side cap)")
 	exportCmd.Flags().Bool("include-attachments", true, "Include email attachments in export")
 	exportCmd.Flags().Bool("compress-exports", false, "Compress exported emails")
-	exportCmd.Flags().String("format", "eml", "Export format (eml, mbox, json)")
+	exportCmd.Flags().String("format", "eml", "Export format (eml, mbox, json, txt, metadata); metadata fetches only headers, labels and sizes into one file")
+	exportCmd.Flags().String("metadata-format", "", "With --format metadata, the file format of messages.json or messages.csv (json, csv) [default: json]")
+	exportCmd.Flags().StringSlice("headers", nil, "With --format metadata, the only headers to fetch; headers beyond Date, From, To, Cc, Subject and Message-ID become extra fields")
+	exportCmd.Flags().String("default-charset", "", "Charset of unlabeled non-UTF-8 text when transcoding json and txt exports, e.g. koi8-r or shift_jis [default: windows-1252]")
 	exportCmd.Flags().Bool("resume", false, "Resume a previous export")
-	exportCmd.Flags().String("state-file", "", "State file for resumable operations")
+	exportCmd.Flags().Bool("snapshot", false, "Write the export to a new timestamped snapshot under --output-dir, hardlinking the messages of the previous snapshot instead of downloading them again")
+	exportCmd.Flags().String("skip-larger-than", "", "Skip messages larger than this (e.g. 35MB), listing them in skipped.json")
+	exportCmd.Flags().String("max-in-memory-size", "", "Stream eml/mbox messages larger than this to disk instead of decoding them in memory (e.g. 8MB) [default: 16MB]")
+	exportCmd.Flags().Bool("run-history", false, "Append a summary of the run to runs.jsonl in the output directory (see 'metrics report')")
+	exportCmd.Flags().Bool("fsync", false, "Sync each exported file and its directory to disk before recording it as exported")
+	exportCmd.Flags().Bool("gmail-labels-header", false, "Add Google Takeout's X-Gmail-Labels header, with the labels and read/starred/important state, to eml and mbox exports")
+	exportCmd.Flags().String("thunderbird-dir", "", "Also write the export as a Thunderbird Local Folders mbox hierarchy in this directory, one folder per label")
+	exportCmd.Flags().Bool("notmuch-tags", false, "Write notmuch_tags.txt, a 'notmuch tag --batch' file tagging each message with its Gmail labels")
+	exportCmd.Flags().String("backup-tool", "", "Back up the finished export to a repository of this tool (restic, borg)")
+	exportCmd.Flags().String("backup-repo", "", "Restic or borg repository to back up to (default: $RESTIC_REPOSITORY or $BORG_REPO)")
+	exportCmd.Flags().StringSlice("backup-tag", nil, "Tags of the restic snapshot; for borg, the first tag prefixes the archive name [default: gmail-exporter]")
+	exportCmd.Flags().String("post-hook", "", "Shell command to run in the output directory after the export, e.g. \"notmuch new\"")
+	exportCmd.Flags().String("state-file", "", "State file for resumable operations (default: output-dir/.export_state.json)")
+	exportCmd.Flags().String("metadata-cache", "", "Message metadata cache path (default: output-dir/metadata.db)")
+	exportCmd.Flags().StringSlice("redact", nil, "Redact PII from exported content (emails, phones, credit-cards); json, txt and metadata formats only")
+	exportCmd.Flags().StringArray("redact-pattern", nil, "Custom regular expression to redact (repeatable)")
+	exportCmd.Flags().StringSlice("accounts", nil, "Export several account profiles from the config file (e.g. work,personal)")
+	exportCmd.Flags().Int("parallel-accounts", 1, "Number of accounts to export at once with --accounts")
+	exportCmd.Flags().Bool("all-users", false, "Export every active user in a Google Workspace domain (requires domain-wide delegation)")
+	exportCmd.Flags().String("users-file", "", "Export the Workspace users listed in this file, one email per line")
+	exportCmd.Flags().String("service-account-key", "", "Service account JSON key with domain-wide delegation")
+	exportCmd.Flags().String("admin-email", "", "Workspace admin to impersonate when listing users")
+	exportCmd.Flags().String("domain", "", "Workspace domain to list users from (default: all domains of the admin's organization)")
+	exportCmd.Flags().Bool("include-spam-trash", false, "Also export spam and trash, which Gmail purges after 30 days (same as --search-scope anywhere)")
+	exportCmd.Flags().Bool("yes", false, "Skip the interactive confirmation for exporting spam or trash")
+	exportCmd.Flags().String("split-by", "", "Split the export into date windows (day, week, month, year) for large mailboxes")
+	exportCmd.Flags().String("list-shards", "", "List message IDs as concurrent date shards (day, week, month, year) for large mailboxes")
+	exportCmd.Flags().Int("list-concurrency", 0, "Number of shards to list at once with --list-shards (0 = 4)")
+	exportCmd.Flags().Float64("refresh-search-at", 0, "Search again for missed emails when more than this fraction (0-1) of them were deleted during the export (0 = never)")
 	exportCmd.Flags().IntP("limit", "l", 0, "Limit the number of messages to process (0 = no limit, useful for testing)")
+	exportCmd.Flags().String("max-total-size", "", "Stop cleanly, saving progress, once this much has been exported (e.g. 10GB); continue with --resume")
+	exportCmd.Flags().Duration("max-duration", 0, "Stop cleanly, saving progress, after this long (e.g. 2h); continue with --resume")
+	exportCmd.Flags().String("order", "", "Export order (newest-first, oldest-first, largest-first) so an interrupted run has done what matters most (default: newest first)")
+	exportCmd.Flags().Int("sample", 0, "Export this many matching messages picked at random instead of the newest (0 = no sample)")
+	exportCmd.Flags().Int("checkpoint-every", 0, "Flush metrics and the processed emails file every N messages (0 = use config default)")
+	exportCmd.Flags().Duration("checkpoint-interval", 0, "Flush metrics and the processed emails file at least this often (0 = use config default)")
+	addTimeoutFlag(exportCmd)
+
+	// Simulated exports, for testing the automation around exports
+	exportCmd.Flags().Bool("simulate", false, "Export a fabricated mailbox with injected API failures instead of calling the Gmail API")
+	exportCmd.Flags().Int("simulate-messages", simulate.DefaultMessages, "Number of messages in the simulated mailbox")
+	exportCmd.Flags().Float64("simulate-failure-rate", simulate.DefaultFailureRate, "Fraction (0-1) of simulated messages whose API calls fail")
+	exportCmd.Flags().StringSlice("simulate-failures", nil, "Failures injected (rate_limit, server, not_found) (default: all)")
+	exportCmd.Flags().Uint64("simulate-seed", 0, "Seed of the simulated mailbox and its failures, for repeatable runs")
+	exportCmd.Flags().Duration("simulate-latency", 0, "Latency added to every simulated API call")
+	for _, name := range []string{"simulate", "simulate-messages", "simulate-failure-rate", "simulate-failures", "simulate-seed", "simulate-latency"} {
+		if err := exportCmd.Flags().MarkHidden(name); err != nil {
+			logrus.WithError(err).Fatalf("Failed to hide %s flag", name)
+		}
+	}
 
 	// Bind flags to viper
 	if err := viper.BindPFlag("output_dir", exportCmd.Flags().Lookup("output-dir")); err != nil {
@@ -102,6 +292,78 @@ func init() {
 	if err := viper.BindPFlag("parallel_workers", exportCmd.Flags().Lookup("parallel-workers")); err != nil {
 		logrus.WithError(err).Fatal("Failed to bind parallel-workers flag")
 	}
+	if err := viper.BindPFlag("adaptive_workers", exportCmd.Flags().Lookup("adaptive-workers")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind adaptive-workers flag")
+	}
+	if err := viper.BindPFlag("skip_larger_than", exportCmd.Flags().Lookup("skip-larger-than")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind skip-larger-than flag")
+	}
+	if err := viper.BindPFlag("max_in_memory_size", exportCmd.Flags().Lookup("max-in-memory-size")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind max-in-memory-size flag")
+	}
+	if err := viper.BindPFlag("default_charset", exportCmd.Flags().Lookup("default-charset")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind default-charset flag")
+	}
+	if err := viper.BindPFlag("run_window", exportCmd.Flags().Lookup("run-window")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind run-window flag")
+	}
+	if err := viper.BindPFlag("metrics.run_history", exportCmd.Flags().Lookup("run-history")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind run-history flag")
+	}
+	if err := viper.BindPFlag("quota_budget", exportCmd.Flags().Lookup("quota-budget")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind quota-budget flag")
+	}
+	if err := viper.BindPFlag("max_qps", exportCmd.Flags().Lookup("max-qps")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind max-qps flag")
+	}
+	if err := viper.BindPFlag("gmail_labels_header", exportCmd.Flags().Lookup("gmail-labels-header")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind gmail-labels-header flag")
+	}
+	if err := viper.BindPFlag("backup.tool", exportCmd.Flags().Lookup("backup-tool")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind backup-tool flag")
+	}
+	if err := viper.BindPFlag("backup.repository", exportCmd.Flags().Lookup("backup-repo")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind backup-repo flag")
+	}
+	if err := viper.BindPFlag("checkpoint_every", exportCmd.Flags().Lookup("checkpoint-every")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind checkpoint-every flag")
+	}
+	if err := viper.BindPFlag("checkpoint_interval", exportCmd.Flags().Lookup("checkpoint-interval")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind checkpoint-interval flag")
+	}
+}
+
+// addFilterFlags adds the message filter flags read by buildFilterConfig
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().String("to", "", "Recipient email address")
+	cmd.Flags().String("from", "", "Sender email address")
+	cmd.Flags().String("subject", "", "Subject contains text")
+	cmd.Flags().String("includes-words", "", "Email body contains words (space-separated)")
+	cmd.Flags().String("excludes-words", "", "Email body excludes words (space-separated)")
+	cmd.Flags().StringArray("from-any", nil, "Sender, any of which must match (repeatable)")
+	cmd.Flags().StringArray("to-any", nil, "Recipient, any of which must match (repeatable)")
+	cmd.Flags().StringArray("subject-any", nil, "Subject text, any of which must match (repeatable)")
+	cmd.Flags().StringArray("any", nil, "Gmail search expression, any of which must match, e.g. \"from:boss has:attachment\" (repeatable)")
+	cmd.Flags().String("size-greater-than", "", "Email size greater than (e.g., 5MB)")
+	cmd.Flags().String("size-less-than", "", "Email size less than (e.g., 10MB)")
+	cmd.Flags().String("date-within", "", "Date within period (e.g., 30d, 1w, 6m)")
+	cmd.Flags().String("older-than", "", "Older than period (e.g., 2y, 6m)")
+	cmd.Flags().String("date-after", "", "After specific date (YYYY-MM-DD)")
+	cmd.Flags().String("date-before", "", "Before specific date (YYYY-MM-DD)")
+	cmd.Flags().String("timezone", "", "Time zone of --date-after and --date-before (e.g. Europe/London, Local); Gmail uses Pacific time otherwise")
+	cmd.Flags().Bool("has-attachment", false, "Has attachments")
+	cmd.Flags().Bool("no-attachment", false, "No attachments")
+	cmd.Flags().Bool("exclude-chats", true, "Exclude chat messages")
+	cmd.Flags().String("filename", "", "Attachment names or extensions, any of which must match (comma-separated, e.g. pdf,xlsx)")
+	cmd.Flags().Bool("starred", false, "Starred messages only")
+	cmd.Flags().Bool("unstarred", false, "Messages that are not starred")
+	cmd.Flags().Bool("important", false, "Messages marked important only")
+	cmd.Flags().Bool("not-important", false, "Messages not marked important")
+	cmd.Flags().Bool("unread", false, "Unread messages only")
+	cmd.Flags().Bool("read", false, "Read messages only")
+	cmd.Flags().String("labels", "", "Specific labels (comma-separated)")
+	cmd.Flags().String("search-scope", "all_mail", "Search scope (all_mail, inbox, sent, drafts, spam, trash, anywhere = all mail with spam and trash)")
+	cmd.Flags().String("category", "", "Inbox category tab (primary, social, promotions, updates, forums)")
 }
 
 func buildFilterConfig(cmd *cobra.Command) (*filters.Config, error) {
@@ -124,6 +386,20 @@ func buildFilterConfig(cmd *cobra.Command) (*filters.Config, error) {
 		config.ExcludesWords = excludes
 	}
 
+	// OR groups
+	if fromAny, _ := cmd.Flags().GetStringArray("from-any"); len(fromAny) > 0 {
+		config.FromAny = fromAny
+	}
+	if toAny, _ := cmd.Flags().GetStringArray("to-any"); len(toAny) > 0 {
+		config.ToAny = toAny
+	}
+	if subjectAny, _ := cmd.Flags().GetStringArray("subject-any"); len(subjectAny) > 0 {
+		config.SubjectAny = subjectAny
+	}
+	if anyOf, _ := cmd.Flags().GetStringArray("any"); len(anyOf) > 0 {
+		config.AnyOf = anyOf
+	}
+
 	// Size filters
 	if sizeGreater, _ := cmd.Flags().GetString("size-greater-than"); sizeGreater != "" {
 		size, err := filters.ParseSize(sizeGreater)
@@ -139,6 +415,9 @@ func buildFilterConfig(cmd *cobra.Command) (*filters.Config, error) {
 		}
 		config.SizeLessThan = size
 	}
+	if exactSize, _ := cmd.Flags().GetBool("exact-size"); exactSize {
+		config.ExactSize = exactSize
+	}
 
 	// Date filters
 	if dateWithin, _ := cmd.Flags().GetString("date-within"); dateWithin != "" {
@@ -148,6 +427,13 @@ func buildFilterConfig(cmd *cobra.Command) (*filters.Config, error) {
 		}
 		config.DateWithin = duration
 	}
+	if olderThan, _ := cmd.Flags().GetString("older-than"); olderThan != "" {
+		duration, err := filters.ParseDuration(olderThan)
+		if err != nil {
+			return nil, fmt.Errorf("invalid older-than: %w", err)
+		}
+		config.OlderThan = duration
+	}
 	if dateAfter, _ := cmd.Flags().GetString("date-after"); dateAfter != "" {
 		date, err := time.Parse("2006-01-02", dateAfter)
 		if err != nil {
@@ -162,6 +448,9 @@ func buildFilterConfig(cmd *cobra.Command) (*filters.Config, error) {
 		}
 		config.DateBefore = &date
 	}
+	if timezone, _ := cmd.Flags().GetString("timezone"); timezone != "" {
+		config.Timezone = timezone
+	}
 
 	// Boolean filters
 	if hasAttachment, _ := cmd.Flags().GetBool("has-attachment"); hasAttachment {
@@ -174,14 +463,44 @@ func buildFilterConfig(cmd *cobra.Command) (*filters.Config, error) {
 	if excludeChats, _ := cmd.Flags().GetBool("exclude-chats"); excludeChats {
 		config.ExcludeChats = excludeChats
 	}
+	if filename, _ := cmd.Flags().GetString("filename"); filename != "" {
+		config.Filenames = filename
+	}
 
-	// Labels and search scope
+	// Message state filters, each set by a pair of flags
+	for _, state := range []struct {
+		yes, no string
+		value   **bool
+	}{
+		{"starred", "unstarred", &config.IsStarred},
+		{"important", "not-important", &config.IsImportant},
+		{"unread", "read", &config.IsUnread},
+	} {
+		yes, _ := cmd.Flags().GetBool(state.yes)
+		no, _ := cmd.Flags().GetBool(state.no)
+		if yes && no {
+			return nil, fmt.Errorf("--%s and --%s cannot be combined", state.yes, state.no)
+		}
+		if yes || no {
+			*state.value = &yes
+		}
+	}
+
+	// Labels, search scope and category tab
 	if labels, _ := cmd.Flags().GetString("labels"); labels != "" {
 		config.Labels = labels
 	}
 	if searchScope, _ := cmd.Flags().GetString("search-scope"); searchScope != "" {
 		config.SearchScope = searchScope
 	}
+	if category, _ := cmd.Flags().GetString("category"); category != "" {
+		config.Category = category
+	}
+
+	// Triage reviews the spam folder unless another scope is chosen
+	if triageMode, _ := cmd.Flags().GetBool("triage"); triageMode && !cmd.Flags().Changed("search-scope") {
+		config.SearchScope = "spam"
+	}
 
 	return config, nil
 }
@@ -190,9 +509,24 @@ func buildExportConfig(cmd *cobra.Command) (*exporter.Config, error) {
 	config := &exporter.Config{
 		CredentialsFile:  viper.GetString("credentials_file"),
 		TokenFile:        viper.GetString("token_file"),
+		AuthMode:         viper.GetString("auth_mode"),
 		OutputDir:        viper.GetString("output_dir"),
 		OrganizeByLabels: viper.GetBool("organize_by_labels"),
 		ParallelWorkers:  viper.GetInt("parallel_workers"),
+		MaxQPS:           viper.GetFloat64("max_qps"),
+		AdaptiveWorkers:  viper.GetBool("adaptive_workers"),
+		QuotaBudget:      viper.GetInt("quota_budget"),
+		RunWindow:        viper.GetString("run_window"),
+		DefaultCharset:   viper.GetString("default_charset"),
+
+		GmailLabelsHeader: viper.GetBool("gmail_labels_header"),
+
+		CheckpointEvery:    viper.GetInt("checkpoint_every"),
+		CheckpointInterval: viper.GetDuration("checkpoint_interval"),
+
+		LegalHold:  viper.GetBool("legal_hold"),
+		RunHistory: viper.GetBool("metrics.run_history"),
+		Version:    version,
 	}
 
 	// Override with command flags if provided
@@ -205,6 +539,54 @@ func buildExportConfig(cmd *cobra.Command) (*exporter.Config, error) {
 	if parallelWorkers, _ := cmd.Flags().GetInt("parallel-workers"); parallelWorkers > 0 {
 		config.ParallelWorkers = parallelWorkers
 	}
+	if adaptiveWorkers, _ := cmd.Flags().GetBool("adaptive-workers"); adaptiveWorkers {
+		config.AdaptiveWorkers = adaptiveWorkers
+	}
+	if maxQPS, _ := cmd.Flags().GetFloat64("max-qps"); maxQPS > 0 {
+		config.MaxQPS = maxQPS
+	}
+	if onlyLabels, _ := cmd.Flags().GetStringSlice("only-labels"); len(onlyLabels) > 0 {
+		config.OnlyLabels = onlyLabels
+	}
+	if skipLabels, _ := cmd.Flags().GetStringSlice("skip-labels"); len(skipLabels) > 0 {
+		config.SkipLabels = skipLabels
+	}
+	if maxPerLabel, _ := cmd.Flags().GetInt("max-per-label"); maxPerLabel > 0 {
+		config.MaxPerLabel = maxPerLabel
+	}
+	if triageMode, _ := cmd.Flags().GetBool("triage"); triageMode {
+		config.Triage = triageMode
+	}
+	if thunderbirdDir, _ := cmd.Flags().GetString("thunderbird-dir"); thunderbirdDir != "" {
+		config.ThunderbirdDir = thunderbirdDir
+	}
+	if notmuchTags, _ := cmd.Flags().GetBool("notmuch-tags"); notmuchTags {
+		config.NotmuchTags = notmuchTags
+	}
+	if legalHold, _ := cmd.Flags().GetBool("legal-hold"); legalHold {
+		config.LegalHold = legalHold
+	}
+	if operator, _ := cmd.Flags().GetString("operator"); operator != "" {
+		config.Operator = operator
+	}
+	if custodyKeyFile, _ := cmd.Flags().GetString("custody-key-file"); custodyKeyFile != "" {
+		config.CustodyKeyFile = custodyKeyFile
+	}
+	if custodySigningKey, _ := cmd.Flags().GetString("custody-signing-key"); custodySigningKey != "" {
+		config.CustodySigningKey = custodySigningKey
+	}
+	if labelDirNames, _ := cmd.Flags().GetBool("label-dir-names"); labelDirNames {
+		config.LabelDirNames = labelDirNames
+	}
+	if transliterate, _ := cmd.Flags().GetString("transliterate"); transliterate != "" {
+		config.Transliterate = transliterate
+	}
+	if maxPathLength, _ := cmd.Flags().GetInt("max-path-length"); maxPathLength > 0 {
+		config.MaxPathLength = maxPathLength
+	}
+	if labelStrategy, _ := cmd.Flags().GetString("label-strategy"); labelStrategy != "" {
+		config.LabelStrategy = labelStrategy
+	}
 	if includeAttachments, _ := cmd.Flags().GetBool("include-attachments"); !includeAttachments {
 		config.IncludeAttachments = includeAttachments
 	} else {
@@ -216,24 +598,131 @@ func buildExportConfig(cmd *cobra.Command) (*exporter.Config, error) {
 	if format, _ := cmd.Flags().GetString("format"); format != "" {
 		config.Format = format
 	}
+	if metadataFormat, _ := cmd.Flags().GetString("metadata-format"); metadataFormat != "" {
+		config.MetadataFormat = metadataFormat
+	}
+	if headers, _ := cmd.Flags().GetStringSlice("headers"); len(headers) > 0 {
+		config.Headers = headers
+	}
 	if resume, _ := cmd.Flags().GetBool("resume"); resume {
 		config.Resume = resume
 	}
 	if stateFile, _ := cmd.Flags().GetString("state-file"); stateFile != "" {
 		config.StateFile = stateFile
 	}
+	if skipLargerThan := viper.GetString("skip_larger_than"); skipLargerThan != "" {
+		size, err := filters.ParseSize(skipLargerThan)
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip-larger-than: %w", err)
+		}
+		config.SkipLargerThan = size
+	}
+	if maxInMemorySize := viper.GetString("max_in_memory_size"); maxInMemorySize != "" {
+		size, err := filters.ParseSize(maxInMemorySize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max-in-memory-size: %w", err)
+		}
+		config.MaxInMemorySize = size
+	}
+	if fsync, _ := cmd.Flags().GetBool("fsync"); fsync {
+		config.Fsync = fsync
+	}
+	if splitBy, _ := cmd.Flags().GetString("split-by"); splitBy != "" {
+		config.SplitBy = splitBy
+	}
+	if listShards, _ := cmd.Flags().GetString("list-shards"); listShards != "" {
+		config.ListShards = listShards
+	}
+	if listConcurrency, _ := cmd.Flags().GetInt("list-concurrency"); listConcurrency > 0 {
+		config.ListConcurrency = listConcurrency
+	}
+	if refreshSearchAt, _ := cmd.Flags().GetFloat64("refresh-search-at"); refreshSearchAt != 0 {
+		config.RefreshSearchAt = refreshSearchAt
+	}
+	if metadataCache, _ := cmd.Flags().GetString("metadata-cache"); metadataCache != "" {
+		config.MetadataCache = metadataCache
+	}
+	if rules, _ := cmd.Flags().GetStringSlice("redact"); len(rules) > 0 {
+		config.Redact = rules
+	}
+	if patterns, _ := cmd.Flags().GetStringArray("redact-pattern"); len(patterns) > 0 {
+		config.RedactPatterns = patterns
+	}
 	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
 		config.Limit = limit
 	}
+	if maxTotalSize, _ := cmd.Flags().GetString("max-total-size"); maxTotalSize != "" {
+		size, err := filters.ParseSize(maxTotalSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max-total-size: %w", err)
+		}
+		config.MaxTotalSize = size
+	}
+	if maxDuration, _ := cmd.Flags().GetDuration("max-duration"); maxDuration > 0 {
+		config.MaxDuration = maxDuration
+	}
+	if order, _ := cmd.Flags().GetString("order"); order != "" {
+		config.Order = order
+	}
+	if sample, _ := cmd.Flags().GetInt("sample"); sample > 0 {
+		config.Sample = sample
+	}
+	if checkpointEvery, _ := cmd.Flags().GetInt("checkpoint-every"); checkpointEvery > 0 {
+		config.CheckpointEvery = checkpointEvery
+	}
+	if checkpointInterval, _ := cmd.Flags().GetDuration("checkpoint-interval"); checkpointInterval > 0 {
+		config.CheckpointInterval = checkpointInterval
+	}
 
 	// Validate required fields
 	if config.OutputDir == "" {
 		return nil, fmt.Errorf("output directory is required")
 	}
 
+	if simulated, _ := cmd.Flags().GetBool("simulate"); simulated {
+		config.Simulate = buildSimulateConfig(cmd)
+	}
+
+	// Additional OAuth clients to rotate API calls across
+	clients, err := loadOAuthClients()
+	if err != nil {
+		return nil, err
+	}
+	if config.Simulate == nil {
+		config.OAuthClients = clients
+	}
+
+	if config.Retry, err = loadRetryPolicies(); err != nil {
+		return nil, err
+	}
+	if config.Hooks, err = loadHooks(); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
+// buildSimulateConfig returns the simulated mailbox of the simulate flags
+func buildSimulateConfig(cmd *cobra.Command) *simulate.Config {
+	config := &simulate.Config{}
+	config.Messages, _ = cmd.Flags().GetInt("simulate-messages")
+	config.FailureRate, _ = cmd.Flags().GetFloat64("simulate-failure-rate")
+	config.Failures, _ = cmd.Flags().GetStringSlice("simulate-failures")
+	config.Seed, _ = cmd.Flags().GetUint64("simulate-seed")
+	config.Latency, _ = cmd.Flags().GetDuration("simulate-latency")
+	return config
+}
+
+// loadRetryPolicies reads the per-category retry policies of the retry
+// section of the config file
+func loadRetryPolicies() (retry.Policies, error) {
+	var settings map[string]retry.Policy
+	if err := viper.UnmarshalKey("retry", &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse retry configuration: %w", err)
+	}
+	return retry.Parse(settings)
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -1,8 +1,10 @@
 package cli
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,8 +12,13 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"google.golang.org/api/gmail/v1"
 
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
 	"github.com/octasoft-ltd/gmail-exporter/internal/cleaner"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
 )
 
 var generateFilterCmd = &cobra.Command{
@@ -21,7 +28,8 @@ var generateFilterCmd = &cobra.Command{
 This file can then be used with the cleanup command to archive or delete the processed emails.
 
 The command scans the exports directory for .eml files and extracts the Gmail message IDs
-from the filenames to create a processed_emails.json file.`,
+from the filenames to create a processed_emails.json file. The subject, sender and date
+of each email are read from the file headers (or the JSON payload for JSON exports).`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		inputDir, _ := cmd.Flags().GetString("input-dir")
 		outputFile, _ := cmd.Flags().GetString("output-file")
@@ -31,7 +39,7 @@ from the filenames to create a processed_emails.json file.`,
 		}
 
 		if outputFile == "" {
-			outputFile = filepath.Join(inputDir, "processed_emails.json")
+			outputFile = filepath.Join(inputDir, exporter.ProcessedEmailsFile)
 		}
 
 		logrus.WithFields(logrus.Fields{
@@ -49,13 +57,29 @@ from the filenames to create a processed_emails.json file.`,
 			return fmt.Errorf("no email files found in directory: %s", inputDir)
 		}
 
+		// Fill in subjects and senders from the export's metadata cache
+		cachePath, _ := cmd.Flags().GetString("metadata-cache")
+		if cachePath == "" {
+			cachePath = filepath.Join(inputDir, cache.DefaultFileName)
+		}
+		store, err := cache.OpenIfExists(cachePath)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to open metadata cache")
+		} else if store != nil {
+			enriched := cleaner.EnrichFromCache(processedEmails, store)
+			if err := store.Close(); err != nil {
+				logrus.WithError(err).Warn("Failed to close metadata cache")
+			}
+			logrus.WithField("enriched", enriched).Info("Loaded email metadata from cache")
+		}
+
 		// Write the filter file
 		data, err := json.MarshalIndent(processedEmails, "", "  ")
 		if err != nil {
 			return fmt.Errorf("failed to marshal processed emails: %w", err)
 		}
 
-		if err := os.WriteFile(outputFile, data, 0o600); err != nil {
+		if err := atomicfile.WriteFile(outputFile, data, 0o600); err != nil {
 			return fmt.Errorf("failed to write filter file: %w", err)
 		}
 
@@ -72,6 +96,7 @@ from the filenames to create a processed_emails.json file.`,
 func init() {
 	generateFilterCmd.Flags().StringP("input-dir", "i", "", "Input directory containing exported emails")
 	generateFilterCmd.Flags().StringP("output-file", "o", "", "Output filter file path (default: input-dir/processed_emails.json)")
+	generateFilterCmd.Flags().String("metadata-cache", "", "Metadata cache written by export (default: input-dir/metadata.db)")
 	if err := generateFilterCmd.MarkFlagRequired("input-dir"); err != nil {
 		logrus.WithError(err).Fatal("Failed to mark input-dir flag as required")
 	}
@@ -80,6 +105,7 @@ func init() {
 // scanExportsDirectory scans the exports directory and extracts email IDs from filenames
 func scanExportsDirectory(inputDir string) ([]cleaner.ProcessedEmail, error) {
 	var processedEmails []cleaner.ProcessedEmail
+	seen := make(map[string]bool)
 	now := time.Now()
 
 	err := filepath.WalkDir(inputDir, func(path string, d os.DirEntry, err error) error {
@@ -93,7 +119,7 @@ func scanExportsDirectory(inputDir string) ([]cleaner.ProcessedEmail, error) {
 
 		// Check for email files
 		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".eml" && ext != ".json" && ext != ".mbox" {
+		if ext != ".eml" && ext != ".json" && ext != ".mbox" && ext != ".txt" {
 			return nil
 		}
 
@@ -107,6 +133,12 @@ func scanExportsDirectory(inputDir string) ([]cleaner.ProcessedEmail, error) {
 			return nil
 		}
 
+		// Messages copied into several label directories are listed once
+		if seen[emailID] {
+			return nil
+		}
+		seen[emailID] = true
+
 		// Get file info for additional metadata
 		fileInfo, err := d.Info()
 		if err != nil {
@@ -124,6 +156,11 @@ func scanExportsDirectory(inputDir string) ([]cleaner.ProcessedEmail, error) {
 			processedEmail.Size = fileInfo.Size()
 		}
 
+		// Read subject, sender and date from the exported file
+		if err := readEmailMetadata(path, ext, &processedEmail); err != nil {
+			logrus.WithError(err).WithField("path", path).Debug("Failed to read email metadata")
+		}
+
 		processedEmails = append(processedEmails, processedEmail)
 		return nil
 	})
@@ -134,6 +171,53 @@ func scanExportsDirectory(inputDir string) ([]cleaner.ProcessedEmail, error) {
 	return processedEmails, nil
 }
 
+// readEmailMetadata fills in the subject, sender and date of an exported email
+// from its headers, or from the Gmail payload for JSON exports
+func readEmailMetadata(path, ext string, email *cleaner.ProcessedEmail) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open email file: %w", err)
+	}
+	defer file.Close()
+
+	if ext == ".json" {
+		var message gmail.Message
+		if err := json.NewDecoder(file).Decode(&message); err != nil {
+			return fmt.Errorf("failed to parse JSON export: %w", err)
+		}
+
+		metadata := cache.FromMessage(&message)
+		email.Subject = metadata.Subject
+		email.From = metadata.From
+		email.Date = metadata.Date
+		return nil
+	}
+
+	reader := bufio.NewReader(file)
+
+	// Skip the mbox "From " separator line if present
+	if ext == ".mbox" {
+		if peek, err := reader.Peek(5); err == nil && string(peek) == "From " {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return fmt.Errorf("failed to read mbox separator: %w", err)
+			}
+		}
+	}
+
+	message, err := mail.ReadMessage(reader)
+	if err != nil {
+		return fmt.Errorf("failed to parse email headers: %w", err)
+	}
+
+	email.Subject = mimepart.DecodeHeader(message.Header.Get("Subject"))
+	email.From = mimepart.DecodeHeader(message.Header.Get("From"))
+	if date, err := message.Header.Date(); err == nil {
+		email.Date = date
+	}
+
+	return nil
+}
+
 // isValidGmailMessageID checks if a string looks like a valid Gmail message ID
 func isValidGmailMessageID(id string) bool {
 	if len(id) < 10 || len(id) > 20 {
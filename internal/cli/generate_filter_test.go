@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/octasoft-ltd/gmail-exporter/internal/cleaner"
 )
@@ -205,3 +206,61 @@ func TestGenerateFilterIntegration(t *testing.T) {
 		t.Errorf("Expected %d loaded emails, got %d", len(processedEmails), len(loadedEmails))
 	}
 }
+
+func TestScanExportsDirectory_ReadsMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+
+	eml := "From: =?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>\r\n" +
+		"Subject: Quarterly report\r\n" +
+		"Date: Mon, 02 Jan 2023 15:04:05 +0000\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	mbox := "From renee@example.com Mon Jan  2 15:04:05 2023\n" +
+		"From: bob@example.com\n" +
+		"Subject: Mbox message\n" +
+		"\n" +
+		"Body\n"
+	jsonExport := `{"id":"125289e540f06a4e","internalDate":"1672671845000","payload":{"headers":[` +
+		`{"name":"Subject","value":"JSON message"},{"name":"From","value":"carol@example.com"}]}}`
+
+	files := map[string]string{
+		"125288cd4bd52814.eml":  eml,
+		"12d7a4179949125c.mbox": mbox,
+		"125289e540f06a4e.json": jsonExport,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	processedEmails, err := scanExportsDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("scanExportsDirectory failed: %v", err)
+	}
+
+	byID := make(map[string]cleaner.ProcessedEmail)
+	for _, email := range processedEmails {
+		byID[email.ID] = email
+	}
+
+	emlEmail := byID["125288cd4bd52814"]
+	if emlEmail.Subject != "Quarterly report" || emlEmail.From != "Renée <renee@example.com>" {
+		t.Errorf("Unexpected EML metadata: %+v", emlEmail)
+	}
+	if !emlEmail.Date.Equal(time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected EML date: %v", emlEmail.Date)
+	}
+
+	if mboxEmail := byID["12d7a4179949125c"]; mboxEmail.Subject != "Mbox message" || mboxEmail.From != "bob@example.com" {
+		t.Errorf("Unexpected mbox metadata: %+v", mboxEmail)
+	}
+
+	jsonEmail := byID["125289e540f06a4e"]
+	if jsonEmail.Subject != "JSON message" || jsonEmail.From != "carol@example.com" {
+		t.Errorf("Unexpected JSON metadata: %+v", jsonEmail)
+	}
+	if !jsonEmail.Date.Equal(time.UnixMilli(1672671845000)) {
+		t.Errorf("Unexpected JSON date: %v", jsonEmail.Date)
+	}
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/gui"
+)
+
+var guiCmd = &cobra.Command{
+	Use:   "gui",
+	Short: "Open a local web UI to configure and run exports",
+	Long: `Open a small web UI in the browser to pick filters, run an export and watch its
+progress, for users who prefer not to use the command line.
+
+The UI is served from this binary on the loopback interface only (127.0.0.1) and
+uses the credentials and settings of the config file. Authenticate first with
+'gmail-exporter auth login'. Press Ctrl+C to stop the server.
+
+EXAMPLES:
+  gmail-exporter gui
+  gmail-exporter gui --port 8765 --no-browser`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		port, _ := cmd.Flags().GetInt("port")
+		listener, err := gui.Listen(port)
+		if err != nil {
+			return err
+		}
+
+		server := gui.New(runGUIExport, gui.Request{
+			OutputDir:    viper.GetString("output_dir"),
+			Format:       "eml",
+			SearchScope:  viper.GetString("filters.search_scope"),
+			ExcludeChats: viper.GetBool("filters.exclude_chats"),
+		})
+
+		url := fmt.Sprintf("http://%s/", listener.Addr())
+		fmt.Printf("Gmail Exporter UI: %s (press Ctrl+C to stop)\n", url)
+
+		if noBrowser, _ := cmd.Flags().GetBool("no-browser"); !noBrowser {
+			if err := auth.OpenBrowser(url); err != nil {
+				logrus.WithError(err).Warn("Failed to open browser, open the URL manually")
+			}
+		}
+
+		return server.Serve(listener)
+	},
+}
+
+func init() {
+	guiCmd.Flags().Int("port", 0, "Port to listen on at 127.0.0.1 (0 = pick a free port)")
+	guiCmd.Flags().Bool("no-browser", false, "Print the URL instead of opening the browser")
+}
+
+// runGUIExport runs an export submitted from the web UI with the settings of
+// the config file
+func runGUIExport(request gui.Request, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error) {
+	config := &exporter.Config{
+		CredentialsFile:    viper.GetString("credentials_file"),
+		TokenFile:          viper.GetString("token_file"),
+		AuthMode:           viper.GetString("auth_mode"),
+		OutputDir:          request.OutputDir,
+		OrganizeByLabels:   request.OrganizeByLabels,
+		ParallelWorkers:    viper.GetInt("parallel_workers"),
+		MaxQPS:             viper.GetFloat64("max_qps"),
+		AdaptiveWorkers:    viper.GetBool("adaptive_workers"),
+		IncludeAttachments: true,
+		Format:             request.Format,
+		Limit:              request.Limit,
+		CheckpointEvery:    viper.GetInt("checkpoint_every"),
+		CheckpointInterval: viper.GetDuration("checkpoint_interval"),
+		Version:            version,
+		Events:             events,
+	}
+	if config.Format == "" {
+		config.Format = "eml"
+	}
+
+	clients, err := loadOAuthClients()
+	if err != nil {
+		return nil, err
+	}
+	config.OAuthClients = clients
+
+	exp, err := exporter.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	result, err := exp.Export(filterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("export failed: %w", err)
+	}
+
+	return result, nil
+}
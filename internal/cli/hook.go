@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/hooks"
+)
+
+// hookResult is what a post hook is told about the run it follows, through
+// GMAIL_EXPORTER_* environment variables
+type hookResult struct {
+	Dir      string
+	Exported int
+	Failed   int
+}
+
+// runPostHook runs the --post-hook command, if any, through the shell in the
+// output directory, with its output passed through. A failing hook fails the
+// command so wrappers notice that the mail store was not updated.
+func runPostHook(cmd *cobra.Command, result hookResult) error {
+	command, _ := cmd.Flags().GetString("post-hook")
+	if command == "" {
+		return nil
+	}
+
+	hook := hooks.ShellCommand(context.Background(), command)
+	hook.Dir = result.Dir
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+	hook.Env = append(os.Environ(),
+		"GMAIL_EXPORTER_OUTPUT_DIR="+result.Dir,
+		"GMAIL_EXPORTER_EXPORTED="+strconv.Itoa(result.Exported),
+		"GMAIL_EXPORTER_FAILED="+strconv.Itoa(result.Failed),
+	)
+
+	logrus.WithFields(logrus.Fields{
+		"command": command,
+		"dir":     result.Dir,
+	}).Info("Running post hook")
+	if err := hook.Run(); err != nil {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("post hook %q failed: %w", command, err)
+	}
+	return nil
+}
+
+// loadHooks reads the hooks section of the config file
+func loadHooks() ([]hooks.Hook, error) {
+	var configured []hooks.Hook
+	if err := viper.UnmarshalKey("hooks", &configured); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks configuration: %w", err)
+	}
+	if err := hooks.Validate(configured); err != nil {
+		return nil, err
+	}
+	return configured, nil
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestRunPostHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("post hook test uses sh")
+	}
+
+	newCmd := func(command string) *cobra.Command {
+		cmd := &cobra.Command{Use: "test"}
+		cmd.Flags().String("post-hook", command, "")
+		return cmd
+	}
+
+	if err := runPostHook(newCmd(""), hookResult{Dir: t.TempDir()}); err != nil {
+		t.Errorf("Expected no hook to succeed, got %v", err)
+	}
+
+	dir := t.TempDir()
+	hook := `echo "$GMAIL_EXPORTER_EXPORTED $GMAIL_EXPORTER_FAILED" > hook.out`
+	if err := runPostHook(newCmd(hook), hookResult{Dir: dir, Exported: 12, Failed: 1}); err != nil {
+		t.Fatalf("runPostHook() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "hook.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "12 1\n" {
+		t.Errorf("Expected the hook to run in the output directory with the counts, got %q", data)
+	}
+
+	if err := runPostHook(newCmd("exit 3"), hookResult{Dir: dir}); err == nil {
+		t.Error("Expected a failing hook to fail the command")
+	}
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -13,7 +14,7 @@ import (
 
 var importCmd = &cobra.Command{
 	Use:   "import",
-	Short: "Import exported emails into a Gmail account",
+	Short: "Import exported emails into a Gmail or Microsoft 365 account",
 	Long: `Import previously exported emails into a Gmail account.
 This command takes exported emails and adds them to the authenticated user's mailbox
 without sending them as new emails. The emails will appear as if they were received normally.
@@ -23,6 +24,48 @@ The import command uses separate credentials from export to allow importing into
 Gmail account. Use --import-credentials and --import-token to specify different authentication
 files for the destination account.
 
+MICROSOFT 365:
+Use --backend graph to upload EML exports into an Outlook / Exchange Online mailbox
+through the Microsoft Graph API instead. This needs an Entra ID app registration with
+the Mail.ReadWrite application permission; pass its --graph-tenant, --graph-client-id
+and client secret (--graph-client-secret or GRAPH_CLIENT_SECRET) and the destination
+--graph-mailbox. Gmail system labels map to the matching Outlook folders (Inbox,
+Sent Items, Drafts, Deleted Items, Junk Email), and other labelled mail goes to the
+Archive folder. User labels become Outlook categories, or folders with
+--graph-label-mode folders. Use --label-map to rename labels, for example to give
+Gmail label IDs readable names.
+
+HEADERS:
+Use --drop-header, --rename-header and --add-header to clean up messages before upload,
+for example dropping stale DKIM-Signature and ARC-* headers that no longer verify in
+the new account. Headers can also be set in the import.headers section of the config file.
+
+MIME REPAIR:
+Use --repair-mime to fix messages Gmail would reject for broken MIME structure: bare
+LF line endings, a missing blank line after the headers, missing Message-ID, Date and
+MIME-Version headers, missing or unclosed multipart boundaries, raw 8-bit subjects,
+misspelled transfer encodings and undecodable base64 bodies. The repairs applied to
+each message are listed in import_repairs.json next to the input directory.
+
+DOMAIN MIGRATIONS:
+Use --rewrite-address olddomain.com=newdomain.com to rewrite matching addresses in the
+From, To, Cc, Bcc, Reply-To and Sender headers, so migrated mail reflects the new domain
+in search and replies. Whole addresses can be mapped too (old@a.com=new@b.com) and take
+precedence over domain rules.
+
+GOOGLE TAKEOUT:
+Point --input-dir at a Takeout .mbox file (or a directory containing one) to import it
+directly. Large archives are streamed one message at a time and labels are restored from
+each message's X-Gmail-Labels header. Progress is saved to import_state.json next to the
+input as messages finish, so an interrupted import continues where it stopped with --resume.
+
+DUPLICATES:
+Use --skip-existing to search the destination for each message's Message-ID before
+upload (rfc822msgid: in Gmail, internetMessageId in Microsoft 365) and skip messages
+already there, so re-running a partial import without its state file does not create
+duplicates. It costs one search per message; messages without a Message-ID are always
+imported.
+
 Use --limit to process only a specific number of messages, which is useful for testing
 the import process with a small number of messages before running a full import.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -41,37 +84,82 @@ the import process with a small number of messages before running a full import.
 		// Run import
 		logrus.WithFields(logrus.Fields{
 			"input_dir":        importConfig.InputDir,
+			"backend":          importConfig.Backend,
 			"credentials_file": importConfig.CredentialsFile,
 			"limit":            importConfig.Limit,
 		}).Info("Starting email import")
 
+		deadline := startDeadline(cmd, imp.Cancel)
+		defer deadline.stop()
+
 		result, err := imp.Import()
 		if err != nil {
 			return fmt.Errorf("import failed: %w", err)
 		}
+		recordResult(result)
+		if result.Cancelled && deadline.expired() {
+			fmt.Printf("Import stopped after %d emails; progress saved\n", result.TotalImported)
+			return deadline.err(cmd, "import", "run it again with --resume to continue")
+		}
 
 		// Display results
 		fmt.Printf("Import completed successfully!\n")
-		fmt.Printf("Total files found: %d\n", result.TotalFound)
+		fmt.Printf("Total messages found: %d\n", result.TotalFound)
 		fmt.Printf("Total emails imported: %d\n", result.TotalImported)
+		if result.TotalSkipped > 0 {
+			fmt.Printf("Already imported (skipped): %d\n", result.TotalSkipped)
+		}
+		if result.TotalDuplicates > 0 {
+			fmt.Printf("Already in the destination (skipped): %d\n", result.TotalDuplicates)
+		}
 		fmt.Printf("Total size: %s\n", metrics.FormatBytes(result.TotalSize))
 		fmt.Printf("Duration: %s\n", result.Duration)
 
+		if result.TotalRepaired > 0 {
+			fmt.Printf("Repaired messages: %d (see import_repairs.json next to the input)\n", result.TotalRepaired)
+		}
+
 		if result.TotalFailed > 0 {
-			fmt.Printf("Failed imports: %d (see log for details)\n", result.TotalFailed)
+			fmt.Printf("Failed imports: %d (%s; see log for details)\n",
+				result.TotalFailed, formatCategories(result.FailedByCategory))
 		}
 
-		return nil
+		return partialFailure(cmd, "imports", result.TotalFailed, result.FailedByCategory)
 	},
 }
 
 func init() {
-	importCmd.Flags().StringP("input-dir", "i", "", "Input directory containing exported emails")
+	importCmd.Flags().StringP("input-dir", "i", "", "Input directory containing exported emails, or a Takeout .mbox file")
 	importCmd.Flags().String("import-credentials", "", "Gmail API credentials file for destination account (defaults to main credentials)")
 	importCmd.Flags().String("import-token", "", "OAuth token file for destination account (defaults to main token)")
 	importCmd.Flags().Int("parallel-workers", 3, "Number of parallel workers")
 	importCmd.Flags().Bool("preserve-dates", true, "Preserve original email dates")
 	importCmd.Flags().IntP("limit", "l", 0, "Limit the number of messages to process (0 = no limit, useful for testing)")
+	importCmd.Flags().Bool("resume", false, "Resume an interrupted import, skipping messages already imported")
+	importCmd.Flags().String("state-file", "", "File recording import progress for --resume (default: import_state.json next to the input)")
+	addTimeoutFlag(importCmd)
+
+	// Microsoft Graph backend
+	importCmd.Flags().String("backend", importer.BackendGmail, "Import destination: gmail or graph (Microsoft 365 / Outlook)")
+	importCmd.Flags().String("graph-tenant", "", "Entra ID tenant ID for the graph backend")
+	importCmd.Flags().String("graph-client-id", "", "Application (client) ID for the graph backend")
+	importCmd.Flags().String("graph-client-secret", "", "Client secret for the graph backend (or set GRAPH_CLIENT_SECRET)")
+	importCmd.Flags().String("graph-mailbox", "", "Destination Outlook mailbox (user principal name or ID)")
+	importCmd.Flags().String("graph-folder", "inbox", "Folder for messages without label information")
+	importCmd.Flags().String("graph-label-mode", importer.LabelModeCategories, "Map Gmail user labels to Outlook categories or folders")
+	importCmd.Flags().StringToString("label-map", nil, "Rename Gmail labels on import (e.g. Label_12=Projects)")
+
+	// Header transforms
+	importCmd.Flags().StringSlice("drop-header", nil, "Remove these headers before upload; a trailing * matches a prefix (e.g. DKIM-Signature,ARC-*)")
+	importCmd.Flags().StringToString("rename-header", nil, "Rename headers before upload (e.g. List-Unsubscribe=X-Original-List-Unsubscribe)")
+	importCmd.Flags().StringArray("add-header", nil, "Add a header to every message (e.g. \"X-Migrated-From: old@example.com\"); repeatable")
+
+	// MIME repair of broken messages
+	importCmd.Flags().Bool("skip-existing", false, "Search the destination for each message's Message-ID and skip messages already there")
+	importCmd.Flags().Bool("repair-mime", false, "Repair broken MIME structure before upload (line endings, missing headers and boundaries, bad encodings)")
+
+	// Address rewriting for domain migrations
+	importCmd.Flags().StringToString("rewrite-address", nil, "Rewrite addresses in From/To/Cc headers (e.g. olddomain.com=newdomain.com or old@a.com=new@b.com)")
 }
 
 func buildImportConfig(cmd *cobra.Command) (*importer.Config, error) {
@@ -90,6 +178,9 @@ func buildImportConfig(cmd *cobra.Command) (*importer.Config, error) {
 	config := &importer.Config{
 		CredentialsFile: credentialsFile,
 		TokenFile:       tokenFile,
+		AuthMode:        viper.GetString("auth_mode"),
+		AuditLog:        viper.GetString("audit_log"),
+		AuditSigningKey: viper.GetString("audit_signing_key"),
 	}
 
 	// Get flags
@@ -105,11 +196,119 @@ func buildImportConfig(cmd *cobra.Command) (*importer.Config, error) {
 	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
 		config.Limit = limit
 	}
+	if resume, _ := cmd.Flags().GetBool("resume"); resume {
+		config.Resume = resume
+	}
+	if stateFile, _ := cmd.Flags().GetString("state-file"); stateFile != "" {
+		config.StateFile = stateFile
+	}
+	if repairMIME, _ := cmd.Flags().GetBool("repair-mime"); repairMIME {
+		config.RepairMIME = repairMIME
+	}
+	if skipExisting, _ := cmd.Flags().GetBool("skip-existing"); skipExisting {
+		config.SkipExisting = skipExisting
+	}
 
 	// Validate required fields
 	if config.InputDir == "" {
 		return nil, fmt.Errorf("input directory is required")
 	}
 
+	config.Headers = buildHeaderTransform(cmd)
+	config.RewriteAddresses = viper.GetStringMapString("import.rewrite_addresses")
+	if rewrites, _ := cmd.Flags().GetStringToString("rewrite-address"); len(rewrites) > 0 {
+		if config.RewriteAddresses == nil {
+			config.RewriteAddresses = make(map[string]string)
+		}
+		for from, to := range rewrites {
+			config.RewriteAddresses[from] = to
+		}
+	}
+
+	config.Backend, _ = cmd.Flags().GetString("backend")
+	if config.Backend == importer.BackendGraph {
+		config.Graph = buildGraphConfig(cmd)
+	}
+
+	retryPolicies, err := loadRetryPolicies()
+	if err != nil {
+		return nil, err
+	}
+	config.Retry = retryPolicies
+
 	return config, nil
 }
+
+// buildHeaderTransform reads the header transform from flags, adding to the
+// import.headers section of the config file
+func buildHeaderTransform(cmd *cobra.Command) importer.HeaderTransform {
+	transform := importer.HeaderTransform{
+		Drop:   viper.GetStringSlice("import.headers.drop"),
+		Rename: viper.GetStringMapString("import.headers.rename"),
+		Add:    viper.GetStringSlice("import.headers.add"),
+	}
+
+	if drop, _ := cmd.Flags().GetStringSlice("drop-header"); len(drop) > 0 {
+		transform.Drop = append(transform.Drop, drop...)
+	}
+	if rename, _ := cmd.Flags().GetStringToString("rename-header"); len(rename) > 0 {
+		if transform.Rename == nil {
+			transform.Rename = make(map[string]string)
+		}
+		for from, to := range rename {
+			transform.Rename[from] = to
+		}
+	}
+	if add, _ := cmd.Flags().GetStringArray("add-header"); len(add) > 0 {
+		transform.Add = append(transform.Add, add...)
+	}
+
+	return transform
+}
+
+// buildGraphConfig reads the Microsoft Graph backend settings from flags,
+// falling back to the graph section of the config file
+func buildGraphConfig(cmd *cobra.Command) importer.GraphConfig {
+	config := importer.GraphConfig{
+		TenantID:      viper.GetString("graph.tenant_id"),
+		ClientID:      viper.GetString("graph.client_id"),
+		ClientSecret:  viper.GetString("graph.client_secret"),
+		Mailbox:       viper.GetString("graph.mailbox"),
+		DefaultFolder: viper.GetString("graph.default_folder"),
+		LabelMode:     viper.GetString("graph.label_mode"),
+		LabelMap:      viper.GetStringMapString("graph.label_map"),
+	}
+
+	if secret := os.Getenv("GRAPH_CLIENT_SECRET"); secret != "" {
+		config.ClientSecret = secret
+	}
+
+	if tenant, _ := cmd.Flags().GetString("graph-tenant"); tenant != "" {
+		config.TenantID = tenant
+	}
+	if clientID, _ := cmd.Flags().GetString("graph-client-id"); clientID != "" {
+		config.ClientID = clientID
+	}
+	if secret, _ := cmd.Flags().GetString("graph-client-secret"); secret != "" {
+		config.ClientSecret = secret
+	}
+	if mailbox, _ := cmd.Flags().GetString("graph-mailbox"); mailbox != "" {
+		config.Mailbox = mailbox
+	}
+	if cmd.Flags().Changed("graph-folder") || config.DefaultFolder == "" {
+		config.DefaultFolder, _ = cmd.Flags().GetString("graph-folder")
+	}
+	if cmd.Flags().Changed("graph-label-mode") || config.LabelMode == "" {
+		config.LabelMode, _ = cmd.Flags().GetString("graph-label-mode")
+	}
+	if labelMap, _ := cmd.Flags().GetStringToString("label-map"); len(labelMap) > 0 {
+		if config.LabelMap == nil {
+			config.LabelMap = make(map[string]string)
+		}
+		for label, name := range labelMap {
+			config.LabelMap[label] = name
+		}
+	}
+
+	return config
+}
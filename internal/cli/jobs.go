@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+	"github.com/octasoft-ltd/gmail-exporter/internal/orchestrator"
+)
+
+// jobsTimeout bounds each call to the job server
+const jobsTimeout = 30 * time.Second
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List, inspect and cancel the export jobs of a job server",
+	Long: `Manage the export jobs of a running 'gmail-exporter serve'. The server keeps its
+jobs in its jobs database, so jobs submitted before a restart are listed too.
+
+EXAMPLES:
+  gmail-exporter jobs list
+  gmail-exporter jobs list --state running --account work
+  gmail-exporter jobs show 3f9a1c0d2b4e6f70
+  gmail-exporter jobs cancel 3f9a1c0d2b4e6f70 --server jobs.example.com:50051 --tls-ca ca.crt`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the jobs of the server, oldest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid format: %s (valid: text, json)", format)
+		}
+		stateName, _ := cmd.Flags().GetString("state")
+		state, err := parseJobState(stateName)
+		if err != nil {
+			return err
+		}
+		account, _ := cmd.Flags().GetString("account")
+
+		client, closeConn, err := dialJobServer(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeConn()
+
+		ctx, cancel := context.WithTimeout(context.Background(), jobsTimeout)
+		defer cancel()
+		response, err := client.ListJobs(ctx, &jobsv1.ListJobsRequest{State: state, Account: account})
+		if err != nil {
+			return fmt.Errorf("failed to list jobs: %w", err)
+		}
+
+		if format == "json" {
+			return printProto(response)
+		}
+		if len(response.Jobs) == 0 {
+			fmt.Println("No jobs")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "JOB ID\tACCOUNT\tSTATE\tEXPORTED\tFAILED\tCREATED\tOUTPUT DIR")
+		for _, job := range response.Jobs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%d\t%s\t%s\n",
+				job.GetJobId(), jobAccount(job), jobStateName(job.GetState()),
+				job.GetProgress().GetExported(), job.GetProgress().GetMatched(), job.GetProgress().GetFailed(),
+				job.GetCreatedAt().AsTime().Local().Format("2006-01-02 15:04:05"), job.GetOutputDir())
+		}
+		return w.Flush()
+	},
+}
+
+var jobsShowCmd = &cobra.Command{
+	Use:   "show JOB-ID",
+	Short: "Show the status and progress of a job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid format: %s (valid: text, json)", format)
+		}
+
+		client, closeConn, err := dialJobServer(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeConn()
+
+		ctx, cancel := context.WithTimeout(context.Background(), jobsTimeout)
+		defer cancel()
+		job, err := client.GetJobStatus(ctx, &jobsv1.GetJobStatusRequest{JobId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to get job %s: %w", args[0], err)
+		}
+
+		if format == "json" {
+			return printProto(job)
+		}
+		printJob(job)
+		return nil
+	},
+}
+
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel JOB-ID",
+	Short: "Cancel a queued or running job",
+	Long: `Cancel a queued or running job. A running export finishes the messages in
+flight and keeps what it exported, so it can be resumed with a new job.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closeConn, err := dialJobServer(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeConn()
+
+		ctx, cancel := context.WithTimeout(context.Background(), jobsTimeout)
+		defer cancel()
+		job, err := client.CancelJob(ctx, &jobsv1.CancelJobRequest{JobId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to cancel job %s: %w", args[0], err)
+		}
+
+		fmt.Printf("Cancelling job %s (state: %s)\n", job.GetJobId(), jobStateName(job.GetState()))
+		return nil
+	},
+}
+
+func init() {
+	jobsCmd.PersistentFlags().String("server", "127.0.0.1:50051", "Address of the job server")
+	jobsCmd.PersistentFlags().String("tls-ca", "", "CA certificate to verify the server with (enables TLS)")
+	jobsCmd.PersistentFlags().String("tls-cert", "", "Client certificate file, for a server with --tls-client-ca (enables TLS)")
+	jobsCmd.PersistentFlags().String("tls-key", "", "Client certificate private key file")
+	jobsCmd.PersistentFlags().String("auth-token-file", "", "File holding the bearer token of the server")
+
+	jobsListCmd.Flags().String("state", "", "List only jobs in this state (queued, running, succeeded, failed, cancelled)")
+	jobsListCmd.Flags().String("account", "", "List only jobs of this account profile")
+	jobsListCmd.Flags().String("format", "text", "Output format (text, json)")
+	jobsShowCmd.Flags().String("format", "text", "Output format (text, json)")
+
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsShowCmd)
+	jobsCmd.AddCommand(jobsCancelCmd)
+}
+
+// dialJobServer connects to the job server of the --server flag, returning
+// its client and a function closing the connection
+func dialJobServer(cmd *cobra.Command) (jobsv1.JobServiceClient, func(), error) {
+	address, _ := cmd.Flags().GetString("server")
+	caFile, _ := cmd.Flags().GetString("tls-ca")
+	certFile, _ := cmd.Flags().GetString("tls-cert")
+	keyFile, _ := cmd.Flags().GetString("tls-key")
+	tokenFile, _ := cmd.Flags().GetString("auth-token-file")
+	if (certFile == "") != (keyFile == "") {
+		return nil, nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+
+	secure := caFile != "" || certFile != ""
+	creds := insecure.NewCredentials()
+	if secure {
+		var err error
+		if creds, err = orchestrator.ClientTLS(caFile, certFile, keyFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	options := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if tokenFile != "" {
+		token, err := readAuthToken(tokenFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		options = append(options, grpc.WithPerRPCCredentials(orchestrator.TokenCredentials(token, secure)))
+	}
+
+	conn, err := grpc.Dial(address, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to job server %s: %w", address, err)
+	}
+
+	return jobsv1.NewJobServiceClient(conn), func() { conn.Close() }, nil
+}
+
+// parseJobState parses a job state name such as "running"; empty matches
+// every state
+func parseJobState(name string) (jobsv1.JobState, error) {
+	if name == "" {
+		return jobsv1.JobState_JOB_STATE_UNSPECIFIED, nil
+	}
+	value, ok := jobsv1.JobState_value["JOB_STATE_"+strings.ToUpper(name)]
+	if !ok || value == int32(jobsv1.JobState_JOB_STATE_UNSPECIFIED) {
+		return 0, fmt.Errorf("invalid state: %s (valid: queued, running, succeeded, failed, cancelled)", name)
+	}
+	return jobsv1.JobState(value), nil
+}
+
+// jobStateName returns the short lowercase name of a job state
+func jobStateName(state jobsv1.JobState) string {
+	return strings.ToLower(strings.TrimPrefix(state.String(), "JOB_STATE_"))
+}
+
+// jobAccount returns the account of a job, or "default" for the default
+// credentials
+func jobAccount(job *jobsv1.Job) string {
+	if job.GetAccount() == "" {
+		return "default"
+	}
+	return job.GetAccount()
+}
+
+// printJob prints the details of a job
+func printJob(job *jobsv1.Job) {
+	progress := job.GetProgress()
+
+	fmt.Printf("Job: %s\n", job.GetJobId())
+	fmt.Printf("Account: %s\n", jobAccount(job))
+	fmt.Printf("State: %s\n", jobStateName(job.GetState()))
+	fmt.Printf("Output directory: %s\n", job.GetOutputDir())
+	fmt.Printf("Query: %s\n", job.GetQuery())
+	fmt.Printf("Created: %s\n", job.GetCreatedAt().AsTime().Local().Format(time.RFC3339))
+	if job.GetStartedAt() != nil {
+		fmt.Printf("Started: %s\n", job.GetStartedAt().AsTime().Local().Format(time.RFC3339))
+	}
+	if job.GetEndedAt() != nil {
+		fmt.Printf("Ended: %s\n", job.GetEndedAt().AsTime().Local().Format(time.RFC3339))
+	}
+	if job.GetRestarts() > 0 {
+		fmt.Printf("Restarts: %d\n", job.GetRestarts())
+	}
+	fmt.Printf("Progress: %d/%d processed (exported: %d, failed: %d, skipped: %d, %d bytes)\n",
+		progress.GetProcessed(), progress.GetMatched(), progress.GetExported(),
+		progress.GetFailed(), progress.GetSkipped(), progress.GetBytes())
+
+	categories := make([]string, 0, len(job.GetFailedByCategory()))
+	for category := range job.GetFailedByCategory() {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Printf("  %s: %d\n", category, job.GetFailedByCategory()[category])
+	}
+
+	if job.GetError() != "" {
+		fmt.Printf("Error: %s\n", job.GetError())
+	}
+}
+
+// printProto prints a message as indented JSON
+func printProto(message proto.Message) error {
+	data, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
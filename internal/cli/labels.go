@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/labelstats"
+)
+
+var labelsCmd = &cobra.Command{
+	Use:   "labels",
+	Short: "Inspect the labels of the mailbox",
+}
+
+var labelsStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the message count and size of every label",
+	Long: `Show every label of the mailbox with its message count, unread count and total
+size, to help decide which labels to export or clean up.
+
+Counts come from the labels themselves. Sizes take an API call per message, so
+they are totalled from the mailbox only with --sizes; --metadata-cache instead
+takes counts and sizes from the metadata cache of an earlier export, covering
+the messages that export matched.
+
+EXAMPLES:
+  gmail-exporter labels stats
+  gmail-exporter labels stats --sizes --sort size
+  gmail-exporter labels stats --metadata-cache ./exports/metadata.db --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sizes, _ := cmd.Flags().GetBool("sizes")
+		cachePath, _ := cmd.Flags().GetString("metadata-cache")
+		workers, _ := cmd.Flags().GetInt("parallel-workers")
+
+		sortBy, _ := cmd.Flags().GetString("sort")
+		if sortBy == "" {
+			sortBy = labelstats.SortMessages
+			if sizes || cachePath != "" {
+				sortBy = labelstats.SortSize
+			}
+		}
+		// Check the sort order before any API call
+		if err := labelstats.Sort(nil, sortBy); err != nil {
+			return err
+		}
+
+		credentialsFile, tokenFile, err := resolveAccountFiles(cmd)
+		if err != nil {
+			return err
+		}
+		_, service, err := auth.NewGmailService(viper.GetString("auth_mode"), auth.AccessReadonly, credentialsFile, tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to get Gmail service: %w", err)
+		}
+
+		options := labelstats.Options{Sizes: sizes, Workers: workers}
+		if cachePath != "" {
+			store, err := cache.OpenIfExists(cachePath)
+			if err != nil {
+				return err
+			}
+			if store == nil {
+				return fmt.Errorf("metadata cache not found: %s", cachePath)
+			}
+			defer store.Close()
+			options.Cache = store
+		}
+
+		stats, err := labelstats.Collect(service, options)
+		if err != nil {
+			return err
+		}
+		if err := labelstats.Sort(stats, sortBy); err != nil {
+			return err
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			return labelstats.WriteJSON(os.Stdout, stats)
+		}
+		return labelstats.WriteTable(os.Stdout, stats, sizes || cachePath != "")
+	},
+}
+
+func init() {
+	labelsCmd.AddCommand(labelsStatsCmd)
+
+	labelsStatsCmd.Flags().Bool("sizes", false, "Total the size of each label's messages (one API call per message)")
+	labelsStatsCmd.Flags().String("metadata-cache", "", "Take counts and sizes from the metadata cache of an export instead of the mailbox")
+	labelsStatsCmd.Flags().String("sort", "", "Sort order (size, messages, name) [default: size with sizes, otherwise messages]")
+	labelsStatsCmd.Flags().Bool("json", false, "Print the statistics as JSON")
+	labelsStatsCmd.Flags().Int("parallel-workers", 4, "Number of messages whose size is fetched at once with --sizes")
+	labelsStatsCmd.Flags().String("account", "", "Account profile from the accounts section of the config file")
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/preview"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the messages matching a filter",
+	Long: `List the messages matching the filter flags, one page at a time, with their
+date, sender, subject, size and labels. The filter flags are those of export, so
+a filter can be checked before exporting or cleaning up with it.
+
+Only the Date, From and Subject headers are fetched; --headers fetches and
+shows more.
+
+Each page ends with the --page-token that fetches the next one.
+
+EXAMPLES:
+  gmail-exporter list --from newsletter@example.com --date-before 2023-01-01
+  gmail-exporter list --labels Receipts --page-size 100 --json > receipts.json
+  gmail-exporter list --headers To,List-Id --from newsletter@example.com`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filterConfig, err := buildFilterConfig(cmd)
+		if err != nil {
+			return fmt.Errorf("failed to build filter config: %w", err)
+		}
+		if err := filterConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid filter configuration: %w", err)
+		}
+
+		pageSize, _ := cmd.Flags().GetInt64("page-size")
+		if pageSize < 1 || pageSize > 500 {
+			return fmt.Errorf("invalid page size: %d (valid: 1-500)", pageSize)
+		}
+		pageToken, _ := cmd.Flags().GetString("page-token")
+		headers, _ := cmd.Flags().GetStringSlice("headers")
+
+		credentialsFile, tokenFile, err := resolveAccountFiles(cmd)
+		if err != nil {
+			return err
+		}
+		_, service, err := auth.NewGmailService(viper.GetString("auth_mode"), auth.AccessReadonly, credentialsFile, tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to get Gmail service: %w", err)
+		}
+
+		page, err := preview.List(service, filterConfig, pageSize, pageToken, headers)
+		if err != nil {
+			return err
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			return preview.WriteJSON(os.Stdout, page)
+		}
+		return preview.WriteTable(os.Stdout, page)
+	},
+}
+
+func init() {
+	addFilterFlags(listCmd)
+
+	listCmd.Flags().Int64("page-size", preview.DefaultPageSize, "Messages per page (1-500)")
+	listCmd.Flags().String("page-token", "", "Page to list, as printed after the previous page")
+	listCmd.Flags().Bool("json", false, "Print the page as JSON")
+	listCmd.Flags().StringSlice("headers", nil, "Additional headers to fetch and show for each message, e.g. To,List-Id")
+	listCmd.Flags().String("account", "", "Account profile from the accounts section of the config file")
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Inspect metrics recorded across runs",
+}
+
+var metricsReportCmd = &cobra.Command{
+	Use:   "report EXPORT-DIR|HISTORY-FILE",
+	Short: "Show throughput and failure trends across recorded runs",
+	Long: `Show the runs recorded in an export directory's runs.jsonl, written by exports
+run with --run-history (or metrics.run_history in the config file), with their
+duration, throughput, failure rate, API latency and retries.
+
+The latest run is compared with the median of the runs before it, and flagged as a
+regression when its throughput drops below 75% of the usual rate, its failure rate
+rises by more than 5 points, or its average API latency grows by more than half,
+which usually points at Gmail throttling or a degraded network.
+
+EXAMPLES:
+  gmail-exporter metrics report ./backups
+  gmail-exporter metrics report ./backups/runs.jsonl --last 30 --format json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid format: %s (valid: text, json)", format)
+		}
+		last, _ := cmd.Flags().GetInt("last")
+		if last < 0 {
+			return fmt.Errorf("last must be >= 0")
+		}
+		operation, _ := cmd.Flags().GetString("operation")
+
+		path := args[0]
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			path = filepath.Join(path, metrics.HistoryFileName)
+		}
+
+		runs, err := metrics.LoadHistory(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no run history at %s: export with --run-history to record one", path)
+			}
+			return err
+		}
+
+		history := metrics.BuildHistory(runs, operation, last)
+		if format == "json" {
+			return history.WriteJSON(os.Stdout)
+		}
+		return history.WriteText(os.Stdout)
+	},
+}
+
+func init() {
+	metricsReportCmd.Flags().Int("last", 10, "Report only the most recent runs (0 = all)")
+	metricsReportCmd.Flags().String("operation", "", "Report only runs of this operation (e.g. export)")
+	metricsReportCmd.Flags().String("format", "text", "Report format (text, json)")
+
+	metricsCmd.AddCommand(metricsReportCmd)
+}
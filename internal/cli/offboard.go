@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+	"github.com/octasoft-ltd/gmail-exporter/internal/workflow"
+)
+
+// offboardSteps are the steps of the offboard workflow
+var offboardSteps = []string{workflow.StepExport, workflow.StepImport, workflow.StepAutoReply, workflow.StepManifest}
+
+// defaultAutoReplySubject is the subject of the auto-reply set by the
+// offboard workflow unless --auto-reply-subject is given
+const defaultAutoReplySubject = "No longer with the organization"
+
+var workflowOffboardCmd = &cobra.Command{
+	Use:   "offboard",
+	Short: "Offboard a departing Workspace user's mailbox",
+	Long: `Offboard a departing employee's mailbox in four steps:
+
+  export      export the mailbox of --user through a service account with
+              domain-wide delegation, as a legal hold export with a signed
+              chain-of-custody manifest
+  import      import the messages with one of --import-labels (all when not
+              set) to the manager's account, given by --import-credentials and
+              --import-token, under the label "Offboarded/<name>"
+  auto_reply  turn on an auto-reply on the departing user's account that points
+              correspondents to --manager
+  manifest    write offboarding_manifest.json next to the workflow state: a
+              compliance record of the steps, signed with the custody key and
+              tied to the export's custody manifest
+
+The service account needs the gmail.readonly and gmail.settings.basic scopes.
+The custody key comes from --custody-key-file or $GMAIL_EXPORTER_CUSTODY_KEY.
+With --custody-signing-key, both manifests are also signed with an Ed25519 key
+that auditors can check with the public key alone.
+
+As with the other workflows, progress is saved in workflow_state.json next to the
+output directory, and a failed run continues at the failed step with --resume.`,
+	Example: `  gmail-exporter workflow offboard --user jane@example.com --manager boss@example.com \
+    --import-token boss_token.json --import-labels Clients,Projects \
+    --service-account-key sa.json --custody-key-file custody.key --output-dir ./offboarding/jane`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		offboard, err := buildOffboard(cmd, time.Now())
+		if err != nil {
+			return err
+		}
+		if _, err := serviceAccountKeyFile(cmd); err != nil {
+			return err
+		}
+		keyFile, _ := cmd.Flags().GetString("custody-key-file")
+		if _, err := custody.LoadKey(keyFile); err != nil {
+			return err
+		}
+		if signingKey, _ := cmd.Flags().GetString("custody-signing-key"); signingKey != "" {
+			if _, err := signing.LoadPrivateKey(signingKey); err != nil {
+				return err
+			}
+		}
+
+		_, err = runWorkflow(cmd, offboardSteps, offboard)
+		return err
+	},
+}
+
+func init() {
+	addOffboardFlags(workflowOffboardCmd)
+}
+
+// addOffboardFlags adds the flags of the offboard workflow
+func addOffboardFlags(cmd *cobra.Command) {
+	addFilterFlags(cmd)
+
+	cmd.Flags().String("user", "", "Departing user whose mailbox is offboarded (required)")
+	cmd.Flags().String("name", "", "Name of the label the mail is filed under, Offboarded/<name> (default: the user name of --user)")
+	cmd.Flags().String("manager", "", "Manager who receives the mail and is named in the auto-reply (required)")
+	cmd.Flags().StringSlice("import-labels", nil, "Import only the messages with one of these labels (default: all)")
+	cmd.Flags().String("import-credentials", "", "Gmail API credentials file for the manager's account (defaults to main credentials)")
+	cmd.Flags().String("import-token", "", "OAuth token file for the manager's account (defaults to main token)")
+	cmd.Flags().String("auto-reply-subject", defaultAutoReplySubject, "Subject of the auto-reply")
+	cmd.Flags().String("auto-reply-message", "", "Text of the auto-reply (default: a notice naming the manager)")
+	cmd.Flags().String("auto-reply-until", "", "Last day of the auto-reply, YYYY-MM-DD (default: no end)")
+	cmd.Flags().String("service-account-key", "", "Service account JSON key with domain-wide delegation")
+	cmd.Flags().String("operator", "", "Operator identity recorded in the manifests (default: local user name)")
+	cmd.Flags().String("custody-key-file", "", "File holding the manifest HMAC key (default: $GMAIL_EXPORTER_CUSTODY_KEY)")
+	cmd.Flags().String("custody-signing-key", "", "Ed25519 private key (PEM) also signing the manifests, checkable without the HMAC key")
+	cmd.Flags().StringP("output-dir", "o", "./exports", "Output directory for exported emails")
+	cmd.Flags().Bool("include-attachments", true, "Include email attachments in export")
+	cmd.Flags().Int("parallel-workers", 3, "Number of parallel workers")
+	cmd.Flags().IntP("limit", "l", 0, "Limit the number of messages to process in each step (0 = no limit, useful for testing)")
+	cmd.Flags().Bool("resume", false, "Resume a failed workflow at the step that failed")
+	cmd.Flags().String("workflow-state", "", "Workflow state file (default: workflow_state.json next to the output directory)")
+}
+
+// buildOffboard describes the offboarding set up by the offboard flags
+func buildOffboard(cmd *cobra.Command, now time.Time) (*workflow.Offboard, error) {
+	user, _ := cmd.Flags().GetString("user")
+	manager, _ := cmd.Flags().GetString("manager")
+	if user == "" || manager == "" {
+		return nil, fmt.Errorf("--user and --manager are required")
+	}
+
+	name, _ := cmd.Flags().GetString("name")
+	if name == "" {
+		name, _, _ = strings.Cut(user, "@")
+	}
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if name == "" {
+		return nil, fmt.Errorf("invalid offboarding name for %s", user)
+	}
+
+	reply := &workflow.AutoReply{}
+	reply.Subject, _ = cmd.Flags().GetString("auto-reply-subject")
+	reply.Message, _ = cmd.Flags().GetString("auto-reply-message")
+	if reply.Message == "" {
+		reply.Message = fmt.Sprintf("%s is no longer with the organization. Please contact %s instead.", user, manager)
+	}
+	if until, _ := cmd.Flags().GetString("auto-reply-until"); until != "" {
+		day, err := time.ParseInLocation("2006-01-02", until, now.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid --auto-reply-until: %w", err)
+		}
+		// The auto-reply runs to the end of the day
+		end := day.AddDate(0, 0, 1)
+		if !end.After(now) {
+			return nil, fmt.Errorf("--auto-reply-until %s is in the past", until)
+		}
+		reply.Until = &end
+	}
+
+	offboard := &workflow.Offboard{
+		User:        user,
+		Name:        name,
+		Manager:     manager,
+		ImportLabel: "Offboarded/" + name,
+		AutoReply:   reply,
+	}
+	offboard.ImportLabels, _ = cmd.Flags().GetStringSlice("import-labels")
+	return offboard, nil
+}
+
+// offboardExportConfig sets up the export of the departing user's mailbox:
+// a legal hold export through the service account, with the labels of each
+// message recorded for the import
+func offboardExportConfig(cmd *cobra.Command, config *exporter.Config, offboard *workflow.Offboard) error {
+	keyFile, err := serviceAccountKeyFile(cmd)
+	if err != nil {
+		return err
+	}
+	config.ServiceAccountKey = keyFile
+	config.ImpersonateUser = offboard.User
+	config.Format = "eml"
+	config.LegalHold = true
+	config.GmailLabelsHeader = true
+	return nil
+}
+
+// vacationSettings returns the Gmail vacation responder settings of reply
+func vacationSettings(reply *workflow.AutoReply) *gmail.VacationSettings {
+	settings := &gmail.VacationSettings{
+		EnableAutoReply:       true,
+		ResponseSubject:       reply.Subject,
+		ResponseBodyPlainText: reply.Message,
+	}
+	if reply.Until != nil {
+		settings.EndTime = reply.Until.UnixMilli()
+	}
+	return settings
+}
+
+// runWorkflowAutoReply runs the auto_reply step, turning on the auto-reply
+// of the departing user's account
+func runWorkflowAutoReply(cmd *cobra.Command, state *workflow.State) error {
+	if state.Offboard == nil || state.Offboard.AutoReply == nil {
+		return fmt.Errorf("the workflow has no auto-reply to set")
+	}
+
+	keyFile, err := serviceAccountKeyFile(cmd)
+	if err != nil {
+		return err
+	}
+	serviceAccount, err := auth.NewServiceAccount(keyFile)
+	if err != nil {
+		return err
+	}
+	service, err := serviceAccount.GmailService(state.Offboard.User, gmail.GmailSettingsBasicScope)
+	if err != nil {
+		return err
+	}
+
+	if _, err := service.Users.Settings.UpdateVacation("me", vacationSettings(state.Offboard.AutoReply)).Do(); err != nil {
+		return fmt.Errorf("failed to set the auto-reply of %s: %w", state.Offboard.User, err)
+	}
+
+	fmt.Printf("Auto-reply set for %s\n", state.Offboard.User)
+	return nil
+}
+
+// runWorkflowManifest runs the manifest step, writing the signed compliance
+// manifest of the offboarding next to the workflow state
+func runWorkflowManifest(cmd *cobra.Command, state *workflow.State) error {
+	keyFile, _ := cmd.Flags().GetString("custody-key-file")
+	key, err := custody.LoadKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := workflow.NewOffboardManifest(state, version, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := custody.Sign(manifest, key); err != nil {
+		return err
+	}
+	if signingKey, _ := cmd.Flags().GetString("custody-signing-key"); signingKey != "" {
+		privateKey, err := signing.LoadPrivateKey(signingKey)
+		if err != nil {
+			return err
+		}
+		if err := custody.SignEd25519(manifest, privateKey); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(filepath.Dir(state.Path()), workflow.OffboardManifestFileName)
+	if err := manifest.Save(path); err != nil {
+		return err
+	}
+	state.Artifacts.ManifestFile = path
+	return nil
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestBuildOffboard(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	newCmd := func(flags map[string]string) *cobra.Command {
+		cmd := &cobra.Command{}
+		addOffboardFlags(cmd)
+		for name, value := range flags {
+			if err := cmd.Flags().Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return cmd
+	}
+
+	offboard, err := buildOffboard(newCmd(map[string]string{
+		"user":             "jane.doe@example.com",
+		"manager":          "boss@example.com",
+		"import-labels":    "Clients,Projects",
+		"auto-reply-until": "2024-06-30",
+	}), now)
+	if err != nil {
+		t.Fatalf("buildOffboard() error = %v", err)
+	}
+	if offboard.Name != "jane.doe" || offboard.ImportLabel != "Offboarded/jane.doe" || len(offboard.ImportLabels) != 2 {
+		t.Errorf("Unexpected offboarding %+v", offboard)
+	}
+	reply := offboard.AutoReply
+	if reply.Subject != defaultAutoReplySubject || reply.Message == "" {
+		t.Errorf("Expected the default auto-reply, got %+v", reply)
+	}
+	if want := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC); reply.Until == nil || !reply.Until.Equal(want) {
+		t.Errorf("Until = %v, want %v", reply.Until, want)
+	}
+	if settings := vacationSettings(reply); !settings.EnableAutoReply || settings.EndTime != reply.Until.UnixMilli() {
+		t.Errorf("Unexpected vacation settings %+v", settings)
+	}
+
+	tests := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{"no user", map[string]string{"manager": "boss@example.com"}},
+		{"no manager", map[string]string{"user": "jane@example.com"}},
+		{"invalid end", map[string]string{"user": "jane@example.com", "manager": "boss@example.com", "auto-reply-until": "June"}},
+		{"past end", map[string]string{"user": "jane@example.com", "manager": "boss@example.com", "auto-reply-until": "2024-04-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := buildOffboard(newCmd(tt.flags), now); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause EXPORT-DIR",
+	Short: "Pause a running export",
+	Long: `Pause the export running into EXPORT-DIR by creating its pause file. Workers
+finish the message they are exporting and wait until the export is unpaused, so
+the export stops using bandwidth and API quota without losing its progress.
+
+A running export can also be paused and unpaused by sending it SIGUSR1
+(not available on Windows).`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := filepath.Join(args[0], exporter.PauseFileName)
+		if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o600); err != nil {
+			return fmt.Errorf("failed to write pause file: %w", err)
+		}
+
+		fmt.Printf("Export in %s paused; run 'gmail-exporter unpause %s' to resume\n", args[0], args[0])
+		return nil
+	},
+}
+
+var unpauseCmd = &cobra.Command{
+	Use:   "unpause EXPORT-DIR",
+	Short: "Resume an export paused with pause",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := filepath.Join(args[0], exporter.PauseFileName)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove pause file: %w", err)
+		}
+
+		fmt.Printf("Export in %s resumed\n", args[0])
+		return nil
+	},
+}
+
+// pauseSwitch pauses and resumes the exporters of a run together when the
+// process receives the pause signal, and cancels them on termination
+type pauseSwitch struct {
+	mu        sync.Mutex
+	paused    bool
+	stopped   bool
+	exporters []*exporter.Exporter
+}
+
+// exportPauses holds the exporters of the running export command
+var exportPauses = &pauseSwitch{}
+
+// errExportInterrupted is returned when an export stopped on a termination
+// signal before it finished
+var errExportInterrupted = errors.New("export interrupted; run it again with --resume to continue")
+
+// add registers an exporter, pausing or cancelling it if the run is paused
+// or stopped
+func (s *pauseSwitch) add(exp *exporter.Exporter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exporters = append(s.exporters, exp)
+	if s.stopped {
+		exp.Cancel()
+	} else if s.paused {
+		exp.Pause()
+	}
+}
+
+// stop cancels the registered exporters and those added later
+func (s *pauseSwitch) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	for _, exp := range s.exporters {
+		exp.Cancel()
+	}
+}
+
+// isStopped reports whether the run was stopped
+func (s *pauseSwitch) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopped
+}
+
+// notifyStop stops s when the process is interrupted or terminated, so the
+// exporters save their progress before exiting. A second signal exits at
+// once. It returns a function that stops listening.
+func notifyStop(s *pauseSwitch) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			logrus.Info("Received termination signal; saving progress before exiting (send it again to exit at once)")
+			signal.Stop(signals)
+			s.stop()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// toggle pauses the registered exporters, or resumes them if paused
+func (s *pauseSwitch) toggle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused = !s.paused
+	for _, exp := range s.exporters {
+		if s.paused {
+			exp.Pause()
+		} else {
+			exp.Resume()
+		}
+	}
+
+	if s.paused {
+		logrus.Info("Received pause signal; send it again to resume")
+	} else {
+		logrus.Info("Received pause signal; resuming")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package cli
+
+// notifyPause is a no-op on Windows, which has no SIGUSR1; exports are
+// paused with the pause file only
+func notifyPause(s *pauseSwitch) func() {
+	return func() {}
+}
@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/hooks"
+	"github.com/octasoft-ltd/gmail-exporter/internal/workflow"
+)
+
+var workflowRunCmd = &cobra.Command{
+	Use:   "run PIPELINE.yaml",
+	Short: "Run a workflow defined in a YAML file",
+	Long: `Run the workflow defined in a pipeline file: its steps, filters, destination
+account, cleanup policy and notifications. Flags given on the command line take
+precedence over the file, so --resume and --dry-run work as with workflow.
+
+EXAMPLE PIPELINE:
+  name: acme-migration
+  steps: [export, import, cleanup]
+  output_dir: migration/acme
+  filters:
+    from: "@acme.com"
+    date_before: "2024-01-01"
+    has_attachment: true
+  import:
+    credentials: dest-creds.json
+    token: dest-token.json
+  cleanup:
+    action: archive
+  notifications:
+    - name: chat
+      url: https://chat.example.com/hooks/migrations
+      on: failure
+    - command: mail -s "acme migration finished" ops@example.com
+      on: success
+
+Notifications get the outcome as JSON: the pipeline name, whether it succeeded,
+the error, each step's status and the files produced. Commands also get
+GMAIL_EXPORTER_WORKFLOW and GMAIL_EXPORTER_WORKFLOW_STATUS (succeeded or failed).`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline(args[0])
+		if err != nil {
+			return err
+		}
+		if err := applyPipeline(pipeline, cmd); err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"pipeline": pipeline.Name,
+			"steps":    strings.Join(pipeline.Steps, ", "),
+		}).Info("Running pipeline")
+		state, runErr := runWorkflow(cmd, pipeline.Steps, nil)
+		if state != nil {
+			notifyPipeline(pipeline, state, runErr)
+		}
+		return runErr
+	},
+}
+
+func init() {
+	addWorkflowFlags(workflowRunCmd)
+}
+
+// loadPipeline reads and validates a pipeline file. A pipeline without a
+// name is named after its file.
+func loadPipeline(path string) (*workflow.Pipeline, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read pipeline: %w", err)
+	}
+
+	var pipeline workflow.Pipeline
+	if err := v.Unmarshal(&pipeline); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+	if pipeline.Name == "" {
+		pipeline.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := pipeline.Validate(); err != nil {
+		return nil, fmt.Errorf("pipeline %s: %w", pipeline.Name, err)
+	}
+	return &pipeline, nil
+}
+
+// applyPipeline sets the workflow flags from the pipeline, leaving those
+// given on the command line
+func applyPipeline(pipeline *workflow.Pipeline, cmd *cobra.Command) error {
+	filterFlags := &cobra.Command{}
+	addFilterFlags(filterFlags)
+
+	settings := &exportPreset{
+		Name:     pipeline.Name,
+		Settings: make(map[string][]string),
+		lists:    make(map[string]bool),
+	}
+	for key, value := range pipeline.Filters {
+		flagName := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if filterFlags.Flags().Lookup(flagName) == nil {
+			return fmt.Errorf("pipeline %s: unknown filter %q", pipeline.Name, key)
+		}
+		values, list, err := presetValues(value)
+		if err != nil {
+			return fmt.Errorf("pipeline %s filter %s: %w", pipeline.Name, key, err)
+		}
+		settings.Settings[flagName] = values
+		settings.lists[flagName] = list
+	}
+
+	for flagName, value := range map[string]string{
+		"output-dir":         pipeline.OutputDir,
+		"workflow-state":     pipeline.StateFile,
+		"import-credentials": pipeline.Import.Credentials,
+		"import-token":       pipeline.Import.Token,
+		"cleanup-action":     pipeline.Cleanup.Action,
+	} {
+		if value != "" {
+			settings.Settings[flagName] = []string{value}
+		}
+	}
+	for flagName, value := range map[string]int{
+		"parallel-workers": pipeline.ParallelWorkers,
+		"limit":            pipeline.Limit,
+	} {
+		if value > 0 {
+			settings.Settings[flagName] = []string{strconv.Itoa(value)}
+		}
+	}
+	for flagName, value := range map[string]bool{
+		"dry-run": pipeline.Cleanup.DryRun,
+		"yes":     pipeline.Cleanup.Yes,
+	} {
+		if value {
+			settings.Settings[flagName] = []string{"true"}
+		}
+	}
+
+	return settings.apply(cmd.Flags())
+}
+
+// notifyPipeline tells the pipeline's notifications how the run ended
+func notifyPipeline(pipeline *workflow.Pipeline, state *workflow.State, runErr error) {
+	runner := hooks.New(pipeline.NotificationHooks(runErr), filepath.Dir(state.Path()))
+	if runner == nil {
+		return
+	}
+	defer runner.Close()
+
+	status := "succeeded"
+	if runErr != nil {
+		status = "failed"
+	}
+	failures := runner.Notify(workflow.NewOutcome(pipeline.Name, state, runErr), []string{
+		"GMAIL_EXPORTER_WORKFLOW=" + pipeline.Name,
+		"GMAIL_EXPORTER_WORKFLOW_STATUS=" + status,
+	})
+	if failures > 0 {
+		fmt.Printf("Notification failures: %d (see log for details)\n", failures)
+	}
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestLoadAndApplyPipeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acme-migration.yaml")
+	pipeline := `steps: [export, cleanup]
+output_dir: migration/acme
+limit: 10
+filters:
+  from: "@acme.com"
+  date_before: "2024-01-01"
+  has_attachment: true
+  from_any: [a@acme.com, b@acme.com]
+cleanup:
+  action: delete
+  yes: true
+notifications:
+  - command: echo done
+    on: success
+`
+	if err := os.WriteFile(path, []byte(pipeline), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadPipeline(path)
+	if err != nil {
+		t.Fatalf("loadPipeline() error = %v", err)
+	}
+	if loaded.Name != "acme-migration" {
+		t.Errorf("Expected the pipeline to be named after its file, got %q", loaded.Name)
+	}
+	if strings.Join(loaded.Steps, ",") != "export,cleanup" {
+		t.Errorf("Expected steps export,cleanup, got %v", loaded.Steps)
+	}
+
+	cmd := &cobra.Command{}
+	addWorkflowFlags(cmd)
+	if err := cmd.Flags().Set("limit", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPipeline(loaded, cmd); err != nil {
+		t.Fatalf("applyPipeline() error = %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"output-dir":     "migration/acme",
+		"from":           "@acme.com",
+		"date-before":    "2024-01-01",
+		"has-attachment": "true",
+		"from-any":       "[a@acme.com,b@acme.com]",
+		"cleanup-action": "delete",
+		"yes":            "true",
+		"limit":          "2", // given on the command line
+	} {
+		if got := cmd.Flags().Lookup(name).Value.String(); got != want {
+			t.Errorf("--%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyPipelineUnknownFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.yaml")
+	if err := os.WriteFile(path, []byte("filters:\n  sender: x@example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadPipeline(path)
+	if err != nil {
+		t.Fatalf("loadPipeline() error = %v", err)
+	}
+	cmd := &cobra.Command{}
+	addWorkflowFlags(cmd)
+	if err := applyPipeline(loaded, cmd); err == nil || !strings.Contains(err.Error(), "unknown filter") {
+		t.Errorf("Expected an unknown filter error, got %v", err)
+	}
+}
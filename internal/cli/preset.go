@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// exportPreset is a named set of export flags defined under "presets" in the
+// config file
+type exportPreset struct {
+	Name        string
+	Description string
+	// Settings maps export flag names to their values
+	Settings map[string][]string
+	// lists marks settings given as YAML lists
+	lists map[string]bool
+}
+
+var presetCmd = &cobra.Command{
+	Use:   "preset",
+	Short: "List and inspect export presets",
+	Long: `Export presets are named combinations of filters, format and output settings
+defined under "presets" in the config file and run with 'export --preset NAME'.
+Each setting is an export flag name (dashes or underscores), for example:
+
+  presets:
+    yearly-backup:
+      description: "Last year of mail, organized by label"
+      date_within: 1y
+      organize_by_labels: true
+      output_dir: ./backups/yearly
+    big-attachments:
+      has_attachment: true
+      size_greater_than: 10MB
+      skip_labels: [CATEGORY_PROMOTIONS]
+
+Flags given on the command line take precedence over the preset.`,
+}
+
+var presetListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the export presets in the config file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		presets, err := loadPresets()
+		if err != nil {
+			return err
+		}
+		if len(presets) == 0 {
+			fmt.Println("No presets defined under presets in the config file")
+			return nil
+		}
+
+		for _, preset := range presets {
+			if preset.Description != "" {
+				fmt.Printf("%-24s %s\n", preset.Name, preset.Description)
+			} else {
+				fmt.Println(preset.Name)
+			}
+		}
+		return nil
+	},
+}
+
+var presetShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show the settings of an export preset",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		preset, err := findPreset(args[0])
+		if err != nil {
+			return err
+		}
+		if err := preset.validate(exportCmd.Flags()); err != nil {
+			return err
+		}
+
+		fmt.Printf("Preset: %s\n", preset.Name)
+		if preset.Description != "" {
+			fmt.Printf("Description: %s\n", preset.Description)
+		}
+		for _, name := range preset.flagNames() {
+			fmt.Printf("  --%s = %s\n", name, strings.Join(preset.Settings[name], ","))
+		}
+		fmt.Printf("Equivalent to: gmail-exporter export %s\n", preset.commandLine())
+		return nil
+	},
+}
+
+func init() {
+	presetCmd.AddCommand(presetListCmd)
+	presetCmd.AddCommand(presetShowCmd)
+}
+
+// loadPresets returns the presets defined in the config file, sorted by name
+func loadPresets() ([]exportPreset, error) {
+	configured := viper.GetStringMap("presets")
+
+	presets := make([]exportPreset, 0, len(configured))
+	for name, raw := range configured {
+		settings, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("preset %q must be a map of export settings", name)
+		}
+
+		preset := exportPreset{
+			Name:     name,
+			Settings: make(map[string][]string),
+			lists:    make(map[string]bool),
+		}
+		for key, value := range settings {
+			if key == "description" {
+				preset.Description = fmt.Sprint(value)
+				continue
+			}
+
+			flagName := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+			values, list, err := presetValues(value)
+			if err != nil {
+				return nil, fmt.Errorf("preset %q setting %s: %w", name, key, err)
+			}
+			preset.Settings[flagName] = values
+			preset.lists[flagName] = list
+		}
+		presets = append(presets, preset)
+	}
+
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets, nil
+}
+
+// findPreset returns the named preset from the config file
+func findPreset(name string) (*exportPreset, error) {
+	presets, err := loadPresets()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range presets {
+		if presets[i].Name == strings.ToLower(name) {
+			return &presets[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("preset %q is not defined under presets in the config file", name)
+}
+
+// presetValues converts a YAML setting to flag values, reporting whether it
+// was given as a list
+func presetValues(value any) ([]string, bool, error) {
+	switch v := value.(type) {
+	case string, bool, int, int64, float64:
+		return []string{fmt.Sprint(v)}, false, nil
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case string, bool, int, int64, float64:
+				values = append(values, fmt.Sprint(item))
+			default:
+				return nil, false, fmt.Errorf("unsupported list item %v", item)
+			}
+		}
+		return values, true, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// flagNames returns the preset's flag names in order
+func (p *exportPreset) flagNames() []string {
+	names := make([]string, 0, len(p.Settings))
+	for name := range p.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validate checks that every setting names a flag of flags that a preset
+// may set
+func (p *exportPreset) validate(flags *pflag.FlagSet) error {
+	for _, name := range p.flagNames() {
+		if name == "preset" || flags.Lookup(name) == nil {
+			return fmt.Errorf("preset %q: unknown export setting %q", p.Name, name)
+		}
+	}
+	return nil
+}
+
+// apply sets the flags of the preset that were not given on the command line
+func (p *exportPreset) apply(flags *pflag.FlagSet) error {
+	if err := p.validate(flags); err != nil {
+		return err
+	}
+
+	for _, name := range p.flagNames() {
+		flag := flags.Lookup(name)
+		if flag.Changed {
+			continue
+		}
+
+		values := p.Settings[name]
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			if err := slice.Replace(values); err != nil {
+				return fmt.Errorf("preset %q: invalid %s: %w", p.Name, name, err)
+			}
+			flag.Changed = true
+			continue
+		}
+
+		if p.lists[name] {
+			return fmt.Errorf("preset %q: %s takes a single value", p.Name, name)
+		}
+		if err := flags.Set(name, values[0]); err != nil {
+			return fmt.Errorf("preset %q: invalid %s: %w", p.Name, name, err)
+		}
+	}
+
+	return nil
+}
+
+// commandLine returns the export flags equivalent to the preset
+func (p *exportPreset) commandLine() string {
+	var args []string
+	for _, name := range p.flagNames() {
+		for _, value := range p.Settings[name] {
+			args = append(args, fmt.Sprintf("--%s=%s", name, quoteArg(value)))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// quoteArg quotes a command-line argument for the shell when needed
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"$`\\*?[]{}()<>|&;#~!") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
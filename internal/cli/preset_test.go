@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestPresets(t *testing.T) {
+	viper.Set("presets", map[string]interface{}{
+		"yearly-backup": map[string]interface{}{
+			"description":        "Last year, by label",
+			"date_within":        "1y",
+			"organize_by_labels": true,
+			"output_dir":         "./backups/yearly",
+			"skip_labels":        []interface{}{"CATEGORY_PROMOTIONS", "SPAM"},
+		},
+		"big-attachments": map[string]interface{}{
+			"has-attachment":    true,
+			"size_greater_than": "10MB",
+		},
+		"broken": map[string]interface{}{
+			"no_such_flag": "x",
+		},
+	})
+	defer viper.Set("presets", nil)
+
+	presets, err := loadPresets()
+	if err != nil {
+		t.Fatalf("loadPresets() error = %v", err)
+	}
+	if len(presets) != 3 || presets[0].Name != "big-attachments" || presets[2].Name != "yearly-backup" {
+		t.Fatalf("Expected presets sorted by name, got %+v", presets)
+	}
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("date-within", "", "")
+	cmd.Flags().Bool("organize-by-labels", false, "")
+	cmd.Flags().String("output-dir", "", "")
+	cmd.Flags().StringSlice("skip-labels", nil, "")
+	if err := cmd.Flags().Set("output-dir", "cli-dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	preset, err := findPreset("yearly-backup")
+	if err != nil {
+		t.Fatalf("findPreset() error = %v", err)
+	}
+	if preset.Description != "Last year, by label" {
+		t.Errorf("Unexpected description %q", preset.Description)
+	}
+	if err := preset.apply(cmd.Flags()); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+
+	if dateWithin, _ := cmd.Flags().GetString("date-within"); dateWithin != "1y" {
+		t.Errorf("Expected date-within 1y, got %q", dateWithin)
+	}
+	if organize, _ := cmd.Flags().GetBool("organize-by-labels"); !organize {
+		t.Error("Expected organize-by-labels from the preset")
+	}
+	if skipLabels, _ := cmd.Flags().GetStringSlice("skip-labels"); len(skipLabels) != 2 || skipLabels[1] != "SPAM" {
+		t.Errorf("Expected skip-labels from the preset, got %v", skipLabels)
+	}
+	// The command line takes precedence
+	if outputDir, _ := cmd.Flags().GetString("output-dir"); outputDir != "cli-dir" {
+		t.Errorf("Expected output-dir from the command line, got %q", outputDir)
+	}
+
+	expected := "--date-within=1y --organize-by-labels=true --output-dir=./backups/yearly " +
+		"--skip-labels=CATEGORY_PROMOTIONS --skip-labels=SPAM"
+	if line := preset.commandLine(); line != expected {
+		t.Errorf("Expected command line %q, got %q", expected, line)
+	}
+
+	broken, err := findPreset("broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broken.apply(cmd.Flags()); err == nil {
+		t.Error("Expected error for an unknown setting")
+	}
+
+	if _, err := findPreset("missing"); err == nil {
+		t.Error("Expected error for an undefined preset")
+	}
+}
+
+func TestPresetValues(t *testing.T) {
+	if values, list, err := presetValues(3); err != nil || list || values[0] != "3" {
+		t.Errorf("presetValues(3) = %v, %v, %v", values, list, err)
+	}
+	if _, _, err := presetValues(map[string]interface{}{"a": 1}); err == nil {
+		t.Error("Expected error for a nested map")
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	tests := map[string]string{
+		"1y":            "1y",
+		"./out":         "./out",
+		"has words":     "'has words'",
+		"it's":          `'it'\''s'`,
+		"":              "''",
+		"from:a@b.com":  "from:a@b.com",
+		"subject:(x y)": "'subject:(x y)'",
+	}
+	for arg, expected := range tests {
+		if quoted := quoteArg(arg); quoted != expected {
+			t.Errorf("quoteArg(%q) = %s, want %s", arg, quoted, expected)
+		}
+	}
+}
@@ -4,10 +4,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/audit"
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/console"
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+	"github.com/octasoft-ltd/gmail-exporter/internal/orchestrator"
 )
 
 var (
@@ -16,6 +24,9 @@ var (
 	logFile  string
 	verbose  bool
 
+	// apiHeaders are the --api-header lines
+	apiHeaders []string
+
 	// Version information
 	version = "dev"
 	commit  = "unknown"
@@ -39,14 +50,24 @@ Features:
 - Comprehensive metrics in JSON and Prometheus formats
 - Progress tracking and resumable operations
 - Parallel and serial processing options`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		initLogging()
+		return initNetwork()
 	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
+// The --summary-file of the command run is written once it returns.
 func Execute() error {
-	return rootCmd.Execute()
+	started := time.Now()
+	cmd, err := rootCmd.ExecuteC()
+	if summaryErr := writeSummary(cmd, started, err); summaryErr != nil {
+		if err == nil {
+			return summaryErr
+		}
+		logrus.WithError(summaryErr).Warn("Failed to write summary file")
+	}
+	return err
 }
 
 // SetVersion sets the version information
@@ -64,6 +85,12 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "log file path (default: stderr)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().String("auth-mode", "auto", "authentication mode (auto, oauth, adc)")
+	rootCmd.PersistentFlags().String("summary-file", "", "write the outcome, flags and version of the run to this JSON file when the command finishes")
+	rootCmd.PersistentFlags().String("api-endpoint", "", "Gmail API endpoint, such as a corporate API gateway or a mock server (default: https://gmail.googleapis.com/)")
+	rootCmd.PersistentFlags().String("audit-log", "", "append-only log of the messages imported, archived, labeled and deleted (default is $HOME/.gmail-exporter/audit.jsonl)")
+	rootCmd.PersistentFlags().String("audit-signing-key", "", "Ed25519 private key (PEM) signing the audit log entries")
+	rootCmd.PersistentFlags().StringArrayVar(&apiHeaders, "api-header", nil, "Header added to every Gmail API request, as \"Name: value\" (repeatable), such as an API gateway key")
 
 	// Bind flags to viper
 	if err := viper.BindPFlag("log_level", rootCmd.PersistentFlags().Lookup("log-level")); err != nil {
@@ -75,16 +102,51 @@ func init() {
 	if err := viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose")); err != nil {
 		logrus.WithError(err).Fatal("Failed to bind verbose flag")
 	}
+	if err := viper.BindPFlag("auth_mode", rootCmd.PersistentFlags().Lookup("auth-mode")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind auth-mode flag")
+	}
+	if err := viper.BindPFlag("summary_file", rootCmd.PersistentFlags().Lookup("summary-file")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind summary-file flag")
+	}
+	if err := viper.BindPFlag("audit_log", rootCmd.PersistentFlags().Lookup("audit-log")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind audit-log flag")
+	}
+	if err := viper.BindPFlag("audit_signing_key", rootCmd.PersistentFlags().Lookup("audit-signing-key")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind audit-signing-key flag")
+	}
+	if err := viper.BindPFlag("api_endpoint", rootCmd.PersistentFlags().Lookup("api-endpoint")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind api-endpoint flag")
+	}
 
 	// Add subcommands
 	rootCmd.AddCommand(authCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(labelsCmd)
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(deliveryReportCmd)
+	rootCmd.AddCommand(analyzeCmd)
+	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(catalogCmd)
+	rootCmd.AddCommand(custodyCmd)
+	rootCmd.AddCommand(snapshotsCmd)
+	rootCmd.AddCommand(guiCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(jobsCmd)
+	rootCmd.AddCommand(presetCmd)
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(unpauseCmd)
+	rootCmd.AddCommand(metricsCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(settingsCmd)
 	rootCmd.AddCommand(workflowCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(generateFilterCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(mockServerCmd)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -109,8 +171,13 @@ func initConfig() {
 	// Set default values
 	viper.SetDefault("credentials_file", filepath.Join(os.Getenv("HOME"), ".gmail-exporter", "credentials.json"))
 	viper.SetDefault("token_file", filepath.Join(os.Getenv("HOME"), ".gmail-exporter", "token.json"))
+	viper.SetDefault("jobs_db", filepath.Join(os.Getenv("HOME"), ".gmail-exporter", orchestrator.DefaultStoreFileName))
+	viper.SetDefault("audit_log", filepath.Join(os.Getenv("HOME"), ".gmail-exporter", audit.DefaultFileName))
 	viper.SetDefault("output_dir", "./exports")
-	viper.SetDefault("parallel_workers", 3)
+	viper.SetDefault("parallel_workers", 0) // 0 = auto
+	viper.SetDefault("max_qps", 0)
+	viper.SetDefault("checkpoint_every", 500)
+	viper.SetDefault("checkpoint_interval", "1m")
 	viper.SetDefault("organize_by_labels", false)
 	viper.SetDefault("filters.exclude_chats", true)
 	viper.SetDefault("filters.search_scope", "all_mail")
@@ -146,7 +213,9 @@ func initLogging() {
 		ForceColors:   true,
 	})
 
-	// Set log output
+	// Set log output. Terminal logs go through the console so they do not
+	// break up progress lines.
+	logrus.SetOutput(console.Default().LogWriter())
 	logFile := viper.GetString("log_file")
 	if logFile != "" {
 		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
@@ -158,6 +227,68 @@ func initLogging() {
 	}
 }
 
+// initNetwork sets up the proxy, extra CA certificates and connection pool
+// of the HTTP client used for authentication and API calls
+func initNetwork() error {
+	config := httpclient.Config{
+		ProxyURL:            viper.GetString("proxy_url"),
+		CACerts:             viper.GetStringSlice("ca_certs"),
+		MaxIdleConnsPerHost: viper.GetInt("http.max_idle_conns_per_host"),
+		DisableHTTP2:        viper.GetBool("http.disable_http2"),
+		KeepAlive:           viper.GetDuration("http.keep_alive"),
+		IdleConnTimeout:     viper.GetDuration("http.idle_conn_timeout"),
+	}
+	if err := httpclient.Configure(config); err != nil {
+		return fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if err := initAPIEndpoint(); err != nil {
+		return err
+	}
+	if config.ProxyURL != "" || len(config.CACerts) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"proxy":    config.ProxyURL != "",
+			"ca_certs": config.CACerts,
+		}).Debug("Using custom network configuration")
+	}
+	return nil
+}
+
+// initAPIEndpoint points the Gmail API client at the endpoint of
+// --api-endpoint, such as a corporate API gateway, with the headers of
+// --api-header and the api_headers config section
+func initAPIEndpoint() error {
+	endpoint := viper.GetString("api_endpoint")
+	if err := auth.SetAPIEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	headers, err := auth.ParseAPIHeaders(apiHeaders)
+	if err != nil {
+		return err
+	}
+	for name, value := range viper.GetStringMapString("api_headers") {
+		if headers.Get(name) == "" {
+			headers.Set(name, value)
+		}
+	}
+	if err := auth.SetAPIHeaders(headers); err != nil {
+		return err
+	}
+
+	if endpoint != "" || len(headers) > 0 {
+		names := make([]string, 0, len(headers))
+		for name := range headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		logrus.WithFields(logrus.Fields{
+			"endpoint": endpoint,
+			"headers":  names,
+		}).Info("Using a custom Gmail API endpoint")
+	}
+	return nil
+}
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number",
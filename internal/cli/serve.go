@@ -0,0 +1,270 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/health"
+	"github.com/octasoft-ltd/gmail-exporter/internal/orchestrator"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a gRPC API to start, watch and cancel export jobs",
+	Long: `Serve the JobService gRPC API (api/jobs/v1/jobs.proto) so orchestration systems
+can drive exports programmatically: StartExport queues an export, GetJobStatus and
+StreamProgress report its progress, and CancelJob stops it.
+
+Jobs are saved to the jobs database (--jobs-db), so a restarted server still
+reports them and queues again the exports it had not finished, resuming those it
+was running. 'gmail-exporter jobs list|show|cancel' manage the jobs of a running
+server.
+
+Jobs export with the settings of the config file. A job's account selects a
+profile from the accounts section, so one server can export many mailboxes; at
+most --max-jobs exports run at a time and the rest are queued. A cancelled
+export keeps what it exported and can be resumed with resume set.
+
+The API listens on the loopback interface by default. Callers are authenticated
+with client certificates issued by the CAs of --tls-client-ca, or with the bearer
+token of --auth-token-file, which 'jobs' sends with its own --auth-token-file. On
+other interfaces serve refuses to start without TLS (--tls-cert and --tls-key) and
+one of the two. On Ctrl+C or SIGTERM running jobs
+stop after their messages in flight, saving their progress, and the server exits;
+they stay queued in the jobs database for the next start.
+
+--health-listen serves /healthz (liveness) and /readyz (readiness) over HTTP for
+Kubernetes probes. /readyz fails until the server accepts jobs and again once it
+starts shutting down.
+
+EXAMPLES:
+  gmail-exporter serve
+  gmail-exporter serve --listen :50051 --tls-cert server.crt --tls-key server.key --tls-client-ca clients-ca.crt --max-jobs 8
+  gmail-exporter serve --listen :50051 --tls-cert server.crt --tls-key server.key --auth-token-file jobs.token
+  gmail-exporter serve --listen :50051 --health-listen :8080`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address, _ := cmd.Flags().GetString("listen")
+		maxJobs, _ := cmd.Flags().GetInt("max-jobs")
+		if maxJobs < 1 {
+			return fmt.Errorf("max jobs must be at least 1")
+		}
+		options, err := serveSecurity(cmd, address)
+		if err != nil {
+			return err
+		}
+
+		probe := health.New()
+		if healthAddress, _ := cmd.Flags().GetString("health-listen"); healthAddress != "" {
+			stopProbe, err := probe.Start(healthAddress)
+			if err != nil {
+				return err
+			}
+			defer stopProbe()
+		}
+
+		store, err := orchestrator.OpenStore(viper.GetString("jobs_db"))
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+
+		jobs := orchestrator.New(runJobExport, store, orchestrator.Defaults{
+			SearchScope: viper.GetString("filters.search_scope"),
+		}, maxJobs)
+		if err := jobs.Restore(); err != nil {
+			return fmt.Errorf("failed to restore jobs: %w", err)
+		}
+		server := grpc.NewServer(options...)
+		jobsv1.RegisterJobServiceServer(server, jobs)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			logrus.Info("Stopping job server; running jobs are requeued on the next start")
+			probe.ShuttingDown()
+			jobs.Shutdown()
+			server.GracefulStop()
+		}()
+
+		probe.SetReady()
+		fmt.Printf("Gmail Exporter job API listening on %s (press Ctrl+C to stop)\n", listener.Addr())
+		return server.Serve(listener)
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("listen", "127.0.0.1:50051", "Address to serve the gRPC API on")
+	serveCmd.Flags().Int("max-jobs", 4, "Maximum number of exports to run at a time")
+	serveCmd.Flags().String("tls-cert", "", "TLS certificate file (enables TLS with --tls-key)")
+	serveCmd.Flags().String("tls-key", "", "TLS private key file")
+	serveCmd.Flags().String("tls-client-ca", "", "CA certificate that client certificates must be issued by (requires TLS)")
+	serveCmd.Flags().String("auth-token-file", "", "File holding the bearer token callers must send")
+	serveCmd.Flags().String("jobs-db", "", "Jobs database (default: ~/.gmail-exporter/jobs.db)")
+	serveCmd.Flags().String("health-listen", "", "Address to serve /healthz and /readyz on (e.g. :8080; empty = disabled)")
+
+	if err := viper.BindPFlag("jobs_db", serveCmd.Flags().Lookup("jobs-db")); err != nil {
+		logrus.WithError(err).Fatal("Failed to bind jobs-db flag")
+	}
+}
+
+// serveSecurity returns the server options securing the job API served on
+// address: TLS, client certificates and a bearer token as the flags ask.
+// Serving on other than the loopback interface requires TLS and either
+// client certificates or a token.
+func serveSecurity(cmd *cobra.Command, address string) ([]grpc.ServerOption, error) {
+	certFile, _ := cmd.Flags().GetString("tls-cert")
+	keyFile, _ := cmd.Flags().GetString("tls-key")
+	clientCAFile, _ := cmd.Flags().GetString("tls-client-ca")
+	tokenFile, _ := cmd.Flags().GetString("auth-token-file")
+
+	switch {
+	case (certFile == "") != (keyFile == ""):
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	case clientCAFile != "" && certFile == "":
+		return nil, fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+	case !isLoopbackAddress(address) && certFile == "":
+		return nil, fmt.Errorf("serving the job API on %s requires TLS (--tls-cert and --tls-key)", address)
+	case !isLoopbackAddress(address) && clientCAFile == "" && tokenFile == "":
+		return nil, fmt.Errorf("serving the job API on %s requires authentication (--tls-client-ca or --auth-token-file)", address)
+	}
+
+	var options []grpc.ServerOption
+	if certFile != "" {
+		creds, err := orchestrator.ServerTLS(certFile, keyFile, clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	if tokenFile != "" {
+		token, err := readAuthToken(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, orchestrator.TokenAuth(token)...)
+	}
+	return options, nil
+}
+
+// readAuthToken reads the bearer token of the job API from path
+func readAuthToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read auth token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("auth token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// isLoopbackAddress reports whether a listen address only accepts local
+// connections
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runJobExport runs an export job with the settings of the config file,
+// cancelling the export when ctx is cancelled
+func runJobExport(ctx context.Context, request *jobsv1.StartExportRequest, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error) {
+	config := &exporter.Config{
+		CredentialsFile:    viper.GetString("credentials_file"),
+		TokenFile:          viper.GetString("token_file"),
+		AuthMode:           viper.GetString("auth_mode"),
+		OutputDir:          request.GetOutputDir(),
+		OrganizeByLabels:   request.GetOrganizeByLabels(),
+		ParallelWorkers:    viper.GetInt("parallel_workers"),
+		MaxQPS:             viper.GetFloat64("max_qps"),
+		AdaptiveWorkers:    viper.GetBool("adaptive_workers"),
+		QuotaBudget:        viper.GetInt("quota_budget"),
+		DefaultCharset:     viper.GetString("default_charset"),
+		IncludeAttachments: true,
+		Format:             request.GetFormat(),
+		Limit:              int(request.GetLimit()),
+		Resume:             request.GetResume(),
+		CheckpointEvery:    viper.GetInt("checkpoint_every"),
+		CheckpointInterval: viper.GetDuration("checkpoint_interval"),
+		RunHistory:         viper.GetBool("metrics.run_history"),
+		GmailLabelsHeader:  viper.GetBool("gmail_labels_header"),
+		Version:            version,
+		Events:             events,
+	}
+	if config.Format == "" {
+		config.Format = "eml"
+	}
+
+	if request.GetAccount() != "" {
+		profiles, err := loadAccountProfiles([]string{request.GetAccount()})
+		if err != nil {
+			return nil, err
+		}
+		config.CredentialsFile = profiles[0].CredentialsFile
+		config.TokenFile = profiles[0].TokenFile
+	} else {
+		// Additional OAuth clients belong to the default mailbox
+		clients, err := loadOAuthClients()
+		if err != nil {
+			return nil, err
+		}
+		config.OAuthClients = clients
+	}
+
+	retryPolicies, err := loadRetryPolicies()
+	if err != nil {
+		return nil, err
+	}
+	config.Retry = retryPolicies
+	if config.Hooks, err = loadHooks(); err != nil {
+		return nil, err
+	}
+
+	exp, err := exporter.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			exp.Cancel()
+		case <-done:
+		}
+	}()
+
+	result, err := exp.Export(filterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("export failed: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/sendas"
+)
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "Export and import mailbox settings such as signatures",
+}
+
+var settingsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the send-as addresses of the mailbox with their signatures",
+	Long: `Export the send-as addresses of the mailbox to a JSON file: the primary address
+and every alias, with its display name, reply-to address, HTML signature and
+whether it is the default. Import the file into another account with
+'settings import'.
+
+The passwords of aliases that send through another SMTP server are never
+returned by Gmail, so such aliases must be set up again by hand.
+
+With --user, the mailbox of a Workspace user is read through a service account
+with domain-wide delegation for the gmail.readonly scope.
+
+EXAMPLES:
+  gmail-exporter settings export --output jane-send-as.json
+  gmail-exporter settings export --user jane@example.com --service-account-key sa.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		service, _, err := settingsService(cmd, auth.AccessReadonly, gmail.GmailReadonlyScope)
+		if err != nil {
+			return err
+		}
+
+		export, err := sendas.Fetch(service)
+		if err != nil {
+			return err
+		}
+		output, _ := cmd.Flags().GetString("output")
+		if err := export.Save(output); err != nil {
+			return err
+		}
+
+		fmt.Printf("Exported %d send-as addresses of %s (%d with a signature) to %s\n",
+			len(export.SendAs), export.Account, export.Signatures(), output)
+		for _, alias := range export.SendAs {
+			if alias.SmtpMsa != nil {
+				fmt.Printf("! %s sends through %s; its password cannot be exported\n", alias.SendAsEmail, alias.SmtpMsa.Host)
+			}
+		}
+		return nil
+	},
+}
+
+var settingsImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Apply exported send-as addresses and signatures to the mailbox",
+	Long: `Apply a file written by 'settings export' to the mailbox: the display name,
+reply-to address and signature of the exported primary address go to the
+primary address of the mailbox, even when the two addresses differ, and every
+other address to the same address of the mailbox.
+
+Gmail lets an OAuth token change only the primary address, and only with the
+settings access of 'auth login --scope settings', which is asked for when
+missing. Other addresses are reported as skipped. To also create the missing
+aliases and change existing ones, import through a service account with
+domain-wide delegation for the gmail.settings.basic and gmail.settings.sharing
+scopes with --user. Gmail sends a verification email to aliases outside the
+account's domain, which are pending until it is followed.
+
+EXAMPLES:
+  gmail-exporter settings import jane-send-as.json --dry-run
+  gmail-exporter settings import jane-send-as.json --import-token dest-token.json
+  gmail-exporter settings import jane-send-as.json --user jane@example.com --service-account-key sa.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		export, err := sendas.Load(args[0])
+		if err != nil {
+			return err
+		}
+
+		service, delegated, err := settingsService(cmd, auth.AccessSettings, gmail.GmailSettingsBasicScope, gmail.GmailSettingsSharingScope)
+		if err != nil {
+			return err
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		results, err := sendas.Import(service, export, sendas.Options{DryRun: dryRun, Delegated: delegated})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Send-as addresses of %s exported %s:\n", export.Account, export.ExportedAt.Local().Format("2006-01-02 15:04"))
+		for _, result := range results {
+			line := fmt.Sprintf("  %-9s %s", result.Action, result.Address)
+			if !strings.EqualFold(result.Source, result.Address) {
+				line += " (from " + result.Source + ")"
+			}
+			if len(result.Changed) > 0 {
+				line += ": " + strings.Join(result.Changed, ", ")
+			}
+			if result.Detail != "" {
+				line += " - " + result.Detail
+			}
+			fmt.Println(line)
+		}
+		if dryRun {
+			fmt.Println("Dry run: nothing was changed")
+		}
+
+		if failed := sendas.Failed(results); failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d send-as addresses failed to import", failed)
+		}
+		return nil
+	},
+}
+
+// settingsService returns a Gmail service of the mailbox whose settings are
+// exported or imported: that of --user through the service account with
+// scopes, which reports delegated, or that of the account's token for
+// access
+func settingsService(cmd *cobra.Command, access auth.Access, scopes ...string) (service *gmail.Service, delegated bool, err error) {
+	if user, _ := cmd.Flags().GetString("user"); user != "" {
+		keyFile, err := serviceAccountKeyFile(cmd)
+		if err != nil {
+			return nil, false, err
+		}
+		serviceAccount, err := auth.NewServiceAccount(keyFile)
+		if err != nil {
+			return nil, false, err
+		}
+		service, err := serviceAccount.GmailService(user, scopes...)
+		if err != nil {
+			return nil, false, err
+		}
+		return service, true, nil
+	}
+
+	credentialsFile, tokenFile, err := resolveAccountFiles(cmd)
+	if err != nil {
+		return nil, false, err
+	}
+	if credentials, _ := cmd.Flags().GetString("import-credentials"); credentials != "" {
+		credentialsFile = credentials
+	}
+	if token, _ := cmd.Flags().GetString("import-token"); token != "" {
+		tokenFile = token
+	}
+
+	_, service, err = auth.NewGmailService(viper.GetString("auth_mode"), access, credentialsFile, tokenFile)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+	return service, false, nil
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{settingsExportCmd, settingsImportCmd} {
+		cmd.Flags().String("account", "", "Account profile from the accounts section of the config file")
+		cmd.Flags().String("user", "", "Workspace user whose mailbox is used through the service account")
+		cmd.Flags().String("service-account-key", "", "Service account JSON key with domain-wide delegation, for --user")
+	}
+
+	settingsExportCmd.Flags().StringP("output", "o", sendas.DefaultFileName, "File to write the send-as addresses to")
+
+	settingsImportCmd.Flags().String("import-credentials", "", "Gmail API credentials file for the destination account (defaults to main credentials)")
+	settingsImportCmd.Flags().String("import-token", "", "OAuth token file for the destination account (defaults to main token)")
+	settingsImportCmd.Flags().Bool("dry-run", false, "Show what would change without changing anything")
+
+	settingsCmd.AddCommand(settingsExportCmd)
+	settingsCmd.AddCommand(settingsImportCmd)
+}
@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/snapshot"
+)
+
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "Manage the snapshots of a versioned backup",
+	Long: `Commands for the snapshot directories written by export --snapshot. Each run
+writes a new snapshot named after its start time (UTC) under the output directory,
+hardlinking the messages of the previous snapshot instead of downloading them
+again, so every snapshot is a complete export that only takes up the space of the
+messages that are new in it.
+
+EXAMPLES:
+  gmail-exporter export --snapshot --output-dir ./backup
+  gmail-exporter snapshots list ./backup
+  gmail-exporter snapshots prune ./backup --keep-daily 7 --keep-weekly 4 --keep-monthly 12`,
+}
+
+var snapshotsListCmd = &cobra.Command{
+	Use:   "list BACKUP-DIR",
+	Short: "List the snapshots of a backup, oldest first",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshots, err := snapshot.List(args[0])
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			fmt.Printf("No snapshots in %s\n", args[0])
+			return nil
+		}
+		for _, s := range snapshots {
+			status := ""
+			if !s.Exported {
+				status = " (nothing exported)"
+			}
+			fmt.Printf("%s  %s%s\n", s.Name, s.Time.Local().Format("2006-01-02 15:04:05 MST"), status)
+		}
+		return nil
+	},
+}
+
+var snapshotsPruneCmd = &cobra.Command{
+	Use:   "prune BACKUP-DIR",
+	Short: "Remove the snapshots outside the retention policy",
+	Long: `Remove the snapshots of a backup that the retention policy does not keep. The
+newest snapshot of each of the last --keep-daily days, --keep-weekly ISO weeks and
+--keep-monthly months is kept (in UTC, like the snapshot names), counting only
+snapshots that exported messages, so failed runs never push out a good backup.
+The newest snapshot, the newest one that exported messages and snapshots under
+legal hold are always kept.
+
+Messages are only deleted from disk once no kept snapshot links to them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var policy snapshot.Policy
+		policy.Daily, _ = cmd.Flags().GetInt("keep-daily")
+		policy.Weekly, _ = cmd.Flags().GetInt("keep-weekly")
+		policy.Monthly, _ = cmd.Flags().GetInt("keep-monthly")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		kept, removed, err := snapshot.Prune(args[0], policy, dryRun)
+		for _, s := range removed {
+			if dryRun {
+				fmt.Printf("Would remove %s\n", s.Name)
+			} else {
+				fmt.Printf("Removed %s\n", s.Name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		recordResult(map[string]int{"kept": len(kept), "removed": len(removed)})
+
+		fmt.Printf("Kept %d snapshots, removed %d\n", len(kept), len(removed))
+		return nil
+	},
+}
+
+func init() {
+	snapshotsPruneCmd.Flags().Int("keep-daily", 0, "Keep the newest snapshot of each of this many days")
+	snapshotsPruneCmd.Flags().Int("keep-weekly", 0, "Keep the newest snapshot of each of this many ISO weeks")
+	snapshotsPruneCmd.Flags().Int("keep-monthly", 0, "Keep the newest snapshot of each of this many months")
+	snapshotsPruneCmd.Flags().Bool("dry-run", false, "List the snapshots that would be removed without removing them")
+
+	snapshotsCmd.AddCommand(snapshotsListCmd)
+	snapshotsCmd.AddCommand(snapshotsPruneCmd)
+}
+
+// startSnapshot points an export with --snapshot at a new snapshot directory
+// under its output directory, linking against the previous snapshot. The
+// metadata cache and pause file stay in the backup directory, shared by all
+// snapshots.
+func startSnapshot(config *exporter.Config, now time.Time) error {
+	if config.Resume {
+		return fmt.Errorf("--snapshot cannot be combined with --resume; the next snapshot links what an interrupted one exported")
+	}
+
+	base := config.OutputDir
+	dir, previous, err := snapshot.New(base, now)
+	if err != nil {
+		return err
+	}
+	config.OutputDir = dir
+	config.LinkDest = previous
+
+	if config.MetadataCache == "" {
+		config.MetadataCache = filepath.Join(base, cache.DefaultFileName)
+	}
+	if config.PauseFile == "" {
+		config.PauseFile = filepath.Join(base, exporter.PauseFileName)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// summaryResult is the result of the running command for --summary-file,
+// recorded by recordResult
+var summaryResult any
+
+// runSummary is the document written to --summary-file once a command
+// finishes, whatever its outcome
+type runSummary struct {
+	Command    string            `json:"command"`
+	Version    string            `json:"version"`
+	Commit     string            `json:"commit,omitempty"`
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Duration   time.Duration     `json:"duration"`
+	ExitCode   int               `json:"exit_code"`
+	Error      string            `json:"error,omitempty"`
+	ConfigFile string            `json:"config_file,omitempty"`
+	Flags      map[string]string `json:"flags"`
+	Result     any               `json:"result,omitempty"`
+}
+
+// recordResult records the final result of the running command for
+// --summary-file
+func recordResult(result any) {
+	summaryResult = result
+}
+
+// writeSummary writes the --summary-file of cmd, if any, for a run started
+// at started that returned err
+func writeSummary(cmd *cobra.Command, started time.Time, err error) error {
+	path := viper.GetString("summary_file")
+	if path == "" || cmd == nil {
+		return nil
+	}
+
+	finished := time.Now()
+	summary := runSummary{
+		Command:    cmd.CommandPath(),
+		Version:    version,
+		Started:    started.UTC(),
+		Finished:   finished.UTC(),
+		Duration:   finished.Sub(started),
+		ExitCode:   ExitCode(err),
+		ConfigFile: viper.ConfigFileUsed(),
+		Flags:      summaryFlags(cmd),
+		Result:     summaryResult,
+	}
+	if commit != "unknown" {
+		summary.Commit = commit
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("failed to create summary directory: %w", err)
+		}
+	}
+	err = atomicfile.Write(path, 0o644, atomicfile.Options{}, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write summary file: %w", err)
+	}
+	return nil
+}
+
+// secretFlags are the flags whose values are left out of the summary
+var secretFlags = map[string]bool{
+	"graph-client-secret": true,
+}
+
+// headerFlags are the flags of "Name: value" header lines, which are
+// recorded by header name only since their values can be API keys
+var headerFlags = map[string]bool{
+	"api-header": true,
+}
+
+// summaryFlags returns the effective flag values of cmd, defaults included,
+// leaving out secrets
+func summaryFlags(cmd *cobra.Command) map[string]string {
+	values := make(map[string]string)
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "summary-file" || flag.Name == "help" || secretFlags[flag.Name] {
+			return
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			items := slice.GetSlice()
+			if headerFlags[flag.Name] {
+				names := make([]string, len(items))
+				for i, line := range items {
+					name, _, _ := strings.Cut(line, ":")
+					names[i] = strings.TrimSpace(name)
+				}
+				items = names
+			}
+			values[flag.Name] = strings.Join(items, ",")
+			return
+		}
+		values[flag.Name] = flag.Value.String()
+	})
+	return values
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestWriteSummary(t *testing.T) {
+	cmd := &cobra.Command{Use: "import"}
+	cmd.Flags().Int("limit", 0, "")
+	cmd.Flags().String("graph-client-secret", "", "")
+	cmd.Flags().StringSlice("drop-header", nil, "")
+	cmd.Flags().StringArray("api-header", nil, "")
+	if err := cmd.Flags().Set("limit", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Flags().Set("graph-client-secret", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Flags().Set("drop-header", "DKIM-Signature,ARC-*"); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []string{"X-Api-Key: sekrit123", "X-Tenant: acme"} {
+		if err := cmd.Flags().Set("api-header", header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "artifacts", "summary.json")
+	viper.Set("summary_file", path)
+	defer viper.Set("summary_file", "")
+	recordResult(map[string]int{"total_imported": 7})
+	defer recordResult(nil)
+
+	runErr := &exitError{code: ExitPartialFailure, err: errors.New("3 imports failed")}
+	if err := writeSummary(cmd, time.Now().Add(-time.Minute), runErr); err != nil {
+		t.Fatalf("writeSummary() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary struct {
+		runSummary
+		Result map[string]int `json:"result"`
+	}
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("Failed to parse summary: %v", err)
+	}
+
+	if summary.Command != "import" || summary.Version != version {
+		t.Errorf("Expected the command and version, got %q %q", summary.Command, summary.Version)
+	}
+	if summary.ExitCode != ExitPartialFailure || summary.Error != "3 imports failed" {
+		t.Errorf("Expected the exit code and error of the run, got %d %q", summary.ExitCode, summary.Error)
+	}
+	if summary.Duration < time.Minute {
+		t.Errorf("Expected a duration of at least a minute, got %s", summary.Duration)
+	}
+	if summary.Flags["limit"] != "10" || summary.Flags["drop-header"] != "DKIM-Signature,ARC-*" {
+		t.Errorf("Expected the flag values, got %v", summary.Flags)
+	}
+	if _, ok := summary.Flags["graph-client-secret"]; ok {
+		t.Error("Expected secret flags to be left out")
+	}
+	if got := summary.Flags["api-header"]; got != "X-Api-Key,X-Tenant" {
+		t.Errorf("Expected only the names of API headers, got %q", got)
+	}
+	if summary.Result["total_imported"] != 7 {
+		t.Errorf("Expected the recorded result, got %v", summary.Result)
+	}
+}
+
+func TestWriteSummaryDisabled(t *testing.T) {
+	if err := writeSummary(&cobra.Command{Use: "export"}, time.Now(), nil); err != nil {
+		t.Errorf("writeSummary() without --summary-file error = %v", err)
+	}
+}
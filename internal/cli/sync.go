@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/health"
+	"github.com/octasoft-ltd/gmail-exporter/internal/syncer"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Keep a local archive in sync with Gmail",
+	Long: `Keep a local maildir, mbox or SQLite archive in sync with a Gmail mailbox.
+
+The first run downloads every message (excluding chats, and spam and trash unless
+--include-spam-trash is set). Later runs use the Gmail History API to fetch only new
+messages, remove deleted ones and record label changes, so repeated syncs are cheap.
+Sync is one-way: changes made to the local archive are never pushed to Gmail.
+
+Use --interval to keep running as a backup agent, syncing again after each interval
+until interrupted. On Ctrl+C or SIGTERM the pass in progress finishes and sync exits;
+the sync index records every archived message, so an interrupted pass is picked up
+by the next run.
+
+--health-listen serves /healthz (liveness) and /readyz (readiness) over HTTP for
+Kubernetes probes. /readyz succeeds once a pass has completed and fails again once
+sync starts shutting down.
+
+FORMATS:
+  maildir  One file per message under archive-dir/cur. Read, starred and draft
+           state are kept in the maildir flags and updated as they change.
+  mbox     A single archive-dir/archive.mbox with an X-Gmail-Labels header per
+           message. Deleted messages are removed by rewriting the file; later
+           label changes are recorded in the sync index only.
+  sqlite   A single archive-dir/archive.db SQLite database with a row per message:
+           the raw message, its sender, subject, date and label names. Deletions
+           and label changes are applied in place.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		syncConfig, err := buildSyncConfig(cmd)
+		if err != nil {
+			return fmt.Errorf("failed to build sync config: %w", err)
+		}
+
+		s, err := syncer.New(syncConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create syncer: %w", err)
+		}
+		defer s.Close()
+
+		logrus.WithFields(logrus.Fields{
+			"archive_dir": syncConfig.ArchiveDir,
+			"format":      syncConfig.Format,
+			"interval":    syncConfig.Interval,
+		}).Info("Starting mailbox sync")
+
+		probe := health.New()
+		if healthAddress, _ := cmd.Flags().GetString("health-listen"); healthAddress != "" {
+			stopProbe, err := probe.Start(healthAddress)
+			if err != nil {
+				return err
+			}
+			defer stopProbe()
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			probe.ShuttingDown()
+		}()
+
+		var last *syncer.Result
+		var hookErr error
+		err = s.Run(ctx, func(result *syncer.Result) {
+			last = result
+			recordResult(result)
+			printSyncResult(result)
+			hookErr = runPostHook(cmd, hookResult{Dir: syncConfig.ArchiveDir, Exported: result.Added, Failed: result.Failed})
+			if hookErr != nil && syncConfig.Interval > 0 {
+				logrus.WithError(hookErr).Warn("Post hook failed; syncing again at the next interval")
+			}
+			probe.SetReady()
+		})
+		if err != nil {
+			return fmt.Errorf("sync failed: %w", err)
+		}
+		if syncConfig.Interval == 0 && hookErr != nil {
+			return hookErr
+		}
+
+		// A one-shot sync reports message failures through the exit code
+		if syncConfig.Interval == 0 && last != nil {
+			return partialFailure(cmd, "messages", last.Failed, last.FailedByCategory)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	syncCmd.Flags().String("archive-dir", "./archive", "Local archive directory")
+	syncCmd.Flags().String("format", syncer.FormatMaildir, "Archive format (maildir, mbox, sqlite)")
+	syncCmd.Flags().Duration("interval", 0, "Sync repeatedly at this interval until interrupted (0 = sync once)")
+	syncCmd.Flags().Bool("include-spam-trash", false, "Also archive messages in spam and trash")
+	syncCmd.Flags().Int("parallel-workers", 4, "Number of parallel downloads")
+	syncCmd.Flags().String("post-hook", "", "Shell command to run in the archive directory after each pass, e.g. \"notmuch new\"")
+	syncCmd.Flags().String("health-listen", "", "Address to serve /healthz and /readyz on (e.g. :8080; empty = disabled)")
+}
+
+func buildSyncConfig(cmd *cobra.Command) (*syncer.Config, error) {
+	config := &syncer.Config{
+		CredentialsFile: viper.GetString("credentials_file"),
+		TokenFile:       viper.GetString("token_file"),
+		AuthMode:        viper.GetString("auth_mode"),
+	}
+
+	if archiveDir, _ := cmd.Flags().GetString("archive-dir"); archiveDir != "" {
+		config.ArchiveDir = archiveDir
+	}
+	if format, _ := cmd.Flags().GetString("format"); format != "" {
+		config.Format = format
+	}
+	if interval, _ := cmd.Flags().GetDuration("interval"); interval != 0 {
+		config.Interval = interval
+	}
+	if includeSpamTrash, _ := cmd.Flags().GetBool("include-spam-trash"); includeSpamTrash {
+		config.IncludeSpamTrash = includeSpamTrash
+	}
+	if parallelWorkers, _ := cmd.Flags().GetInt("parallel-workers"); parallelWorkers > 0 {
+		config.ParallelWorkers = parallelWorkers
+	}
+
+	retryPolicies, err := loadRetryPolicies()
+	if err != nil {
+		return nil, err
+	}
+	config.Retry = retryPolicies
+
+	return config, nil
+}
+
+// printSyncResult prints the outcome of a sync pass
+func printSyncResult(result *syncer.Result) {
+	mode := "incremental"
+	if result.FullSync {
+		mode = "full"
+	}
+
+	fmt.Printf("[%s] %s sync: %d added, %d deleted, %d relabeled, %d archived in total (%s)\n",
+		time.Now().Format(time.DateTime), mode, result.Added, result.Deleted, result.Relabeled,
+		result.Archived, result.Duration.Round(time.Millisecond))
+
+	if result.Failed > 0 {
+		fmt.Printf("Failed messages: %d (%s; retried on the next sync)\n",
+			result.Failed, formatCategories(result.FailedByCategory))
+	}
+}
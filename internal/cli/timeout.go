@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// timeoutGrace is how long a run may take to save its progress after its
+// deadline before the process exits anyway
+var timeoutGrace = time.Minute
+
+// exitProcess exits the process; replaced in tests
+var exitProcess = os.Exit
+
+// deadline enforces the --timeout of a command
+type deadline struct {
+	timeout time.Duration
+	// grace and exit are timeoutGrace and exitProcess when the deadline
+	// started, so the goroutine enforcing it never reads the globals
+	grace   time.Duration
+	exit    func(int)
+	ctx     context.Context
+	release context.CancelFunc
+	done    chan struct{}
+	// stopped is closed when the goroutine enforcing the deadline returns
+	stopped chan struct{}
+}
+
+// addTimeoutFlag adds the --timeout flag read by startDeadline
+func addTimeoutFlag(cmd *cobra.Command) {
+	cmd.Flags().Duration("timeout", 0, "Stop after this long, saving progress, and exit with code 5 (e.g. 6h; 0 = the timeout config setting, or none)")
+}
+
+// commandTimeout returns the command's --timeout, or the timeout config
+// setting when the flag is not given
+func commandTimeout(cmd *cobra.Command) time.Duration {
+	if timeout, _ := cmd.Flags().GetDuration("timeout"); timeout > 0 {
+		return timeout
+	}
+	return viper.GetDuration("timeout")
+}
+
+// startDeadline calls cancel once the command's timeout elapses, so the run
+// saves its progress and returns. If it has not returned timeoutGrace later,
+// for example because an API call hangs, the process exits with ExitTimeout.
+// It returns nil when the command has no timeout.
+func startDeadline(cmd *cobra.Command, cancel func()) *deadline {
+	timeout := commandTimeout(cmd)
+	if timeout <= 0 {
+		return nil
+	}
+
+	ctx, release := context.WithTimeout(context.Background(), timeout)
+	d := &deadline{
+		timeout: timeout,
+		grace:   timeoutGrace,
+		exit:    exitProcess,
+		ctx:     ctx,
+		release: release,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go func() {
+		defer close(d.stopped)
+		select {
+		case <-ctx.Done():
+		case <-d.done:
+			return
+		}
+		if !d.expired() {
+			return
+		}
+
+		logrus.WithField("timeout", timeout).Warn("Timeout reached; saving progress before exiting")
+		cancel()
+		select {
+		case <-time.After(d.grace):
+			logrus.WithField("grace", d.grace).Error("Run did not stop after the timeout; exiting")
+			d.exit(ExitTimeout)
+		case <-d.done:
+		}
+	}()
+	return d
+}
+
+// stop releases the deadline once the run has returned, waiting for the
+// goroutine enforcing it to return
+func (d *deadline) stop() {
+	if d == nil {
+		return
+	}
+	close(d.done)
+	d.release()
+	<-d.stopped
+}
+
+// expired reports whether the timeout elapsed
+func (d *deadline) expired() bool {
+	return d != nil && errors.Is(d.ctx.Err(), context.DeadlineExceeded)
+}
+
+// err returns the error of a run stopped by its timeout, carrying
+// ExitTimeout. next tells the user how to continue.
+func (d *deadline) err(cmd *cobra.Command, operation, next string) error {
+	cmd.SilenceUsage = true
+	return &exitError{
+		code: ExitTimeout,
+		err:  fmt.Errorf("%s timed out after %s; %s", operation, d.timeout, next),
+	}
+}
+
+// exportTimedOut returns the timeout error in place of errExportInterrupted
+// when the export was stopped by its deadline, and err otherwise
+func exportTimedOut(cmd *cobra.Command, d *deadline, err error) error {
+	if d.expired() && errors.Is(err, errExportInterrupted) {
+		return d.err(cmd, "export", "progress saved; run it again with --resume to continue")
+	}
+	return err
+}
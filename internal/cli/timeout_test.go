@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestStartDeadline(t *testing.T) {
+	cmd := &cobra.Command{}
+	addTimeoutFlag(cmd)
+	if d := startDeadline(cmd, func() {}); d != nil {
+		t.Fatal("Expected no deadline without --timeout")
+	}
+
+	if err := cmd.Flags().Set("timeout", "10ms"); err != nil {
+		t.Fatal(err)
+	}
+	cancelled := make(chan struct{})
+	d := startDeadline(cmd, func() { close(cancelled) })
+	defer d.stop()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the run to be cancelled when the timeout elapsed")
+	}
+	if !d.expired() {
+		t.Error("Expected the deadline to have expired")
+	}
+	if got := ExitCode(d.err(cmd, "import", "run it again")); got != ExitTimeout {
+		t.Errorf("ExitCode() = %d, want %d", got, ExitTimeout)
+	}
+	if got := ExitCode(exportTimedOut(cmd, d, errExportInterrupted)); got != ExitTimeout {
+		t.Errorf("ExitCode() of an export stopped by its timeout = %d, want %d", got, ExitTimeout)
+	}
+}
+
+func TestStartDeadlineExitsWhenRunHangs(t *testing.T) {
+	grace, exit := timeoutGrace, exitProcess
+	defer func() { timeoutGrace, exitProcess = grace, exit }()
+
+	exited := make(chan int, 1)
+	timeoutGrace = 10 * time.Millisecond
+	exitProcess = func(code int) { exited <- code }
+
+	cmd := &cobra.Command{}
+	addTimeoutFlag(cmd)
+	if err := cmd.Flags().Set("timeout", "10ms"); err != nil {
+		t.Fatal(err)
+	}
+	d := startDeadline(cmd, func() {})
+	defer d.stop()
+
+	select {
+	case code := <-exited:
+		if code != ExitTimeout {
+			t.Errorf("Expected exit code %d, got %d", ExitTimeout, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the process to exit when the run did not stop")
+	}
+}
@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/preview"
+)
+
+// wizardCountLimit is the number of matches past which the wizard stops
+// counting
+const wizardCountLimit = 5000
+
+// wizardStep is a question of the filter wizard, answered with the value of
+// an export flag. Steps with a no flag are yes or no questions setting one
+// of a pair of boolean flags, such as --has-attachment and --no-attachment.
+type wizardStep struct {
+	flag   string
+	no     string
+	prompt string
+}
+
+// wizardSteps are the questions of the filter wizard, in order
+var wizardSteps = []wizardStep{
+	{"from", "", "Sender (address, name or @domain)"},
+	{"to", "", "Recipient"},
+	{"subject", "", "Subject contains"},
+	{"includes-words", "", "Body contains words"},
+	{"excludes-words", "", "Body excludes words"},
+	{"date-within", "", "Received within (e.g. 30d, 6m, 1y)"},
+	{"older-than", "", "Received longer ago than (e.g. 6m, 2y)"},
+	{"date-after", "", "Received after (YYYY-MM-DD)"},
+	{"date-before", "", "Received before (YYYY-MM-DD)"},
+	{"size-greater-than", "", "Larger than (e.g. 5MB)"},
+	{"size-less-than", "", "Smaller than (e.g. 10MB)"},
+	{"has-attachment", "no-attachment", "Attachments (yes, no)"},
+	{"filename", "", "Attachment names or extensions (comma-separated, e.g. pdf,xlsx)"},
+	{"starred", "unstarred", "Starred (yes, no)"},
+	{"important", "not-important", "Important (yes, no)"},
+	{"unread", "read", "Unread (yes, no)"},
+	{"labels", "", "Labels (comma-separated)"},
+	{"search-scope", "", "Search scope (all_mail, inbox, sent, drafts, spam, trash)"},
+	{"category", "", "Inbox category tab (primary, social, promotions, updates, forums)"},
+}
+
+// filterWizard asks for the filter flags of a command one at a time,
+// showing how many messages match after each answer
+type filterWizard struct {
+	in  *bufio.Reader
+	out io.Writer
+	// count returns the number of messages matching a filter, and whether
+	// counting stopped at wizardCountLimit
+	count func(filterConfig *filters.Config) (int, bool, error)
+}
+
+// run asks the wizard's questions, setting the command's filter flags from
+// the answers. Values already given on the command line are offered as
+// defaults.
+func (w *filterWizard) run(cmd *cobra.Command) error {
+	fmt.Fprintln(w.out, "Build a filter one question at a time. Press Enter to skip a question or keep the value in brackets, or enter - to clear it.")
+	w.showCount(cmd)
+
+	for _, step := range wizardSteps {
+		for {
+			current := w.current(cmd, step)
+			if current != "" {
+				fmt.Fprintf(w.out, "%s [%s]: ", step.prompt, current)
+			} else {
+				fmt.Fprintf(w.out, "%s: ", step.prompt)
+			}
+
+			answer, err := w.in.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read answer: %w", err)
+			}
+			answer = strings.TrimSpace(answer)
+			if answer == "" {
+				if err == io.EOF {
+					fmt.Fprintln(w.out)
+				}
+				break
+			}
+
+			if setErr := w.set(cmd, step, answer); setErr != nil {
+				fmt.Fprintf(w.out, "  %v\n", setErr)
+				if err == io.EOF {
+					return fmt.Errorf("no valid answer for %s", step.flag)
+				}
+				continue
+			}
+			w.showCount(cmd)
+			break
+		}
+	}
+
+	return nil
+}
+
+// current returns the value of a wizard step's flag, or "" when it is unset
+func (w *filterWizard) current(cmd *cobra.Command, step wizardStep) string {
+	if step.no != "" {
+		if yes, _ := cmd.Flags().GetBool(step.flag); yes {
+			return "yes"
+		}
+		if no, _ := cmd.Flags().GetBool(step.no); no {
+			return "no"
+		}
+		return ""
+	}
+	if !cmd.Flags().Changed(step.flag) {
+		return ""
+	}
+	return cmd.Flags().Lookup(step.flag).Value.String()
+}
+
+// set sets a wizard step's flag to the answer, restoring the previous value
+// when the answer does not make a valid filter
+func (w *filterWizard) set(cmd *cobra.Command, step wizardStep, answer string) error {
+	if step.no != "" {
+		var yes, no string
+		switch strings.ToLower(answer) {
+		case "yes", "y":
+			yes, no = "true", "false"
+		case "no", "n":
+			yes, no = "false", "true"
+		case "-":
+			yes, no = "false", "false"
+		default:
+			return fmt.Errorf("answer yes or no")
+		}
+		if err := cmd.Flags().Set(step.flag, yes); err != nil {
+			return err
+		}
+		return cmd.Flags().Set(step.no, no)
+	}
+
+	name := step.flag
+	flag := cmd.Flags().Lookup(name)
+	previous, changed := flag.Value.String(), flag.Changed
+	if answer == "-" {
+		flag.Changed = false
+		return flag.Value.Set(flag.DefValue)
+	}
+	if err := cmd.Flags().Set(name, answer); err != nil {
+		return err
+	}
+
+	filterConfig, err := buildFilterConfig(cmd)
+	if err == nil {
+		err = filterConfig.Validate()
+	}
+	if err != nil {
+		// The previous value was valid, so restoring it cannot fail
+		_ = cmd.Flags().Set(name, previous)
+		flag.Changed = changed
+		return err
+	}
+	return nil
+}
+
+// showCount prints how many messages match the filter built so far
+func (w *filterWizard) showCount(cmd *cobra.Command) {
+	filterConfig, err := buildFilterConfig(cmd)
+	if err != nil {
+		return
+	}
+
+	count, more, err := w.count(filterConfig)
+	switch {
+	case err != nil:
+		fmt.Fprintf(w.out, "  Could not count matches: %v\n", err)
+	case more:
+		fmt.Fprintf(w.out, "  Matching messages: more than %d (query: %s)\n", count, filterConfig.BuildGmailQuery())
+	default:
+		fmt.Fprintf(w.out, "  Matching messages: %d (query: %s)\n", count, filterConfig.BuildGmailQuery())
+	}
+}
+
+// wizardPreset returns the filter flags set on the command as a preset, to
+// print as flags and as config file YAML
+func wizardPreset(cmd *cobra.Command) *exportPreset {
+	preset := &exportPreset{
+		Name:     "my-filter",
+		Settings: make(map[string][]string),
+		lists:    make(map[string]bool),
+	}
+	for _, step := range wizardSteps {
+		if step.no != "" {
+			for _, name := range []string{step.flag, step.no} {
+				if set, _ := cmd.Flags().GetBool(name); set {
+					preset.Settings[name] = []string{"true"}
+				}
+			}
+			continue
+		}
+		if cmd.Flags().Changed(step.flag) {
+			preset.Settings[step.flag] = []string{cmd.Flags().Lookup(step.flag).Value.String()}
+		}
+	}
+	return preset
+}
+
+// yaml returns the preset as a presets section of the config file
+func (p *exportPreset) yaml() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "presets:\n  %s:\n", p.Name)
+	for _, name := range p.flagNames() {
+		value := p.Settings[name][0]
+		if value != "true" && value != "false" {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, "    %s: %s\n", strings.ReplaceAll(name, "-", "_"), value)
+	}
+	return b.String()
+}
+
+// runFilterWizard runs the filter wizard for export --interactive, prints
+// the resulting flags and preset, and reports whether to go on with the
+// export
+func runFilterWizard(cmd *cobra.Command) (bool, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("--interactive requires an interactive terminal")
+	}
+
+	credentialsFile, tokenFile, err := resolveAccountFiles(cmd)
+	if err != nil {
+		return false, err
+	}
+	_, service, err := auth.NewGmailService(viper.GetString("auth_mode"), auth.AccessReadonly, credentialsFile, tokenFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	wizard := &filterWizard{
+		in:  in,
+		out: os.Stdout,
+		count: func(filterConfig *filters.Config) (int, bool, error) {
+			return preview.Count(service, filterConfig, wizardCountLimit)
+		},
+	}
+	if err := wizard.run(cmd); err != nil {
+		return false, err
+	}
+
+	preset := wizardPreset(cmd)
+	fmt.Printf("\nEquivalent flags:\n  gmail-exporter export %s\n", preset.commandLine())
+	fmt.Printf("\nAs a preset for the config file (run with --preset %s):\n%s\n", preset.Name, preset.yaml())
+
+	fmt.Print("Export the matching messages now? [y/N]: ")
+	answer, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read answer: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
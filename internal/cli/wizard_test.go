@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+func TestFilterWizard(t *testing.T) {
+	cmd := &cobra.Command{}
+	addFilterFlags(cmd)
+	if err := cmd.Flags().Set("subject", "invoice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sender, keep the recipient and subject, skip the words, a bad then a
+	// good date, and attachments; the rest is left at its default
+	answers := []string{
+		"billing@example.com", "", "", "", "",
+		"soon", "1y",
+		"", "", "", "", "",
+		"yes",
+	}
+	var queries []string
+	var out bytes.Buffer
+	wizard := &filterWizard{
+		in:  bufio.NewReader(strings.NewReader(strings.Join(answers, "\n") + "\n")),
+		out: &out,
+		count: func(filterConfig *filters.Config) (int, bool, error) {
+			queries = append(queries, filterConfig.BuildGmailQuery())
+			return 42, false, nil
+		},
+	}
+
+	if err := wizard.run(cmd); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if !strings.Contains(out.String(), "Subject contains [invoice]") {
+		t.Errorf("Expected the command-line subject as default, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "invalid date-within") {
+		t.Errorf("Expected the bad date to be reported, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Matching messages: 42") {
+		t.Errorf("Expected match counts, got:\n%s", out.String())
+	}
+	// One count at the start and one per accepted answer
+	if len(queries) != 4 {
+		t.Errorf("Expected 4 counts, got %d: %v", len(queries), queries)
+	}
+
+	preset := wizardPreset(cmd)
+	if got := preset.commandLine(); got != "--date-within=1y --from=billing@example.com --has-attachment=true --subject=invoice" {
+		t.Errorf("commandLine() = %q", got)
+	}
+	if got := preset.yaml(); !strings.Contains(got, "    date_within: \"1y\"\n") || !strings.Contains(got, "    has_attachment: true\n") {
+		t.Errorf("yaml() = %q", got)
+	}
+}
+
+func TestFilterWizardClear(t *testing.T) {
+	cmd := &cobra.Command{}
+	addFilterFlags(cmd)
+	if err := cmd.Flags().Set("from", "old@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	wizard := &filterWizard{
+		in:    bufio.NewReader(strings.NewReader("-\n")),
+		out:   &bytes.Buffer{},
+		count: func(*filters.Config) (int, bool, error) { return 0, false, nil },
+	}
+	if err := wizard.run(cmd); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if cmd.Flags().Changed("from") {
+		t.Errorf("Expected --from to be cleared, got %q", cmd.Flags().Lookup("from").Value.String())
+	}
+}
@@ -1,38 +1,330 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cleaner"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/importer"
+	"github.com/octasoft-ltd/gmail-exporter/internal/workflow"
 )
 
 var workflowCmd = &cobra.Command{
 	Use:   "workflow",
 	Short: "Run complete export, import, and cleanup workflow",
-	Long: `Run a complete workflow that exports emails, forwards them to another account,
-and optionally archives or deletes the original emails.
+	Long: `Run a complete workflow that exports the emails matching the filter flags,
+imports them into another account, and optionally archives or deletes the
+original emails.
+
+The import goes to the account of --import-credentials and --import-token, as with
+the import command. Use --cleanup-action none to leave the originals alone.
+
+RESUMING:
+The progress of each step and the files it produced (the export directory, the
+filter file for cleanup and the import state mapping exported files to imported
+ones) are saved to workflow_state.json next to the output directory. When a step
+fails, run the workflow again with --resume: finished steps are skipped and the
+failed step continues where it stopped, with the filter of the first run.
 
 Use --limit to process only a specific number of messages in each step, which is useful
-for testing the complete workflow with a small number of messages before running a full workflow.`,
+for testing the complete workflow with a small number of messages before running a full workflow.
+With --dry-run the cleanup step only reports what it would do and stays pending, so a
+later --resume performs it.
+
+Use 'workflow run PIPELINE.yaml' to run a workflow defined in a file.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		limit, _ := cmd.Flags().GetInt("limit")
-		if limit > 0 {
-			logrus.WithField("limit", limit).Info("Workflow will be limited to specified number of messages per step")
+		cleanupAction, _ := cmd.Flags().GetString("cleanup-action")
+		if cleanupAction != cleaner.ActionArchive && cleanupAction != cleaner.ActionDelete && cleanupAction != "none" {
+			return fmt.Errorf("invalid cleanup action: %s (valid: archive, delete, none)", cleanupAction)
+		}
+		steps := []string{workflow.StepExport, workflow.StepImport}
+		if cleanupAction != "none" {
+			steps = append(steps, workflow.StepCleanup)
 		}
 
-		logrus.Info("Workflow command not yet implemented")
-		return fmt.Errorf("workflow command not yet implemented")
+		_, err := runWorkflow(cmd, steps, nil)
+		return err
 	},
 }
 
 func init() {
-	// Inherit flags from other commands
-	workflowCmd.Flags().String("to", "", "Recipient email address to filter")
-	workflowCmd.Flags().String("destination", "", "Destination email address for forwarding")
-	workflowCmd.Flags().String("cleanup-action", "archive", "Cleanup action (archive, delete, none)")
-	workflowCmd.Flags().StringP("output-dir", "o", "./exports", "Output directory for exported emails")
-	workflowCmd.Flags().Int("parallel-workers", 3, "Number of parallel workers")
-	workflowCmd.Flags().Bool("dry-run", false, "Show what would be done without actually doing it")
-	workflowCmd.Flags().IntP("limit", "l", 0, "Limit the number of messages to process in each step (0 = no limit, useful for testing)")
+	workflowCmd.AddCommand(workflowRunCmd)
+	workflowCmd.AddCommand(workflowOffboardCmd)
+	addWorkflowFlags(workflowCmd)
+}
+
+// addWorkflowFlags adds the flags read by runWorkflow
+func addWorkflowFlags(cmd *cobra.Command) {
+	addFilterFlags(cmd)
+
+	cmd.Flags().String("import-credentials", "", "Gmail API credentials file for destination account (defaults to main credentials)")
+	cmd.Flags().String("import-token", "", "OAuth token file for destination account (defaults to main token)")
+	cmd.Flags().String("cleanup-action", "archive", "Cleanup action (archive, delete, none)")
+	cmd.Flags().Bool("yes", false, "Skip the interactive confirmation for --cleanup-action delete")
+	cmd.Flags().StringP("output-dir", "o", "./exports", "Output directory for exported emails")
+	cmd.Flags().Bool("include-attachments", true, "Include email attachments in export")
+	cmd.Flags().Int("parallel-workers", 3, "Number of parallel workers")
+	cmd.Flags().Bool("dry-run", false, "Simulate the cleanup step without changing the original emails")
+	cmd.Flags().IntP("limit", "l", 0, "Limit the number of messages to process in each step (0 = no limit, useful for testing)")
+	cmd.Flags().Bool("resume", false, "Resume a failed workflow at the step that failed")
+	cmd.Flags().String("workflow-state", "", "Workflow state file (default: workflow_state.json next to the output directory)")
+}
+
+// runWorkflow runs the named steps of the workflow set up by the command's
+// flags, resuming a failed run with --resume. A fresh run of the offboard
+// workflow records offboard in its state. The state is nil when the
+// workflow could not be set up.
+func runWorkflow(cmd *cobra.Command, steps []string, offboard *workflow.Offboard) (*workflow.State, error) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	if limit > 0 {
+		logrus.WithField("limit", limit).Info("Workflow will be limited to specified number of messages per step")
+	}
+
+	state, err := loadWorkflowState(cmd, steps, offboard)
+	if err != nil {
+		return nil, err
+	}
+	recordResult(state)
+	if state.Next() == "" {
+		fmt.Printf("Workflow already completed (state: %s)\n", state.Path())
+		return state, nil
+	}
+
+	stopPause := notifyPause(exportPauses)
+	defer stopPause()
+	stopSignals := notifyStop(exportPauses)
+	defer stopSignals()
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	available := map[string]workflow.Step{
+		workflow.StepExport: {Name: workflow.StepExport, Run: func(state *workflow.State, resumed bool) error {
+			return runWorkflowExport(cmd, state, resumed)
+		}},
+		workflow.StepImport: {Name: workflow.StepImport, Run: func(state *workflow.State, resumed bool) error {
+			return runWorkflowImport(cmd, state, resumed)
+		}},
+		workflow.StepCleanup: {Name: workflow.StepCleanup, DryRun: dryRun, Run: func(state *workflow.State, resumed bool) error {
+			action, _ := cmd.Flags().GetString("cleanup-action")
+			return runWorkflowCleanup(cmd, state, action)
+		}},
+		workflow.StepAutoReply: {Name: workflow.StepAutoReply, Run: func(state *workflow.State, resumed bool) error {
+			return runWorkflowAutoReply(cmd, state)
+		}},
+		workflow.StepManifest: {Name: workflow.StepManifest, Run: func(state *workflow.State, resumed bool) error {
+			return runWorkflowManifest(cmd, state)
+		}},
+	}
+	runSteps := make([]workflow.Step, 0, len(steps))
+	for _, name := range steps {
+		runSteps = append(runSteps, available[name])
+	}
+
+	if err := workflow.Run(state, runSteps); err != nil {
+		cmd.SilenceUsage = true
+		fmt.Printf("Workflow stopped; progress saved in %s. Run it again with --resume to continue.\n", state.Path())
+		return state, err
+	}
+
+	fmt.Printf("Workflow completed successfully!\n")
+	fmt.Printf("Export directory: %s\n", state.Artifacts.ExportDir)
+	if state.Artifacts.ManifestFile != "" {
+		fmt.Printf("Offboarding manifest: %s\n", state.Artifacts.ManifestFile)
+	}
+	fmt.Printf("Workflow state: %s\n", state.Path())
+	return state, nil
+}
+
+// loadWorkflowState returns the saved state of the workflow to resume, or
+// new state for a fresh run. A fresh run refuses to replace the state of an
+// unfinished one.
+func loadWorkflowState(cmd *cobra.Command, steps []string, offboard *workflow.Offboard) (*workflow.State, error) {
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	path, _ := cmd.Flags().GetString("workflow-state")
+	if path == "" {
+		path = filepath.Join(filepath.Dir(filepath.Clean(outputDir)), workflow.StateFileName)
+	}
+
+	saved, err := workflow.Load(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if resume, _ := cmd.Flags().GetBool("resume"); resume {
+		if saved == nil {
+			return nil, fmt.Errorf("nothing to resume: %w", err)
+		}
+		if !saved.Matches(steps) {
+			return nil, fmt.Errorf("workflow in %s has steps %s, not %s", path, stepNames(saved), strings.Join(steps, ", "))
+		}
+		logrus.WithFields(logrus.Fields{
+			"state": path,
+			"step":  saved.Next(),
+		}).Info("Resuming workflow")
+		return saved, nil
+	}
+
+	if saved != nil && saved.Next() != "" {
+		return nil, fmt.Errorf("an unfinished workflow is recorded in %s; continue it with --resume or remove the file", path)
+	}
+
+	filterConfig, err := buildFilterConfig(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build filter config: %w", err)
+	}
+	if err := filterConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid filter configuration: %w", err)
+	}
+
+	state := workflow.NewState(path, steps)
+	state.Filter = filterConfig
+	state.Offboard = offboard
+	state.Artifacts.ExportDir = outputDir
+	return state, nil
+}
+
+// stepNames lists the steps of a workflow state
+func stepNames(state *workflow.State) string {
+	names := make([]string, 0, len(state.Steps))
+	for _, step := range state.Steps {
+		names = append(names, step.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// runWorkflowExport runs the export step, continuing the export of a
+// previous attempt when resumed
+func runWorkflowExport(cmd *cobra.Command, state *workflow.State, resumed bool) error {
+	exportConfig, err := buildExportConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to build export config: %w", err)
+	}
+	exportConfig.OutputDir = state.Artifacts.ExportDir
+	exportConfig.Resume = resumed
+	if state.Offboard != nil {
+		if err := offboardExportConfig(cmd, exportConfig, state.Offboard); err != nil {
+			return err
+		}
+	}
+
+	exp, err := exporter.New(exportConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create exporter: %w", err)
+	}
+	exportPauses.add(exp)
+
+	result, err := exp.Export(state.Filter)
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+	if result.Cancelled {
+		return errExportInterrupted
+	}
+
+	state.Artifacts.FilterFile = filepath.Join(exportConfig.OutputDir, exporter.ProcessedEmailsFile)
+	fmt.Printf("Exported %d of %d matching emails to %s\n", result.TotalExported, result.TotalMatched, exportConfig.OutputDir)
+	return partialFailure(cmd, "exports", result.TotalFailed, result.FailedByCategory)
+}
+
+// runWorkflowImport runs the import step, skipping the files a previous
+// attempt imported when resumed
+func runWorkflowImport(cmd *cobra.Command, state *workflow.State, resumed bool) error {
+	credentialsFile := viper.GetString("credentials_file")
+	tokenFile := viper.GetString("token_file")
+	if importCreds, _ := cmd.Flags().GetString("import-credentials"); importCreds != "" {
+		credentialsFile = importCreds
+	}
+	if importToken, _ := cmd.Flags().GetString("import-token"); importToken != "" {
+		tokenFile = importToken
+	}
+
+	if state.Artifacts.MappingFile == "" {
+		state.Artifacts.MappingFile = filepath.Join(filepath.Dir(filepath.Clean(state.Artifacts.ExportDir)), "import_state.json")
+	}
+	importConfig := &importer.Config{
+		CredentialsFile: credentialsFile,
+		TokenFile:       tokenFile,
+		AuthMode:        viper.GetString("auth_mode"),
+		InputDir:        state.Artifacts.ExportDir,
+		PreserveDates:   true,
+		Resume:          resumed,
+		StateFile:       state.Artifacts.MappingFile,
+		Backend:         importer.BackendGmail,
+		AuditLog:        viper.GetString("audit_log"),
+		AuditSigningKey: viper.GetString("audit_signing_key"),
+	}
+	importConfig.ParallelWorkers, _ = cmd.Flags().GetInt("parallel-workers")
+	importConfig.Limit, _ = cmd.Flags().GetInt("limit")
+	if state.Offboard != nil {
+		importConfig.LabelPrefix = state.Offboard.ImportLabel
+		importConfig.OnlyLabels = state.Offboard.ImportLabels
+	}
+
+	retryPolicies, err := loadRetryPolicies()
+	if err != nil {
+		return err
+	}
+	importConfig.Retry = retryPolicies
+
+	imp, err := importer.New(importConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create importer: %w", err)
+	}
+	result, err := imp.Import()
+	if err != nil {
+		return fmt.Errorf("import failed: %w", err)
+	}
+
+	if state.Offboard != nil {
+		state.Offboard.Imported += result.TotalImported
+	}
+	fmt.Printf("Imported %d of %d emails\n", result.TotalImported, result.TotalFound)
+	return partialFailure(cmd, "imports", result.TotalFailed, result.FailedByCategory)
+}
+
+// runWorkflowCleanup runs the cleanup step on the emails the export step
+// recorded in its filter file
+func runWorkflowCleanup(cmd *cobra.Command, state *workflow.State, action string) error {
+	if state.Artifacts.FilterFile == "" {
+		return fmt.Errorf("the export step recorded no filter file")
+	}
+
+	cleanupConfig := &cleaner.Config{
+		CredentialsFile: viper.GetString("credentials_file"),
+		TokenFile:       viper.GetString("token_file"),
+		AuthMode:        viper.GetString("auth_mode"),
+		LegalHold:       viper.GetBool("legal_hold"),
+		Action:          action,
+		FilterFile:      state.Artifacts.FilterFile,
+		AuditLog:        viper.GetString("audit_log"),
+		AuditSigningKey: viper.GetString("audit_signing_key"),
+	}
+	cleanupConfig.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	cleanupConfig.Limit, _ = cmd.Flags().GetInt("limit")
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		cleanupConfig.ConfirmDelete = stdinConfirmDeletion
+	}
+
+	cl, err := cleaner.New(cleanupConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create cleaner: %w", err)
+	}
+	result, err := cl.Cleanup()
+	if err != nil {
+		return fmt.Errorf("cleanup failed: %w", err)
+	}
+
+	if result.DryRun {
+		fmt.Printf("DRY RUN - would %s %d emails\n", result.Action, result.TotalFound)
+	} else {
+		fmt.Printf("Cleaned up %d of %d emails (%s)\n", result.TotalProcessed, result.TotalFound, result.Action)
+	}
+	return partialFailure(cmd, "cleanup operations", result.TotalFailed, result.FailedByCategory)
 }
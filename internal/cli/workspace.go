@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+// serviceAccountKeyFile returns the service account key file of the
+// --service-account-key flag or config file, which is empty when the key
+// comes from the environment
+func serviceAccountKeyFile(cmd *cobra.Command) (string, error) {
+	keyFile := viper.GetString("workspace.service_account_key")
+	if key, _ := cmd.Flags().GetString("service-account-key"); key != "" {
+		keyFile = key
+	}
+	if keyFile == "" && !auth.ServiceAccountKeyInEnv() {
+		return "", fmt.Errorf("a service account key with domain-wide delegation is required (--service-account-key or %s)", auth.ServiceAccountKeyB64EnvVar)
+	}
+	return keyFile, nil
+}
+
+// workspaceTargets returns one export target per Workspace user when
+// --all-users or --users-file is set, or nil otherwise
+func workspaceTargets(cmd *cobra.Command, base *exporter.Config) ([]exportTarget, error) {
+	allUsers, _ := cmd.Flags().GetBool("all-users")
+	usersFile, _ := cmd.Flags().GetString("users-file")
+	if !allUsers && usersFile == "" {
+		return nil, nil
+	}
+	if allUsers && usersFile != "" {
+		return nil, fmt.Errorf("--all-users and --users-file cannot be combined")
+	}
+
+	keyFile, err := serviceAccountKeyFile(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	if usersFile != "" {
+		users, err = readUsersFile(usersFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		adminEmail := viper.GetString("workspace.admin_email")
+		if email, _ := cmd.Flags().GetString("admin-email"); email != "" {
+			adminEmail = email
+		}
+		if adminEmail == "" {
+			return nil, fmt.Errorf("--admin-email is required to list domain users")
+		}
+		domain, _ := cmd.Flags().GetString("domain")
+
+		serviceAccount, err := auth.NewServiceAccount(keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		users, err = serviceAccount.ListUsers(adminEmail, domain)
+		if err != nil {
+			return nil, err
+		}
+		logrus.WithField("users", len(users)).Info("Listed active Workspace users")
+	}
+
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users to export")
+	}
+
+	targets := make([]exportTarget, 0, len(users))
+	for _, user := range users {
+		config := targetConfig(base, user)
+		config.ServiceAccountKey = keyFile
+		config.ImpersonateUser = user
+
+		targets = append(targets, exportTarget{Name: user, Config: config})
+	}
+
+	return targets, nil
+}
+
+// readUsersFile reads one email address per line, ignoring blank lines,
+// comments starting with # and duplicates
+func readUsersFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open users file: %w", err)
+	}
+	defer file.Close()
+
+	var users []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		user := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if user == "" || strings.HasPrefix(user, "#") {
+			continue
+		}
+		if !strings.Contains(user, "@") {
+			return nil, fmt.Errorf("invalid email address on line %d of users file: %s", lineNumber, user)
+		}
+		if !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	return users, nil
+}
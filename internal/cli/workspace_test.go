@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+func TestReadUsersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	content := "# finance team\nAlice@Example.com\n\n  bob@example.com  \nalice@example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+
+	users, err := readUsersFile(path)
+	if err != nil {
+		t.Fatalf("readUsersFile failed: %v", err)
+	}
+	if len(users) != 2 || users[0] != "alice@example.com" || users[1] != "bob@example.com" {
+		t.Errorf("Unexpected users: %v", users)
+	}
+
+	if err := os.WriteFile(path, []byte("not-an-email\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	if _, err := readUsersFile(path); err == nil {
+		t.Error("Expected error for invalid email address")
+	}
+}
+
+func newWorkspaceTestCommand() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("all-users", false, "")
+	cmd.Flags().String("users-file", "", "")
+	cmd.Flags().String("service-account-key", "", "")
+	cmd.Flags().String("admin-email", "", "")
+	cmd.Flags().String("domain", "", "")
+	return cmd
+}
+
+func TestWorkspaceTargets(t *testing.T) {
+	base := &exporter.Config{OutputDir: "exports", Format: "eml", StateFile: "state.json"}
+
+	t.Run("not requested", func(t *testing.T) {
+		targets, err := workspaceTargets(newWorkspaceTestCommand(), base)
+		if err != nil || targets != nil {
+			t.Errorf("Expected no targets, got %v, %v", targets, err)
+		}
+	})
+
+	t.Run("users file", func(t *testing.T) {
+		usersFile := filepath.Join(t.TempDir(), "users.txt")
+		if err := os.WriteFile(usersFile, []byte("alice@example.com\nbob@example.com\n"), 0o600); err != nil {
+			t.Fatalf("Failed to write users file: %v", err)
+		}
+
+		cmd := newWorkspaceTestCommand()
+		_ = cmd.Flags().Set("users-file", usersFile)
+		_ = cmd.Flags().Set("service-account-key", "key.json")
+
+		targets, err := workspaceTargets(cmd, base)
+		if err != nil {
+			t.Fatalf("workspaceTargets failed: %v", err)
+		}
+		if len(targets) != 2 {
+			t.Fatalf("Expected 2 targets, got %d", len(targets))
+		}
+
+		alice := targets[0].Config
+		if alice.ImpersonateUser != "alice@example.com" || alice.ServiceAccountKey != "key.json" {
+			t.Errorf("Unexpected impersonation settings: %+v", alice)
+		}
+		if alice.OutputDir != filepath.Join("exports", "alice@example.com") || alice.StateFile != "" {
+			t.Errorf("Expected per-user output directory and state, got %+v", alice)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		cmd := newWorkspaceTestCommand()
+		_ = cmd.Flags().Set("all-users", "true")
+		if _, err := workspaceTargets(cmd, base); err == nil {
+			t.Error("Expected error without a service account key")
+		}
+	})
+
+	t.Run("missing admin", func(t *testing.T) {
+		cmd := newWorkspaceTestCommand()
+		_ = cmd.Flags().Set("all-users", "true")
+		_ = cmd.Flags().Set("service-account-key", "key.json")
+		if _, err := workspaceTargets(cmd, base); err == nil {
+			t.Error("Expected error without an admin email")
+		}
+	})
+}
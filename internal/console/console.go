@@ -0,0 +1,199 @@
+// Package console owns the terminal: progress status lines, log output and
+// other printing go through one Console, which serializes them so that logs
+// from concurrent workers never land in the middle of a progress line
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ANSI sequences used to redraw the status block
+const (
+	eraseLine = "\r\x1b[2K"
+	cursorUp  = "\x1b[1A"
+)
+
+// Console serializes status lines, logs and printed output. On a terminal
+// the status lines form a block at the bottom of the screen that is erased
+// before other output and redrawn after it. Elsewhere only the first status
+// line is written, rewritten in place with a carriage return, as progress
+// lines always were.
+type Console struct {
+	mu     sync.Mutex
+	out    io.Writer
+	logOut io.Writer
+	tty    bool
+	width  int
+
+	lines []string
+	// drawn is the number of status lines on the screen
+	drawn int
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultConsole *Console
+)
+
+// Default returns the console writing to stdout, with logs on stderr
+func Default() *Console {
+	defaultOnce.Do(func() {
+		defaultConsole = New(os.Stdout, os.Stderr, isTerminal(os.Stdout))
+	})
+	return defaultConsole
+}
+
+// New returns a console writing status lines and printed output to out and
+// logs to logOut. Tty selects the redrawn multi-line status block.
+func New(out, logOut io.Writer, tty bool) *Console {
+	width := 80
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		width = columns
+	}
+	return &Console{out: out, logOut: logOut, tty: tty, width: width}
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// SetStatus sets status line index, 0 being the overall progress line, and
+// redraws the status. An empty line removes it from the block.
+func (c *Console) SetStatus(index int, line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.lines) <= index {
+		c.lines = append(c.lines, "")
+	}
+	c.lines[index] = line
+	for len(c.lines) > 0 && c.lines[len(c.lines)-1] == "" {
+		c.lines = c.lines[:len(c.lines)-1]
+	}
+
+	if c.tty {
+		c.erase()
+		c.draw()
+	} else if index == 0 {
+		fmt.Fprint(c.out, "\r"+line)
+		c.drawn = 1
+	}
+}
+
+// Finish ends the status block, leaving the progress line on the screen
+// followed by a newline and dropping the other status lines
+func (c *Console) Finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tty && c.drawn > 0 {
+		c.erase()
+		if len(c.lines) > 0 && c.lines[0] != "" {
+			fmt.Fprintln(c.out, c.truncate(c.lines[0]))
+		}
+	} else if c.drawn > 0 {
+		fmt.Fprintln(c.out)
+	}
+	c.lines = nil
+	c.drawn = 0
+}
+
+// Printf prints a line of output above the status block
+func (c *Console) Printf(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clear()
+	fmt.Fprintf(c.out, format, args...)
+	c.redraw()
+}
+
+// LogWriter returns a writer for log output, such as logrus', that keeps
+// log lines clear of the status block
+func (c *Console) LogWriter() io.Writer {
+	return logWriter{c}
+}
+
+// logWriter writes logs through a console
+type logWriter struct {
+	console *Console
+}
+
+// Write implements io.Writer
+func (w logWriter) Write(p []byte) (int, error) {
+	c := w.console
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clear()
+	n, err := c.logOut.Write(p)
+	c.redraw()
+	return n, err
+}
+
+// clear removes the status block before other output: erased on a
+// terminal, or ended with a newline elsewhere
+func (c *Console) clear() {
+	if c.drawn == 0 {
+		return
+	}
+	if c.tty {
+		c.erase()
+	} else {
+		fmt.Fprintln(c.out)
+		c.drawn = 0
+	}
+}
+
+// redraw draws the status block again after other output. Elsewhere than a
+// terminal the next status update rewrites the progress line instead.
+func (c *Console) redraw() {
+	if c.tty {
+		c.draw()
+	}
+}
+
+// erase removes the drawn status lines, leaving the cursor at the start of
+// the first
+func (c *Console) erase() {
+	if c.drawn == 0 {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(eraseLine)
+	for i := 1; i < c.drawn; i++ {
+		b.WriteString(cursorUp + eraseLine)
+	}
+	fmt.Fprint(c.out, b.String())
+	c.drawn = 0
+}
+
+// draw writes the status lines, each cut to the terminal width so that
+// none wraps and throws off the next erase
+func (c *Console) draw() {
+	if len(c.lines) == 0 {
+		return
+	}
+	lines := make([]string, len(c.lines))
+	for i, line := range c.lines {
+		lines[i] = c.truncate(line)
+	}
+	fmt.Fprint(c.out, strings.Join(lines, "\n"))
+	c.drawn = len(lines)
+}
+
+// truncate cuts a line to one less than the terminal width
+func (c *Console) truncate(line string) string {
+	runes := []rune(line)
+	if len(runes) < c.width {
+		return line
+	}
+	return string(runes[:c.width-1])
+}
@@ -0,0 +1,86 @@
+package console
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConsole_Terminal(t *testing.T) {
+	var out, logs bytes.Buffer
+	c := New(&out, &logs, true)
+	c.width = 20
+
+	c.SetStatus(0, "Progress: 1 of 4")
+	c.SetStatus(2, "  worker 2: a-very-long-message-id")
+	expected := "Progress: 1 of 4" +
+		eraseLine + "Progress: 1 of 4\n\n  worker 2: a-very-"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	// Logs erase the status block and it is drawn again below them
+	out.Reset()
+	fmt.Fprint(c.LogWriter(), "INFO something\n")
+	if logs.String() != "INFO something\n" {
+		t.Errorf("Expected the log line, got %q", logs.String())
+	}
+	expected = eraseLine + cursorUp + eraseLine + cursorUp + eraseLine +
+		"Progress: 1 of 4\n\n  worker 2: a-very-"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	// Clearing the last worker line shrinks the block
+	out.Reset()
+	c.SetStatus(2, "")
+	c.Finish()
+	expected = eraseLine + cursorUp + eraseLine + cursorUp + eraseLine + "Progress: 1 of 4" +
+		eraseLine + "Progress: 1 of 4\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestConsole_NotTerminal(t *testing.T) {
+	var out bytes.Buffer
+	c := New(&out, &out, false)
+
+	c.SetStatus(0, "Progress: 1 of 2")
+	c.SetStatus(1, "  worker 1: m1")
+	fmt.Fprint(c.LogWriter(), "log\n")
+	c.Printf("note\n")
+	c.SetStatus(0, "Progress: 2 of 2")
+	c.Finish()
+
+	expected := "\rProgress: 1 of 2\nlog\nnote\n\rProgress: 2 of 2\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestConsole_ConcurrentWrites(t *testing.T) {
+	var out, logs bytes.Buffer
+	c := New(&out, &logs, true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.SetStatus(worker+1, fmt.Sprintf("worker %d: %d", worker, j))
+				fmt.Fprintf(c.LogWriter(), "worker %d line %d\n", worker, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, line := range strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n") {
+		if !strings.HasPrefix(line, "worker ") || strings.Count(line, "line") != 1 {
+			t.Fatalf("Expected whole log lines, got %q", line)
+		}
+	}
+}
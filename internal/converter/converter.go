@@ -0,0 +1,307 @@
+// Package converter converts the eml files of an existing export to another
+// format offline, so a different format does not mean downloading the
+// mailbox again
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/console"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+)
+
+// Formats
+const (
+	FormatEML     = "eml"
+	FormatMbox    = "mbox"
+	FormatJSON    = "json"
+	FormatHTML    = "html"
+	FormatMaildir = "maildir"
+)
+
+// SourceFormats are the formats an export can be converted from
+var SourceFormats = []string{FormatEML}
+
+// TargetFormats are the formats an export can be converted to
+var TargetFormats = []string{FormatMbox, FormatJSON, FormatHTML, FormatMaildir}
+
+// Config represents the converter configuration
+type Config struct {
+	InputDir  string `json:"input_dir"`
+	OutputDir string `json:"output_dir"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// Result represents the conversion result
+type Result struct {
+	TotalFound     int           `json:"total_found"`
+	TotalConverted int           `json:"total_converted"`
+	TotalFailed    int           `json:"total_failed"`
+	Duration       time.Duration `json:"duration"`
+	Failures       []Failure     `json:"failures,omitempty"`
+
+	// FailedByCategory counts failures by error category (disk, unknown, ...)
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+}
+
+// Failure represents a message that could not be converted
+type Failure struct {
+	FilePath  string    `json:"file_path"`
+	Category  string    `json:"category"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// exportRecord is the subset of a processed_emails.json entry used to carry
+// a message's labels, state and date into the converted export
+type exportRecord struct {
+	File   string    `json:"file"`
+	Copies []string  `json:"copies,omitempty"`
+	Labels []string  `json:"labels,omitempty"`
+	State  []string  `json:"state,omitempty"`
+	Date   time.Time `json:"date,omitempty"`
+}
+
+// converter runs a conversion
+type converter struct {
+	config  *Config
+	records map[string]exportRecord
+	result  *Result
+	index   []indexEntry
+}
+
+// Convert converts the eml files under the input directory, keeping the
+// directory structure of label-organized exports, and writes them to the
+// output directory in the target format
+func Convert(config *Config) (*Result, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	startTime := time.Now()
+
+	records, err := loadRecords(config.InputDir)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := findMessages(config.InputDir, config.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.OutputDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	c := &converter{config: config, records: records, result: &Result{}}
+	for _, files := range dirs {
+		c.result.TotalFound += len(files)
+	}
+	logrus.WithFields(logrus.Fields{
+		"input_dir": config.InputDir,
+		"messages":  c.result.TotalFound,
+		"to":        config.To,
+	}).Info("Converting export")
+
+	rels := make([]string, 0, len(dirs))
+	for rel := range dirs {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		if err := c.convertDir(rel, dirs[rel]); err != nil {
+			return nil, err
+		}
+	}
+	console.Default().Finish()
+
+	if config.To == FormatHTML {
+		if err := c.writeIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.result.Duration = time.Since(startTime)
+	return c.result, nil
+}
+
+// validateConfig validates the converter configuration
+func validateConfig(config *Config) error {
+	if config.InputDir == "" {
+		return fmt.Errorf("input directory is required")
+	}
+	if config.OutputDir == "" {
+		return fmt.Errorf("output directory is required")
+	}
+	if config.From == "" {
+		config.From = FormatEML
+	}
+	if !slices.Contains(SourceFormats, config.From) {
+		return fmt.Errorf("unsupported source format: %s (valid: %s)", config.From, strings.Join(SourceFormats, ", "))
+	}
+	if !slices.Contains(TargetFormats, config.To) {
+		return fmt.Errorf("unsupported target format: %s (valid: %s)", config.To, strings.Join(TargetFormats, ", "))
+	}
+	if filepath.Clean(config.InputDir) == filepath.Clean(config.OutputDir) {
+		return fmt.Errorf("output directory must differ from the input directory")
+	}
+	return nil
+}
+
+// loadRecords reads the exporter's processed_emails.json, keyed by the
+// cleaned path of each export file and copy. It returns nil when the input
+// is not an export directory.
+func loadRecords(dir string) (map[string]exportRecord, error) {
+	var processed []exportRecord
+	err := atomicfile.ReadFile(filepath.Join(dir, exporter.ProcessedEmailsFile), func(data []byte) error {
+		processed = nil
+		return json.Unmarshal(data, &processed)
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", exporter.ProcessedEmailsFile, err)
+	}
+
+	records := make(map[string]exportRecord)
+	for _, record := range processed {
+		for _, file := range append([]string{record.File}, record.Copies...) {
+			if file != "" {
+				records[filepath.Join(dir, filepath.FromSlash(file))] = record
+			}
+		}
+	}
+	return records, nil
+}
+
+// findMessages returns the eml files under dir grouped by their directory
+// relative to dir, leaving out skip, hidden files and temporary files
+func findMessages(dir, skip string) (map[string][]string, error) {
+	skip = filepath.Clean(skip)
+	dirs := make(map[string][]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (filepath.Clean(path) == skip || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || strings.ToLower(filepath.Ext(path)) != ".eml" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		dirs[rel] = append(dirs[rel], path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan export directory: %w", err)
+	}
+	return dirs, nil
+}
+
+// convertDir converts the eml files of the input directory rel
+func (c *converter) convertDir(rel string, files []string) error {
+	outDir := filepath.Join(c.config.OutputDir, rel)
+	if err := os.MkdirAll(outDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	switch c.config.To {
+	case FormatMbox:
+		return c.writeMbox(filepath.Join(outDir, mboxFileName), files)
+	case FormatMaildir:
+		if err := createMaildir(outDir); err != nil {
+			return err
+		}
+		c.each(files, func(msg *message, record exportRecord) error {
+			return writeMaildirMessage(outDir, msg, record.State)
+		})
+	case FormatJSON:
+		c.each(files, func(msg *message, record exportRecord) error {
+			return writeJSON(filepath.Join(outDir, msg.id+".json"), msg, record)
+		})
+	case FormatHTML:
+		c.each(files, func(msg *message, record exportRecord) error {
+			path := filepath.Join(outDir, msg.id+".html")
+			if err := writeHTML(path, msg); err != nil {
+				return err
+			}
+			c.addToIndex(path, msg)
+			return nil
+		})
+	}
+	return nil
+}
+
+// each reads and parses each file and calls convert with it, recording the
+// files that fail
+func (c *converter) each(files []string, convert func(*message, exportRecord) error) {
+	for _, path := range files {
+		record := c.records[filepath.Clean(path)]
+		msg, err := readMessage(path, record)
+		if err == nil {
+			err = convert(msg, record)
+		}
+		c.record(path, err)
+	}
+}
+
+// readMessage reads and parses an eml file
+func readMessage(path string, record exportRecord) (*message, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	fallback := record.Date
+	if fallback.IsZero() {
+		if info, err := os.Stat(path); err == nil {
+			fallback = info.ModTime()
+		}
+	}
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return parseMessage(id, raw, fallback)
+}
+
+// record counts the outcome of converting a file
+func (c *converter) record(path string, err error) {
+	if err != nil {
+		category := string(failure.Categorize(err))
+		c.result.TotalFailed++
+		if c.result.FailedByCategory == nil {
+			c.result.FailedByCategory = make(map[string]int)
+		}
+		c.result.FailedByCategory[category]++
+		c.result.Failures = append(c.result.Failures, Failure{
+			FilePath:  path,
+			Category:  category,
+			Error:     err.Error(),
+			Timestamp: time.Now(),
+		})
+		logrus.WithError(err).WithField("file_path", path).Error("Failed to convert email")
+	} else {
+		c.result.TotalConverted++
+	}
+
+	done := c.result.TotalConverted + c.result.TotalFailed
+	console.Default().SetStatus(0, fmt.Sprintf("Progress: %d of %d messages converted (%.1f%%)",
+		done, c.result.TotalFound, float64(done)/float64(c.result.TotalFound)*100))
+}
@@ -0,0 +1,220 @@
+package converter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+const plainMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: =?UTF-8?B?SGVsbG8g8J+Riw==?=\r\n" +
+	"Date: Mon, 02 Jan 2023 15:04:05 +0000\r\n" +
+	"\r\n" +
+	"Hi Bob,\r\nFrom here on it's mbox quoting.\r\n"
+
+const multipartMessage = "From: carol@example.com\r\n" +
+	"Subject: Report\r\n" +
+	"Date: Tue, 03 Jan 2023 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>Caf=E9</p><script>alert(1)</script>\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/pdf; name=report.pdf\r\n" +
+	"Content-Disposition: attachment; filename=report.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQ=\r\n" +
+	"--b1--\r\n"
+
+// writeExport writes a label-organized export of the two messages with
+// their processed_emails.json
+func writeExport(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"INBOX/m1.eml":    plainMessage,
+		"Label_7/m2.eml":  multipartMessage,
+		"metrics.json":    "{}",
+		".m3.eml.tmp.eml": "partial",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processed := `[
+		{"id": "m1", "file": "INBOX/m1.eml", "labels": ["INBOX"], "state": ["UNREAD", "STARRED"]},
+		{"id": "m2", "file": "Label_7/m2.eml", "labels": ["Label_7"]}
+	]`
+	if err := os.WriteFile(filepath.Join(dir, exporter.ProcessedEmailsFile), []byte(processed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// convert converts the export to format into a new directory
+func convert(t *testing.T, input, format string) string {
+	t.Helper()
+	output := filepath.Join(t.TempDir(), "out")
+	result, err := Convert(&Config{InputDir: input, OutputDir: output, To: format})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if result.TotalFound != 2 || result.TotalConverted != 2 || result.TotalFailed != 0 {
+		t.Fatalf("Expected 2 of 2 messages converted, got %+v", result)
+	}
+	return output
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestConvertMbox(t *testing.T) {
+	output := convert(t, writeExport(t), FormatMbox)
+
+	inbox := readFile(t, filepath.Join(output, "INBOX", mboxFileName))
+	if !strings.HasPrefix(inbox, "From alice@example.com Mon Jan  2 15:04:05 2023\nStatus: O\nX-Status: F\n") {
+		t.Errorf("Expected a separator line and the unread, starred state, got:\n%s", inbox)
+	}
+	if !strings.Contains(inbox, "\n>From here on") {
+		t.Errorf("Expected body lines starting with From to be quoted, got:\n%s", inbox)
+	}
+	if strings.Contains(inbox, "\r\n") {
+		t.Error("Expected mbox line endings to be LF")
+	}
+
+	label := readFile(t, filepath.Join(output, "Label_7", mboxFileName))
+	if !strings.Contains(label, "Status: RO\n") || strings.Contains(label, "X-Status") {
+		t.Errorf("Expected a read, unstarred message, got:\n%s", label)
+	}
+}
+
+func TestConvertMaildir(t *testing.T) {
+	output := convert(t, writeExport(t), FormatMaildir)
+
+	for dir, flags := range map[string]string{"INBOX": "2,F", "Label_7": "2,S"} {
+		entries, err := os.ReadDir(filepath.Join(output, dir, "cur"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), maildirInfoSeparator+flags) {
+			t.Errorf("Expected one message flagged %s in %s/cur, got %v", flags, dir, entries)
+		}
+		if tmp, _ := os.ReadDir(filepath.Join(output, dir, "tmp")); len(tmp) != 0 {
+			t.Errorf("Expected tmp to be empty, got %v", tmp)
+		}
+	}
+}
+
+func TestConvertJSON(t *testing.T) {
+	output := convert(t, writeExport(t), FormatJSON)
+
+	var msg gmail.Message
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(output, "INBOX", "m1.json"))), &msg); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if msg.Id != "m1" || strings.Join(msg.LabelIds, ",") != "INBOX,UNREAD,STARRED" {
+		t.Errorf("Expected ID m1 with labels and state, got %q %v", msg.Id, msg.LabelIds)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(msg.Raw)
+	if err != nil || string(raw) != plainMessage {
+		t.Errorf("Expected the raw message to round-trip, got %q (%v)", raw, err)
+	}
+
+	var multipart gmail.Message
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(output, "Label_7", "m2.json"))), &multipart); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	parts := multipart.Payload.Parts
+	if multipart.Payload.MimeType != "multipart/mixed" || len(parts) != 2 {
+		t.Fatalf("Expected a multipart payload with 2 parts, got %+v", multipart.Payload)
+	}
+	if parts[1].PartId != "1" || parts[1].Filename != "report.pdf" || parts[1].Body.Size != 8 {
+		t.Errorf("Expected the decoded attachment, got %+v %+v", parts[1], parts[1].Body)
+	}
+}
+
+func TestConvertHTML(t *testing.T) {
+	output := convert(t, writeExport(t), FormatHTML)
+
+	page := readFile(t, filepath.Join(output, "Label_7", "m2.html"))
+	if !strings.Contains(page, "<iframe sandbox srcdoc=") || !strings.Contains(page, "Café") {
+		t.Errorf("Expected the transcoded HTML body in a sandboxed frame, got:\n%s", page)
+	}
+	if strings.Contains(page, "<script>") {
+		t.Error("Expected the HTML body to be escaped into the frame")
+	}
+	if !strings.Contains(page, "report.pdf (8 bytes)") {
+		t.Errorf("Expected the attachment to be listed, got:\n%s", page)
+	}
+
+	plain := readFile(t, filepath.Join(output, "INBOX", "m1.html"))
+	if !strings.Contains(plain, "<title>Hello 👋</title>") || !strings.Contains(plain, "<pre>Hi Bob,") {
+		t.Errorf("Expected the decoded subject and text body, got:\n%s", plain)
+	}
+
+	index := readFile(t, filepath.Join(output, indexFileName))
+	if strings.Index(index, "Label_7/m2.html") > strings.Index(index, "INBOX/m1.html") {
+		t.Errorf("Expected the index to list the newest message first, got:\n%s", index)
+	}
+}
+
+func TestConvertRecordsFailures(t *testing.T) {
+	input := t.TempDir()
+	if err := os.WriteFile(filepath.Join(input, "bad.eml"), []byte("not a message"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Convert(&Config{InputDir: input, OutputDir: filepath.Join(t.TempDir(), "out"), To: FormatJSON})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if result.TotalFailed != 1 || len(result.Failures) != 1 {
+		t.Errorf("Expected the unparsable message to be recorded as failed, got %+v", result)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"missing input", Config{OutputDir: "out", To: FormatMbox}},
+		{"missing output", Config{InputDir: "in", To: FormatMbox}},
+		{"unsupported source", Config{InputDir: "in", OutputDir: "out", From: FormatJSON, To: FormatMbox}},
+		{"unsupported target", Config{InputDir: "in", OutputDir: "out", To: "pst"}},
+		{"same directory", Config{InputDir: "in", OutputDir: "in/", To: FormatMbox}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateConfig(&tt.config); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// mboxFileName is the mbox file written for each directory of the export
+const mboxFileName = "messages.mbox"
+
+// writeMbox writes the messages of files to a single mbox file, with mboxrd
+// quoting and their read and starred state in Status and X-Status headers
+func (c *converter) writeMbox(path string, files []string) error {
+	err := atomicfile.Write(path, 0o600, atomicfile.Options{}, func(w io.Writer) error {
+		buffered := bufio.NewWriter(w)
+		c.each(files, func(msg *message, record exportRecord) error {
+			writeMboxMessage(buffered, msg, record.State)
+			return nil
+		})
+		return buffered.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write mbox %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeMboxMessage appends a message to an mbox file
+func writeMboxMessage(w *bufio.Writer, msg *message, state []string) {
+	fmt.Fprintf(w, "From %s %s\n", msg.sender(), msg.date.UTC().Format(time.ANSIC))
+	if slices.Contains(state, "UNREAD") {
+		fmt.Fprintf(w, "Status: O\n")
+	} else {
+		fmt.Fprintf(w, "Status: RO\n")
+	}
+	if slices.Contains(state, "STARRED") {
+		fmt.Fprintf(w, "X-Status: F\n")
+	}
+
+	raw := bytes.ReplaceAll(msg.raw, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			w.WriteByte('>')
+		}
+		w.Write(line)
+	}
+
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		w.WriteByte('\n')
+	}
+	w.WriteByte('\n')
+}
+
+// maildirInfoSeparator starts the flags of a maildir file name. Windows
+// does not allow ':' in file names, so mail clients there use '!'.
+var maildirInfoSeparator = func() string {
+	if runtime.GOOS == "windows" {
+		return "!"
+	}
+	return ":"
+}()
+
+// createMaildir creates the tmp, new and cur directories of a maildir
+func createMaildir(dir string) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeMaildirMessage delivers a message to the cur directory of a maildir
+// through its tmp directory, flagged seen unless unread and flagged when
+// starred
+func writeMaildirMessage(dir string, msg *message, state []string) error {
+	var flags string
+	if slices.Contains(state, "STARRED") {
+		flags += "F"
+	}
+	if !slices.Contains(state, "UNREAD") {
+		flags += "S"
+	}
+
+	unique := fmt.Sprintf("%d.%s.gmail-exporter", msg.date.Unix(), msg.id)
+	tmp := filepath.Join(dir, "tmp", unique)
+	if err := os.WriteFile(tmp, msg.raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write maildir message: %w", err)
+	}
+	if err := os.Chtimes(tmp, msg.date, msg.date); err != nil {
+		return fmt.Errorf("failed to date maildir message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "cur", unique+maildirInfoSeparator+"2,"+flags)); err != nil {
+		return fmt.Errorf("failed to deliver maildir message: %w", err)
+	}
+	return nil
+}
+
+// writeJSON writes a message in the Gmail API representation used by json
+// exports, with the raw message so that it can be imported
+func writeJSON(path string, msg *message, record exportRecord) error {
+	labels := slices.Clone(record.Labels)
+	for _, label := range record.State {
+		if !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+
+	data, err := json.MarshalIndent(&gmail.Message{
+		Id:           msg.id,
+		LabelIds:     labels,
+		InternalDate: msg.date.UnixMilli(),
+		SizeEstimate: int64(len(msg.raw)),
+		Payload:      msg.payload,
+		Raw:          base64.RawURLEncoding.EncodeToString(msg.raw),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal message to JSON: %w", err)
+	}
+
+	if err := atomicfile.Write(path, 0o600, atomicfile.Options{}, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to write JSON file: %w", err)
+	}
+	return nil
+}
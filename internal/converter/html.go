@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// indexFileName is the page listing the messages of an html conversion
+const indexFileName = "index.html"
+
+// messageTemplate renders a message as a standalone page. HTML bodies are
+// shown in a sandboxed frame, so their scripts do not run.
+var messageTemplate = template.Must(template.New("message").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Subject}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table.headers td { padding: 2px 8px; vertical-align: top; }
+table.headers td:first-child { font-weight: bold; color: #555; }
+iframe { width: 100%; height: 80vh; border: 1px solid #ccc; }
+pre { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Subject}}</h1>
+<table class="headers">
+{{range .Headers}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+<hr>
+{{if .HTML}}<iframe sandbox srcdoc="{{.HTML}}"></iframe>
+{{else}}<pre>{{.Text}}</pre>
+{{end}}{{if .Attachments}}<h2>Attachments</h2>
+<ul>
+{{range .Attachments}}<li>{{.Name}} ({{.Size}} bytes)</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+// indexTemplate renders the list of converted messages, newest first
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Exported messages</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+td { padding: 2px 8px; }
+</style>
+</head>
+<body>
+<h1>Exported messages ({{len .}})</h1>
+<table>
+<tr><th>Date</th><th>From</th><th>Subject</th></tr>
+{{range .}}<tr><td>{{.Date.Format "2006-01-02 15:04"}}</td><td>{{.From}}</td><td><a href="{{.Link}}">{{.Subject}}</a></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// htmlHeader is a header shown on a message page
+type htmlHeader struct {
+	Name, Value string
+}
+
+// htmlAttachment is an attachment listed on a message page
+type htmlAttachment struct {
+	Name string
+	Size int64
+}
+
+// indexEntry is a message listed on the index page
+type indexEntry struct {
+	Date          time.Time
+	From, Subject string
+	Link          string
+}
+
+// writeHTML writes a message as an HTML page
+func writeHTML(path string, msg *message) error {
+	page := struct {
+		Subject     string
+		Headers     []htmlHeader
+		HTML, Text  string
+		Attachments []htmlAttachment
+	}{Subject: msg.subject()}
+
+	for _, name := range []string{"From", "To", "Cc", "Date", "Subject"} {
+		if value := msg.header.Get(name); value != "" {
+			page.Headers = append(page.Headers, htmlHeader{Name: name, Value: mimepart.DecodeHeader(value)})
+		}
+	}
+	if part := msg.findPart("text/html"); part != nil {
+		page.HTML = partText(part)
+	} else if part := msg.findPart("text/plain"); part != nil {
+		page.Text = partText(part)
+	}
+	for _, part := range msg.attachments() {
+		page.Attachments = append(page.Attachments, htmlAttachment{Name: part.Filename, Size: part.Body.Size})
+	}
+
+	if err := atomicfile.Write(path, 0o600, atomicfile.Options{}, func(w io.Writer) error {
+		return messageTemplate.Execute(w, page)
+	}); err != nil {
+		return fmt.Errorf("failed to write HTML file: %w", err)
+	}
+	return nil
+}
+
+// addToIndex lists the page of a message on the index page
+func (c *converter) addToIndex(path string, msg *message) {
+	link, err := filepath.Rel(c.config.OutputDir, path)
+	if err != nil {
+		return
+	}
+	c.index = append(c.index, indexEntry{
+		Date:    msg.date,
+		From:    msg.from(),
+		Subject: msg.subject(),
+		Link:    filepath.ToSlash(link),
+	})
+}
+
+// writeIndex writes the index page of an html conversion
+func (c *converter) writeIndex() error {
+	sort.SliceStable(c.index, func(i, j int) bool {
+		return c.index[i].Date.After(c.index[j].Date)
+	})
+
+	path := filepath.Join(c.config.OutputDir, indexFileName)
+	if err := atomicfile.Write(path, 0o600, atomicfile.Options{}, func(w io.Writer) error {
+		return indexTemplate.Execute(w, c.index)
+	}); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"time"
+
+	"golang.org/x/net/html/charset"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// message is a parsed eml file
+type message struct {
+	id      string
+	raw     []byte
+	header  mail.Header
+	date    time.Time
+	payload *gmail.MessagePart
+}
+
+// parseMessage parses the raw message of an eml file named after its
+// message ID. The date falls back to fallback when the Date header is
+// missing or invalid.
+func parseMessage(id string, raw []byte, fallback time.Time) (*message, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	date, err := parsed.Header.Date()
+	if err != nil {
+		date = fallback
+	}
+	payload, err := mimepart.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	return &message{
+		id:      id,
+		raw:     raw,
+		header:  parsed.Header,
+		date:    date,
+		payload: payload,
+	}, nil
+}
+
+// subject returns the decoded Subject header
+func (m *message) subject() string {
+	return mimepart.DecodeHeader(m.header.Get("Subject"))
+}
+
+// from returns the decoded From header
+func (m *message) from() string {
+	return mimepart.DecodeHeader(m.header.Get("From"))
+}
+
+// sender returns the envelope sender address for an mbox separator line
+func (m *message) sender() string {
+	for _, name := range []string{"Return-Path", "From"} {
+		if address, err := mail.ParseAddress(m.header.Get(name)); err == nil && address.Address != "" {
+			return address.Address
+		}
+	}
+	return "MAILER-DAEMON"
+}
+
+// findPart returns the first leaf part of mimeType that is not an
+// attachment, or nil
+func (m *message) findPart(mimeType string) *gmail.MessagePart {
+	var found *gmail.MessagePart
+	walkLeaves(m.payload, func(part *gmail.MessagePart) {
+		if found == nil && part.MimeType == mimeType && part.Filename == "" {
+			found = part
+		}
+	})
+	return found
+}
+
+// attachments returns the leaf parts with a filename
+func (m *message) attachments() []*gmail.MessagePart {
+	var attachments []*gmail.MessagePart
+	walkLeaves(m.payload, func(part *gmail.MessagePart) {
+		if part.Filename != "" {
+			attachments = append(attachments, part)
+		}
+	})
+	return attachments
+}
+
+// walkLeaves calls visit for each leaf part under part
+func walkLeaves(part *gmail.MessagePart, visit func(*gmail.MessagePart)) {
+	if len(part.Parts) == 0 {
+		visit(part)
+		return
+	}
+	for _, child := range part.Parts {
+		walkLeaves(child, visit)
+	}
+}
+
+// partText returns the body of a text part as UTF-8, transcoded from the
+// charset of its Content-Type
+func partText(part *gmail.MessagePart) string {
+	content, err := mimepart.Data(part)
+	if err != nil {
+		return ""
+	}
+
+	var label string
+	if _, params, err := mime.ParseMediaType(mimepart.Header(part.Headers, "Content-Type")); err == nil {
+		label = params["charset"]
+	}
+	if label == "" {
+		return string(content)
+	}
+
+	reader, err := charset.NewReaderLabel(label, bytes.NewReader(content))
+	if err != nil {
+		return string(content)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return string(content)
+	}
+	return string(decoded)
+}
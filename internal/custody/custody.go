@@ -0,0 +1,352 @@
+package custody
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+)
+
+// File names written to an export directory in legal hold mode
+const (
+	ManifestFileName = "custody_manifest.json"
+	HoldFileName     = "legal_hold.json"
+)
+
+// KeyEnvVar holds the manifest signing key when no key file is given
+const KeyEnvVar = "GMAIL_EXPORTER_CUSTODY_KEY"
+
+// ManifestVersion identifies the manifest layout
+const ManifestVersion = "gmail-exporter-custody/v1"
+
+// SignatureAlgorithm is the algorithm used to sign manifests
+const SignatureAlgorithm = "hmac-sha256"
+
+// Manifest is the chain-of-custody record of a legal hold export
+type Manifest struct {
+	Version         string    `json:"version"`
+	ExporterVersion string    `json:"exporter_version"`
+	Operator        string    `json:"operator"`
+	Account         string    `json:"account,omitempty"`
+	Query           string    `json:"query"`
+	StartedAt       time.Time `json:"started_at"`
+	CompletedAt     time.Time `json:"completed_at,omitempty"`
+	Messages        []Record  `json:"messages"`
+
+	Signatures
+}
+
+// Signatures are the signatures of a manifest that embeds them: the HMAC of
+// the custody key and an optional Ed25519 signature, both over the JSON of
+// the manifest without its signatures
+type Signatures struct {
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	Signature          string `json:"signature,omitempty"`
+
+	// Ed25519Signature is the optional public key signature of the
+	// manifest by the key Ed25519KeyID identifies, which can be checked
+	// without the HMAC key
+	Ed25519KeyID     string `json:"ed25519_key_id,omitempty"`
+	Ed25519Signature string `json:"ed25519_signature,omitempty"`
+}
+
+// signatures returns the signatures of the manifest embedding them
+func (s *Signatures) signatures() *Signatures {
+	return s
+}
+
+// Signed is a manifest signed as custody manifests are, by embedding
+// Signatures
+type Signed interface {
+	signatures() *Signatures
+}
+
+// Record is the custody entry of a single exported message
+type Record struct {
+	ID string `json:"id"`
+	// RawSHA256 is the checksum of the raw RFC 822 message as held by Gmail
+	RawSHA256 string `json:"raw_sha256"`
+	// File and FileSHA256 identify the export file relative to the export
+	// directory
+	File       string    `json:"file"`
+	FileSHA256 string    `json:"file_sha256"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	// PrevHash is the Hash of the record before, and Hash the SHA-256 of
+	// this record's JSON without Hash (see Chain)
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Hold marks an export directory as under legal hold
+type Hold struct {
+	PlacedAt time.Time `json:"placed_at"`
+	Operator string    `json:"operator"`
+	Account  string    `json:"account,omitempty"`
+	Query    string    `json:"query"`
+}
+
+// LoadKey reads the manifest signing key from keyFile, or from the
+// GMAIL_EXPORTER_CUSTODY_KEY environment variable when keyFile is empty
+func LoadKey(keyFile string) ([]byte, error) {
+	var key []byte
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read custody key file: %w", err)
+		}
+		key = []byte(strings.TrimSpace(string(data)))
+	} else {
+		key = []byte(os.Getenv(KeyEnvVar))
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("a custody signing key is required (--custody-key-file or %s)", KeyEnvVar)
+	}
+
+	return key, nil
+}
+
+// Sign signs the manifest m with key
+func Sign(m Signed, key []byte) error {
+	signature, err := hmacSignature(m, key)
+	if err != nil {
+		return err
+	}
+
+	m.signatures().SignatureAlgorithm = SignatureAlgorithm
+	m.signatures().Signature = signature
+	return nil
+}
+
+// Verify checks the signature of the manifest m against key
+func Verify(m Signed, key []byte) error {
+	signatures := m.signatures()
+	if signatures.SignatureAlgorithm != SignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm: %q", signatures.SignatureAlgorithm)
+	}
+
+	expected, err := hmacSignature(m, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signatures.Signature)) {
+		return fmt.Errorf("manifest signature does not match")
+	}
+
+	return nil
+}
+
+// SignEd25519 adds the public key signature of the manifest m
+func SignEd25519(m Signed, key ed25519.PrivateKey) error {
+	data, err := unsigned(m)
+	if err != nil {
+		return err
+	}
+
+	m.signatures().Ed25519KeyID = signing.KeyID(key.Public().(ed25519.PublicKey))
+	m.signatures().Ed25519Signature = signing.Sign(key, data)
+	return nil
+}
+
+// VerifyEd25519 checks the public key signature of the manifest m
+func VerifyEd25519(m Signed, key ed25519.PublicKey) error {
+	signature := m.signatures().Ed25519Signature
+	if signature == "" {
+		return fmt.Errorf("manifest has no Ed25519 signature")
+	}
+
+	data, err := unsigned(m)
+	if err != nil {
+		return err
+	}
+	if err := signing.Verify(key, data, signature); err != nil {
+		return fmt.Errorf("manifest Ed25519 %w", err)
+	}
+	return nil
+}
+
+// hmacSignature computes the HMAC of the manifest without its signatures
+func hmacSignature(m Signed, key []byte) (string, error) {
+	data, err := unsigned(m)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// unsigned returns the JSON of the manifest without its signatures, which
+// are cleared while it is marshalled
+func unsigned(m Signed) ([]byte, error) {
+	signatures := m.signatures()
+	saved := *signatures
+	*signatures = Signatures{}
+	defer func() { *signatures = saved }()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return data, nil
+}
+
+// Chain links each record to the record before it by hash, so that a
+// record edited, removed or reordered breaks the chain
+func (m *Manifest) Chain() error {
+	prev := ""
+	for i := range m.Messages {
+		m.Messages[i].PrevHash = prev
+		hash, err := m.Messages[i].hash()
+		if err != nil {
+			return err
+		}
+		m.Messages[i].Hash = hash
+		prev = hash
+	}
+	return nil
+}
+
+// VerifyChain checks the hash chain of the records. Manifests written
+// before records were chained have no chain and pass.
+func (m *Manifest) VerifyChain() error {
+	prev := ""
+	for i, record := range m.Messages {
+		if i == 0 && record.Hash == "" {
+			break
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("record %d (%s) was modified", i+1, record.ID)
+		}
+		if record.PrevHash != prev {
+			return fmt.Errorf("record %d (%s) does not follow the record before it", i+1, record.ID)
+		}
+		prev = hash
+	}
+	return nil
+}
+
+// Chained reports whether the records are hash chained
+func (m *Manifest) Chained() bool {
+	return len(m.Messages) > 0 && m.Messages[0].Hash != ""
+}
+
+// hash returns the SHA-256 of the record's JSON without Hash
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal custody record: %w", err)
+	}
+	return signing.Hash(data), nil
+}
+
+// Save writes the manifest to path
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := atomicfile.WriteFileBackup(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write custody manifest: %w", err)
+	}
+
+	return nil
+}
+
+// LoadManifest reads the manifest at path
+func LoadManifest(path string) (*Manifest, error) {
+	var manifest Manifest
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		manifest = Manifest{}
+		return json.Unmarshal(data, &manifest)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read custody manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// VerifyFiles recomputes the checksum of each export file recorded in the
+// manifest and returns the records whose file is missing or changed
+func (m *Manifest) VerifyFiles(dir string) []Record {
+	var mismatched []Record
+	for _, record := range m.Messages {
+		sum, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(record.File)))
+		if err != nil || sum != record.FileSHA256 {
+			mismatched = append(mismatched, record)
+		}
+	}
+	return mismatched
+}
+
+// fileSHA256 returns the hex SHA-256 checksum of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// PlaceHold marks dir as under legal hold. An existing hold is kept as is.
+func PlaceHold(dir string, hold Hold) error {
+	existing, err := CheckHold(dir)
+	if err != nil || existing != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(hold, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal legal hold: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(filepath.Join(dir, HoldFileName), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write legal hold: %w", err)
+	}
+
+	return nil
+}
+
+// CheckHold returns the legal hold placed on dir, or nil if there is none
+func CheckHold(dir string) (*Hold, error) {
+	data, err := os.ReadFile(filepath.Join(dir, HoldFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legal hold: %w", err)
+	}
+
+	var hold Hold
+	if err := json.Unmarshal(data, &hold); err != nil {
+		// An unreadable hold file still marks the directory as held
+		return &Hold{}, nil
+	}
+
+	return &hold, nil
+}
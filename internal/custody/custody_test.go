@@ -0,0 +1,213 @@
+package custody
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+)
+
+func testManifest() *Manifest {
+	return &Manifest{
+		Version:         ManifestVersion,
+		ExporterVersion: "1.2.3",
+		Operator:        "counsel",
+		Account:         "user@example.com",
+		Query:           "from:someone@example.com",
+		StartedAt:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		CompletedAt:     time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC),
+		Messages: []Record{
+			{ID: "a", RawSHA256: "raw", File: "a.eml", FileSHA256: "file", ExportedAt: time.Date(2024, 1, 2, 3, 30, 0, 0, time.UTC)},
+		},
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("secret")
+	manifest := testManifest()
+
+	if err := Sign(manifest, key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := Verify(manifest, key); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := Verify(manifest, []byte("other")); err == nil {
+		t.Error("Expected verification with a different key to fail")
+	}
+
+	manifest.Messages[0].RawSHA256 = "tampered"
+	if err := Verify(manifest, key); err == nil {
+		t.Error("Expected verification of a tampered manifest to fail")
+	}
+}
+
+func TestSaveLoadManifest(t *testing.T) {
+	key := []byte("secret")
+	path := filepath.Join(t.TempDir(), ManifestFileName)
+
+	manifest := testManifest()
+	if err := Sign(manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+	if err := Verify(loaded, key); err != nil {
+		t.Errorf("Verify() after reload error = %v", err)
+	}
+}
+
+func TestVerifyFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.eml"), []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := &Manifest{Messages: []Record{
+		// SHA-256 of "hello"
+		{ID: "a", File: "a.eml", FileSHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{ID: "b", File: "b.eml", FileSHA256: "missing"},
+	}}
+
+	mismatched := manifest.VerifyFiles(dir)
+	if len(mismatched) != 1 || mismatched[0].ID != "b" {
+		t.Errorf("Expected only b to mismatch, got %+v", mismatched)
+	}
+}
+
+func TestHold(t *testing.T) {
+	dir := t.TempDir()
+
+	hold, err := CheckHold(dir)
+	if err != nil || hold != nil {
+		t.Fatalf("CheckHold() = %v, %v; want no hold", hold, err)
+	}
+
+	if err := PlaceHold(dir, Hold{Operator: "counsel"}); err != nil {
+		t.Fatalf("PlaceHold() error = %v", err)
+	}
+	// A second hold does not replace the first
+	if err := PlaceHold(dir, Hold{Operator: "someone else"}); err != nil {
+		t.Fatalf("PlaceHold() error = %v", err)
+	}
+
+	hold, err = CheckHold(dir)
+	if err != nil || hold == nil {
+		t.Fatalf("CheckHold() = %v, %v; want a hold", hold, err)
+	}
+	if hold.Operator != "counsel" {
+		t.Errorf("Expected original hold by counsel, got %q", hold.Operator)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	t.Setenv(KeyEnvVar, "")
+	if _, err := LoadKey(""); err == nil {
+		t.Error("Expected error without a key")
+	}
+
+	t.Setenv(KeyEnvVar, "from-env")
+	if key, err := LoadKey(""); err != nil || string(key) != "from-env" {
+		t.Errorf("LoadKey() = %q, %v; want key from environment", key, err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := LoadKey(keyFile); err != nil || string(key) != "from-file" {
+		t.Errorf("LoadKey() = %q, %v; want key from file", key, err)
+	}
+}
+
+func TestSignVerifyEd25519(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := testManifest()
+	if err := VerifyEd25519(manifest, public); err == nil {
+		t.Error("Expected verification of an unsigned manifest to fail")
+	}
+
+	// Both signatures cover the manifest without the other's fields
+	if err := SignEd25519(manifest, private); err != nil {
+		t.Fatalf("SignEd25519() error = %v", err)
+	}
+	if err := Sign(manifest, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyEd25519(manifest, public); err != nil {
+		t.Errorf("VerifyEd25519() error = %v", err)
+	}
+	if err := Verify(manifest, []byte("secret")); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if manifest.Ed25519KeyID != signing.KeyID(public) {
+		t.Errorf("Ed25519KeyID = %q, want %q", manifest.Ed25519KeyID, signing.KeyID(public))
+	}
+	if err := VerifyEd25519(manifest, otherPublic); err == nil {
+		t.Error("Expected verification with a different key to fail")
+	}
+
+	manifest.Operator = "someone else"
+	if err := VerifyEd25519(manifest, public); err == nil {
+		t.Error("Expected verification of a tampered manifest to fail")
+	}
+}
+
+func TestChain(t *testing.T) {
+	chained := func() *Manifest {
+		manifest := testManifest()
+		manifest.Messages = append(manifest.Messages,
+			Record{ID: "b", RawSHA256: "raw-b", File: "b.eml", FileSHA256: "file-b"},
+			Record{ID: "c", RawSHA256: "raw-c", File: "c.eml", FileSHA256: "file-c"},
+		)
+		if err := manifest.Chain(); err != nil {
+			t.Fatalf("Chain() error = %v", err)
+		}
+		return manifest
+	}
+
+	manifest := chained()
+	if !manifest.Chained() || manifest.Messages[1].PrevHash != manifest.Messages[0].Hash {
+		t.Fatalf("Expected the records to be chained: %+v", manifest.Messages)
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(m *Manifest)
+		wantErr bool
+	}{
+		{"intact", func(m *Manifest) {}, false},
+		{"unchained", func(m *Manifest) { *m = *testManifest() }, false},
+		{"modified", func(m *Manifest) { m.Messages[1].FileSHA256 = "other" }, true},
+		{"removed", func(m *Manifest) { m.Messages = append(m.Messages[:1], m.Messages[2]) }, true},
+		{"reordered", func(m *Manifest) { m.Messages[1], m.Messages[2] = m.Messages[2], m.Messages[1] }, true},
+		{"truncated at the start", func(m *Manifest) { m.Messages = m.Messages[1:] }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := chained()
+			tt.tamper(manifest)
+			if err := manifest.VerifyChain(); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
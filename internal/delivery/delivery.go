@@ -0,0 +1,399 @@
+package delivery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// Delivery statuses of a recipient
+const (
+	StatusSent    = "sent"
+	StatusDelayed = "delayed"
+	StatusBounced = "bounced"
+)
+
+// Record is the delivery outcome of one recipient of a sent message
+type Record struct {
+	// ID is the Gmail message ID of the sent message
+	ID        string    `json:"id"`
+	Date      time.Time `json:"date"`
+	Subject   string    `json:"subject"`
+	Recipient string    `json:"recipient"`
+	// Field is the header the recipient was addressed in (to, cc, bcc)
+	Field  string `json:"field"`
+	Status string `json:"status"`
+
+	// Bounce details, set when a delivery status notification was found
+	BounceID   string    `json:"bounce_id,omitempty"`
+	BouncedAt  time.Time `json:"bounced_at,omitempty"`
+	DSNStatus  string    `json:"dsn_status,omitempty"`
+	Diagnostic string    `json:"diagnostic,omitempty"`
+
+	messageID string
+}
+
+// Bounce is a failed or delayed delivery reported for one recipient
+type Bounce struct {
+	// ID is the Gmail message ID of the notification
+	ID         string    `json:"id"`
+	Date       time.Time `json:"date"`
+	Recipient  string    `json:"recipient"`
+	Action     string    `json:"action"`
+	DSNStatus  string    `json:"dsn_status,omitempty"`
+	Diagnostic string    `json:"diagnostic,omitempty"`
+	// OriginalMessageID is the Message-ID header of the bounced message,
+	// when the notification includes it
+	OriginalMessageID string `json:"original_message_id,omitempty"`
+}
+
+// Report lists who each sent message was addressed to and which deliveries
+// bounced
+type Report struct {
+	Source    string    `json:"source"`
+	Generated time.Time `json:"generated"`
+
+	Messages int `json:"messages"`
+	Sent     int `json:"sent"`
+	Delayed  int `json:"delayed"`
+	Bounced  int `json:"bounced"`
+
+	Records []Record `json:"records"`
+	// Unmatched lists bounces for which no sent message was found
+	Unmatched []Bounce `json:"unmatched_bounces"`
+}
+
+// Build reads the eml files of an export directory and builds its delivery
+// report. Messages from sender are treated as sent mail; with no sender every
+// message that is not a bounce is, which suits exports of the SENT label.
+func Build(dir, sender string) (*Report, error) {
+	report := &Report{Source: dir, Generated: time.Now().UTC(), Records: []Record{}, Unmatched: []Bounce{}}
+	sender = strings.ToLower(sender)
+
+	var bounces []Bounce
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.ToLower(filepath.Ext(path)) != ".eml" {
+			return nil
+		}
+
+		// Messages copied into several label directories are read once
+		id := strings.TrimSuffix(d.Name(), filepath.Ext(path))
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		message, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			logrus.WithError(err).WithField("file", path).Warn("Skipping unreadable message")
+			return nil
+		}
+
+		if isBounce(message.Header) {
+			bounces = append(bounces, parseBounce(id, message)...)
+			return nil
+		}
+		if sender != "" && !fromSender(message.Header, sender) {
+			return nil
+		}
+
+		report.Messages++
+		report.Records = append(report.Records, sentRecords(id, message.Header)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan export directory: %w", err)
+	}
+
+	sort.SliceStable(report.Records, func(i, j int) bool {
+		return report.Records[i].Date.Before(report.Records[j].Date)
+	})
+	report.match(bounces)
+
+	for _, record := range report.Records {
+		switch record.Status {
+		case StatusBounced:
+			report.Bounced++
+		case StatusDelayed:
+			report.Delayed++
+		default:
+			report.Sent++
+		}
+	}
+
+	return report, nil
+}
+
+// sentRecords returns a record per recipient of a sent message
+func sentRecords(id string, header mail.Header) []Record {
+	date, _ := header.Date()
+	subject := mimepart.DecodeHeader(header.Get("Subject"))
+	messageID := normalizeMessageID(header.Get("Message-Id"))
+
+	var records []Record
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		addresses, err := header.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			records = append(records, Record{
+				ID:        id,
+				Date:      date.UTC(),
+				Subject:   subject,
+				Recipient: strings.ToLower(address.Address),
+				Field:     strings.ToLower(field),
+				Status:    StatusSent,
+				messageID: messageID,
+			})
+		}
+	}
+	return records
+}
+
+// match marks the records of the bounced deliveries. A bounce is matched to
+// the sent message it quotes, or else to the latest message sent to the
+// recipient before the bounce arrived. A failure takes precedence over a
+// delay.
+func (r *Report) match(bounces []Bounce) {
+	for _, bounce := range bounces {
+		index := -1
+		for i, record := range r.Records {
+			if record.Recipient != bounce.Recipient {
+				continue
+			}
+			if bounce.OriginalMessageID != "" && record.messageID == bounce.OriginalMessageID {
+				index = i
+				break
+			}
+			if bounce.Date.IsZero() || !record.Date.After(bounce.Date) {
+				index = i
+			}
+		}
+
+		if index < 0 {
+			r.Unmatched = append(r.Unmatched, bounce)
+			continue
+		}
+
+		record := &r.Records[index]
+		status := StatusDelayed
+		if bounce.Action != "delayed" {
+			status = StatusBounced
+		}
+		if record.Status == StatusBounced && status == StatusDelayed {
+			continue
+		}
+		record.Status = status
+		record.BounceID = bounce.ID
+		record.BouncedAt = bounce.Date
+		record.DSNStatus = bounce.DSNStatus
+		record.Diagnostic = bounce.Diagnostic
+	}
+}
+
+// fromSender reports whether the message was sent by the lower-cased sender
+func fromSender(header mail.Header, sender string) bool {
+	addresses, err := header.AddressList("From")
+	if err != nil {
+		return false
+	}
+	for _, address := range addresses {
+		if strings.ToLower(address.Address) == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// isBounce reports whether the message is a delivery status notification,
+// either as a multipart/report or from a mailer daemon naming the failed
+// recipients
+func isBounce(header mail.Header) bool {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/report" && strings.EqualFold(params["report-type"], "delivery-status") {
+		return true
+	}
+	return header.Get("X-Failed-Recipients") != ""
+}
+
+// parseBounce returns the failed and delayed deliveries reported by a
+// notification
+func parseBounce(id string, message *mail.Message) []Bounce {
+	date, _ := message.Header.Date()
+	template := Bounce{ID: id, Date: date.UTC()}
+
+	var bounces []Bounce
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/report" {
+		bounces = parseReport(template, message.Body, params["boundary"])
+	}
+
+	// Notifications without a readable report name the failed recipients
+	// in a header
+	if len(bounces) == 0 {
+		for _, recipient := range strings.Split(message.Header.Get("X-Failed-Recipients"), ",") {
+			if recipient = strings.ToLower(strings.TrimSpace(recipient)); recipient != "" {
+				bounce := template
+				bounce.Recipient = recipient
+				bounce.Action = "failed"
+				bounces = append(bounces, bounce)
+			}
+		}
+	}
+
+	return bounces
+}
+
+// parseReport reads the delivery-status part of a multipart/report and the
+// Message-ID of the returned message
+func parseReport(template Bounce, body io.Reader, boundary string) []Bounce {
+	var bounces []Bounce
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		content, err := io.ReadAll(partReader(part))
+		if err != nil {
+			break
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch mediaType {
+		case "message/delivery-status", "message/global-delivery-status":
+			bounces = append(bounces, parseDeliveryStatus(template, content)...)
+		case "text/rfc822-headers", "message/rfc822", "message/global", "message/global-headers":
+			// A header block cut short still yields the fields read so far
+			returned, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(content))).ReadMIMEHeader()
+			template.OriginalMessageID = normalizeMessageID(returned.Get("Message-Id"))
+		}
+	}
+
+	for i := range bounces {
+		bounces[i].OriginalMessageID = template.OriginalMessageID
+	}
+	return bounces
+}
+
+// partReader decodes a base64 part; quoted-printable parts are decoded by
+// the multipart reader
+func partReader(part *multipart.Part) io.Reader {
+	if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return part
+}
+
+// parseDeliveryStatus parses the per-recipient field groups of a
+// delivery-status body, returning the failed and delayed recipients
+func parseDeliveryStatus(template Bounce, content []byte) []Bounce {
+	var bounces []Bounce
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(content)))
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if recipient := dsnValue(fields.Get("Final-Recipient")); recipient != "" {
+			action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+			if action == "failed" || action == "delayed" {
+				bounce := template
+				bounce.Recipient = strings.ToLower(recipient)
+				bounce.Action = action
+				bounce.DSNStatus = strings.TrimSpace(fields.Get("Status"))
+				bounce.Diagnostic = dsnValue(fields.Get("Diagnostic-Code"))
+				bounces = append(bounces, bounce)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return bounces
+}
+
+// dsnValue strips the type prefix of a typed DSN field such as
+// "rfc822; user@example.com"
+func dsnValue(value string) string {
+	if _, rest, ok := strings.Cut(value, ";"); ok {
+		value = rest
+	}
+	return strings.TrimSpace(value)
+}
+
+// normalizeMessageID returns a Message-ID without its angle brackets
+func normalizeMessageID(value string) string {
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
+
+// csvHeader is the header row of the CSV report
+var csvHeader = []string{"id", "date", "subject", "recipient", "field", "status", "bounce_id", "bounced_at", "dsn_status", "diagnostic"}
+
+// WriteCSV writes a row per recipient of each sent message
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, record := range r.Records {
+		row := []string{
+			record.ID,
+			formatTime(record.Date),
+			record.Subject,
+			record.Recipient,
+			record.Field,
+			record.Status,
+			record.BounceID,
+			formatTime(record.BouncedAt),
+			record.DSNStatus,
+			record.Diagnostic,
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// formatTime formats t as RFC 3339, or "" for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
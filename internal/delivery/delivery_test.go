@@ -0,0 +1,187 @@
+package delivery
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sentMessage = "From: Support <support@example.com>\r\n" +
+	"To: alice@example.org, Bob <bob@example.net>\r\n" +
+	"Cc: carol@example.com\r\n" +
+	"Subject: Your ticket\r\n" +
+	"Date: Mon, 4 Mar 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <ticket-1@example.com>\r\n" +
+	"\r\n" +
+	"Hello\r\n"
+
+const dsnBounce = "From: Mail Delivery Subsystem <mailer-daemon@googlemail.com>\r\n" +
+	"To: support@example.com\r\n" +
+	"Subject: Delivery Status Notification (Failure)\r\n" +
+	"Date: Mon, 4 Mar 2024 10:01:00 +0000\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message wasn't delivered.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; googlemail.com\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; bob@example.net\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 No such user\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"Message-ID: <ticket-1@example.com>\r\n" +
+	"Subject: Your ticket\r\n" +
+	"\r\n" +
+	"--b1--\r\n"
+
+const eximBounce = "From: Mail Delivery System <Mailer-Daemon@mx.example.org>\r\n" +
+	"To: support@example.com\r\n" +
+	"Subject: Mail delivery failed\r\n" +
+	"Date: Mon, 4 Mar 2024 11:00:00 +0000\r\n" +
+	"X-Failed-Recipients: alice@example.org\r\n" +
+	"\r\n" +
+	"This message was created automatically by mail delivery software.\r\n"
+
+const inboundMessage = "From: alice@example.org\r\n" +
+	"To: support@example.com\r\n" +
+	"Subject: Re: Your ticket\r\n" +
+	"Date: Mon, 4 Mar 2024 12:00:00 +0000\r\n" +
+	"\r\n" +
+	"Thanks\r\n"
+
+func writeExport(t *testing.T, messages map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range messages {
+		if err := os.WriteFile(filepath.Join(dir, name+".eml"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBuild(t *testing.T) {
+	dir := writeExport(t, map[string]string{
+		"18c0000000000001": sentMessage,
+		"18c0000000000002": dsnBounce,
+		"18c0000000000003": eximBounce,
+		"18c0000000000004": inboundMessage,
+	})
+
+	report, err := Build(dir, "support@example.com")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if report.Messages != 1 {
+		t.Errorf("Expected 1 sent message, got %d", report.Messages)
+	}
+	if len(report.Records) != 3 {
+		t.Fatalf("Expected 3 recipient records, got %d", len(report.Records))
+	}
+	if report.Sent != 1 || report.Bounced != 2 {
+		t.Errorf("Expected 1 delivered and 2 bounced, got %d and %d", report.Sent, report.Bounced)
+	}
+
+	byRecipient := make(map[string]Record)
+	for _, record := range report.Records {
+		byRecipient[record.Recipient] = record
+	}
+
+	bob := byRecipient["bob@example.net"]
+	if bob.Status != StatusBounced || bob.DSNStatus != "5.1.1" || bob.BounceID != "18c0000000000002" {
+		t.Errorf("Unexpected record for bob: %+v", bob)
+	}
+	if !strings.Contains(bob.Diagnostic, "No such user") {
+		t.Errorf("Expected diagnostic code, got %q", bob.Diagnostic)
+	}
+	if alice := byRecipient["alice@example.org"]; alice.Status != StatusBounced || alice.BounceID != "18c0000000000003" {
+		t.Errorf("Unexpected record for alice: %+v", alice)
+	}
+	if carol := byRecipient["carol@example.com"]; carol.Status != StatusSent || carol.Field != "cc" {
+		t.Errorf("Unexpected record for carol: %+v", carol)
+	}
+}
+
+func TestBuild_WithoutSender(t *testing.T) {
+	dir := writeExport(t, map[string]string{
+		"18c0000000000001": sentMessage,
+		"18c0000000000004": inboundMessage,
+	})
+
+	report, err := Build(dir, "")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if report.Messages != 2 {
+		t.Errorf("Expected every non-bounce message to count as sent, got %d", report.Messages)
+	}
+}
+
+func TestBuild_UnmatchedBounce(t *testing.T) {
+	dir := writeExport(t, map[string]string{
+		"18c0000000000003": eximBounce,
+	})
+
+	report, err := Build(dir, "support@example.com")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0].Recipient != "alice@example.org" {
+		t.Errorf("Expected unmatched bounce for alice, got %+v", report.Unmatched)
+	}
+}
+
+func TestParseDeliveryStatus_IgnoresDelivered(t *testing.T) {
+	content := []byte("Reporting-MTA: dns; mx.example.org\r\n\r\n" +
+		"Final-Recipient: rfc822; ok@example.org\r\nAction: delivered\r\nStatus: 2.0.0\r\n\r\n" +
+		"Final-Recipient: rfc822; slow@example.org\r\nAction: delayed\r\nStatus: 4.4.7\r\n")
+
+	bounces := parseDeliveryStatus(Bounce{ID: "1"}, content)
+	if len(bounces) != 1 {
+		t.Fatalf("Expected 1 bounce, got %d", len(bounces))
+	}
+	if bounces[0].Recipient != "slow@example.org" || bounces[0].Action != "delayed" {
+		t.Errorf("Unexpected bounce: %+v", bounces[0])
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	dir := writeExport(t, map[string]string{
+		"18c0000000000001": sentMessage,
+		"18c0000000000002": dsnBounce,
+	})
+
+	report, err := Build(dir, "support@example.com")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("Expected header and 3 rows, got %d", len(rows))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("Unexpected header: %v", rows[0])
+	}
+}
@@ -0,0 +1,303 @@
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+// Entry is a message as recorded in one snapshot
+type Entry struct {
+	ID string `json:"id"`
+	// Labels holds the message label IDs when LabelsKnown is set
+	Labels      []string `json:"labels,omitempty"`
+	LabelsKnown bool     `json:"-"`
+	// File and SHA256 identify the export file, empty for a live mailbox
+	File   string `json:"file,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Snapshot is the set of messages in an export run or a live mailbox
+type Snapshot struct {
+	Source  string
+	Entries map[string]*Entry
+}
+
+// LabelChange records the labels a message gained and lost
+type LabelChange struct {
+	ID      string   `json:"id"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Delta is the machine-readable difference between two snapshots
+type Delta struct {
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Generated time.Time `json:"generated"`
+
+	Added          []string      `json:"added"`
+	Removed        []string      `json:"removed"`
+	LabelsChanged  []LabelChange `json:"labels_changed"`
+	ContentChanged []string      `json:"content_changed"`
+	Unchanged      int           `json:"unchanged"`
+}
+
+// Empty reports whether the snapshots hold the same messages, labels and
+// content
+func (d *Delta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.LabelsChanged) == 0 && len(d.ContentChanged) == 0
+}
+
+// processedEmail is the subset of a processed_emails.json entry used for
+// diffing
+type processedEmail struct {
+	ID     string   `json:"id"`
+	File   string   `json:"file,omitempty"`
+	SHA256 string   `json:"sha256,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// LoadExport reads the snapshot of an export directory from its processed
+// emails file. Labels come from the file when it records them, otherwise
+// from the metadata cache next to it.
+func LoadExport(dir string) (*Snapshot, error) {
+	var processed []processedEmail
+	err := atomicfile.ReadFile(filepath.Join(dir, exporter.ProcessedEmailsFile), func(data []byte) error {
+		processed = nil
+		return json.Unmarshal(data, &processed)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s in %s: %w", exporter.ProcessedEmailsFile, dir, err)
+	}
+
+	metadataCache, err := cache.OpenIfExists(filepath.Join(dir, cache.DefaultFileName))
+	if err != nil {
+		return nil, err
+	}
+	if metadataCache != nil {
+		defer metadataCache.Close()
+	}
+
+	snapshot := &Snapshot{Source: dir, Entries: make(map[string]*Entry, len(processed))}
+	for _, email := range processed {
+		entry := &Entry{ID: email.ID, File: email.File, SHA256: email.SHA256}
+		if email.Labels != nil {
+			entry.Labels, entry.LabelsKnown = email.Labels, true
+		} else if metadataCache != nil {
+			metadata, err := metadataCache.Get(email.ID)
+			if err != nil {
+				return nil, err
+			}
+			if metadata != nil {
+				entry.Labels, entry.LabelsKnown = metadata.Labels, true
+			}
+		}
+		snapshot.Entries[email.ID] = entry
+	}
+
+	return snapshot, nil
+}
+
+// LiveConfig represents the configuration for reading a live mailbox
+type LiveConfig struct {
+	CredentialsFile string `json:"credentials_file"`
+	TokenFile       string `json:"token_file"`
+	AuthMode        string `json:"auth_mode"`
+	Query           string `json:"query"`
+	ParallelWorkers int    `json:"parallel_workers"`
+}
+
+// LoadLive reads the snapshot of the messages in the live mailbox matching
+// config.Query
+func LoadLive(config *LiveConfig) (*Snapshot, error) {
+	if err := auth.ValidateMode(config.AuthMode); err != nil {
+		return nil, err
+	}
+
+	_, gmailService, err := auth.NewGmailService(config.AuthMode, auth.AccessReadonly, config.CredentialsFile, config.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	return loadLive(gmailService, config.Query, config.ParallelWorkers)
+}
+
+// loadLive lists the messages matching query and fetches their labels with
+// workers parallel requests
+func loadLive(gmailService *gmail.Service, query string, workers int) (*Snapshot, error) {
+	var ids []string
+	err := gmailService.Users.Messages.List("me").Q(query).Pages(nil, func(resp *gmail.ListMessagesResponse) error {
+		for _, message := range resp.Messages {
+			ids = append(ids, message.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	logrus.WithField("count", len(ids)).Info("Fetching labels of mailbox messages")
+
+	if workers <= 0 {
+		workers = 1
+	}
+
+	source := "mailbox"
+	if query != "" {
+		source = fmt.Sprintf("mailbox (%s)", query)
+	}
+	snapshot := &Snapshot{Source: source, Entries: make(map[string]*Entry, len(ids))}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				message, err := gmailService.Users.Messages.Get("me", id).Format("minimal").Do()
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to get message %s: %w", id, err)
+				} else if err == nil {
+					snapshot.Entries[id] = &Entry{ID: id, Labels: message.LabelIds, LabelsKnown: true}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return snapshot, nil
+}
+
+// Compare reports the messages added to, removed from and changed in
+// newer relative to older. Labels are compared when both snapshots know
+// them, and content when both hold a checksum of the same export format.
+func Compare(older, newer *Snapshot) *Delta {
+	delta := &Delta{
+		Old:            older.Source,
+		New:            newer.Source,
+		Generated:      time.Now().UTC(),
+		Added:          []string{},
+		Removed:        []string{},
+		LabelsChanged:  []LabelChange{},
+		ContentChanged: []string{},
+	}
+
+	for id, oldEntry := range older.Entries {
+		newEntry, ok := newer.Entries[id]
+		if !ok {
+			delta.Removed = append(delta.Removed, id)
+			continue
+		}
+
+		changed := false
+		if oldEntry.LabelsKnown && newEntry.LabelsKnown {
+			added, removed := labelDifference(oldEntry.Labels, newEntry.Labels)
+			if len(added) > 0 || len(removed) > 0 {
+				delta.LabelsChanged = append(delta.LabelsChanged, LabelChange{ID: id, Added: added, Removed: removed})
+				changed = true
+			}
+		}
+		if contentChanged(oldEntry, newEntry) {
+			delta.ContentChanged = append(delta.ContentChanged, id)
+			changed = true
+		}
+		if !changed {
+			delta.Unchanged++
+		}
+	}
+
+	for id := range newer.Entries {
+		if _, ok := older.Entries[id]; !ok {
+			delta.Added = append(delta.Added, id)
+		}
+	}
+
+	sort.Strings(delta.Added)
+	sort.Strings(delta.Removed)
+	sort.Strings(delta.ContentChanged)
+	sort.Slice(delta.LabelsChanged, func(i, j int) bool {
+		return delta.LabelsChanged[i].ID < delta.LabelsChanged[j].ID
+	})
+
+	return delta
+}
+
+// labelDifference returns the labels in newer but not older, and the other
+// way round, each sorted
+func labelDifference(older, newer []string) (added, removed []string) {
+	oldSet := make(map[string]bool, len(older))
+	for _, label := range older {
+		oldSet[label] = true
+	}
+	newSet := make(map[string]bool, len(newer))
+	for _, label := range newer {
+		newSet[label] = true
+		if !oldSet[label] {
+			added = append(added, label)
+		}
+	}
+	for _, label := range older {
+		if !newSet[label] {
+			removed = append(removed, label)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// contentChanged reports whether two export files of the same format have
+// different checksums
+func contentChanged(older, newer *Entry) bool {
+	if older.SHA256 == "" || newer.SHA256 == "" {
+		return false
+	}
+	if !strings.EqualFold(filepath.Ext(older.File), filepath.Ext(newer.File)) {
+		return false
+	}
+	return older.SHA256 != newer.SHA256
+}
+
+// Save writes the delta as indented JSON to path
+func (d *Delta) Save(path string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal delta: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write delta: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package differ
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+func TestCompare(t *testing.T) {
+	older := &Snapshot{Source: "old", Entries: map[string]*Entry{
+		"a": {ID: "a", Labels: []string{"INBOX"}, LabelsKnown: true, File: "a.eml", SHA256: "1"},
+		"b": {ID: "b", Labels: []string{"INBOX", "Label_1"}, LabelsKnown: true, File: "b.eml", SHA256: "2"},
+		"c": {ID: "c", File: "c.eml", SHA256: "3"},
+		"d": {ID: "d", File: "d.eml", SHA256: "4"},
+	}}
+	newer := &Snapshot{Source: "new", Entries: map[string]*Entry{
+		"a": {ID: "a", Labels: []string{"INBOX"}, LabelsKnown: true, File: "a.eml", SHA256: "1"},
+		"b": {ID: "b", Labels: []string{"Label_2", "INBOX"}, LabelsKnown: true, File: "b.eml", SHA256: "2"},
+		"c": {ID: "c", File: "c.eml", SHA256: "changed"},
+		"e": {ID: "e"},
+	}}
+
+	delta := Compare(older, newer)
+
+	if !reflect.DeepEqual(delta.Added, []string{"e"}) {
+		t.Errorf("Expected added [e], got %v", delta.Added)
+	}
+	if !reflect.DeepEqual(delta.Removed, []string{"d"}) {
+		t.Errorf("Expected removed [d], got %v", delta.Removed)
+	}
+	wantLabels := []LabelChange{{ID: "b", Added: []string{"Label_2"}, Removed: []string{"Label_1"}}}
+	if !reflect.DeepEqual(delta.LabelsChanged, wantLabels) {
+		t.Errorf("Expected label changes %+v, got %+v", wantLabels, delta.LabelsChanged)
+	}
+	if !reflect.DeepEqual(delta.ContentChanged, []string{"c"}) {
+		t.Errorf("Expected content changed [c], got %v", delta.ContentChanged)
+	}
+	if delta.Unchanged != 1 {
+		t.Errorf("Expected 1 unchanged, got %d", delta.Unchanged)
+	}
+	if delta.Empty() {
+		t.Error("Expected delta to be non-empty")
+	}
+
+	if !Compare(older, older).Empty() {
+		t.Error("Expected a snapshot compared with itself to be empty")
+	}
+}
+
+func TestCompare_DifferentFormats(t *testing.T) {
+	older := &Snapshot{Entries: map[string]*Entry{"a": {ID: "a", File: "a.eml", SHA256: "1"}}}
+	newer := &Snapshot{Entries: map[string]*Entry{"a": {ID: "a", File: "a.json", SHA256: "2"}}}
+
+	if delta := Compare(older, newer); len(delta.ContentChanged) != 0 {
+		t.Errorf("Expected no content changes across formats, got %v", delta.ContentChanged)
+	}
+}
+
+func TestLoadExport(t *testing.T) {
+	dir := t.TempDir()
+
+	processed := `[
+  {"id": "a", "file": "a.eml", "sha256": "1", "labels": ["INBOX"]},
+  {"id": "b", "file": "b.eml", "sha256": "2"},
+  {"id": "c", "file": "c.eml", "sha256": "3"}
+]`
+	if err := os.WriteFile(filepath.Join(dir, exporter.ProcessedEmailsFile), []byte(processed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := cache.Open(filepath.Join(dir, cache.DefaultFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(cache.Metadata{ID: "b", Labels: []string{"Label_1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := LoadExport(dir)
+	if err != nil {
+		t.Fatalf("LoadExport() error = %v", err)
+	}
+
+	if len(snapshot.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(snapshot.Entries))
+	}
+	if entry := snapshot.Entries["a"]; !entry.LabelsKnown || !reflect.DeepEqual(entry.Labels, []string{"INBOX"}) {
+		t.Errorf("Expected labels from processed emails file, got %+v", entry)
+	}
+	if entry := snapshot.Entries["b"]; !entry.LabelsKnown || !reflect.DeepEqual(entry.Labels, []string{"Label_1"}) {
+		t.Errorf("Expected labels from metadata cache, got %+v", entry)
+	}
+	if entry := snapshot.Entries["c"]; entry.LabelsKnown {
+		t.Errorf("Expected unknown labels for uncached message, got %+v", entry)
+	}
+}
+
+func TestLoadExport_Missing(t *testing.T) {
+	if _, err := LoadExport(t.TempDir()); err == nil {
+		t.Error("Expected error for export without processed emails file")
+	}
+}
+
+func TestLoadLive(t *testing.T) {
+	messages := map[string][]string{"a": {"INBOX"}, "b": {"Label_1"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/")
+		var response any
+		if path == "messages" {
+			list := &gmail.ListMessagesResponse{}
+			for id := range messages {
+				list.Messages = append(list.Messages, &gmail.Message{Id: id})
+			}
+			response = list
+		} else {
+			id := strings.TrimPrefix(path, "messages/")
+			response = &gmail.Message{Id: id, LabelIds: messages[id]}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service, err := gmail.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("failed to create Gmail service: %v", err)
+	}
+
+	snapshot, err := loadLive(service, "", 2)
+	if err != nil {
+		t.Fatalf("loadLive() error = %v", err)
+	}
+
+	if len(snapshot.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(snapshot.Entries))
+	}
+	if entry := snapshot.Entries["b"]; !entry.LabelsKnown || !reflect.DeepEqual(entry.Labels, []string{"Label_1"}) {
+		t.Errorf("Unexpected entry for b: %+v", entry)
+	}
+}
@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Adaptive concurrency settings
+const (
+	// maxAdaptiveWorkers caps how far adaptive mode ramps up
+	maxAdaptiveWorkers = 32
+	// throttleCooldown is how long after a quota error adaptive mode waits
+	// before cutting concurrency again or ramping back up
+	throttleCooldown = 5 * time.Second
+)
+
+// adaptiveGate limits how many workers export at once, ramping the limit up
+// by one after every limit successful exports and halving it when Gmail
+// reports quota errors (additive increase, multiplicative decrease)
+type adaptiveGate struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  func() time.Time
+
+	limit     int
+	max       int
+	active    int
+	successes int
+	throttled time.Time
+
+	// onChange is called with the new limit, outside the lock
+	onChange func(limit int)
+}
+
+// newAdaptiveGate creates a gate starting at initial concurrent workers and
+// never exceeding max
+func newAdaptiveGate(initial, max int, onChange func(int)) *adaptiveGate {
+	if max < 1 {
+		max = 1
+	}
+	if initial < 1 {
+		initial = 1
+	}
+	if initial > max {
+		initial = max
+	}
+
+	g := &adaptiveGate{limit: initial, max: max, now: time.Now, onChange: onChange}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire blocks until the worker may export its next message. A nil gate
+// never blocks.
+func (g *adaptiveGate) acquire() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	for g.active >= g.limit {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+// release frees the slot taken by acquire, counting a successful export
+// towards the next ramp-up
+func (g *adaptiveGate) release(success bool) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	g.active--
+	changed := false
+	if success {
+		g.successes++
+		if g.successes >= g.limit && g.limit < g.max && g.now().Sub(g.throttled) >= throttleCooldown {
+			g.limit++
+			g.successes = 0
+			changed = true
+		}
+	}
+	limit := g.limit
+	g.cond.Broadcast()
+	g.mu.Unlock()
+
+	if changed {
+		g.changed(limit, "ramp up")
+	}
+}
+
+// throttle halves the limit after a quota error. Errors within the cooldown
+// of the last cut are attributed to the same burst and ignored.
+func (g *adaptiveGate) throttle() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	now := g.now()
+	if !g.throttled.IsZero() && now.Sub(g.throttled) < throttleCooldown {
+		g.mu.Unlock()
+		return
+	}
+	g.throttled = now
+	g.successes = 0
+	changed := g.limit > 1
+	if changed {
+		g.limit /= 2
+	}
+	limit := g.limit
+	g.mu.Unlock()
+
+	if changed {
+		g.changed(limit, "back off")
+	}
+}
+
+// startAdaptiveGate creates the adaptive gate on first use and returns the
+// number of workers to start. The gate is kept across date windows so the
+// concurrency learned in one window carries over to the next.
+func (e *Exporter) startAdaptiveGate(messages int) int {
+	ceiling := maxAdaptiveWorkers
+	if e.config.ParallelWorkers > 0 {
+		ceiling = e.config.ParallelWorkers
+	}
+
+	if e.gate == nil {
+		plan := autoWorkerCount(runtime.NumCPU(), e.apiLatency.average(), e.config.MaxQPS, messages)
+		e.gate = newAdaptiveGate(plan.Workers, ceiling, e.metrics.SetConcurrency)
+		e.metrics.SetConcurrency(e.gate.current())
+
+		logrus.WithFields(logrus.Fields{
+			"workers": e.gate.current(),
+			"max":     ceiling,
+		}).Info("Adaptive worker concurrency enabled")
+	}
+
+	if messages < ceiling {
+		return messages
+	}
+	return ceiling
+}
+
+// current returns the current concurrency limit
+func (g *adaptiveGate) current() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// changed logs and publishes a new limit
+func (g *adaptiveGate) changed(limit int, reason string) {
+	logrus.WithFields(logrus.Fields{
+		"workers": limit,
+		"reason":  reason,
+	}).Debug("Adjusted adaptive worker concurrency")
+
+	if g.onChange != nil {
+		g.onChange(limit)
+	}
+}
@@ -0,0 +1,134 @@
+package exporter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveGate_RampsUp(t *testing.T) {
+	var changes []int
+	gate := newAdaptiveGate(2, 4, func(limit int) { changes = append(changes, limit) })
+
+	// Two successes at a limit of 2 ramp up to 3, then three more to 4
+	for i := 0; i < 10; i++ {
+		gate.acquire()
+		gate.release(true)
+	}
+
+	if gate.current() != 4 {
+		t.Errorf("Expected limit capped at 4, got %d", gate.current())
+	}
+	if len(changes) != 2 || changes[0] != 3 || changes[1] != 4 {
+		t.Errorf("Expected changes [3 4], got %v", changes)
+	}
+}
+
+func TestAdaptiveGate_FailuresDoNotRampUp(t *testing.T) {
+	gate := newAdaptiveGate(2, 8, nil)
+
+	for i := 0; i < 10; i++ {
+		gate.acquire()
+		gate.release(false)
+	}
+
+	if gate.current() != 2 {
+		t.Errorf("Expected limit 2, got %d", gate.current())
+	}
+}
+
+func TestAdaptiveGate_Throttle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	gate := newAdaptiveGate(8, 16, nil)
+	gate.now = func() time.Time { return now }
+
+	gate.throttle()
+	if gate.current() != 4 {
+		t.Fatalf("Expected limit halved to 4, got %d", gate.current())
+	}
+
+	// Errors from the same burst are ignored
+	gate.throttle()
+	if gate.current() != 4 {
+		t.Errorf("Expected limit 4 within cooldown, got %d", gate.current())
+	}
+
+	// No ramp-up within the cooldown
+	for i := 0; i < 10; i++ {
+		gate.acquire()
+		gate.release(true)
+	}
+	if gate.current() != 4 {
+		t.Errorf("Expected limit 4 within cooldown, got %d", gate.current())
+	}
+
+	now = now.Add(throttleCooldown)
+	gate.throttle()
+	gate.throttle()
+	if gate.current() != 2 {
+		t.Errorf("Expected limit halved to 2, got %d", gate.current())
+	}
+
+	now = now.Add(throttleCooldown)
+	for i := 0; i < 2; i++ {
+		gate.acquire()
+		gate.release(true)
+	}
+	if gate.current() != 3 {
+		t.Errorf("Expected limit 3 after the cooldown, got %d", gate.current())
+	}
+}
+
+func TestAdaptiveGate_NeverBelowOne(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	gate := newAdaptiveGate(1, 4, nil)
+	gate.now = func() time.Time { return now }
+
+	gate.throttle()
+	if gate.current() != 1 {
+		t.Errorf("Expected limit 1, got %d", gate.current())
+	}
+}
+
+func TestAdaptiveGate_LimitsConcurrency(t *testing.T) {
+	gate := newAdaptiveGate(2, 2, nil)
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gate.acquire()
+			mu.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+			gate.release(true)
+		}()
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent workers, got %d", peak)
+	}
+}
+
+func TestAdaptiveGate_Nil(t *testing.T) {
+	var gate *adaptiveGate
+
+	// A nil gate never blocks
+	gate.acquire()
+	gate.release(true)
+	gate.throttle()
+}
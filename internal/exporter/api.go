@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// callAPI runs a single Gmail API call, applying the QPS limiter, recording
+// its latency and retrying failures under the retry policy of their
+// category. With several OAuth clients, each attempt uses the next client of
+// the pool.
+func (e *Exporter) callAPI(method string, call func(service *gmail.Service) error) error {
+	return e.retry.Do(func() error {
+		e.limiter.Wait()
+		e.metrics.RecordQuota(method, e.quota.consume(method))
+
+		service, client := e.gmailService, ""
+		if e.clients != nil {
+			pooled := e.clients.Next()
+			service, client = pooled.Service, pooled.Name
+		}
+
+		start := time.Now()
+		err := call(service)
+		elapsed := time.Since(start)
+
+		e.apiLatency.observe(elapsed)
+		e.metrics.RecordAPICall(method, elapsed, err)
+
+		rateLimited := isRateLimitError(err)
+		if client != "" {
+			e.metrics.RecordClientCall(client, rateLimited, err)
+		}
+		if rateLimited {
+			e.gate.throttle()
+		}
+		return err
+	}, func(err error, attempt int, wait time.Duration) {
+		e.metrics.RecordRetry(method)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"method":  method,
+			"attempt": attempt + 1,
+			"backoff": wait,
+		}).Debug("Retrying Gmail API call")
+	})
+}
+
+// isRateLimitError reports whether a Gmail API error signals that a quota
+// was exceeded
+func isRateLimitError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.Code {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
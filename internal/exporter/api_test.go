@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsRateLimitError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "too many requests",
+			err:      fmt.Errorf("failed: %w", &googleapi.Error{Code: 429}),
+			expected: true,
+		},
+		{
+			name: "rate limit exceeded",
+			err: &googleapi.Error{
+				Code:   403,
+				Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}},
+			},
+			expected: true,
+		},
+		{
+			name:     "server error",
+			err:      &googleapi.Error{Code: 503},
+			expected: false,
+		},
+		{
+			name:     "forbidden",
+			err:      &googleapi.Error{Code: 403},
+			expected: false,
+		},
+		{
+			name:     "non API error",
+			err:      errors.New("disk full"),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isRateLimitError(tt.err); result != tt.expected {
+				t.Errorf("isRateLimitError() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Budgets that stop an export before all matching emails are exported
+const (
+	BudgetSize     = "size"
+	BudgetDuration = "duration"
+)
+
+// exportBudget stops an export cleanly once it has written MaxTotalSize
+// bytes or run for MaxDuration, for staged exports onto limited storage
+type exportBudget struct {
+	maxSize int64
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	size    int64
+	reached string
+}
+
+// startBudget starts the budget of an export, calling stop once it is
+// reached. It returns nil when the export has no budget.
+func startBudget(config *Config, stop func()) *exportBudget {
+	if config.MaxTotalSize <= 0 && config.MaxDuration <= 0 {
+		return nil
+	}
+
+	b := &exportBudget{maxSize: config.MaxTotalSize}
+	if config.MaxDuration > 0 {
+		b.timer = time.AfterFunc(config.MaxDuration, func() {
+			if b.reach(BudgetDuration) {
+				logrus.WithField("max_duration", config.MaxDuration).Info("Duration budget reached; stopping after the emails in progress")
+				stop()
+			}
+		})
+	}
+	return b
+}
+
+// add records size bytes written and reports whether that reached the size
+// budget, the first time it does
+func (b *exportBudget) add(size int64) bool {
+	if b == nil || b.maxSize <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	b.size += size
+	full := b.size >= b.maxSize
+	b.mu.Unlock()
+	return full && b.reach(BudgetSize)
+}
+
+// reach records the budget reached, reporting false when one was already
+func (b *exportBudget) reach(budget string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reached != "" {
+		return false
+	}
+	b.reached = budget
+	return true
+}
+
+// reason returns the budget that stopped the export, or "" if none did
+func (b *exportBudget) reason() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reached
+}
+
+// finish stops the duration budget's timer once the export has returned
+func (b *exportBudget) finish() {
+	if b != nil && b.timer != nil {
+		b.timer.Stop()
+	}
+}
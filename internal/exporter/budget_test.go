@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExportBudget_Size(t *testing.T) {
+	if startBudget(&Config{}, func() {}) != nil {
+		t.Fatal("Expected no budget without limits")
+	}
+
+	budget := startBudget(&Config{MaxTotalSize: 1000}, func() { t.Error("Expected no timer without a duration") })
+	defer budget.finish()
+	if budget.add(600) || budget.reason() != "" {
+		t.Fatal("Expected the budget not to be reached at 600 of 1000 bytes")
+	}
+	if !budget.add(600) || budget.reason() != BudgetSize {
+		t.Fatalf("Expected the size budget to be reached, got %q", budget.reason())
+	}
+	if budget.add(600) {
+		t.Error("Expected the budget to be reported reached only once")
+	}
+}
+
+func TestExportBudget_Duration(t *testing.T) {
+	stopped := make(chan struct{})
+	budget := startBudget(&Config{MaxDuration: 10 * time.Millisecond}, func() { close(stopped) })
+	defer budget.finish()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the duration budget to stop the export")
+	}
+	if budget.reason() != BudgetDuration {
+		t.Errorf("reason() = %q, want %q", budget.reason(), BudgetDuration)
+	}
+	if budget.add(1 << 30) {
+		t.Error("Expected no size budget once the duration budget stopped the export")
+	}
+}
@@ -0,0 +1,188 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// iso2022Escapes are the escape sequences that switch an ISO-2022-JP text to
+// a Japanese character set; unlabeled text containing one is ISO-2022-JP
+var iso2022Escapes = [][]byte{[]byte("\x1b$B"), []byte("\x1b$@"), []byte("\x1b(J")}
+
+// normalizeCharsets transcodes the headers and inline text bodies of a
+// message to UTF-8 in place, for the derived json and txt formats. Bodies
+// are decoded from the charset of their Content-Type, or a detected one when
+// the label is missing, unknown or wrong, and their Content-Type is
+// relabeled as utf-8. Attachments are left untouched.
+func (e *Exporter) normalizeCharsets(message *gmail.Message) error {
+	return e.normalizePartCharset(message.Payload)
+}
+
+// normalizePartCharset normalizes a message part and its children
+func (e *Exporter) normalizePartCharset(part *gmail.MessagePart) error {
+	if part == nil {
+		return nil
+	}
+
+	for _, header := range part.Headers {
+		header.Value = mimepart.DecodeHeader(header.Value)
+	}
+
+	if strings.HasPrefix(strings.ToLower(part.MimeType), "text/") && part.Filename == "" &&
+		part.Body != nil && part.Body.Data != "" {
+		data, err := decodeBase64URL(part.Body.Data)
+		if err != nil {
+			return err
+		}
+
+		label := partCharset(part)
+		text, name, err := toUTF8(data, label, part.MimeType, e.config.DefaultCharset)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s body: %w", name, err)
+		}
+		if name != "utf-8" {
+			part.Body.Data = base64.URLEncoding.EncodeToString(text)
+			part.Body.Size = int64(len(text))
+		}
+		if name != "utf-8" || (label != "" && !isUTF8Label(label)) {
+			relabelUTF8(part)
+		}
+	}
+
+	for _, child := range part.Parts {
+		if err := e.normalizePartCharset(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toUTF8 decodes a text body to UTF-8, returning the text and the canonical
+// name of the charset it was decoded from ("utf-8" when it is left as is).
+// The labeled charset is trusted unless it is US-ASCII and the body is not;
+// other text is detected by its ISO-2022-JP escapes, kept if valid UTF-8, or
+// else decoded by an HTML meta tag or BOM, then fallback, then windows-1252.
+func toUTF8(data []byte, label, mediaType, fallback string) ([]byte, string, error) {
+	if label != "" && !isUTF8Label(label) {
+		if encoding, name := charset.Lookup(label); encoding != nil {
+			if !isASCIILabel(label) || isASCII(data) {
+				decoded, err := encoding.NewDecoder().Bytes(data)
+				return decoded, name, err
+			}
+		}
+	}
+
+	// ISO-2022-JP is 7-bit, so it passes as UTF-8 unless its escapes are
+	// looked for first
+	if utf8.Valid(data) && !isISO2022JP(data) {
+		return data, "utf-8", nil
+	}
+
+	encoding, name := charset.Lookup(detectCharset(data, mediaType, fallback))
+	if encoding == nil {
+		encoding, name = charset.Lookup("windows-1252")
+	}
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	return decoded, name, err
+}
+
+// detectCharset guesses the charset of a text body that is not valid UTF-8
+func detectCharset(data []byte, mediaType, fallback string) string {
+	if isISO2022JP(data) {
+		return "iso-2022-jp"
+	}
+
+	if strings.EqualFold(mediaType, "text/html") {
+		// A meta tag naming windows-1252 is indistinguishable from no meta
+		// tag, which falls through to the fallback below
+		if _, name, _ := charset.DetermineEncoding(data, mediaType); name != "utf-8" && name != "windows-1252" {
+			return name
+		}
+	}
+
+	if fallback != "" {
+		return fallback
+	}
+	return "windows-1252"
+}
+
+// isISO2022JP reports whether data contains ISO-2022-JP escape sequences
+func isISO2022JP(data []byte) bool {
+	for _, escape := range iso2022Escapes {
+		if bytes.Contains(data, escape) {
+			return true
+		}
+	}
+	return false
+}
+
+// partCharset returns the charset parameter of a part's Content-Type header
+func partCharset(part *gmail.MessagePart) string {
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, "Content-Type") {
+			_, params, err := mime.ParseMediaType(header.Value)
+			if err != nil {
+				return ""
+			}
+			return strings.Trim(params["charset"], `"' `)
+		}
+	}
+	return ""
+}
+
+// relabelUTF8 sets the charset of a part's Content-Type header to utf-8
+func relabelUTF8(part *gmail.MessagePart) {
+	for _, header := range part.Headers {
+		if !strings.EqualFold(header.Name, "Content-Type") {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(header.Value)
+		if err != nil {
+			return
+		}
+		params["charset"] = "utf-8"
+		if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+			header.Value = formatted
+		}
+		return
+	}
+}
+
+// isUTF8Label reports whether a charset label names UTF-8
+func isUTF8Label(label string) bool {
+	label = strings.ToLower(label)
+	return label == "utf-8" || label == "utf8"
+}
+
+// isASCIILabel reports whether a charset label names US-ASCII, which
+// mislabeled 8-bit mail often carries
+func isASCIILabel(label string) bool {
+	label = strings.ToLower(label)
+	return label == "us-ascii" || label == "ascii"
+}
+
+// isASCII reports whether data is 7-bit
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// validCharset reports whether label names a charset that can be decoded
+func validCharset(label string) bool {
+	encoding, _ := charset.Lookup(label)
+	return encoding != nil
+}
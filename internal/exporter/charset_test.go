@@ -0,0 +1,103 @@
+package exporter
+
+import (
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		label     string
+		mediaType string
+		fallback  string
+		want      string
+		charset   string
+	}{
+		{"utf-8 unchanged", "héllo", "utf-8", "text/plain", "", "héllo", "utf-8"},
+		{"unlabeled utf-8", "héllo", "", "text/plain", "", "héllo", "utf-8"},
+		{"iso-2022-jp", "\x1b$B$3$s$K$A$O\x1b(B", "ISO-2022-JP", "text/plain", "", "こんにちは", "iso-2022-jp"},
+		{"koi8-r", "\xf0\xd2\xc9\xd7\xc5\xd4", "koi8-r", "text/plain", "", "Привет", "koi8-r"},
+		{"unlabeled iso-2022-jp", "\x1b$B$3$s$K$A$O\x1b(B", "", "text/plain", "", "こんにちは", "iso-2022-jp"},
+		{"mislabeled us-ascii", "caf\xe9", "us-ascii", "text/plain", "", "café", "windows-1252"},
+		{"wrong utf-8 label", "\xf0\xd2\xc9\xd7\xc5\xd4", "utf-8", "text/plain", "koi8-r", "Привет", "koi8-r"},
+		{"unknown label", "caf\xe9", "x-unknown", "text/plain", "", "café", "windows-1252"},
+		{"html meta", `<meta charset="windows-1251"><p>` + "\xcf\xf0\xe8\xe2\xe5\xf2", "", "text/html", "", `<meta charset="windows-1251"><p>Привет`, "windows-1251"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, name, err := toUTF8([]byte(tt.data), tt.label, tt.mediaType, tt.fallback)
+			if err != nil {
+				t.Fatalf("toUTF8 failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if name != tt.charset {
+				t.Errorf("Expected charset %s, got %s", tt.charset, name)
+			}
+		})
+	}
+}
+
+func TestNormalizeCharsets(t *testing.T) {
+	e := &Exporter{config: &Config{}}
+	message := &gmail.Message{
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/mixed",
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Subject", Value: "=?ISO-2022-JP?B?GyRCJDMkcyRLJEEkTxsoQg==?="},
+				{Name: "Content-Type", Value: `multipart/mixed; boundary="b"`},
+			},
+			Parts: []*gmail.MessagePart{
+				{
+					MimeType: "text/plain",
+					Headers:  []*gmail.MessagePartHeader{{Name: "Content-Type", Value: "text/plain; charset=koi8-r"}},
+					Body:     encodeBody("\xf0\xd2\xc9\xd7\xc5\xd4"),
+				},
+				{
+					MimeType: "text/plain",
+					Filename: "notes.txt",
+					Headers:  []*gmail.MessagePartHeader{{Name: "Content-Type", Value: "text/plain; charset=koi8-r"}},
+					Body:     encodeBody("\xf0\xd2\xc9\xd7\xc5\xd4"),
+				},
+			},
+		},
+	}
+
+	if err := e.normalizeCharsets(message); err != nil {
+		t.Fatalf("normalizeCharsets failed: %v", err)
+	}
+
+	if got := message.Payload.Headers[0].Value; got != "こんにちは" {
+		t.Errorf("Expected decoded subject, got %q", got)
+	}
+
+	body, _ := decodeBase64URL(message.Payload.Parts[0].Body.Data)
+	if string(body) != "Привет" {
+		t.Errorf("Expected transcoded body, got %q", body)
+	}
+	if got := message.Payload.Parts[0].Headers[0].Value; got != "text/plain; charset=utf-8" {
+		t.Errorf("Expected Content-Type relabeled as utf-8, got %q", got)
+	}
+
+	attachment, _ := decodeBase64URL(message.Payload.Parts[1].Body.Data)
+	if string(attachment) != "\xf0\xd2\xc9\xd7\xc5\xd4" {
+		t.Errorf("Expected attachment untouched, got %q", attachment)
+	}
+}
+
+func TestValidateConfig_DefaultCharset(t *testing.T) {
+	config := &Config{CredentialsFile: "c", TokenFile: "t", OutputDir: "o", DefaultCharset: "klingon"}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected an unknown default charset to be rejected")
+	}
+
+	config.DefaultCharset = "koi8-r"
+	if err := validateConfig(config); err != nil {
+		t.Errorf("Expected koi8-r to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// Default checkpoint thresholds used when the configuration leaves them unset
+const (
+	DefaultCheckpointEvery    = 500
+	DefaultCheckpointInterval = time.Minute
+)
+
+// checkpointer decides when partial export results should be flushed to disk
+type checkpointer struct {
+	every    int
+	interval time.Duration
+	pending  int
+	last     time.Time
+}
+
+// newCheckpointer creates a checkpointer that fires after every messages or
+// after interval has elapsed, whichever comes first. A value <= 0 disables
+// that trigger.
+func newCheckpointer(every int, interval time.Duration, now time.Time) *checkpointer {
+	return &checkpointer{
+		every:    every,
+		interval: interval,
+		last:     now,
+	}
+}
+
+// record notes one completed message and reports whether a checkpoint is due
+func (c *checkpointer) record(now time.Time) bool {
+	c.pending++
+
+	if c.every > 0 && c.pending >= c.every {
+		return true
+	}
+	if c.interval > 0 && now.Sub(c.last) >= c.interval {
+		return true
+	}
+	return false
+}
+
+// reset starts a new checkpoint period
+func (c *checkpointer) reset(now time.Time) {
+	c.pending = 0
+	c.last = now
+}
+
+// checkpoint flushes the metrics and appends the emails processed since the
+// last checkpoint to the processed emails journal, so a partial run can be
+// inspected and resumed. Its cost does not grow with the emails processed
+// before; the filter file and the custody manifest are written in full once
+// the export ends.
+func (e *Exporter) checkpoint(processedEmails []ProcessedEmail) {
+	if err := e.metrics.Save(e.metricsPath()); err != nil {
+		logrus.WithError(err).Warn("Failed to checkpoint metrics")
+	}
+
+	if len(processedEmails) > e.journaled {
+		if err := appendProcessedEmails(e.processedJournalPath(), processedEmails[e.journaled:]); err != nil {
+			logrus.WithError(err).Warn("Failed to checkpoint processed emails journal")
+		} else {
+			e.journaled = len(processedEmails)
+			e.events.OnStateSaved(StateEvent{Path: e.processedJournalPath(), Processed: len(processedEmails)})
+		}
+	}
+
+	logrus.WithField("processed", len(processedEmails)).Debug("Checkpointed export progress")
+}
+
+// metricsPath returns the path of the metrics file for this export
+func (e *Exporter) metricsPath() string {
+	return filepath.Join(e.config.OutputDir, "metrics.json")
+}
+
+// ProcessedEmailsFile is the file an export records its messages in, next
+// to them. It doubles as a filter file of the messages already exported.
+const ProcessedEmailsFile = "processed_emails.json"
+
+// processedJournalSuffix is appended to the processed emails filter file
+// to name the journal its checkpoints append to: one JSON entry per line,
+// folded into the filter file when the export ends
+const processedJournalSuffix = "l"
+
+// processedEmailsPath returns the path of the processed emails filter file
+func (e *Exporter) processedEmailsPath() string {
+	return filepath.Join(e.config.OutputDir, ProcessedEmailsFile)
+}
+
+// processedJournalPath returns the path of the processed emails journal
+func (e *Exporter) processedJournalPath() string {
+	return e.processedEmailsPath() + processedJournalSuffix
+}
+
+// loadResumeState loads the processed emails filter file and journal left
+// by a previous run, folding the journal into the filter file so this run
+// journals from a clean end. A missing file is not an error and yields no
+// entries.
+func (e *Exporter) loadResumeState() ([]ProcessedEmail, error) {
+	processedEmails, err := readProcessedEmails(e.processedEmailsPath())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(e.processedJournalPath()); err == nil {
+		if err := writeProcessedEmails(e.processedEmailsPath(), processedEmails); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(e.processedJournalPath()); err != nil {
+			return nil, fmt.Errorf("failed to remove processed emails journal: %w", err)
+		}
+	}
+	return processedEmails, nil
+}
+
+// readProcessedEmails reads the processed emails filter file at path and
+// the journal next to it, salvaging the complete entries of a truncated
+// file. An entry of the journal replaces that of the same message in the
+// file. A missing file is not an error and yields no entries.
+func readProcessedEmails(path string) ([]ProcessedEmail, error) {
+	processedEmails, err := readProcessedEmailsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	journal, err := readProcessedJournal(path + processedJournalSuffix)
+	if err != nil || len(journal) == 0 {
+		return processedEmails, err
+	}
+
+	index := make(map[string]int, len(processedEmails))
+	for i, email := range processedEmails {
+		index[email.ID] = i
+	}
+	for _, email := range journal {
+		if i, ok := index[email.ID]; ok {
+			processedEmails[i] = email
+			continue
+		}
+		index[email.ID] = len(processedEmails)
+		processedEmails = append(processedEmails, email)
+	}
+	return processedEmails, nil
+}
+
+// readProcessedJournal reads the entries of the processed emails journal at
+// path, ignoring a line torn by an interrupted write. A missing journal is
+// not an error and yields no entries.
+func readProcessedJournal(path string) ([]ProcessedEmail, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processed emails journal: %w", err)
+	}
+
+	var processedEmails []ProcessedEmail
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var email ProcessedEmail
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &email) != nil {
+			continue
+		}
+		processedEmails = append(processedEmails, email)
+	}
+	return processedEmails, nil
+}
+
+// appendProcessedEmails appends entries to the processed emails journal at
+// path, one JSON entry per line, and syncs it
+func appendProcessedEmails(path string, processedEmails []ProcessedEmail) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, email := range processedEmails {
+		if err := encoder.Encode(email); err != nil {
+			return fmt.Errorf("failed to marshal processed email: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open processed emails journal: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write processed emails journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync processed emails journal: %w", err)
+	}
+	return file.Close()
+}
+
+// readProcessedEmailsFile reads the processed emails filter file at path,
+// salvaging the complete entries of a truncated file. A missing file is not
+// an error and yields no entries.
+func readProcessedEmailsFile(path string) ([]ProcessedEmail, error) {
+	var processedEmails []ProcessedEmail
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		processedEmails = nil
+		return json.Unmarshal(data, &processedEmails)
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if _, ok := err.(*os.PathError); ok {
+		return nil, fmt.Errorf("failed to read processed emails filter file: %w", err)
+	}
+	if err != nil {
+		// Without a usable backup, keep the complete entries of a truncated file
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read processed emails filter file: %w", readErr)
+		}
+		processedEmails = salvageProcessedEmails(data)
+		if len(processedEmails) == 0 {
+			return nil, fmt.Errorf("failed to parse processed emails filter file: %w", err)
+		}
+		logrus.WithError(err).WithField("recovered", len(processedEmails)).
+			Warn("Processed emails filter file is truncated, resuming from its complete entries")
+	}
+
+	return processedEmails, nil
+}
+
+// salvageProcessedEmails decodes the entries of a JSON array up to the first
+// incomplete one
+func salvageProcessedEmails(data []byte) []ProcessedEmail {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil
+	}
+
+	var processedEmails []ProcessedEmail
+	for decoder.More() {
+		var email ProcessedEmail
+		if err := decoder.Decode(&email); err != nil {
+			break
+		}
+		processedEmails = append(processedEmails, email)
+	}
+
+	return processedEmails
+}
+
+// skipProcessed removes message IDs that were already exported by a previous run
+func skipProcessed(messageIDs []string, processedEmails []ProcessedEmail) []string {
+	if len(processedEmails) == 0 {
+		return messageIDs
+	}
+
+	done := make(map[string]bool, len(processedEmails))
+	for _, email := range processedEmails {
+		done[email.ID] = true
+	}
+
+	remaining := make([]string, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		if !done[id] {
+			remaining = append(remaining, id)
+		}
+	}
+
+	return remaining
+}
+
+// writeProcessedEmails writes the processed emails filter file
+func writeProcessedEmails(filterFile string, processedEmails []ProcessedEmail) error {
+	data, err := json.MarshalIndent(processedEmails, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal processed emails: %w", err)
+	}
+
+	if err := atomicfile.WriteFileBackup(filterFile, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write filter file: %w", err)
+	}
+
+	return nil
+}
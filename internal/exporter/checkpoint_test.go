@@ -0,0 +1,208 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+func TestCheckpointer(t *testing.T) {
+	start := time.Now()
+
+	t.Run("fires after N messages", func(t *testing.T) {
+		c := newCheckpointer(3, time.Hour, start)
+		if c.record(start) || c.record(start) {
+			t.Error("Checkpoint fired too early")
+		}
+		if !c.record(start) {
+			t.Error("Expected checkpoint after 3 messages")
+		}
+		c.reset(start)
+		if c.record(start) {
+			t.Error("Expected counter to reset")
+		}
+	})
+
+	t.Run("fires after interval", func(t *testing.T) {
+		c := newCheckpointer(1000, time.Minute, start)
+		if c.record(start.Add(30 * time.Second)) {
+			t.Error("Checkpoint fired too early")
+		}
+		if !c.record(start.Add(61 * time.Second)) {
+			t.Error("Expected checkpoint after interval elapsed")
+		}
+	})
+
+	t.Run("disabled triggers", func(t *testing.T) {
+		c := newCheckpointer(0, 0, start)
+		for i := 0; i < 100; i++ {
+			if c.record(start.Add(time.Duration(i) * time.Hour)) {
+				t.Fatal("Checkpoint fired with both triggers disabled")
+			}
+		}
+	})
+}
+
+func TestSkipProcessed(t *testing.T) {
+	ids := []string{"a", "b", "c", "d"}
+	processed := []ProcessedEmail{{ID: "b"}, {ID: "d"}, {ID: "z"}}
+
+	remaining := skipProcessed(ids, processed)
+	if len(remaining) != 2 || remaining[0] != "a" || remaining[1] != "c" {
+		t.Errorf("skipProcessed() = %v, want [a c]", remaining)
+	}
+
+	if all := skipProcessed(ids, nil); len(all) != 4 {
+		t.Errorf("Expected all IDs with no processed emails, got %v", all)
+	}
+}
+
+func TestLoadResumeState(t *testing.T) {
+	tempDir := t.TempDir()
+	e := &Exporter{config: &Config{OutputDir: tempDir}}
+
+	// Missing file yields no state
+	state, err := e.loadResumeState()
+	if err != nil {
+		t.Fatalf("Expected no error for missing state, got %v", err)
+	}
+	if len(state) != 0 {
+		t.Errorf("Expected empty state, got %d entries", len(state))
+	}
+
+	processed := []ProcessedEmail{
+		{ID: "18c2e1c8a9b4f5d6", Size: 1024, Processed: time.Now()},
+		{ID: "18c2e1c8a9b4f5d7", Size: 2048, Processed: time.Now()},
+	}
+	if err := writeProcessedEmails(e.processedEmailsPath(), processed); err != nil {
+		t.Fatalf("Failed to write processed emails: %v", err)
+	}
+
+	state, err = e.loadResumeState()
+	if err != nil {
+		t.Fatalf("Failed to load resume state: %v", err)
+	}
+	if len(state) != 2 || state[1].ID != "18c2e1c8a9b4f5d7" {
+		t.Errorf("Unexpected resume state: %+v", state)
+	}
+
+	// A corrupt file is reported
+	if err := os.WriteFile(filepath.Join(tempDir, "processed_emails.json"), []byte("{"), 0o600); err != nil {
+		t.Fatalf("Failed to write corrupt file: %v", err)
+	}
+	if _, err := e.loadResumeState(); err == nil {
+		t.Error("Expected error for corrupt state file")
+	}
+}
+
+func TestLoadResumeState_Truncated(t *testing.T) {
+	tempDir := t.TempDir()
+	e := &Exporter{config: &Config{OutputDir: tempDir}}
+
+	processed := []ProcessedEmail{
+		{ID: "a", Size: 1, Processed: time.Now()},
+		{ID: "b", Size: 2, Processed: time.Now()},
+	}
+	if err := writeProcessedEmails(e.processedEmailsPath(), processed); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file cut off mid-entry keeps the entries before the cut
+	data, err := os.ReadFile(e.processedEmailsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := bytes.Index(data, []byte(`"b"`))
+	if err := os.WriteFile(e.processedEmailsPath(), data[:cut], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := e.loadResumeState()
+	if err != nil {
+		t.Fatalf("loadResumeState() error = %v", err)
+	}
+	if len(state) != 1 || state[0].ID != "a" {
+		t.Errorf("salvaged state = %+v", state)
+	}
+
+	// Once a previous version exists it is preferred over salvaging
+	if err := writeProcessedEmails(e.processedEmailsPath(), processed); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeProcessedEmails(e.processedEmailsPath(), processed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(e.processedEmailsPath(), data[:cut], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = e.loadResumeState()
+	if err != nil {
+		t.Fatalf("loadResumeState() error = %v", err)
+	}
+	if len(state) != 2 {
+		t.Errorf("state from backup = %+v", state)
+	}
+}
+
+func TestCheckpoint_Journal(t *testing.T) {
+	e := &Exporter{
+		config:  &Config{OutputDir: t.TempDir()},
+		metrics: metrics.NewCollector("test"),
+		events:  EventFuncs{},
+	}
+
+	processed := []ProcessedEmail{{ID: "a", Size: 1}, {ID: "b", Size: 2}}
+	e.checkpoint(processed)
+	processed = append(processed, ProcessedEmail{ID: "c", Size: 3})
+	e.checkpoint(processed)
+
+	// Each checkpoint appends only the entries since the last one
+	data, err := os.ReadFile(e.processedJournalPath())
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Errorf("Expected 3 journal lines, got %d", lines)
+	}
+	if _, err := os.Stat(e.processedEmailsPath()); !os.IsNotExist(err) {
+		t.Errorf("Expected no filter file before the export ends, got %v", err)
+	}
+
+	// A torn last line is ignored and the journal entry of a message
+	// replaces that of the filter file
+	if err := writeProcessedEmails(e.processedEmailsPath(), []ProcessedEmail{{ID: "a", Size: 9}, {ID: "z", Size: 26}}); err != nil {
+		t.Fatal(err)
+	}
+	torn := append(data, []byte(`{"id":"d","si`)...)
+	if err := os.WriteFile(e.processedJournalPath(), torn, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := e.loadResumeState()
+	if err != nil {
+		t.Fatalf("loadResumeState() error = %v", err)
+	}
+	var ids []string
+	for _, email := range state {
+		ids = append(ids, fmt.Sprintf("%s:%d", email.ID, email.Size))
+	}
+	if got := strings.Join(ids, " "); got != "a:1 z:26 b:2 c:3" {
+		t.Errorf("loadResumeState() = %s, want the filter file updated by the journal", got)
+	}
+
+	// Resuming folds the journal into the filter file
+	if _, err := os.Stat(e.processedJournalPath()); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be folded into the filter file, got %v", err)
+	}
+	folded, err := readProcessedEmailsFile(e.processedEmailsPath())
+	if err != nil || len(folded) != 4 {
+		t.Errorf("Expected the 4 entries in the filter file, got %d, %v", len(folded), err)
+	}
+}
@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/signing"
+)
+
+// custodyState is the chain-of-custody context of a legal hold export
+type custodyState struct {
+	key        []byte
+	signingKey ed25519.PrivateKey // optional, also signs the manifest
+	operator   string
+	account    string
+	query      string
+	startedAt  time.Time
+}
+
+// newCustodyState loads the signing keys and resolves the operator of a
+// legal hold export. The operator defaults to the local user name.
+func newCustodyState(config *Config) (*custodyState, error) {
+	key, err := custody.LoadKey(config.CustodyKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var signingKey ed25519.PrivateKey
+	if config.CustodySigningKey != "" {
+		if signingKey, err = signing.LoadPrivateKey(config.CustodySigningKey); err != nil {
+			return nil, err
+		}
+	}
+
+	operator := config.Operator
+	if operator == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine operator, set it explicitly: %w", err)
+		}
+		operator = current.Username
+	}
+
+	return &custodyState{key: key, signingKey: signingKey, operator: operator}, nil
+}
+
+// startCustody records the query, start time and exported account of a
+// legal hold export
+func (e *Exporter) startCustody(filterConfig *filters.Config) error {
+	var profile *gmail.Profile
+	err := e.callAPI("users.getProfile", func(service *gmail.Service) error {
+		var callErr error
+		profile, callErr = service.Users.GetProfile("me").Do()
+		return callErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get account profile: %w", err)
+	}
+
+	e.custody.account = profile.EmailAddress
+	e.custody.query = filterConfig.BuildGmailQuery()
+	e.custody.startedAt = time.Now().UTC()
+
+	logrus.WithFields(logrus.Fields{
+		"operator": e.custody.operator,
+		"account":  e.custody.account,
+	}).Info("Legal hold export: recording chain of custody")
+
+	return nil
+}
+
+// rawSHA256 returns the checksum of the raw message as held by Gmail
+func (e *Exporter) rawSHA256(messageID string) (string, error) {
+	rawData, err := e.getRawMessage(messageID)
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex(rawData), nil
+}
+
+// sha256Hex returns the hex SHA-256 checksum of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// custodyManifest builds the custody manifest of the processed emails. A
+// zero completedAt marks a checkpoint of a run still in progress.
+func (e *Exporter) custodyManifest(processedEmails []ProcessedEmail, completedAt time.Time) *custody.Manifest {
+	manifest := &custody.Manifest{
+		Version:         custody.ManifestVersion,
+		ExporterVersion: e.config.Version,
+		Operator:        e.custody.operator,
+		Account:         e.custody.account,
+		Query:           e.custody.query,
+		StartedAt:       e.custody.startedAt,
+		CompletedAt:     completedAt,
+		Messages:        make([]custody.Record, 0, len(processedEmails)),
+	}
+
+	for _, email := range processedEmails {
+		if email.RawSHA256 == "" {
+			continue
+		}
+		manifest.Messages = append(manifest.Messages, custody.Record{
+			ID:         email.ID,
+			RawSHA256:  email.RawSHA256,
+			File:       email.File,
+			FileSHA256: email.SHA256,
+			ExportedAt: email.Processed.UTC(),
+		})
+	}
+
+	return manifest
+}
+
+// saveCustody chains, signs and writes the custody manifest, and places the
+// legal hold on the output directory once the export is complete
+func (e *Exporter) saveCustody(processedEmails []ProcessedEmail, completedAt time.Time) error {
+	manifest := e.custodyManifest(processedEmails, completedAt)
+	if err := manifest.Chain(); err != nil {
+		return err
+	}
+	if err := custody.Sign(manifest, e.custody.key); err != nil {
+		return err
+	}
+	if e.custody.signingKey != nil {
+		if err := custody.SignEd25519(manifest, e.custody.signingKey); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(e.config.OutputDir, custody.ManifestFileName)
+	if err := manifest.Save(path); err != nil {
+		return err
+	}
+
+	if completedAt.IsZero() {
+		return nil
+	}
+
+	err := custody.PlaceHold(e.config.OutputDir, custody.Hold{
+		PlacedAt: completedAt,
+		Operator: e.custody.operator,
+		Account:  e.custody.account,
+		Query:    e.custody.query,
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"manifest": path,
+		"messages": len(manifest.Messages),
+	}).Info("Saved signed custody manifest and placed legal hold")
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+)
+
+func TestCustodyManifest(t *testing.T) {
+	e := &Exporter{
+		config:  &Config{Version: "1.2.3"},
+		custody: &custodyState{operator: "counsel", account: "user@example.com", query: "in:inbox"},
+	}
+
+	processed := []ProcessedEmail{
+		{ID: "a", File: "a.eml", SHA256: "file-a", RawSHA256: "raw-a", Processed: time.Now()},
+		// Exported before legal hold mode was enabled
+		{ID: "b", File: "b.eml", SHA256: "file-b", Processed: time.Now()},
+	}
+
+	manifest := e.custodyManifest(processed, time.Time{})
+	if manifest.ExporterVersion != "1.2.3" || manifest.Operator != "counsel" || manifest.Query != "in:inbox" {
+		t.Errorf("Unexpected manifest header: %+v", manifest)
+	}
+	if len(manifest.Messages) != 1 {
+		t.Fatalf("Expected 1 custody record, got %d", len(manifest.Messages))
+	}
+	if record := manifest.Messages[0]; record.ID != "a" || record.RawSHA256 != "raw-a" || record.FileSHA256 != "file-a" {
+		t.Errorf("Unexpected custody record: %+v", record)
+	}
+}
+
+func TestSaveCustody_ChainedAndSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	e := &Exporter{
+		config:  &Config{OutputDir: dir},
+		custody: &custodyState{key: []byte("secret"), signingKey: private, operator: "counsel"},
+	}
+
+	processed := []ProcessedEmail{
+		{ID: "a", File: "a.eml", SHA256: "file-a", RawSHA256: "raw-a", Processed: time.Now()},
+		{ID: "b", File: "b.eml", SHA256: "file-b", RawSHA256: "raw-b", Processed: time.Now()},
+	}
+	if err := e.saveCustody(processed, time.Time{}); err != nil {
+		t.Fatalf("saveCustody() error = %v", err)
+	}
+
+	manifest, err := custody.LoadManifest(filepath.Join(dir, custody.ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := custody.Verify(manifest, []byte("secret")); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := custody.VerifyEd25519(manifest, public); err != nil {
+		t.Errorf("VerifyEd25519() error = %v", err)
+	}
+	if !manifest.Chained() {
+		t.Error("Expected the custody records to be chained")
+	}
+	if err := manifest.VerifyChain(); err != nil {
+		t.Errorf("VerifyChain() error = %v", err)
+	}
+}
+
+func TestValidateConfig_LegalHoldRedaction(t *testing.T) {
+	config := &Config{
+		CredentialsFile: "credentials.json",
+		TokenFile:       "token.json",
+		OutputDir:       "out",
+		LegalHold:       true,
+		Redact:          []string{"emails"},
+	}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected legal hold with redaction to be rejected")
+	}
+}
@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/console"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+// Events receives progress from an export so that embedding programs can
+// render their own progress. Methods are called from a single goroutine and
+// should return quickly, as the export waits for them.
+type Events interface {
+	// OnMessageExported is called after each message is written
+	OnMessageExported(MessageEvent)
+	// OnError is called for each message that failed to export
+	OnError(ErrorEvent)
+	// OnStateSaved is called whenever the processed emails file or its
+	// journal is written
+	OnStateSaved(StateEvent)
+}
+
+// MessageEvent describes an exported message
+type MessageEvent struct {
+	MessageID string
+	// File is the export file relative to the output directory
+	File     string
+	Size     int64
+	Progress metrics.Progress
+}
+
+// ErrorEvent describes a message that failed to export
+type ErrorEvent struct {
+	MessageID string
+	Category  string
+	Err       error
+	Progress  metrics.Progress
+}
+
+// StateEvent describes a write of the processed emails file or, at a
+// checkpoint, of its journal
+type StateEvent struct {
+	Path      string
+	Processed int
+	// Final is set for the write of the file at the end of a run, and unset
+	// for checkpoints
+	Final bool
+}
+
+// EventFuncs adapts callbacks to the Events interface. Nil callbacks are
+// skipped.
+type EventFuncs struct {
+	MessageExported func(MessageEvent)
+	Error           func(ErrorEvent)
+	StateSaved      func(StateEvent)
+}
+
+// OnMessageExported implements Events
+func (f EventFuncs) OnMessageExported(event MessageEvent) {
+	if f.MessageExported != nil {
+		f.MessageExported(event)
+	}
+}
+
+// OnError implements Events
+func (f EventFuncs) OnError(event ErrorEvent) {
+	if f.Error != nil {
+		f.Error(event)
+	}
+}
+
+// OnStateSaved implements Events
+func (f EventFuncs) OnStateSaved(event StateEvent) {
+	if f.StateSaved != nil {
+		f.StateSaved(event)
+	}
+}
+
+// progressPrinter is the default Events implementation, printing a progress
+// line, and on a terminal a line per busy worker, to the console
+type progressPrinter struct {
+	console *console.Console
+}
+
+// newProgressPrinter creates a progress printer on the default console
+func newProgressPrinter() *progressPrinter {
+	return &progressPrinter{console: console.Default()}
+}
+
+// OnMessageExported implements Events
+func (p *progressPrinter) OnMessageExported(event MessageEvent) {
+	p.print(event.Progress)
+}
+
+// OnError implements Events
+func (p *progressPrinter) OnError(event ErrorEvent) {
+	p.print(event.Progress)
+}
+
+// OnStateSaved implements Events
+func (p *progressPrinter) OnStateSaved(StateEvent) {}
+
+// print rewrites the progress line
+func (p *progressPrinter) print(progress metrics.Progress) {
+	p.console.SetStatus(0, fmt.Sprintf("Progress: %d of %d messages exported (%.1f%%)",
+		progress.Exported, progress.Matched, progress.Percent()))
+}
+
+// worker shows the message a worker is exporting, or clears its line when
+// messageID is empty
+func (p *progressPrinter) worker(workerID int, messageID string) {
+	line := ""
+	if messageID != "" {
+		line = fmt.Sprintf("  worker %d: %s", workerID+1, messageID)
+	}
+	p.console.SetStatus(workerID+1, line)
+}
+
+// finish ends the progress line
+func (p *progressPrinter) finish() {
+	p.console.Finish()
+}
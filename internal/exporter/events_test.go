@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/console"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+func TestEventFuncs(t *testing.T) {
+	var exported, failed []string
+	events := EventFuncs{
+		MessageExported: func(event MessageEvent) { exported = append(exported, event.MessageID) },
+		Error:           func(event ErrorEvent) { failed = append(failed, event.MessageID) },
+	}
+
+	events.OnMessageExported(MessageEvent{MessageID: "a"})
+	events.OnError(ErrorEvent{MessageID: "b", Err: errors.New("boom")})
+	// A nil callback is skipped
+	events.OnStateSaved(StateEvent{Processed: 1})
+
+	if len(exported) != 1 || exported[0] != "a" {
+		t.Errorf("Expected exported [a], got %v", exported)
+	}
+	if len(failed) != 1 || failed[0] != "b" {
+		t.Errorf("Expected failed [b], got %v", failed)
+	}
+}
+
+func TestProgressPrinter(t *testing.T) {
+	var out bytes.Buffer
+	printer := &progressPrinter{console: console.New(&out, &out, false)}
+
+	// Nothing printed yet, so no newline either
+	printer.finish()
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got %q", out.String())
+	}
+
+	printer.OnMessageExported(MessageEvent{Progress: metrics.Progress{Matched: 4, Exported: 1, Processed: 1}})
+	// Worker lines are only shown on a terminal
+	printer.worker(0, "m2")
+	printer.OnError(ErrorEvent{Progress: metrics.Progress{Matched: 4, Exported: 1, Failed: 1, Processed: 2}})
+	printer.finish()
+
+	expected := "\rProgress: 1 of 4 messages exported (25.0%)" +
+		"\rProgress: 1 of 4 messages exported (50.0%)\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestCheckpoint_StateSaved(t *testing.T) {
+	var states []StateEvent
+	e := &Exporter{
+		config:  &Config{OutputDir: t.TempDir()},
+		metrics: metrics.NewCollector("test"),
+		events:  EventFuncs{StateSaved: func(event StateEvent) { states = append(states, event) }},
+	}
+
+	processed := []ProcessedEmail{{ID: "a", Processed: time.Now()}}
+	e.checkpoint(processed)
+	if err := e.saveProcessedEmailsFilter(processed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 state events, got %d", len(states))
+	}
+	if states[0].Final || !states[1].Final {
+		t.Errorf("Expected a checkpoint then a final save, got %+v", states)
+	}
+	if states[1].Path != e.processedEmailsPath() || states[1].Processed != 1 {
+		t.Errorf("Unexpected final state event: %+v", states[1])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,23 +15,215 @@ import (
 	"google.golang.org/api/gmail/v1"
 
 	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
 	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/hooks"
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
 	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+	"github.com/octasoft-ltd/gmail-exporter/internal/redact"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
+	"github.com/octasoft-ltd/gmail-exporter/internal/simulate"
 )
 
 // Config represents the exporter configuration
 type Config struct {
-	CredentialsFile    string `json:"credentials_file"`
-	TokenFile          string `json:"token_file"`
-	OutputDir          string `json:"output_dir"`
-	OrganizeByLabels   bool   `json:"organize_by_labels"`
-	ParallelWorkers    int    `json:"parallel_workers"`
-	IncludeAttachments bool   `json:"include_attachments"`
-	CompressExports    bool   `json:"compress_exports"`
-	Format             string `json:"format"`
-	Resume             bool   `json:"resume"`
-	StateFile          string `json:"state_file"`
-	Limit              int    `json:"limit"`
+	CredentialsFile    string  `json:"credentials_file"`
+	TokenFile          string  `json:"token_file"`
+	AuthMode           string  `json:"auth_mode"`
+	OutputDir          string  `json:"output_dir"`
+	OrganizeByLabels   bool    `json:"organize_by_labels"`
+	ParallelWorkers    int     `json:"parallel_workers"`
+	IncludeAttachments bool    `json:"include_attachments"`
+	CompressExports    bool    `json:"compress_exports"`
+	Format             string  `json:"format"`
+	Resume             bool    `json:"resume"`
+	StateFile          string  `json:"state_file"`
+	Limit              int     `json:"limit"`
+	MaxQPS             float64 `json:"max_qps"`
+
+	// Sample exports this many matching emails picked at random, rather
+	// than the newest as Limit does, so test runs represent the mailbox
+	Sample int `json:"sample,omitempty"`
+
+	// Order is the order the matching emails are exported in, so an
+	// interrupted run has done the ones that matter most: newest-first (the
+	// default, but date windows run oldest first), oldest-first or
+	// largest-first
+	Order string `json:"order,omitempty"`
+
+	// MaxTotalSize and MaxDuration stop the export cleanly, saving its
+	// progress for a resumed run, once it has written this many bytes or
+	// run this long (0 = no budget)
+	MaxTotalSize int64         `json:"max_total_size,omitempty"`
+	MaxDuration  time.Duration `json:"max_duration,omitempty"`
+
+	// AdaptiveWorkers ramps the number of concurrent workers up while Gmail
+	// accepts the load and halves it on quota errors. ParallelWorkers, when
+	// set, caps the concurrency.
+	AdaptiveWorkers bool `json:"adaptive_workers,omitempty"`
+
+	// PauseFile pauses the export while it exists; workers finish their
+	// current message and wait for it to be removed
+	// (default: output-dir/.pause)
+	PauseFile string `json:"pause_file,omitempty"`
+
+	// RunWindow restricts exporting to a daily local time range such as
+	// "22:00-06:00"; outside it the workers sleep with the state saved
+	RunWindow string `json:"run_window,omitempty"`
+
+	// QuotaBudget is the number of Gmail API quota units the export may use
+	// per day; API calls pause once it is used up (0 = no budget)
+	QuotaBudget int `json:"quota_budget,omitempty"`
+
+	// MaxInMemorySize is the largest message, in bytes, downloaded and
+	// decoded in memory by exportAsEML, which mbox exports go through too;
+	// larger messages are decoded from the API response into their file as
+	// it is downloaded (0 = DefaultMaxInMemorySize)
+	MaxInMemorySize int64 `json:"max_in_memory_size,omitempty"`
+
+	// SkipLargerThan skips messages larger than this many bytes, recording
+	// them in skipped.json (0 = no cap)
+	SkipLargerThan int64 `json:"skip_larger_than,omitempty"`
+
+	// CheckpointEvery and CheckpointInterval control how often metrics and the
+	// processed emails filter file are flushed during a run (0 = use defaults)
+	CheckpointEvery    int           `json:"checkpoint_every"`
+	CheckpointInterval time.Duration `json:"checkpoint_interval"`
+
+	// SplitBy partitions the export into date windows (day, week, month, year)
+	SplitBy string `json:"split_by"`
+
+	// ListShards lists the matching message IDs as date shards of this size
+	// (day, week, month, year), ListConcurrency of them at a time (default 4)
+	ListShards      string `json:"list_shards,omitempty"`
+	ListConcurrency int    `json:"list_concurrency,omitempty"`
+
+	// RefreshSearchAt searches again, for emails that arrived or moved in
+	// since the listing, when more than this fraction (0-1) of the listed
+	// emails were deleted before they could be downloaded (0 = never)
+	RefreshSearchAt float64 `json:"refresh_search_at,omitempty"`
+
+	// MetadataCache is the path of the message metadata cache
+	// (default: output-dir/metadata.db)
+	MetadataCache string `json:"metadata_cache"`
+
+	// Redact lists built-in redaction rules (emails, phones, credit-cards) and
+	// RedactPatterns custom regular expressions applied to exported content
+	Redact         []string `json:"redact"`
+	RedactPatterns []string `json:"redact_patterns"`
+
+	// ServiceAccountKey and ImpersonateUser export a Workspace user's mailbox
+	// through domain-wide delegation instead of an OAuth token. The key may
+	// instead come from the environment (see auth.ServiceAccountKeyB64EnvVar).
+	ServiceAccountKey string `json:"service_account_key,omitempty"`
+	ImpersonateUser   string `json:"impersonate_user,omitempty"`
+
+	// OnlyLabels and SkipLabels restrict organize-by-labels exports to, or
+	// exclude, labels given by name or ID, and MaxPerLabel caps the messages
+	// exported per label directory (0 = no cap)
+	OnlyLabels  []string `json:"only_labels,omitempty"`
+	SkipLabels  []string `json:"skip_labels,omitempty"`
+	MaxPerLabel int      `json:"max_per_label,omitempty"`
+
+	// LabelDirNames names organize-by-labels directories after label names
+	// instead of label IDs, nested labels becoming nested directories.
+	// Transliterate (none, ascii; default: none) controls how names outside
+	// ASCII are written.
+	LabelDirNames bool   `json:"label_dir_names,omitempty"`
+	Transliterate string `json:"transliterate,omitempty"`
+
+	// MaxPathLength is the longest output path, in characters; label
+	// directory names are shortened to fit (0 = 259 on Windows, otherwise
+	// no limit)
+	MaxPathLength int `json:"max_path_length,omitempty"`
+
+	// LabelStrategy places messages with several labels in organize-by-labels
+	// mode (first, copy, hardlink, index; default: first)
+	LabelStrategy string `json:"label_strategy,omitempty"`
+
+	// LegalHold records the raw checksum of every message in a signed custody
+	// manifest and places a legal hold on the output directory, which cleanup
+	// refuses to act on. Operator identifies who ran the export (default: the
+	// local user), CustodyKeyFile holds the manifest HMAC key,
+	// CustodySigningKey an optional Ed25519 key also signing the manifest and
+	// Version is the exporter version recorded in the manifest.
+	LegalHold         bool   `json:"legal_hold,omitempty"`
+	Operator          string `json:"operator,omitempty"`
+	CustodyKeyFile    string `json:"custody_key_file,omitempty"`
+	CustodySigningKey string `json:"custody_signing_key,omitempty"`
+	Version           string `json:"version,omitempty"`
+
+	// Triage writes triage_report.json with the SPF, DKIM and DMARC results,
+	// sender IP and body URLs of each exported message for security review
+	// of spam and phishing. It requires the eml format.
+	Triage bool `json:"triage,omitempty"`
+
+	// DefaultCharset is the charset assumed for unlabeled text bodies that
+	// are not valid UTF-8 when transcoding json and txt exports, such as
+	// koi8-r for an old Russian mailbox (default: windows-1252)
+	DefaultCharset string `json:"default_charset,omitempty"`
+
+	// Events receives export progress when the exporter is embedded in
+	// another program (default: a progress line printed to stdout)
+	Events Events `json:"-"`
+
+	// OAuthClients are additional OAuth clients, each with its own token for
+	// the same mailbox, that API calls are rotated across to spread the load
+	// over several per-project quotas
+	OAuthClients []auth.OAuthClient `json:"oauth_clients,omitempty"`
+
+	// Simulate exports a fabricated mailbox with injected API failures
+	// instead of calling the Gmail API, for testing the automation around
+	// exports without a Gmail account
+	Simulate *simulate.Config `json:"simulate,omitempty"`
+
+	// Retry overrides the retry policies of failure categories (rate_limit,
+	// server, network, ...); failures are only recorded once the policy of
+	// their category is exhausted
+	Retry retry.Policies `json:"retry,omitempty"`
+
+	// RunHistory appends a summary of the run to runs.jsonl in the output
+	// directory, for comparing throughput and failure rates across runs
+	RunHistory bool `json:"run_history,omitempty"`
+
+	// Fsync syncs each export file and its directory to disk before the file
+	// is recorded as exported
+	Fsync bool `json:"fsync"`
+
+	// GmailLabelsHeader prepends an X-Gmail-Labels header with the message's
+	// labels, including its read, starred and importance state, to eml and
+	// mbox exports
+	GmailLabelsHeader bool `json:"gmail_labels_header,omitempty"`
+
+	// ThunderbirdDir receives the export as a Thunderbird Local Folders mbox
+	// hierarchy, one folder per label, that can be copied into a Thunderbird
+	// profile. It requires the eml or mbox format.
+	ThunderbirdDir string `json:"thunderbird_dir,omitempty"`
+
+	// NotmuchTags writes notmuch_tags.txt, a notmuch tag batch file giving
+	// each exported message the tags of its Gmail labels. It requires the
+	// eml or mbox format.
+	NotmuchTags bool `json:"notmuch_tags,omitempty"`
+
+	// Hooks run a command or HTTP call for each exported message and once
+	// the export has finished
+	Hooks []hooks.Hook `json:"hooks,omitempty"`
+
+	// MetadataFormat is the file format of the metadata format's single
+	// output file (json, csv; default: json)
+	MetadataFormat string `json:"metadata_format,omitempty"`
+
+	// Headers are the only headers the metadata format fetches (default:
+	// Date, From, To, Cc, Subject and Message-ID); headers beyond those are
+	// written as additional fields
+	Headers []string `json:"headers,omitempty"`
+
+	// LinkDest is a previous export, such as the last snapshot of a backup,
+	// whose files of the emails still matching are hardlinked into the output
+	// directory instead of downloaded again
+	LinkDest string `json:"link_dest,omitempty"`
 }
 
 // Result represents the export operation result
@@ -38,14 +231,52 @@ type Result struct {
 	TotalMatched  int           `json:"total_matched"`
 	TotalExported int           `json:"total_exported"`
 	TotalFailed   int           `json:"total_failed"`
+	TotalSkipped  int           `json:"total_skipped"`
 	TotalSize     int64         `json:"total_size"`
 	Duration      time.Duration `json:"duration"`
 	Failures      []Failure     `json:"failures,omitempty"`
+
+	// SkippedByReason counts deliberately skipped messages by skip reason
+	SkippedByReason map[string]int `json:"skipped_by_reason,omitempty"`
+
+	// FailedByCategory counts failures by error category (auth, rate_limit, ...)
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+
+	// QuotaUnits is the number of Gmail API quota units the export used
+	QuotaUnits int `json:"quota_units"`
+
+	// Cancelled is set when the export was stopped by Cancel before all
+	// matching messages were exported
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// HookFailures is the number of message and run hook calls that failed
+	HookFailures int `json:"hook_failures,omitempty"`
+
+	// TotalLinked is the number of emails hardlinked from LinkDest instead of
+	// downloaded again
+	TotalLinked int `json:"total_linked,omitempty"`
+
+	// TotalDeleted is the number of matching emails deleted before they could
+	// be downloaded; they are also counted as skipped
+	TotalDeleted int `json:"total_deleted,omitempty"`
+
+	// SearchRefreshes is the number of times the search was run again because
+	// of deleted emails
+	SearchRefreshes int `json:"search_refreshes,omitempty"`
+
+	// BudgetReached is the budget, size or duration, that stopped the export,
+	// and TotalRemaining the number of listed emails it left for a resumed run
+	BudgetReached  string `json:"budget_reached,omitempty"`
+	TotalRemaining int    `json:"total_remaining,omitempty"`
+
+	// truncated is set when the limit left matching emails unexported
+	truncated bool
 }
 
 // Failure represents a failed export operation
 type Failure struct {
 	EmailID   string    `json:"email_id"`
+	Category  string    `json:"category"`
 	Error     string    `json:"error"`
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -58,6 +289,23 @@ type ProcessedEmail struct {
 	Date      time.Time `json:"date,omitempty"`
 	Size      int64     `json:"size,omitempty"`
 	Processed time.Time `json:"processed"`
+
+	// File is the export file relative to the output directory and SHA256
+	// its checksum, recorded once the file has been completely written
+	File   string `json:"file,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Copies are the copies or hardlinks of File in other label directories
+	// and Labels the message labels, both recorded in organize-by-labels mode
+	Copies []string `json:"copies,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+
+	// RawSHA256 is the checksum of the raw message, recorded in legal hold mode
+	RawSHA256 string `json:"raw_sha256,omitempty"`
+
+	// State lists the message's UNREAD, STARRED and IMPORTANT labels, which
+	// the importer re-applies
+	State []string `json:"state,omitempty"`
 }
 
 // Exporter handles email export operations
@@ -65,7 +313,28 @@ type Exporter struct {
 	config        *Config
 	authenticator *auth.Authenticator
 	gmailService  *gmail.Service
+	clients       *auth.ClientPool
 	metrics       *metrics.Collector
+	limiter       *rateLimiter
+	quota         *quotaTracker
+	apiLatency    latencyTracker
+	processed     []ProcessedEmail
+	journaled     int
+	linkable      map[string]ProcessedEmail
+	skipped       []SkippedEmail
+	filter        *filters.Config
+	cache         *cache.Store
+	redactor      *redact.Redactor
+	labels        *labelSelector
+	custody       *custodyState
+	gate          *adaptiveGate
+	pause         *pauseControl
+	paths         pathNaming
+	retry         *retry.Engine
+	events        Events
+	hooks         *hooks.Runner
+	labelCache    *labels.Cache
+	budget        *exportBudget
 }
 
 // New creates a new exporter instance
@@ -75,32 +344,121 @@ func New(config *Config) (*Exporter, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	// Create authenticator
-	authenticator, err := auth.NewAuthenticator(config.CredentialsFile, config.TokenFile)
+	// Build the redaction pipeline
+	redactor, err := redact.New(config.Redact, config.RedactPatterns)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create authenticator: %w", err)
+		return nil, fmt.Errorf("invalid redaction configuration: %w", err)
+	}
+
+	// Load the custody signing key before any work is done
+	var legalHold *custodyState
+	if config.LegalHold {
+		legalHold, err = newCustodyState(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid legal hold configuration: %w", err)
+		}
+	}
+
+	window, err := parseRunWindow(config.RunWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	// Get Gmail service
-	gmailService, err := authenticator.GetGmailService()
+	authenticator, gmailService, err := newGmailService(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Gmail service: %w", err)
+		return nil, err
+	}
+
+	// Rotate API calls across the additional OAuth clients
+	var clients *auth.ClientPool
+	if len(config.OAuthClients) > 0 {
+		if authenticator == nil {
+			return nil, fmt.Errorf("additional OAuth clients require OAuth authentication")
+		}
+		clients, err = auth.NewClientPool(gmailService, config.OAuthClients)
+		if err != nil {
+			return nil, err
+		}
+		logrus.WithField("clients", clients.Len()).Info("Rotating Gmail API calls across OAuth clients")
 	}
 
 	// Create metrics collector
 	metricsCollector := metrics.NewCollector("export")
 
-	return &Exporter{
+	events := config.Events
+	if events == nil {
+		events = newProgressPrinter()
+	}
+
+	e := &Exporter{
 		config:        config,
 		authenticator: authenticator,
 		gmailService:  gmailService,
+		clients:       clients,
 		metrics:       metricsCollector,
-	}, nil
+		limiter:       newRateLimiter(config.MaxQPS),
+		quota:         newQuotaTracker(config.QuotaBudget),
+		redactor:      redactor,
+		labels:        newLabelSelector(config.OnlyLabels, config.SkipLabels, config.MaxPerLabel, config.LabelStrategy),
+		custody:       legalHold,
+		pause:         newPauseControl(pauseFilePath(config), window),
+		paths:         newPathNaming(config),
+		retry:         retry.New(retry.Defaults().Merge(config.Retry)),
+		events:        events,
+	}
+	e.labelCache = labels.New(e.listLabels, nil, 0)
+
+	// Refresh the token when Gmail rejects it mid-run instead of failing
+	// every remaining message
+	if authenticator != nil {
+		e.retry.SetReauth(authenticator.Reauthenticate)
+	}
+	return e, nil
+}
+
+// newGmailService authenticates with the OAuth token or Application Default
+// Credentials, or as the impersonated Workspace user when a service account
+// key is configured. The authenticator is nil unless the OAuth token is used.
+func newGmailService(config *Config) (*auth.Authenticator, *gmail.Service, error) {
+	if config.Simulate != nil {
+		gmailService, err := simulate.NewGmailService(*config.Simulate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to simulate Gmail service: %w", err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"messages":     config.Simulate.Messages,
+			"failure_rate": config.Simulate.FailureRate,
+		}).Warn("Simulating the Gmail API: exporting a fabricated mailbox with injected failures")
+		return nil, gmailService, nil
+	}
+
+	if config.ServiceAccountKey != "" || config.ImpersonateUser != "" {
+		serviceAccount, err := auth.NewServiceAccount(config.ServiceAccountKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load service account: %w", err)
+		}
+
+		gmailService, err := serviceAccount.GetGmailService(config.ImpersonateUser)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get Gmail service: %w", err)
+		}
+
+		return nil, gmailService, nil
+	}
+
+	authenticator, gmailService, err := auth.NewGmailService(config.AuthMode, auth.AccessReadonly, config.CredentialsFile, config.TokenFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	return authenticator, gmailService, nil
 }
 
 // Export performs the email export operation
 func (e *Exporter) Export(filterConfig *filters.Config) (*Result, error) {
 	startTime := time.Now()
+	startConns := httpclient.Stats()
 	e.metrics.Start()
 
 	logrus.WithField("query", filterConfig.BuildGmailQuery()).Info("Starting export with Gmail query")
@@ -109,48 +467,143 @@ func (e *Exporter) Export(filterConfig *filters.Config) (*Result, error) {
 	if err := filterConfig.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid filter configuration: %w", err)
 	}
+	e.filter = filterConfig
+	e.hooks = hooks.New(e.config.Hooks, e.config.OutputDir)
+	defer e.hooks.Close()
+	e.checkLabelFilters()
+
+	// Stop cleanly once the size or duration budget is used up
+	e.budget = startBudget(e.config, e.pause.stop)
+	defer e.budget.finish()
 
 	// Create output directory
 	if err := os.MkdirAll(e.config.OutputDir, 0o750); err != nil {
 		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Search for emails
-	messageIDs, err := e.searchEmails(filterConfig)
+	// Open the metadata cache shared with later commands
+	metadataCache, err := cache.Open(e.metadataCachePath())
 	if err != nil {
-		return nil, fmt.Errorf("failed to search emails: %w", err)
+		return nil, err
 	}
+	e.cache = metadataCache
+	defer func() {
+		if err := metadataCache.Close(); err != nil {
+			logrus.WithError(err).Warn("Failed to close metadata cache")
+		}
+	}()
 
-	logrus.WithField("count", len(messageIDs)).Info("Found emails matching filter")
+	// Load the emails exported by a previous run
+	if e.config.Resume {
+		e.processed, err = e.loadResumeState()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load resume state: %w", err)
+		}
+		e.processed = e.verifyProcessed(e.processed)
+		e.journaled = len(e.processed)
+	} else if err := os.Remove(e.processedJournalPath()); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove processed emails journal: %w", err)
+	}
+	removePartialFiles(e.config.OutputDir)
 
-	// Apply limit if specified
-	if e.config.Limit > 0 && len(messageIDs) > e.config.Limit {
-		messageIDs = messageIDs[:e.config.Limit]
-		logrus.WithField("limited_count", len(messageIDs)).Info("Limited number of emails to process")
+	// Load the emails of the previous snapshot to link instead of download
+	if e.config.LinkDest != "" {
+		e.linkable, err = e.loadLinkDest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load previous snapshot: %w", err)
+		}
 	}
 
-	// Set total matched in metrics
-	e.metrics.SetTotalMatched(len(messageIDs))
+	if e.custody != nil {
+		if err := e.startCustody(filterConfig); err != nil {
+			return nil, err
+		}
+	}
 
-	// Export emails
-	result, err := e.exportEmails(messageIDs)
+	// Export emails, optionally one date window at a time
+	var result *Result
+	if e.config.SplitBy != "" {
+		result, err = e.exportByWindows(filterConfig)
+	} else {
+		result, err = e.exportMatching(filterConfig, e.config.Limit)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to export emails: %w", err)
+		return nil, err
 	}
 
 	// Calculate duration
 	result.Duration = time.Since(startTime)
-	result.TotalMatched = len(messageIDs)
+	result.QuotaUnits = e.quota.consumed()
+	result.BudgetReached = e.budget.reason()
+	result.Cancelled = e.pause.isStopped() && result.BudgetReached == ""
+
+	// Write the records of the metadata format
+	if e.config.Format == FormatMetadata {
+		if err := e.saveMetadataExport(e.processed); err != nil {
+			return nil, fmt.Errorf("failed to write metadata export: %w", err)
+		}
+	}
+
+	// Sign the custody manifest and place the legal hold
+	if e.custody != nil {
+		if err := e.saveCustody(e.processed, time.Now().UTC()); err != nil {
+			return nil, fmt.Errorf("failed to record chain of custody: %w", err)
+		}
+	}
 
-	// Record metrics
-	e.metrics.RecordEmailsProcessed(result.TotalExported, result.TotalFailed)
-	e.metrics.RecordBytesProcessed(result.TotalSize)
+	// Report the authentication results, sender IPs and URLs for review
+	if e.config.Triage {
+		if err := e.saveTriageReport(e.processed); err != nil {
+			logrus.WithError(err).Warn("Failed to save triage report")
+		}
+	}
+
+	// Write the Thunderbird folders from the exported files
+	if e.config.ThunderbirdDir != "" {
+		if err := e.saveThunderbirdFolders(e.processed); err != nil {
+			return nil, fmt.Errorf("failed to write Thunderbird folders: %w", err)
+		}
+	}
+
+	// Tag the messages for notmuch after it indexes the export
+	if e.config.NotmuchTags {
+		if err := e.saveNotmuchTags(e.processed); err != nil {
+			logrus.WithError(err).Warn("Failed to save notmuch tags")
+		}
+	}
+
+	// Let the message hooks finish, then run the run hooks
+	result.HookFailures = e.hooks.Finish(hooks.Run{
+		OutputDir: e.config.OutputDir,
+		Matched:   result.TotalMatched,
+		Exported:  result.TotalExported,
+		Failed:    result.TotalFailed,
+		Skipped:   result.TotalSkipped,
+		Cancelled: result.Cancelled,
+		Duration:  time.Since(startTime),
+	})
+
+	// Report the skipped messages
+	if len(e.skipped) > 0 {
+		if err := e.saveSkipped(e.skipped); err != nil {
+			logrus.WithError(err).Warn("Failed to save skipped emails report")
+		}
+	}
+
+	// Record metrics (email and byte counts are recorded live by the workers)
 	e.metrics.RecordDuration(result.Duration)
+	conns := httpclient.Stats()
+	e.metrics.RecordConnections(conns.New-startConns.New, conns.Reused-startConns.Reused)
 
 	// Save metrics
-	if err := e.metrics.Save(filepath.Join(e.config.OutputDir, "metrics.json")); err != nil {
+	if err := e.metrics.Save(e.metricsPath()); err != nil {
 		logrus.WithError(err).Warn("Failed to save metrics")
 	}
+	if e.config.RunHistory {
+		if err := metrics.AppendHistory(filepath.Join(e.config.OutputDir, metrics.HistoryFileName), e.metrics.RunSummary()); err != nil {
+			logrus.WithError(err).Warn("Failed to record run history")
+		}
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"total_matched":  result.TotalMatched,
@@ -162,20 +615,98 @@ func (e *Exporter) Export(filterConfig *filters.Config) (*Result, error) {
 	return result, nil
 }
 
-// searchEmails searches for emails matching the filter criteria
+// exportMatching searches for and exports the emails matching filterConfig,
+// exporting at most limit emails (0 = no limit)
+func (e *Exporter) exportMatching(filterConfig *filters.Config, limit int) (*Result, error) {
+	// Search for emails
+	messageIDs, err := e.searchEmails(filterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search emails: %w", err)
+	}
+
+	logrus.WithField("count", len(messageIDs)).Info("Found emails matching filter")
+
+	// Skip emails exported by a previous run or an earlier date window
+	if e.config.Resume || e.config.SplitBy != "" {
+		before := len(messageIDs)
+		messageIDs = skipProcessed(messageIDs, e.processed)
+		if skipped := before - len(messageIDs); skipped > 0 {
+			logrus.WithFields(logrus.Fields{
+				"already_exported": skipped,
+				"remaining":        len(messageIDs),
+			}).Info("Skipping emails already exported")
+		}
+	}
+
+	// Link the emails the previous snapshot already holds
+	messageIDs, linked := e.linkPrevious(messageIDs)
+
+	// Apply sample or limit if specified
+	if e.config.Sample > 0 && len(messageIDs) > e.config.Sample {
+		messageIDs = sampleIDs(messageIDs, e.config.Sample, rand.IntN)
+		logrus.WithField("sampled_count", len(messageIDs)).Info("Sampled emails to process at random")
+	}
+	messageIDs = e.orderMessages(messageIDs)
+	truncated := limit > 0 && len(messageIDs) > limit
+	if truncated {
+		messageIDs = messageIDs[:limit]
+		logrus.WithField("limited_count", len(messageIDs)).Info("Limited number of emails to process")
+	}
+
+	// Add to total matched in metrics
+	e.metrics.AddMatched(len(messageIDs))
+
+	// Export emails
+	result, err := e.exportEmails(messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export emails: %w", err)
+	}
+	result.TotalMatched = len(messageIDs)
+	result.TotalLinked = linked
+	result.truncated = truncated
+	if e.budget.reason() != "" {
+		result.TotalRemaining = len(messageIDs) - result.TotalExported - result.TotalFailed - result.TotalSkipped
+	}
+
+	// A mailbox changing under the export may hold matching emails the
+	// listing missed
+	if limit == 0 && e.config.Sample == 0 {
+		if err := e.refreshSearch(filterConfig, messageIDs, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// searchEmails searches for emails matching the filter criteria, listing
+// date shards concurrently when ListShards is set
 func (e *Exporter) searchEmails(filterConfig *filters.Config) ([]string, error) {
+	if e.config.ListShards != "" {
+		return e.listSharded(filterConfig)
+	}
+	return e.listMessageIDs(filterConfig)
+}
+
+// listMessageIDs pages through the IDs of the emails matching filterConfig
+func (e *Exporter) listMessageIDs(filterConfig *filters.Config) ([]string, error) {
 	query := filterConfig.BuildGmailQuery()
 
 	var messageIDs []string
 	pageToken := ""
 
 	for {
-		req := e.gmailService.Users.Messages.List("me").Q(query)
-		if pageToken != "" {
-			req = req.PageToken(pageToken)
-		}
-
-		resp, err := req.Do()
+		var resp *gmail.ListMessagesResponse
+		err := e.callAPI("messages.list", func(service *gmail.Service) error {
+			req := service.Users.Messages.List("me").Q(query).IncludeSpamTrash(filterConfig.IncludesSpamTrash())
+			if pageToken != "" {
+				req = req.PageToken(pageToken)
+			}
+
+			var callErr error
+			resp, callErr = req.Do()
+			return callErr
+		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to list messages: %w", err)
 		}
@@ -199,12 +730,20 @@ func (e *Exporter) exportEmails(messageIDs []string) (*Result, error) {
 		Failures: make([]Failure, 0),
 	}
 
-	// Track successfully processed emails for filter file
-	var processedEmails []ProcessedEmail
-
-	// Create worker pool for parallel processing
-	if e.config.ParallelWorkers <= 0 {
-		e.config.ParallelWorkers = 1
+	// Track successfully processed emails for filter file, including those
+	// exported earlier in this run or by a resumed run
+	processedEmails := append([]ProcessedEmail(nil), e.processed...)
+	var pendingMetadata []cache.Metadata
+	checkpoints := newCheckpointer(e.config.CheckpointEvery, e.config.CheckpointInterval, time.Now())
+
+	// Create worker pool for parallel processing. In adaptive mode enough
+	// workers for the concurrency cap are started and the gate decides how
+	// many of them export at once.
+	var workers int
+	if e.config.AdaptiveWorkers {
+		workers = e.startAdaptiveGate(len(messageIDs))
+	} else {
+		workers = e.resolveWorkerCount(len(messageIDs))
 	}
 
 	jobs := make(chan string, len(messageIDs))
@@ -212,9 +751,9 @@ func (e *Exporter) exportEmails(messageIDs []string) (*Result, error) {
 
 	// Start workers
 	var wg sync.WaitGroup
-	for i := 0; i < e.config.ParallelWorkers; i++ {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go e.exportWorker(jobs, results, &wg)
+		go e.exportWorker(i, jobs, results, &wg)
 	}
 
 	// Send jobs
@@ -230,36 +769,110 @@ func (e *Exporter) exportEmails(messageIDs []string) (*Result, error) {
 	}()
 
 	// Collect results with progress indicator
-	processed := 0
-	total := len(messageIDs)
 	for exportRes := range results {
-		processed++
+		if exportRes.Checkpoint {
+			pendingMetadata = e.cacheMetadata(pendingMetadata)
+			e.checkpoint(processedEmails)
+			checkpoints.reset(time.Now())
+			continue
+		}
 
-		if exportRes.Error != nil {
+		if reason, skipped := skipReason(exportRes.Error); skipped {
+			result.TotalSkipped++
+			if result.SkippedByReason == nil {
+				result.SkippedByReason = make(map[string]int)
+			}
+			result.SkippedByReason[reason]++
+			if reason == SkipReasonDeleted {
+				result.TotalDeleted++
+			}
+			e.skipped = append(e.skipped, newSkippedEmail(exportRes.MessageID, reason, exportRes.Error, exportRes.Metadata))
+			logrus.WithField("message_id", exportRes.MessageID).Debug(exportRes.Error.Error())
+		} else if exportRes.Error != nil {
+			category := string(failure.Categorize(exportRes.Error))
 			result.TotalFailed++
+			if result.FailedByCategory == nil {
+				result.FailedByCategory = make(map[string]int)
+			}
+			result.FailedByCategory[category]++
 			result.Failures = append(result.Failures, Failure{
 				EmailID:   exportRes.MessageID,
+				Category:  category,
 				Error:     exportRes.Error.Error(),
 				Timestamp: time.Now(),
 			})
 			logrus.WithError(exportRes.Error).WithField("message_id", exportRes.MessageID).Error("Failed to export email")
+			e.events.OnError(ErrorEvent{
+				MessageID: exportRes.MessageID,
+				Category:  category,
+				Err:       exportRes.Error,
+				Progress:  e.metrics.Progress(),
+			})
 		} else {
 			result.TotalExported++
 			result.TotalSize += exportRes.Size
+			if e.budget.add(exportRes.Size) {
+				logrus.WithField("max_total_size", e.config.MaxTotalSize).Info("Size budget reached; stopping after the emails in progress")
+				e.pause.stop()
+			}
 
 			// Add to processed emails for filter file
-			processedEmails = append(processedEmails, ProcessedEmail{
+			processedEmail := ProcessedEmail{
 				ID:        exportRes.MessageID,
 				Size:      exportRes.Size,
 				Processed: time.Now(),
+				File:      e.relativePath(exportRes.File.Path),
+				SHA256:    exportRes.File.SHA256,
+				Labels:    exportRes.File.Labels,
+				RawSHA256: exportRes.File.RawSHA256,
+				State:     exportRes.File.State,
+			}
+			for _, copyPath := range exportRes.File.Copies {
+				processedEmail.Copies = append(processedEmail.Copies, e.relativePath(copyPath))
+			}
+			if exportRes.Metadata != nil {
+				processedEmail.Subject = exportRes.Metadata.Subject
+				processedEmail.From = exportRes.Metadata.From
+				processedEmail.Date = exportRes.Metadata.Date
+				pendingMetadata = append(pendingMetadata, *exportRes.Metadata)
+			}
+			processedEmails = append(processedEmails, processedEmail)
+			e.events.OnMessageExported(MessageEvent{
+				MessageID: processedEmail.ID,
+				File:      processedEmail.File,
+				Size:      processedEmail.Size,
+				Progress:  e.metrics.Progress(),
 			})
+			hookMessage := hooks.Message{
+				MessageID: processedEmail.ID,
+				File:      exportRes.File.Path,
+				SHA256:    processedEmail.SHA256,
+				Subject:   processedEmail.Subject,
+				From:      processedEmail.From,
+				Date:      processedEmail.Date,
+				Size:      processedEmail.Size,
+				OutputDir: e.config.OutputDir,
+			}
+			if exportRes.Metadata != nil {
+				hookMessage.Labels = exportRes.Metadata.Labels
+			}
+			e.hooks.Message(hookMessage)
 		}
 
-		// Show progress
-		fmt.Printf("\rProgress: %d of %d messages exported (%.1f%%)",
-			result.TotalExported, total, float64(processed)/float64(total)*100)
+		// Periodically flush partial results, and every result finished
+		// while the workers are held
+		if now := time.Now(); checkpoints.record(now) || e.pause.holding() {
+			pendingMetadata = e.cacheMetadata(pendingMetadata)
+			e.checkpoint(processedEmails)
+			checkpoints.reset(now)
+		}
 	}
-	fmt.Println() // New line after progress
+	if printer, ok := e.events.(*progressPrinter); ok {
+		printer.finish()
+	}
+
+	e.cacheMetadata(pendingMetadata)
+	e.processed = processedEmails
 
 	// Save processed emails filter file
 	if len(processedEmails) > 0 {
@@ -267,6 +880,11 @@ func (e *Exporter) exportEmails(messageIDs []string) (*Result, error) {
 			logrus.WithError(err).Warn("Failed to save processed emails filter file")
 		}
 	}
+	if e.config.LabelStrategy == LabelStrategyIndex {
+		if err := e.saveLabelIndex(processedEmails); err != nil {
+			logrus.WithError(err).Warn("Failed to save labels index")
+		}
+	}
 
 	return result, nil
 }
@@ -275,146 +893,469 @@ func (e *Exporter) exportEmails(messageIDs []string) (*Result, error) {
 type exportResult struct {
 	MessageID string
 	Size      int64
+	File      exportedFile
+	Metadata  *cache.Metadata
 	Error     error
+
+	// Checkpoint asks for the export state to be saved while the workers
+	// are held by a pause or the run window
+	Checkpoint bool
 }
 
 // exportWorker is a worker function for exporting emails in parallel
-func (e *Exporter) exportWorker(jobs <-chan string, results chan<- exportResult, wg *sync.WaitGroup) {
+func (e *Exporter) exportWorker(workerID int, jobs <-chan string, results chan<- exportResult, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for messageID := range jobs {
-		size, err := e.exportSingleEmail(messageID)
+		e.pause.wait(func() {
+			results <- exportResult{Checkpoint: true}
+		})
+		if e.pause.isStopped() {
+			continue
+		}
+		e.gate.acquire()
+		e.showWorker(workerID, messageID)
+		start := time.Now()
+		file, metadata, err := e.exportSingleEmail(messageID)
+		err = skipDeleted(err)
+		e.showWorker(workerID, "")
+		e.gate.release(err == nil)
+		e.recordExportResult(workerID, messageID, file.Size, time.Since(start), err)
 		results <- exportResult{
 			MessageID: messageID,
-			Size:      size,
+			Size:      file.Size,
+			File:      file,
+			Metadata:  metadata,
 			Error:     err,
 		}
 	}
 }
 
-// exportSingleEmail exports a single email
-func (e *Exporter) exportSingleEmail(messageID string) (int64, error) {
-	// Get the full message
-	message, err := e.gmailService.Users.Messages.Get("me", messageID).Format("full").Do()
+// showWorker shows the message a worker is exporting on the default progress
+// printer
+func (e *Exporter) showWorker(workerID int, messageID string) {
+	if printer, ok := e.events.(*progressPrinter); ok {
+		printer.worker(workerID, messageID)
+	}
+}
+
+// recordExportResult records the outcome of a single export in the metrics
+// collector as soon as the worker finishes it
+func (e *Exporter) recordExportResult(workerID int, messageID string, size int64, duration time.Duration, err error) {
+	if reason, skipped := skipReason(err); skipped {
+		e.metrics.RecordWorkerResult(workerID, 0, duration, nil)
+		e.metrics.AddSkipped(reason)
+		return
+	}
+
+	e.metrics.RecordWorkerResult(workerID, size, duration, err)
+
 	if err != nil {
-		return 0, fmt.Errorf("failed to get message: %w", err)
+		e.metrics.AddFailed(1)
+		e.metrics.RecordFailure(messageID, string(failure.Categorize(err)), err.Error())
+		return
 	}
 
-	// Determine output path
-	outputPath, err := e.getOutputPath(message)
+	e.metrics.AddExported(1)
+	e.metrics.AddBytes(size)
+}
+
+// exportSingleEmail exports a single email and returns the written file and
+// its metadata
+func (e *Exporter) exportSingleEmail(messageID string) (exportedFile, *cache.Metadata, error) {
+	// Get the full message, or only its headers and labels for the
+	// metadata format
+	format := "full"
+	if e.config.Format == FormatMetadata {
+		format = "metadata"
+	}
+	var message *gmail.Message
+	err := e.callAPI("messages.get", func(service *gmail.Service) error {
+		var callErr error
+		call := service.Users.Messages.Get("me", messageID).Format(format)
+		if format == "metadata" {
+			call = call.MetadataHeaders(e.metadataHeaders()...)
+		}
+		message, callErr = call.Do()
+		return callErr
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to determine output path: %w", err)
+		return exportedFile{}, nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	// Skip oversized messages before downloading their raw content
+	if err := e.checkMaxSize(message); err != nil {
+		metadata := cache.FromMessage(message)
+		e.redactMetadata(&metadata)
+		return exportedFile{}, &metadata, err
+	}
+
+	// The metadata format writes no file per message; its records are
+	// written from the metadata cache once the export finishes
+	if e.config.Format == FormatMetadata {
+		return e.exportMetadataOnly(message)
 	}
 
+	// Determine output paths
+	outputPaths, reserved, labels, err := e.getOutputPaths(message)
+	if err != nil {
+		return exportedFile{}, nil, fmt.Errorf("failed to determine output path: %w", err)
+	}
+	outputPath := outputPaths[0]
+
 	// Export based on format
-	var size int64
+	var file exportedFile
 	switch e.config.Format {
 	case "eml":
-		size, err = e.exportAsEML(message, outputPath)
+		file, err = e.exportAsEML(message, outputPath)
 	case "json":
-		size, err = e.exportAsJSON(message, outputPath)
+		file, err = e.exportAsJSON(message, outputPath)
 	case "mbox":
-		size, err = e.exportAsMbox(message, outputPath)
+		file, err = e.exportAsMbox(message, outputPath)
+	case "txt":
+		file, err = e.exportAsText(message, outputPath)
 	default:
-		return 0, fmt.Errorf("unsupported export format: %s", e.config.Format)
+		return exportedFile{}, nil, fmt.Errorf("unsupported export format: %s", e.config.Format)
 	}
 
+	if err == nil && len(outputPaths) > 1 {
+		file.Copies, err = e.replicateExport(file, outputPaths[1:])
+	}
 	if err != nil {
-		return 0, err
+		if e.config.OrganizeByLabels {
+			e.releaseLabels(reserved)
+		}
+		return exportedFile{}, nil, err
+	}
+	file.Labels = labels
+	file.State = messageState(message.LabelIds)
+
+	// Record the checksum of the raw message for the custody manifest
+	if e.custody != nil && file.RawSHA256 == "" {
+		if file.RawSHA256, err = e.rawSHA256(messageID); err != nil {
+			return exportedFile{}, nil, fmt.Errorf("failed to checksum raw message: %w", err)
+		}
 	}
 
-	return size, nil
+	metadata := cache.FromMessage(message)
+	e.redactMetadata(&metadata)
+	return file, &metadata, nil
 }
 
-// getOutputPath determines the output path for an email
-func (e *Exporter) getOutputPath(message *gmail.Message) (string, error) {
+// getOutputPaths determines the output paths for an email, the first being
+// the file to write and the rest label directories it is copied or linked
+// into, and, when organizing by labels, the label slots reserved for it and
+// the labels of the email
+func (e *Exporter) getOutputPaths(message *gmail.Message) (paths, reserved, labels []string, err error) {
 	// Create base filename from message ID and timestamp
 	filename := fmt.Sprintf("%s.%s", message.Id, e.config.Format)
 
 	if !e.config.OrganizeByLabels {
-		return filepath.Join(e.config.OutputDir, filename), nil
+		path, err := e.paths.filePath(e.config.OutputDir, filename)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return []string{path}, nil, nil, nil
 	}
 
 	// Organize by labels
-	labelDir := "unlabeled"
-	if len(message.LabelIds) > 0 {
-		// Use the first label for directory structure
-		// In a real implementation, you might want to get label names from the API
-		labelDir = message.LabelIds[0]
+	reserved, labels, err = e.selectLabels(message)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	outputDir := filepath.Join(e.config.OutputDir, labelDir)
-	if err := os.MkdirAll(outputDir, 0o750); err != nil {
-		return "", fmt.Errorf("failed to create label directory: %w", err)
+	var names map[string]string
+	if e.paths.byName {
+		names, _ = e.labelNames()
+	}
+
+	paths = make([]string, 0, len(reserved))
+	for _, label := range reserved {
+		path, err := e.paths.labelPath(e.config.OutputDir, label, names[label], filename)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0o750)
+		}
+		if err != nil {
+			e.releaseLabels(reserved)
+			return nil, nil, nil, fmt.Errorf("failed to create label directory: %w", err)
+		}
+		paths = append(paths, path)
 	}
 
-	return filepath.Join(outputDir, filename), nil
+	return paths, reserved, labels, nil
 }
 
 // exportAsEML exports an email in EML format
-func (e *Exporter) exportAsEML(message *gmail.Message, outputPath string) (int64, error) {
-	// Get the raw message
-	rawMessage, err := e.gmailService.Users.Messages.Get("me", message.Id).Format("raw").Do()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get raw message: %w", err)
+func (e *Exporter) exportAsEML(message *gmail.Message, outputPath string) (exportedFile, error) {
+	if e.streamRaw(message) {
+		return e.exportRawStreamed(message, outputPath)
 	}
 
-	// Decode the raw message
-	rawData, err := decodeBase64URL(rawMessage.Raw)
+	rawData, err := e.getRawMessage(message.Id)
 	if err != nil {
-		return 0, fmt.Errorf("failed to decode raw message: %w", err)
+		return exportedFile{}, err
+	}
+
+	// Enforce exact size bounds on the actual raw size
+	if err := e.checkExactSize(int64(len(rawData))); err != nil {
+		return exportedFile{}, err
+	}
+
+	var rawSHA256 string
+	if e.custody != nil {
+		rawSHA256 = sha256Hex(rawData)
+	}
+
+	if header := e.takeoutHeaders(message); header != nil {
+		rawData = append(header, rawData...)
 	}
 
 	// Write to file
-	if err := os.WriteFile(outputPath, rawData, 0o600); err != nil {
-		return 0, fmt.Errorf("failed to write EML file: %w", err)
+	file, err := e.writeExportFile(outputPath, rawData)
+	if err != nil {
+		return exportedFile{}, fmt.Errorf("failed to write EML file: %w", err)
 	}
+	file.RawSHA256 = rawSHA256
 
-	return int64(len(rawData)), nil
+	return file, nil
+}
+
+// getRawMessage downloads and decodes the raw RFC 822 message
+func (e *Exporter) getRawMessage(messageID string) ([]byte, error) {
+	encoded, err := e.getEncodedRawMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	rawData, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode raw message: %w", err)
+	}
+
+	return rawData, nil
+}
+
+// getEncodedRawMessage downloads the base64url encoded raw RFC 822 message
+func (e *Exporter) getEncodedRawMessage(messageID string) (string, error) {
+	var rawMessage *gmail.Message
+	err := e.callAPI("messages.get.raw", func(service *gmail.Service) error {
+		var callErr error
+		rawMessage, callErr = service.Users.Messages.Get("me", messageID).Format("raw").Do()
+		return callErr
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get raw message: %w", err)
+	}
+
+	return rawMessage.Raw, nil
 }
 
 // exportAsJSON exports an email in JSON format
-func (e *Exporter) exportAsJSON(message *gmail.Message, outputPath string) (int64, error) {
+func (e *Exporter) exportAsJSON(message *gmail.Message, outputPath string) (exportedFile, error) {
+	// Enforce exact size bounds on Gmail's size estimate, the only size
+	// available without downloading the raw message
+	if err := e.checkExactSize(message.SizeEstimate); err != nil {
+		return exportedFile{}, err
+	}
+
+	if err := e.normalizeCharsets(message); err != nil {
+		return exportedFile{}, fmt.Errorf("failed to transcode message: %w", err)
+	}
+
+	if err := e.redactMessage(message); err != nil {
+		return exportedFile{}, fmt.Errorf("failed to redact message: %w", err)
+	}
+
 	// Convert message to JSON
 	jsonData, err := json.MarshalIndent(message, "", "  ")
 	if err != nil {
-		return 0, fmt.Errorf("failed to marshal message to JSON: %w", err)
+		return exportedFile{}, fmt.Errorf("failed to marshal message to JSON: %w", err)
 	}
 
 	// Write to file
-	if err := os.WriteFile(outputPath, jsonData, 0o600); err != nil {
-		return 0, fmt.Errorf("failed to write JSON file: %w", err)
+	file, err := e.writeExportFile(outputPath, jsonData)
+	if err != nil {
+		return exportedFile{}, fmt.Errorf("failed to write JSON file: %w", err)
 	}
 
-	return int64(len(jsonData)), nil
+	return file, nil
 }
 
 // exportAsMbox exports an email in Mbox format
-func (e *Exporter) exportAsMbox(message *gmail.Message, outputPath string) (int64, error) {
+func (e *Exporter) exportAsMbox(message *gmail.Message, outputPath string) (exportedFile, error) {
 	// This is a simplified implementation
 	// In a real implementation, you would properly format the mbox
 	return e.exportAsEML(message, outputPath)
 }
 
+// relativePath returns path relative to the output directory
+func (e *Exporter) relativePath(path string) string {
+	if path == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(e.config.OutputDir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// metadataCachePath returns the path of the message metadata cache
+func (e *Exporter) metadataCachePath() string {
+	if e.config.MetadataCache != "" {
+		return e.config.MetadataCache
+	}
+	return filepath.Join(e.config.OutputDir, cache.DefaultFileName)
+}
+
+// cacheMetadata writes pending metadata entries to the cache and returns the
+// emptied pending slice. Cache failures are logged, not fatal.
+func (e *Exporter) cacheMetadata(pending []cache.Metadata) []cache.Metadata {
+	if e.cache == nil || len(pending) == 0 {
+		return pending[:0]
+	}
+
+	if err := e.cache.Put(pending...); err != nil {
+		logrus.WithError(err).Warn("Failed to update metadata cache")
+	}
+
+	return pending[:0]
+}
+
 // validateConfig validates the exporter configuration
 func validateConfig(config *Config) error {
-	if config.CredentialsFile == "" {
-		return fmt.Errorf("credentials file is required")
+	if err := auth.ValidateMode(config.AuthMode); err != nil {
+		return err
 	}
-	if config.TokenFile == "" {
-		return fmt.Errorf("token file is required")
+	if config.Simulate != nil {
+		if config.ServiceAccountKey != "" || config.ImpersonateUser != "" || len(config.OAuthClients) > 0 {
+			return fmt.Errorf("a simulated export cannot use a service account or additional OAuth clients")
+		}
+		if err := config.Simulate.Validate(); err != nil {
+			return err
+		}
+	} else if config.ServiceAccountKey != "" || config.ImpersonateUser != "" {
+		if config.ImpersonateUser == "" {
+			return fmt.Errorf("impersonated user is required with a service account key")
+		}
+	} else {
+		if config.CredentialsFile == "" {
+			return fmt.Errorf("credentials file is required")
+		}
+		if config.TokenFile == "" {
+			return fmt.Errorf("token file is required")
+		}
 	}
 	if config.OutputDir == "" {
 		return fmt.Errorf("output directory is required")
 	}
+	if config.LinkDest != "" && config.Resume {
+		return fmt.Errorf("resume cannot be combined with a link destination")
+	}
 	if config.ParallelWorkers < 0 {
 		return fmt.Errorf("parallel workers must be >= 0")
 	}
+	if config.MaxQPS < 0 {
+		return fmt.Errorf("max qps must be >= 0")
+	}
+	if (len(config.OnlyLabels) > 0 || len(config.SkipLabels) > 0 || config.MaxPerLabel > 0) && !config.OrganizeByLabels {
+		return fmt.Errorf("label filters and per-label caps require organize by labels")
+	}
+	if config.LabelStrategy != "" && config.LabelStrategy != LabelStrategyFirst && !config.OrganizeByLabels {
+		return fmt.Errorf("label strategy %s requires organize by labels", config.LabelStrategy)
+	}
+	if config.LabelStrategy == "" {
+		config.LabelStrategy = LabelStrategyFirst
+	}
+	if !isValidLabelStrategy(config.LabelStrategy) {
+		return fmt.Errorf("invalid label strategy: %s (valid: %s)", config.LabelStrategy, strings.Join(validLabelStrategies, ", "))
+	}
+	if (config.LabelDirNames || (config.Transliterate != "" && config.Transliterate != TransliterateNone)) && !config.OrganizeByLabels {
+		return fmt.Errorf("label directory names require organize by labels")
+	}
+	if config.Transliterate == "" {
+		config.Transliterate = TransliterateNone
+	}
+	if !isValidTransliteration(config.Transliterate) {
+		return fmt.Errorf("invalid transliteration: %s (valid: %s)", config.Transliterate, strings.Join(validTransliterations, ", "))
+	}
+	if config.MaxPathLength < 0 {
+		return fmt.Errorf("max path length must be >= 0")
+	}
+	if config.MaxPerLabel < 0 {
+		return fmt.Errorf("max per label must be >= 0")
+	}
+	if config.LegalHold && (len(config.Redact) > 0 || len(config.RedactPatterns) > 0) {
+		return fmt.Errorf("legal hold exports cannot be redacted")
+	}
+	if config.Triage && config.Format != "" && config.Format != "eml" {
+		return fmt.Errorf("triage requires the eml format")
+	}
+	if config.ThunderbirdDir != "" && config.Format != "" && config.Format != "eml" && config.Format != "mbox" {
+		return fmt.Errorf("thunderbird folders require the eml or mbox format")
+	}
+	if err := hooks.Validate(config.Hooks); err != nil {
+		return err
+	}
+	if config.NotmuchTags && config.Format != "" && config.Format != "eml" && config.Format != "mbox" {
+		return fmt.Errorf("notmuch tags require the eml or mbox format")
+	}
+	if config.DefaultCharset != "" && !validCharset(config.DefaultCharset) {
+		return fmt.Errorf("unknown default charset: %s", config.DefaultCharset)
+	}
+	if config.QuotaBudget < 0 {
+		return fmt.Errorf("quota budget must be >= 0")
+	}
+	if config.SkipLargerThan < 0 {
+		return fmt.Errorf("skip larger than must be >= 0")
+	}
+	if config.MaxInMemorySize < 0 {
+		return fmt.Errorf("max in-memory size must be >= 0")
+	}
+	if config.MaxInMemorySize == 0 {
+		config.MaxInMemorySize = DefaultMaxInMemorySize
+	}
+	if config.CheckpointEvery < 0 {
+		return fmt.Errorf("checkpoint every must be >= 0")
+	}
+	if config.CheckpointEvery == 0 {
+		config.CheckpointEvery = DefaultCheckpointEvery
+	}
+	if config.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint interval must be >= 0")
+	}
+	if config.CheckpointInterval == 0 {
+		config.CheckpointInterval = DefaultCheckpointInterval
+	}
 	if config.Format == "" {
 		config.Format = "eml"
 	}
+	if config.SplitBy != "" && !isValidSplitBy(config.SplitBy) {
+		return fmt.Errorf("invalid split-by: %s (valid: %s)", config.SplitBy, strings.Join(validSplitBy, ", "))
+	}
+	if config.ListShards != "" && !isValidSplitBy(config.ListShards) {
+		return fmt.Errorf("invalid list shards: %s (valid: %s)", config.ListShards, strings.Join(validSplitBy, ", "))
+	}
+	if config.ListConcurrency < 0 {
+		return fmt.Errorf("list concurrency must be >= 0")
+	}
+	if config.RefreshSearchAt < 0 || config.RefreshSearchAt > 1 {
+		return fmt.Errorf("refresh search rate must be between 0 and 1")
+	}
+	if err := validateOrder(config); err != nil {
+		return err
+	}
+	if config.MaxTotalSize < 0 || config.MaxDuration < 0 {
+		return fmt.Errorf("max total size and max duration must be >= 0")
+	}
+	if config.Sample < 0 {
+		return fmt.Errorf("sample size must be >= 0")
+	}
+	if config.Sample > 0 && (config.Limit > 0 || config.SplitBy != "") {
+		return fmt.Errorf("a sample cannot be combined with a limit or split-by")
+	}
 
-	validFormats := []string{"eml", "json", "mbox"}
+	validFormats := []string{"eml", "json", "mbox", "txt", FormatMetadata}
 	valid := false
 	for _, format := range validFormats {
 		if config.Format == format {
@@ -423,7 +1364,15 @@ func validateConfig(config *Config) error {
 		}
 	}
 	if !valid {
-		return fmt.Errorf("invalid format: %s (valid: eml, json, mbox)", config.Format)
+		return fmt.Errorf("invalid format: %s (valid: eml, json, mbox, txt, metadata)", config.Format)
+	}
+	// Text parts of raw messages are mostly base64 or quoted-printable
+	// encoded, where the redaction patterns cannot see them
+	if (len(config.Redact) > 0 || len(config.RedactPatterns) > 0) && (config.Format == "eml" || config.Format == "mbox") {
+		return fmt.Errorf("redaction is not available for the %s format; use json, txt or metadata", config.Format)
+	}
+	if err := validateMetadataFormat(config); err != nil {
+		return err
 	}
 
 	return nil
@@ -446,23 +1395,24 @@ func decodeBase64URL(data string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(data)
 }
 
-// saveProcessedEmailsFilter saves the list of processed emails to a filter file
+// saveProcessedEmailsFilter saves the list of processed emails to a filter
+// file, replacing the journal of its checkpoints
 func (e *Exporter) saveProcessedEmailsFilter(processedEmails []ProcessedEmail) error {
-	filterFile := filepath.Join(e.config.OutputDir, "processed_emails.json")
+	filterFile := e.processedEmailsPath()
 
-	data, err := json.MarshalIndent(processedEmails, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal processed emails: %w", err)
+	if err := writeProcessedEmails(filterFile, processedEmails); err != nil {
+		return err
 	}
-
-	if err := os.WriteFile(filterFile, data, 0o600); err != nil {
-		return fmt.Errorf("failed to write filter file: %w", err)
+	if err := os.Remove(e.processedJournalPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove processed emails journal: %w", err)
 	}
+	e.journaled = len(processedEmails)
 
 	logrus.WithFields(logrus.Fields{
 		"filter_file": filterFile,
 		"count":       len(processedEmails),
 	}).Info("Saved processed emails filter file")
+	e.events.OnStateSaved(StateEvent{Path: filterFile, Processed: len(processedEmails), Final: true})
 
 	return nil
 }
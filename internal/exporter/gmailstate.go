@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// stateLabels are the system labels that carry a message's read, starred
+// and importance state, which the raw message itself does not record
+var stateLabels = []string{"UNREAD", "STARRED", "IMPORTANT"}
+
+// systemLabelNames are the names Google Takeout writes for system labels in
+// X-Gmail-Labels headers
+var systemLabelNames = map[string]string{
+	"INBOX":               "Inbox",
+	"SENT":                "Sent",
+	"DRAFT":               "Drafts",
+	"CHAT":                "Chat",
+	"SPAM":                "Spam",
+	"TRASH":               "Trash",
+	"UNREAD":              "Unread",
+	"STARRED":             "Starred",
+	"IMPORTANT":           "Important",
+	"CATEGORY_PERSONAL":   "Category Personal",
+	"CATEGORY_SOCIAL":     "Category Social",
+	"CATEGORY_PROMOTIONS": "Category Promotions",
+	"CATEGORY_UPDATES":    "Category Updates",
+	"CATEGORY_FORUMS":     "Category Forums",
+}
+
+// messageState returns the state labels of a message
+func messageState(labelIDs []string) []string {
+	var state []string
+	for _, label := range stateLabels {
+		for _, id := range labelIDs {
+			if id == label {
+				state = append(state, label)
+				break
+			}
+		}
+	}
+	return state
+}
+
+// takeoutHeaderWidth is the line length X-Gmail-Labels is folded at
+const takeoutHeaderWidth = 78
+
+// takeoutHeaders returns the X-GM-THRID and X-Gmail-Labels header lines
+// Google Takeout writes, or nil unless GmailLabelsHeader is set. Labels are
+// listed by name and read messages get Takeout's "Opened" label. The
+// importer restores labels and state from them.
+func (e *Exporter) takeoutHeaders(message *gmail.Message) []byte {
+	if !e.config.GmailLabelsHeader || len(message.LabelIds) == 0 {
+		return nil
+	}
+
+	names, _ := e.labelNames()
+	labels := make([]string, 0, len(message.LabelIds)+1)
+	for _, id := range message.LabelIds {
+		name, ok := systemLabelNames[id]
+		if !ok {
+			name = names[id]
+		}
+		if name == "" {
+			// Unknown system labels such as CATEGORY_* added later by Gmail
+			name = id
+		}
+		labels = append(labels, strings.ReplaceAll(name, ",", " "))
+	}
+	if !slices.Contains(message.LabelIds, "UNREAD") {
+		labels = append(labels, "Opened")
+	}
+
+	var header strings.Builder
+	// Takeout writes the thread ID in decimal
+	if thread, err := strconv.ParseUint(message.ThreadId, 16, 64); err == nil {
+		header.WriteString("X-GM-THRID: " + strconv.FormatUint(thread, 10) + "\r\n")
+	}
+
+	line := "X-Gmail-Labels: "
+	for n, label := range labels {
+		if n > 0 {
+			line += ","
+			if len(line)+len(label) > takeoutHeaderWidth {
+				header.WriteString(line + "\r\n")
+				line = " "
+			}
+		}
+		line += label
+	}
+	header.WriteString(line + "\r\n")
+
+	return []byte(header.String())
+}
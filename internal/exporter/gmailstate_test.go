@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+)
+
+func TestMessageState(t *testing.T) {
+	tests := []struct {
+		labelIDs []string
+		want     []string
+	}{
+		{labelIDs: nil, want: nil},
+		{labelIDs: []string{"INBOX", "Label_1"}, want: nil},
+		{labelIDs: []string{"IMPORTANT", "INBOX", "UNREAD"}, want: []string{"UNREAD", "IMPORTANT"}},
+		{labelIDs: []string{"STARRED"}, want: []string{"STARRED"}},
+	}
+
+	for _, tt := range tests {
+		if got := messageState(tt.labelIDs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("messageState(%v) = %v, want %v", tt.labelIDs, got, tt.want)
+		}
+	}
+}
+
+func TestTakeoutHeaders(t *testing.T) {
+	e := &Exporter{config: &Config{GmailLabelsHeader: true}, labels: &labelSelector{}}
+	e.labelCache = labels.Static([]*gmail.Label{
+		{Id: "Label_1", Name: "Clients, Acme"},
+		{Id: "Label_2", Name: "Projects/Apollo/Launch Review"},
+		{Id: "INBOX", Name: "INBOX"},
+	})
+
+	tests := []struct {
+		name    string
+		message *gmail.Message
+		want    string
+	}{
+		{
+			name:    "unread",
+			message: &gmail.Message{ThreadId: "18c2a4f5e6d7b801", LabelIds: []string{"INBOX", "UNREAD", "Label_1"}},
+			want:    "X-GM-THRID: 1784169778438977537\r\nX-Gmail-Labels: Inbox,Unread,Clients  Acme\r\n",
+		},
+		{
+			name:    "read, unknown system label",
+			message: &gmail.Message{LabelIds: []string{"STARRED", "CATEGORY_RESERVATIONS"}},
+			want:    "X-Gmail-Labels: Starred,CATEGORY_RESERVATIONS,Opened\r\n",
+		},
+		{
+			name:    "folded",
+			message: &gmail.Message{LabelIds: []string{"INBOX", "IMPORTANT", "Label_1", "Label_2", "CATEGORY_PERSONAL"}},
+			want:    "X-Gmail-Labels: Inbox,Important,Clients  Acme,Projects/Apollo/Launch Review,\r\n Category Personal,Opened\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(e.takeoutHeaders(tt.message)); got != tt.want {
+				t.Errorf("takeoutHeaders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if header := e.takeoutHeaders(&gmail.Message{}); header != nil {
+		t.Errorf("Expected no header for a message without labels, got %q", header)
+	}
+
+	e.config.GmailLabelsHeader = false
+	if header := e.takeoutHeaders(tests[0].message); header != nil {
+		t.Errorf("Expected no header when disabled, got %q", header)
+	}
+}
@@ -0,0 +1,361 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// unlabeledDir holds messages without labels in organize-by-labels mode. It
+// can be named in OnlyLabels and SkipLabels like a label.
+const unlabeledDir = "unlabeled"
+
+// Strategies for messages with several labels in organize-by-labels mode
+const (
+	// LabelStrategyFirst writes the message to the directory of its first label
+	LabelStrategyFirst = "first"
+	// LabelStrategyCopy writes a copy of the message to every label directory
+	LabelStrategyCopy = "copy"
+	// LabelStrategyHardlink writes the message once and hardlinks it into the
+	// other label directories, copying where links are not supported
+	LabelStrategyHardlink = "hardlink"
+	// LabelStrategyIndex writes the message once, under its first label, and
+	// lists all its labels in the labels index file
+	LabelStrategyIndex = "index"
+)
+
+var validLabelStrategies = []string{LabelStrategyFirst, LabelStrategyCopy, LabelStrategyHardlink, LabelStrategyIndex}
+
+// labelIndexFileName is the labels index written by LabelStrategyIndex
+const labelIndexFileName = "labels_index.json"
+
+// isValidLabelStrategy checks if the label strategy is supported
+func isValidLabelStrategy(strategy string) bool {
+	for _, valid := range validLabelStrategies {
+		if strategy == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// labelSelector picks the label directory of each message in
+// organize-by-labels mode, applying the label filters and per-label caps
+type labelSelector struct {
+	only     map[string]bool
+	skip     map[string]bool
+	max      int
+	strategy string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// newLabelSelector builds a selector from the configured label filters.
+// Labels are matched case-insensitively by name or ID.
+func newLabelSelector(only, skip []string, max int, strategy string) *labelSelector {
+	return &labelSelector{
+		only:     labelSet(only),
+		skip:     labelSet(skip),
+		max:      max,
+		strategy: strategy,
+		counts:   make(map[string]int),
+	}
+}
+
+// multiDir reports whether messages are written to every label directory
+// rather than just one
+func (s *labelSelector) multiDir() bool {
+	return s.strategy == LabelStrategyCopy || s.strategy == LabelStrategyHardlink
+}
+
+// labelSet lowercases label names into a set, or returns nil when empty
+func labelSet(labels []string) map[string]bool {
+	if len(labels) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		set[strings.ToLower(strings.TrimSpace(label))] = true
+	}
+	return set
+}
+
+// matches reports whether a label ID or its name is in set
+func matches(set map[string]bool, id, name string) bool {
+	return set[strings.ToLower(id)] || set[strings.ToLower(name)]
+}
+
+// eligible returns the message's labels that pass the include and exclude
+// filters, in the message's label order. A message without labels is
+// treated as carrying the unlabeled pseudo-label.
+func (s *labelSelector) eligible(labelIDs []string, names map[string]string) []string {
+	if len(labelIDs) == 0 {
+		labelIDs = []string{unlabeledDir}
+	}
+
+	var labels []string
+	for _, id := range labelIDs {
+		name := names[id]
+		if s.skip != nil && matches(s.skip, id, name) {
+			continue
+		}
+		if s.only != nil && !matches(s.only, id, name) {
+			continue
+		}
+		labels = append(labels, id)
+	}
+	return labels
+}
+
+// reserve claims a slot under label, failing once the per-label cap is
+// reached. A reservation is returned with release if the export fails.
+func (s *labelSelector) reserve(label string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.max > 0 && s.counts[label] >= s.max {
+		return false
+	}
+	s.counts[label]++
+	return true
+}
+
+// release returns a slot claimed by reserve
+func (s *labelSelector) release(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counts[label] > 0 {
+		s.counts[label]--
+	}
+}
+
+// selectLabels picks the directory labels for a message and returns them
+// with all its eligible labels. With the copy and hardlink strategies every
+// eligible label with room left under the per-label cap gets a directory,
+// otherwise only the first one does. Messages whose labels are all filtered
+// out or full are skipped.
+func (e *Exporter) selectLabels(message *gmail.Message) (dirs, labels []string, err error) {
+	names, err := e.labelNames()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labels = e.labels.eligible(message.LabelIds, names)
+	if len(labels) == 0 {
+		return nil, nil, &skipError{
+			reason: SkipReasonLabelFilter,
+			detail: fmt.Sprintf("labels %v excluded by label filters", message.LabelIds),
+		}
+	}
+
+	for _, label := range labels {
+		if e.labels.reserve(label) {
+			dirs = append(dirs, label)
+			if !e.labels.multiDir() {
+				break
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, nil, &skipError{
+			reason: SkipReasonLabelQuota,
+			detail: fmt.Sprintf("per-label cap of %d reached for %v", e.labels.max, labels),
+		}
+	}
+
+	return dirs, labels, nil
+}
+
+// releaseLabels returns the slots reserved for dirs
+func (e *Exporter) releaseLabels(dirs []string) {
+	for _, dir := range dirs {
+		e.labels.release(dir)
+	}
+}
+
+// needsLabelNames reports whether the export uses label names: to match
+// label filters, for the labels index, to name label directories and
+// Thunderbird folders, and for X-Gmail-Labels headers and notmuch tags
+func (e *Exporter) needsLabelNames() bool {
+	return e.labels.only != nil || e.labels.skip != nil || e.labels.strategy == LabelStrategyIndex || e.paths.byName ||
+		e.config.GmailLabelsHeader || e.config.ThunderbirdDir != "" || e.config.NotmuchTags
+}
+
+// labelNames returns label names by ID, fetched once per export. Nothing is
+// fetched unless the export needs them.
+func (e *Exporter) labelNames() (map[string]string, error) {
+	if !e.needsLabelNames() {
+		return nil, nil
+	}
+	return e.labelCache.Names()
+}
+
+// checkLabelFilters warns about label filter entries that name no label of
+// the mailbox, which would otherwise silently match nothing
+func (e *Exporter) checkLabelFilters() {
+	for _, filter := range [][]string{e.config.OnlyLabels, e.config.SkipLabels} {
+		for _, label := range filter {
+			found, err := e.labelCache.Lookup(label)
+			if err != nil {
+				logrus.WithError(err).Warn("Failed to check label filters")
+				return
+			}
+			if found == nil && !strings.EqualFold(strings.TrimSpace(label), unlabeledDir) {
+				logrus.WithField("label", label).Warn("Label filter matches no label of the mailbox")
+			}
+		}
+	}
+}
+
+// listLabels fetches the labels of the mailbox for the label cache
+func (e *Exporter) listLabels() ([]*gmail.Label, error) {
+	var resp *gmail.ListLabelsResponse
+	err := e.callAPI("labels.list", func(service *gmail.Service) error {
+		var callErr error
+		resp, callErr = service.Users.Labels.List("me").Do()
+		return callErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Labels, nil
+}
+
+// replicateExport places the export file in the extra label directories
+// paths, as copies or, with the hardlink strategy, as hardlinks
+func (e *Exporter) replicateExport(file exportedFile, paths []string) ([]string, error) {
+	for _, path := range paths {
+		if e.labels.strategy == LabelStrategyHardlink {
+			// A file left by an earlier run would make the link fail
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to replace %s: %w", path, err)
+			}
+			err := os.Link(file.Path, path)
+			if err == nil {
+				continue
+			}
+			logrus.WithError(err).WithField("file", path).Debug("Hardlink failed, copying instead")
+		}
+
+		if err := e.copyExportFile(file.Path, path); err != nil {
+			return nil, fmt.Errorf("failed to copy export to %s: %w", path, err)
+		}
+	}
+
+	return paths, nil
+}
+
+// copyExportFile atomically copies the export file src to dst
+func (e *Exporter) copyExportFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return atomicfile.Write(dst, 0o600, atomicfile.Options{Sync: e.config.Fsync}, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// labelIndexEntry lists the export files of one label in the labels index
+type labelIndexEntry struct {
+	Name  string   `json:"name,omitempty"`
+	Files []string `json:"files"`
+}
+
+// buildLabelIndex maps each label ID to its name and the export files of the
+// processed emails carrying it
+func buildLabelIndex(processedEmails []ProcessedEmail, names map[string]string) map[string]*labelIndexEntry {
+	index := make(map[string]*labelIndexEntry)
+	for _, email := range processedEmails {
+		if email.File == "" {
+			continue
+		}
+		for _, label := range email.Labels {
+			entry, ok := index[label]
+			if !ok {
+				entry = &labelIndexEntry{Name: names[label]}
+				index[label] = entry
+			}
+			entry.Files = append(entry.Files, email.File)
+		}
+	}
+	return index
+}
+
+// saveLabelIndex writes the labels index of the processed emails
+func (e *Exporter) saveLabelIndex(processedEmails []ProcessedEmail) error {
+	names, err := e.labelNames()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(buildLabelIndex(processedEmails, names), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal labels index: %w", err)
+	}
+
+	path := filepath.Join(e.config.OutputDir, labelIndexFileName)
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write labels index: %w", err)
+	}
+
+	logrus.WithField("labels_index", path).Info("Saved labels index")
+	return nil
+}
+
+// exportedMessage is an exported message with all its labels
+type exportedMessage struct {
+	ID     string
+	File   string
+	Date   time.Time
+	Labels []string
+}
+
+// exportedMessages returns the processed emails with the labels and date
+// recorded in the metadata cache, oldest first. Emails without a file are
+// left out.
+func (e *Exporter) exportedMessages(processedEmails []ProcessedEmail) ([]exportedMessage, error) {
+	messages := make([]exportedMessage, 0, len(processedEmails))
+	for _, email := range processedEmails {
+		if email.File == "" {
+			continue
+		}
+
+		message := exportedMessage{ID: email.ID, File: email.File, Date: email.Date, Labels: email.Labels}
+		if e.cache != nil {
+			metadata, err := e.cache.Get(email.ID)
+			if err != nil {
+				return nil, err
+			}
+			if metadata != nil {
+				message.Labels = metadata.Labels
+				if message.Date.IsZero() {
+					message.Date = metadata.Date
+				}
+			}
+		}
+		if message.Date.IsZero() {
+			message.Date = email.Processed
+		}
+		messages = append(messages, message)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Date.Before(messages[j].Date) })
+	return messages, nil
+}
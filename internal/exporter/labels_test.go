@@ -0,0 +1,199 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestLabelSelectorEligible(t *testing.T) {
+	names := map[string]string{
+		"Label_1":             "Projects",
+		"CATEGORY_PROMOTIONS": "CATEGORY_PROMOTIONS",
+		"INBOX":               "INBOX",
+	}
+
+	tests := []struct {
+		name     string
+		only     []string
+		skip     []string
+		labelIDs []string
+		want     []string
+	}{
+		{
+			name:     "no filters",
+			labelIDs: []string{"INBOX", "Label_1"},
+			want:     []string{"INBOX", "Label_1"},
+		},
+		{
+			name:     "skip by ID",
+			skip:     []string{"CATEGORY_PROMOTIONS"},
+			labelIDs: []string{"CATEGORY_PROMOTIONS", "INBOX"},
+			want:     []string{"INBOX"},
+		},
+		{
+			name:     "only by name, case-insensitive",
+			only:     []string{"projects"},
+			labelIDs: []string{"INBOX", "Label_1"},
+			want:     []string{"Label_1"},
+		},
+		{
+			name:     "all filtered",
+			skip:     []string{"inbox", "category_promotions"},
+			labelIDs: []string{"CATEGORY_PROMOTIONS", "INBOX"},
+			want:     nil,
+		},
+		{
+			name: "unlabeled pseudo-label",
+			want: []string{unlabeledDir},
+		},
+		{
+			name: "unlabeled skipped",
+			skip: []string{unlabeledDir},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newLabelSelector(tt.only, tt.skip, 0, LabelStrategyFirst)
+			got := s.eligible(tt.labelIDs, names)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("eligible(%v) = %v, want %v", tt.labelIDs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelSelectorQuota(t *testing.T) {
+	s := newLabelSelector(nil, nil, 2, LabelStrategyFirst)
+
+	if !s.reserve("INBOX") || !s.reserve("INBOX") {
+		t.Fatal("Expected first two reservations to succeed")
+	}
+	if s.reserve("INBOX") {
+		t.Fatal("Expected reservation beyond the cap to fail")
+	}
+	if !s.reserve("Label_1") {
+		t.Error("Expected cap to apply per label")
+	}
+
+	s.release("INBOX")
+	if !s.reserve("INBOX") {
+		t.Error("Expected released slot to be reusable")
+	}
+}
+
+func TestSelectLabelsSkips(t *testing.T) {
+	e := &Exporter{config: &Config{}, labels: newLabelSelector(nil, nil, 1, LabelStrategyFirst)}
+
+	msg := &gmail.Message{Id: "m1", LabelIds: []string{"INBOX", "Label_1"}}
+	dirs, _, err := e.selectLabels(msg)
+	if err != nil || !reflect.DeepEqual(dirs, []string{"INBOX"}) {
+		t.Fatalf("selectLabels() = %v, %v; want [INBOX]", dirs, err)
+	}
+
+	dirs, _, err = e.selectLabels(msg)
+	if err != nil || !reflect.DeepEqual(dirs, []string{"Label_1"}) {
+		t.Fatalf("selectLabels() = %v, %v; want fallback to [Label_1]", dirs, err)
+	}
+
+	_, _, err = e.selectLabels(msg)
+	if reason, skipped := skipReason(err); !skipped || reason != SkipReasonLabelQuota {
+		t.Errorf("Expected %q skip, got %v", SkipReasonLabelQuota, err)
+	}
+}
+
+func TestSelectLabelsCopy(t *testing.T) {
+	e := &Exporter{config: &Config{}, labels: newLabelSelector(nil, nil, 1, LabelStrategyCopy)}
+
+	first := &gmail.Message{Id: "m1", LabelIds: []string{"INBOX", "Label_1"}}
+	dirs, labels, err := e.selectLabels(first)
+	if err != nil {
+		t.Fatalf("selectLabels() error = %v", err)
+	}
+	if want := []string{"INBOX", "Label_1"}; !reflect.DeepEqual(dirs, want) || !reflect.DeepEqual(labels, want) {
+		t.Errorf("selectLabels() = %v, %v; want %v in both", dirs, labels, want)
+	}
+
+	// Full labels are left out, the rest still get a copy
+	second := &gmail.Message{Id: "m2", LabelIds: []string{"INBOX", "Label_2"}}
+	dirs, labels, err = e.selectLabels(second)
+	if err != nil {
+		t.Fatalf("selectLabels() error = %v", err)
+	}
+	if !reflect.DeepEqual(dirs, []string{"Label_2"}) {
+		t.Errorf("Expected dirs [Label_2], got %v", dirs)
+	}
+	if !reflect.DeepEqual(labels, []string{"INBOX", "Label_2"}) {
+		t.Errorf("Expected labels [INBOX Label_2], got %v", labels)
+	}
+}
+
+func TestReplicateExport(t *testing.T) {
+	for _, strategy := range []string{LabelStrategyCopy, LabelStrategyHardlink} {
+		t.Run(strategy, func(t *testing.T) {
+			dir := t.TempDir()
+			e := &Exporter{
+				config: &Config{OutputDir: dir},
+				labels: newLabelSelector(nil, nil, 0, strategy),
+			}
+
+			src := filepath.Join(dir, "INBOX", "m1.eml")
+			dst := filepath.Join(dir, "Label_1", "m1.eml")
+			for _, path := range []string{src, dst} {
+				if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A stale file from an earlier run is replaced
+			if err := os.WriteFile(dst, []byte("stale"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := e.writeExportFile(src, []byte("message"))
+			if err != nil {
+				t.Fatalf("writeExportFile() error = %v", err)
+			}
+
+			copies, err := e.replicateExport(file, []string{dst})
+			if err != nil {
+				t.Fatalf("replicateExport() error = %v", err)
+			}
+			if !reflect.DeepEqual(copies, []string{dst}) {
+				t.Errorf("Expected copies [%s], got %v", dst, copies)
+			}
+
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatalf("Failed to read copy: %v", err)
+			}
+			if string(data) != "message" {
+				t.Errorf("Expected copy content %q, got %q", "message", data)
+			}
+		})
+	}
+}
+
+func TestBuildLabelIndex(t *testing.T) {
+	processed := []ProcessedEmail{
+		{ID: "m1", File: "Label_1/m1.eml", Labels: []string{"Label_1", "Label_2"}},
+		{ID: "m2", File: "Label_2/m2.eml", Labels: []string{"Label_2"}},
+		{ID: "m3", File: "m3.eml"},
+	}
+	names := map[string]string{"Label_1": "Clients/Acme", "Label_2": "Invoices"}
+
+	index := buildLabelIndex(processed, names)
+	if len(index) != 2 {
+		t.Fatalf("Expected 2 labels in index, got %d", len(index))
+	}
+	if entry := index["Label_1"]; entry.Name != "Clients/Acme" || !reflect.DeepEqual(entry.Files, []string{"Label_1/m1.eml"}) {
+		t.Errorf("Unexpected Label_1 entry: %+v", entry)
+	}
+	if entry := index["Label_2"]; entry.Name != "Invoices" || !reflect.DeepEqual(entry.Files, []string{"Label_1/m1.eml", "Label_2/m2.eml"}) {
+		t.Errorf("Unexpected Label_2 entry: %+v", entry)
+	}
+}
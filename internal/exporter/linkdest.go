@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loadLinkDest loads the emails exported into the LinkDest directory, keyed
+// by message ID
+func (e *Exporter) loadLinkDest() (map[string]ProcessedEmail, error) {
+	processedEmails, err := readProcessedEmails(filepath.Join(e.config.LinkDest, ProcessedEmailsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	linkable := make(map[string]ProcessedEmail, len(processedEmails))
+	for _, email := range processedEmails {
+		linkable[email.ID] = email
+	}
+	return linkable, nil
+}
+
+// linkPrevious hardlinks the files of the emails LinkDest already holds into
+// the output directory, recording them as processed, and returns the message
+// IDs that still have to be downloaded and the number of emails linked
+func (e *Exporter) linkPrevious(messageIDs []string) (remaining []string, linked int) {
+	if len(e.linkable) == 0 {
+		return messageIDs, 0
+	}
+
+	remaining = make([]string, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		email, ok := e.linkable[messageID]
+		if !ok {
+			remaining = append(remaining, messageID)
+			continue
+		}
+		if err := e.linkEmail(email); err != nil {
+			logrus.WithError(err).WithField("message_id", messageID).Debug("Cannot link email from the previous snapshot, downloading it")
+			remaining = append(remaining, messageID)
+			continue
+		}
+		e.processed = append(e.processed, email)
+		linked++
+	}
+
+	if linked > 0 {
+		logrus.WithFields(logrus.Fields{
+			"linked":    linked,
+			"remaining": len(remaining),
+		}).Info("Linked emails unchanged since the previous snapshot")
+	}
+	return remaining, linked
+}
+
+// linkEmail hardlinks the export file of email, and its label directory
+// copies, from LinkDest into the output directory. Nothing is left behind
+// when it fails.
+func (e *Exporter) linkEmail(email ProcessedEmail) error {
+	if email.File == "" || filepath.Ext(email.File) != "."+e.config.Format {
+		return fmt.Errorf("previous snapshot has no %s file for the email", e.config.Format)
+	}
+
+	source := filepath.Join(e.config.LinkDest, filepath.FromSlash(email.File))
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.Size() != email.Size {
+		return fmt.Errorf("previous snapshot file %s is incomplete", email.File)
+	}
+
+	files := append([]string{email.File}, email.Copies...)
+	for i, file := range files {
+		target := filepath.Join(e.config.OutputDir, filepath.FromSlash(file))
+		err := os.MkdirAll(filepath.Dir(target), 0o750)
+		if err == nil {
+			err = os.Link(filepath.Join(e.config.LinkDest, filepath.FromSlash(file)), target)
+		}
+		if err != nil {
+			for _, linked := range files[:i] {
+				_ = os.Remove(filepath.Join(e.config.OutputDir, filepath.FromSlash(linked)))
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkPrevious(t *testing.T) {
+	previous := t.TempDir()
+	output := t.TempDir()
+
+	// The previous snapshot holds m1 with a copy in a label directory, a
+	// truncated m2 and m3 in another format
+	files := map[string]string{
+		"INBOX/m1.eml": "Subject: one\r\n\r\nbody\r\n",
+		"Work/m1.eml":  "Subject: one\r\n\r\nbody\r\n",
+		"INBOX/m2.eml": "Subject: tw",
+		"INBOX/m3.txt": "three",
+	}
+	for name, content := range files {
+		path := filepath.Join(previous, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processed := []ProcessedEmail{
+		{ID: "m1", File: "INBOX/m1.eml", Copies: []string{"Work/m1.eml"}, Size: int64(len(files["INBOX/m1.eml"])), Subject: "one"},
+		{ID: "m2", File: "INBOX/m2.eml", Size: 100},
+		{ID: "m3", File: "INBOX/m3.txt", Size: 5},
+	}
+	data, err := json.Marshal(processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(previous, "processed_emails.json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Exporter{config: &Config{OutputDir: output, LinkDest: previous, Format: "eml"}}
+	if e.linkable, err = e.loadLinkDest(); err != nil {
+		t.Fatalf("loadLinkDest() error = %v", err)
+	}
+
+	remaining, linked := e.linkPrevious([]string{"m1", "m2", "m3", "m4"})
+	if linked != 1 || len(remaining) != 3 || remaining[0] != "m2" {
+		t.Errorf("linkPrevious() = %v, %d, want m2 m3 m4 left and 1 linked", remaining, linked)
+	}
+	if len(e.processed) != 1 || e.processed[0].ID != "m1" || e.processed[0].Subject != "one" {
+		t.Errorf("Expected the record of m1 to be carried over, got %+v", e.processed)
+	}
+
+	for _, name := range []string{"INBOX/m1.eml", "Work/m1.eml"} {
+		source, err := os.Stat(filepath.Join(previous, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, err := os.Stat(filepath.Join(output, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("Expected %s to be linked: %v", name, err)
+		}
+		if !os.SameFile(source, target) {
+			t.Errorf("Expected %s to be a hardlink of the previous snapshot's file", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(output, "INBOX", "m2.eml")); !os.IsNotExist(err) {
+		t.Error("Expected the truncated file not to be linked")
+	}
+}
@@ -0,0 +1,144 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+// defaultListConcurrency is the number of shards listed at once when
+// ListConcurrency is not set
+const defaultListConcurrency = 4
+
+// listShardFilters splits filterConfig into one filter per date shard,
+// newest first so the merged IDs keep Gmail's newest-first order
+func listShardFilters(filterConfig *filters.Config, shardBy string, now time.Time) []*filters.Config {
+	start, end := dateRangeFor(filterConfig, now)
+	windows := splitDateRange(start, end, shardBy)
+
+	shards := make([]*filters.Config, 0, len(windows))
+	for i := len(windows) - 1; i >= 0; i-- {
+		shards = append(shards, windowFilter(filterConfig, windows[i]))
+	}
+	return shards
+}
+
+// listShards lists every shard with list, at most concurrency at a time,
+// and merges their IDs in shard order without duplicates. Messages dated on
+// a shard boundary can match two shards.
+func listShards(shards []*filters.Config, concurrency int, list func(*filters.Config) ([]string, error)) ([]string, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([][]string, len(shards))
+	jobs := make(chan int, len(shards))
+	for i := range shards {
+		jobs <- i
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(shards); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					continue
+				}
+
+				ids, err := list(shards[index])
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				results[index] = ids
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	seen := make(map[string]bool)
+	var messageIDs []string
+	for _, ids := range results {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				messageIDs = append(messageIDs, id)
+			}
+		}
+	}
+	return messageIDs, nil
+}
+
+// listSharded lists the IDs of the emails matching filterConfig as
+// concurrent date shards, which is much faster than paging through a single
+// query for mailboxes with millions of messages
+func (e *Exporter) listSharded(filterConfig *filters.Config) ([]string, error) {
+	concurrency := e.config.ListConcurrency
+	if concurrency == 0 {
+		concurrency = defaultListConcurrency
+	}
+	shards := listShardFilters(filterConfig, e.config.ListShards, time.Now())
+
+	logrus.WithFields(logrus.Fields{
+		"shards":      len(shards),
+		"shard_by":    e.config.ListShards,
+		"concurrency": concurrency,
+	}).Info("Listing emails in shards")
+
+	return listShards(shards, concurrency, func(shard *filters.Config) ([]string, error) {
+		ids, err := e.listMessageIDs(shard)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s..%s: %w",
+				shard.DateAfter.Format("2006-01-02"), shard.DateBefore.Format("2006-01-02"), err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"after":  shard.DateAfter.Format("2006-01-02"),
+			"before": shard.DateBefore.Format("2006-01-02"),
+			"count":  len(ids),
+		}).Debug("Listed shard")
+		return ids, nil
+	})
+}
+
+// sampleIDs returns n of ids picked uniformly at random by intN, which
+// returns a number in [0, k), keeping the listing order of the picked IDs
+func sampleIDs(ids []string, n int, intN func(k int) int) []string {
+	if n >= len(ids) {
+		return ids
+	}
+
+	// A partial Fisher-Yates shuffle of the indexes picks n of them
+	indexes := make([]int, len(ids))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	for i := 0; i < n; i++ {
+		j := i + intN(len(indexes)-i)
+		indexes[i], indexes[j] = indexes[j], indexes[i]
+	}
+	picked := indexes[:n]
+	sort.Ints(picked)
+
+	sampled := make([]string, n)
+	for i, index := range picked {
+		sampled[i] = ids[index]
+	}
+	return sampled
+}
@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+func TestListShardFilters(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	filterConfig := &filters.Config{From: "billing@example.com", DateAfter: &after, DateBefore: &before}
+
+	shards := listShardFilters(filterConfig, SplitByMonth, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if len(shards) != 3 {
+		t.Fatalf("Expected 3 monthly shards, got %d", len(shards))
+	}
+	if !shards[0].DateAfter.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || !shards[2].DateBefore.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the newest shard first, got %v..%v first", shards[0].DateAfter, shards[0].DateBefore)
+	}
+	if shards[1].From != "billing@example.com" {
+		t.Errorf("Expected shards to keep the other criteria, got %+v", shards[1])
+	}
+}
+
+func TestListShards(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	var shards []*filters.Config
+	for d := 3; d > 0; d-- {
+		start, end := day(d), day(d+1)
+		shards = append(shards, &filters.Config{DateAfter: &start, DateBefore: &end})
+	}
+	ids := map[int][]string{3: {"c2", "c1"}, 2: {"b1", "c1"}, 1: {"a1"}}
+
+	var running, peak int32
+	got, err := listShards(shards, 2, func(shard *filters.Config) ([]string, error) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return ids[shard.DateAfter.Day()], nil
+	})
+	if err != nil {
+		t.Fatalf("listShards() error = %v", err)
+	}
+	if want := "c2,c1,b1,a1"; strings.Join(got, ",") != want {
+		t.Errorf("listShards() = %v, want %s", got, want)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 shards listed at once, got %d", peak)
+	}
+
+	_, err = listShards(shards, 2, func(shard *filters.Config) ([]string, error) {
+		return nil, fmt.Errorf("quota exceeded")
+	})
+	if err == nil {
+		t.Error("Expected an error when a shard fails")
+	}
+}
+
+func TestValidateConfig_ListShards(t *testing.T) {
+	config := &Config{CredentialsFile: "c", TokenFile: "t", OutputDir: "out", ListShards: "decade"}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected an error for an invalid shard size")
+	}
+	config.ListShards = SplitByMonth
+	if err := validateConfig(config); err != nil {
+		t.Errorf("validateConfig() error = %v", err)
+	}
+}
+
+func TestSampleIDs(t *testing.T) {
+	ids := []string{"m1", "m2", "m3", "m4", "m5", "m6"}
+
+	// Always picking the last remaining index takes m6, then m1 and m2 as
+	// each pick swaps them to the end
+	last := func(k int) int { return k - 1 }
+	if got := strings.Join(sampleIDs(ids, 3, last), ","); got != "m1,m2,m6" {
+		t.Errorf("sampleIDs() = %s, want m1,m2,m6 in listing order", got)
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range sampleIDs(ids, 4, rand.IntN) {
+		if seen[id] {
+			t.Errorf("sampleIDs() picked %s twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected 4 sampled IDs, got %d", len(seen))
+	}
+
+	if got := sampleIDs(ids, 10, rand.IntN); len(got) != len(ids) {
+		t.Errorf("Expected all IDs when the sample is larger, got %v", got)
+	}
+}
+
+func TestValidateConfig_Sample(t *testing.T) {
+	config := &Config{CredentialsFile: "c", TokenFile: "t", OutputDir: "out", Sample: 100}
+	if err := validateConfig(config); err != nil {
+		t.Errorf("validateConfig() error = %v", err)
+	}
+	config.Limit = 10
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected an error for a sample with a limit")
+	}
+}
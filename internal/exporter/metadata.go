@@ -0,0 +1,254 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+)
+
+// FormatMetadata exports only the headers, labels and size of each message,
+// fetched without their bodies, into a single JSON or CSV file
+const FormatMetadata = "metadata"
+
+// Metadata format output file formats
+const (
+	MetadataFormatJSON = "json"
+	MetadataFormatCSV  = "csv"
+)
+
+// MetadataFileName is the name, without extension, of the file the metadata
+// format writes into the output directory
+const MetadataFileName = "messages"
+
+// metadataColumns are the CSV columns of the metadata format, followed by
+// a column for each additional header
+var metadataColumns = []string{"id", "thread_id", "date", "from", "to", "cc", "subject", "message_id", "labels", "size"}
+
+// defaultMetadataHeaders are the headers the metadata format fetches unless
+// Headers is set; they fill the fixed fields of the records
+var defaultMetadataHeaders = []string{"Date", "From", "To", "Cc", "Subject", "Message-ID"}
+
+// MetadataRecord is the record of one message written by the metadata format
+type MetadataRecord struct {
+	ID        string    `json:"id"`
+	ThreadID  string    `json:"thread_id,omitempty"`
+	Date      time.Time `json:"date,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+	Cc        string    `json:"cc,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	Labels    []string  `json:"labels,omitempty"`
+	Size      int64     `json:"size"`
+
+	// Headers holds the requested headers beyond the fields above
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// validateMetadataFormat checks the options of the metadata format, which
+// writes no file per message
+func validateMetadataFormat(config *Config) error {
+	if config.Format != FormatMetadata {
+		if config.MetadataFormat != "" {
+			return fmt.Errorf("metadata output format requires the metadata format")
+		}
+		if len(config.Headers) > 0 {
+			return fmt.Errorf("selecting headers requires the metadata format")
+		}
+		return nil
+	}
+	for _, header := range config.Headers {
+		if header == "" || strings.ContainsAny(header, ": \t") {
+			return fmt.Errorf("invalid header name: %q", header)
+		}
+	}
+	if config.MetadataFormat == "" {
+		config.MetadataFormat = MetadataFormatJSON
+	}
+	if config.MetadataFormat != MetadataFormatJSON && config.MetadataFormat != MetadataFormatCSV {
+		return fmt.Errorf("invalid metadata output format: %s (valid: json, csv)", config.MetadataFormat)
+	}
+	if config.OrganizeByLabels {
+		return fmt.Errorf("the metadata format cannot be organized by labels")
+	}
+	if config.LegalHold {
+		return fmt.Errorf("legal hold requires message files; the metadata format writes none")
+	}
+	return nil
+}
+
+// exportMetadataOnly records a message fetched in metadata format; the
+// metadata cache keeps its headers until saveMetadataExport writes them
+func (e *Exporter) exportMetadataOnly(message *gmail.Message) (exportedFile, *cache.Metadata, error) {
+	// Gmail's size estimate is the only size without downloading the message
+	if err := e.checkExactSize(message.SizeEstimate); err != nil {
+		return exportedFile{}, nil, err
+	}
+
+	metadata := cache.FromMessage(message)
+	metadata.Headers = e.additionalHeaders(message)
+	e.redactMetadata(&metadata)
+	file := exportedFile{
+		Size:  message.SizeEstimate,
+		State: messageState(message.LabelIds),
+	}
+	return file, &metadata, nil
+}
+
+// metadataHeaders returns the headers the metadata format fetches
+func (e *Exporter) metadataHeaders() []string {
+	if len(e.config.Headers) > 0 {
+		return e.config.Headers
+	}
+	return defaultMetadataHeaders
+}
+
+// additionalHeaders returns the first value of each requested header that
+// has no field of its own in the records
+func (e *Exporter) additionalHeaders(message *gmail.Message) map[string]string {
+	if message.Payload == nil {
+		return nil
+	}
+
+	var values map[string]string
+	for _, name := range e.extraHeaderNames() {
+		for _, header := range message.Payload.Headers {
+			if strings.EqualFold(header.Name, name) {
+				if values == nil {
+					values = make(map[string]string)
+				}
+				values[name] = header.Value
+				break
+			}
+		}
+	}
+	return values
+}
+
+// extraHeaderNames returns the requested headers that have no field of
+// their own in the records, in the order requested
+func (e *Exporter) extraHeaderNames() []string {
+	var names []string
+	for _, name := range e.config.Headers {
+		fixed := false
+		for _, header := range defaultMetadataHeaders {
+			if strings.EqualFold(name, header) {
+				fixed = true
+				break
+			}
+		}
+		if !fixed {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// saveMetadataExport writes the records of the processed emails, oldest
+// first, to the metadata format's output file
+func (e *Exporter) saveMetadataExport(processedEmails []ProcessedEmail) error {
+	names, err := e.labelCache.Names()
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to list label names, writing label IDs")
+	}
+
+	records := make([]MetadataRecord, 0, len(processedEmails))
+	for _, email := range processedEmails {
+		metadata, err := e.cache.Get(email.ID)
+		if err != nil {
+			return err
+		}
+		if metadata == nil {
+			metadata = &cache.Metadata{ID: email.ID, Subject: email.Subject, From: email.From, Date: email.Date, Size: email.Size}
+		}
+		records = append(records, newMetadataRecord(*metadata, names))
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Date.Before(records[j].Date) })
+
+	path := filepath.Join(e.config.OutputDir, MetadataFileName+"."+e.config.MetadataFormat)
+	err = atomicfile.Write(path, 0o600, atomicfile.Options{Sync: e.config.Fsync}, func(w io.Writer) error {
+		if e.config.MetadataFormat == MetadataFormatCSV {
+			return writeMetadataCSV(w, records, e.extraHeaderNames())
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file":  path,
+		"count": len(records),
+	}).Info("Saved message metadata")
+	return nil
+}
+
+// newMetadataRecord builds the record of a cached message, naming its labels
+// after names when known
+func newMetadataRecord(metadata cache.Metadata, names map[string]string) MetadataRecord {
+	record := MetadataRecord{
+		ID:        metadata.ID,
+		ThreadID:  metadata.ThreadID,
+		Date:      metadata.Date,
+		From:      metadata.From,
+		To:        metadata.To,
+		Cc:        metadata.Cc,
+		Subject:   metadata.Subject,
+		MessageID: metadata.MessageID,
+		Size:      metadata.Size,
+		Headers:   metadata.Headers,
+	}
+	for _, label := range metadata.Labels {
+		if name, ok := names[label]; ok {
+			label = name
+		}
+		record.Labels = append(record.Labels, label)
+	}
+	return record
+}
+
+// writeMetadataCSV writes records as CSV with a header row, labels joined by
+// semicolons and a column for each of the additional headers
+func writeMetadataCSV(w io.Writer, records []MetadataRecord, headers []string) error {
+	writer := csv.NewWriter(w)
+	columns := append([]string(nil), metadataColumns...)
+	for _, header := range headers {
+		columns = append(columns, strings.ToLower(header))
+	}
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		var date string
+		if !record.Date.IsZero() {
+			date = record.Date.UTC().Format(time.RFC3339)
+		}
+		row := []string{
+			record.ID, record.ThreadID, date, record.From, record.To, record.Cc,
+			record.Subject, record.MessageID, strings.Join(record.Labels, ";"),
+			strconv.FormatInt(record.Size, 10),
+		}
+		for _, header := range headers {
+			row = append(row, record.Headers[header])
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,181 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+)
+
+// metadataExporter returns an exporter in the metadata format writing into
+// a temporary directory, with its metadata cache open
+func metadataExporter(t *testing.T, metadataFormat string) *Exporter {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := cache.Open(filepath.Join(dir, cache.DefaultFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	listLabels := func() ([]*gmail.Label, error) {
+		return []*gmail.Label{{Id: "INBOX", Name: "INBOX"}, {Id: "Label_7", Name: "Invoices"}}, nil
+	}
+	return &Exporter{
+		config:     &Config{OutputDir: dir, Format: FormatMetadata, MetadataFormat: metadataFormat},
+		cache:      store,
+		labelCache: labels.New(listLabels, nil, 0),
+	}
+}
+
+// exportMetadata records messages as the export workers and collector do
+func exportMetadata(t *testing.T, e *Exporter, messages ...*gmail.Message) {
+	t.Helper()
+	for _, message := range messages {
+		file, metadata, err := e.exportMetadataOnly(message)
+		if err != nil {
+			t.Fatalf("exportMetadataOnly() error = %v", err)
+		}
+		if file.Path != "" || file.Size != message.SizeEstimate {
+			t.Errorf("Expected no file and the size estimate, got %+v", file)
+		}
+		if err := e.cache.Put(*metadata); err != nil {
+			t.Fatal(err)
+		}
+		e.processed = append(e.processed, ProcessedEmail{ID: message.Id, Size: file.Size})
+	}
+	if err := e.saveMetadataExport(e.processed); err != nil {
+		t.Fatalf("saveMetadataExport() error = %v", err)
+	}
+}
+
+func metadataMessage(id string, date time.Time, subject string, labelIDs ...string) *gmail.Message {
+	return &gmail.Message{
+		Id:           id,
+		ThreadId:     "t-" + id,
+		LabelIds:     labelIDs,
+		SizeEstimate: 2048,
+		InternalDate: date.UnixMilli(),
+		Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "From", Value: "Billing <billing@example.com>"},
+			{Name: "To", Value: "user@example.com"},
+			{Name: "Cc", Value: "audit@example.com"},
+			{Name: "Subject", Value: subject},
+			{Name: "Message-ID", Value: "<" + id + "@example.com>"},
+		}},
+	}
+}
+
+func TestSaveMetadataExport_JSON(t *testing.T) {
+	e := metadataExporter(t, MetadataFormatJSON)
+	exportMetadata(t, e,
+		metadataMessage("m2", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC), "February invoice", "INBOX", "Label_7"),
+		metadataMessage("m1", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), "January invoice", "Label_7"),
+	)
+
+	data, err := os.ReadFile(filepath.Join(e.config.OutputDir, "messages.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []MetadataRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("Failed to parse messages.json: %v", err)
+	}
+	if len(records) != 2 || records[0].ID != "m1" {
+		t.Fatalf("Expected both records, oldest first, got %+v", records)
+	}
+	record := records[1]
+	if record.Cc != "audit@example.com" || record.MessageID != "<m2@example.com>" || record.ThreadID != "t-m2" || record.Size != 2048 {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if strings.Join(record.Labels, ",") != "INBOX,Invoices" {
+		t.Errorf("Expected label names, got %v", record.Labels)
+	}
+}
+
+func TestSaveMetadataExport_CSV(t *testing.T) {
+	e := metadataExporter(t, MetadataFormatCSV)
+	exportMetadata(t, e, metadataMessage("m1", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), "Invoice, January", "INBOX", "Label_7"))
+
+	file, err := os.Open(filepath.Join(e.config.OutputDir, "messages.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse messages.csv: %v", err)
+	}
+
+	want := []string{"m1", "t-m1", "2024-01-01T09:00:00Z", "Billing <billing@example.com>", "user@example.com",
+		"audit@example.com", "Invoice, January", "<m1@example.com>", "INBOX;Invoices", "2048"}
+	if len(rows) != 2 || strings.Join(rows[0], ",") != strings.Join(metadataColumns, ",") {
+		t.Fatalf("Expected a header and one row, got %v", rows)
+	}
+	if strings.Join(rows[1], "|") != strings.Join(want, "|") {
+		t.Errorf("CSV row = %v, want %v", rows[1], want)
+	}
+}
+
+func TestSaveMetadataExport_Headers(t *testing.T) {
+	e := metadataExporter(t, MetadataFormatCSV)
+	e.config.Headers = []string{"From", "Subject", "List-Id"}
+	if got := strings.Join(e.metadataHeaders(), ","); got != "From,Subject,List-Id" {
+		t.Errorf("metadataHeaders() = %s, want only the selected headers", got)
+	}
+
+	message := metadataMessage("m1", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), "Weekly digest")
+	message.Payload.Headers = append(message.Payload.Headers, &gmail.MessagePartHeader{Name: "list-id", Value: "<digest.example.com>"})
+	exportMetadata(t, e, message)
+
+	file, err := os.Open(filepath.Join(e.config.OutputDir, "messages.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse messages.csv: %v", err)
+	}
+	if len(rows) != 2 || rows[0][len(rows[0])-1] != "list-id" {
+		t.Fatalf("Expected a list-id column, got %v", rows)
+	}
+	if got := rows[1][len(rows[1])-1]; got != "<digest.example.com>" {
+		t.Errorf("list-id = %q, want <digest.example.com>", got)
+	}
+}
+
+func TestValidateMetadataFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"default output format", Config{Format: FormatMetadata}, false},
+		{"csv", Config{Format: FormatMetadata, MetadataFormat: "csv"}, false},
+		{"unknown output format", Config{Format: FormatMetadata, MetadataFormat: "xml"}, true},
+		{"output format without metadata", Config{Format: "eml", MetadataFormat: "csv"}, true},
+		{"organized by labels", Config{Format: FormatMetadata, OrganizeByLabels: true}, true},
+		{"legal hold", Config{Format: FormatMetadata, LegalHold: true}, true},
+		{"headers", Config{Format: FormatMetadata, Headers: []string{"From", "List-Id"}}, false},
+		{"invalid header", Config{Format: FormatMetadata, Headers: []string{"List-Id:"}}, true},
+		{"headers without metadata", Config{Format: "eml", Headers: []string{"From"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetadataFormat(&tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMetadataFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/mockgmail"
+)
+
+func TestExport_MockServer(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		maxInMemorySize int64
+	}{
+		{"in memory", 0},
+		{"streamed", 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testExportMockServer(t, tt.maxInMemorySize)
+		})
+	}
+}
+
+// testExportMockServer exports a message from a mock mailbox, decoding
+// messages larger than maxInMemorySize as they are downloaded
+func testExportMockServer(t *testing.T, maxInMemorySize int64) {
+	mailbox := mockgmail.New("")
+	invoice, err := mailbox.AddMessage([]byte("From: billing@example.com\r\nSubject: Invoice\r\n"+
+		"Date: Mon, 01 Jan 2024 10:00:00 +0000\r\n\r\nAmount due: 10 EUR\r\n"), "INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mailbox.AddMessage([]byte("From: friend@example.com\r\nSubject: Lunch?\r\n\r\nNoon?\r\n"), "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+	if err := auth.SetAPIEndpoint(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auth.SetAPIEndpoint("") })
+	t.Setenv(auth.TokenEnvVar, mockgmail.TokenJSON)
+
+	dir := t.TempDir()
+	exp, err := New(&Config{
+		CredentialsFile: filepath.Join(dir, "credentials.json"),
+		TokenFile:       filepath.Join(dir, "token.json"),
+		OutputDir:       filepath.Join(dir, "out"),
+		Format:          "eml",
+		ParallelWorkers: 2,
+		MaxInMemorySize: maxInMemorySize,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := exp.Export(&filters.Config{From: "billing@example.com"})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if result.TotalMatched != 1 || result.TotalExported != 1 {
+		t.Fatalf("Export() = %d matched, %d exported, want 1 and 1", result.TotalMatched, result.TotalExported)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", invoice+".eml"))
+	if err != nil {
+		t.Fatalf("Expected the invoice to be exported: %v", err)
+	}
+	// The invoice is the older of the two messages
+	if string(data) != string(mailbox.Messages()[1].Raw) {
+		t.Errorf("Exported %q, want the raw message", data)
+	}
+}
+
+func TestExportRawStreamed_RawChecksum(t *testing.T) {
+	mailbox := mockgmail.New("")
+	raw := []byte("From: billing@example.com\r\nSubject: Invoice\r\n\r\nAmount due: 10 EUR\r\n")
+	id, err := mailbox.AddMessage(raw, "INBOX", "UNREAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+	if err := auth.SetAPIEndpoint(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auth.SetAPIEndpoint("") })
+	t.Setenv(auth.TokenEnvVar, mockgmail.TokenJSON)
+
+	dir := t.TempDir()
+	exp, err := New(&Config{
+		CredentialsFile:   filepath.Join(dir, "credentials.json"),
+		TokenFile:         filepath.Join(dir, "token.json"),
+		OutputDir:         filepath.Join(dir, "out"),
+		Format:            "eml",
+		GmailLabelsHeader: true,
+		MaxInMemorySize:   1,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	exp.custody = &custodyState{}
+
+	message := &gmail.Message{Id: id, LabelIds: []string{"INBOX", "UNREAD"}, SizeEstimate: int64(len(raw))}
+	path := filepath.Join(dir, id+".eml")
+	file, err := exp.exportRawStreamed(message, path)
+	if err != nil {
+		t.Fatalf("exportRawStreamed() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := exp.takeoutHeaders(message)
+	if len(header) == 0 || string(data) != string(header)+string(raw) {
+		t.Fatalf("Exported %q, want the takeout header and the raw message", data)
+	}
+	// The checksum is that of the message as held by Gmail, not of the file
+	if file.RawSHA256 != sha256Hex(raw) {
+		t.Errorf("RawSHA256 = %q, want the checksum of the raw message %q", file.RawSHA256, sha256Hex(raw))
+	}
+	if file.SHA256 != sha256Hex(data) {
+		t.Errorf("SHA256 = %q, want the checksum of the file", file.SHA256)
+	}
+}
+
+func TestExportByWindows_LimitLeavesWindowPending(t *testing.T) {
+	mailbox := mockgmail.New("")
+	for _, day := range []string{"01", "02", "03"} {
+		raw := "From: billing@example.com\r\nSubject: Invoice " + day + "\r\n" +
+			"Date: Mon, " + day + " Jan 2024 10:00:00 +0000\r\n\r\nAmount due\r\n"
+		if _, err := mailbox.AddMessage([]byte(raw), "INBOX"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+	if err := auth.SetAPIEndpoint(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auth.SetAPIEndpoint("") })
+	t.Setenv(auth.TokenEnvVar, mockgmail.TokenJSON)
+
+	dir := t.TempDir()
+	export := func(limit int, resume bool) *Result {
+		t.Helper()
+		exp, err := New(&Config{
+			CredentialsFile: filepath.Join(dir, "credentials.json"),
+			TokenFile:       filepath.Join(dir, "token.json"),
+			OutputDir:       filepath.Join(dir, "out"),
+			Format:          "eml",
+			ParallelWorkers: 1,
+			SplitBy:         SplitByYear,
+			Limit:           limit,
+			Resume:          resume,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		after := date(2023, 6, 1)
+		result, err := exp.Export(&filters.Config{From: "billing@example.com", DateAfter: &after})
+		if err != nil {
+			t.Fatalf("Export() error = %v", err)
+		}
+		return result
+	}
+
+	// The limit stops the export inside the 2024 window
+	if result := export(2, false); result.TotalExported != 2 {
+		t.Fatalf("Exported %d, want the limit of 2", result.TotalExported)
+	}
+	state, err := loadExportState(filepath.Join(dir, "out", ".export_state.json"))
+	if err != nil || state == nil {
+		t.Fatalf("loadExportState() = %v, %v", state, err)
+	}
+	for _, window := range state.Windows {
+		if window.Start.Year() == 2024 && window.Status != windowPending {
+			t.Errorf("Expected the window cut short by the limit to stay pending, got %s", window.Status)
+		}
+	}
+
+	// so a resumed run exports the rest of it
+	if result := export(0, true); result.TotalExported != 1 {
+		t.Errorf("Resumed run exported %d, want the remaining message", result.TotalExported)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eml int
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".eml" {
+			eml++
+		}
+	}
+	if eml != 3 {
+		t.Errorf("Expected all 3 messages to be exported, got %d", eml)
+	}
+}
@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// NotmuchTagsFileName is the notmuch tag batch file written to the output
+// directory, applied with "notmuch tag --batch --input=notmuch_tags.txt"
+// once "notmuch new" has indexed the export
+const NotmuchTagsFileName = "notmuch_tags.txt"
+
+// notmuchSystemTags maps system labels to the tags notmuch and its mail
+// clients use for them
+var notmuchSystemTags = map[string]string{
+	"INBOX":     "inbox",
+	"UNREAD":    "unread",
+	"STARRED":   "flagged",
+	"IMPORTANT": "important",
+	"SENT":      "sent",
+	"DRAFT":     "draft",
+	"SPAM":      "spam",
+	"TRASH":     "deleted",
+}
+
+// notmuchNewTags are the tags "notmuch new" adds by default, removed from
+// messages whose labels do not call for them
+var notmuchNewTags = []string{"inbox", "unread"}
+
+// notmuchTags returns the tag operations for a message with the given
+// labels. Categories become category/NAME tags and user labels keep their
+// names.
+func notmuchTags(labelIDs []string, names map[string]string) []string {
+	var tags []string
+	for _, id := range labelIDs {
+		if id == "CHAT" {
+			continue
+		}
+		tag, ok := notmuchSystemTags[id]
+		if !ok && strings.HasPrefix(id, "CATEGORY_") {
+			tag = "category/" + strings.ToLower(strings.TrimPrefix(id, "CATEGORY_"))
+		} else if !ok {
+			if tag = names[id]; tag == "" {
+				tag = id
+			}
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	operations := make([]string, 0, len(tags)+len(notmuchNewTags))
+	for _, tag := range notmuchNewTags {
+		if !slices.Contains(tags, tag) {
+			operations = append(operations, "-"+notmuchEncode(tag))
+		}
+	}
+	for _, tag := range tags {
+		operations = append(operations, "+"+notmuchEncode(tag))
+	}
+	return operations
+}
+
+// notmuchEncode hex-encodes a tag or search term for a notmuch batch file,
+// which leaves only letters, digits and +-_@=.,: unencoded
+func notmuchEncode(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("+-_@=.,:", c) >= 0 {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02x", c)
+		}
+	}
+	return builder.String()
+}
+
+// messageIDHeader returns the Message-ID of an exported eml file, without
+// its angle brackets
+func messageIDHeader(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	message, err := mail.ReadMessage(bufio.NewReader(file))
+	if err != nil {
+		return "", err
+	}
+	id := strings.Trim(strings.TrimSpace(message.Header.Get("Message-ID")), "<>")
+	if id == "" {
+		return "", fmt.Errorf("no Message-ID header")
+	}
+	return id, nil
+}
+
+// saveNotmuchTags writes a notmuch tag batch file giving every exported
+// message the tags of its Gmail labels. Notmuch finds messages by their
+// Message-ID, so messages without one are left out with a warning.
+func (e *Exporter) saveNotmuchTags(processedEmails []ProcessedEmail) error {
+	names, err := e.labelNames()
+	if err != nil {
+		return err
+	}
+	messages, err := e.exportedMessages(processedEmails)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(e.config.OutputDir, NotmuchTagsFileName)
+	tagged := 0
+	err = atomicfile.Write(path, 0o600, atomicfile.Options{Sync: e.config.Fsync}, func(w io.Writer) error {
+		buffered := bufio.NewWriter(w)
+		for _, message := range messages {
+			id, err := messageIDHeader(filepath.Join(e.config.OutputDir, filepath.FromSlash(message.File)))
+			if err != nil {
+				logrus.WithError(err).WithField("message_id", message.ID).Warn("Failed to read Message-ID for notmuch tags")
+				continue
+			}
+			fmt.Fprintf(buffered, "%s -- %s\n", strings.Join(notmuchTags(message.Labels, names), " "), notmuchEncode("id:"+id))
+			tagged++
+		}
+		return buffered.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write notmuch tags: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"messages": tagged,
+		"file":     path,
+	}).Info("Saved notmuch tags")
+	return nil
+}
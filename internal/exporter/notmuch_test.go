@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+)
+
+func TestNotmuchTags(t *testing.T) {
+	names := map[string]string{"Label_1": "Clients/Acme", "Label_2": "To do"}
+
+	tests := []struct {
+		name     string
+		labelIDs []string
+		want     []string
+	}{
+		{
+			name:     "inbox unread",
+			labelIDs: []string{"INBOX", "UNREAD", "CATEGORY_UPDATES"},
+			want:     []string{"+inbox", "+unread", "+category%2fupdates"},
+		},
+		{
+			name:     "archived and read",
+			labelIDs: []string{"STARRED", "Label_1", "Label_2", "CHAT"},
+			want:     []string{"-inbox", "-unread", "+flagged", "+Clients%2fAcme", "+To%20do"},
+		},
+		{
+			name:     "no labels",
+			labelIDs: nil,
+			want:     []string{"-inbox", "-unread"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notmuchTags(tt.labelIDs, names); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notmuchTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveNotmuchTags(t *testing.T) {
+	dir := t.TempDir()
+	messages := map[string]string{
+		"m1.eml": "Message-ID: <CAB+1@mail.gmail.com>\r\nSubject: one\r\n\r\nbody\r\n",
+		"m2.eml": "Subject: no id\r\n\r\nbody\r\n",
+	}
+	for name, raw := range messages {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(raw), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := &Exporter{config: &Config{OutputDir: dir, NotmuchTags: true}, labels: &labelSelector{}}
+	e.labelCache = labels.Static(nil)
+
+	processed := []ProcessedEmail{
+		{ID: "m1", File: "m1.eml", Labels: []string{"INBOX"}},
+		{ID: "m2", File: "m2.eml"},
+	}
+	if err := e.saveNotmuchTags(processed); err != nil {
+		t.Fatalf("saveNotmuchTags() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, NotmuchTagsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-unread +inbox -- id:CAB+1@mail.gmail.com\n"
+	if string(data) != want {
+		t.Errorf("notmuch tags = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Orders in which the matching emails are exported
+const (
+	OrderNewestFirst  = "newest-first"
+	OrderOldestFirst  = "oldest-first"
+	OrderLargestFirst = "largest-first"
+)
+
+var validOrders = []string{OrderNewestFirst, OrderOldestFirst, OrderLargestFirst}
+
+// isValidOrder reports whether order is a supported export order
+func isValidOrder(order string) bool {
+	return slices.Contains(validOrders, order)
+}
+
+// orderMessages returns the listed message IDs, newest first, in the order
+// of Order. Largest first looks up the size of each message the metadata
+// cache does not hold.
+func (e *Exporter) orderMessages(messageIDs []string) []string {
+	switch e.config.Order {
+	case OrderOldestFirst:
+		ordered := slices.Clone(messageIDs)
+		slices.Reverse(ordered)
+		return ordered
+	case OrderLargestFirst:
+		return largestFirst(messageIDs, e.messageSizes(messageIDs))
+	default:
+		return messageIDs
+	}
+}
+
+// largestFirst returns messageIDs sorted by size, largest first, keeping the
+// listing order of messages of the same size
+func largestFirst(messageIDs []string, sizes map[string]int64) []string {
+	ordered := slices.Clone(messageIDs)
+	sort.SliceStable(ordered, func(i, j int) bool { return sizes[ordered[i]] > sizes[ordered[j]] })
+	return ordered
+}
+
+// messageSizes returns the size of each message, from the metadata cache or
+// fetched with one minimal messages.get call per message. Messages whose
+// size cannot be looked up get -1, so they are exported last.
+func (e *Exporter) messageSizes(messageIDs []string) map[string]int64 {
+	sizes := make(map[string]int64, len(messageIDs))
+	var missing []string
+	for _, id := range messageIDs {
+		if e.cache != nil {
+			if metadata, err := e.cache.Get(id); err == nil && metadata != nil {
+				sizes[id] = metadata.Size
+				continue
+			}
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return sizes
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"cached":  len(sizes),
+		"missing": len(missing),
+	}).Info("Looking up message sizes to export the largest first")
+
+	jobs := make(chan string, len(missing))
+	for _, id := range missing {
+		jobs <- id
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	var failed []string
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < max(e.config.ParallelWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				var message *gmail.Message
+				err := e.callAPI("messages.get", func(service *gmail.Service) error {
+					var callErr error
+					message, callErr = service.Users.Messages.Get("me", id).Format("minimal").
+						Fields("id", "sizeEstimate").Do()
+					return callErr
+				})
+
+				mu.Lock()
+				if err != nil {
+					failed = append(failed, id)
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					sizes[id] = message.SizeEstimate
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		logrus.WithError(firstErr).WithField("messages", len(failed)).Warn("Failed to look up the size of some messages, exporting them last")
+		for _, id := range failed {
+			sizes[id] = -1
+		}
+	}
+	return sizes
+}
+
+// windowOrder returns the indexes of n date windows, oldest first, in the
+// order they are exported: newest first only when asked for explicitly
+func windowOrder(n int, order string) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	if order == OrderNewestFirst {
+		slices.Reverse(indexes)
+	}
+	return indexes
+}
+
+// validateOrder checks the export order
+func validateOrder(config *Config) error {
+	if config.Order != "" && !isValidOrder(config.Order) {
+		return fmt.Errorf("invalid order: %s (valid: %s)", config.Order, strings.Join(validOrders, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+)
+
+func TestOrderMessages(t *testing.T) {
+	e := metadataExporter(t, MetadataFormatJSON)
+	for id, size := range map[string]int64{"m1": 100, "m2": 5000, "m3": 100, "m4": 800} {
+		if err := e.cache.Put(cache.Metadata{ID: id, Size: size}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed := []string{"m4", "m3", "m2", "m1"}
+
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{"", "m4,m3,m2,m1"},
+		{OrderNewestFirst, "m4,m3,m2,m1"},
+		{OrderOldestFirst, "m1,m2,m3,m4"},
+		{OrderLargestFirst, "m2,m4,m3,m1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			e.config.Order = tt.order
+			if got := strings.Join(e.orderMessages(listed), ","); got != tt.want {
+				t.Errorf("orderMessages() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if strings.Join(listed, ",") != "m4,m3,m2,m1" {
+		t.Errorf("Expected the listed IDs to be left as they are, got %v", listed)
+	}
+}
+
+func TestWindowOrder(t *testing.T) {
+	if got := windowOrder(3, ""); got[0] != 0 || got[2] != 2 {
+		t.Errorf("windowOrder() = %v, want oldest first by default", got)
+	}
+	if got := windowOrder(3, OrderNewestFirst); got[0] != 2 || got[2] != 0 {
+		t.Errorf("windowOrder() = %v, want newest first", got)
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	for _, order := range append([]string{""}, validOrders...) {
+		if err := validateOrder(&Config{Order: order}); err != nil {
+			t.Errorf("validateOrder(%q) error = %v", order, err)
+		}
+	}
+	if err := validateOrder(&Config{Order: "random"}); err == nil {
+		t.Error("Expected an error for an unknown order")
+	}
+}
@@ -0,0 +1,194 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Transliteration modes for label directory names
+const (
+	// TransliterateNone keeps label names in Unicode, normalized to NFC
+	TransliterateNone = "none"
+	// TransliterateASCII strips accents and replaces the remaining non-ASCII
+	// characters, such as CJK and emoji, for filesystems and tools that
+	// mishandle them
+	TransliterateASCII = "ascii"
+)
+
+var validTransliterations = []string{TransliterateNone, TransliterateASCII}
+
+// maxNameBytes is the longest path component most filesystems accept
+const maxNameBytes = 255
+
+// nameHashLength is the length of the hash suffix that keeps shortened or
+// transliterated names distinct
+const nameHashLength = 8
+
+// reservedNames are device names Windows refuses as file or directory names,
+// with or without an extension
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// pathNaming names label directories and enforces the path length limit.
+// The zero value names directories after label IDs with no limit.
+type pathNaming struct {
+	byName        bool
+	transliterate string
+	maxLength     int
+}
+
+// newPathNaming creates the path naming of an export
+func newPathNaming(config *Config) pathNaming {
+	maxLength := config.MaxPathLength
+	if maxLength == 0 {
+		maxLength = defaultMaxPathLength
+	}
+	return pathNaming{byName: config.LabelDirNames, transliterate: config.Transliterate, maxLength: maxLength}
+}
+
+// isValidTransliteration checks if the transliteration mode is supported
+func isValidTransliteration(mode string) bool {
+	for _, valid := range validTransliterations {
+		if mode == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// labelPath returns the path of filename in the directory of a label under
+// base. Directories are named after the label ID, or with byName after the
+// label name, nested labels such as "Work/Clients" becoming nested
+// directories. Components are shortened to fit the path length limit.
+func (n pathNaming) labelPath(base, id, name, filename string) (string, error) {
+	components := []string{id}
+	if n.byName && name != "" {
+		components = components[:0]
+		for _, part := range strings.Split(name, "/") {
+			components = append(components, sanitizeName(part, n.transliterate))
+		}
+	}
+
+	return n.fit(base, components, filename)
+}
+
+// filePath returns the path of filename directly under base
+func (n pathNaming) filePath(base, filename string) (string, error) {
+	return n.fit(base, nil, filename)
+}
+
+// fit joins base, components and filename, shortening the components from
+// the last to the first until the path fits the length limit
+func (n pathNaming) fit(base string, components []string, filename string) (string, error) {
+	join := func() string {
+		return filepath.Join(append(append([]string{base}, components...), filename)...)
+	}
+
+	path := join()
+	if n.maxLength <= 0 {
+		return path, nil
+	}
+
+	for i := len(components) - 1; i >= 0 && pathLength(path) > n.maxLength; i-- {
+		excess := pathLength(path) - n.maxLength
+		target := max(pathLength(components[i])-excess, nameHashLength+2)
+		components[i] = shortenName(components[i], components[i], func(s string) bool { return pathLength(s) <= target })
+		path = join()
+	}
+
+	if pathLength(path) > n.maxLength {
+		return "", fmt.Errorf("path %s exceeds the maximum length of %d characters", path, n.maxLength)
+	}
+	return path, nil
+}
+
+// sanitizeName turns a label name into a directory name that is valid on
+// common filesystems: NFC normalized, optionally transliterated, without
+// path separators, characters Windows rejects or control characters, not a
+// reserved device name and at most maxNameBytes long. Names that lose
+// characters to transliteration or length get a hash suffix of the
+// original name so they stay distinct.
+func sanitizeName(name, transliterate string) string {
+	original := name
+	name = norm.NFC.String(name)
+
+	lossy := false
+	if transliterate == TransliterateASCII {
+		name, lossy = toASCII(name)
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.TrimLeft(strings.TrimRight(name, " ."), " ")
+	if name == "" {
+		name = "_"
+	}
+	if stem, _, _ := strings.Cut(name, "."); reservedNames[strings.ToLower(stem)] {
+		name = "_" + name
+	}
+
+	if lossy || len(name) > maxNameBytes {
+		name = shortenName(name, original, func(s string) bool { return len(s) <= maxNameBytes })
+	}
+	return name
+}
+
+// toASCII strips the accents of a string and replaces the remaining
+// non-ASCII characters with underscores, reporting whether any were replaced
+func toASCII(s string) (string, bool) {
+	var builder strings.Builder
+	replaced := false
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case r < utf8.RuneSelf:
+			builder.WriteRune(r)
+		default:
+			builder.WriteByte('_')
+			replaced = true
+		}
+	}
+	return builder.String(), replaced
+}
+
+// shortenName truncates name at a character boundary and appends a hash of
+// original, returning the longest result fits accepts
+func shortenName(name, original string, fits func(string) bool) string {
+	runes := []rune(name)
+	for n := len(runes); n > 0; n-- {
+		if shortened := withHash(strings.TrimRight(string(runes[:n]), " ."), original); fits(shortened) {
+			return shortened
+		}
+	}
+	return withHash("", original)
+}
+
+// withHash appends a short hash of original to name
+func withHash(name, original string) string {
+	sum := sha256.Sum256([]byte(original))
+	return name + "~" + hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// pathLength returns the length of a path in UTF-16 code units, the unit of
+// the Windows path length limit
+func pathLength(path string) int {
+	return len(utf16.Encode([]rune(path)))
+}
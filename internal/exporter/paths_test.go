@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name          string
+		label         string
+		transliterate string
+		want          string
+	}{
+		{"ascii unchanged", "Receipts", TransliterateNone, "Receipts"},
+		{"cjk kept", "仕事", TransliterateNone, "仕事"},
+		{"nfc normalized", "Café", TransliterateNone, "Café"},
+		{"invalid characters", `a<b>c:d"e\f|g?h*`, TransliterateNone, "a_b_c_d_e_f_g_h_"},
+		{"trailing dots and spaces", " Notes. ", TransliterateNone, "Notes"},
+		{"reserved name", "CON", TransliterateNone, "_CON"},
+		{"reserved name with extension", "nul.txt", TransliterateNone, "_nul.txt"},
+		{"empty", "..", TransliterateNone, "_"},
+		{"accents stripped", "Résumé", TransliterateASCII, "Resume"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeName(tt.label, tt.transliterate); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSanitizeName_LossyNamesStayDistinct(t *testing.T) {
+	first := sanitizeName("仕事 🎉", TransliterateASCII)
+	second := sanitizeName("家族 🎉", TransliterateASCII)
+
+	if first == second {
+		t.Errorf("Expected distinct names, both got %q", first)
+	}
+	if !strings.HasPrefix(first, "__ _~") || len(first) != len("__ _~")+nameHashLength {
+		t.Errorf("Expected replaced characters and a hash suffix, got %q", first)
+	}
+}
+
+func TestSanitizeName_Long(t *testing.T) {
+	long := strings.Repeat("日本", 100)
+	got := sanitizeName(long, TransliterateNone)
+
+	if len(got) > maxNameBytes {
+		t.Errorf("Expected at most %d bytes, got %d", maxNameBytes, len(got))
+	}
+	if !strings.HasPrefix(got, "日本") || !strings.Contains(got, "~") {
+		t.Errorf("Expected a truncated name with a hash suffix, got %q", got)
+	}
+}
+
+func TestPathNaming_LabelPath(t *testing.T) {
+	naming := pathNaming{byName: true, transliterate: TransliterateNone}
+
+	path, err := naming.labelPath("out", "Label_1", "Work/Clients", "m1.eml")
+	if err != nil {
+		t.Fatalf("labelPath failed: %v", err)
+	}
+	if want := filepath.Join("out", "Work", "Clients", "m1.eml"); path != want {
+		t.Errorf("Expected %s, got %s", want, path)
+	}
+
+	// Labels without a name, such as the unlabeled directory, keep their ID
+	path, err = naming.labelPath("out", unlabeledDir, "", "m1.eml")
+	if err != nil {
+		t.Fatalf("labelPath failed: %v", err)
+	}
+	if want := filepath.Join("out", unlabeledDir, "m1.eml"); path != want {
+		t.Errorf("Expected %s, got %s", want, path)
+	}
+
+	// Without byName the label ID is used
+	path, _ = pathNaming{}.labelPath("out", "Label_1", "Work/Clients", "m1.eml")
+	if want := filepath.Join("out", "Label_1", "m1.eml"); path != want {
+		t.Errorf("Expected %s, got %s", want, path)
+	}
+}
+
+func TestPathNaming_MaxLength(t *testing.T) {
+	naming := pathNaming{byName: true, transliterate: TransliterateNone, maxLength: 40}
+
+	path, err := naming.labelPath("out", "Label_1", strings.Repeat("プロジェクト", 10), "0123456789abcdef.eml")
+	if err != nil {
+		t.Fatalf("labelPath failed: %v", err)
+	}
+	if pathLength(path) > 40 {
+		t.Errorf("Expected at most 40 characters, got %d: %s", pathLength(path), path)
+	}
+	if filepath.Base(path) != "0123456789abcdef.eml" {
+		t.Errorf("Expected the file name to be kept, got %s", path)
+	}
+
+	if _, err := naming.filePath(strings.Repeat("d", 40), "m1.eml"); err == nil {
+		t.Error("Expected an error for an output directory longer than the limit")
+	}
+}
+
+func TestPathLength(t *testing.T) {
+	if got := pathLength("a/日本/🎉"); got != 7 {
+		t.Errorf("Expected 7 UTF-16 code units, got %d", got)
+	}
+}
+
+func TestValidateConfig_LabelDirNames(t *testing.T) {
+	config := &Config{CredentialsFile: "c", TokenFile: "t", OutputDir: "o", LabelDirNames: true}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected label directory names without organize by labels to be rejected")
+	}
+
+	config.OrganizeByLabels = true
+	config.Transliterate = "latin"
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected an invalid transliteration to be rejected")
+	}
+
+	config.Transliterate = TransliterateASCII
+	if err := validateConfig(config); err != nil {
+		t.Errorf("Expected a valid configuration, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package exporter
+
+// defaultMaxPathLength is the path length limit when none is configured;
+// other platforms only limit each path component
+const defaultMaxPathLength = 0
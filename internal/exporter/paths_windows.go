@@ -0,0 +1,7 @@
+//go:build windows
+
+package exporter
+
+// defaultMaxPathLength is the path length limit when none is configured:
+// MAX_PATH, 260 characters including the terminating NUL
+const defaultMaxPathLength = 259
@@ -0,0 +1,206 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PauseFileName is the control file that pauses a running export while it
+// exists in the output directory
+const PauseFileName = ".pause"
+
+// pauseCheckInterval is how often held workers check whether to continue
+const pauseCheckInterval = time.Second
+
+// Reasons workers are held between messages
+const (
+	holdPaused        = "paused"
+	holdOutsideWindow = "outside run window"
+)
+
+// pauseControl holds workers between messages while the export is paused,
+// either through Pause or by the presence of the pause file, and outside the
+// run window. Messages being exported when the hold starts are finished
+// first. Once stopped, workers are released and skip the messages they have
+// not started.
+type pauseControl struct {
+	mu      sync.Mutex
+	paused  bool
+	stopped bool
+	held    string
+	file    string
+	window  *runWindow
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+// newPauseControl creates a pause control watching file and window
+func newPauseControl(file string, window *runWindow) *pauseControl {
+	return &pauseControl{file: file, window: window, now: time.Now, sleep: time.Sleep}
+}
+
+// set pauses or resumes the export
+func (p *pauseControl) set(paused bool) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.paused = paused
+	p.mu.Unlock()
+}
+
+// stop releases held workers for good and makes them skip the messages
+// they have not started
+func (p *pauseControl) stop() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+}
+
+// isStopped reports whether the export was stopped
+func (p *pauseControl) isStopped() bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+// isPaused reports whether the export is paused by Pause or the pause file
+func (p *pauseControl) isPaused() bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	paused := p.paused
+	p.mu.Unlock()
+	if paused {
+		return true
+	}
+
+	_, err := os.Stat(p.file)
+	return err == nil
+}
+
+// reason returns why workers are held, or "" if they may export or the
+// export was stopped
+func (p *pauseControl) reason() string {
+	if p.isStopped() {
+		return ""
+	}
+	if p.isPaused() {
+		return holdPaused
+	}
+	if !p.window.contains(p.now()) {
+		return holdOutsideWindow
+	}
+	return ""
+}
+
+// wait blocks while the export is paused or outside the run window. The
+// worker that starts a hold calls onHold, before sleeping, so the export
+// state can be saved. A nil control never blocks.
+func (p *pauseControl) wait(onHold func()) {
+	if p == nil {
+		return
+	}
+
+	reason := p.reason()
+	if reason == "" {
+		return
+	}
+
+	if p.transition(reason) && onHold != nil {
+		onHold()
+	}
+	for ; reason != ""; reason = p.reason() {
+		p.transition(reason)
+		p.sleep(pauseCheckInterval)
+	}
+	p.transition("")
+}
+
+// holding reports whether workers are being held
+func (p *pauseControl) holding() bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.held != ""
+}
+
+// transition records and logs a change of hold reason once, however many
+// workers are waiting. It reports whether a hold started.
+func (p *pauseControl) transition(reason string) bool {
+	p.mu.Lock()
+	previous := p.held
+	p.held = reason
+	p.mu.Unlock()
+
+	if previous == reason {
+		return false
+	}
+
+	switch reason {
+	case holdPaused:
+		logrus.WithField("pause_file", p.file).Info("Export paused; workers wait after their current message")
+	case holdOutsideWindow:
+		logrus.WithFields(logrus.Fields{
+			"run_window": p.window.String(),
+			"resumes_at": p.window.opens(p.now()).Format("2006-01-02 15:04"),
+		}).Info("Outside run window; export sleeping")
+	default:
+		logrus.Info("Export resumed")
+	}
+
+	return previous == ""
+}
+
+// pauseFilePath returns the path of the pause control file
+func pauseFilePath(config *Config) string {
+	if config.PauseFile != "" {
+		return config.PauseFile
+	}
+	return filepath.Join(config.OutputDir, PauseFileName)
+}
+
+// Pause stops workers from starting new messages until Resume is called.
+// Messages already being exported are finished.
+func (e *Exporter) Pause() {
+	e.pause.set(true)
+}
+
+// Resume continues an export paused by Pause. An export paused by the pause
+// file stays paused until the file is removed.
+func (e *Exporter) Resume() {
+	e.pause.set(false)
+}
+
+// Paused reports whether the export is paused
+func (e *Exporter) Paused() bool {
+	return e.pause.isPaused()
+}
+
+// Cancel stops the export: workers finish the messages they are exporting
+// and skip the rest, and Export returns the result so far with Cancelled
+// set. A later run with Resume continues where it stopped.
+func (e *Exporter) Cancel() {
+	if !e.pause.isStopped() {
+		logrus.Info("Export cancelled; workers stop after their current message")
+	}
+	e.pause.stop()
+}
@@ -0,0 +1,135 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPauseControl_Nil(t *testing.T) {
+	var p *pauseControl
+	p.set(true)
+	if p.isPaused() {
+		t.Error("Expected nil pause control to never be paused")
+	}
+	p.wait(nil)
+}
+
+func TestPauseControl_PauseAndResume(t *testing.T) {
+	p := newPauseControl(filepath.Join(t.TempDir(), PauseFileName), nil)
+	if p.isPaused() {
+		t.Fatal("Expected export to start unpaused")
+	}
+
+	p.set(true)
+	if !p.isPaused() {
+		t.Fatal("Expected export to be paused")
+	}
+
+	waits := 0
+	p.sleep = func(time.Duration) {
+		waits++
+		if waits == 3 {
+			p.set(false)
+		}
+	}
+	holds := 0
+	p.wait(func() { holds++ })
+
+	if holds != 1 {
+		t.Errorf("Expected one hold callback, got %d", holds)
+	}
+	if waits != 3 {
+		t.Errorf("Expected wait to poll until resumed, polled %d times", waits)
+	}
+	if p.isPaused() {
+		t.Error("Expected export to be resumed")
+	}
+}
+
+func TestPauseControl_PauseFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), PauseFileName)
+	p := newPauseControl(file, nil)
+
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !p.isPaused() {
+		t.Fatal("Expected pause file to pause the export")
+	}
+
+	waits := 0
+	p.sleep = func(time.Duration) {
+		waits++
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.wait(nil)
+
+	if waits != 1 {
+		t.Errorf("Expected one poll before the pause file was removed, got %d", waits)
+	}
+}
+
+func TestPauseFilePath(t *testing.T) {
+	config := &Config{OutputDir: "exports"}
+	if path := pauseFilePath(config); path != filepath.Join("exports", PauseFileName) {
+		t.Errorf("Expected default pause file in output dir, got %s", path)
+	}
+
+	config.PauseFile = "shared.pause"
+	if path := pauseFilePath(config); path != "shared.pause" {
+		t.Errorf("Expected configured pause file, got %s", path)
+	}
+}
+
+func TestPauseControl_RunWindow(t *testing.T) {
+	window, err := parseRunWindow("22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 1, 21, 59, 0, 0, time.UTC)
+	p := newPauseControl(filepath.Join(t.TempDir(), PauseFileName), window)
+	p.now = func() time.Time { return now }
+	p.sleep = func(d time.Duration) {
+		if !p.holding() {
+			t.Error("Expected workers to be held while sleeping")
+		}
+		now = now.Add(d)
+	}
+
+	holds := 0
+	p.wait(func() { holds++ })
+
+	if holds != 1 {
+		t.Errorf("Expected one hold callback, got %d", holds)
+	}
+	if now.Before(time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected wait to sleep until the window opened, woke at %s", now)
+	}
+	if p.holding() {
+		t.Error("Expected hold to end once the window opened")
+	}
+}
+
+func TestPauseControl_Stop(t *testing.T) {
+	p := newPauseControl(filepath.Join(t.TempDir(), PauseFileName), nil)
+	p.set(true)
+
+	waits := 0
+	p.sleep = func(time.Duration) {
+		waits++
+		p.stop()
+	}
+	p.wait(nil)
+
+	if waits != 1 {
+		t.Errorf("Expected stop to release the paused workers, polled %d times", waits)
+	}
+	if !p.isStopped() {
+		t.Error("Expected the export to be stopped")
+	}
+}
@@ -0,0 +1,117 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Gmail API quota units charged per call, as documented for the methods the
+// exporter uses (https://developers.google.com/gmail/api/reference/quota)
+var quotaUnits = map[string]int{
+	"labels.list":      1,
+	"users.getProfile": 1,
+	"messages.list":    5,
+	"messages.get":     5,
+	"messages.get.raw": 5,
+}
+
+// Quota tracking settings
+const (
+	// defaultQuotaUnits is charged for methods missing from quotaUnits
+	defaultQuotaUnits = 5
+	// quotaWindow is the period a quota budget applies to
+	quotaWindow = 24 * time.Hour
+	// quotaWarnRatio is the share of the budget that triggers a warning
+	quotaWarnRatio = 0.8
+)
+
+// quotaTracker counts the quota units consumed by a run and, when a budget
+// is set, pauses API calls once the budget of the current day is used up
+type quotaTracker struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	budget      int
+	windowStart time.Time
+	used        int
+	total       int
+	warned      bool
+	pausedUntil time.Time
+}
+
+// newQuotaTracker creates a tracker with a daily budget of quota units
+// (0 = no budget, usage is only counted)
+func newQuotaTracker(budget int) *quotaTracker {
+	return &quotaTracker{now: time.Now, sleep: time.Sleep, budget: budget}
+}
+
+// methodQuotaUnits returns the quota units charged for a call to method
+func methodQuotaUnits(method string) int {
+	if units, ok := quotaUnits[method]; ok {
+		return units
+	}
+	return defaultQuotaUnits
+}
+
+// consume charges a call to method against the budget, blocking until the
+// next window when the budget is used up, and returns the units charged. A
+// nil tracker charges without tracking.
+func (q *quotaTracker) consume(method string) int {
+	units := methodQuotaUnits(method)
+	if q == nil {
+		return units
+	}
+
+	q.mu.Lock()
+	for {
+		now := q.now()
+		if q.windowStart.IsZero() || now.Sub(q.windowStart) >= quotaWindow {
+			q.windowStart = now
+			q.used = 0
+			q.warned = false
+		}
+
+		if q.budget <= 0 || q.used+units <= q.budget {
+			break
+		}
+
+		resume := q.windowStart.Add(quotaWindow)
+		if !q.pausedUntil.Equal(resume) {
+			q.pausedUntil = resume
+			logrus.WithFields(logrus.Fields{
+				"budget": q.budget,
+				"resume": resume.Format(time.RFC3339),
+			}).Warn("Daily quota budget used up, pausing API calls")
+		}
+		q.mu.Unlock()
+		q.sleep(resume.Sub(now))
+		q.mu.Lock()
+	}
+
+	q.used += units
+	q.total += units
+	if q.budget > 0 && !q.warned && float64(q.used) >= quotaWarnRatio*float64(q.budget) {
+		q.warned = true
+		logrus.WithFields(logrus.Fields{
+			"used":   q.used,
+			"budget": q.budget,
+		}).Warn("Approaching the daily quota budget")
+	}
+	q.mu.Unlock()
+
+	return units
+}
+
+// consumed returns the quota units consumed by the run so far
+func (q *quotaTracker) consumed() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.total
+}
@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMethodQuotaUnits(t *testing.T) {
+	tests := map[string]int{
+		"labels.list":      1,
+		"messages.get":     5,
+		"messages.get.raw": 5,
+		"unknown.method":   defaultQuotaUnits,
+	}
+	for method, expected := range tests {
+		if units := methodQuotaUnits(method); units != expected {
+			t.Errorf("methodQuotaUnits(%q) = %d, want %d", method, units, expected)
+		}
+	}
+}
+
+func TestQuotaTracker_NoBudget(t *testing.T) {
+	q := newQuotaTracker(0)
+	for i := 0; i < 100; i++ {
+		q.consume("messages.get")
+	}
+	if q.consumed() != 500 {
+		t.Errorf("Expected 500 units, got %d", q.consumed())
+	}
+}
+
+func TestQuotaTracker_PausesAtBudget(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+
+	q := newQuotaTracker(10)
+	q.now = func() time.Time { return now }
+	q.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+
+	q.consume("messages.get")
+	now = now.Add(time.Hour)
+	q.consume("messages.get")
+	if len(slept) != 0 {
+		t.Fatalf("Expected no pause within the budget, slept %v", slept)
+	}
+
+	// The third call waits for the next window
+	q.consume("messages.get")
+	if len(slept) != 1 || slept[0] != quotaWindow-time.Hour {
+		t.Errorf("Expected a pause of %s, got %v", quotaWindow-time.Hour, slept)
+	}
+	if q.consumed() != 15 {
+		t.Errorf("Expected 15 units in total, got %d", q.consumed())
+	}
+	if q.used != 5 {
+		t.Errorf("Expected 5 units in the new window, got %d", q.used)
+	}
+}
+
+func TestQuotaTracker_Nil(t *testing.T) {
+	var q *quotaTracker
+	if units := q.consume("labels.list"); units != 1 {
+		t.Errorf("Expected 1 unit, got %d", units)
+	}
+	if q.consumed() != 0 {
+		t.Errorf("Expected 0 units from a nil tracker, got %d", q.consumed())
+	}
+}
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+)
+
+// redactMessage redacts the snippet, headers and inline text bodies of a
+// message in place. Attachments are left untouched.
+func (e *Exporter) redactMessage(message *gmail.Message) error {
+	if e.redactor == nil {
+		return nil
+	}
+
+	message.Snippet = e.redactor.Redact(message.Snippet)
+	return e.redactPart(message.Payload)
+}
+
+// redactPart redacts a message part and its children
+func (e *Exporter) redactPart(part *gmail.MessagePart) error {
+	if part == nil {
+		return nil
+	}
+
+	for _, header := range part.Headers {
+		header.Value = e.redactor.Redact(header.Value)
+	}
+
+	if strings.HasPrefix(strings.ToLower(part.MimeType), "text/") && part.Filename == "" &&
+		part.Body != nil && part.Body.Data != "" {
+		data, err := decodeBase64URL(part.Body.Data)
+		if err != nil {
+			return err
+		}
+		part.Body.Data = base64.URLEncoding.EncodeToString(e.redactor.RedactBytes(data))
+	}
+
+	for _, child := range part.Parts {
+		if err := e.redactPart(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redactMetadata redacts the header fields recorded in the metadata cache and
+// filter file, which live alongside the export
+func (e *Exporter) redactMetadata(metadata *cache.Metadata) {
+	if e.redactor == nil {
+		return
+	}
+
+	metadata.Subject = e.redactor.Redact(metadata.Subject)
+	metadata.From = e.redactor.Redact(metadata.From)
+	metadata.To = e.redactor.Redact(metadata.To)
+	metadata.Cc = e.redactor.Redact(metadata.Cc)
+	for name, value := range metadata.Headers {
+		metadata.Headers[name] = e.redactor.Redact(value)
+	}
+}
@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/redact"
+)
+
+func TestRedactMessage(t *testing.T) {
+	redactor, err := redact.New([]string{redact.Emails}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create redactor: %v", err)
+	}
+	e := &Exporter{redactor: redactor}
+
+	message := &gmail.Message{
+		Snippet: "Reply to bob@example.com",
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/mixed",
+			Headers:  []*gmail.MessagePartHeader{{Name: "From", Value: "Alice <alice@example.com>"}},
+			Parts: []*gmail.MessagePart{
+				{MimeType: "text/plain", Body: encodeBody("Write to carol@example.com")},
+				{MimeType: "text/csv", Filename: "contacts.csv", Body: encodeBody("dave@example.com")},
+			},
+		},
+	}
+
+	if err := e.redactMessage(message); err != nil {
+		t.Fatalf("redactMessage failed: %v", err)
+	}
+
+	if strings.Contains(message.Snippet, "@") || strings.Contains(message.Payload.Headers[0].Value, "@") {
+		t.Errorf("Expected snippet and headers to be redacted: %q, %q", message.Snippet, message.Payload.Headers[0].Value)
+	}
+
+	body, _ := decodeBase64URL(message.Payload.Parts[0].Body.Data)
+	if string(body) != "Write to [REDACTED-EMAIL]" {
+		t.Errorf("Expected text body to be redacted, got %q", body)
+	}
+
+	attachment, _ := decodeBase64URL(message.Payload.Parts[1].Body.Data)
+	if string(attachment) != "dave@example.com" {
+		t.Errorf("Expected attachment to be untouched, got %q", attachment)
+	}
+
+	metadata := cache.Metadata{Subject: "Hi", From: "alice@example.com"}
+	e.redactMetadata(&metadata)
+	if metadata.From != "[REDACTED-EMAIL]" || metadata.Subject != "Hi" {
+		t.Errorf("Unexpected redacted metadata: %+v", metadata)
+	}
+}
+
+func TestRedactMessage_Disabled(t *testing.T) {
+	e := &Exporter{}
+	message := &gmail.Message{Snippet: "alice@example.com"}
+
+	if err := e.redactMessage(message); err != nil {
+		t.Fatalf("redactMessage failed: %v", err)
+	}
+	if message.Snippet != "alice@example.com" {
+		t.Errorf("Expected message to be unchanged, got %q", message.Snippet)
+	}
+}
+
+func TestValidateConfig_RedactFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{"", true},
+		{"eml", true},
+		{"mbox", true},
+		{"json", false},
+		{"txt", false},
+		{FormatMetadata, false},
+	}
+
+	for _, tt := range tests {
+		config := &Config{
+			CredentialsFile: "credentials.json",
+			TokenFile:       "token.json",
+			OutputDir:       "out",
+			Format:          tt.format,
+			RedactPatterns:  []string{`\d{6}`},
+		}
+		if err := validateConfig(config); (err != nil) != tt.wantErr {
+			t.Errorf("validateConfig() of a redacted %q export error = %v, wantErr %v", tt.format, err, tt.wantErr)
+		}
+	}
+}
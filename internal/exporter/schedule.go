@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// runWindow is the daily time of day range, in local time, in which messages
+// are exported. A window whose end is before its start crosses midnight.
+type runWindow struct {
+	start time.Duration
+	end   time.Duration
+	text  string
+}
+
+// parseRunWindow parses a run window such as "22:00-06:00". An empty string
+// means no window.
+func parseRunWindow(value string) (*runWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid run window: %s (want HH:MM-HH:MM)", value)
+	}
+
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run window start: %w", err)
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run window end: %w", err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid run window: %s (start and end must differ)", value)
+	}
+
+	return &runWindow{start: start, end: end, text: value}, nil
+}
+
+// parseTimeOfDay parses HH:MM into the offset since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day (want HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// sinceMidnight returns the offset of t since its local midnight
+func sinceMidnight(t time.Time) time.Duration {
+	hour, minute, second := t.Clock()
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+}
+
+// contains reports whether t falls inside the window. A nil window contains
+// every time.
+func (w *runWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+
+	offset := sinceMidnight(t)
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+// opens returns when the window next opens after t
+func (w *runWindow) opens(t time.Time) time.Time {
+	year, month, day := t.Date()
+	next := time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Add(w.start)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// String returns the window as configured
+func (w *runWindow) String() string {
+	return w.text
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRunWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"", false},
+		{"22:00-06:00", false},
+		{"09:30-17:00", false},
+		{"22:00", true},
+		{"25:00-06:00", true},
+		{"22:00-6pm", true},
+		{"08:00-08:00", true},
+	}
+
+	for _, tt := range tests {
+		_, err := parseRunWindow(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRunWindow(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunWindow_Contains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		window string
+		time   time.Time
+		want   bool
+	}{
+		{"09:00-17:00", at(9, 0), true},
+		{"09:00-17:00", at(16, 59), true},
+		{"09:00-17:00", at(17, 0), false},
+		{"09:00-17:00", at(3, 0), false},
+		{"22:00-06:00", at(23, 30), true},
+		{"22:00-06:00", at(2, 0), true},
+		{"22:00-06:00", at(6, 0), false},
+		{"22:00-06:00", at(12, 0), false},
+	}
+
+	for _, tt := range tests {
+		window, err := parseRunWindow(tt.window)
+		if err != nil {
+			t.Fatalf("parseRunWindow(%q) failed: %v", tt.window, err)
+		}
+		if got := window.contains(tt.time); got != tt.want {
+			t.Errorf("%s contains %s = %v, want %v", tt.window, tt.time.Format("15:04"), got, tt.want)
+		}
+	}
+
+	var none *runWindow
+	if !none.contains(at(12, 0)) {
+		t.Error("Expected no window to contain every time")
+	}
+}
+
+func TestRunWindow_Opens(t *testing.T) {
+	window, err := parseRunWindow("22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if opens := window.opens(now); !opens.Equal(time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected window to open tonight, got %s", opens)
+	}
+
+	now = time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	if opens := window.opens(now); !opens.Equal(time.Date(2024, 3, 2, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected window to open tomorrow, got %s", opens)
+	}
+}
@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+// Reasons for deliberately skipping a message, as recorded in Result.SkippedByReason
+const (
+	// SkipReasonSize marks messages outside the exact size bounds
+	SkipReasonSize = "size_out_of_bounds"
+	// SkipReasonLabelFilter marks messages whose labels are all excluded by
+	// the label filters in organize-by-labels mode
+	SkipReasonLabelFilter = "label_filtered"
+	// SkipReasonLabelQuota marks messages whose labels have all reached the
+	// per-label cap
+	SkipReasonLabelQuota = "label_quota"
+	// SkipReasonTooLarge marks messages larger than the configured size cap
+	SkipReasonTooLarge = "too_large"
+	// SkipReasonDeleted marks messages deleted between the search and their
+	// download
+	SkipReasonDeleted = "deleted"
+)
+
+// skippedFileName is the report of skipped messages in the output directory
+const skippedFileName = "skipped.json"
+
+// SkippedEmail records a message deliberately not exported
+type SkippedEmail struct {
+	ID      string    `json:"id"`
+	Reason  string    `json:"reason"`
+	Detail  string    `json:"detail,omitempty"`
+	Subject string    `json:"subject,omitempty"`
+	From    string    `json:"from,omitempty"`
+	Date    time.Time `json:"date,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Skipped time.Time `json:"skipped"`
+}
+
+// skipError marks a message that was deliberately not exported. Skips are
+// counted separately from failures.
+type skipError struct {
+	reason string
+	detail string
+}
+
+// Error implements the error interface
+func (e *skipError) Error() string {
+	return fmt.Sprintf("skipped (%s): %s", e.reason, e.detail)
+}
+
+// skipReason returns the skip reason if err marks a skipped message
+func skipReason(err error) (string, bool) {
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		return skipErr.reason, true
+	}
+	return "", false
+}
+
+// checkExactSize returns a skip error when exact size enforcement is enabled
+// and size lies outside the configured bounds
+func (e *Exporter) checkExactSize(size int64) error {
+	if e.filter == nil || !e.filter.ExactSize || e.filter.MatchesSize(size) {
+		return nil
+	}
+
+	return &skipError{
+		reason: SkipReasonSize,
+		detail: fmt.Sprintf("size %d bytes outside bounds (greater than %d, less than %d)",
+			size, e.filter.SizeGreaterThan, e.filter.SizeLessThan),
+	}
+}
+
+// checkMaxSize returns a skip error when the message is larger than the
+// configured size cap, before its raw content is downloaded
+func (e *Exporter) checkMaxSize(message *gmail.Message) error {
+	if e.config.SkipLargerThan <= 0 || message.SizeEstimate <= e.config.SkipLargerThan {
+		return nil
+	}
+
+	return &skipError{
+		reason: SkipReasonTooLarge,
+		detail: fmt.Sprintf("size %d bytes larger than %d", message.SizeEstimate, e.config.SkipLargerThan),
+	}
+}
+
+// skipDeleted turns the not found error of a message deleted since the
+// search into a skip error, leaving other errors unchanged
+func skipDeleted(err error) error {
+	if err == nil || failure.Categorize(err) != failure.NotFound {
+		return err
+	}
+	return &skipError{reason: SkipReasonDeleted, detail: "message no longer exists: " + err.Error()}
+}
+
+// maxSearchRefreshes bounds how often refreshSearch searches again in one
+// export
+const maxSearchRefreshes = 3
+
+// deletedRateExceeded reports whether more than rate of the attempted
+// emails were deleted before they could be downloaded
+func deletedRateExceeded(deleted, attempted int, rate float64) bool {
+	return rate > 0 && attempted > 0 && float64(deleted)/float64(attempted) > rate
+}
+
+// refreshSearch searches again while the share of deleted emails in the
+// last pass exceeds RefreshSearchAt, and exports the matching emails the
+// earlier searches did not list, adding them to result
+func (e *Exporter) refreshSearch(filterConfig *filters.Config, listed []string, result *Result) error {
+	seen := make(map[string]bool, len(listed))
+	for _, id := range listed {
+		seen[id] = true
+	}
+
+	deleted, attempted := result.TotalDeleted, len(listed)
+	for result.SearchRefreshes < maxSearchRefreshes && deletedRateExceeded(deleted, attempted, e.config.RefreshSearchAt) {
+		if e.pause.isStopped() {
+			return nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"deleted":   deleted,
+			"attempted": attempted,
+		}).Warn("Many emails were deleted since the search, searching again")
+
+		messageIDs, err := e.searchEmails(filterConfig)
+		if err != nil {
+			return fmt.Errorf("failed to search emails: %w", err)
+		}
+		result.SearchRefreshes++
+
+		var fresh []string
+		for _, id := range messageIDs {
+			if !seen[id] {
+				seen[id] = true
+				fresh = append(fresh, id)
+			}
+		}
+		fresh = skipProcessed(fresh, e.processed)
+		if len(fresh) == 0 {
+			return nil
+		}
+
+		logrus.WithField("count", len(fresh)).Info("Found emails the earlier search missed")
+		e.metrics.AddMatched(len(fresh))
+		pass, err := e.exportEmails(fresh)
+		if err != nil {
+			return fmt.Errorf("failed to export emails: %w", err)
+		}
+		pass.TotalMatched = len(fresh)
+		mergeResult(result, pass)
+		deleted, attempted = pass.TotalDeleted, len(fresh)
+	}
+	return nil
+}
+
+// newSkippedEmail builds the skip report entry of a skipped message, with
+// its metadata when known
+func newSkippedEmail(messageID, reason string, err error, metadata *cache.Metadata) SkippedEmail {
+	skipped := SkippedEmail{ID: messageID, Reason: reason, Skipped: time.Now()}
+
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		skipped.Detail = skipErr.detail
+	}
+	if metadata != nil {
+		skipped.Subject = metadata.Subject
+		skipped.From = metadata.From
+		skipped.Date = metadata.Date
+		skipped.Size = metadata.Size
+	}
+
+	return skipped
+}
+
+// saveSkipped writes the report of the messages skipped by this run
+func (e *Exporter) saveSkipped(skipped []SkippedEmail) error {
+	data, err := json.MarshalIndent(skipped, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal skipped emails: %w", err)
+	}
+
+	path := filepath.Join(e.config.OutputDir, skippedFileName)
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write skipped emails: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"skipped": len(skipped),
+		"report":  path,
+	}).Info("Saved report of skipped emails")
+	return nil
+}
@@ -0,0 +1,191 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+func TestCheckExactSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     *filters.Config
+		size       int64
+		expectSkip bool
+	}{
+		{
+			name:       "no filter",
+			filter:     nil,
+			size:       10,
+			expectSkip: false,
+		},
+		{
+			name:       "exact size disabled",
+			filter:     &filters.Config{SizeGreaterThan: 100},
+			size:       10,
+			expectSkip: false,
+		},
+		{
+			name:       "within bounds",
+			filter:     &filters.Config{SizeGreaterThan: 100, SizeLessThan: 1000, ExactSize: true},
+			size:       500,
+			expectSkip: false,
+		},
+		{
+			name:       "too small",
+			filter:     &filters.Config{SizeGreaterThan: 100, ExactSize: true},
+			size:       99,
+			expectSkip: true,
+		},
+		{
+			name:       "too large",
+			filter:     &filters.Config{SizeLessThan: 1000, ExactSize: true},
+			size:       1001,
+			expectSkip: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Exporter{filter: tt.filter}
+			err := e.checkExactSize(tt.size)
+
+			reason, skipped := skipReason(err)
+			if skipped != tt.expectSkip {
+				t.Fatalf("checkExactSize(%d) skipped = %v, want %v", tt.size, skipped, tt.expectSkip)
+			}
+			if skipped && reason != SkipReasonSize {
+				t.Errorf("Expected reason %q, got %q", SkipReasonSize, reason)
+			}
+		})
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	wrapped := fmt.Errorf("export: %w", &skipError{reason: SkipReasonSize, detail: "too big"})
+	if reason, ok := skipReason(wrapped); !ok || reason != SkipReasonSize {
+		t.Errorf("Expected wrapped skip error to be detected, got %q, %v", reason, ok)
+	}
+
+	if _, ok := skipReason(errors.New("boom")); ok {
+		t.Error("Expected plain error not to be a skip")
+	}
+
+	if _, ok := skipReason(nil); ok {
+		t.Error("Expected nil error not to be a skip")
+	}
+}
+
+func TestCheckMaxSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		cap        int64
+		size       int64
+		expectSkip bool
+	}{
+		{name: "no cap", cap: 0, size: 50 << 20, expectSkip: false},
+		{name: "under cap", cap: 35 << 20, size: 1 << 20, expectSkip: false},
+		{name: "at cap", cap: 35 << 20, size: 35 << 20, expectSkip: false},
+		{name: "over cap", cap: 35 << 20, size: 50 << 20, expectSkip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Exporter{config: &Config{SkipLargerThan: tt.cap}}
+			err := e.checkMaxSize(&gmail.Message{Id: "m1", SizeEstimate: tt.size})
+
+			reason, skipped := skipReason(err)
+			if skipped != tt.expectSkip {
+				t.Fatalf("checkMaxSize(%d) skipped = %v, want %v", tt.size, skipped, tt.expectSkip)
+			}
+			if skipped && reason != SkipReasonTooLarge {
+				t.Errorf("Expected reason %q, got %q", SkipReasonTooLarge, reason)
+			}
+		})
+	}
+}
+
+func TestSaveSkipped(t *testing.T) {
+	dir := t.TempDir()
+	e := &Exporter{config: &Config{OutputDir: dir, SkipLargerThan: 10}}
+
+	err := e.checkMaxSize(&gmail.Message{Id: "m1", SizeEstimate: 20})
+	metadata := &cache.Metadata{ID: "m1", Subject: "Huge", Size: 20}
+	skipped := []SkippedEmail{
+		newSkippedEmail("m1", SkipReasonTooLarge, err, metadata),
+		newSkippedEmail("m2", SkipReasonLabelFilter, nil, nil),
+	}
+
+	if err := e.saveSkipped(skipped); err != nil {
+		t.Fatalf("saveSkipped() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, skippedFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded []SkippedEmail
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 2 {
+		t.Fatalf("Expected 2 skipped emails, got %d", len(loaded))
+	}
+	if loaded[0].Reason != SkipReasonTooLarge || loaded[0].Subject != "Huge" || loaded[0].Size != 20 {
+		t.Errorf("Unexpected first entry: %+v", loaded[0])
+	}
+	if loaded[0].Detail == "" {
+		t.Error("Expected the skip detail to be recorded")
+	}
+	if loaded[1].ID != "m2" || loaded[1].Reason != SkipReasonLabelFilter {
+		t.Errorf("Unexpected second entry: %+v", loaded[1])
+	}
+}
+
+func TestSkipDeleted(t *testing.T) {
+	notFound := fmt.Errorf("failed to get message: %w", &googleapi.Error{Code: 404, Message: "Requested entity was not found."})
+	if reason, skipped := skipReason(skipDeleted(notFound)); !skipped || reason != SkipReasonDeleted {
+		t.Errorf("Expected a 404 to be skipped as deleted, got %q", reason)
+	}
+
+	serverErr := &googleapi.Error{Code: 500}
+	if err := skipDeleted(serverErr); err != serverErr {
+		t.Errorf("Expected other errors unchanged, got %v", err)
+	}
+	if err := skipDeleted(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
+
+func TestDeletedRateExceeded(t *testing.T) {
+	tests := []struct {
+		name      string
+		deleted   int
+		attempted int
+		rate      float64
+		want      bool
+	}{
+		{"disabled", 50, 100, 0, false},
+		{"below rate", 4, 100, 0.05, false},
+		{"above rate", 6, 100, 0.05, true},
+		{"nothing attempted", 0, 0, 0.05, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deletedRateExceeded(tt.deleted, tt.attempted, tt.rate); got != tt.want {
+				t.Errorf("deletedRateExceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+)
+
+// DefaultMaxInMemorySize is the largest message decoded in memory before
+// export; larger messages are streamed to their file
+const DefaultMaxInMemorySize = 16 * 1024 * 1024
+
+// streamRaw reports whether the raw message is streamed to its file instead
+// of being decoded in memory
+func (e *Exporter) streamRaw(message *gmail.Message) bool {
+	return message.SizeEstimate > e.config.MaxInMemorySize
+}
+
+// exportRawStreamed writes the raw message to outputPath as it is
+// downloaded: the base64 of the API response is decoded through a bounded
+// buffer as the response is read, so neither the response nor the message
+// is held in memory
+func (e *Exporter) exportRawStreamed(message *gmail.Message, outputPath string) (exportedFile, error) {
+	logrus.WithFields(logrus.Fields{
+		"message_id":    message.Id,
+		"size_estimate": message.SizeEstimate,
+	}).Debug("Streaming large message to disk")
+
+	header := e.takeoutHeaders(message)
+	var file exportedFile
+	var skipped error
+	err := e.callAPI("messages.get.raw", func(service *gmail.Service) error {
+		return streamRawMessage(service, message.Id, func(raw io.Reader) error {
+			// Enforce exact size bounds before the file is moved into place,
+			// and checksum the raw message apart from the added header
+			sized := &sizeCheckedReader{r: raw, check: e.checkExactSize}
+			rawHash := sha256.New()
+			var err error
+			file, err = e.writeExportStream(outputPath, io.MultiReader(bytes.NewReader(header), io.TeeReader(sized, rawHash)))
+			var skip *skipError
+			if errors.As(err, &skip) {
+				// Not a failed call to retry
+				skipped = skip
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write EML file: %w", err)
+			}
+			if e.custody != nil {
+				file.RawSHA256 = hex.EncodeToString(rawHash.Sum(nil))
+			}
+			return nil
+		})
+	})
+	if skipped != nil {
+		return exportedFile{}, skipped
+	}
+	if err != nil {
+		return exportedFile{}, err
+	}
+
+	return file, nil
+}
+
+// streamRawMessage downloads a raw message with service and passes write a
+// reader of the decoded message, which reads the response as it goes.
+// Services not created by the auth package cannot be streamed from, and
+// their response is decoded in memory.
+func streamRawMessage(service *gmail.Service, messageID string, write func(raw io.Reader) error) error {
+	client := auth.HTTPClient(service)
+	if client == nil {
+		message, err := service.Users.Messages.Get("me", messageID).Format("raw").Do()
+		if err != nil {
+			return fmt.Errorf("failed to get raw message: %w", err)
+		}
+		return write(newBase64URLDecoder(message.Raw))
+	}
+
+	endpoint := service.BasePath + "gmail/v1/users/me/messages/" + url.PathEscape(messageID) + "?alt=json&format=raw&prettyPrint=false"
+	response, err := client.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to get raw message: %w", err)
+	}
+	defer response.Body.Close()
+	if err := googleapi.CheckResponse(response); err != nil {
+		return fmt.Errorf("failed to get raw message: %w", err)
+	}
+
+	raw, err := rawMessageReader(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to get raw message: %w", err)
+	}
+	return write(raw)
+}
+
+// rawMessageReader returns a reader of the decoded "raw" field of a
+// messages.get response body. Fields before it are skipped; the field itself
+// is decoded as it is read, never held as a string.
+func rawMessageReader(body io.Reader) (io.Reader, error) {
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("invalid message response")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid message response: %w", err)
+		}
+		if token == "raw" {
+			// The decoder has read the key; its value follows in the
+			// decoder's buffer and then the rest of the body
+			r := bufio.NewReader(io.MultiReader(decoder.Buffered(), body))
+			if err := skipToString(r); err != nil {
+				return nil, err
+			}
+			return base64.NewDecoder(base64.RawURLEncoding, &base64StringReader{r: r}), nil
+		}
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return nil, fmt.Errorf("invalid message response: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("message response has no raw message")
+}
+
+// skipToString reads up to and including the opening quote of the string
+// value after an object key
+func skipToString(r *bufio.Reader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("invalid message response: %w", err)
+		}
+		switch b {
+		case '"':
+			return nil
+		case ':', ' ', '\t', '\r', '\n':
+		default:
+			return fmt.Errorf("invalid message response: raw message is not a string")
+		}
+	}
+}
+
+// base64StringReader reads a JSON string of base64url data up to its closing
+// quote, without the padding
+type base64StringReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (s *base64StringReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+		switch b {
+		case '"':
+			s.done = true
+			if n == 0 {
+				return 0, io.EOF
+			}
+			return n, nil
+		case '=':
+			continue
+		case '\\':
+			// base64url never needs escaping
+			return n, fmt.Errorf("invalid raw message: unexpected escape")
+		}
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// sizeCheckedReader counts the bytes read from r and, at its end, returns
+// the error of check for the total instead of io.EOF
+type sizeCheckedReader struct {
+	r     io.Reader
+	check func(size int64) error
+	size  int64
+}
+
+func (s *sizeCheckedReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.size += int64(n)
+	if err == io.EOF {
+		if checkErr := s.check(s.size); checkErr != nil {
+			return n, checkErr
+		}
+	}
+	return n, err
+}
+
+// newBase64URLDecoder returns a reader decoding base64url data, padded or
+// not, without decoding it all at once
+func newBase64URLDecoder(data string) io.Reader {
+	return base64.NewDecoder(base64.RawURLEncoding, strings.NewReader(strings.TrimRight(data, "=")))
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestNewBase64URLDecoder(t *testing.T) {
+	for n := 0; n < 70; n++ {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(i*37 + 251)
+		}
+
+		for name, encoding := range map[string]*base64.Encoding{
+			"padded":   base64.URLEncoding,
+			"unpadded": base64.RawURLEncoding,
+		} {
+			encoded := encoding.EncodeToString(data)
+
+			decoded, err := io.ReadAll(newBase64URLDecoder(encoded))
+			if err != nil {
+				t.Fatalf("%s length %d: decode error = %v", name, n, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s length %d: decoded data differs", name, n)
+			}
+
+			response := `{"id":"m1","labelIds":["INBOX","UNREAD"],"payload":{"headers":[]}, "raw" : "` + encoded + `","historyId":"1"}`
+			raw, err := rawMessageReader(strings.NewReader(response))
+			if err != nil {
+				t.Fatalf("%s length %d: rawMessageReader() error = %v", name, n, err)
+			}
+			streamed, err := io.ReadAll(raw)
+			if err != nil || !bytes.Equal(streamed, data) {
+				t.Errorf("%s length %d: streamed %q, %v", name, n, streamed, err)
+			}
+		}
+	}
+}
+
+func TestNewBase64URLDecoder_Invalid(t *testing.T) {
+	if _, err := io.ReadAll(newBase64URLDecoder("not*base64")); err == nil {
+		t.Error("Expected an error for invalid base64")
+	}
+}
+
+func TestRawMessageReader_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"not an object", `["raw"]`},
+		{"no raw", `{"id":"m1","snippet":"raw"}`},
+		{"raw not a string", `{"raw":42}`},
+		{"cut short", `{"id":"m1","raw":"U3ViamVjd`},
+		{"escaped", `{"raw":"U3Vi\u0061"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := rawMessageReader(strings.NewReader(tt.response))
+			if err == nil {
+				_, err = io.ReadAll(raw)
+			}
+			if err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestSizeCheckedReader(t *testing.T) {
+	var checked int64
+	r := &sizeCheckedReader{r: strings.NewReader("12345"), check: func(size int64) error {
+		checked = size
+		return errors.New("too large")
+	}}
+	if _, err := io.ReadAll(r); err == nil || checked != 5 {
+		t.Errorf("ReadAll() error = %v, checked %d, want the check of 5 bytes", err, checked)
+	}
+}
+
+func TestWriteExportStream(t *testing.T) {
+	dir := t.TempDir()
+	e := &Exporter{config: &Config{OutputDir: dir}}
+
+	data := bytes.Repeat([]byte("Subject: large\r\n"), 10000)
+	path := filepath.Join(dir, "large.eml")
+
+	file, err := e.writeExportStream(path, newBase64URLDecoder(base64.URLEncoding.EncodeToString(data)))
+	if err != nil {
+		t.Fatalf("writeExportStream() error = %v", err)
+	}
+	if file.Size != int64(len(data)) || file.SHA256 != sha256Hex(data) {
+		t.Errorf("writeExportStream() = %+v", file)
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, data) {
+		t.Error("Written file differs from the decoded message")
+	}
+}
+
+func TestStreamRaw(t *testing.T) {
+	e := &Exporter{config: &Config{MaxInMemorySize: 1024}}
+
+	if e.streamRaw(&gmail.Message{SizeEstimate: 1024}) {
+		t.Error("Expected a message at the limit to be decoded in memory")
+	}
+	if !e.streamRaw(&gmail.Message{SizeEstimate: 1025}) {
+		t.Error("Expected a message over the limit to be streamed")
+	}
+}
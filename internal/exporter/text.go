@@ -0,0 +1,245 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"google.golang.org/api/gmail/v1"
+)
+
+// textHeaders are the headers written at the top of a txt export, in order
+var textHeaders = []string{"Date", "From", "To", "Cc", "Subject"}
+
+// exportAsText exports an email as plain text: key headers followed by the
+// text/plain body, or the text/html body converted to markdown
+func (e *Exporter) exportAsText(message *gmail.Message, outputPath string) (exportedFile, error) {
+	// Enforce exact size bounds on Gmail's size estimate, the only size
+	// available without downloading the raw message
+	if err := e.checkExactSize(message.SizeEstimate); err != nil {
+		return exportedFile{}, err
+	}
+
+	if err := e.normalizeCharsets(message); err != nil {
+		return exportedFile{}, fmt.Errorf("failed to transcode message: %w", err)
+	}
+
+	if err := e.redactMessage(message); err != nil {
+		return exportedFile{}, fmt.Errorf("failed to redact message: %w", err)
+	}
+
+	text, err := renderText(message)
+	if err != nil {
+		return exportedFile{}, err
+	}
+
+	file, err := e.writeExportFile(outputPath, []byte(text))
+	if err != nil {
+		return exportedFile{}, fmt.Errorf("failed to write text file: %w", err)
+	}
+
+	return file, nil
+}
+
+// renderText renders the headers and preferred body of a message as text
+func renderText(message *gmail.Message) (string, error) {
+	var builder strings.Builder
+
+	if message.Payload != nil {
+		for _, name := range textHeaders {
+			for _, header := range message.Payload.Headers {
+				if strings.EqualFold(header.Name, name) {
+					fmt.Fprintf(&builder, "%s: %s\n", name, header.Value)
+					break
+				}
+			}
+		}
+	}
+	builder.WriteString("\n")
+
+	body, err := textBody(message.Payload)
+	if err != nil {
+		return "", err
+	}
+	if body == "" {
+		body = message.Snippet
+	}
+
+	builder.WriteString(strings.TrimSpace(body))
+	builder.WriteString("\n")
+
+	return builder.String(), nil
+}
+
+// textBody returns the text/plain body of a message part tree, falling back
+// to the text/html body converted to markdown
+func textBody(part *gmail.MessagePart) (string, error) {
+	if plain := findPart(part, "text/plain"); plain != nil {
+		data, err := decodeBase64URL(plain.Body.Data)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode text body: %w", err)
+		}
+		return normalizeNewlines(string(data)), nil
+	}
+
+	if htmlPart := findPart(part, "text/html"); htmlPart != nil {
+		data, err := decodeBase64URL(htmlPart.Body.Data)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode HTML body: %w", err)
+		}
+		return htmlToMarkdown(string(data)), nil
+	}
+
+	return "", nil
+}
+
+// findPart returns the first inline part with the given MIME type, searching
+// depth-first. Attachments are ignored.
+func findPart(part *gmail.MessagePart, mimeType string) *gmail.MessagePart {
+	if part == nil {
+		return nil
+	}
+
+	if strings.EqualFold(part.MimeType, mimeType) && part.Filename == "" && part.Body != nil && part.Body.Data != "" {
+		return part
+	}
+
+	for _, child := range part.Parts {
+		if found := findPart(child, mimeType); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// normalizeNewlines converts CRLF line endings to LF
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+var (
+	blankLines = regexp.MustCompile(`\n{3,}`)
+	spaceRuns  = regexp.MustCompile(`[ \t\r\n]+`)
+)
+
+// htmlToMarkdown converts an HTML body to lightweight markdown. It keeps
+// headings, paragraphs, lists, links and emphasis and drops everything else.
+func htmlToMarkdown(source string) string {
+	var out strings.Builder
+	var linkHref []string
+	skipDepth := 0
+	listDepth := 0
+	preDepth := 0
+
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.Data {
+			case "script", "style", "head", "title":
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+			case "br":
+				out.WriteString("\n")
+			case "p", "div", "table", "tr", "blockquote":
+				out.WriteString("\n\n")
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				out.WriteString("\n\n" + strings.Repeat("#", int(token.Data[1]-'0')) + " ")
+			case "ul", "ol":
+				listDepth++
+				out.WriteString("\n")
+			case "li":
+				out.WriteString("\n" + strings.Repeat("  ", max(listDepth-1, 0)) + "- ")
+			case "strong", "b":
+				out.WriteString("**")
+			case "em", "i":
+				out.WriteString("_")
+			case "hr":
+				out.WriteString("\n\n---\n\n")
+			case "pre":
+				preDepth++
+				out.WriteString("\n\n```\n")
+			case "td", "th":
+				out.WriteString(" | ")
+			case "a":
+				linkHref = append(linkHref, attr(token, "href"))
+				out.WriteString("[")
+			case "img":
+				if alt := attr(token, "alt"); alt != "" {
+					out.WriteString("[image: " + alt + "]")
+				}
+			}
+		case html.EndTagToken:
+			switch token.Data {
+			case "script", "style", "head", "title":
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case "p", "div", "table", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6":
+				out.WriteString("\n\n")
+			case "ul", "ol":
+				if listDepth > 0 {
+					listDepth--
+				}
+				out.WriteString("\n")
+			case "strong", "b":
+				out.WriteString("**")
+			case "em", "i":
+				out.WriteString("_")
+			case "pre":
+				if preDepth > 0 {
+					preDepth--
+				}
+				out.WriteString("\n```\n\n")
+			case "a":
+				href := ""
+				if n := len(linkHref); n > 0 {
+					href = linkHref[n-1]
+					linkHref = linkHref[:n-1]
+				}
+				if href != "" {
+					out.WriteString("](" + href + ")")
+				} else {
+					out.WriteString("]")
+				}
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			if preDepth > 0 {
+				out.WriteString(token.Data)
+				continue
+			}
+			out.WriteString(spaceRuns.ReplaceAllString(token.Data, " "))
+		}
+	}
+
+	// Tidy up whitespace introduced by block elements
+	lines := strings.Split(out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	text := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return strings.TrimSpace(text)
+}
+
+// attr returns the value of the named attribute of an HTML token
+func attr(token html.Token, name string) string {
+	for _, a := range token.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
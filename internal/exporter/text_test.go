@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func encodeBody(s string) *gmail.MessagePartBody {
+	return &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(s))}
+}
+
+func TestRenderText_PrefersPlainText(t *testing.T) {
+	message := &gmail.Message{
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/alternative",
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Subject", Value: "Hello"},
+				{Name: "From", Value: "alice@example.com"},
+				{Name: "X-Mailer", Value: "ignored"},
+				{Name: "Date", Value: "Mon, 02 Jan 2023 15:04:05 +0000"},
+			},
+			Parts: []*gmail.MessagePart{
+				{MimeType: "text/plain", Body: encodeBody("Plain body\r\nline two")},
+				{MimeType: "text/html", Body: encodeBody("<p>HTML body</p>")},
+			},
+		},
+	}
+
+	text, err := renderText(message)
+	if err != nil {
+		t.Fatalf("renderText failed: %v", err)
+	}
+
+	want := "Date: Mon, 02 Jan 2023 15:04:05 +0000\nFrom: alice@example.com\nSubject: Hello\n\nPlain body\nline two\n"
+	if text != want {
+		t.Errorf("renderText() = %q, want %q", text, want)
+	}
+}
+
+func TestRenderText_FallsBackToHTML(t *testing.T) {
+	message := &gmail.Message{
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/mixed",
+			Parts: []*gmail.MessagePart{
+				{MimeType: "text/html", Body: encodeBody("<h1>Title</h1><p>Hello <b>world</b></p>")},
+				{MimeType: "text/plain", Filename: "notes.txt", Body: encodeBody("attachment")},
+			},
+		},
+	}
+
+	text, err := renderText(message)
+	if err != nil {
+		t.Fatalf("renderText failed: %v", err)
+	}
+	if !strings.Contains(text, "# Title") || !strings.Contains(text, "Hello **world**") {
+		t.Errorf("Expected markdown body, got %q", text)
+	}
+	if strings.Contains(text, "attachment") {
+		t.Errorf("Expected attachments to be ignored, got %q", text)
+	}
+}
+
+func TestRenderText_SnippetFallback(t *testing.T) {
+	text, err := renderText(&gmail.Message{Snippet: "Just a snippet"})
+	if err != nil {
+		t.Fatalf("renderText failed: %v", err)
+	}
+	if text != "\nJust a snippet\n" {
+		t.Errorf("Unexpected text %q", text)
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs and line breaks",
+			html: "<p>First   paragraph</p><p>Second<br>line</p>",
+			want: "First paragraph\n\nSecond\nline",
+		},
+		{
+			name: "links and emphasis",
+			html: `<p>See <a href="https://example.com">the <em>docs</em></a></p>`,
+			want: "See [the _docs_](https://example.com)",
+		},
+		{
+			name: "lists",
+			html: "<ul><li>One</li><li>Two</li></ul>",
+			want: "- One\n- Two",
+		},
+		{
+			name: "scripts and styles dropped",
+			html: "<html><head><title>T</title><style>p{}</style></head><body><script>x()</script>Body</body></html>",
+			want: "Body",
+		},
+		{
+			name: "entities decoded",
+			html: "<p>Fish &amp; chips</p>",
+			want: "Fish & chips",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlToMarkdown(tt.html); got != tt.want {
+				t.Errorf("htmlToMarkdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
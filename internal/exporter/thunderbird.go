@@ -0,0 +1,213 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// Thunderbird Local Folders conventions: each folder is an mbox file without
+// an extension, its subfolders live in a directory named after it with a
+// .sbd suffix, and the .msf summary files beside the mbox files are indexes
+// Thunderbird rebuilds, so they are not written
+const (
+	thunderbirdSubdirSuffix  = ".sbd"
+	thunderbirdSummarySuffix = ".msf"
+	thunderbirdArchives      = "Archives"
+)
+
+// thunderbirdSystemFolders are the Thunderbird special folders that system
+// labels file messages into
+var thunderbirdSystemFolders = map[string]string{
+	"INBOX": "Inbox",
+	"SENT":  "Sent",
+	"DRAFT": "Drafts",
+	"TRASH": "Trash",
+	"SPAM":  "Junk",
+}
+
+// X-Mozilla-Status flags
+const (
+	mozillaStatusRead   = 0x0001
+	mozillaStatusMarked = 0x0004
+)
+
+// thunderbirdFolders returns the folder paths, as nested folder names, that
+// a message with the given labels is filed in. Trash and spam keep only that
+// folder, as in Gmail; messages in no folder go to Archives, Gmail's All Mail.
+func thunderbirdFolders(labelIDs []string, names map[string]string) [][]string {
+	for _, id := range []string{"TRASH", "SPAM"} {
+		if slices.Contains(labelIDs, id) {
+			return [][]string{{thunderbirdSystemFolders[id]}}
+		}
+	}
+
+	var folders [][]string
+	for _, id := range labelIDs {
+		if folder, ok := thunderbirdSystemFolders[id]; ok {
+			folders = append(folders, []string{folder})
+			continue
+		}
+		if id == "CHAT" || strings.HasPrefix(id, "CATEGORY_") || slices.Contains(stateLabels, id) {
+			continue
+		}
+
+		name := names[id]
+		if name == "" {
+			name = id
+		}
+		var folder []string
+		for _, part := range strings.Split(name, "/") {
+			if part = strings.TrimSpace(part); part != "" {
+				folder = append(folder, sanitizeName(part, TransliterateNone))
+			}
+		}
+		if len(folder) > 0 {
+			folders = append(folders, folder)
+		}
+	}
+
+	if len(folders) == 0 {
+		return [][]string{{thunderbirdArchives}}
+	}
+	return folders
+}
+
+// thunderbirdPath returns the mbox file of a folder under the Local Folders
+// directory
+func thunderbirdPath(dir string, folder []string) string {
+	parts := make([]string, 0, len(folder)+1)
+	parts = append(parts, dir)
+	for _, parent := range folder[:len(folder)-1] {
+		parts = append(parts, parent+thunderbirdSubdirSuffix)
+	}
+	parts = append(parts, folder[len(folder)-1])
+	return filepath.Join(parts...)
+}
+
+// saveThunderbirdFolders writes the exported messages as a Thunderbird Local
+// Folders mbox hierarchy in ThunderbirdDir, one folder per label. Every run
+// rewrites the folders from all the processed emails, so a resumed export
+// ends with complete folders.
+func (e *Exporter) saveThunderbirdFolders(processedEmails []ProcessedEmail) error {
+	names, err := e.labelNames()
+	if err != nil {
+		return err
+	}
+	messages, err := e.exportedMessages(processedEmails)
+	if err != nil {
+		return err
+	}
+
+	byPath := make(map[string][]exportedMessage)
+	parents := make(map[string]bool)
+	for _, message := range messages {
+		for _, folder := range thunderbirdFolders(message.Labels, names) {
+			path := thunderbirdPath(e.config.ThunderbirdDir, folder)
+			byPath[path] = append(byPath[path], message)
+			for depth := 1; depth < len(folder); depth++ {
+				parents[thunderbirdPath(e.config.ThunderbirdDir, folder[:depth])] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(byPath))
+	for path := range byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := e.writeThunderbirdFolder(path, byPath[path]); err != nil {
+			return err
+		}
+	}
+
+	// A folder with subfolders needs its own mbox file, even when empty
+	for parent := range parents {
+		if _, ok := byPath[parent]; ok {
+			continue
+		}
+		file, err := os.OpenFile(parent, os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create folder %s: %w", parent, err)
+		}
+		file.Close()
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"folders": len(paths),
+		"dir":     e.config.ThunderbirdDir,
+	}).Info("Saved Thunderbird folders")
+	return nil
+}
+
+// writeThunderbirdFolder writes the mbox file of a folder and removes its
+// stale summary so Thunderbird rebuilds it
+func (e *Exporter) writeThunderbirdFolder(path string, messages []exportedMessage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create folder directory: %w", err)
+	}
+
+	err := atomicfile.Write(path, 0o600, atomicfile.Options{Sync: e.config.Fsync}, func(w io.Writer) error {
+		buffered := bufio.NewWriter(w)
+		for _, message := range messages {
+			raw, err := os.ReadFile(filepath.Join(e.config.OutputDir, filepath.FromSlash(message.File)))
+			if err != nil {
+				logrus.WithError(err).WithField("message_id", message.ID).Warn("Failed to read message for Thunderbird folder")
+				continue
+			}
+			writeThunderbirdMessage(buffered, raw, message.Date, message.Labels)
+		}
+		return buffered.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write folder %s: %w", path, err)
+	}
+
+	if err := os.Remove(path + thunderbirdSummarySuffix); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).WithField("folder", path).Warn("Failed to remove stale folder summary")
+	}
+	return nil
+}
+
+// writeThunderbirdMessage appends a message to an mbox folder: a "From - "
+// separator line, X-Mozilla-Status headers carrying the read and starred
+// state, and the message with mboxrd quoting
+func writeThunderbirdMessage(w *bufio.Writer, raw []byte, date time.Time, labelIDs []string) {
+	status := mozillaStatusRead
+	if slices.Contains(labelIDs, "UNREAD") {
+		status &^= mozillaStatusRead
+	}
+	if slices.Contains(labelIDs, "STARRED") {
+		status |= mozillaStatusMarked
+	}
+
+	fmt.Fprintf(w, "From - %s\n", date.UTC().Format(time.ANSIC))
+	fmt.Fprintf(w, "X-Mozilla-Status: %04x\n", status)
+	fmt.Fprintf(w, "X-Mozilla-Status2: 00000000\n")
+
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			w.WriteByte('>')
+		}
+		w.Write(line)
+	}
+
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		w.WriteByte('\n')
+	}
+	w.WriteByte('\n')
+}
@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+)
+
+func TestThunderbirdFolders(t *testing.T) {
+	names := map[string]string{"Label_1": "Clients/Acme", "Label_2": "Q3: Plans"}
+
+	tests := []struct {
+		name     string
+		labelIDs []string
+		want     [][]string
+	}{
+		{
+			name:     "inbox and nested label",
+			labelIDs: []string{"INBOX", "UNREAD", "CATEGORY_UPDATES", "Label_1"},
+			want:     [][]string{{"Inbox"}, {"Clients", "Acme"}},
+		},
+		{
+			name:     "sanitized name",
+			labelIDs: []string{"SENT", "Label_2"},
+			want:     [][]string{{"Sent"}, {"Q3_ Plans"}},
+		},
+		{
+			name:     "trash only",
+			labelIDs: []string{"TRASH", "Label_1"},
+			want:     [][]string{{"Trash"}},
+		},
+		{
+			name:     "archived",
+			labelIDs: []string{"IMPORTANT", "STARRED"},
+			want:     [][]string{{"Archives"}},
+		},
+		{
+			name:     "unknown label",
+			labelIDs: []string{"Label_9"},
+			want:     [][]string{{"Label_9"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := thunderbirdFolders(tt.labelIDs, names); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("thunderbirdFolders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveThunderbirdFolders(t *testing.T) {
+	dir := t.TempDir()
+	tbDir := filepath.Join(dir, "Local Folders")
+
+	metadataCache, err := cache.Open(filepath.Join(dir, cache.DefaultFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metadataCache.Close()
+	err = metadataCache.Put(
+		cache.Metadata{ID: "m1", Labels: []string{"INBOX", "Label_1", "STARRED"}},
+		cache.Metadata{ID: "m2", Labels: []string{"Label_1", "UNREAD"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := map[string]string{
+		"m1.eml": "Subject: one\r\n\r\nFrom the top\r\n",
+		"m2.eml": "Subject: two\r\n\r\nbody",
+	}
+	for name, raw := range messages {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(raw), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A stale summary is removed so Thunderbird rebuilds it
+	if err := os.MkdirAll(filepath.Join(tbDir, "Clients.sbd"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tbDir, "Clients.sbd", "Acme.msf"), []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Exporter{
+		config: &Config{OutputDir: dir, ThunderbirdDir: tbDir},
+		labels: &labelSelector{},
+		cache:  metadataCache,
+	}
+	e.labelCache = labels.Static([]*gmail.Label{{Id: "Label_1", Name: "Clients/Acme"}})
+
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	processed := []ProcessedEmail{
+		{ID: "m2", File: "m2.eml", Date: date.Add(time.Hour)},
+		{ID: "m1", File: "m1.eml", Date: date},
+	}
+	if err := e.saveThunderbirdFolders(processed); err != nil {
+		t.Fatalf("saveThunderbirdFolders() error = %v", err)
+	}
+
+	inbox, err := os.ReadFile(filepath.Join(tbDir, "Inbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInbox := "From - Tue Jan  2 03:04:05 2024\nX-Mozilla-Status: 0005\nX-Mozilla-Status2: 00000000\n" +
+		"Subject: one\n\n>From the top\n\n"
+	if string(inbox) != wantInbox {
+		t.Errorf("Inbox = %q, want %q", inbox, wantInbox)
+	}
+
+	acme, err := os.ReadFile(filepath.Join(tbDir, "Clients.sbd", "Acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(acme), "From - ") != 2 || !strings.Contains(string(acme), "X-Mozilla-Status: 0000\nX-Mozilla-Status2: 00000000\nSubject: two\n\nbody\n\n") {
+		t.Errorf("Unexpected Clients/Acme folder: %q", acme)
+	}
+	if strings.Index(string(acme), "Subject: one") > strings.Index(string(acme), "Subject: two") {
+		t.Error("Expected messages oldest first")
+	}
+
+	if info, err := os.Stat(filepath.Join(tbDir, "Clients")); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty parent folder, got %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(tbDir, "Clients.sbd", "Acme.msf")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale summary to be removed, got %v", err)
+	}
+}
+
+func TestValidateConfig_ThunderbirdFormat(t *testing.T) {
+	config := &Config{
+		CredentialsFile: "credentials.json",
+		TokenFile:       "token.json",
+		OutputDir:       "out",
+		Format:          "txt",
+		ThunderbirdDir:  "tb",
+	}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected Thunderbird folders of a txt export to be rejected")
+	}
+}
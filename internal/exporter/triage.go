@@ -0,0 +1,55 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/triage"
+)
+
+// buildTriageReport triages the exported eml files of the processed emails.
+// Files that cannot be read or parsed are left out with a warning.
+func (e *Exporter) buildTriageReport(processedEmails []ProcessedEmail) *triage.Report {
+	report := triage.NewReport()
+	for _, email := range processedEmails {
+		raw, err := os.ReadFile(filepath.Join(e.config.OutputDir, filepath.FromSlash(email.File)))
+		if err != nil {
+			logrus.WithError(err).WithField("message_id", email.ID).Warn("Failed to read message for triage")
+			continue
+		}
+
+		message, err := triage.Analyze(email.ID, email.File, raw)
+		if err != nil {
+			logrus.WithError(err).WithField("message_id", email.ID).Warn("Failed to parse message for triage")
+			continue
+		}
+		report.Add(message)
+	}
+	return report
+}
+
+// saveTriageReport writes the triage report of the processed emails
+func (e *Exporter) saveTriageReport(processedEmails []ProcessedEmail) error {
+	report := e.buildTriageReport(processedEmails)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal triage report: %w", err)
+	}
+
+	path := filepath.Join(e.config.OutputDir, triage.ReportFileName)
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write triage report: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"messages": report.Summary.Messages,
+		"report":   path,
+	}).Info("Saved triage report")
+	return nil
+}
@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/triage"
+)
+
+func TestSaveTriageReport(t *testing.T) {
+	dir := t.TempDir()
+	raw := "Authentication-Results: mx.google.com; spf=fail smtp.mailfrom=x@spam.example\r\n" +
+		"From: x@spam.example\r\n" +
+		"Subject: Prize\r\n" +
+		"\r\n" +
+		"Claim at http://prize.example/claim\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "m1.eml"), []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Exporter{config: &Config{OutputDir: dir, Triage: true}}
+	processed := []ProcessedEmail{
+		{ID: "m1", File: "m1.eml"},
+		{ID: "m2", File: "missing.eml"},
+	}
+	if err := e.saveTriageReport(processed); err != nil {
+		t.Fatalf("saveTriageReport() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, triage.ReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report triage.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Messages) != 1 {
+		t.Fatalf("Expected the readable message only, got %d", len(report.Messages))
+	}
+	if report.Messages[0].SPF != "fail" || len(report.Messages[0].URLs) != 1 {
+		t.Errorf("Unexpected triage: %+v", report.Messages[0])
+	}
+}
+
+func TestValidateConfig_TriageFormat(t *testing.T) {
+	config := &Config{
+		CredentialsFile: "credentials.json",
+		TokenFile:       "token.json",
+		OutputDir:       "out",
+		Format:          "json",
+		Triage:          true,
+	}
+	if err := validateConfig(config); err == nil {
+		t.Error("Expected triage of a json export to be rejected")
+	}
+}
@@ -0,0 +1,309 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+// Supported date window sizes for splitting large exports
+const (
+	SplitByDay   = "day"
+	SplitByWeek  = "week"
+	SplitByMonth = "month"
+	SplitByYear  = "year"
+)
+
+// Window states recorded in the export state file
+const (
+	windowPending = "pending"
+	windowDone    = "done"
+)
+
+var validSplitBy = []string{SplitByDay, SplitByWeek, SplitByMonth, SplitByYear}
+
+// gmailLaunch is the earliest date a Gmail message can have, used as the
+// start of the first window when the filter has no lower date bound
+var gmailLaunch = time.Date(2004, 4, 1, 0, 0, 0, 0, time.UTC)
+
+// dateWindow is a half-open date range [Start, End)
+type dateWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// String returns a human-readable label for the window
+func (w dateWindow) String() string {
+	return fmt.Sprintf("%s..%s", w.Start.Format("2006-01-02"), w.End.Format("2006-01-02"))
+}
+
+// windowState records the progress of a single date window
+type windowState struct {
+	dateWindow
+	Status   string `json:"status"`
+	Matched  int    `json:"matched"`
+	Exported int    `json:"exported"`
+	Failed   int    `json:"failed"`
+}
+
+// exportState is persisted between runs so split exports can resume at the
+// first unfinished window
+type exportState struct {
+	Query   string        `json:"query"`
+	SplitBy string        `json:"split_by"`
+	Updated time.Time     `json:"updated"`
+	Windows []windowState `json:"windows"`
+}
+
+// isValidSplitBy reports whether splitBy is a supported window size
+func isValidSplitBy(splitBy string) bool {
+	for _, valid := range validSplitBy {
+		if splitBy == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// nextWindowStart returns the start of the window following the one containing t.
+// Month and year windows are aligned to calendar boundaries.
+func nextWindowStart(t time.Time, splitBy string) time.Time {
+	switch splitBy {
+	case SplitByDay:
+		return t.AddDate(0, 0, 1)
+	case SplitByWeek:
+		return t.AddDate(0, 0, 7)
+	case SplitByYear:
+		return time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// splitDateRange partitions [start, end) into consecutive windows
+func splitDateRange(start, end time.Time, splitBy string) []dateWindow {
+	var windows []dateWindow
+	for current := start; current.Before(end); {
+		next := nextWindowStart(current, splitBy)
+		if next.After(end) {
+			next = end
+		}
+		windows = append(windows, dateWindow{Start: current, End: next})
+		current = next
+	}
+	return windows
+}
+
+// dateRangeFor returns the overall date range covered by a filter
+func dateRangeFor(filterConfig *filters.Config, now time.Time) (start, end time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	start = gmailLaunch
+	if filterConfig.DateWithin > 0 {
+		within := today.Add(-filterConfig.DateWithin)
+		start = time.Date(within.Year(), within.Month(), within.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if filterConfig.DateAfter != nil && filterConfig.DateAfter.After(start) {
+		start = *filterConfig.DateAfter
+	}
+
+	end = today.AddDate(0, 0, 1)
+	if filterConfig.OlderThan > 0 {
+		older := today.Add(-filterConfig.OlderThan)
+		end = time.Date(older.Year(), older.Month(), older.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if filterConfig.DateBefore != nil && filterConfig.DateBefore.Before(end) {
+		end = *filterConfig.DateBefore
+	}
+
+	return start, end
+}
+
+// windowFilter returns a copy of filterConfig restricted to a single window
+func windowFilter(filterConfig *filters.Config, window dateWindow) *filters.Config {
+	windowed := *filterConfig
+	start, end := window.Start, window.End
+	windowed.DateAfter = &start
+	windowed.DateBefore = &end
+	windowed.DateWithin = 0
+	windowed.OlderThan = 0
+	return &windowed
+}
+
+// exportByWindows exports the matching emails one date window at a time,
+// persisting per-window state so an interrupted run can resume
+func (e *Exporter) exportByWindows(filterConfig *filters.Config) (*Result, error) {
+	state := e.initWindowState(filterConfig)
+	total := &Result{Failures: make([]Failure, 0)}
+
+	for position, i := range windowOrder(len(state.Windows), e.config.Order) {
+		window := &state.Windows[i]
+		logger := logrus.WithFields(logrus.Fields{
+			"window":   window.dateWindow.String(),
+			"position": fmt.Sprintf("%d/%d", position+1, len(state.Windows)),
+		})
+
+		if window.Status == windowDone {
+			logger.Debug("Skipping window completed by a previous run")
+			continue
+		}
+
+		remaining := 0
+		if e.config.Limit > 0 {
+			remaining = e.config.Limit - total.TotalMatched
+			if remaining <= 0 {
+				logger.Info("Limit reached, stopping before remaining windows")
+				break
+			}
+		}
+
+		logger.Info("Exporting date window")
+		result, err := e.exportMatching(windowFilter(filterConfig, window.dateWindow), remaining)
+		if err != nil {
+			e.saveWindowState(state)
+			return nil, fmt.Errorf("failed to export window %s: %w", window.dateWindow, err)
+		}
+
+		// A cancelled window is left pending so a resumed run finishes it
+		if e.pause.isStopped() {
+			mergeResult(total, result)
+			e.saveWindowState(state)
+			logger.Info("Export cancelled, stopping before remaining windows")
+			break
+		}
+
+		// A window the limit cut short is left pending too, or a resumed run
+		// would never export the rest of it
+		if !result.truncated {
+			window.Status = windowDone
+		}
+		window.Matched += result.TotalMatched
+		window.Exported += result.TotalExported
+		window.Failed += result.TotalFailed
+		mergeResult(total, result)
+		e.saveWindowState(state)
+
+		logger.WithFields(logrus.Fields{
+			"matched":  result.TotalMatched,
+			"exported": result.TotalExported,
+			"failed":   result.TotalFailed,
+		}).Info("Finished date window")
+	}
+
+	return total, nil
+}
+
+// initWindowState builds the window list for a filter, reusing the state of a
+// previous run when resuming the same query
+func (e *Exporter) initWindowState(filterConfig *filters.Config) *exportState {
+	query := filterConfig.BuildGmailQuery()
+
+	if e.config.Resume {
+		previous, err := loadExportState(e.statePath())
+		switch {
+		case err != nil:
+			logrus.WithError(err).Warn("Failed to load export state, starting windows from scratch")
+		case previous != nil && previous.Query == query && previous.SplitBy == e.config.SplitBy:
+			logrus.WithField("state_file", e.statePath()).Info("Resuming split export from state file")
+			return previous
+		case previous != nil:
+			logrus.Warn("Export state was written for a different query, starting windows from scratch")
+		}
+	}
+
+	start, end := dateRangeFor(filterConfig, time.Now())
+	state := &exportState{
+		Query:   query,
+		SplitBy: e.config.SplitBy,
+	}
+	for _, window := range splitDateRange(start, end, e.config.SplitBy) {
+		state.Windows = append(state.Windows, windowState{dateWindow: window, Status: windowPending})
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"split_by": e.config.SplitBy,
+		"windows":  len(state.Windows),
+		"from":     start.Format("2006-01-02"),
+		"to":       end.Format("2006-01-02"),
+	}).Info("Split export into date windows")
+
+	return state
+}
+
+// statePath returns the path of the export state file
+func (e *Exporter) statePath() string {
+	if e.config.StateFile != "" {
+		return e.config.StateFile
+	}
+	return filepath.Join(e.config.OutputDir, ".export_state.json")
+}
+
+// saveWindowState writes the export state file, logging rather than failing on error
+func (e *Exporter) saveWindowState(state *exportState) {
+	state.Updated = time.Now()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to marshal export state")
+		return
+	}
+
+	if err := atomicfile.WriteFileBackup(e.statePath(), data, 0o600); err != nil {
+		logrus.WithError(err).Warn("Failed to write export state")
+	}
+}
+
+// loadExportState reads an export state file. A missing file yields nil state.
+func loadExportState(path string) (*exportState, error) {
+	var state exportState
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		state = exportState{}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("failed to parse state file: %w", err)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// mergeResult adds the counts and failures of src into dst
+func mergeResult(dst, src *Result) {
+	dst.TotalMatched += src.TotalMatched
+	dst.TotalExported += src.TotalExported
+	dst.TotalFailed += src.TotalFailed
+	dst.TotalSkipped += src.TotalSkipped
+	dst.TotalSize += src.TotalSize
+	dst.TotalLinked += src.TotalLinked
+	dst.TotalDeleted += src.TotalDeleted
+	dst.SearchRefreshes += src.SearchRefreshes
+	dst.TotalRemaining += src.TotalRemaining
+	dst.Failures = append(dst.Failures, src.Failures...)
+
+	for reason, count := range src.SkippedByReason {
+		if dst.SkippedByReason == nil {
+			dst.SkippedByReason = make(map[string]int)
+		}
+		dst.SkippedByReason[reason] += count
+	}
+
+	for category, count := range src.FailedByCategory {
+		if dst.FailedByCategory == nil {
+			dst.FailedByCategory = make(map[string]int)
+		}
+		dst.FailedByCategory[category] += count
+	}
+}
@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestSplitDateRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   time.Time
+		end     time.Time
+		splitBy string
+		want    []dateWindow
+	}{
+		{
+			name:    "months aligned to calendar",
+			start:   date(2024, 1, 15),
+			end:     date(2024, 3, 10),
+			splitBy: SplitByMonth,
+			want: []dateWindow{
+				{date(2024, 1, 15), date(2024, 2, 1)},
+				{date(2024, 2, 1), date(2024, 3, 1)},
+				{date(2024, 3, 1), date(2024, 3, 10)},
+			},
+		},
+		{
+			name:    "years",
+			start:   date(2022, 6, 1),
+			end:     date(2024, 1, 1),
+			splitBy: SplitByYear,
+			want: []dateWindow{
+				{date(2022, 6, 1), date(2023, 1, 1)},
+				{date(2023, 1, 1), date(2024, 1, 1)},
+			},
+		},
+		{
+			name:    "weeks",
+			start:   date(2024, 1, 1),
+			end:     date(2024, 1, 10),
+			splitBy: SplitByWeek,
+			want: []dateWindow{
+				{date(2024, 1, 1), date(2024, 1, 8)},
+				{date(2024, 1, 8), date(2024, 1, 10)},
+			},
+		},
+		{
+			name:    "days",
+			start:   date(2024, 2, 28),
+			end:     date(2024, 3, 1),
+			splitBy: SplitByDay,
+			want: []dateWindow{
+				{date(2024, 2, 28), date(2024, 2, 29)},
+				{date(2024, 2, 29), date(2024, 3, 1)},
+			},
+		},
+		{
+			name:    "empty range",
+			start:   date(2024, 1, 1),
+			end:     date(2024, 1, 1),
+			splitBy: SplitByDay,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitDateRange(tt.start, tt.end, tt.splitBy)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d windows, got %d: %v", len(tt.want), len(got), got)
+			}
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+					t.Errorf("Window %d: expected %s, got %s", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDateRangeFor(t *testing.T) {
+	now := time.Date(2024, 6, 15, 13, 45, 0, 0, time.UTC)
+
+	t.Run("no date bounds", func(t *testing.T) {
+		start, end := dateRangeFor(&filters.Config{}, now)
+		if !start.Equal(gmailLaunch) {
+			t.Errorf("Expected start %v, got %v", gmailLaunch, start)
+		}
+		if !end.Equal(date(2024, 6, 16)) {
+			t.Errorf("Expected end 2024-06-16, got %v", end)
+		}
+	})
+
+	t.Run("explicit bounds", func(t *testing.T) {
+		after, before := date(2023, 1, 1), date(2023, 7, 1)
+		start, end := dateRangeFor(&filters.Config{DateAfter: &after, DateBefore: &before}, now)
+		if !start.Equal(after) || !end.Equal(before) {
+			t.Errorf("Expected %v..%v, got %v..%v", after, before, start, end)
+		}
+	})
+
+	t.Run("date within", func(t *testing.T) {
+		start, _ := dateRangeFor(&filters.Config{DateWithin: 10 * 24 * time.Hour}, now)
+		if !start.Equal(date(2024, 6, 5)) {
+			t.Errorf("Expected start 2024-06-05, got %v", start)
+		}
+	})
+
+	t.Run("older than", func(t *testing.T) {
+		_, end := dateRangeFor(&filters.Config{OlderThan: 10 * 24 * time.Hour}, now)
+		if !end.Equal(date(2024, 6, 5)) {
+			t.Errorf("Expected end 2024-06-05, got %v", end)
+		}
+	})
+}
+
+func TestWindowFilter(t *testing.T) {
+	original := &filters.Config{From: "a@example.com", DateWithin: time.Hour, OlderThan: time.Minute}
+	window := dateWindow{Start: date(2024, 1, 1), End: date(2024, 2, 1)}
+
+	windowed := windowFilter(original, window)
+
+	if windowed.DateWithin != 0 || windowed.OlderThan != 0 {
+		t.Error("Expected DateWithin and OlderThan to be cleared")
+	}
+	if !windowed.DateAfter.Equal(window.Start) || !windowed.DateBefore.Equal(window.End) {
+		t.Errorf("Expected window bounds, got %v..%v", windowed.DateAfter, windowed.DateBefore)
+	}
+	if original.DateAfter != nil || original.DateWithin != time.Hour {
+		t.Error("Expected original filter to be unchanged")
+	}
+	if windowed.From != "a@example.com" {
+		t.Error("Expected other criteria to be preserved")
+	}
+}
+
+func TestExportStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	e := &Exporter{config: &Config{OutputDir: dir, SplitBy: SplitByMonth}}
+
+	if got := e.statePath(); got != filepath.Join(dir, ".export_state.json") {
+		t.Errorf("Unexpected default state path: %s", got)
+	}
+
+	state, err := loadExportState(e.statePath())
+	if err != nil || state != nil {
+		t.Fatalf("Expected no state for missing file, got %v, %v", state, err)
+	}
+
+	saved := &exportState{
+		Query:   "from:a@example.com",
+		SplitBy: SplitByMonth,
+		Windows: []windowState{
+			{dateWindow: dateWindow{date(2024, 1, 1), date(2024, 2, 1)}, Status: windowDone, Matched: 3, Exported: 3},
+			{dateWindow: dateWindow{date(2024, 2, 1), date(2024, 3, 1)}, Status: windowPending},
+		},
+	}
+	e.saveWindowState(saved)
+
+	loaded, err := loadExportState(e.statePath())
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.Query != saved.Query || len(loaded.Windows) != 2 {
+		t.Fatalf("Unexpected state: %+v", loaded)
+	}
+	if loaded.Windows[0].Status != windowDone || loaded.Windows[0].Exported != 3 {
+		t.Errorf("Unexpected first window: %+v", loaded.Windows[0])
+	}
+	if !loaded.Windows[1].Start.Equal(date(2024, 2, 1)) {
+		t.Errorf("Unexpected second window start: %v", loaded.Windows[1].Start)
+	}
+}
+
+func TestMergeResult(t *testing.T) {
+	total := &Result{}
+	mergeResult(total, &Result{TotalMatched: 2, TotalExported: 1, TotalFailed: 1, Failures: []Failure{{EmailID: "a"}}})
+	mergeResult(total, &Result{TotalMatched: 3, TotalExported: 2, TotalSkipped: 1, SkippedByReason: map[string]int{SkipReasonSize: 1}})
+
+	if total.TotalMatched != 5 || total.TotalExported != 3 || total.TotalFailed != 1 || total.TotalSkipped != 1 {
+		t.Errorf("Unexpected totals: %+v", total)
+	}
+	if len(total.Failures) != 1 || total.SkippedByReason[SkipReasonSize] != 1 {
+		t.Errorf("Unexpected failures or skips: %+v", total)
+	}
+}
+
+func TestIsValidSplitBy(t *testing.T) {
+	for _, valid := range validSplitBy {
+		if !isValidSplitBy(valid) {
+			t.Errorf("Expected %q to be valid", valid)
+		}
+	}
+	if isValidSplitBy("quarter") {
+		t.Error("Expected quarter to be invalid")
+	}
+}
@@ -0,0 +1,166 @@
+package exporter
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Worker auto-tuning bounds
+const (
+	// minAutoWorkers is the smallest worker count auto mode will choose
+	minAutoWorkers = 1
+	// maxAutoWorkers caps auto mode so a single run cannot exhaust per-user quota
+	maxAutoWorkers = 16
+	// workersPerCPU allows several workers per core since exports are I/O bound
+	workersPerCPU = 4
+	// defaultQPSBudget approximates Gmail's per-user limit for messages.get
+	// (250 quota units/sec at 5 units per call) when no cap is configured
+	defaultQPSBudget = 50.0
+	// defaultAPILatency is assumed when no latency sample is available
+	defaultAPILatency = 200 * time.Millisecond
+)
+
+// workerPlan describes the inputs and outcome of automatic worker selection
+type workerPlan struct {
+	CPUs      int
+	Latency   time.Duration
+	QPSCap    float64
+	Messages  int
+	Workers   int
+	LimitedBy string
+	CPUBound  int
+	QPSBound  int
+}
+
+// autoWorkerCount chooses a worker count from CPU count, observed API latency
+// and the QPS budget. Each worker issues one call per latency period, so
+// QPS * latency workers are enough to saturate the budget.
+func autoWorkerCount(cpus int, latency time.Duration, qpsCap float64, messages int) workerPlan {
+	plan := workerPlan{
+		CPUs:     cpus,
+		Latency:  latency,
+		QPSCap:   qpsCap,
+		Messages: messages,
+	}
+
+	if plan.CPUs < 1 {
+		plan.CPUs = 1
+	}
+	if plan.Latency <= 0 {
+		plan.Latency = defaultAPILatency
+	}
+	budget := plan.QPSCap
+	if budget <= 0 {
+		budget = defaultQPSBudget
+	}
+
+	plan.CPUBound = plan.CPUs * workersPerCPU
+	plan.QPSBound = int(math.Ceil(budget * plan.Latency.Seconds()))
+	if plan.QPSBound < minAutoWorkers {
+		plan.QPSBound = minAutoWorkers
+	}
+
+	plan.Workers, plan.LimitedBy = plan.CPUBound, "cpu"
+	if plan.QPSBound < plan.Workers {
+		plan.Workers, plan.LimitedBy = plan.QPSBound, "qps"
+	}
+	if plan.Workers > maxAutoWorkers {
+		plan.Workers, plan.LimitedBy = maxAutoWorkers, "max_workers"
+	}
+	if messages > 0 && messages < plan.Workers {
+		plan.Workers, plan.LimitedBy = messages, "messages"
+	}
+	if plan.Workers < minAutoWorkers {
+		plan.Workers = minAutoWorkers
+	}
+
+	return plan
+}
+
+// resolveWorkerCount returns the configured worker count, or picks one
+// automatically when the configuration leaves it at 0
+func (e *Exporter) resolveWorkerCount(messages int) int {
+	if e.config.ParallelWorkers > 0 {
+		return e.config.ParallelWorkers
+	}
+
+	plan := autoWorkerCount(runtime.NumCPU(), e.apiLatency.average(), e.config.MaxQPS, messages)
+
+	logrus.WithFields(logrus.Fields{
+		"workers":     plan.Workers,
+		"limited_by":  plan.LimitedBy,
+		"cpus":        plan.CPUs,
+		"cpu_bound":   plan.CPUBound,
+		"api_latency": plan.Latency,
+		"qps_cap":     plan.QPSCap,
+		"qps_bound":   plan.QPSBound,
+		"messages":    plan.Messages,
+	}).Info("Auto-selected parallel worker count (override with --parallel-workers)")
+
+	return plan.Workers
+}
+
+// latencyTracker keeps a running average of observed API call latency
+type latencyTracker struct {
+	mu    sync.Mutex
+	total time.Duration
+	count int
+}
+
+// observe records a single API call duration
+func (l *latencyTracker) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total += d
+	l.count++
+}
+
+// average returns the mean observed latency, or 0 when nothing was observed
+func (l *latencyTracker) average() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return 0
+	}
+	return l.total / time.Duration(l.count)
+}
+
+// rateLimiter spaces API calls so that at most qps calls start per second
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter creates a limiter for the given QPS, or nil when qps <= 0
+func newRateLimiter(qps float64) *rateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / qps)}
+}
+
+// Wait blocks until the caller may issue its next API call. A nil limiter
+// never blocks.
+func (r *rateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,131 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoWorkerCount(t *testing.T) {
+	tests := []struct {
+		name          string
+		cpus          int
+		latency       time.Duration
+		qpsCap        float64
+		messages      int
+		expected      int
+		expectedLimit string
+	}{
+		{
+			name:          "qps bound with default budget",
+			cpus:          8,
+			latency:       100 * time.Millisecond,
+			qpsCap:        0,
+			messages:      1000,
+			expected:      5,
+			expectedLimit: "qps",
+		},
+		{
+			name:          "explicit qps cap",
+			cpus:          8,
+			latency:       500 * time.Millisecond,
+			qpsCap:        10,
+			messages:      1000,
+			expected:      5,
+			expectedLimit: "qps",
+		},
+		{
+			name:          "cpu bound",
+			cpus:          1,
+			latency:       time.Second,
+			qpsCap:        0,
+			messages:      1000,
+			expected:      4,
+			expectedLimit: "cpu",
+		},
+		{
+			name:          "capped at max workers",
+			cpus:          32,
+			latency:       2 * time.Second,
+			qpsCap:        0,
+			messages:      1000,
+			expected:      maxAutoWorkers,
+			expectedLimit: "max_workers",
+		},
+		{
+			name:          "fewer messages than workers",
+			cpus:          8,
+			latency:       time.Second,
+			qpsCap:        0,
+			messages:      2,
+			expected:      2,
+			expectedLimit: "messages",
+		},
+		{
+			name:          "no latency sample uses default",
+			cpus:          8,
+			latency:       0,
+			qpsCap:        0,
+			messages:      1000,
+			expected:      10,
+			expectedLimit: "qps",
+		},
+		{
+			name:          "tiny qps cap still yields one worker",
+			cpus:          8,
+			latency:       10 * time.Millisecond,
+			qpsCap:        1,
+			messages:      1000,
+			expected:      1,
+			expectedLimit: "qps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := autoWorkerCount(tt.cpus, tt.latency, tt.qpsCap, tt.messages)
+			if plan.Workers != tt.expected {
+				t.Errorf("autoWorkerCount() workers = %d, want %d", plan.Workers, tt.expected)
+			}
+			if plan.LimitedBy != tt.expectedLimit {
+				t.Errorf("autoWorkerCount() limited by %q, want %q", plan.LimitedBy, tt.expectedLimit)
+			}
+		})
+	}
+}
+
+func TestLatencyTracker(t *testing.T) {
+	var tracker latencyTracker
+
+	if avg := tracker.average(); avg != 0 {
+		t.Errorf("Expected 0 average with no samples, got %v", avg)
+	}
+
+	tracker.observe(100 * time.Millisecond)
+	tracker.observe(300 * time.Millisecond)
+
+	if avg := tracker.average(); avg != 200*time.Millisecond {
+		t.Errorf("Expected 200ms average, got %v", avg)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if limiter := newRateLimiter(0); limiter != nil {
+		t.Error("Expected nil limiter when qps is 0")
+	}
+
+	// A nil limiter must never block
+	var nilLimiter *rateLimiter
+	nilLimiter.Wait()
+
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.Wait()
+	}
+	elapsed := time.Since(start)
+
+	// 5 calls at 100 QPS: the first is immediate, the next four are 10ms apart
+	if elapsed < 35*time.Millisecond {
+		t.Errorf("Expected limiter to space calls, elapsed %v", elapsed)
+	}
+}
@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// exportedFile describes a message file written to the output directory
+type exportedFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+
+	// Copies are the copies or hardlinks of Path in other label directories
+	// and Labels the message labels, set in organize-by-labels mode
+	Copies []string
+	Labels []string
+
+	// RawSHA256 is the checksum of the raw message, set in legal hold mode
+	RawSHA256 string
+
+	// State lists the message's UNREAD, STARRED and IMPORTANT labels
+	State []string
+}
+
+// writeExportFile writes data under a temporary name and renames it into
+// place once complete, so an interrupted write never leaves a truncated file
+// under the final name. With Fsync the file and its directory are synced to
+// disk too.
+func (e *Exporter) writeExportFile(path string, data []byte) (exportedFile, error) {
+	return e.writeExportStream(path, bytes.NewReader(data))
+}
+
+// writeExportStream writes everything read from r like writeExportFile,
+// copying through a bounded buffer
+func (e *Exporter) writeExportStream(path string, r io.Reader) (exportedFile, error) {
+	hash := sha256.New()
+	var size int64
+	err := atomicfile.Write(path, 0o600, atomicfile.Options{Sync: e.config.Fsync}, func(w io.Writer) error {
+		var err error
+		size, err = io.Copy(io.MultiWriter(w, hash), r)
+		return err
+	})
+	if err != nil {
+		return exportedFile{}, err
+	}
+
+	return exportedFile{Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// removePartialFiles deletes temporary files left behind by an interrupted
+// run
+func removePartialFiles(outputDir string) {
+	removed := 0
+	err := filepath.WalkDir(outputDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && atomicfile.IsTemp(d.Name()) {
+			if err := os.Remove(path); err == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to remove partially written files")
+	}
+	if removed > 0 {
+		logrus.WithField("count", removed).Info("Removed partially written files from a previous run")
+	}
+}
+
+// verifyProcessed drops processed emails whose export file is missing or has
+// a different size than recorded, so they are exported again on resume
+func (e *Exporter) verifyProcessed(processedEmails []ProcessedEmail) []ProcessedEmail {
+	verified := processedEmails[:0]
+	for _, email := range processedEmails {
+		if email.File != "" {
+			info, err := os.Stat(filepath.Join(e.config.OutputDir, email.File))
+			if err != nil || info.Size() != email.Size {
+				logrus.WithField("file", email.File).Warn("Exported file is missing or incomplete, exporting again")
+				continue
+			}
+		}
+		verified = append(verified, email)
+	}
+	return verified
+}
@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+func TestWriteExportFile(t *testing.T) {
+	for _, fsync := range []bool{false, true} {
+		dir := t.TempDir()
+		e := &Exporter{config: &Config{OutputDir: dir, Fsync: fsync}}
+
+		data := []byte("Subject: test\r\n\r\nbody\r\n")
+		path := filepath.Join(dir, "abc.eml")
+
+		file, err := e.writeExportFile(path, data)
+		if err != nil {
+			t.Fatalf("writeExportFile() error = %v", err)
+		}
+
+		sum := sha256.Sum256(data)
+		if file.Path != path || file.Size != int64(len(data)) || file.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("writeExportFile() = %+v", file)
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "abc.eml" {
+			t.Errorf("directory holds %v, want only abc.eml", entries)
+		}
+	}
+}
+
+func TestRemovePartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "INBOX")
+	if err := os.MkdirAll(sub, 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]bool{
+		filepath.Join(dir, "a.eml"):                            true,
+		filepath.Join(dir, ".a.eml.123"+atomicfile.TempSuffix): false,
+		filepath.Join(sub, ".b.eml.456"+atomicfile.TempSuffix): false,
+		filepath.Join(sub, "notes"+atomicfile.TempSuffix):      true,
+		filepath.Join(dir, "processed_emails.json"):            true,
+	}
+	for path := range files {
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removePartialFiles(dir)
+
+	for path, keep := range files {
+		_, err := os.Stat(path)
+		if exists := err == nil; exists != keep {
+			t.Errorf("%s exists = %v, want %v", path, exists, keep)
+		}
+	}
+}
+
+func TestVerifyProcessed(t *testing.T) {
+	dir := t.TempDir()
+	e := &Exporter{config: &Config{OutputDir: dir}}
+
+	if err := os.WriteFile(filepath.Join(dir, "complete.eml"), []byte("12345"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "short.eml"), []byte("12"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	processed := []ProcessedEmail{
+		{ID: "complete", File: "complete.eml", Size: 5},
+		{ID: "short", File: "short.eml", Size: 5},
+		{ID: "missing", File: "missing.eml", Size: 5},
+		{ID: "legacy", Size: 5},
+	}
+
+	got := e.verifyProcessed(processed)
+	if len(got) != 2 || got[0].ID != "complete" || got[1].ID != "legacy" {
+		t.Errorf("verifyProcessed() = %+v", got)
+	}
+}
@@ -0,0 +1,127 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+)
+
+// Category classifies why a message operation failed
+type Category string
+
+// Failure categories recorded in results and metrics
+const (
+	Auth      Category = "auth"
+	RateLimit Category = "rate_limit"
+	NotFound  Category = "not_found"
+	Network   Category = "network"
+	Disk      Category = "disk"
+	Server    Category = "server"
+	Unknown   Category = "unknown"
+)
+
+// Categorize returns the failure category of err
+func Categorize(err error) Category {
+	if err == nil {
+		return Unknown
+	}
+
+	if errors.Is(err, auth.ErrNotAuthenticated) {
+		return Auth
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return Auth
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return categorizeAPIError(apiErr)
+	}
+
+	var statusErr statusCoder
+	if errors.As(err, &statusErr) {
+		return categorizeStatus(statusErr.HTTPStatusCode())
+	}
+
+	if isDiskError(err) {
+		return Disk
+	}
+
+	if isNetworkError(err) {
+		return Network
+	}
+
+	return Unknown
+}
+
+// statusCoder is implemented by errors from other HTTP APIs, such as
+// Microsoft Graph, that carry a response status
+type statusCoder interface {
+	HTTPStatusCode() int
+}
+
+// categorizeAPIError classifies a Gmail API error by status code and reason
+func categorizeAPIError(apiErr *googleapi.Error) Category {
+	if apiErr.Code == http.StatusForbidden {
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return RateLimit
+			}
+		}
+	}
+
+	return categorizeStatus(apiErr.Code)
+}
+
+// categorizeStatus classifies an HTTP API error by status code
+func categorizeStatus(code int) Category {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return Auth
+	case code == http.StatusTooManyRequests:
+		return RateLimit
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return NotFound
+	case code >= http.StatusInternalServerError:
+		return Server
+	default:
+		return Unknown
+	}
+}
+
+// isDiskError reports whether err came from the local filesystem
+func isDiskError(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) || errors.Is(err, syscall.EROFS) {
+		return true
+	}
+
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
+
+// isNetworkError reports whether err is a transport-level failure
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,58 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+)
+
+func TestCategorize(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Category
+	}{
+		{"nil", nil, Unknown},
+		{"not authenticated", fmt.Errorf("failed: %w", auth.ErrNotAuthenticated), Auth},
+		{"token refresh", &oauth2.RetrieveError{}, Auth},
+		{"401", &googleapi.Error{Code: 401}, Auth},
+		{"403 forbidden", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "insufficientPermissions"}}}, Auth},
+		{"403 rate limit", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, RateLimit},
+		{"429", fmt.Errorf("failed to get message: %w", &googleapi.Error{Code: 429}), RateLimit},
+		{"404", &googleapi.Error{Code: 404}, NotFound},
+		{"503", &googleapi.Error{Code: 503}, Server},
+		{"400", &googleapi.Error{Code: 400}, Unknown},
+		{"disk full", fmt.Errorf("failed to write EML file: %w", &fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}), Disk},
+		{"permission denied", &fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}, Disk},
+		{"connection reset", &url.Error{Op: "Get", URL: "https://example.com", Err: syscall.ECONNRESET}, Network},
+		{"dial error", &net.OpError{Op: "dial", Err: errors.New("refused")}, Network},
+		{"timeout", context.DeadlineExceeded, Network},
+		{"other API 429", fmt.Errorf("failed to upload: %w", statusError(429)), RateLimit},
+		{"other API 403", statusError(403), Auth},
+		{"other", errors.New("boom"), Unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Categorize(tt.err); got != tt.want {
+				t.Errorf("Categorize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// statusError is an HTTP API error from a non-Google service
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
 )
 
 // Config represents email filtering configuration
@@ -16,37 +18,82 @@ type Config struct {
 	IncludesWords string `json:"includes_words,omitempty"`
 	ExcludesWords string `json:"excludes_words,omitempty"`
 
+	// OR groups: a message matches a group when it matches any of its values.
+	// AnyOf holds Gmail search expressions, each matched as a whole, so
+	// "from:a has:attachment" and "label:b" match either combination.
+	FromAny    []string `json:"from_any,omitempty"`
+	ToAny      []string `json:"to_any,omitempty"`
+	SubjectAny []string `json:"subject_any,omitempty"`
+	AnyOf      []string `json:"any_of,omitempty"`
+
 	// Size filters (in bytes)
 	SizeGreaterThan int64 `json:"size_greater_than,omitempty"`
 	SizeLessThan    int64 `json:"size_less_than,omitempty"`
+	// ExactSize enforces the size bounds client-side on the actual message
+	// size, since Gmail's size operators are approximate
+	ExactSize bool `json:"exact_size,omitempty"`
 
 	// Date filters
 	DateWithin time.Duration `json:"date_within,omitempty"`
+	// OlderThan matches messages received longer ago than the duration
+	OlderThan  time.Duration `json:"older_than,omitempty"`
 	DateAfter  *time.Time    `json:"date_after,omitempty"`
 	DateBefore *time.Time    `json:"date_before,omitempty"`
+	// Timezone, when set, is the time zone (an IANA name such as
+	// Europe/London, or Local) whose midnight starts the days of DateAfter
+	// and DateBefore. The dates are then sent as epoch seconds, since Gmail
+	// reads after:/before: dates in Pacific time.
+	Timezone string `json:"timezone,omitempty"`
 
 	// Boolean filters
 	HasAttachment *bool `json:"has_attachment,omitempty"`
 	ExcludeChats  bool  `json:"exclude_chats,omitempty"`
 
-	// Labels and search scope
+	// Filenames are attachment names or extensions (comma-separated), any of
+	// which a message must carry
+	Filenames string `json:"filenames,omitempty"`
+
+	// Message state filters: nil matches either state
+	IsStarred   *bool `json:"is_starred,omitempty"`
+	IsImportant *bool `json:"is_important,omitempty"`
+	IsUnread    *bool `json:"is_unread,omitempty"`
+
+	// Labels, search scope and inbox category tab
 	Labels      string `json:"labels,omitempty"`
 	SearchScope string `json:"search_scope,omitempty"`
+	Category    string `json:"category,omitempty"`
+}
+
+// ScopeAnywhere searches all mail including spam and trash, which all_mail
+// leaves out
+const ScopeAnywhere = "anywhere"
+
+// IncludesSpamTrash reports whether the search scope covers spam or trash,
+// whose messages Gmail deletes permanently after 30 days
+func (c *Config) IncludesSpamTrash() bool {
+	switch c.SearchScope {
+	case "spam", "trash", ScopeAnywhere:
+		return true
+	}
+	return false
 }
 
+// Categories are the inbox category tabs a filter can select
+var Categories = []string{"primary", "social", "promotions", "updates", "forums"}
+
 // BuildGmailQuery converts the filter configuration to a Gmail search query
 func (c *Config) BuildGmailQuery() string {
 	var parts []string
 
 	// Basic filters
 	if c.To != "" {
-		parts = append(parts, fmt.Sprintf("to:%s", c.To))
+		parts = append(parts, fmt.Sprintf("to:%s", quoteTerm(c.To)))
 	}
 	if c.From != "" {
-		parts = append(parts, fmt.Sprintf("from:%s", c.From))
+		parts = append(parts, fmt.Sprintf("from:%s", quoteTerm(c.From)))
 	}
 	if c.Subject != "" {
-		parts = append(parts, fmt.Sprintf("subject:(%s)", c.Subject))
+		parts = append(parts, subjectTerm(c.Subject))
 	}
 	if c.IncludesWords != "" {
 		parts = append(parts, c.IncludesWords)
@@ -58,12 +105,36 @@ func (c *Config) BuildGmailQuery() string {
 		}
 	}
 
+	// OR groups
+	var from, to, subjects, expressions []string
+	for _, value := range nonEmpty(c.FromAny) {
+		from = append(from, fmt.Sprintf("from:%s", quoteTerm(value)))
+	}
+	for _, value := range nonEmpty(c.ToAny) {
+		to = append(to, fmt.Sprintf("to:%s", quoteTerm(value)))
+	}
+	for _, value := range nonEmpty(c.SubjectAny) {
+		subjects = append(subjects, subjectTerm(value))
+	}
+	for _, expression := range nonEmpty(c.AnyOf) {
+		if !grouped(expression) && strings.ContainsAny(expression, " \t") {
+			expression = "(" + expression + ")"
+		}
+		expressions = append(expressions, expression)
+	}
+	for _, group := range [][]string{from, to, subjects, expressions} {
+		if term := anyOf(group); term != "" {
+			parts = append(parts, term)
+		}
+	}
+
 	// Size filters
-	if c.SizeGreaterThan > 0 {
-		parts = append(parts, fmt.Sprintf("size:%d", c.SizeGreaterThan))
+	sizeGreater, sizeLess := c.querySizeBounds()
+	if sizeGreater > 0 {
+		parts = append(parts, fmt.Sprintf("size:%d", sizeGreater))
 	}
-	if c.SizeLessThan > 0 {
-		parts = append(parts, fmt.Sprintf("-size:%d", c.SizeLessThan))
+	if sizeLess > 0 {
+		parts = append(parts, fmt.Sprintf("-size:%d", sizeLess))
 	}
 
 	// Date filters
@@ -71,11 +142,15 @@ func (c *Config) BuildGmailQuery() string {
 		days := int(c.DateWithin.Hours() / 24)
 		parts = append(parts, fmt.Sprintf("newer_than:%dd", days))
 	}
+	if c.OlderThan > 0 {
+		days := int(c.OlderThan.Hours() / 24)
+		parts = append(parts, fmt.Sprintf("older_than:%dd", days))
+	}
 	if c.DateAfter != nil {
-		parts = append(parts, fmt.Sprintf("after:%s", c.DateAfter.Format("2006/01/02")))
+		parts = append(parts, fmt.Sprintf("after:%s", c.queryDate(*c.DateAfter)))
 	}
 	if c.DateBefore != nil {
-		parts = append(parts, fmt.Sprintf("before:%s", c.DateBefore.Format("2006/01/02")))
+		parts = append(parts, fmt.Sprintf("before:%s", c.queryDate(*c.DateBefore)))
 	}
 
 	// Boolean filters
@@ -90,13 +165,42 @@ func (c *Config) BuildGmailQuery() string {
 		parts = append(parts, "-in:chats")
 	}
 
+	// Attachment names, OR-ed together
+	var filenames []string
+	for _, filename := range strings.Split(c.Filenames, ",") {
+		if filename = strings.TrimSpace(filename); filename != "" {
+			filenames = append(filenames, fmt.Sprintf("filename:%s", quoteTerm(filename)))
+		}
+	}
+	if term := anyOf(filenames); term != "" {
+		parts = append(parts, term)
+	}
+
+	// Message state filters
+	for _, state := range []struct {
+		value *bool
+		term  string
+	}{
+		{c.IsStarred, "is:starred"},
+		{c.IsImportant, "is:important"},
+		{c.IsUnread, "is:unread"},
+	} {
+		if state.value == nil {
+			continue
+		}
+		if *state.value {
+			parts = append(parts, state.term)
+		} else {
+			parts = append(parts, "-"+state.term)
+		}
+	}
+
 	// Labels
 	if c.Labels != "" {
-		labels := strings.Split(c.Labels, ",")
-		for _, label := range labels {
+		for _, label := range strings.Split(c.Labels, ",") {
 			label = strings.TrimSpace(label)
 			if label != "" {
-				parts = append(parts, fmt.Sprintf("label:%s", label))
+				parts = append(parts, fmt.Sprintf("label:%s", labels.SearchName(label)))
 			}
 		}
 	}
@@ -105,10 +209,120 @@ func (c *Config) BuildGmailQuery() string {
 	if c.SearchScope != "" && c.SearchScope != "all_mail" {
 		parts = append(parts, fmt.Sprintf("in:%s", c.SearchScope))
 	}
+	if c.Category != "" {
+		parts = append(parts, fmt.Sprintf("category:%s", strings.ToLower(c.Category)))
+	}
 
 	return strings.Join(parts, " ")
 }
 
+// specialChars are the characters Gmail's query parser treats as syntax
+// inside a term
+const specialChars = "\"(){}:"
+
+// grouped reports whether a value is already a Gmail group, such as
+// {a@example.com b@example.com} or (invoice receipt), and is passed on as is
+func grouped(value string) bool {
+	value = strings.TrimSpace(value)
+	return len(value) >= 2 &&
+		((value[0] == '{' && value[len(value)-1] == '}') || (value[0] == '(' && value[len(value)-1] == ')'))
+}
+
+// subjectTerm returns the subject: term for a value, grouping its words so
+// that they all have to be in the subject, or as a quoted phrase when the
+// value holds query syntax
+func subjectTerm(value string) string {
+	if grouped(value) || !strings.ContainsAny(value, specialChars) {
+		return fmt.Sprintf("subject:(%s)", strings.TrimSpace(strings.Trim(value, "()")))
+	}
+	return fmt.Sprintf("subject:%s", quotePhrase(value))
+}
+
+// anyOf joins search terms into a Gmail OR group, {a b}. A single term is
+// returned as is and no terms give "".
+func anyOf(terms []string) string {
+	switch len(terms) {
+	case 0:
+		return ""
+	case 1:
+		return terms[0]
+	default:
+		return "{" + strings.Join(terms, " ") + "}"
+	}
+}
+
+// nonEmpty returns the values with surrounding space trimmed, dropping
+// blank ones
+func nonEmpty(values []string) []string {
+	var kept []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// quoteTerm returns a value as a single search term, quoted when it holds
+// spaces or query syntax, so that from:John Smith becomes from:"John Smith"
+// rather than a search for the word Smith anywhere
+func quoteTerm(value string) string {
+	value = strings.TrimSpace(value)
+	if grouped(value) || !strings.ContainsAny(value, " \t"+specialChars) {
+		return value
+	}
+	return quotePhrase(value)
+}
+
+// quotePhrase wraps a value in double quotes. Gmail has no escape sequence
+// inside quotes, so double quotes in the value are dropped; Gmail ignores
+// such punctuation in phrase searches anyway.
+func quotePhrase(value string) string {
+	return `"` + strings.Join(strings.Fields(strings.ReplaceAll(value, `"`, " ")), " ") + `"`
+}
+
+// queryDate returns a date for an after: or before: term: the date itself,
+// or with a timezone the epoch seconds of its midnight in that zone
+func (c *Config) queryDate(date time.Time) string {
+	if c.Timezone == "" {
+		return date.Format("2006/01/02")
+	}
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		// Validate reports the bad zone; fall back to Gmail's own reading
+		return date.Format("2006/01/02")
+	}
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
+	return strconv.FormatInt(midnight.Unix(), 10)
+}
+
+// exactSizeSlack widens the Gmail size query when exact sizes are enforced
+// client-side, so messages whose approximate size falls just outside the
+// bounds are still fetched and checked
+const exactSizeSlack = 0.1
+
+// querySizeBounds returns the size bounds to send to Gmail
+func (c *Config) querySizeBounds() (greater, less int64) {
+	if !c.ExactSize {
+		return c.SizeGreaterThan, c.SizeLessThan
+	}
+
+	greater = int64(float64(c.SizeGreaterThan) * (1 - exactSizeSlack))
+	less = int64(float64(c.SizeLessThan) * (1 + exactSizeSlack))
+	return greater, less
+}
+
+// MatchesSize reports whether size (in bytes) lies within the configured size bounds
+func (c *Config) MatchesSize(size int64) bool {
+	if c.SizeGreaterThan > 0 && size <= c.SizeGreaterThan {
+		return false
+	}
+	if c.SizeLessThan > 0 && size >= c.SizeLessThan {
+		return false
+	}
+	return true
+}
+
 // Validate checks if the filter configuration is valid
 func (c *Config) Validate() error {
 	// Check for conflicting size filters
@@ -120,12 +334,23 @@ func (c *Config) Validate() error {
 	if c.DateAfter != nil && c.DateBefore != nil && c.DateAfter.After(*c.DateBefore) {
 		return fmt.Errorf("date-after must be before date-before")
 	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %s", c.Timezone)
+		}
+	}
+	if c.DateWithin > 0 && c.OlderThan > 0 && c.OlderThan >= c.DateWithin {
+		return fmt.Errorf("older-than must be shorter than date-within")
+	}
 
 	// Check for conflicting attachment filters
 	// Attachment filter conflicts are handled in the CLI layer
+	if c.HasAttachment != nil && !*c.HasAttachment && strings.TrimSpace(strings.ReplaceAll(c.Filenames, ",", "")) != "" {
+		return fmt.Errorf("filename cannot be combined with no-attachment")
+	}
 
 	// Validate search scope
-	validScopes := []string{"all_mail", "inbox", "sent", "drafts", "spam", "trash"}
+	validScopes := []string{"all_mail", "inbox", "sent", "drafts", "spam", "trash", ScopeAnywhere}
 	if c.SearchScope != "" {
 		valid := false
 		for _, scope := range validScopes {
@@ -139,6 +364,20 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate category tab
+	if c.Category != "" {
+		valid := false
+		for _, category := range Categories {
+			if strings.EqualFold(c.Category, category) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid category: %s (valid: %s)", c.Category, strings.Join(Categories, ", "))
+		}
+	}
+
 	return nil
 }
 
@@ -122,6 +122,56 @@ func TestConfig_BuildGmailQuery(t *testing.T) {
 			},
 			expected: "label:important label:work",
 		},
+		{
+			name: "nested labels with spaces",
+			config: Config{
+				Labels: "Projects/Apollo Launch, To do",
+			},
+			expected: "label:Projects-Apollo-Launch label:To-do",
+		},
+		{
+			name: "sender name with spaces",
+			config: Config{
+				From: "John Smith",
+				To:   "team@example.com",
+			},
+			expected: `to:team@example.com from:"John Smith"`,
+		},
+		{
+			name: "quotes inside a quoted value are dropped",
+			config: Config{
+				From: `"Acme" Billing`,
+			},
+			expected: `from:"Acme Billing"`,
+		},
+		{
+			name: "sender group passed on",
+			config: Config{
+				From: "{alice@example.com bob@example.com}",
+			},
+			expected: "from:{alice@example.com bob@example.com}",
+		},
+		{
+			name: "subject with query syntax",
+			config: Config{
+				Subject: "Re: (urgent) invoice",
+			},
+			expected: `subject:"Re: (urgent) invoice"`,
+		},
+		{
+			name: "subject group",
+			config: Config{
+				Subject: "(invoice receipt)",
+			},
+			expected: "subject:(invoice receipt)",
+		},
+		{
+			name: "label with spaces and nesting",
+			config: Config{
+				Labels: "Clients/Acme Corp,Q3: Plans",
+			},
+			expected: "label:Clients-Acme-Corp label:Q3--Plans",
+		},
 		{
 			name: "search scope inbox",
 			config: Config{
@@ -129,6 +179,104 @@ func TestConfig_BuildGmailQuery(t *testing.T) {
 			},
 			expected: "in:inbox",
 		},
+		{
+			name: "search scope anywhere",
+			config: Config{
+				SearchScope: ScopeAnywhere,
+			},
+			expected: "in:anywhere",
+		},
+		{
+			name: "dates in a timezone",
+			config: Config{
+				DateAfter:  timePtr("2023-01-01"),
+				DateBefore: timePtr("2023-02-01"),
+				Timezone:   "UTC",
+			},
+			expected: "after:1672531200 before:1675209600",
+		},
+		{
+			name: "dates east of UTC",
+			config: Config{
+				DateAfter: timePtr("2023-01-01"),
+				Timezone:  "Asia/Tokyo",
+			},
+			expected: "after:1672498800",
+		},
+		{
+			name: "older than",
+			config: Config{
+				OlderThan: 2 * 365 * 24 * time.Hour,
+			},
+			expected: "older_than:730d",
+		},
+		{
+			name: "senders OR-ed",
+			config: Config{
+				FromAny: []string{"alice@example.com", " ", "John Smith"},
+			},
+			expected: `{from:alice@example.com from:"John Smith"}`,
+		},
+		{
+			name: "single value in an OR group",
+			config: Config{
+				ToAny: []string{"team@example.com"},
+			},
+			expected: "to:team@example.com",
+		},
+		{
+			name: "subjects OR-ed",
+			config: Config{
+				SubjectAny: []string{"invoice", "Re: receipt"},
+			},
+			expected: `{subject:(invoice) subject:"Re: receipt"}`,
+		},
+		{
+			name: "nested expressions OR-ed",
+			config: Config{
+				From:  "billing@example.com",
+				AnyOf: []string{"has:attachment larger:1M", "label:receipts", "{is:starred is:important}"},
+			},
+			expected: "from:billing@example.com {(has:attachment larger:1M) label:receipts {is:starred is:important}}",
+		},
+		{
+			name: "single filename",
+			config: Config{
+				Filenames: "pdf",
+			},
+			expected: "filename:pdf",
+		},
+		{
+			name: "filenames are OR-ed",
+			config: Config{
+				Filenames: "pdf, report.xlsx,,Q1 summary.docx",
+			},
+			expected: `{filename:pdf filename:report.xlsx filename:"Q1 summary.docx"}`,
+		},
+		{
+			name: "starred and unread",
+			config: Config{
+				IsStarred: boolPtr(true),
+				IsUnread:  boolPtr(true),
+			},
+			expected: "is:starred is:unread",
+		},
+		{
+			name: "not important and read",
+			config: Config{
+				IsImportant: boolPtr(false),
+				IsUnread:    boolPtr(false),
+			},
+			expected: "-is:important -is:unread",
+		},
+		{
+			name: "category tab in the inbox",
+			config: Config{
+				SearchScope: "inbox",
+				Category:    "Promotions",
+			},
+			expected: "in:inbox category:promotions",
+		},
 		{
 			name: "complex query",
 			config: Config{
@@ -155,12 +303,52 @@ func TestConfig_BuildGmailQuery(t *testing.T) {
 	}
 }
 
+func TestConfig_BuildGmailQuery_ExactSize(t *testing.T) {
+	config := Config{
+		SizeGreaterThan: 1000,
+		SizeLessThan:    2000,
+		ExactSize:       true,
+	}
+
+	// Exact size enforcement widens the approximate Gmail bounds
+	expected := "size:900 -size:2200"
+	if query := config.BuildGmailQuery(); query != expected {
+		t.Errorf("BuildGmailQuery() = %q, want %q", query, expected)
+	}
+}
+
+func TestConfig_MatchesSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		size     int64
+		expected bool
+	}{
+		{name: "no bounds", config: Config{}, size: 42, expected: true},
+		{name: "above lower bound", config: Config{SizeGreaterThan: 100}, size: 101, expected: true},
+		{name: "equal to lower bound", config: Config{SizeGreaterThan: 100}, size: 100, expected: false},
+		{name: "below upper bound", config: Config{SizeLessThan: 100}, size: 99, expected: true},
+		{name: "equal to upper bound", config: Config{SizeLessThan: 100}, size: 100, expected: false},
+		{name: "within both bounds", config: Config{SizeGreaterThan: 10, SizeLessThan: 100}, size: 50, expected: true},
+		{name: "outside both bounds", config: Config{SizeGreaterThan: 10, SizeLessThan: 100}, size: 5, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.config.MatchesSize(tt.size); result != tt.expected {
+				t.Errorf("MatchesSize(%d) = %v, want %v", tt.size, result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestConfig_Validate(t *testing.T) {
 	// Helper function to create time pointers
 	timePtr := func(s string) *time.Time {
 		t, _ := time.Parse("2006-01-02", s)
 		return &t
 	}
+	no := false
 
 	tests := []struct {
 		name    string
@@ -212,6 +400,43 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid category",
+			config: Config{
+				Category: "social",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid category",
+			config: Config{
+				Category: "newsletters",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid timezone",
+			config: Config{
+				Timezone: "Mars/Olympus",
+			},
+			wantErr: true,
+		},
+		{
+			name: "older than within a shorter period",
+			config: Config{
+				DateWithin: 30 * 24 * time.Hour,
+				OlderThan:  365 * 24 * time.Hour,
+			},
+			wantErr: true,
+		},
+		{
+			name: "filename without attachments",
+			config: Config{
+				Filenames:     "pdf",
+				HasAttachment: &no,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -341,3 +566,24 @@ func TestParseDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_IncludesSpamTrash(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{"", false},
+		{"all_mail", false},
+		{"inbox", false},
+		{"spam", true},
+		{"trash", true},
+		{ScopeAnywhere, true},
+	}
+
+	for _, tt := range tests {
+		config := Config{SearchScope: tt.scope}
+		if got := config.IncludesSpamTrash(); got != tt.want {
+			t.Errorf("IncludesSpamTrash() with scope %q = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
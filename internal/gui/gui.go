@@ -0,0 +1,313 @@
+package gui
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+//go:embed static
+var static embed.FS
+
+// maxRecentErrors caps the failed messages kept in the status
+const maxRecentErrors = 20
+
+// Export states reported in Status.State
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+// Request is an export submitted from the web UI
+type Request struct {
+	From          string `json:"from,omitempty"`
+	To            string `json:"to,omitempty"`
+	Subject       string `json:"subject,omitempty"`
+	IncludesWords string `json:"includes_words,omitempty"`
+	ExcludesWords string `json:"excludes_words,omitempty"`
+	Labels        string `json:"labels,omitempty"`
+	SearchScope   string `json:"search_scope,omitempty"`
+	// DateAfter and DateBefore are YYYY-MM-DD dates
+	DateAfter     string `json:"date_after,omitempty"`
+	DateBefore    string `json:"date_before,omitempty"`
+	HasAttachment bool   `json:"has_attachment,omitempty"`
+	ExcludeChats  bool   `json:"exclude_chats,omitempty"`
+
+	OutputDir        string `json:"output_dir"`
+	Format           string `json:"format"`
+	OrganizeByLabels bool   `json:"organize_by_labels,omitempty"`
+	Limit            int    `json:"limit,omitempty"`
+}
+
+// Filters returns the filter configuration of the request
+func (r *Request) Filters() (*filters.Config, error) {
+	config := &filters.Config{
+		From:          r.From,
+		To:            r.To,
+		Subject:       r.Subject,
+		IncludesWords: r.IncludesWords,
+		ExcludesWords: r.ExcludesWords,
+		Labels:        r.Labels,
+		SearchScope:   r.SearchScope,
+		ExcludeChats:  r.ExcludeChats,
+	}
+
+	if r.DateAfter != "" {
+		date, err := time.Parse("2006-01-02", r.DateAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date after (use YYYY-MM-DD): %w", err)
+		}
+		config.DateAfter = &date
+	}
+	if r.DateBefore != "" {
+		date, err := time.Parse("2006-01-02", r.DateBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date before (use YYYY-MM-DD): %w", err)
+		}
+		config.DateBefore = &date
+	}
+	if r.HasAttachment {
+		hasAttachment := true
+		config.HasAttachment = &hasAttachment
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Runner runs an export, reporting its progress to events
+type Runner func(request Request, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error)
+
+// Status is the state of the current or last export
+type Status struct {
+	State     string           `json:"state"`
+	Request   *Request         `json:"request,omitempty"`
+	Query     string           `json:"query,omitempty"`
+	Progress  metrics.Progress `json:"progress"`
+	LastFile  string           `json:"last_file,omitempty"`
+	Errors    []string         `json:"errors,omitempty"`
+	Result    *exporter.Result `json:"result,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	StartedAt time.Time        `json:"started_at,omitempty"`
+	EndedAt   time.Time        `json:"ended_at,omitempty"`
+}
+
+// Server serves the web UI and runs one export at a time
+type Server struct {
+	run      Runner
+	defaults Request
+
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a server running exports with run. defaults prefill the
+// export form.
+func New(run Runner, defaults Request) *Server {
+	return &Server{run: run, defaults: defaults, status: Status{State: StateIdle}}
+}
+
+// Handler returns the HTTP handler of the web UI and its API. Requests must
+// address the server by a loopback host, so other web sites cannot reach it
+// through DNS rebinding.
+func (s *Server) Handler() http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(assets)))
+	mux.HandleFunc("GET /api/defaults", s.handleDefaults)
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("POST /api/export", s.handleExport)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(r.Host) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Listen opens a listener on the loopback interface. Port 0 picks a free
+// port.
+func Listen(port int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return listener, nil
+}
+
+// Serve serves the web UI on listener until it fails
+func (s *Server) Serve(listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.Serve(listener)
+}
+
+// isLoopbackHost reports whether the Host header names the loopback
+// interface
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleDefaults returns the values prefilling the export form
+func (s *Server) handleDefaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.defaults)
+}
+
+// handleStatus returns the state of the current or last export
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+// handleExport starts an export. Only JSON bodies are accepted, so other
+// origins cannot submit the form without a CORS preflight, which is never
+// granted.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if request.OutputDir == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("output directory is required"))
+		return
+	}
+	filterConfig, err := request.Filters()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.start(request, filterConfig.BuildGmailQuery()) {
+		writeError(w, http.StatusConflict, fmt.Errorf("an export is already running"))
+		return
+	}
+
+	go s.export(request, filterConfig)
+
+	writeJSON(w, http.StatusAccepted, s.Status())
+}
+
+// start marks an export as running, unless one already is
+func (s *Server) start(request Request, query string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status.State == StateRunning {
+		return false
+	}
+
+	s.status = Status{
+		State:     StateRunning,
+		Request:   &request,
+		Query:     query,
+		StartedAt: time.Now(),
+	}
+	return true
+}
+
+// export runs an export and records its outcome
+func (s *Server) export(request Request, filterConfig *filters.Config) {
+	logrus.WithField("query", filterConfig.BuildGmailQuery()).Info("Starting export from the web UI")
+
+	result, err := s.run(request, filterConfig, s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.EndedAt = time.Now()
+	s.status.Result = result
+	if err != nil {
+		s.status.State = StateFailed
+		s.status.Error = err.Error()
+		return
+	}
+	s.status.State = StateDone
+}
+
+// Status returns a snapshot of the export status
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Errors = append([]string(nil), s.status.Errors...)
+	return status
+}
+
+// OnMessageExported implements exporter.Events
+func (s *Server) OnMessageExported(event exporter.MessageEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Progress = event.Progress
+	s.status.LastFile = event.File
+}
+
+// OnError implements exporter.Events
+func (s *Server) OnError(event exporter.ErrorEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Progress = event.Progress
+	s.status.Errors = append(s.status.Errors, fmt.Sprintf("%s: %v", event.MessageID, event.Err))
+	if len(s.status.Errors) > maxRecentErrors {
+		s.status.Errors = s.status.Errors[len(s.status.Errors)-maxRecentErrors:]
+	}
+}
+
+// OnStateSaved implements exporter.Events
+func (s *Server) OnStateSaved(exporter.StateEvent) {}
+
+// writeJSON writes value as a JSON response
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logrus.WithError(err).Debug("Failed to write web UI response")
+	}
+}
+
+// writeError writes err as a JSON error response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,191 @@
+package gui
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+func TestRequest_Filters(t *testing.T) {
+	request := Request{
+		From:          "a@example.com",
+		DateAfter:     "2024-01-01",
+		HasAttachment: true,
+	}
+
+	config, err := request.Filters()
+	if err != nil {
+		t.Fatalf("Filters() error = %v", err)
+	}
+	if config.From != "a@example.com" || config.DateAfter == nil || config.HasAttachment == nil || !*config.HasAttachment {
+		t.Errorf("Unexpected filter config: %+v", config)
+	}
+
+	request.DateBefore = "01/02/2024"
+	if _, err := request.Filters(); err == nil {
+		t.Error("Expected an error for an invalid date")
+	}
+}
+
+func TestIsLoopbackHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"127.0.0.1:8765", true},
+		{"localhost:8765", true},
+		{"[::1]:8765", true},
+		{"localhost", true},
+		{"evil.example.com:8765", false},
+		{"192.168.1.10:8765", false},
+	}
+
+	for _, tt := range tests {
+		if result := isLoopbackHost(tt.host); result != tt.expected {
+			t.Errorf("isLoopbackHost(%q) = %v, want %v", tt.host, result, tt.expected)
+		}
+	}
+}
+
+// newTestServer serves a GUI whose exports run until release is closed
+func newTestServer(t *testing.T, release chan struct{}) (*Server, *httptest.Server) {
+	t.Helper()
+
+	server := New(func(request Request, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error) {
+		events.OnMessageExported(exporter.MessageEvent{
+			MessageID: "m1",
+			File:      "m1.eml",
+			Progress:  metrics.Progress{Matched: 2, Exported: 1, Processed: 1},
+		})
+		events.OnError(exporter.ErrorEvent{
+			MessageID: "m2",
+			Err:       errors.New("boom"),
+			Progress:  metrics.Progress{Matched: 2, Exported: 1, Failed: 1, Processed: 2},
+		})
+		<-release
+		return &exporter.Result{TotalMatched: 2, TotalExported: 1, TotalFailed: 1}, nil
+	}, Request{OutputDir: "./exports", Format: "eml"})
+
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return server, httpServer
+}
+
+func postExport(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+
+	response, err := http.Post(url+"/api/export", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	return response
+}
+
+func TestServer_Export(t *testing.T) {
+	release := make(chan struct{})
+	server, httpServer := newTestServer(t, release)
+
+	response := postExport(t, httpServer.URL, `{"from":"a@example.com","output_dir":"out","format":"eml"}`)
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", response.StatusCode)
+	}
+
+	// Only one export runs at a time
+	response = postExport(t, httpServer.URL, `{"output_dir":"out"}`)
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409 while running, got %d", response.StatusCode)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Status().State == StateRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("Export did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	statusResponse, err := http.Get(httpServer.URL + "/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer statusResponse.Body.Close()
+
+	var status Status
+	if err := json.NewDecoder(statusResponse.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StateDone || status.Result == nil || status.Result.TotalExported != 1 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if status.Progress.Processed != 2 || status.LastFile != "m1.eml" {
+		t.Errorf("Expected progress from events, got %+v", status)
+	}
+	if len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "boom") {
+		t.Errorf("Expected the failed message in errors, got %v", status.Errors)
+	}
+	if status.Query != "from:a@example.com" {
+		t.Errorf("Expected the Gmail query, got %q", status.Query)
+	}
+}
+
+func TestServer_ExportRejected(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	_, httpServer := newTestServer(t, release)
+
+	if response := postExport(t, httpServer.URL, `{"format":"eml"}`); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without output dir, got %d", response.StatusCode)
+	}
+
+	// Form posts from other origins are refused
+	response, err := http.Post(httpServer.URL+"/api/export", "application/x-www-form-urlencoded", strings.NewReader("output_dir=out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for a form post, got %d", response.StatusCode)
+	}
+}
+
+func TestServer_ForeignHost(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server, _ := newTestServer(t, release)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	request.Host = "evil.example.com"
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a foreign host, got %d", recorder.Code)
+	}
+}
+
+func TestServer_Assets(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	_, httpServer := newTestServer(t, release)
+
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		response, err := http.Get(httpServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200 for %s, got %d", path, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+"use strict";
+
+const form = document.getElementById("export-form");
+const startButton = document.getElementById("start");
+let polling = null;
+
+async function loadDefaults() {
+  const response = await fetch("api/defaults");
+  const defaults = await response.json();
+  for (const [name, value] of Object.entries(defaults)) {
+    const field = form.elements[name];
+    if (!field) {
+      continue;
+    }
+    if (field.type === "checkbox") {
+      field.checked = Boolean(value);
+    } else if (value !== null && value !== "") {
+      field.value = value;
+    }
+  }
+}
+
+function formRequest() {
+  const request = {};
+  for (const field of form.elements) {
+    if (!field.name) {
+      continue;
+    }
+    if (field.type === "checkbox") {
+      request[field.name] = field.checked;
+    } else if (field.type === "number") {
+      request[field.name] = Number(field.value) || 0;
+    } else if (field.value !== "") {
+      request[field.name] = field.value;
+    }
+  }
+  return request;
+}
+
+function render(status) {
+  document.getElementById("status").hidden = status.state === "idle";
+  startButton.disabled = status.state === "running";
+
+  const titles = {running: "Exporting…", done: "Export complete", failed: "Export failed"};
+  document.getElementById("state").textContent = titles[status.state] || "";
+  document.getElementById("query").textContent = status.query ? "Gmail query: " + status.query : "";
+
+  const progress = status.progress;
+  const bar = document.getElementById("bar");
+  bar.value = progress.Matched > 0 ? (100 * progress.Processed) / progress.Matched : 0;
+
+  document.getElementById("counts").textContent =
+    `${progress.Exported} of ${progress.Matched} exported, ${progress.Failed} failed, ${progress.Skipped} skipped`;
+  document.getElementById("last-file").textContent = status.last_file ? "Last file: " + status.last_file : "";
+  document.getElementById("error").textContent = status.error || "";
+
+  const errors = document.getElementById("errors");
+  errors.replaceChildren(...(status.errors || []).map((text) => {
+    const item = document.createElement("li");
+    item.textContent = text;
+    return item;
+  }));
+
+  if (status.state !== "running" && polling) {
+    clearInterval(polling);
+    polling = null;
+  }
+}
+
+async function refresh() {
+  const response = await fetch("api/status");
+  render(await response.json());
+}
+
+form.addEventListener("submit", async (event) => {
+  event.preventDefault();
+
+  const response = await fetch("api/export", {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify(formRequest()),
+  });
+  const body = await response.json();
+  if (!response.ok) {
+    document.getElementById("status").hidden = false;
+    document.getElementById("error").textContent = body.error;
+    return;
+  }
+
+  render(body);
+  if (!polling) {
+    polling = setInterval(refresh, 1000);
+  }
+});
+
+loadDefaults().then(refresh).then(() => {
+  if (startButton.disabled && !polling) {
+    polling = setInterval(refresh, 1000);
+  }
+});
@@ -0,0 +1,127 @@
+// Package health serves the liveness and readiness endpoints that container
+// orchestrators such as Kubernetes probe long-running commands with
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Probe reports whether a long-running command is alive and ready for work.
+// It starts not ready.
+type Probe struct {
+	mu       sync.Mutex
+	ready    bool
+	reason   string
+	stopping bool
+	started  time.Time
+}
+
+// Status is the body of the health endpoints
+type Status struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Uptime string `json:"uptime"`
+}
+
+// New creates a probe that is not ready yet
+func New() *Probe {
+	return &Probe{reason: "starting", started: time.Now()}
+}
+
+// SetReady marks the command ready for work, unless it is shutting down
+func (p *Probe) SetReady() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopping {
+		return
+	}
+	p.ready = true
+	p.reason = ""
+}
+
+// ShuttingDown marks the command not ready for good, so orchestrators stop
+// routing work to it while it finishes
+func (p *Probe) ShuttingDown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ready = false
+	p.reason = "shutting down"
+	p.stopping = true
+}
+
+// Handler returns the HTTP handler of the endpoints: /healthz answers while
+// the process is running, and /readyz only while it is ready
+func (p *Probe) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", p.handleHealth)
+	mux.HandleFunc("GET /readyz", p.handleReady)
+	return mux
+}
+
+// Start serves the endpoints on address in the background and returns a
+// function that stops serving
+func (p *Probe) Start(address string) (func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for health checks on %s: %w", address, err)
+	}
+
+	server := &http.Server{
+		Handler:           p.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Error("Health check server failed")
+		}
+	}()
+	logrus.WithField("listen", listener.Addr().String()).Info("Serving health checks on /healthz and /readyz")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
+
+// handleHealth reports that the process is alive
+func (p *Probe) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, Status{Status: "ok", Uptime: p.uptime()})
+}
+
+// handleReady reports whether the command is ready for work
+func (p *Probe) handleReady(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	ready, reason := p.ready, p.reason
+	p.mu.Unlock()
+
+	if !ready {
+		writeStatus(w, http.StatusServiceUnavailable, Status{Status: "not ready", Reason: reason, Uptime: p.uptime()})
+		return
+	}
+	writeStatus(w, http.StatusOK, Status{Status: "ready", Uptime: p.uptime()})
+}
+
+// uptime returns how long the probe has existed
+func (p *Probe) uptime() string {
+	return time.Since(p.started).Round(time.Second).String()
+}
+
+// writeStatus writes status as a JSON response
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logrus.WithError(err).Debug("Failed to write health check response")
+	}
+}
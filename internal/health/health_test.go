@@ -0,0 +1,49 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// get requests path from handler and decodes the response
+func get(t *testing.T, handler http.Handler, path string) (int, Status) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var status Status
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode %s response: %v", path, err)
+	}
+	return recorder.Code, status
+}
+
+func TestProbe(t *testing.T) {
+	probe := New()
+	handler := probe.Handler()
+
+	if code, _ := get(t, handler, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200 while starting, got %d", code)
+	}
+	if code, status := get(t, handler, "/readyz"); code != http.StatusServiceUnavailable || status.Reason != "starting" {
+		t.Errorf("Expected /readyz to answer 503 while starting, got %d %+v", code, status)
+	}
+
+	probe.SetReady()
+	if code, status := get(t, handler, "/readyz"); code != http.StatusOK || status.Status != "ready" {
+		t.Errorf("Expected /readyz to answer 200 once ready, got %d %+v", code, status)
+	}
+
+	// Shutting down is final
+	probe.ShuttingDown()
+	probe.SetReady()
+	if code, status := get(t, handler, "/readyz"); code != http.StatusServiceUnavailable || status.Reason != "shutting down" {
+		t.Errorf("Expected /readyz to answer 503 while shutting down, got %d %+v", code, status)
+	}
+	if code, _ := get(t, handler, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200 while shutting down, got %d", code)
+	}
+}
@@ -0,0 +1,308 @@
+// Package hooks runs user-configured commands and HTTP calls for each
+// exported message and each completed run, so exports can feed downstream
+// processing such as virus scanning, OCR or document management ingestion
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hook events
+const (
+	// EventMessage hooks run once for each message written by the export
+	EventMessage = "message"
+	// EventRun hooks run once the export has finished
+	EventRun = "run"
+)
+
+// DefaultTimeout bounds a hook without a timeout of its own
+const DefaultTimeout = 5 * time.Minute
+
+// messageWorkers is how many message hooks run at a time
+const messageWorkers = 4
+
+// Hook is a command, run through the shell, or a URL that receives a JSON
+// POST. Commands get the same JSON on stdin and the main fields in
+// GMAIL_EXPORTER_* environment variables. Header values may reference
+// environment variables as ${NAME}, to keep tokens out of the config file.
+type Hook struct {
+	Name    string            `json:"name" mapstructure:"name"`
+	Event   string            `json:"event" mapstructure:"event"`
+	Command string            `json:"command,omitempty" mapstructure:"command"`
+	URL     string            `json:"url,omitempty" mapstructure:"url"`
+	Headers map[string]string `json:"headers,omitempty" mapstructure:"headers"`
+	Timeout time.Duration     `json:"timeout,omitempty" mapstructure:"timeout"`
+}
+
+// Message describes an exported message to message hooks
+type Message struct {
+	Event     string    `json:"event"`
+	MessageID string    `json:"message_id"`
+	File      string    `json:"file"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	From      string    `json:"from,omitempty"`
+	Date      time.Time `json:"date,omitempty"`
+	Size      int64     `json:"size"`
+	Labels    []string  `json:"labels,omitempty"`
+	OutputDir string    `json:"output_dir"`
+}
+
+// Run describes a finished export to run hooks
+type Run struct {
+	Event        string        `json:"event"`
+	OutputDir    string        `json:"output_dir"`
+	Matched      int           `json:"matched"`
+	Exported     int           `json:"exported"`
+	Failed       int           `json:"failed"`
+	Skipped      int           `json:"skipped"`
+	Cancelled    bool          `json:"cancelled,omitempty"`
+	Duration     time.Duration `json:"duration"`
+	HookFailures int           `json:"hook_failures,omitempty"`
+}
+
+// Validate checks the configured hooks
+func Validate(hooks []Hook) error {
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		if hook.Event != EventMessage && hook.Event != EventRun {
+			return fmt.Errorf("hook %s: invalid event %q (valid: %s, %s)", name, hook.Event, EventMessage, EventRun)
+		}
+		if (hook.Command == "") == (hook.URL == "") {
+			return fmt.Errorf("hook %s: set exactly one of command and url", name)
+		}
+		if hook.URL != "" && !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+			return fmt.Errorf("hook %s: url must be http or https", name)
+		}
+		if hook.Timeout < 0 {
+			return fmt.Errorf("hook %s: timeout must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// ShellCommand returns a command running line through the shell
+func ShellCommand(ctx context.Context, line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", line)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", line)
+}
+
+// Runner runs the hooks of one export. Message hooks run in the background
+// so slow hooks do not hold up the export; Finish waits for them. A nil
+// Runner runs nothing.
+type Runner struct {
+	message []Hook
+	run     []Hook
+	dir     string
+	client  *http.Client
+
+	queue     chan Message
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	failures int
+}
+
+// New returns a runner for the hooks, running commands in dir, or nil when
+// there are none
+func New(hooks []Hook, dir string) *Runner {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	r := &Runner{dir: dir, client: &http.Client{}}
+	for _, hook := range hooks {
+		if hook.Event == EventMessage {
+			r.message = append(r.message, hook)
+		} else {
+			r.run = append(r.run, hook)
+		}
+	}
+
+	if len(r.message) > 0 {
+		r.queue = make(chan Message, messageWorkers)
+		for i := 0; i < messageWorkers; i++ {
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				for message := range r.queue {
+					for _, hook := range r.message {
+						r.call(hook, message, messageEnv(message))
+					}
+				}
+			}()
+		}
+	}
+
+	return r
+}
+
+// Message queues the message hooks for an exported message, waiting while
+// all the workers are busy
+func (r *Runner) Message(message Message) {
+	if r == nil || r.queue == nil {
+		return
+	}
+	message.Event = EventMessage
+	if abs, err := filepath.Abs(message.File); err == nil {
+		message.File = abs
+	}
+	r.queue <- message
+}
+
+// Finish waits for the queued message hooks, then runs the run hooks, and
+// returns the number of hook calls that failed
+func (r *Runner) Finish(run Run) int {
+	if r == nil {
+		return 0
+	}
+	r.Close()
+
+	run.Event = EventRun
+	run.HookFailures = r.failureCount()
+	return r.Notify(run, []string{
+		"GMAIL_EXPORTER_EXPORTED=" + strconv.Itoa(run.Exported),
+		"GMAIL_EXPORTER_FAILED=" + strconv.Itoa(run.Failed),
+	})
+}
+
+// Notify runs the run hooks with payload and the extra environment
+// variables, for runs other than an export such as a workflow, and returns
+// the number of hook calls that failed
+func (r *Runner) Notify(payload any, env []string) int {
+	if r == nil {
+		return 0
+	}
+	for _, hook := range r.run {
+		r.call(hook, payload, env)
+	}
+	return r.failureCount()
+}
+
+// Close waits for the queued message hooks and stops the workers, for an
+// export that ends without Finish
+func (r *Runner) Close() {
+	if r == nil {
+		return
+	}
+	r.closeOnce.Do(func() {
+		if r.queue != nil {
+			close(r.queue)
+			r.wg.Wait()
+		}
+	})
+}
+
+// failureCount returns the number of failed hook calls so far
+func (r *Runner) failureCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failures
+}
+
+// messageEnv returns the environment variables describing a message
+func messageEnv(message Message) []string {
+	return []string{
+		"GMAIL_EXPORTER_MESSAGE_ID=" + message.MessageID,
+		"GMAIL_EXPORTER_FILE=" + message.File,
+		"GMAIL_EXPORTER_SUBJECT=" + message.Subject,
+		"GMAIL_EXPORTER_FROM=" + message.From,
+	}
+}
+
+// call runs one hook with payload, logging and counting a failure
+func (r *Runner) call(hook Hook, payload any, env []string) {
+	data, err := json.Marshal(payload)
+	if err == nil {
+		timeout := hook.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if hook.Command != "" {
+			err = r.runCommand(ctx, hook.Command, data, env)
+		} else {
+			err = r.post(ctx, hook, data)
+		}
+		cancel()
+	}
+	if err == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.failures++
+	r.mu.Unlock()
+
+	fields := logrus.Fields{"hook": hook.Name}
+	if message, ok := payload.(Message); ok {
+		fields["message_id"] = message.MessageID
+	}
+	logrus.WithError(err).WithFields(fields).Warn("Hook failed")
+}
+
+// runCommand runs a command hook in the runner's directory
+func (r *Runner) runCommand(ctx context.Context, line string, payload []byte, env []string) error {
+	cmd := ShellCommand(ctx, line)
+	cmd.Dir = r.dir
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "GMAIL_EXPORTER_OUTPUT_DIR="+r.dir)
+	cmd.Env = append(cmd.Env, env...)
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		logrus.WithField("command", line).Debug(strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("command %q failed: %w", line, err)
+	}
+	return nil
+}
+
+// post sends the payload to a URL hook
+func (r *Runner) post(ctx context.Context, hook Hook, payload []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		request.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", hook.URL, response.Status)
+	}
+	return nil
+}
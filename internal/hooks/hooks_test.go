@@ -0,0 +1,129 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{"command", Hook{Event: EventMessage, Command: "clamscan \"$GMAIL_EXPORTER_FILE\""}, false},
+		{"url", Hook{Event: EventRun, URL: "https://dms.example.com/ingest"}, false},
+		{"unknown event", Hook{Event: "label", Command: "true"}, true},
+		{"no action", Hook{Event: EventRun}, true},
+		{"both actions", Hook{Event: EventRun, Command: "true", URL: "https://example.com"}, true},
+		{"not http", Hook{Event: EventRun, URL: "ftp://example.com"}, true},
+		{"negative timeout", Hook{Event: EventRun, Command: "true", Timeout: -time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate([]Hook{tt.hook})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNilRunner(t *testing.T) {
+	runner := New(nil, t.TempDir())
+	if runner != nil {
+		t.Fatal("Expected no runner without hooks")
+	}
+	runner.Message(Message{MessageID: "m1"})
+	if failures := runner.Finish(Run{}); failures != 0 {
+		t.Errorf("Expected no failures, got %d", failures)
+	}
+	runner.Close()
+}
+
+func TestCommandHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command hook test uses sh")
+	}
+
+	dir := t.TempDir()
+	runner := New([]Hook{
+		{Name: "record", Event: EventMessage, Command: `cat > "$GMAIL_EXPORTER_MESSAGE_ID.json"`},
+		{Name: "fail", Event: EventMessage, Command: "exit 1"},
+		{Name: "summary", Event: EventRun, Command: `echo "$GMAIL_EXPORTER_EXPORTED $GMAIL_EXPORTER_FAILED" > run.out`},
+	}, dir)
+
+	runner.Message(Message{MessageID: "m1", File: filepath.Join(dir, "m1.eml"), Subject: "Invoice"})
+	runner.Message(Message{MessageID: "m2", File: filepath.Join(dir, "m2.eml")})
+	failures := runner.Finish(Run{Exported: 2, Failed: 1})
+	if failures != 2 {
+		t.Errorf("Expected the failing hook to fail once per message, got %d failures", failures)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "m1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Event != EventMessage || message.Subject != "Invoice" {
+		t.Errorf("Expected the message on stdin, got %+v", message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "m2.json")); err != nil {
+		t.Errorf("Expected a hook call for every message, got %v", err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "run.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "2 1\n" {
+		t.Errorf("Expected the run hook to get the counts, got %q", data)
+	}
+}
+
+func TestURLHooks(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bodies = append(bodies, string(body))
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	t.Setenv("HOOK_TEST_TOKEN", "secret")
+	runner := New([]Hook{
+		{Name: "ingest", Event: EventRun, URL: server.URL + "/ingest", Headers: map[string]string{"Authorization": "Bearer ${HOOK_TEST_TOKEN}"}},
+		{Name: "fail", Event: EventRun, URL: server.URL + "/fail"},
+	}, t.TempDir())
+
+	if failures := runner.Finish(Run{Exported: 3}); failures != 1 {
+		t.Errorf("Expected the error response to count as a failure, got %d failures", failures)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("Expected the header to expand the environment, got %q", authorization)
+	}
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `"event":"run"`) || !strings.Contains(bodies[0], `"exported":3`) {
+		t.Errorf("Expected the run as JSON, got %v", bodies)
+	}
+}
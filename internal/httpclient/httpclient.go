@@ -0,0 +1,185 @@
+// Package httpclient provides the HTTP client shared by the OAuth flow and the
+// API clients, set up for corporate networks that route traffic through a
+// proxy and intercept TLS with their own certificate authority
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Config is the network setup of the HTTP client
+type Config struct {
+	// ProxyURL is the proxy for all requests. Without it the HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY environment variables apply.
+	ProxyURL string `json:"proxy_url,omitempty"`
+
+	// CACerts are PEM files of certificate authorities trusted in addition
+	// to the system ones, such as that of a TLS-intercepting proxy
+	CACerts []string `json:"ca_certs,omitempty"`
+
+	// MaxIdleConnsPerHost is the number of idle connections kept open to
+	// each host for reuse (default: DefaultMaxIdleConnsPerHost). Go's own
+	// default of 2 makes most workers open a new connection per request.
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+
+	// DisableHTTP2 makes requests use HTTP/1.1, one request per connection
+	// at a time, instead of multiplexing them over HTTP/2
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
+
+	// KeepAlive is the interval of TCP keep-alive probes on open connections
+	// (default: 30s); negative disables them
+	KeepAlive time.Duration `json:"keep_alive,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection is kept open
+	// (default: 90s)
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout,omitempty"`
+}
+
+// DefaultMaxIdleConnsPerHost is the number of idle connections kept per
+// host, enough for the largest adaptive worker count
+const DefaultMaxIdleConnsPerHost = 32
+
+// dialTimeout is the timeout of establishing a connection, as in Go's
+// default transport
+const dialTimeout = 30 * time.Second
+
+// ConnStats counts the connections requests were sent on
+type ConnStats struct {
+	New    int64 `json:"new"`
+	Reused int64 `json:"reused"`
+}
+
+var (
+	mu     sync.RWMutex
+	client = &http.Client{Transport: &countingTransport{base: http.DefaultTransport}}
+
+	newConns    atomic.Int64
+	reusedConns atomic.Int64
+)
+
+// Configure sets up the shared client. It is called once at startup, before
+// any client is handed out.
+func Configure(config Config) error {
+	transport, err := NewTransport(config)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	client = &http.Client{Transport: &countingTransport{base: transport}}
+	return nil
+}
+
+// NewTransport returns a transport with the proxy, certificate authorities
+// and connection pool of config
+func NewTransport(config Config) (*http.Transport, error) {
+	if config.MaxIdleConnsPerHost < 0 {
+		return nil, fmt.Errorf("max idle connections per host must be >= 0")
+	}
+	if config.IdleConnTimeout < 0 {
+		return nil, fmt.Errorf("idle connection timeout must be >= 0")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	transport.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	if config.KeepAlive != 0 {
+		dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: config.KeepAlive}
+		transport.DialContext = dialer.DialContext
+	}
+	if config.DisableHTTP2 {
+		// A non-nil empty TLSNextProto turns off the HTTP/2 upgrade
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if config.ProxyURL != "" {
+		proxy, err := url.Parse(config.ProxyURL)
+		if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: %s", config.ProxyURL)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if len(config.CACerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, path := range config.CACerts {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no PEM certificates found in %s", path)
+			}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return transport, nil
+}
+
+// countingTransport counts whether each request got a new or a reused
+// connection
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends req with the base transport, tracing its connection
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				reusedConns.Add(1)
+			} else {
+				newConns.Add(1)
+			}
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// Stats returns the connections the shared client's requests have used
+// since startup
+func Stats() ConnStats {
+	return ConnStats{New: newConns.Load(), Reused: reusedConns.Load()}
+}
+
+// Client returns the shared client
+func Client() *http.Client {
+	mu.RLock()
+	defer mu.RUnlock()
+	return client
+}
+
+// Context returns ctx carrying the shared client, so that OAuth token
+// requests and the clients oauth2 builds from ctx use it
+func Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, Client())
+}
@@ -0,0 +1,130 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewTransportProxy(t *testing.T) {
+	transport, err := NewTransport(Config{ProxyURL: "http://proxy.example.com:3128"})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://gmail.googleapis.com/", nil)
+	proxy, err := transport.Proxy(req)
+	if err != nil {
+		t.Fatalf("Proxy() error = %v", err)
+	}
+	if proxy == nil || proxy.Host != "proxy.example.com:3128" {
+		t.Errorf("Proxy() = %v, want proxy.example.com:3128", proxy)
+	}
+}
+
+func TestNewTransportCACerts(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, pemData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the CA the server's certificate is not trusted
+	plain, err := NewTransport(Config{})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	if _, err := (&http.Client{Transport: plain}).Get(server.URL); err == nil {
+		t.Error("Expected an untrusted certificate error without the CA")
+	}
+
+	transport, err := NewTransport(Config{CACerts: []string{caFile}})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the CA to be trusted, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewTransportPool(t *testing.T) {
+	transport, err := NewTransport(Config{})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || !transport.ForceAttemptHTTP2 {
+		t.Errorf("Expected %d idle connections per host over HTTP/2, got %d (HTTP/2 %v)",
+			DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost, transport.ForceAttemptHTTP2)
+	}
+
+	transport, err = NewTransport(Config{MaxIdleConnsPerHost: 200, DisableHTTP2: true, IdleConnTimeout: time.Minute})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	if transport.MaxIdleConnsPerHost != 200 || transport.MaxIdleConns < 200 || transport.IdleConnTimeout != time.Minute {
+		t.Errorf("Unexpected pool settings: %d per host, %d total, %s idle",
+			transport.MaxIdleConnsPerHost, transport.MaxIdleConns, transport.IdleConnTimeout)
+	}
+	if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil {
+		t.Error("Expected HTTP/2 to be disabled")
+	}
+}
+
+func TestStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if err := Configure(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	before := Stats()
+	for i := 0; i < 3; i++ {
+		resp, err := Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	after := Stats()
+	if after.New-before.New != 1 || after.Reused-before.Reused != 2 {
+		t.Errorf("Expected 1 new and 2 reused connections, got %+v", ConnStats{New: after.New - before.New, Reused: after.Reused - before.Reused})
+	}
+}
+
+func TestNewTransportInvalid(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"proxy without scheme", Config{ProxyURL: "proxy.example.com:3128"}},
+		{"missing CA file", Config{CACerts: []string{filepath.Join(t.TempDir(), "missing.pem")}}},
+		{"CA file without certificates", Config{CACerts: []string{notPEM}}},
+		{"negative idle connections", Config{MaxIdleConnsPerHost: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTransport(tt.config); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// addressHeaders are the header fields whose addresses are rewritten
+var addressHeaders = map[string]bool{
+	"from":     true,
+	"to":       true,
+	"cc":       true,
+	"bcc":      true,
+	"reply-to": true,
+	"sender":   true,
+}
+
+// emailAddress matches the addresses in an address header
+var emailAddress = regexp.MustCompile(`[A-Za-z0-9.!#$%&'*+/=?^_{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)+`)
+
+// addressRewriter rewrites addresses in the address headers of a message.
+// A rule for a whole address takes precedence over one for its domain.
+type addressRewriter struct {
+	addresses map[string]string // lower-cased old address -> new address
+	domains   map[string]string // lower-cased old domain -> new domain
+}
+
+// newAddressRewriter builds a rewriter from old=new rules, each mapping a
+// domain to a domain or an address to an address. It returns nil when there
+// are no rules.
+func newAddressRewriter(rules map[string]string) (*addressRewriter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	r := &addressRewriter{
+		addresses: make(map[string]string),
+		domains:   make(map[string]string),
+	}
+	for from, to := range rules {
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		fromAddress, toAddress := strings.Contains(from, "@"), strings.Contains(to, "@")
+
+		switch {
+		case from == "" || to == "" || fromAddress != toAddress:
+			return nil, fmt.Errorf("invalid address rewrite %q=%q (map a domain to a domain or an address to an address)", from, to)
+		case fromAddress:
+			if !emailAddress.MatchString(from) || !emailAddress.MatchString(to) {
+				return nil, fmt.Errorf("invalid address rewrite %q=%q", from, to)
+			}
+			r.addresses[strings.ToLower(from)] = to
+		default:
+			r.domains[strings.ToLower(strings.TrimPrefix(from, "@"))] = strings.TrimPrefix(to, "@")
+		}
+	}
+
+	return r, nil
+}
+
+// apply returns raw with matching addresses rewritten in its address
+// headers. Display names and the body are left untouched.
+func (r *addressRewriter) apply(raw []byte) []byte {
+	if r == nil {
+		return raw
+	}
+
+	header, body, _ := splitHeader(raw)
+
+	var out bytes.Buffer
+	out.Grow(len(raw))
+
+	for _, field := range headerFields(header) {
+		colon := bytes.IndexByte(field, ':')
+		if colon <= 0 || !addressHeaders[strings.ToLower(strings.TrimSpace(string(field[:colon])))] {
+			out.Write(field)
+			continue
+		}
+
+		out.Write(field[:colon])
+		out.Write(emailAddress.ReplaceAllFunc(field[colon:], r.rewrite))
+	}
+
+	out.Write(body)
+	return out.Bytes()
+}
+
+// rewrite returns the replacement for a single address
+func (r *addressRewriter) rewrite(address []byte) []byte {
+	if to, ok := r.addresses[strings.ToLower(string(address))]; ok {
+		return []byte(to)
+	}
+
+	at := bytes.LastIndexByte(address, '@')
+	if to, ok := r.domains[strings.ToLower(string(address[at+1:]))]; ok {
+		rewritten := append([]byte(nil), address[:at+1]...)
+		return append(rewritten, to...)
+	}
+
+	return address
+}
@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"testing"
+)
+
+func TestAddressRewriter(t *testing.T) {
+	rewriter, err := newAddressRewriter(map[string]string{
+		"olddomain.com":     "newdomain.com",
+		"ceo@olddomain.com": "chief@newdomain.com",
+	})
+	if err != nil {
+		t.Fatalf("newAddressRewriter() error = %v", err)
+	}
+
+	raw := "From: \"Alice\" <Alice@OldDomain.com>\r\n" +
+		"To: bob@olddomain.com,\r\n" +
+		" ceo@olddomain.com, carol@other.com\r\n" +
+		"Subject: mail for bob@olddomain.com\r\n" +
+		"\r\n" +
+		"Write to bob@olddomain.com\r\n"
+
+	got := string(rewriter.apply([]byte(raw)))
+	want := "From: \"Alice\" <Alice@newdomain.com>\r\n" +
+		"To: bob@newdomain.com,\r\n" +
+		" chief@newdomain.com, carol@other.com\r\n" +
+		"Subject: mail for bob@olddomain.com\r\n" +
+		"\r\n" +
+		"Write to bob@olddomain.com\r\n"
+	if got != want {
+		t.Errorf("apply() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestAddressRewriter_SubdomainUnchanged(t *testing.T) {
+	rewriter, err := newAddressRewriter(map[string]string{"olddomain.com": "newdomain.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := "Cc: x@mail.olddomain.com\r\n\r\n"
+	if got := string(rewriter.apply([]byte(raw))); got != raw {
+		t.Errorf("Expected subdomain address unchanged, got %q", got)
+	}
+}
+
+func TestNewAddressRewriter_Invalid(t *testing.T) {
+	tests := []map[string]string{
+		{"olddomain.com": ""},
+		{"olddomain.com": "someone@newdomain.com"},
+		{"old@olddomain.com": "newdomain.com"},
+	}
+
+	for _, rules := range tests {
+		if _, err := newAddressRewriter(rules); err == nil {
+			t.Errorf("Expected error for rules %v", rules)
+		}
+	}
+
+	if rewriter, err := newAddressRewriter(nil); err != nil || rewriter != nil {
+		t.Errorf("Expected nil rewriter without rules, got %v, %v", rewriter, err)
+	}
+}
@@ -0,0 +1,101 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SkipReasonDuplicate is the metrics skip reason of messages already in the
+// destination mailbox
+const SkipReasonDuplicate = "duplicate"
+
+// errAlreadyExists marks a message skipped because the destination mailbox
+// already has a message with its Message-ID
+var errAlreadyExists = errors.New("message already exists in the destination")
+
+// rawMessageID returns the Message-ID of a raw message, without its angle
+// brackets, or "" when it has none
+func rawMessageID(raw []byte) string {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(message.Header.Get("Message-ID")), "<>")
+}
+
+// checkDuplicate returns errAlreadyExists when SkipExisting is set and the
+// destination already has a message with the Message-ID of raw. Messages
+// without a Message-ID are always imported.
+func (i *Importer) checkDuplicate(raw []byte) error {
+	if !i.config.SkipExisting {
+		return nil
+	}
+	id := rawMessageID(raw)
+	if id == "" {
+		return nil
+	}
+
+	var exists bool
+	var err error
+	start := time.Now()
+	if i.graph == nil {
+		exists, err = i.gmailMessageExists(id)
+		i.metrics.RecordAPICall("messages.list", time.Since(start), err)
+	} else {
+		exists, err = i.graph.messageExists(id)
+		i.metrics.RecordAPICall("graph.messages.list", time.Since(start), err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to search the destination for %s: %w", id, err)
+	}
+
+	if exists {
+		logrus.WithField("message_id", id).Debug("Skipping message already in the destination")
+		return errAlreadyExists
+	}
+	return nil
+}
+
+// gmailMessageExists searches the Gmail account, spam and trash included,
+// for a message with the Message-ID
+func (i *Importer) gmailMessageExists(id string) (bool, error) {
+	var found bool
+	err := i.retry.Do(func() error {
+		response, err := i.gmailService.Users.Messages.List("me").
+			Q("rfc822msgid:" + id).
+			IncludeSpamTrash(true).
+			MaxResults(1).
+			Do()
+		if err != nil {
+			return err
+		}
+		found = len(response.Messages) > 0
+		return nil
+	}, func(err error, attempt int, wait time.Duration) {
+		i.metrics.RecordRetry("messages.list")
+	})
+	return found, err
+}
+
+// messageExists searches the mailbox for a message with the Message-ID
+func (g *graphClient) messageExists(id string) (bool, error) {
+	var existing struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	filter := url.QueryEscape(fmt.Sprintf("internetMessageId eq '<%s>'", strings.ReplaceAll(id, "'", "''")))
+	path := fmt.Sprintf("/messages?$filter=%s&$select=id&$top=1", filter)
+	if err := g.do(http.MethodGet, path, "", nil, &existing); err != nil {
+		return false, err
+	}
+	return len(existing.Value) > 0, nil
+}
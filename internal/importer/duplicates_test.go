@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+func TestRawMessageID(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"Message-ID: <abc@example.com>\r\nSubject: x\r\n\r\nbody", "abc@example.com"},
+		{"Message-Id:  <def@example.com> \r\n\r\nbody", "def@example.com"},
+		{"Subject: no id\r\n\r\nbody", ""},
+		{"not a message", ""},
+	}
+
+	for _, tt := range tests {
+		if got := rawMessageID([]byte(tt.raw)); got != tt.want {
+			t.Errorf("rawMessageID(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDuplicate(t *testing.T) {
+	var filters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("$filter")
+		filters = append(filters, filter)
+		if filter == "internetMessageId eq '<known@example.com>'" {
+			_, _ = w.Write([]byte(`{"value":[{"id":"msg-1"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"value":[]}`))
+	}))
+	defer server.Close()
+
+	config := &GraphConfig{Mailbox: "user@example.com", BaseURL: server.URL}
+	i := &Importer{
+		config:  &Config{SkipExisting: true},
+		graph:   newGraphClientWithHTTP(config, server.Client()),
+		metrics: metrics.NewCollector("import"),
+	}
+
+	if err := i.checkDuplicate([]byte("Message-ID: <known@example.com>\r\n\r\nbody")); !errors.Is(err, errAlreadyExists) {
+		t.Errorf("Expected a message already in the mailbox to be skipped, got %v", err)
+	}
+	if err := i.checkDuplicate([]byte("Message-ID: <new@example.com>\r\n\r\nbody")); err != nil {
+		t.Errorf("Expected a new message to be imported, got %v", err)
+	}
+	if err := i.checkDuplicate([]byte("Subject: no id\r\n\r\nbody")); err != nil {
+		t.Errorf("Expected a message without a Message-ID to be imported, got %v", err)
+	}
+	if len(filters) != 2 {
+		t.Errorf("Expected one search per message with a Message-ID, got %v", filters)
+	}
+
+	i.config.SkipExisting = false
+	if err := i.checkDuplicate([]byte("Message-ID: <known@example.com>\r\n\r\nbody")); err != nil || len(filters) != 2 {
+		t.Errorf("Expected no search without SkipExisting, got %v", err)
+	}
+}
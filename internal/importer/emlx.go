@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Apple Mail message flags, from the flags entry of the .emlx plist
+const (
+	emlxFlagRead    = 1 << 0
+	emlxFlagFlagged = 1 << 4
+)
+
+// emlxFlags matches the flags entry of the .emlx plist
+var emlxFlags = regexp.MustCompile(`<key>flags</key>\s*<integer>(\d+)</integer>`)
+
+// appleMailboxes maps Apple Mail's special mailboxes to Gmail labels
+var appleMailboxes = map[string]string{
+	"inbox":            "INBOX",
+	"sent messages":    "SENT",
+	"deleted messages": "TRASH",
+	"junk":             "SPAM",
+	"drafts":           "DRAFT",
+	"archive":          "",
+}
+
+// parseEMLX splits an Apple Mail .emlx file into the raw message and the
+// state labels of its flags. The file is the message's byte count on its own
+// line, the message itself, then an XML plist of Apple Mail metadata.
+func parseEMLX(data []byte) ([]byte, []string, error) {
+	newline := bytes.IndexByte(data, '\n')
+	if newline < 0 {
+		return nil, nil, fmt.Errorf("missing emlx length line")
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(string(data[:newline])))
+	if err != nil || length < 0 {
+		return nil, nil, fmt.Errorf("invalid emlx length line %q", data[:newline])
+	}
+
+	body := data[newline+1:]
+	if length > len(body) {
+		return nil, nil, fmt.Errorf("emlx message is truncated: %d of %d bytes", len(body), length)
+	}
+	raw, plist := body[:length], body[length:]
+
+	var state []string
+	if match := emlxFlags.FindSubmatch(plist); match != nil {
+		flags, _ := strconv.ParseInt(string(match[1]), 10, 64)
+		if flags&emlxFlagRead == 0 {
+			state = append(state, "UNREAD")
+		}
+		if flags&emlxFlagFlagged != 0 {
+			state = append(state, "STARRED")
+		}
+	}
+	return raw, state, nil
+}
+
+// emlxLabels returns the label of an .emlx file from the Apple Mail
+// mailboxes it is stored in, such as Work.mbox/Clients.mbox/Messages, with
+// Apple Mail's special mailboxes mapped to system labels. It reports false
+// when the file is not inside a mailbox.
+func emlxLabels(rel string) ([]string, bool) {
+	var mailboxes []string
+	for _, dir := range strings.Split(filepath.ToSlash(rel), "/") {
+		lower := strings.ToLower(dir)
+		if strings.HasSuffix(lower, ".mbox") || strings.HasSuffix(lower, ".imapmbox") {
+			mailboxes = append(mailboxes, strings.TrimSuffix(dir, filepath.Ext(dir)))
+		}
+	}
+	if len(mailboxes) == 0 {
+		return nil, false
+	}
+
+	if len(mailboxes) == 1 {
+		if label, ok := appleMailboxes[strings.ToLower(mailboxes[0])]; ok {
+			if label == "" {
+				return nil, true
+			}
+			return []string{label}, true
+		}
+	}
+	return []string{strings.Join(mailboxes, "/")}, true
+}
+
+// importEMLXFile imports an Apple Mail .emlx message
+func (i *Importer) importEMLXFile(filePath string, data []byte) (int64, error) {
+	raw, state, err := parseEMLX(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse emlx: %w", err)
+	}
+
+	if strings.HasSuffix(strings.ToLower(filePath), ".partial.emlx") {
+		logrus.WithField("file", filePath).Warn("Apple Mail stored the attachments of this message separately; importing it without them")
+	}
+
+	labels := headerLabels(raw)
+	if len(labels) == 0 {
+		inMailbox := false
+		if rel, err := filepath.Rel(i.config.InputDir, filepath.Dir(filePath)); err == nil && !strings.HasPrefix(rel, "..") {
+			labels, inMailbox = emlxLabels(rel)
+		}
+		if !inMailbox {
+			labels = i.fileLabels(filePath, raw)
+		}
+	}
+
+	labels = append(labels, state...)
+	if err := i.importMessage(raw, labels, Repair{FilePath: filePath}); err != nil {
+		return 0, fmt.Errorf("failed to import message: %w", err)
+	}
+
+	return int64(len(raw)), nil
+}
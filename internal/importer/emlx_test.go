@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseEMLX(t *testing.T) {
+	message := "From: a@example.com\nSubject: Hello\n\nBody\n"
+	plist := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>date-received</key>
+	<integer>1700000000</integer>
+	<key>flags</key>
+	<integer>%d</integer>
+</dict>
+</plist>
+`
+
+	tests := []struct {
+		name      string
+		data      string
+		wantState []string
+		wantErr   bool
+	}{
+		{"read", "41\n" + message + fmt.Sprintf(plist, 1), nil, false},
+		{"unread and flagged", "41\n" + message + fmt.Sprintf(plist, 16), []string{"UNREAD", "STARRED"}, false},
+		{"no plist", "41\n" + message, nil, false},
+		{"bad length", "abc\n" + message, nil, true},
+		{"truncated", "400\n" + message, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, state, err := parseEMLX([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEMLX() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if string(raw) != message {
+				t.Errorf("Expected the message without the length line and plist, got %q", raw)
+			}
+			if !reflect.DeepEqual(state, tt.wantState) {
+				t.Errorf("parseEMLX() state = %v, want %v", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestEMLXLabels(t *testing.T) {
+	tests := []struct {
+		rel        string
+		want       []string
+		wantInMbox bool
+	}{
+		{"Work.mbox/Messages", []string{"Work"}, true},
+		{"Work.mbox/Clients.mbox/Messages", []string{"Work/Clients"}, true},
+		{"V10/1A2B/INBOX.mbox/3C4D/Data/1/Messages", []string{"INBOX"}, true},
+		{"Sent Messages.mbox/Messages", []string{"SENT"}, true},
+		{"Archive.mbox/Messages", nil, true},
+		{"Backup/Messages", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			got, inMbox := emlxLabels(tt.rel)
+			if !reflect.DeepEqual(got, tt.want) || inMbox != tt.wantInMbox {
+				t.Errorf("emlxLabels(%q) = %v, %v, want %v, %v", tt.rel, got, inMbox, tt.want, tt.wantInMbox)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+	"github.com/octasoft-ltd/gmail-exporter/internal/httpclient"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
+)
+
+// Import backends
+const (
+	BackendGmail = "gmail"
+	BackendGraph = "graph"
+)
+
+// Ways of mapping Gmail user labels into Outlook
+const (
+	LabelModeCategories = "categories"
+	LabelModeFolders    = "folders"
+)
+
+// Microsoft Graph endpoints
+const (
+	defaultGraphBaseURL = "https://graph.microsoft.com/v1.0"
+	graphTokenURL       = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	graphScope          = "https://graph.microsoft.com/.default"
+)
+
+// graphRetryPolicies retry throttled and unavailable Graph requests, which
+// Microsoft asks clients to back off from for longer than Gmail does
+var graphRetryPolicies = retry.Policies{
+	failure.RateLimit: {Retries: 4, Backoff: 2 * time.Second},
+	failure.Server:    {Retries: 4, Backoff: 2 * time.Second},
+}
+
+// GraphConfig configures the Microsoft Graph import backend
+type GraphConfig struct {
+	TenantID      string            `json:"tenant_id"`
+	ClientID      string            `json:"client_id"`
+	ClientSecret  string            `json:"-"`
+	Mailbox       string            `json:"mailbox"`
+	DefaultFolder string            `json:"default_folder"`
+	LabelMode     string            `json:"label_mode"`
+	LabelMap      map[string]string `json:"label_map,omitempty"` // keys are matched case-insensitively
+
+	// BaseURL overrides the Graph endpoint, for testing
+	BaseURL string `json:"-"`
+}
+
+// systemFolders maps Gmail system labels to Outlook well-known folder names.
+// Keys are lower case and cover both label IDs and Takeout label names.
+var systemFolders = map[string]string{
+	"inbox":  "inbox",
+	"sent":   "sentitems",
+	"draft":  "drafts",
+	"drafts": "drafts",
+	"trash":  "deleteditems",
+	"spam":   "junkemail",
+}
+
+// ignoredLabels are Gmail labels that describe message state rather than
+// placement and have no Outlook folder or category equivalent
+var ignoredLabels = map[string]bool{
+	"unread":    true,
+	"opened":    true,
+	"starred":   true,
+	"important": true,
+	"archived":  true,
+	"chat":      true,
+	"unlabeled": true,
+}
+
+// graphPlacement is where a message lands in the Outlook mailbox
+type graphPlacement struct {
+	Folder     []string
+	Categories []string
+	// Unread and Draft are the message state of the UNREAD and DRAFT labels
+	Unread bool
+	Draft  bool
+}
+
+// placeLabels maps a message's Gmail labels to an Outlook folder path and
+// categories. Messages without label information go to the default folder;
+// labelled messages outside the inbox go to the archive, as in Gmail.
+func (c *GraphConfig) placeLabels(labels []string) graphPlacement {
+	var placement graphPlacement
+	if len(labels) == 0 {
+		placement.Folder = strings.Split(c.DefaultFolder, "/")
+		return placement
+	}
+
+	var userLabels []string
+	for _, label := range labels {
+		key := strings.ToLower(strings.TrimSpace(label))
+		switch key {
+		case "unread":
+			placement.Unread = true
+		case "draft", "drafts":
+			placement.Draft = true
+		}
+		if key == "" || ignoredLabels[key] || strings.HasPrefix(key, "category") {
+			continue
+		}
+		if folder, ok := systemFolders[key]; ok {
+			if placement.Folder == nil {
+				placement.Folder = []string{folder}
+			}
+			continue
+		}
+
+		name := strings.TrimSpace(label)
+		if mapped, ok := c.LabelMap[key]; ok {
+			name = mapped
+		}
+		if name != "" {
+			userLabels = append(userLabels, name)
+		}
+	}
+
+	// In folder mode the first user label picks the folder unless a system
+	// label already did; the rest become categories
+	if c.LabelMode == LabelModeFolders && placement.Folder == nil && len(userLabels) > 0 {
+		placement.Folder = strings.Split(userLabels[0], "/")
+		userLabels = userLabels[1:]
+	}
+	if placement.Folder == nil {
+		placement.Folder = []string{"archive"}
+	}
+
+	placement.Categories = userLabels
+	return placement
+}
+
+// GraphError is an error response from Microsoft Graph
+type GraphError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("graph API error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// HTTPStatusCode returns the HTTP status of the response
+func (e *GraphError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// RetryDelay returns the wait the Retry-After header asked for
+func (e *GraphError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// graphClient uploads messages to an Outlook mailbox through Microsoft Graph
+type graphClient struct {
+	config  *GraphConfig
+	http    *http.Client
+	baseURL string
+	retry   *retry.Engine
+	// upload sends attachment chunks to upload sessions, whose URLs need no
+	// token
+	upload *http.Client
+
+	mu      sync.Mutex
+	folders map[string]string // folder path -> folder ID
+}
+
+// newGraphClient creates a Graph client authenticated with the app's client
+// credentials
+func newGraphClient(config *GraphConfig) *graphClient {
+	credentials := &clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     fmt.Sprintf(graphTokenURL, url.PathEscape(config.TenantID)),
+		Scopes:       []string{graphScope},
+	}
+
+	g := newGraphClientWithHTTP(config, credentials.Client(httpclient.Context(context.Background())))
+	g.upload = httpclient.Client()
+	return g
+}
+
+// newGraphClientWithHTTP creates a Graph client using an existing HTTP client
+func newGraphClientWithHTTP(config *GraphConfig, client *http.Client) *graphClient {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = defaultGraphBaseURL
+	}
+
+	return &graphClient{
+		config:  config,
+		http:    client,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		retry:   retry.New(graphRetryPolicies),
+		upload:  client,
+		folders: make(map[string]string),
+	}
+}
+
+// pidTagMessageFlags is the MAPI property of a message's flags, and
+// messageFlagRead its read flag. Graph creates a message uploaded as MIME
+// as an unsent draft; setting the flags without the unsent flag makes it a
+// received message.
+const (
+	pidTagMessageFlags = "Integer 0x0E07"
+	messageFlagRead    = 0x1
+)
+
+// importMessage uploads a raw RFC 822 message to the folder its labels map
+// to, applies the remaining labels as categories, clears the draft flag
+// Graph sets and returns its ID. A message over the Graph request limit is
+// uploaded without its attachments, which are added afterwards; one still
+// over the limit without them fails. Once the message is complete it counts
+// as imported: a failure to update it is only logged, as importing it again
+// would duplicate it.
+func (g *graphClient) importMessage(raw []byte, labels []string) (string, error) {
+	// Graph accepts MIME content as base64 text on the messages endpoint
+	var attachments []graphAttachment
+	if base64.StdEncoding.EncodedLen(len(raw)) > graphMaxRequest {
+		stripped, split, err := splitAttachments(raw)
+		if err != nil {
+			return "", err
+		}
+		if base64.StdEncoding.EncodedLen(len(stripped)) > graphMaxRequest {
+			return "", fmt.Errorf("message is too large for Microsoft Graph: %d bytes without its attachments, over the 4 MB request limit", len(stripped))
+		}
+		raw, attachments = stripped, split
+	}
+
+	placement := g.config.placeLabels(labels)
+
+	folderID, err := g.resolveFolder(placement.Folder)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve folder %s: %w", strings.Join(placement.Folder, "/"), err)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	body := []byte(base64.StdEncoding.EncodeToString(raw))
+	path := fmt.Sprintf("/mailFolders/%s/messages", url.PathEscape(folderID))
+	if err := g.do(http.MethodPost, path, "text/plain", body, &created); err != nil {
+		return "", fmt.Errorf("failed to upload message: %w", err)
+	}
+
+	// A message missing attachments is deleted so a resumed import uploads
+	// it again
+	for _, attachment := range attachments {
+		if err := g.addAttachment(created.ID, attachment); err != nil {
+			if deleteErr := g.do(http.MethodDelete, "/messages/"+url.PathEscape(created.ID), "", nil, nil); deleteErr != nil {
+				logrus.WithError(deleteErr).WithField("message_id", created.ID).
+					Warn("Failed to delete Outlook message left without its attachments")
+			}
+			return "", err
+		}
+	}
+
+	if err := g.updateMessage(created.ID, placement); err != nil {
+		logrus.WithError(err).WithField("message_id", created.ID).
+			Warn("Imported message to Outlook, but failed to set its categories and flags; it may show as a draft")
+	}
+
+	return created.ID, nil
+}
+
+// updateMessage sets the categories and message flags of an uploaded
+// message. Drafts keep the draft flag Graph gave them.
+func (g *graphClient) updateMessage(id string, placement graphPlacement) error {
+	update := make(map[string]any)
+	if len(placement.Categories) > 0 {
+		update["categories"] = placement.Categories
+	}
+	if !placement.Draft {
+		flags := messageFlagRead
+		if placement.Unread {
+			flags = 0
+		}
+		update["singleValueExtendedProperties"] = []map[string]string{
+			{"id": pidTagMessageFlags, "value": strconv.Itoa(flags)},
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to encode message update: %w", err)
+	}
+	if err := g.do(http.MethodPatch, "/messages/"+url.PathEscape(id), "application/json", patch, nil); err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+	return nil
+}
+
+// resolveFolder returns the ID of a folder path, creating missing user
+// folders under the mailbox root. Well-known folder names are used as is.
+func (g *graphClient) resolveFolder(folderPath []string) (string, error) {
+	if len(folderPath) == 1 && isWellKnownFolder(folderPath[0]) {
+		return folderPath[0], nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	parentID := "msgfolderroot"
+	for depth := range folderPath {
+		key := strings.Join(folderPath[:depth+1], "/")
+		if id, ok := g.folders[key]; ok {
+			parentID = id
+			continue
+		}
+
+		id, err := g.childFolder(parentID, folderPath[depth])
+		if err != nil {
+			return "", err
+		}
+		g.folders[key] = id
+		parentID = id
+	}
+
+	return parentID, nil
+}
+
+// childFolder returns the ID of the named child folder, creating it if needed
+func (g *graphClient) childFolder(parentID, name string) (string, error) {
+	var existing struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	filter := url.QueryEscape(fmt.Sprintf("displayName eq '%s'", strings.ReplaceAll(name, "'", "''")))
+	path := fmt.Sprintf("/mailFolders/%s/childFolders?$filter=%s", url.PathEscape(parentID), filter)
+	if err := g.do(http.MethodGet, path, "", nil, &existing); err != nil {
+		return "", fmt.Errorf("failed to look up folder %q: %w", name, err)
+	}
+	if len(existing.Value) > 0 {
+		return existing.Value[0].ID, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"displayName": name})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode folder: %w", err)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	path = fmt.Sprintf("/mailFolders/%s/childFolders", url.PathEscape(parentID))
+	if err := g.do(http.MethodPost, path, "application/json", body, &created); err != nil {
+		return "", fmt.Errorf("failed to create folder %q: %w", name, err)
+	}
+
+	logrus.WithField("folder", name).Info("Created Outlook folder")
+	return created.ID, nil
+}
+
+// do sends a request for the configured mailbox, retrying failures under
+// the retry policy of their category, and decodes a JSON response into out
+// if non-nil
+func (g *graphClient) do(method, path, contentType string, body []byte, out any) error {
+	endpoint := fmt.Sprintf("%s/users/%s%s", g.baseURL, url.PathEscape(g.config.Mailbox), path)
+
+	return g.retry.Do(func() error {
+		return g.send(method, endpoint, contentType, body, out)
+	}, func(err error, attempt int, wait time.Duration) {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"backoff": wait,
+		}).Debug("Retrying Graph API call")
+	})
+}
+
+// send performs a single Graph request
+func (g *graphClient) send(method, endpoint, contentType string, body []byte, out any) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return parseGraphError(resp, data)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseGraphError builds a GraphError from an error response
+func parseGraphError(resp *http.Response, data []byte) *GraphError {
+	graphErr := &GraphError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error.Code != "" {
+		graphErr.Code = body.Error.Code
+		graphErr.Message = body.Error.Message
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		graphErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return graphErr
+}
+
+// isWellKnownFolder reports whether name is an Outlook well-known folder name
+func isWellKnownFolder(name string) bool {
+	switch name {
+	case "inbox", "sentitems", "drafts", "deleteditems", "junkemail", "archive":
+		return true
+	}
+	return false
+}
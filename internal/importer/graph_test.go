@@ -0,0 +1,341 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPlaceLabels(t *testing.T) {
+	config := &GraphConfig{
+		DefaultFolder: "inbox",
+		LabelMode:     LabelModeCategories,
+		LabelMap:      map[string]string{"label_12": "Projects"},
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		labels     []string
+		folder     []string
+		categories []string
+	}{
+		{"no labels", "", nil, []string{"inbox"}, nil},
+		{"inbox", "", []string{"INBOX", "UNREAD", "CATEGORY_UPDATES"}, []string{"inbox"}, nil},
+		{"sent with user label", "", []string{"SENT", "Label_12"}, []string{"sentitems"}, []string{"Projects"}},
+		{"takeout names", "", []string{"Opened", "Spam"}, []string{"junkemail"}, nil},
+		{"archived user label", "", []string{"Receipts"}, []string{"archive"}, []string{"Receipts"}},
+		{"folder mode", LabelModeFolders, []string{"Work/Clients", "Receipts"}, []string{"Work", "Clients"}, []string{"Receipts"}},
+		{"folder mode inbox wins", LabelModeFolders, []string{"Receipts", "INBOX"}, []string{"inbox"}, []string{"Receipts"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *config
+			if tt.mode != "" {
+				c.LabelMode = tt.mode
+			}
+			got := c.placeLabels(tt.labels)
+			if !reflect.DeepEqual(got.Folder, tt.folder) {
+				t.Errorf("folder = %v, want %v", got.Folder, tt.folder)
+			}
+			if !reflect.DeepEqual(got.Categories, tt.categories) {
+				t.Errorf("categories = %v, want %v", got.Categories, tt.categories)
+			}
+		})
+	}
+}
+
+// fakeGraph records requests made against a minimal Graph mailbox
+type fakeGraph struct {
+	mu        sync.Mutex
+	requests  []string
+	uploaded  []byte
+	patched   graphPatch
+	failPatch bool
+
+	// uploadURL is returned for upload sessions; attached collects the
+	// attachments added, by name
+	uploadURL string
+	attached  map[string][]byte
+}
+
+// graphPatch is the body of a message update
+type graphPatch struct {
+	Categories                    []string `json:"categories"`
+	SingleValueExtendedProperties []struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	} `json:"singleValueExtendedProperties"`
+}
+
+func (f *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/childFolders"):
+		_, _ = w.Write([]byte(`{"value":[]}`))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/childFolders"):
+		_, _ = w.Write([]byte(`{"id":"folder-1"}`))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/attachments"):
+		var attachment struct {
+			Name         string `json:"name"`
+			ContentBytes []byte `json:"contentBytes"`
+		}
+		_ = json.Unmarshal(body, &attachment)
+		f.attach(attachment.Name, attachment.ContentBytes)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{}`))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/createUploadSession"):
+		var session struct {
+			AttachmentItem struct {
+				Name string `json:"name"`
+			}
+		}
+		_ = json.Unmarshal(body, &session)
+		_, _ = w.Write([]byte(`{"uploadUrl":"` + f.uploadURL + `/upload/` + session.AttachmentItem.Name + `"}`))
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/upload/"):
+		name := strings.TrimPrefix(r.URL.Path, "/upload/")
+		if !strings.HasPrefix(r.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", len(f.attached[name]))) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		f.attach(name, body)
+		_, _ = w.Write([]byte(`{}`))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/messages"):
+		if r.Header.Get("Content-Type") != "text/plain" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.uploaded, _ = base64.StdEncoding.DecodeString(string(body))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"msg-1"}`))
+	case r.Method == http.MethodPatch:
+		if f.failPatch {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":"ErrorInvalidProperty","message":"read-only property"}}`))
+			return
+		}
+		f.patched = graphPatch{}
+		_ = json.Unmarshal(body, &f.patched)
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":"ErrorItemNotFound","message":"not found"}}`))
+	}
+}
+
+// attach appends data to the named attachment
+func (f *fakeGraph) attach(name string, data []byte) {
+	if f.attached == nil {
+		f.attached = make(map[string][]byte)
+	}
+	f.attached[name] = append(f.attached[name], data...)
+}
+
+func TestGraphClient_ImportMessage(t *testing.T) {
+	fake := &fakeGraph{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := &GraphConfig{
+		Mailbox:       "user@example.com",
+		DefaultFolder: "inbox",
+		LabelMode:     LabelModeFolders,
+		BaseURL:       server.URL,
+	}
+	client := newGraphClientWithHTTP(config, server.Client())
+
+	raw := []byte("Subject: Hello\r\n\r\nBody\r\n")
+	for range 2 {
+		if _, err := client.importMessage(raw, []string{"Receipts", "Travel"}); err != nil {
+			t.Fatalf("importMessage() error = %v", err)
+		}
+	}
+
+	if string(fake.uploaded) != string(raw) {
+		t.Errorf("uploaded = %q, want %q", fake.uploaded, raw)
+	}
+	if got := fake.patched.Categories; !reflect.DeepEqual(got, []string{"Travel"}) {
+		t.Errorf("categories = %v, want [Travel]", got)
+	}
+	if got := fake.patched.SingleValueExtendedProperties; len(got) != 1 || got[0].ID != pidTagMessageFlags || got[0].Value != "1" {
+		t.Errorf("extended properties = %+v, want the read flag without the unsent flag", got)
+	}
+
+	// The folder is looked up and created once, then cached
+	want := []string{
+		"GET /users/user@example.com/mailFolders/msgfolderroot/childFolders",
+		"POST /users/user@example.com/mailFolders/msgfolderroot/childFolders",
+		"POST /users/user@example.com/mailFolders/folder-1/messages",
+		"PATCH /users/user@example.com/messages/msg-1",
+		"POST /users/user@example.com/mailFolders/folder-1/messages",
+		"PATCH /users/user@example.com/messages/msg-1",
+	}
+	if !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %v, want %v", fake.requests, want)
+	}
+}
+
+func TestGraphClient_LargeMessage(t *testing.T) {
+	fake := &fakeGraph{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.uploadURL = server.URL
+	config := &GraphConfig{Mailbox: "user@example.com", DefaultFolder: "inbox", BaseURL: server.URL}
+	client := newGraphClientWithHTTP(config, server.Client())
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 5<<20/16)
+	small := []byte("%PDF-")
+	raw := "Subject: Photos\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached\r\n" +
+		"--b\r\nContent-Type: application/zip; name=\"photos.zip\"\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		base64.StdEncoding.EncodeToString(large) + "\r\n" +
+		"--b\r\nContent-Disposition: attachment; filename=\"scan.pdf\"\r\nContent-Type: application/pdf\r\n\r\n" +
+		string(small) + "\r\n--b--\r\n"
+
+	if _, err := client.importMessage([]byte(raw), []string{"INBOX"}); err != nil {
+		t.Fatalf("importMessage() error = %v", err)
+	}
+
+	// The message is uploaded without its attachments, which are added
+	// afterwards, the large one in chunks
+	if strings.Contains(string(fake.uploaded), "photos.zip") || !strings.Contains(string(fake.uploaded), "See attached") {
+		t.Errorf("uploaded = %q, want the message without its attachments", fake.uploaded)
+	}
+	if !bytes.Equal(fake.attached["photos.zip"], large) || !bytes.Equal(fake.attached["scan.pdf"], small) {
+		t.Errorf("Expected both attachments added whole, got %d and %d bytes", len(fake.attached["photos.zip"]), len(fake.attached["scan.pdf"]))
+	}
+	var chunks int
+	for _, request := range fake.requests {
+		if strings.HasPrefix(request, "PUT /upload/photos.zip") {
+			chunks++
+		}
+	}
+	if chunks != 2 {
+		t.Errorf("Expected the large attachment in 2 chunks, got %d", chunks)
+	}
+	if last := fake.requests[len(fake.requests)-1]; last != "PATCH /users/user@example.com/messages/msg-1" {
+		t.Errorf("Expected the message flags set last, got %s", last)
+	}
+}
+
+func TestGraphClient_TooLarge(t *testing.T) {
+	fake := &fakeGraph{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	config := &GraphConfig{Mailbox: "user@example.com", DefaultFolder: "inbox", BaseURL: server.URL}
+	client := newGraphClientWithHTTP(config, server.Client())
+
+	raw := "Subject: Big\r\n\r\n" + strings.Repeat("x", 4<<20)
+	_, err := client.importMessage([]byte(raw), nil)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("importMessage() error = %v, want a too large error", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("Expected nothing sent, got %v", fake.requests)
+	}
+}
+
+func TestGraphClient_MessageFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		want   string
+	}{
+		{"read", []string{"INBOX"}, "1"},
+		{"unread", []string{"INBOX", "UNREAD"}, "0"},
+		{"draft", []string{"DRAFT"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGraph{}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			config := &GraphConfig{Mailbox: "user@example.com", DefaultFolder: "inbox", BaseURL: server.URL}
+			client := newGraphClientWithHTTP(config, server.Client())
+
+			if _, err := client.importMessage([]byte("Subject: x\r\n\r\n"), tt.labels); err != nil {
+				t.Fatalf("importMessage() error = %v", err)
+			}
+			var got string
+			for _, property := range fake.patched.SingleValueExtendedProperties {
+				if property.ID == pidTagMessageFlags {
+					got = property.Value
+				}
+			}
+			if got != tt.want {
+				t.Errorf("message flags = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphClient_UpdateFailure(t *testing.T) {
+	fake := &fakeGraph{failPatch: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	config := &GraphConfig{Mailbox: "user@example.com", DefaultFolder: "inbox", BaseURL: server.URL}
+	client := newGraphClientWithHTTP(config, server.Client())
+
+	// The uploaded message is imported even though it could not be updated,
+	// so that a resumed import does not upload it again
+	id, err := client.importMessage([]byte("Subject: x\r\n\r\n"), []string{"INBOX", "Travel"})
+	if err != nil || id != "msg-1" {
+		t.Errorf("importMessage() = %q, %v, want the uploaded message", id, err)
+	}
+}
+
+func TestGraphClient_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":{"code":"ErrorAccessDenied","message":"Access is denied."}}`))
+	}))
+	defer server.Close()
+
+	config := &GraphConfig{Mailbox: "user@example.com", DefaultFolder: "inbox", BaseURL: server.URL}
+	client := newGraphClientWithHTTP(config, server.Client())
+
+	_, err := client.importMessage([]byte("Subject: x\r\n\r\n"), nil)
+	var graphErr *GraphError
+	if !errors.As(err, &graphErr) {
+		t.Fatalf("expected GraphError, got %v", err)
+	}
+	if graphErr.StatusCode != http.StatusForbidden || graphErr.Code != "ErrorAccessDenied" {
+		t.Errorf("unexpected error: %+v", graphErr)
+	}
+}
+
+func TestHeaderLabels(t *testing.T) {
+	data := []byte("From 1234@xxx Mon Jan 01 00:00:00 +0000 2024\nX-Gmail-Labels: Inbox, Work,Opened\nSubject: x\n\nbody\n")
+	got := headerLabels(data)
+	want := []string{"Inbox", "Work", "Opened"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("headerLabels() = %v, want %v", got, want)
+	}
+
+	// Folded as written by export --gmail-labels-header
+	folded := []byte("X-GM-THRID: 1784169778438977537\r\nX-Gmail-Labels: Inbox,Starred,\r\n Projects/Apollo,Opened\r\nSubject: x\r\n\r\nbody\r\n")
+	want = []string{"Inbox", "Starred", "Projects/Apollo", "Opened"}
+	if got := headerLabels(folded); !reflect.DeepEqual(got, want) {
+		t.Errorf("headerLabels() = %v, want %v", got, want)
+	}
+
+	if labels := headerLabels([]byte("Subject: x\n\nbody\n")); labels != nil {
+		t.Errorf("expected no labels, got %v", labels)
+	}
+}
@@ -0,0 +1,252 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// Microsoft Graph size limits. Requests are at most 4 MB, so a message
+// whose base64 MIME content is larger is uploaded without its attachments,
+// which are then added one by one: small ones in a single request, larger
+// ones through an upload session in chunks that are multiples of 320 KiB.
+// Outlook takes attachments of up to 150 MB.
+const (
+	graphMaxRequest    = 4 << 20
+	graphMaxSimpleFile = 3 << 20
+	graphChunkSize     = 10 * 320 << 10
+	graphMaxAttachment = 150 << 20
+)
+
+// graphMaxDepth bounds the nesting of multipart bodies searched for
+// attachments
+const graphMaxDepth = 10
+
+// graphAttachment is an attachment moved out of a message too large to
+// upload in one request
+type graphAttachment struct {
+	Name        string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Data        []byte
+}
+
+// splitAttachments returns a message without its attachment parts and the
+// attachments, decoded. Messages that are not multipart have none.
+func splitAttachments(raw []byte) ([]byte, []graphAttachment, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return raw, nil, nil
+	}
+
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+	stripped, attachments, err := splitMultipart(body, params["boundary"], 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := raw[:len(raw)-len(body)]
+	return append(append([]byte(nil), header...), stripped...), attachments, nil
+}
+
+// splitMultipart rewrites a multipart body without its attachment parts,
+// searching nested multipart parts, and returns the attachments
+func splitMultipart(body []byte, boundary string, depth int) ([]byte, []graphAttachment, error) {
+	var buf bytes.Buffer
+	var attachments []graphAttachment
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read message part: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read message part: %w", err)
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if strings.HasPrefix(mediaType, "multipart/") && depth < graphMaxDepth {
+			inner, innerAttachments, err := splitMultipart(data, params["boundary"], depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			data = inner
+			attachments = append(attachments, innerAttachments...)
+		} else if attachment, ok := partAttachment(part.Header, mediaType, params, data); ok {
+			attachments = append(attachments, attachment)
+			continue
+		}
+
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		writePartHeader(&buf, part.Header)
+		buf.Write(data)
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), attachments, nil
+}
+
+// partAttachment returns the attachment of a part with a file name or an
+// attachment disposition, decoded from its transfer encoding
+func partAttachment(header textproto.MIMEHeader, mediaType string, params map[string]string, data []byte) (graphAttachment, bool) {
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if name == "" && disposition != "attachment" {
+		return graphAttachment{}, false
+	}
+
+	decoded, err := io.ReadAll(mimepart.DecodeBody(bytes.NewReader(data), header.Get("Content-Transfer-Encoding")))
+	if err != nil {
+		return graphAttachment{}, false
+	}
+	if name == "" {
+		name = "attachment"
+	}
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return graphAttachment{
+		Name:        mimepart.DecodeHeader(name),
+		ContentType: mediaType,
+		ContentID:   strings.Trim(header.Get("Content-Id"), "<>"),
+		Inline:      disposition == "inline",
+		Data:        decoded,
+	}, true
+}
+
+// writePartHeader writes the headers of a part, sorted by name, and the
+// blank line ending them
+func writePartHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(buf, "%s: %s\r\n", name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+// addAttachment adds an attachment to an uploaded message, in one request
+// when it fits and through an upload session otherwise
+func (g *graphClient) addAttachment(messageID string, attachment graphAttachment) error {
+	if len(attachment.Data) > graphMaxAttachment {
+		return fmt.Errorf("attachment %q is %d bytes, over the Outlook limit of %d", attachment.Name, len(attachment.Data), graphMaxAttachment)
+	}
+
+	path := "/messages/" + url.PathEscape(messageID) + "/attachments"
+	if len(attachment.Data) <= graphMaxSimpleFile {
+		body, err := json.Marshal(map[string]any{
+			"@odata.type":  "#microsoft.graph.fileAttachment",
+			"name":         attachment.Name,
+			"contentType":  attachment.ContentType,
+			"contentId":    attachment.ContentID,
+			"isInline":     attachment.Inline,
+			"contentBytes": base64.StdEncoding.EncodeToString(attachment.Data),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode attachment: %w", err)
+		}
+		if err := g.do(http.MethodPost, path, "application/json", body, nil); err != nil {
+			return fmt.Errorf("failed to add attachment %q: %w", attachment.Name, err)
+		}
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"AttachmentItem": map[string]any{
+			"attachmentType": "file",
+			"name":           attachment.Name,
+			"size":           len(attachment.Data),
+			"contentType":    attachment.ContentType,
+			"contentId":      attachment.ContentID,
+			"isInline":       attachment.Inline,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode upload session: %w", err)
+	}
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := g.do(http.MethodPost, path+"/createUploadSession", "application/json", body, &session); err != nil {
+		return fmt.Errorf("failed to create upload session for attachment %q: %w", attachment.Name, err)
+	}
+
+	for start := 0; start < len(attachment.Data); start += graphChunkSize {
+		chunk := attachment.Data[start:min(start+graphChunkSize, len(attachment.Data))]
+		err := g.retry.Do(func() error {
+			return g.uploadChunk(session.UploadURL, chunk, start, len(attachment.Data))
+		}, func(err error, attempt int, wait time.Duration) {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"attempt": attempt + 1,
+				"backoff": wait,
+			}).Debug("Retrying attachment upload")
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload attachment %q: %w", attachment.Name, err)
+		}
+	}
+	return nil
+}
+
+// uploadChunk sends a range of an attachment to its upload session. The
+// upload URL is authorized by itself, so the request carries no token.
+func (g *graphClient) uploadChunk(uploadURL string, chunk []byte, start, total int) error {
+	req, err := http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+len(chunk)-1, total))
+
+	resp, err := g.upload.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return parseGraphError(resp, data)
+	}
+	return nil
+}
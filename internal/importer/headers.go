@@ -0,0 +1,157 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// HeaderTransform drops, renames and adds message headers before upload.
+// Header names match case-insensitively, and a trailing * in Drop matches
+// every header with that prefix (e.g. "ARC-*").
+type HeaderTransform struct {
+	Drop   []string          `json:"drop,omitempty"`
+	Rename map[string]string `json:"rename,omitempty"`
+	// Add lists "Name: value" headers appended to every message
+	Add []string `json:"add,omitempty"`
+}
+
+// empty reports whether the transform leaves messages unchanged
+func (t *HeaderTransform) empty() bool {
+	return len(t.Drop) == 0 && len(t.Rename) == 0 && len(t.Add) == 0
+}
+
+// validate checks the header names and normalizes them for matching
+func (t *HeaderTransform) validate() error {
+	for i, name := range t.Drop {
+		name = strings.TrimSpace(name)
+		if !validHeaderName(strings.TrimSuffix(name, "*")) {
+			return fmt.Errorf("invalid header to drop: %q", name)
+		}
+		t.Drop[i] = strings.ToLower(name)
+	}
+
+	rename := make(map[string]string, len(t.Rename))
+	for from, to := range t.Rename {
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !validHeaderName(from) || !validHeaderName(to) {
+			return fmt.Errorf("invalid header rename: %q=%q", from, to)
+		}
+		rename[strings.ToLower(from)] = to
+	}
+	t.Rename = rename
+
+	for i, field := range t.Add {
+		name, value, ok := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header to add: %q (want \"Name: value\")", field)
+		}
+		t.Add[i] = name + ": " + strings.TrimSpace(value)
+	}
+
+	return nil
+}
+
+// validHeaderName reports whether name is a non-empty RFC 5322 field name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// dropped reports whether the lower-cased header name is to be dropped
+func (t *HeaderTransform) dropped(name string) bool {
+	for _, pattern := range t.Drop {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns raw with the header transform applied to its header block.
+// The body is left untouched, and folded header lines are handled as part of
+// their field.
+func (t *HeaderTransform) apply(raw []byte) []byte {
+	if t.empty() {
+		return raw
+	}
+
+	header, body, newline := splitHeader(raw)
+
+	var out bytes.Buffer
+	out.Grow(len(raw))
+
+	for _, field := range headerFields(header) {
+		colon := bytes.IndexByte(field, ':')
+		if colon <= 0 {
+			out.Write(field)
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(string(field[:colon])))
+		if t.dropped(name) {
+			continue
+		}
+		if to, ok := t.Rename[name]; ok {
+			out.WriteString(to)
+			out.Write(field[colon:])
+			continue
+		}
+		out.Write(field)
+	}
+
+	if len(t.Add) > 0 && out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.Write(newline)
+	}
+	for _, field := range t.Add {
+		out.WriteString(field)
+		out.Write(newline)
+	}
+
+	out.Write(body)
+	return out.Bytes()
+}
+
+// splitHeader splits a raw message into its header block, including the
+// newline ending the last field, and the body, starting with the blank
+// separator line. It also returns the message's line ending.
+func splitHeader(raw []byte) (header, body, newline []byte) {
+	newline = []byte("\r\n")
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if lf := bytes.Index(raw, []byte("\n\n")); lf >= 0 && (end < 0 || lf < end) {
+		newline, end = []byte("\n"), lf
+	}
+	if end < 0 {
+		return raw, nil, newline
+	}
+	return raw[:end+len(newline)], raw[end+len(newline):], newline
+}
+
+// headerFields splits a header block into fields, each with its folded
+// continuation lines and line endings
+func headerFields(header []byte) [][]byte {
+	var fields [][]byte
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if n := len(fields); n > 0 && (line[0] == ' ' || line[0] == '\t') {
+			fields[n-1] = append(fields[n-1], line...)
+			continue
+		}
+		fields = append(fields, append([]byte(nil), line...))
+	}
+	return fields
+}
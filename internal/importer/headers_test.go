@@ -0,0 +1,75 @@
+package importer
+
+import (
+	"testing"
+)
+
+func TestHeaderTransformApply(t *testing.T) {
+	raw := "DKIM-Signature: v=1; a=rsa-sha256;\r\n" +
+		"\tb=abcdef\r\n" +
+		"ARC-Seal: i=1\r\n" +
+		"From: alice@example.com\r\n" +
+		"List-Unsubscribe: <mailto:unsub@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"DKIM-Signature: in the body stays\r\n"
+
+	transform := &HeaderTransform{
+		Drop:   []string{"dkim-signature", "ARC-*"},
+		Rename: map[string]string{"List-Unsubscribe": "X-Original-List-Unsubscribe"},
+		Add:    []string{"X-Migrated:  yes"},
+	}
+	if err := transform.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	got := string(transform.apply([]byte(raw)))
+	want := "From: alice@example.com\r\n" +
+		"X-Original-List-Unsubscribe: <mailto:unsub@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"X-Migrated: yes\r\n" +
+		"\r\n" +
+		"DKIM-Signature: in the body stays\r\n"
+	if got != want {
+		t.Errorf("apply() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestHeaderTransformApply_LF(t *testing.T) {
+	transform := &HeaderTransform{Add: []string{"X-Migrated: yes"}}
+	if err := transform.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(transform.apply([]byte("Subject: Hi\n\nBody\n")))
+	if want := "Subject: Hi\nX-Migrated: yes\n\nBody\n"; got != want {
+		t.Errorf("apply() = %q, want %q", got, want)
+	}
+}
+
+func TestHeaderTransformApply_Empty(t *testing.T) {
+	raw := []byte("Subject: Hi\r\n\r\nBody")
+	if got := (&HeaderTransform{}).apply(raw); string(got) != string(raw) {
+		t.Errorf("Expected message unchanged, got %q", got)
+	}
+}
+
+func TestHeaderTransformValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform HeaderTransform
+	}{
+		{name: "drop with space", transform: HeaderTransform{Drop: []string{"Bad Header"}}},
+		{name: "rename to empty", transform: HeaderTransform{Rename: map[string]string{"Subject": ""}}},
+		{name: "add without colon", transform: HeaderTransform{Add: []string{"X-Migrated"}}},
+		{name: "add with newline", transform: HeaderTransform{Add: []string{"X-Migrated: a\r\nBcc: b"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.transform.validate(); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
@@ -1,46 +1,118 @@
 package importer
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/gmail/v1"
 
+	"github.com/octasoft-ltd/gmail-exporter/internal/audit"
 	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/console"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
 	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+	"github.com/octasoft-ltd/gmail-exporter/internal/outlook"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
 )
 
 // Config represents the importer configuration
 type Config struct {
 	CredentialsFile string `json:"credentials_file"`
 	TokenFile       string `json:"token_file"`
+	AuthMode        string `json:"auth_mode"`
 	InputDir        string `json:"input_dir"`
 	ParallelWorkers int    `json:"parallel_workers"`
 	PreserveDates   bool   `json:"preserve_dates"`
 	Limit           int    `json:"limit"`
+
+	// Resume continues from the progress saved in StateFile (default
+	// import_state.json next to the input directory)
+	Resume    bool   `json:"resume"`
+	StateFile string `json:"state_file"`
+
+	// Backend selects the destination: "gmail" (default) or "graph" for an
+	// Outlook / Exchange Online mailbox
+	Backend string      `json:"backend"`
+	Graph   GraphConfig `json:"graph"`
+
+	// Headers drops, renames and adds headers of each message before upload
+	Headers HeaderTransform `json:"headers"`
+
+	// RepairMIME fixes broken MIME structure before upload (line endings,
+	// missing headers and boundaries, bad encodings) and records the repairs
+	// in import_repairs.json next to the input directory
+	RepairMIME bool `json:"repair_mime,omitempty"`
+
+	// RewriteAddresses maps old domains or addresses to new ones in the
+	// address headers (From, To, Cc, ...) of each message before upload
+	RewriteAddresses map[string]string `json:"rewrite_addresses,omitempty"`
+
+	// Retry overrides the retry policies of failure categories for Gmail
+	// and Graph API calls
+	Retry retry.Policies `json:"retry,omitempty"`
+
+	// SkipExisting searches the destination for each message's Message-ID
+	// before upload and skips messages that are already there
+	SkipExisting bool `json:"skip_existing,omitempty"`
+
+	// OnlyLabels imports only the messages with at least one of these
+	// labels and skips the others
+	OnlyLabels []string `json:"only_labels,omitempty"`
+
+	// LabelPrefix files every imported message under this label, with its
+	// user labels nested below it and out of the inbox, such as to hand a
+	// former colleague's mail to another account
+	LabelPrefix string `json:"label_prefix,omitempty"`
+
+	// AuditLog is the audit log each imported message is recorded in (no
+	// audit log when empty), its entries signed with the Ed25519 key of
+	// AuditSigningKey when set
+	AuditLog        string `json:"audit_log,omitempty"`
+	AuditSigningKey string `json:"audit_signing_key,omitempty"`
 }
 
 // Result represents the import operation result
 type Result struct {
-	TotalFound    int           `json:"total_found"`
-	TotalImported int           `json:"total_imported"`
-	TotalFailed   int           `json:"total_failed"`
+	TotalFound    int `json:"total_found"`
+	TotalImported int `json:"total_imported"`
+	TotalFailed   int `json:"total_failed"`
+	TotalSkipped  int `json:"total_skipped,omitempty"`
+	// TotalDuplicates counts messages skipped by SkipExisting
+	TotalDuplicates int `json:"total_duplicates,omitempty"`
+	// TotalFiltered counts messages skipped by OnlyLabels
+	TotalFiltered int           `json:"total_filtered,omitempty"`
+	TotalRepaired int           `json:"total_repaired,omitempty"`
 	TotalSize     int64         `json:"total_size"`
 	Duration      time.Duration `json:"duration"`
 	Failures      []Failure     `json:"failures,omitempty"`
+
+	// FailedByCategory counts failures by error category (auth, rate_limit, ...)
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+
+	// Cancelled is set when the import was stopped by Cancel before all
+	// messages were imported
+	Cancelled bool `json:"cancelled,omitempty"`
 }
 
 // Failure represents a failed import operation
 type Failure struct {
 	FilePath  string    `json:"file_path"`
+	Message   int       `json:"message,omitempty"` // 1-based position within an mbox file
+	Category  string    `json:"category"`
 	Error     string    `json:"error"`
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -50,7 +122,21 @@ type Importer struct {
 	config        *Config
 	authenticator *auth.Authenticator
 	gmailService  *gmail.Service
+	labels        *labelResolver
+	graph         *graphClient
+	addresses     *addressRewriter
 	metrics       *metrics.Collector
+	retry         *retry.Engine
+	audit         *audit.Log
+
+	// state holds the read, starred and importance state recorded by the
+	// exporter, keyed by export file
+	state map[string][]string
+
+	repairsMu sync.Mutex
+	repairs   []Repair
+
+	cancelled atomic.Bool
 }
 
 // New creates a new importer instance
@@ -60,26 +146,44 @@ func New(config *Config) (*Importer, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	// Create authenticator
-	authenticator, err := auth.NewAuthenticator(config.CredentialsFile, config.TokenFile)
+	addresses, err := newAddressRewriter(config.RewriteAddresses)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create authenticator: %w", err)
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Create metrics collector
+	metricsCollector := metrics.NewCollector("import")
+
+	if config.Backend == BackendGraph {
+		graph := newGraphClient(&config.Graph)
+		graph.retry = retry.New(graphRetryPolicies.Merge(config.Retry))
+		return &Importer{
+			config:    config,
+			graph:     graph,
+			addresses: addresses,
+			metrics:   metricsCollector,
+		}, nil
 	}
 
 	// Get Gmail service
-	gmailService, err := authenticator.GetGmailService()
+	authenticator, gmailService, err := auth.NewGmailService(config.AuthMode, auth.AccessModify, config.CredentialsFile, config.TokenFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Gmail service: %w", err)
 	}
 
-	// Create metrics collector
-	metricsCollector := metrics.NewCollector("import")
+	engine := retry.New(retry.Defaults().Merge(config.Retry))
+	if authenticator != nil {
+		engine.SetReauth(authenticator.Reauthenticate)
+	}
 
 	return &Importer{
 		config:        config,
 		authenticator: authenticator,
 		gmailService:  gmailService,
+		labels:        newLabelResolver(gmailService),
+		addresses:     addresses,
 		metrics:       metricsCollector,
+		retry:         engine,
 	}, nil
 }
 
@@ -90,6 +194,7 @@ func (i *Importer) Import() (*Result, error) {
 
 	logrus.WithFields(logrus.Fields{
 		"input_dir": i.config.InputDir,
+		"backend":   i.backendName(),
 		"limit":     i.config.Limit,
 	}).Info("Starting email import")
 
@@ -101,11 +206,22 @@ func (i *Importer) Import() (*Result, error) {
 
 	logrus.WithField("count", len(emailFiles)).Info("Found email files to import")
 
-	// Apply limit if specified
-	if i.config.Limit > 0 && len(emailFiles) > i.config.Limit {
-		emailFiles = emailFiles[:i.config.Limit]
-		logrus.WithField("limited_count", len(emailFiles)).Info("Limited number of files to process")
+	// Nothing is imported unless it can be audited
+	if err := i.openAudit(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := i.audit.Close(); err != nil {
+			logrus.WithError(err).Warn("Failed to close audit log")
+		}
+	}()
+
+	// Re-apply the message state the exporter recorded beside the files
+	state, err := loadMessageState(i.config.InputDir)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to load message state; importing without it")
 	}
+	i.state = state
 
 	// Import emails
 	result, err := i.importEmails(emailFiles)
@@ -115,13 +231,17 @@ func (i *Importer) Import() (*Result, error) {
 
 	// Calculate duration
 	result.Duration = time.Since(startTime)
-	result.TotalFound = len(emailFiles)
 
-	// Record metrics
-	i.metrics.RecordEmailsProcessed(result.TotalImported, result.TotalFailed)
-	i.metrics.RecordBytesProcessed(result.TotalSize)
+	// Report the messages whose MIME structure was repaired
+	result.TotalRepaired = len(i.repairs)
+	if len(i.repairs) > 0 {
+		if err := i.saveRepairs(); err != nil {
+			logrus.WithError(err).Warn("Failed to save repairs report")
+		}
+	}
+
+	// Record metrics (email and byte counts are recorded live by the workers)
 	i.metrics.RecordDuration(result.Duration)
-	i.metrics.SetTotalMatched(result.TotalFound)
 
 	// Save metrics
 	metricsPath := filepath.Join(filepath.Dir(i.config.InputDir), "import_metrics.json")
@@ -133,13 +253,27 @@ func (i *Importer) Import() (*Result, error) {
 		"total_found":    result.TotalFound,
 		"total_imported": result.TotalImported,
 		"total_failed":   result.TotalFailed,
+		"total_skipped":  result.TotalSkipped,
 		"duration":       result.Duration,
 	}).Info("Import completed")
 
 	return result, nil
 }
 
-// findEmailFiles finds all email files in the input directory
+// exportRecords are the JSON files the exporter writes next to the messages
+// it exports, which are not messages themselves
+var exportRecords = map[string]bool{
+	exporter.ProcessedEmailsFile: true,
+	"metrics.json":               true,
+	"labels_index.json":          true,
+	"skipped.json":               true,
+	"triage_report.json":         true,
+	"custody_manifest.json":      true,
+	"legal_hold.json":            true,
+}
+
+// findEmailFiles finds all email files in the input directory, leaving out
+// the exporter's own records and hidden files such as its resume state
 func (i *Importer) findEmailFiles() ([]string, error) {
 	var emailFiles []string
 
@@ -151,10 +285,13 @@ func (i *Importer) findEmailFiles() ([]string, error) {
 		if d.IsDir() {
 			return nil
 		}
+		if exportRecords[d.Name()] || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
 
 		// Check for supported email file extensions
 		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".eml" || ext == ".json" || ext == ".mbox" {
+		if ext == ".eml" || ext == ".emlx" || ext == ".msg" || ext == ".json" || ext == ".mbox" {
 			emailFiles = append(emailFiles, path)
 		}
 
@@ -167,7 +304,33 @@ func (i *Importer) findEmailFiles() ([]string, error) {
 	return emailFiles, nil
 }
 
-// importEmails imports the specified email files
+// importSource is an input file still to be imported
+type importSource struct {
+	Path string
+	// From is where to resume an mbox file
+	From mboxProgress
+}
+
+// importJob is a single file, or a single message of an mbox file, to import
+type importJob struct {
+	FilePath string
+	Message  *mboxMessage
+	// Retry marks an mbox message that failed in a previous run
+	Retry bool
+	// Err is a failure to read the input before it could be imported
+	Err error
+}
+
+// label identifies the job in logs and metrics
+func (j importJob) label() string {
+	if j.Message != nil {
+		return fmt.Sprintf("%s#%d", j.FilePath, j.Message.Index+1)
+	}
+	return j.FilePath
+}
+
+// importEmails imports the specified email files. Mbox files are streamed
+// message by message, so their messages are spread across the workers.
 func (i *Importer) importEmails(emailFiles []string) (*Result, error) {
 	result := &Result{
 		Failures: make([]Failure, 0),
@@ -178,21 +341,40 @@ func (i *Importer) importEmails(emailFiles []string) (*Result, error) {
 		i.config.ParallelWorkers = 1
 	}
 
-	jobs := make(chan string, len(emailFiles))
-	results := make(chan importResult, len(emailFiles))
+	// Skip work finished by a previous run
+	state := i.initImportState()
+	sources := make([]importSource, 0, len(emailFiles))
+	for _, filePath := range emailFiles {
+		key := i.stateKey(filePath)
+		if isMboxFile(filePath) {
+			from := state.snapshot(key)
+			result.TotalSkipped += from.Messages - len(from.Failed)
+			sources = append(sources, importSource{Path: filePath, From: from})
+			continue
+		}
+		if state.Files[key] {
+			result.TotalSkipped++
+			continue
+		}
+		sources = append(sources, importSource{Path: filePath})
+	}
+	if result.TotalSkipped > 0 {
+		logrus.WithField("skipped", result.TotalSkipped).Info("Skipping messages already imported")
+	}
+
+	// Bounded queues keep only a few mbox messages in memory at a time
+	jobs := make(chan importJob, i.config.ParallelWorkers*2)
+	results := make(chan importResult, i.config.ParallelWorkers*2)
 
 	// Start workers
 	var wg sync.WaitGroup
 	for w := 0; w < i.config.ParallelWorkers; w++ {
 		wg.Add(1)
-		go i.importWorker(jobs, results, &wg)
+		go i.importWorker(w, jobs, results, &wg)
 	}
 
 	// Send jobs
-	for _, filePath := range emailFiles {
-		jobs <- filePath
-	}
-	close(jobs)
+	go i.produceJobs(sources, jobs)
 
 	// Wait for workers to complete
 	go func() {
@@ -201,54 +383,237 @@ func (i *Importer) importEmails(emailFiles []string) (*Result, error) {
 	}()
 
 	// Collect results with progress indicator
-	processed := 0
-	total := len(emailFiles)
+	finished := 0
 	for importRes := range results {
-		processed++
-
+		result.TotalFound++
 		if importRes.Error != nil {
+			category := string(failure.Categorize(importRes.Error))
 			result.TotalFailed++
-			result.Failures = append(result.Failures, Failure{
+			if result.FailedByCategory == nil {
+				result.FailedByCategory = make(map[string]int)
+			}
+			result.FailedByCategory[category]++
+
+			fail := Failure{
 				FilePath:  importRes.FilePath,
+				Category:  category,
 				Error:     importRes.Error.Error(),
 				Timestamp: time.Now(),
-			})
-			logrus.WithError(importRes.Error).WithField("file_path", importRes.FilePath).Error("Failed to import email")
+			}
+			if importRes.Message != nil {
+				fail.Message = importRes.Message.Index + 1
+			}
+			result.Failures = append(result.Failures, fail)
+			logrus.WithError(importRes.Error).WithFields(logrus.Fields{
+				"file_path": importRes.FilePath,
+				"message":   fail.Message,
+			}).Error("Failed to import email")
+		} else if importRes.Duplicate {
+			result.TotalDuplicates++
+		} else if importRes.Filtered {
+			result.TotalFiltered++
 		} else {
 			result.TotalImported++
 			result.TotalSize += importRes.Size
 		}
 
+		i.recordProgress(state, importRes)
+		if finished++; finished%stateCheckpointEvery == 0 {
+			i.saveImportState(state)
+		}
+
 		// Show progress
-		fmt.Printf("\rProgress: %d of %d messages imported (%.1f%%)",
-			result.TotalImported, total, float64(processed)/float64(total)*100)
+		progress := i.metrics.Progress()
+		console.Default().SetStatus(0, fmt.Sprintf("Progress: %d of %d messages imported (%.1f%%)",
+			progress.Exported, progress.Matched, progress.Percent()))
 	}
-	fmt.Println() // New line after progress
+	console.Default().Finish()
+
+	i.saveImportState(state)
+	result.Cancelled = i.cancelled.Load()
 
 	return result, nil
 }
 
+// Cancel stops the import: no more messages are queued, workers finish the
+// ones already queued, and Import returns the result so far with Cancelled
+// set. A later run with Resume continues where it stopped.
+func (i *Importer) Cancel() {
+	if !i.cancelled.Swap(true) {
+		logrus.Info("Import cancelled; workers stop after the queued messages")
+	}
+}
+
+// produceJobs queues every file and mbox message to import, stopping at the
+// configured limit or when cancelled, and closes jobs when done
+func (i *Importer) produceJobs(sources []importSource, jobs chan<- importJob) {
+	defer close(jobs)
+
+	queued := 0
+	send := func(job importJob) bool {
+		if i.cancelled.Load() || (i.config.Limit > 0 && queued >= i.config.Limit) {
+			return false
+		}
+		i.metrics.AddMatched(1)
+		jobs <- job
+		queued++
+		return true
+	}
+
+	for _, source := range sources {
+		var more bool
+		if isMboxFile(source.Path) {
+			more = i.readMbox(source, send)
+		} else {
+			more = send(importJob{FilePath: source.Path})
+		}
+
+		if !more {
+			if !i.cancelled.Load() {
+				logrus.WithField("limit", i.config.Limit).Info("Limited number of messages to process")
+			}
+			return
+		}
+	}
+}
+
+// readMbox streams the messages of an mbox file to send, retrying messages
+// that failed in a previous run first. It returns false once send refuses
+// more work.
+func (i *Importer) readMbox(source importSource, send func(importJob) bool) bool {
+	file, err := os.Open(source.Path)
+	if err != nil {
+		return send(importJob{FilePath: source.Path, Err: fmt.Errorf("failed to open mbox file: %w", err)})
+	}
+	defer file.Close()
+
+	for _, span := range source.From.Failed {
+		reader := newMboxReader(io.NewSectionReader(file, span.Start, span.End-span.Start), span.Start, span.Index)
+		message, err := reader.Next()
+		job := importJob{FilePath: source.Path, Message: message, Retry: true}
+		if err != nil {
+			job.Message = &mboxMessage{Index: span.Index, Start: span.Start, End: span.End}
+			job.Err = fmt.Errorf("failed to read message %d: %w", span.Index+1, err)
+		}
+		if !send(job) {
+			return false
+		}
+	}
+
+	if _, err := file.Seek(source.From.Offset, io.SeekStart); err != nil {
+		return send(importJob{FilePath: source.Path, Err: fmt.Errorf("failed to seek mbox file: %w", err)})
+	}
+
+	reader := newMboxReader(file, source.From.Offset, source.From.Messages)
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return send(importJob{FilePath: source.Path, Err: fmt.Errorf("failed to read mbox file: %w", err)})
+		}
+		if !send(importJob{FilePath: source.Path, Message: message}) {
+			return false
+		}
+	}
+}
+
+// recordProgress updates the resume state with a finished job
+func (i *Importer) recordProgress(state *importState, res importResult) {
+	key := i.stateKey(res.FilePath)
+	switch {
+	case res.Message != nil:
+		state.mbox(key).finish(res.Message, res.Retry, res.Error)
+	case res.Error == nil:
+		state.Files[key] = true
+	}
+}
+
 // importResult represents the result of importing a single email
 type importResult struct {
 	FilePath string
+	Message  *mboxMessage
+	Retry    bool
 	Size     int64
 	Error    error
+	// Duplicate is set for a message skipped as already in the destination
+	Duplicate bool
+	// Filtered is set for a message skipped by OnlyLabels
+	Filtered bool
 }
 
 // importWorker is a worker function for importing emails in parallel
-func (i *Importer) importWorker(jobs <-chan string, results chan<- importResult, wg *sync.WaitGroup) {
+func (i *Importer) importWorker(workerID int, jobs <-chan importJob, results chan<- importResult, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for filePath := range jobs {
-		size, err := i.importSingleEmail(filePath)
+	for job := range jobs {
+		console.Default().SetStatus(workerID+1, fmt.Sprintf("  worker %d: %s", workerID+1, job.label()))
+		start := time.Now()
+		size, err := i.runJob(job)
+		console.Default().SetStatus(workerID+1, "")
+		duplicate := errors.Is(err, errAlreadyExists)
+		filtered := errors.Is(err, errLabelFiltered)
+		if duplicate || filtered {
+			err = nil
+		}
+		i.recordImportResult(workerID, job.label(), size, time.Since(start), duplicate, filtered, err)
 		results <- importResult{
-			FilePath: filePath,
-			Size:     size,
-			Error:    err,
+			FilePath:  job.FilePath,
+			Message:   job.Message,
+			Retry:     job.Retry,
+			Size:      size,
+			Error:     err,
+			Duplicate: duplicate,
+			Filtered:  filtered,
 		}
 	}
 }
 
+// runJob imports a single file or mbox message
+func (i *Importer) runJob(job importJob) (int64, error) {
+	if job.Err != nil {
+		return 0, job.Err
+	}
+	if job.Message == nil {
+		return i.importSingleEmail(job.FilePath)
+	}
+
+	// Release the message body once it has been uploaded
+	raw := job.Message.Raw
+	job.Message.Raw = nil
+
+	source := Repair{FilePath: job.FilePath, Message: job.Message.Index + 1}
+	if err := i.importMessage(raw, i.fileLabels(job.FilePath, raw), source); err != nil {
+		return 0, fmt.Errorf("failed to import message %d: %w", job.Message.Index+1, err)
+	}
+
+	return int64(len(raw)), nil
+}
+
+// recordImportResult records the outcome of a single import in the metrics
+// collector as soon as the worker finishes it
+func (i *Importer) recordImportResult(workerID int, filePath string, size int64, duration time.Duration, duplicate, filtered bool, err error) {
+	i.metrics.RecordWorkerResult(workerID, size, duration, err)
+
+	if err != nil {
+		i.metrics.AddFailed(1)
+		i.metrics.RecordFailure(filePath, string(failure.Categorize(err)), err.Error())
+		return
+	}
+	if duplicate {
+		i.metrics.AddSkipped(SkipReasonDuplicate)
+		return
+	}
+	if filtered {
+		i.metrics.AddSkipped(SkipReasonLabelFiltered)
+		return
+	}
+
+	i.metrics.AddExported(1)
+	i.metrics.AddBytes(size)
+}
+
 // importSingleEmail imports a single email file
 func (i *Importer) importSingleEmail(filePath string) (int64, error) {
 	// Read the email file
@@ -261,76 +626,212 @@ func (i *Importer) importSingleEmail(filePath string) (int64, error) {
 	ext := strings.ToLower(filepath.Ext(filePath))
 	switch ext {
 	case ".eml":
-		return i.importEMLFile(data)
+		return i.importEMLFile(filePath, data)
+	case ".emlx":
+		return i.importEMLXFile(filePath, data)
+	case ".msg":
+		return i.importMSGFile(filePath, data)
 	case ".json":
-		return i.importJSONFile(data)
-	case ".mbox":
-		return i.importMboxFile(data)
+		return i.importJSONFile(filePath, data)
 	default:
 		return 0, fmt.Errorf("unsupported file type: %s", ext)
 	}
 }
 
+// isMboxFile reports whether path is an mbox file, which is streamed rather
+// than imported whole
+func isMboxFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".mbox"
+}
+
 // importEMLFile imports an EML format email
-func (i *Importer) importEMLFile(data []byte) (int64, error) {
-	// Create a Gmail message from the EML data
-	message := &gmail.Message{
-		Raw: encodeBase64URL(data),
+func (i *Importer) importEMLFile(filePath string, data []byte) (int64, error) {
+	// Import the message (does not send, just adds to mailbox)
+	labels := append(i.fileLabels(filePath, data), i.messageState(filePath)...)
+	if err := i.importMessage(data, labels, Repair{FilePath: filePath}); err != nil {
+		return 0, fmt.Errorf("failed to import message: %w", err)
 	}
 
-	// Import the message (does not send, just adds to mailbox)
-	_, err := i.gmailService.Users.Messages.Import("me", message).Do()
+	return int64(len(data)), nil
+}
+
+// importMSGFile imports an Outlook .msg message, converted to RFC 822
+func (i *Importer) importMSGFile(filePath string, data []byte) (int64, error) {
+	message, err := outlook.Convert(data)
 	if err != nil {
+		return 0, fmt.Errorf("failed to convert msg: %w", err)
+	}
+
+	labels := i.fileLabels(filePath, message.Raw)
+	if !message.Read {
+		labels = append(labels, "UNREAD")
+	}
+	if message.Flagged {
+		labels = append(labels, "STARRED")
+	}
+	if err := i.importMessage(message.Raw, labels, Repair{FilePath: filePath}); err != nil {
 		return 0, fmt.Errorf("failed to import message: %w", err)
 	}
 
-	return int64(len(data)), nil
+	return int64(len(message.Raw)), nil
 }
 
 // importJSONFile imports a JSON format email
-func (i *Importer) importJSONFile(data []byte) (int64, error) {
+func (i *Importer) importJSONFile(filePath string, data []byte) (int64, error) {
 	// Parse the JSON to extract the raw email data
 	var emailData struct {
-		Raw string `json:"raw"`
+		Raw      string   `json:"raw"`
+		LabelIds []string `json:"labelIds"`
 	}
 
 	if err := json.Unmarshal(data, &emailData); err != nil {
 		return 0, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
-	// Create a Gmail message
-	message := &gmail.Message{
-		Raw: emailData.Raw,
+	raw, err := decodeBase64URL(emailData.Raw)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode raw message: %w", err)
 	}
 
 	// Import the message (does not send, just adds to mailbox)
-	_, err := i.gmailService.Users.Messages.Import("me", message).Do()
-	if err != nil {
+	labels := append(emailData.LabelIds, i.messageState(filePath)...)
+	if err := i.importMessage(raw, labels, Repair{FilePath: filePath}); err != nil {
 		return 0, fmt.Errorf("failed to import message: %w", err)
 	}
 
 	return int64(len(data)), nil
 }
 
-// importMboxFile imports an mbox format email
-func (i *Importer) importMboxFile(data []byte) (int64, error) {
-	// For mbox files, we need to parse the format and extract individual messages
-	// This is a simplified implementation - in practice, you'd want a proper mbox parser
-	message := &gmail.Message{
-		Raw: encodeBase64URL(data),
+// importMessage uploads a single raw message to the configured backend and
+// records the API call latency. Source identifies the message in the repair
+// report.
+func (i *Importer) importMessage(raw []byte, labels []string, source Repair) error {
+	raw = i.repairMIME(raw, source)
+	raw = i.config.Headers.apply(raw)
+	raw = i.addresses.apply(raw)
+
+	if !selectedByLabels(labels, i.config.OnlyLabels) {
+		return errLabelFiltered
+	}
+	if err := i.checkDuplicate(raw); err != nil {
+		return err
 	}
 
-	// Import the message (does not send, just adds to mailbox)
-	_, err := i.gmailService.Users.Messages.Import("me", message).Do()
+	var id string
+	var err error
+	if i.graph == nil {
+		id, err = i.importGmailMessage(raw, prefixLabels(labels, i.config.LabelPrefix))
+	} else {
+		start := time.Now()
+		id, err = i.graph.importMessage(raw, labels)
+		i.metrics.RecordAPICall("graph.messages.import", time.Since(start), err)
+	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to import message: %w", err)
+		return err
 	}
 
-	return int64(len(data)), nil
+	i.recordAudit(id, source)
+	return nil
+}
+
+// recordAudit records an imported message in the audit log
+func (i *Importer) recordAudit(id string, source Repair) {
+	entry := audit.Entry{
+		Action:     audit.ActionImport,
+		MessageIDs: []string{id},
+		Source:     source.FilePath,
+	}
+	if source.Message > 0 {
+		entry.Source = fmt.Sprintf("%s#%d", source.FilePath, source.Message)
+	}
+	if i.graph != nil {
+		entry.Account = i.config.Graph.Mailbox
+	}
+	if err := i.audit.Record(entry); err != nil {
+		logrus.WithError(err).WithField("message_id", id).Error("Failed to record import in audit log")
+	}
+}
+
+// openAudit opens the audit log imports are recorded in. Gmail imports are
+// recorded as made by the account the token is for, and Graph imports by
+// the application.
+func (i *Importer) openAudit() error {
+	if i.config.AuditLog == "" {
+		return nil
+	}
+
+	operator := "graph app " + i.config.Graph.ClientID
+	if i.graph == nil {
+		operator = audit.Operator(i.gmailService)
+	}
+	auditLog, err := audit.Open(i.config.AuditLog, operator, i.config.AuditSigningKey)
+	if err != nil {
+		return err
+	}
+	i.audit = auditLog
+	return nil
+}
+
+// backendName returns the configured import backend
+func (i *Importer) backendName() string {
+	if i.config.Backend == "" {
+		return BackendGmail
+	}
+	return i.config.Backend
+}
+
+// fileLabels returns the Gmail labels of an EML or mbox export, taken from an
+// X-Gmail-Labels header or, failing that, the label directory the file was
+// exported into with --organize-by-labels. Nested directories, written for
+// nested labels with --label-dir-names, name the nested label.
+func (i *Importer) fileLabels(filePath string, data []byte) []string {
+	if labels := headerLabels(data); len(labels) > 0 {
+		return labels
+	}
+
+	rel, err := filepath.Rel(i.config.InputDir, filepath.Dir(filePath))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+
+	return []string{filepath.ToSlash(rel)}
+}
+
+// headerLabels parses the X-Gmail-Labels header of a raw message, as written
+// by Google Takeout
+func headerLabels(data []byte) []string {
+	// Skip the mbox "From " separator line if present
+	if bytes.HasPrefix(data, []byte("From ")) {
+		if newline := bytes.IndexByte(data, '\n'); newline >= 0 {
+			data = data[newline+1:]
+		}
+	}
+
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	header := message.Header.Get("X-Gmail-Labels")
+	if header == "" {
+		return nil
+	}
+
+	var labels []string
+	for _, label := range strings.Split(header, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
 }
 
 // validateConfig validates the importer configuration
 func validateConfig(config *Config) error {
+	if err := auth.ValidateMode(config.AuthMode); err != nil {
+		return err
+	}
+
 	if config.InputDir == "" {
 		return fmt.Errorf("input directory is required")
 	}
@@ -347,9 +848,64 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("limit must be >= 0")
 	}
 
+	if err := config.Headers.validate(); err != nil {
+		return err
+	}
+
+	if config.LabelPrefix != "" && config.Backend == BackendGraph {
+		return fmt.Errorf("a label prefix requires the gmail backend")
+	}
+
+	switch config.Backend {
+	case "", BackendGmail:
+	case BackendGraph:
+		if err := validateGraphConfig(&config.Graph); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid backend: %s (valid: gmail, graph)", config.Backend)
+	}
+
 	return nil
 }
 
+// validateGraphConfig validates the Microsoft Graph backend settings
+func validateGraphConfig(config *GraphConfig) error {
+	if config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
+		return fmt.Errorf("graph backend requires a tenant ID, client ID and client secret")
+	}
+
+	if config.Mailbox == "" {
+		return fmt.Errorf("graph backend requires a destination mailbox")
+	}
+
+	if config.DefaultFolder == "" {
+		config.DefaultFolder = "inbox"
+	}
+
+	// Config file keys arrive lower-cased, so match labels case-insensitively
+	labelMap := make(map[string]string, len(config.LabelMap))
+	for label, name := range config.LabelMap {
+		labelMap[strings.ToLower(label)] = name
+	}
+	config.LabelMap = labelMap
+
+	switch config.LabelMode {
+	case "":
+		config.LabelMode = LabelModeCategories
+	case LabelModeCategories, LabelModeFolders:
+	default:
+		return fmt.Errorf("invalid label mode: %s (valid: categories, folders)", config.LabelMode)
+	}
+
+	return nil
+}
+
+// decodeBase64URL decodes base64url data with or without padding
+func decodeBase64URL(data string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+}
+
 // encodeBase64URL encodes data in base64url format for Gmail API
 func encodeBase64URL(data []byte) string {
 	encoded := base64.URLEncoding.EncodeToString(data)
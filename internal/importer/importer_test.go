@@ -1,9 +1,15 @@
 package importer
 
 import (
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/audit"
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+	"github.com/octasoft-ltd/gmail-exporter/internal/mockgmail"
 )
 
 func TestNew(t *testing.T) {
@@ -69,6 +75,42 @@ func TestValidateConfig(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "invalid backend",
+			config: &Config{
+				InputDir: ".",
+				Backend:  "imap",
+			},
+			expectError: true,
+		},
+		{
+			name: "graph backend without mailbox",
+			config: &Config{
+				InputDir: ".",
+				Backend:  BackendGraph,
+				Graph:    GraphConfig{TenantID: "t", ClientID: "c", ClientSecret: "s"},
+			},
+			expectError: true,
+		},
+		{
+			name: "valid graph backend",
+			config: &Config{
+				InputDir: ".",
+				Backend:  BackendGraph,
+				Graph:    GraphConfig{TenantID: "t", ClientID: "c", ClientSecret: "s", Mailbox: "user@example.com"},
+			},
+			expectError: false,
+		},
+		{
+			name: "label prefix with graph backend",
+			config: &Config{
+				InputDir:    ".",
+				Backend:     BackendGraph,
+				Graph:       GraphConfig{TenantID: "t", ClientID: "c", ClientSecret: "s", Mailbox: "user@example.com"},
+				LabelPrefix: "Offboarded/jane",
+			},
+			expectError: true,
+		},
 		{
 			name: "non-existent input dir",
 			config: &Config{
@@ -107,6 +149,8 @@ func TestFindEmailFiles(t *testing.T) {
 		"email3.mbox",
 		"not_email.txt",
 		"document.pdf",
+		"processed_emails.json",
+		".export_state.json",
 	}
 
 	for _, filename := range testFiles {
@@ -190,3 +234,71 @@ func TestEncodeBase64URL(t *testing.T) {
 		})
 	}
 }
+
+func TestProduceJobs_Cancelled(t *testing.T) {
+	importer := &Importer{
+		config:  &Config{},
+		metrics: metrics.NewCollector("import"),
+	}
+	importer.Cancel()
+
+	jobs := make(chan importJob, 2)
+	importer.produceJobs([]importSource{{Path: "a.eml"}, {Path: "b.eml"}}, jobs)
+	if queued := len(jobs); queued != 0 {
+		t.Errorf("Expected no jobs queued after Cancel, got %d", queued)
+	}
+}
+
+func TestImport_AuditLog(t *testing.T) {
+	mailbox := mockgmail.New("")
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+	if err := auth.SetAPIEndpoint(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auth.SetAPIEndpoint("") })
+	t.Setenv(auth.TokenEnvVar, mockgmail.TokenJSON)
+
+	dir := t.TempDir()
+	inputDir := filepath.Join(dir, "export")
+	if err := os.MkdirAll(inputDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	emlPath := filepath.Join(inputDir, "invoice.eml")
+	if err := os.WriteFile(emlPath, []byte("From: billing@example.com\r\nSubject: Invoice\r\n\r\nDue\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auditLog := filepath.Join(dir, "audit.jsonl")
+
+	imp, err := New(&Config{
+		CredentialsFile: filepath.Join(dir, "credentials.json"),
+		TokenFile:       filepath.Join(dir, "token.json"),
+		InputDir:        inputDir,
+		ParallelWorkers: 1,
+		AuditLog:        auditLog,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := imp.Import()
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if result.TotalImported != 1 {
+		t.Fatalf("Import() imported %d, want 1", result.TotalImported)
+	}
+
+	entries, err := audit.Load(auditLog)
+	if err != nil {
+		t.Fatalf("audit.Load() error = %v", err)
+	}
+	messages := mailbox.Messages()
+	if len(entries) != 1 || len(messages) != 1 {
+		t.Fatalf("Expected 1 audit entry and 1 message, got %+v and %d", entries, len(messages))
+	}
+	entry := entries[0]
+	if entry.Operator != mockgmail.DefaultAccount || entry.Action != audit.ActionImport ||
+		len(entry.MessageIDs) != 1 || entry.MessageIDs[0] != messages[0].ID || entry.Source != emlPath {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+}
@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+)
+
+// gmailSystemLabels maps normalized label names, as found in Takeout
+// X-Gmail-Labels headers or Gmail label IDs, to the system label IDs that can
+// be applied on import
+var gmailSystemLabels = map[string]string{
+	"inbox":               "INBOX",
+	"sent":                "SENT",
+	"important":           "IMPORTANT",
+	"starred":             "STARRED",
+	"unread":              "UNREAD",
+	"spam":                "SPAM",
+	"trash":               "TRASH",
+	"category_personal":   "CATEGORY_PERSONAL",
+	"category_social":     "CATEGORY_SOCIAL",
+	"category_promotions": "CATEGORY_PROMOTIONS",
+	"category_updates":    "CATEGORY_UPDATES",
+	"category_forums":     "CATEGORY_FORUMS",
+}
+
+// gmailSkippedLabels are labels that cannot or should not be applied on import
+var gmailSkippedLabels = map[string]bool{
+	"opened":    true,
+	"archived":  true,
+	"chat":      true,
+	"draft":     true,
+	"drafts":    true,
+	"unlabeled": true,
+}
+
+// keptUnderPrefix are the system labels kept when messages are filed under
+// a label prefix; the others, such as INBOX and SENT, describe the original
+// mailbox
+var keptUnderPrefix = map[string]bool{
+	"UNREAD":    true,
+	"STARRED":   true,
+	"IMPORTANT": true,
+}
+
+// SkipReasonLabelFiltered is the metrics skip reason of messages without
+// any of the labels selected by OnlyLabels
+const SkipReasonLabelFiltered = "label_filtered"
+
+// errLabelFiltered marks a message skipped because it has none of the
+// labels selected by OnlyLabels
+var errLabelFiltered = errors.New("message has none of the selected labels")
+
+// opaqueLabelID matches user label IDs, which are meaningless in another account
+var opaqueLabelID = regexp.MustCompile(`^Label_\d+$`)
+
+// labelResolver maps label names to label IDs in the destination Gmail
+// account, creating user labels, and the parents of nested ones, that do
+// not exist yet
+type labelResolver struct {
+	cache *labels.Cache
+}
+
+// newLabelResolver creates a resolver for the authenticated account
+func newLabelResolver(service *gmail.Service) *labelResolver {
+	return &labelResolver{cache: labels.ForService(service, 0)}
+}
+
+// resolve returns the label IDs to apply for the given label names
+func (l *labelResolver) resolve(names []string) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := strings.ReplaceAll(strings.ToLower(name), " ", "_")
+		if name == "" || gmailSkippedLabels[key] {
+			continue
+		}
+		if opaqueLabelID.MatchString(name) {
+			logrus.WithField("label", name).Debug("Skipping label ID from another account")
+			continue
+		}
+
+		id, ok := gmailSystemLabels[key]
+		if !ok {
+			var err error
+			if id, err = l.cache.Ensure(name); err != nil {
+				return nil, err
+			}
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// importGmailMessage imports a raw message into the Gmail account with the
+// given labels restored and returns its ID
+func (i *Importer) importGmailMessage(raw []byte, labels []string) (string, error) {
+	labelIDs, err := i.labels.resolve(labels)
+	if err != nil {
+		return "", err
+	}
+
+	message := &gmail.Message{
+		Raw:      encodeBase64URL(raw),
+		LabelIds: labelIDs,
+	}
+
+	var imported *gmail.Message
+	err = i.retry.Do(func() error {
+		start := time.Now()
+		var callErr error
+		imported, callErr = i.gmailService.Users.Messages.Import("me", message).Do()
+		i.metrics.RecordAPICall("messages.import", time.Since(start), callErr)
+		return callErr
+	}, func(err error, attempt int, wait time.Duration) {
+		i.metrics.RecordRetry("messages.import")
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"backoff": wait,
+		}).Debug("Retrying Gmail import")
+	})
+	if err != nil {
+		return "", err
+	}
+	return imported.Id, nil
+}
+
+// selectedByLabels reports whether a message with labels has one of only,
+// matched case-insensitively; every message is selected when only is empty
+func selectedByLabels(labels, only []string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, label := range labels {
+		for _, selected := range only {
+			if strings.EqualFold(strings.TrimSpace(label), strings.TrimSpace(selected)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prefixLabels nests the user labels of a message under prefix and adds
+// prefix itself, keeping only the read, starred and important state of the
+// system labels. Labels are unchanged without a prefix.
+func prefixLabels(labels []string, prefix string) []string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return labels
+	}
+
+	prefixed := []string{prefix}
+	for _, name := range labels {
+		name = strings.TrimSpace(name)
+		key := strings.ReplaceAll(strings.ToLower(name), " ", "_")
+		if name == "" || gmailSkippedLabels[key] || opaqueLabelID.MatchString(name) {
+			continue
+		}
+		if id, ok := gmailSystemLabels[key]; ok {
+			if keptUnderPrefix[id] {
+				prefixed = append(prefixed, id)
+			}
+			continue
+		}
+		prefixed = append(prefixed, prefix+"/"+name)
+	}
+	return prefixed
+}
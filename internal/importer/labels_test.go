@@ -0,0 +1,57 @@
+package importer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelResolver_SystemLabels(t *testing.T) {
+	// System labels resolve without any API calls
+	resolver := newLabelResolver(nil)
+
+	got, err := resolver.resolve([]string{"Inbox", "Opened", "Category Promotions", "Label_5", "Drafts", "UNREAD", "inbox"})
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+
+	want := []string{"INBOX", "CATEGORY_PROMOTIONS", "UNREAD"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolve() = %v, want %v", got, want)
+	}
+}
+
+func TestPrefixLabels(t *testing.T) {
+	labels := []string{"INBOX", "Unread", "Projects/Apollo", "Label_5", "Sent", "Opened", "Starred", "Invoices"}
+
+	got := prefixLabels(labels, "Offboarded/jane/")
+	want := []string{"Offboarded/jane", "UNREAD", "Offboarded/jane/Projects/Apollo", "STARRED", "Offboarded/jane/Invoices"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prefixLabels() = %v, want %v", got, want)
+	}
+
+	if got := prefixLabels(labels, ""); !reflect.DeepEqual(got, labels) {
+		t.Errorf("prefixLabels() without a prefix = %v, want the labels unchanged", got)
+	}
+}
+
+func TestSelectedByLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		only   []string
+		want   bool
+	}{
+		{"no selection", []string{"INBOX"}, nil, true},
+		{"selected label", []string{"INBOX", "Clients"}, []string{"clients", "Projects"}, true},
+		{"other labels", []string{"INBOX", "Personal"}, []string{"Clients"}, false},
+		{"no labels", nil, []string{"Clients"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectedByLabels(tt.labels, tt.only); got != tt.want {
+				t.Errorf("selectedByLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxMboxLine bounds a single mbox line so a corrupt file cannot exhaust memory
+const maxMboxLine = 64 << 20
+
+// mboxMessage is a single message read from an mbox file
+type mboxMessage struct {
+	// Index is the zero-based position of the message in the file
+	Index int
+	// Start and End are the byte offsets of the message, including its
+	// "From " separator line
+	Start int64
+	End   int64
+	Raw   []byte
+}
+
+// mboxReader streams messages out of an mbox file one at a time, so
+// multi-gigabyte Takeout archives never have to fit in memory
+type mboxReader struct {
+	reader *bufio.Reader
+	offset int64
+	index  int
+
+	// pending is a "From " line already read for the next message
+	pending []byte
+	// separated is set once a "From " separator has been seen
+	separated bool
+}
+
+// newMboxReader reads messages from r, which is positioned at byte offset of
+// the file and at the start of the message numbered index
+func newMboxReader(r io.Reader, offset int64, index int) *mboxReader {
+	// Resuming part way through implies the file uses separators
+	return &mboxReader{
+		reader:    bufio.NewReaderSize(r, 1<<20),
+		offset:    offset,
+		index:     index,
+		separated: offset > 0,
+	}
+}
+
+// Next returns the next message, or io.EOF when the file is exhausted
+func (m *mboxReader) Next() (*mboxMessage, error) {
+	start := m.offset - int64(len(m.pending))
+	var raw bytes.Buffer
+	inMessage := m.pending != nil
+	m.pending = nil
+
+	for {
+		line, err := m.readLine()
+		if len(line) > 0 {
+			m.offset += int64(len(line))
+
+			switch {
+			case isMboxSeparator(line) && inMessage:
+				m.pending = line
+				return m.message(start, raw.Bytes()), nil
+			case isMboxSeparator(line):
+				inMessage = true
+				m.separated = true
+				start = m.offset - int64(len(line))
+			case m.separated:
+				raw.Write(unescapeFromLine(line))
+			default:
+				// A file without separators, such as a single exported
+				// message, is one message
+				if !inMessage {
+					inMessage = true
+					start = m.offset - int64(len(line))
+				}
+				raw.Write(line)
+			}
+		}
+
+		if err == io.EOF {
+			if !inMessage || raw.Len() == 0 {
+				return nil, io.EOF
+			}
+			return m.message(start, raw.Bytes()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// message builds the message ending at the current offset
+func (m *mboxReader) message(start int64, raw []byte) *mboxMessage {
+	message := &mboxMessage{
+		Index: m.index,
+		Start: start,
+		End:   m.offset - int64(len(m.pending)),
+		Raw:   append([]byte(nil), raw...),
+	}
+	m.index++
+	return message
+}
+
+// readLine reads a full line including its terminator
+func (m *mboxReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := m.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxMboxLine {
+			return nil, fmt.Errorf("mbox line exceeds %d bytes at offset %d", maxMboxLine, m.offset)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		return line, err
+	}
+}
+
+// isMboxSeparator reports whether line starts a new message
+func isMboxSeparator(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From "))
+}
+
+// unescapeFromLine reverses mboxrd quoting, where body lines matching
+// ">*From " gain an extra ">" when written
+func unescapeFromLine(line []byte) []byte {
+	quoted := bytes.TrimLeft(line, ">")
+	if len(quoted) < len(line) && bytes.HasPrefix(quoted, []byte("From ")) {
+		return line[1:]
+	}
+	return line
+}
@@ -0,0 +1,203 @@
+package importer
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+const testMbox = "From 1@xxx Mon Jan 01 00:00:00 +0000 2024\n" +
+	"X-Gmail-Labels: Inbox,Work\n" +
+	"Subject: one\n" +
+	"\n" +
+	">From the desk of\n" +
+	"\n" +
+	"From 2@xxx Tue Jan 02 00:00:00 +0000 2024\r\n" +
+	"Subject: two\r\n" +
+	"\r\n" +
+	"body\r\n"
+
+func readAll(t *testing.T, reader *mboxReader) []*mboxMessage {
+	t.Helper()
+
+	var messages []*mboxMessage
+	for {
+		message, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return messages
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+func TestMboxReader(t *testing.T) {
+	messages := readAll(t, newMboxReader(strings.NewReader(testMbox), 0, 0))
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+
+	first, second := messages[0], messages[1]
+	if want := "X-Gmail-Labels: Inbox,Work\nSubject: one\n\nFrom the desk of\n\n"; string(first.Raw) != want {
+		t.Errorf("first message = %q, want %q", first.Raw, want)
+	}
+	if second.Index != 1 || !strings.HasPrefix(string(second.Raw), "Subject: two") {
+		t.Errorf("unexpected second message: index %d, raw %q", second.Index, second.Raw)
+	}
+
+	// Offsets cover each message including its separator line
+	if first.Start != 0 || first.End != second.Start || second.End != int64(len(testMbox)) {
+		t.Errorf("offsets = [%d,%d) [%d,%d)", first.Start, first.End, second.Start, second.End)
+	}
+	if !strings.HasPrefix(testMbox[second.Start:], "From 2@xxx") {
+		t.Errorf("second message starts at %q", testMbox[second.Start:second.Start+10])
+	}
+}
+
+func TestMboxReader_Resume(t *testing.T) {
+	offset := int64(strings.Index(testMbox, "From 2@xxx"))
+	messages := readAll(t, newMboxReader(strings.NewReader(testMbox[offset:]), offset, 1))
+
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if messages[0].Index != 1 || messages[0].Start != offset || messages[0].End != int64(len(testMbox)) {
+		t.Errorf("unexpected message: %+v", messages[0])
+	}
+}
+
+func TestMboxReader_WithoutSeparator(t *testing.T) {
+	eml := "Subject: exported\n\n>From here\n"
+	messages := readAll(t, newMboxReader(strings.NewReader(eml), 0, 0))
+
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	// Without separators the body is not mboxrd-quoted, so it is left alone
+	if string(messages[0].Raw) != eml {
+		t.Errorf("message = %q, want %q", messages[0].Raw, eml)
+	}
+}
+
+func TestMboxProgress_Finish(t *testing.T) {
+	progress := &mboxProgress{}
+	messages := []*mboxMessage{
+		{Index: 0, Start: 0, End: 10},
+		{Index: 1, Start: 10, End: 25},
+		{Index: 2, Start: 25, End: 40},
+	}
+
+	// Messages finishing out of order do not move the offset past a gap
+	progress.finish(messages[2], false, nil)
+	if progress.Offset != 0 || progress.Messages != 0 {
+		t.Fatalf("offset moved past unfinished message: %+v", progress)
+	}
+
+	progress.finish(messages[0], false, nil)
+	progress.finish(messages[1], false, errors.New("upload failed"))
+	if progress.Offset != 40 || progress.Messages != 3 {
+		t.Errorf("progress = %d messages at %d, want 3 at 40", progress.Messages, progress.Offset)
+	}
+	if len(progress.Failed) != 1 || progress.Failed[0] != (mboxSpan{Index: 1, Start: 10, End: 25}) {
+		t.Errorf("failed = %+v", progress.Failed)
+	}
+
+	// A successful retry clears the failure
+	progress.finish(messages[1], true, nil)
+	if len(progress.Failed) != 0 {
+		t.Errorf("failed = %+v, want none", progress.Failed)
+	}
+}
+
+func TestImport_MboxResume(t *testing.T) {
+	dir := t.TempDir()
+	mboxPath := filepath.Join(dir, "All mail Including Spam and Trash.mbox")
+	content := "From 1@xxx Mon Jan 01 00:00:00 +0000 2024\nSubject: one\n\nok\n" +
+		"From 2@xxx Mon Jan 01 00:00:00 +0000 2024\nSubject: two\n\nFAIL\n" +
+		"From 3@xxx Mon Jan 01 00:00:00 +0000 2024\nSubject: three\n\nok\n"
+	if err := os.WriteFile(mboxPath, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var uploaded []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Uploaded messages are then updated with their flags
+		if r.Method == http.MethodPatch {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		raw, _ := base64.StdEncoding.DecodeString(string(body))
+
+		mu.Lock()
+		defer mu.Unlock()
+		if failing && strings.Contains(string(raw), "FAIL") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		uploaded = append(uploaded, strings.SplitN(string(raw), "\n", 2)[0])
+		_, _ = w.Write([]byte(`{"id":"msg"}`))
+	}))
+	defer server.Close()
+
+	run := func(resume bool) *Result {
+		config := &Config{
+			InputDir:        mboxPath,
+			ParallelWorkers: 2,
+			Resume:          resume,
+			StateFile:       filepath.Join(dir, "state.json"),
+			Backend:         BackendGraph,
+			Graph:           GraphConfig{Mailbox: "user@example.com", DefaultFolder: "inbox", BaseURL: server.URL},
+		}
+		imp := &Importer{
+			config:  config,
+			graph:   newGraphClientWithHTTP(&config.Graph, server.Client()),
+			metrics: metrics.NewCollector("import"),
+		}
+		result, err := imp.importEmails([]string{mboxPath})
+		if err != nil {
+			t.Fatalf("importEmails() error = %v", err)
+		}
+		return result
+	}
+
+	first := run(false)
+	if first.TotalImported != 2 || first.TotalFailed != 1 || first.Failures[0].Message != 2 {
+		t.Fatalf("first run: imported %d, failed %d (%+v)", first.TotalImported, first.TotalFailed, first.Failures)
+	}
+
+	// The resumed run only retries the failed message
+	mu.Lock()
+	failing = false
+	uploaded = nil
+	mu.Unlock()
+	second := run(true)
+	if second.TotalImported != 1 || second.TotalSkipped != 2 {
+		t.Errorf("second run: imported %d, skipped %d", second.TotalImported, second.TotalSkipped)
+	}
+	if len(uploaded) != 1 || uploaded[0] != "Subject: two" {
+		t.Errorf("uploaded = %v, want [Subject: two]", uploaded)
+	}
+
+	// Nothing is left to do after that
+	mu.Lock()
+	uploaded = nil
+	mu.Unlock()
+	third := run(true)
+	if third.TotalImported != 0 || third.TotalSkipped != 3 || len(uploaded) != 0 {
+		t.Errorf("third run: imported %d, skipped %d, uploaded %v", third.TotalImported, third.TotalSkipped, uploaded)
+	}
+}
@@ -0,0 +1,54 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+// exportedState is the subset of a processed_emails.json entry recording
+// where a message was written and its read, starred and importance state
+type exportedState struct {
+	File   string   `json:"file"`
+	Copies []string `json:"copies,omitempty"`
+	State  []string `json:"state,omitempty"`
+}
+
+// loadMessageState reads the UNREAD, STARRED and IMPORTANT labels recorded
+// by the exporter for each export file in dir, keyed by the cleaned file
+// path. It returns nil when dir is not an export directory.
+func loadMessageState(dir string) (map[string][]string, error) {
+	var processed []exportedState
+	err := atomicfile.ReadFile(filepath.Join(dir, exporter.ProcessedEmailsFile), func(data []byte) error {
+		processed = nil
+		return json.Unmarshal(data, &processed)
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", exporter.ProcessedEmailsFile, err)
+	}
+
+	state := make(map[string][]string)
+	for _, email := range processed {
+		if len(email.State) == 0 {
+			continue
+		}
+		for _, file := range append([]string{email.File}, email.Copies...) {
+			if file != "" {
+				state[filepath.Join(dir, filepath.FromSlash(file))] = email.State
+			}
+		}
+	}
+	return state, nil
+}
+
+// messageState returns the state labels recorded for an export file
+func (i *Importer) messageState(filePath string) []string {
+	return i.state[filepath.Clean(filePath)]
+}
@@ -0,0 +1,43 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+func TestLoadMessageState(t *testing.T) {
+	dir := t.TempDir()
+
+	state, err := loadMessageState(dir)
+	if err != nil || state != nil {
+		t.Fatalf("Expected no state without a processed emails file, got %v, %v", state, err)
+	}
+
+	processed := `[
+		{"id": "m1", "file": "INBOX/m1.eml", "copies": ["Work/m1.eml"], "state": ["UNREAD", "STARRED"]},
+		{"id": "m2", "file": "m2.eml"}
+	]`
+	if err := os.WriteFile(filepath.Join(dir, exporter.ProcessedEmailsFile), []byte(processed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = loadMessageState(dir)
+	if err != nil {
+		t.Fatalf("loadMessageState() error = %v", err)
+	}
+
+	i := &Importer{state: state}
+	want := []string{"UNREAD", "STARRED"}
+	for _, file := range []string{"INBOX/m1.eml", "Work/m1.eml"} {
+		if got := i.messageState(dir + "/./" + file); !reflect.DeepEqual(got, want) {
+			t.Errorf("messageState(%s) = %v, want %v", file, got, want)
+		}
+	}
+	if got := i.messageState(filepath.Join(dir, "m2.eml")); got != nil {
+		t.Errorf("Expected no state for m2.eml, got %v", got)
+	}
+}
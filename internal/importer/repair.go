@@ -0,0 +1,413 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// Repairs applied to messages with broken MIME structure
+const (
+	RepairLineEndings      = "line_endings"
+	RepairHeaderSeparator  = "header_separator"
+	RepairMessageID        = "missing_message_id"
+	RepairDate             = "missing_date"
+	RepairMIMEVersion      = "missing_mime_version"
+	RepairBoundary         = "missing_boundary"
+	RepairClosingBoundary  = "missing_closing_boundary"
+	RepairHeaderEncoding   = "header_encoding"
+	RepairTransferEncoding = "transfer_encoding"
+	RepairBase64           = "invalid_base64"
+)
+
+// repairsFileName is the report of the repairs applied by an import, written
+// next to the input directory
+const repairsFileName = "import_repairs.json"
+
+// repairedMessageIDDomain is the domain of the Message-IDs added to messages
+// without one
+const repairedMessageIDDomain = "repaired.gmail-exporter.invalid"
+
+// Repair records the repairs applied to a single message
+type Repair struct {
+	FilePath string   `json:"file_path"`
+	Message  int      `json:"message,omitempty"` // 1-based position within an mbox file
+	Repairs  []string `json:"repairs"`
+}
+
+// unstructuredHeaders are the free-text header fields whose raw 8-bit values
+// are re-encoded as RFC 2047 encoded words
+var unstructuredHeaders = map[string]bool{
+	"subject":      true,
+	"comments":     true,
+	"keywords":     true,
+	"thread-topic": true,
+}
+
+// transferEncodingAliases maps misspelled Content-Transfer-Encoding values to
+// the standard ones
+var transferEncodingAliases = map[string]string{
+	"7bit":             "7bit",
+	"7-bit":            "7bit",
+	"8bit":             "8bit",
+	"8-bit":            "8bit",
+	"binary":           "binary",
+	"base64":           "base64",
+	"base-64":          "base64",
+	"quoted-printable": "quoted-printable",
+	"quoted_printable": "quoted-printable",
+	"quotedprintable":  "quoted-printable",
+}
+
+// boundaryLine matches a multipart delimiter line in a body
+var boundaryLine = regexp.MustCompile(`(?m)^--([0-9A-Za-z'()+_,./:=?-]{1,70})\r?$`)
+
+// repairMessage fixes the MIME problems that make Gmail reject a message and
+// returns the repaired message with the list of repairs applied. A message
+// needing no repair is returned unchanged.
+func repairMessage(raw []byte) ([]byte, []string) {
+	var repairs []string
+	repaired := func(repair string) {
+		repairs = append(repairs, repair)
+	}
+
+	if normalized, changed := normalizeLineEndings(raw); changed {
+		raw = normalized
+		repaired(RepairLineEndings)
+	}
+	if separated, changed := ensureHeaderSeparator(raw); changed {
+		raw = separated
+		repaired(RepairHeaderSeparator)
+	}
+
+	header, body, _ := splitHeader(raw)
+	fields := headerFields(header)
+	present := make(map[string]bool)
+	for _, field := range fields {
+		present[fieldName(field)] = true
+	}
+
+	var added []string
+	if !present["message-id"] {
+		sum := sha256.Sum256(raw)
+		added = append(added, fmt.Sprintf("Message-ID: <%s@%s>", hex.EncodeToString(sum[:16]), repairedMessageIDDomain))
+		repaired(RepairMessageID)
+	}
+	if !present["date"] {
+		if date, ok := receivedDate(fields); ok {
+			added = append(added, "Date: "+date)
+			repaired(RepairDate)
+		}
+	}
+	if !present["mime-version"] && (present["content-type"] || present["content-transfer-encoding"]) {
+		added = append(added, "MIME-Version: 1.0")
+		repaired(RepairMIMEVersion)
+	}
+
+	mediaType, encoding := "", ""
+	for i, field := range fields {
+		name := fieldName(field)
+		value := fieldValue(field)
+
+		switch {
+		case unstructuredHeaders[name] && hasEightBit(value):
+			fields[i] = rebuildField(field, mime.QEncoding.Encode("utf-8", toUTF8(value)))
+			repaired(RepairHeaderEncoding)
+
+		case name == "content-transfer-encoding":
+			encoding = strings.ToLower(value)
+			standard, ok := transferEncodingAliases[encoding]
+			if !ok {
+				standard = "8bit"
+			}
+			if standard != encoding {
+				fields[i] = rebuildField(field, standard)
+				repaired(RepairTransferEncoding)
+			}
+			encoding = standard
+
+		case name == "content-type":
+			var params map[string]string
+			var err error
+			mediaType, params, err = mime.ParseMediaType(value)
+			if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+				continue
+			}
+
+			boundary := params["boundary"]
+			if boundary == "" || !bytes.Contains(body, []byte("--"+boundary)) {
+				// Recover the boundary the body actually uses, or treat an
+				// unsplittable body as plain text
+				if match := boundaryLine.FindSubmatch(body); match != nil {
+					params["boundary"] = string(match[1])
+					fields[i] = rebuildField(field, mime.FormatMediaType(mediaType, params))
+				} else {
+					mediaType = "text/plain"
+					fields[i] = rebuildField(field, "text/plain; charset=utf-8")
+				}
+				repaired(RepairBoundary)
+				boundary = params["boundary"]
+			}
+
+			if strings.HasPrefix(mediaType, "multipart/") && !bytes.Contains(body, []byte("--"+boundary+"--")) {
+				if !bytes.HasSuffix(body, []byte("\r\n")) {
+					body = append(body, "\r\n"...)
+				}
+				body = append(body, "--"+boundary+"--\r\n"...)
+				repaired(RepairClosingBoundary)
+			}
+		}
+	}
+
+	// Re-encode a single-part base64 body that does not decode
+	if encoding == "base64" && !strings.HasPrefix(mediaType, "multipart/") {
+		if reencoded, changed := repairBase64(body); changed {
+			body = reencoded
+			repaired(RepairBase64)
+		}
+	}
+
+	if len(repairs) == 0 {
+		return raw, nil
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(raw) + 128)
+	for _, field := range fields {
+		out.Write(field)
+	}
+	for _, field := range added {
+		out.WriteString(field)
+		out.WriteString("\r\n")
+	}
+	out.Write(body)
+	return out.Bytes(), repairs
+}
+
+// normalizeLineEndings converts bare LF and CR line endings to CRLF
+func normalizeLineEndings(raw []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	changed := false
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case raw[i] == '\r' && i+1 < len(raw) && raw[i+1] == '\n':
+			out.WriteString("\r\n")
+			i++
+		case raw[i] == '\r' || raw[i] == '\n':
+			out.WriteString("\r\n")
+			changed = true
+		default:
+			out.WriteByte(raw[i])
+		}
+	}
+
+	if !changed {
+		return raw, false
+	}
+	return out.Bytes(), true
+}
+
+// ensureHeaderSeparator inserts the blank line ending the header block
+// before the first line that is not a header field, or at the end of a
+// message with no body
+func ensureHeaderSeparator(raw []byte) ([]byte, bool) {
+	offset := 0
+	for offset < len(raw) {
+		end := bytes.Index(raw[offset:], []byte("\r\n"))
+		if end < 0 {
+			end = len(raw) - offset
+		}
+		line := raw[offset : offset+end]
+
+		if len(line) == 0 {
+			return raw, false
+		}
+		continued := (line[0] == ' ' || line[0] == '\t') && offset > 0
+		colon := bytes.IndexByte(line, ':')
+		if !continued && (colon <= 0 || !validHeaderName(string(line[:colon]))) {
+			break
+		}
+
+		offset += end + 2
+	}
+
+	if offset > len(raw) {
+		offset = len(raw)
+	}
+
+	separated := make([]byte, 0, len(raw)+4)
+	separated = append(separated, raw[:offset]...)
+	if offset > 0 && !bytes.HasSuffix(separated, []byte("\r\n")) {
+		separated = append(separated, "\r\n"...)
+	}
+	separated = append(separated, "\r\n"...)
+	separated = append(separated, raw[offset:]...)
+	return separated, true
+}
+
+// fieldName returns the lower-cased name of a header field
+func fieldName(field []byte) string {
+	colon := bytes.IndexByte(field, ':')
+	if colon <= 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(string(field[:colon])))
+}
+
+// fieldValue returns the unfolded value of a header field
+func fieldValue(field []byte) string {
+	colon := bytes.IndexByte(field, ':')
+	if colon < 0 {
+		return ""
+	}
+	value := strings.NewReplacer("\r\n", "", "\n", "").Replace(string(field[colon+1:]))
+	return strings.TrimSpace(value)
+}
+
+// rebuildField returns field with its value replaced
+func rebuildField(field []byte, value string) []byte {
+	colon := bytes.IndexByte(field, ':')
+	return []byte(string(field[:colon]) + ": " + value + "\r\n")
+}
+
+// receivedDate returns the date of the most recent Received field, the
+// closest stand-in for a missing Date header
+func receivedDate(fields [][]byte) (string, bool) {
+	for _, field := range fields {
+		if fieldName(field) != "received" {
+			continue
+		}
+		value := fieldValue(field)
+		semicolon := strings.LastIndexByte(value, ';')
+		if semicolon < 0 {
+			continue
+		}
+		date, err := mail.ParseDate(strings.TrimSpace(value[semicolon+1:]))
+		if err != nil {
+			continue
+		}
+		return date.Format("Mon, 02 Jan 2006 15:04:05 -0700"), true
+	}
+	return "", false
+}
+
+// hasEightBit reports whether value holds raw non-ASCII bytes
+func hasEightBit(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// toUTF8 returns value as UTF-8, reading it as Latin-1 when it is not
+// valid UTF-8
+func toUTF8(value string) string {
+	if utf8.ValidString(value) {
+		return value
+	}
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+	return string(runes)
+}
+
+// repairBase64 re-encodes a base64 body that does not decode, dropping
+// stray characters and broken padding. The body starts with the blank line
+// ending the header block.
+func repairBase64(body []byte) ([]byte, bool) {
+	var clean []byte
+	for _, c := range body {
+		if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+			clean = append(clean, c)
+		}
+	}
+	if _, err := base64.StdEncoding.DecodeString(string(clean)); err == nil {
+		return body, false
+	}
+
+	var alphabet []byte
+	for _, c := range clean {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '+' || c == '/' {
+			alphabet = append(alphabet, c)
+		}
+	}
+	// A single trailing character cannot encode a byte
+	if len(alphabet)%4 == 1 {
+		alphabet = alphabet[:len(alphabet)-1]
+	}
+	decoded, err := base64.RawStdEncoding.DecodeString(string(alphabet))
+	if err != nil {
+		return body, false
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(decoded)
+	out := []byte("\r\n")
+	for len(encoded) > 76 {
+		out = append(out, encoded[:76]+"\r\n"...)
+		encoded = encoded[76:]
+	}
+	out = append(out, encoded+"\r\n"...)
+	return out, true
+}
+
+// repairMIME repairs a message when MIME repair is enabled, recording the
+// repairs applied for the repair report
+func (i *Importer) repairMIME(raw []byte, source Repair) []byte {
+	if !i.config.RepairMIME {
+		return raw
+	}
+
+	repaired, repairs := repairMessage(raw)
+	if len(repairs) == 0 {
+		return raw
+	}
+
+	source.Repairs = repairs
+	i.repairsMu.Lock()
+	i.repairs = append(i.repairs, source)
+	i.repairsMu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"file_path": source.FilePath,
+		"message":   source.Message,
+		"repairs":   strings.Join(repairs, ","),
+	}).Debug("Repaired message MIME structure")
+
+	return repaired
+}
+
+// saveRepairs writes the report of the repairs applied during the import
+// next to the input directory
+func (i *Importer) saveRepairs() error {
+	data, err := json.MarshalIndent(i.repairs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal repairs report: %w", err)
+	}
+
+	path := filepath.Join(filepath.Dir(i.config.InputDir), repairsFileName)
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write repairs report: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"repaired": len(i.repairs),
+		"report":   path,
+	}).Info("Saved report of repaired messages")
+	return nil
+}
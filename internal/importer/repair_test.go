@@ -0,0 +1,193 @@
+package importer
+
+import (
+	"encoding/json"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hasRepair reports whether repairs includes repair
+func hasRepair(repairs []string, repair string) bool {
+	for _, r := range repairs {
+		if r == repair {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRepairMessage_ValidUnchanged(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Message-ID: <1@example.com>\r\n" +
+		"Date: Mon, 4 Mar 2024 10:00:00 +0000\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	got, repairs := repairMessage([]byte(raw))
+	if len(repairs) != 0 {
+		t.Errorf("Expected no repairs, got %v", repairs)
+	}
+	if string(got) != raw {
+		t.Errorf("Expected message to be unchanged, got %q", got)
+	}
+}
+
+func TestRepairMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		repair string
+		check  func(t *testing.T, repaired string)
+	}{
+		{
+			name:   "bare LF line endings",
+			raw:    "Message-ID: <1@x>\nSubject: Hi\n\nBody\n",
+			repair: RepairLineEndings,
+			check: func(t *testing.T, repaired string) {
+				if strings.Contains(strings.ReplaceAll(repaired, "\r\n", ""), "\n") {
+					t.Errorf("Expected CRLF line endings only, got %q", repaired)
+				}
+			},
+		},
+		{
+			name:   "missing header separator",
+			raw:    "Message-ID: <1@x>\r\nSubject: Hi\r\nThis is the body\r\n",
+			repair: RepairHeaderSeparator,
+			check: func(t *testing.T, repaired string) {
+				if !strings.Contains(repaired, "Subject: Hi\r\n\r\nThis is the body") {
+					t.Errorf("Expected blank line before the body, got %q", repaired)
+				}
+			},
+		},
+		{
+			name:   "missing message id and date",
+			raw:    "Received: from a by b; Mon, 4 Mar 2024 10:00:00 +0000\r\nSubject: Hi\r\n\r\nBody\r\n",
+			repair: RepairMessageID,
+			check: func(t *testing.T, repaired string) {
+				if !strings.Contains(repaired, "@"+repairedMessageIDDomain+">") {
+					t.Errorf("Expected a Message-ID, got %q", repaired)
+				}
+				if !strings.Contains(repaired, "Date: Mon, 04 Mar 2024 10:00:00 +0000\r\n") {
+					t.Errorf("Expected a Date from the Received header, got %q", repaired)
+				}
+			},
+		},
+		{
+			name:   "missing mime version",
+			raw:    "Message-ID: <1@x>\r\nContent-Type: text/plain\r\n\r\nBody\r\n",
+			repair: RepairMIMEVersion,
+		},
+		{
+			name: "missing boundary parameter",
+			raw: "Message-ID: <1@x>\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed\r\n\r\n" +
+				"--abc123\r\nContent-Type: text/plain\r\n\r\nHi\r\n--abc123--\r\n",
+			repair: RepairBoundary,
+			check: func(t *testing.T, repaired string) {
+				if !strings.Contains(repaired, "Content-Type: multipart/mixed; boundary=abc123\r\n") {
+					t.Errorf("Expected recovered boundary, got %q", repaired)
+				}
+			},
+		},
+		{
+			name: "unclosed multipart",
+			raw: "Message-ID: <1@x>\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nHi",
+			repair: RepairClosingBoundary,
+			check: func(t *testing.T, repaired string) {
+				if !strings.HasSuffix(repaired, "Hi\r\n--b--\r\n") {
+					t.Errorf("Expected closing boundary, got %q", repaired)
+				}
+			},
+		},
+		{
+			name:   "raw 8-bit subject",
+			raw:    "Message-ID: <1@x>\r\nSubject: Caf\xe9\r\n\r\nBody\r\n",
+			repair: RepairHeaderEncoding,
+			check: func(t *testing.T, repaired string) {
+				message, err := mail.ReadMessage(strings.NewReader(repaired))
+				if err != nil {
+					t.Fatal(err)
+				}
+				subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+				if err != nil || subject != "Café" {
+					t.Errorf("Expected encoded subject Café, got %q (%v)", subject, err)
+				}
+			},
+		},
+		{
+			name:   "misspelled transfer encoding",
+			raw:    "Message-ID: <1@x>\r\nMIME-Version: 1.0\r\nContent-Transfer-Encoding: Quoted_Printable\r\n\r\nBody\r\n",
+			repair: RepairTransferEncoding,
+			check: func(t *testing.T, repaired string) {
+				if !strings.Contains(repaired, "Content-Transfer-Encoding: quoted-printable\r\n") {
+					t.Errorf("Expected standard encoding, got %q", repaired)
+				}
+			},
+		},
+		{
+			name:   "invalid base64 body",
+			raw:    "Message-ID: <1@x>\r\nMIME-Version: 1.0\r\nContent-Transfer-Encoding: base64\r\n\r\naGVs*bG8gd29y\r\nbGQ\r\n",
+			repair: RepairBase64,
+			check: func(t *testing.T, repaired string) {
+				if !strings.HasSuffix(repaired, "\r\n\r\naGVsbG8gd29ybGQ=\r\n") {
+					t.Errorf("Expected re-encoded body, got %q", repaired)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repaired, repairs := repairMessage([]byte(tt.raw))
+			if !hasRepair(repairs, tt.repair) {
+				t.Fatalf("Expected repair %s, got %v", tt.repair, repairs)
+			}
+			if _, err := mail.ReadMessage(strings.NewReader(string(repaired))); err != nil {
+				t.Errorf("Repaired message does not parse: %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, string(repaired))
+			}
+		})
+	}
+}
+
+func TestRepairMIME_RecordsReport(t *testing.T) {
+	dir := t.TempDir()
+	i := &Importer{config: &Config{InputDir: filepath.Join(dir, "exports"), RepairMIME: true}}
+
+	i.repairMIME([]byte("Subject: Hi\n\nBody\n"), Repair{FilePath: "a.eml"})
+	i.repairMIME([]byte("Message-ID: <1@x>\r\n\r\nBody\r\n"), Repair{FilePath: "b.eml"})
+
+	if len(i.repairs) != 1 || i.repairs[0].FilePath != "a.eml" {
+		t.Fatalf("Expected one repaired message, got %+v", i.repairs)
+	}
+
+	if err := i.saveRepairs(); err != nil {
+		t.Fatalf("saveRepairs() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, repairsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded []Repair
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || !hasRepair(loaded[0].Repairs, RepairLineEndings) {
+		t.Errorf("Unexpected repairs report: %+v", loaded)
+	}
+}
+
+func TestRepairMIME_Disabled(t *testing.T) {
+	i := &Importer{config: &Config{}}
+	raw := []byte("Subject: Hi\n\nBody\n")
+	if got := i.repairMIME(raw, Repair{FilePath: "a.eml"}); string(got) != string(raw) {
+		t.Errorf("Expected message unchanged without --repair-mime, got %q", got)
+	}
+}
@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// stateCheckpointEvery is how many finished messages trigger a state save
+const stateCheckpointEvery = 100
+
+// importState records import progress so an interrupted import can resume
+type importState struct {
+	InputDir string    `json:"input_dir"`
+	Updated  time.Time `json:"updated"`
+
+	// Files lists EML and JSON files that were imported successfully
+	Files map[string]bool `json:"files,omitempty"`
+	// Mbox records how far each mbox file has been imported
+	Mbox map[string]*mboxProgress `json:"mbox,omitempty"`
+}
+
+// mboxProgress is the resume point of a single mbox file
+type mboxProgress struct {
+	// Offset is the byte offset of the first message not yet finished, and
+	// Messages the number of messages before it
+	Offset   int64 `json:"offset"`
+	Messages int   `json:"messages"`
+
+	// Failed lists messages before Offset that failed and are retried on resume
+	Failed []mboxSpan `json:"failed,omitempty"`
+
+	// done holds end offsets of messages finished out of order
+	done map[int]int64
+}
+
+// mboxSpan locates a single message within an mbox file
+type mboxSpan struct {
+	Index int   `json:"index"`
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// newImportState creates empty progress for an input directory
+func newImportState(inputDir string) *importState {
+	return &importState{
+		InputDir: inputDir,
+		Files:    make(map[string]bool),
+		Mbox:     make(map[string]*mboxProgress),
+	}
+}
+
+// mbox returns the progress of an mbox file, creating it if needed
+func (s *importState) mbox(key string) *mboxProgress {
+	progress, ok := s.Mbox[key]
+	if !ok {
+		progress = &mboxProgress{}
+		s.Mbox[key] = progress
+	}
+	return progress
+}
+
+// snapshot returns a copy of an mbox file's progress for the reader, which
+// runs alongside the updates made as messages finish
+func (s *importState) snapshot(key string) mboxProgress {
+	progress, ok := s.Mbox[key]
+	if !ok {
+		return mboxProgress{}
+	}
+	return mboxProgress{
+		Offset:   progress.Offset,
+		Messages: progress.Messages,
+		Failed:   slices.Clone(progress.Failed),
+	}
+}
+
+// finish records a finished message. The resume offset only moves past a
+// message once every message before it has finished too.
+func (p *mboxProgress) finish(message *mboxMessage, retry bool, err error) {
+	span := mboxSpan{Index: message.Index, Start: message.Start, End: message.End}
+
+	if retry {
+		if err == nil {
+			p.Failed = slices.DeleteFunc(p.Failed, func(s mboxSpan) bool { return s.Index == span.Index })
+		}
+		return
+	}
+
+	if err != nil {
+		p.Failed = append(p.Failed, span)
+	}
+
+	if p.done == nil {
+		p.done = make(map[int]int64)
+	}
+	p.done[message.Index] = message.End
+
+	for {
+		end, ok := p.done[p.Messages]
+		if !ok {
+			break
+		}
+		delete(p.done, p.Messages)
+		p.Offset = end
+		p.Messages++
+	}
+}
+
+// statePath returns the path of the import state file
+func (i *Importer) statePath() string {
+	if i.config.StateFile != "" {
+		return i.config.StateFile
+	}
+	return filepath.Join(filepath.Dir(i.config.InputDir), "import_state.json")
+}
+
+// stateKey identifies an input file in the state file
+func (i *Importer) stateKey(path string) string {
+	rel, err := filepath.Rel(i.config.InputDir, path)
+	if err != nil || rel == "." {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// initImportState loads the progress of a previous run when resuming, or
+// starts afresh
+func (i *Importer) initImportState() *importState {
+	if !i.config.Resume {
+		return newImportState(i.config.InputDir)
+	}
+
+	state, err := loadImportState(i.statePath())
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to load import state, starting from the beginning")
+		return newImportState(i.config.InputDir)
+	}
+	if state == nil {
+		return newImportState(i.config.InputDir)
+	}
+	if filepath.Clean(state.InputDir) != filepath.Clean(i.config.InputDir) {
+		logrus.WithFields(logrus.Fields{
+			"state_input_dir": state.InputDir,
+			"input_dir":       i.config.InputDir,
+		}).Warn("Import state is for a different input, starting from the beginning")
+		return newImportState(i.config.InputDir)
+	}
+
+	if state.Files == nil {
+		state.Files = make(map[string]bool)
+	}
+	if state.Mbox == nil {
+		state.Mbox = make(map[string]*mboxProgress)
+	}
+
+	logrus.WithField("state_file", i.statePath()).Info("Resuming import")
+	return state
+}
+
+// saveImportState writes the import progress
+func (i *Importer) saveImportState(state *importState) {
+	state.Updated = time.Now()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = atomicfile.WriteFileBackup(i.statePath(), data, 0o600)
+	}
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to save import state")
+	}
+}
+
+// loadImportState reads a state file. A missing file yields nil.
+func loadImportState(path string) (*importState, error) {
+	var state importState
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		state = importState{}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("failed to parse state file: %w", err)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
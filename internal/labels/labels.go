@@ -0,0 +1,279 @@
+// Package labels caches the labels of a Gmail mailbox: it maps label IDs to
+// names and back, handles nested label names and creates missing labels
+package labels
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultTTL is how long fetched labels are used before they are fetched
+// again
+const DefaultTTL = 10 * time.Minute
+
+// Separator separates the levels of nested label names, as in
+// "Projects/Apollo"
+const Separator = "/"
+
+// ListFunc fetches every label of the mailbox. Gmail returns them all in
+// one response, nested labels included.
+type ListFunc func() ([]*gmail.Label, error)
+
+// CreateFunc creates a label
+type CreateFunc func(label *gmail.Label) (*gmail.Label, error)
+
+// Cache holds the labels of a mailbox, fetching them on first use and again
+// once they are older than the TTL. It is safe for concurrent use.
+type Cache struct {
+	list   ListFunc
+	create CreateFunc
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	fetched time.Time
+	byID    map[string]*gmail.Label
+	byName  map[string]*gmail.Label // lower-cased name -> label
+	// names maps IDs to names. It is replaced, never modified, so that maps
+	// handed out by Names stay valid.
+	names map[string]string
+}
+
+// New returns a cache fetching labels with list and creating them with
+// create, which may be nil for read-only use. A ttl of zero keeps the
+// labels for the life of the cache.
+func New(list ListFunc, create CreateFunc, ttl time.Duration) *Cache {
+	return &Cache{list: list, create: create, ttl: ttl, now: time.Now}
+}
+
+// ForService returns a cache calling the Gmail API of service directly
+func ForService(service *gmail.Service, ttl time.Duration) *Cache {
+	return New(func() ([]*gmail.Label, error) {
+		resp, err := service.Users.Labels.List("me").Do()
+		if err != nil {
+			return nil, err
+		}
+		return resp.Labels, nil
+	}, func(label *gmail.Label) (*gmail.Label, error) {
+		return service.Users.Labels.Create("me", label).Do()
+	}, ttl)
+}
+
+// Static returns a cache of fixed labels that never fetches them, for
+// callers that already hold them and for tests
+func Static(labels []*gmail.Label) *Cache {
+	c := New(func() ([]*gmail.Label, error) { return labels, nil }, nil, 0)
+	c.store(labels)
+	return c
+}
+
+// load fetches the labels unless fresh ones are held. The lock must be held.
+func (c *Cache) load() error {
+	if c.byID != nil && (c.ttl == 0 || c.now().Sub(c.fetched) < c.ttl) {
+		return nil
+	}
+
+	labels, err := c.list()
+	if err != nil {
+		return fmt.Errorf("failed to list labels: %w", err)
+	}
+	c.store(labels)
+	return nil
+}
+
+// store replaces the held labels. The lock must be held.
+func (c *Cache) store(labels []*gmail.Label) {
+	c.byID = make(map[string]*gmail.Label, len(labels))
+	c.byName = make(map[string]*gmail.Label, len(labels))
+	c.names = make(map[string]string, len(labels))
+	for _, label := range labels {
+		c.byID[label.Id] = label
+		c.byName[strings.ToLower(label.Name)] = label
+		c.names[label.Id] = label.Name
+	}
+	c.fetched = c.now()
+}
+
+// add holds a created label. The lock must be held.
+func (c *Cache) add(label *gmail.Label) {
+	c.byID[label.Id] = label
+	c.byName[strings.ToLower(label.Name)] = label
+
+	names := make(map[string]string, len(c.names)+1)
+	for id, name := range c.names {
+		names[id] = name
+	}
+	names[label.Id] = label.Name
+	c.names = names
+}
+
+// Invalidate drops the held labels, so the next lookup fetches them again
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byID, c.byName, c.names = nil, nil, nil
+}
+
+// All returns the labels of the mailbox
+func (c *Cache) All() ([]*gmail.Label, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	labels := make([]*gmail.Label, 0, len(c.byID))
+	for _, label := range c.byID {
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// Names returns label names by ID. The map is shared and must not be
+// modified; later changes to the cache leave it as it was.
+func (c *Cache) Names() (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c.names, nil
+}
+
+// Lookup returns the label with the ID or name, matched case-insensitively,
+// or nil when the mailbox has none
+func (c *Cache) Lookup(idOrName string) (*gmail.Label, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c.lookup(idOrName), nil
+}
+
+// lookup finds a held label by ID or name. The lock must be held.
+func (c *Cache) lookup(idOrName string) *gmail.Label {
+	idOrName = strings.TrimSpace(idOrName)
+	if label, ok := c.byID[idOrName]; ok {
+		return label
+	}
+	if label, ok := c.byName[strings.ToLower(idOrName)]; ok {
+		return label
+	}
+	for id, label := range c.byID {
+		if strings.EqualFold(id, idOrName) {
+			return label
+		}
+	}
+	return nil
+}
+
+// Resolve returns the IDs of labels given by ID or name, failing on labels
+// the mailbox does not have
+func (c *Cache) Resolve(idsOrNames []string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(idsOrNames))
+	for _, idOrName := range idsOrNames {
+		label := c.lookup(idOrName)
+		if label == nil {
+			return nil, fmt.Errorf("unknown label: %s", idOrName)
+		}
+		ids = append(ids, label.Id)
+	}
+	return ids, nil
+}
+
+// Ensure returns the ID of the named label, creating it when the mailbox has
+// none. The parents of a nested label are created first, as Gmail's own
+// interface does, so that "Projects/Apollo" appears under "Projects".
+func (c *Cache) Ensure(name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return "", err
+	}
+
+	paths := Ancestors(name)
+	if len(paths) == 0 {
+		return "", fmt.Errorf("empty label name")
+	}
+
+	var id string
+	for _, path := range paths {
+		if label := c.lookup(path); label != nil {
+			id = label.Id
+			continue
+		}
+		if c.create == nil {
+			return "", fmt.Errorf("unknown label: %s", path)
+		}
+
+		label, err := c.create(&gmail.Label{
+			Name:                  path,
+			LabelListVisibility:   "labelShow",
+			MessageListVisibility: "show",
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create label %q: %w", path, err)
+		}
+		c.add(label)
+		id = label.Id
+		logrus.WithFields(logrus.Fields{
+			"label": path,
+			"id":    label.Id,
+		}).Info("Created label")
+	}
+	return id, nil
+}
+
+// Ancestors returns the names of a nested label and its parents, outermost
+// first: "A/B/C" gives "A", "A/B" and "A/B/C". Empty levels are dropped.
+func Ancestors(name string) []string {
+	var paths []string
+	var levels []string
+	for _, level := range strings.Split(name, Separator) {
+		if level = strings.TrimSpace(level); level == "" {
+			continue
+		}
+		levels = append(levels, level)
+		paths = append(paths, strings.Join(levels, Separator))
+	}
+	return paths
+}
+
+// Parent returns the name of a nested label's parent, or "" for a top-level
+// label
+func Parent(name string) string {
+	paths := Ancestors(name)
+	if len(paths) < 2 {
+		return ""
+	}
+	return paths[len(paths)-2]
+}
+
+// searchReplacer turns the characters Gmail does not accept in a label
+// search term into dashes
+var searchReplacer = strings.NewReplacer(
+	" ", "-", "\t", "-", Separator, "-",
+	`"`, "-", "(", "-", ")", "-", "{", "-", "}", "-", ":", "-",
+)
+
+// SearchName returns a label name as written in a Gmail search query, where
+// spaces, nesting separators and query syntax become dashes as in Gmail's
+// own searches: "Clients/Acme Corp" is searched as label:Clients-Acme-Corp
+func SearchName(name string) string {
+	return searchReplacer.Replace(strings.TrimSpace(name))
+}
@@ -0,0 +1,144 @@
+package labels
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// fakeMailbox serves labels to a cache, counting the fetches
+type fakeMailbox struct {
+	labels []*gmail.Label
+	lists  int
+}
+
+func (m *fakeMailbox) list() ([]*gmail.Label, error) {
+	m.lists++
+	return m.labels, nil
+}
+
+func (m *fakeMailbox) create(label *gmail.Label) (*gmail.Label, error) {
+	created := &gmail.Label{Id: fmt.Sprintf("Label_%d", len(m.labels)+1), Name: label.Name}
+	m.labels = append(m.labels, created)
+	return created, nil
+}
+
+func TestCacheTTL(t *testing.T) {
+	mailbox := &fakeMailbox{labels: []*gmail.Label{{Id: "INBOX", Name: "INBOX"}}}
+	cache := New(mailbox.list, nil, time.Minute)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Names(); err != nil {
+			t.Fatalf("Names() error = %v", err)
+		}
+	}
+	if mailbox.lists != 1 {
+		t.Errorf("Expected 1 fetch within the TTL, got %d", mailbox.lists)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.Names(); err != nil {
+		t.Fatalf("Names() error = %v", err)
+	}
+	if mailbox.lists != 2 {
+		t.Errorf("Expected a fetch after the TTL, got %d fetches", mailbox.lists)
+	}
+
+	cache.Invalidate()
+	if _, err := cache.Names(); err != nil {
+		t.Fatalf("Names() error = %v", err)
+	}
+	if mailbox.lists != 3 {
+		t.Errorf("Expected a fetch after Invalidate, got %d fetches", mailbox.lists)
+	}
+}
+
+func TestCacheLookup(t *testing.T) {
+	cache := Static([]*gmail.Label{
+		{Id: "INBOX", Name: "INBOX"},
+		{Id: "Label_7", Name: "Projects/Apollo"},
+	})
+
+	tests := []struct {
+		idOrName string
+		want     string
+	}{
+		{"INBOX", "INBOX"},
+		{"inbox", "INBOX"},
+		{"Label_7", "Label_7"},
+		{"label_7", "Label_7"},
+		{"projects/apollo", "Label_7"},
+		{"Missing", ""},
+	}
+	for _, tt := range tests {
+		label, err := cache.Lookup(tt.idOrName)
+		if err != nil {
+			t.Fatalf("Lookup(%q) error = %v", tt.idOrName, err)
+		}
+		got := ""
+		if label != nil {
+			got = label.Id
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.idOrName, got, tt.want)
+		}
+	}
+
+	if _, err := cache.Resolve([]string{"inbox", "Missing"}); err == nil {
+		t.Error("Expected Resolve() to fail on an unknown label")
+	}
+}
+
+func TestCacheEnsure(t *testing.T) {
+	mailbox := &fakeMailbox{labels: []*gmail.Label{{Id: "Label_1", Name: "Projects"}}}
+	cache := New(mailbox.list, mailbox.create, 0)
+
+	id, err := cache.Ensure("Projects/Apollo/Launch")
+	if err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if id != "Label_3" {
+		t.Errorf("Ensure() = %q, want Label_3", id)
+	}
+
+	var names []string
+	for _, label := range mailbox.labels {
+		names = append(names, label.Name)
+	}
+	want := []string{"Projects", "Projects/Apollo", "Projects/Apollo/Launch"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected labels %v, got %v", want, names)
+	}
+
+	// Existing labels are found without creating them again
+	if id, err := cache.Ensure("projects/apollo"); err != nil || id != "Label_2" {
+		t.Errorf("Ensure(existing) = %q, %v, want Label_2", id, err)
+	}
+	if len(mailbox.labels) != 3 || mailbox.lists != 1 {
+		t.Errorf("Expected no more creates or fetches, got %d labels and %d fetches", len(mailbox.labels), mailbox.lists)
+	}
+
+	if _, err := Static(nil).Ensure("New"); err == nil {
+		t.Error("Expected Ensure() to fail on a cache that cannot create labels")
+	}
+}
+
+func TestNestedNames(t *testing.T) {
+	if got := Ancestors("A / B//C"); !reflect.DeepEqual(got, []string{"A", "A/B", "A/B/C"}) {
+		t.Errorf("Ancestors() = %v", got)
+	}
+	if got := Parent("A/B/C"); got != "A/B" {
+		t.Errorf("Parent() = %q, want A/B", got)
+	}
+	if got := Parent("A"); got != "" {
+		t.Errorf("Parent() = %q, want empty", got)
+	}
+	if got := SearchName("Projects/Apollo Launch"); got != "Projects-Apollo-Launch" {
+		t.Errorf("SearchName() = %q, want Projects-Apollo-Launch", got)
+	}
+}
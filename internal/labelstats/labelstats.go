@@ -0,0 +1,292 @@
+// Package labelstats reports how many messages each Gmail label holds and
+// how much space they take, to help choose labels to export or clean up
+package labelstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+	"github.com/octasoft-ltd/gmail-exporter/internal/preview"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
+)
+
+// Sort orders
+const (
+	SortSize     = "size"
+	SortMessages = "messages"
+	SortName     = "name"
+)
+
+// Stat is the usage of one label
+type Stat struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Messages int64  `json:"messages"`
+	Unread   int64  `json:"unread,omitempty"`
+	// Size is the total size in bytes of the label's messages, zero unless
+	// sizes were collected
+	Size int64 `json:"size,omitempty"`
+}
+
+// Options selects where the statistics come from
+type Options struct {
+	// Cache, when set, supplies message counts and sizes from an export's
+	// metadata cache instead of the mailbox
+	Cache *cache.Store
+	// Sizes totals the sizes of each label's messages in the mailbox by
+	// listing them, which takes an API call per message
+	Sizes bool
+	// Workers is the number of messages whose size is fetched at once
+	Workers int
+}
+
+// Collect returns the statistics of every label of the mailbox
+func Collect(service *gmail.Service, options Options) ([]Stat, error) {
+	engine := retry.New(retry.Defaults())
+
+	var labels *gmail.ListLabelsResponse
+	err := engine.Do(func() error {
+		var callErr error
+		labels, callErr = service.Users.Labels.List("me").Do()
+		return callErr
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+
+	if options.Cache != nil {
+		return FromCache(labels.Labels, options.Cache)
+	}
+
+	stats := make([]Stat, 0, len(labels.Labels))
+	messages := make(map[string][]string)
+	for _, label := range labels.Labels {
+		var full *gmail.Label
+		err := engine.Do(func() error {
+			var callErr error
+			full, callErr = service.Users.Labels.Get("me", label.Id).Do()
+			return callErr
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get label %s: %w", label.Name, err)
+		}
+		stats = append(stats, Stat{
+			ID:       full.Id,
+			Name:     full.Name,
+			Type:     full.Type,
+			Messages: full.MessagesTotal,
+			Unread:   full.MessagesUnread,
+		})
+
+		if options.Sizes && full.MessagesTotal > 0 {
+			ids, err := labelMessages(service, engine, label.Id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list messages of label %s: %w", label.Name, err)
+			}
+			messages[label.Id] = ids
+		}
+	}
+
+	if options.Sizes {
+		sizes, err := messageSizes(service, engine, messages, options.Workers)
+		if err != nil {
+			return nil, err
+		}
+		for i := range stats {
+			for _, id := range messages[stats[i].ID] {
+				stats[i].Size += sizes[id]
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// FromCache returns the statistics of the labels from the messages of a
+// metadata cache. Cached label IDs missing from labels are reported by ID.
+func FromCache(labels []*gmail.Label, store *cache.Store) ([]Stat, error) {
+	byID := make(map[string]*Stat, len(labels))
+	for _, label := range labels {
+		byID[label.Id] = &Stat{ID: label.Id, Name: label.Name, Type: label.Type}
+	}
+
+	err := store.ForEach(func(entry cache.Metadata) error {
+		unread := false
+		for _, id := range entry.Labels {
+			unread = unread || id == "UNREAD"
+		}
+		for _, id := range entry.Labels {
+			stat, ok := byID[id]
+			if !ok {
+				stat = &Stat{ID: id, Name: id}
+				byID[id] = stat
+			}
+			stat.Messages++
+			stat.Size += entry.Size
+			if unread {
+				stat.Unread++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]Stat, 0, len(byID))
+	for _, stat := range byID {
+		stats = append(stats, *stat)
+	}
+	return stats, nil
+}
+
+// labelMessages returns the IDs of the messages with a label, spam and
+// trash included
+func labelMessages(service *gmail.Service, engine *retry.Engine, labelID string) ([]string, error) {
+	var ids []string
+	pageToken := ""
+	for {
+		var resp *gmail.ListMessagesResponse
+		err := engine.Do(func() error {
+			req := service.Users.Messages.List("me").LabelIds(labelID).IncludeSpamTrash(true).
+				MaxResults(500).Fields("messages/id", "nextPageToken")
+			if pageToken != "" {
+				req = req.PageToken(pageToken)
+			}
+			var callErr error
+			resp, callErr = req.Do()
+			return callErr
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range resp.Messages {
+			ids = append(ids, message.Id)
+		}
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// messageSizes fetches the size of every message of the labels once, even
+// when it carries several of them
+func messageSizes(service *gmail.Service, engine *retry.Engine, messages map[string][]string, workers int) (map[string]int64, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sizes := make(map[string]int64)
+	for _, ids := range messages {
+		for _, id := range ids {
+			sizes[id] = 0
+		}
+	}
+
+	jobs := make(chan string, len(sizes))
+	for id := range sizes {
+		jobs <- id
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				var message *gmail.Message
+				err := engine.Do(func() error {
+					var callErr error
+					message, callErr = service.Users.Messages.Get("me", id).Format("minimal").
+						Fields("id", "sizeEstimate").Do()
+					return callErr
+				}, nil)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to get message %s: %w", id, err)
+				} else if err == nil {
+					sizes[id] = message.SizeEstimate
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return sizes, firstErr
+}
+
+// Sort orders the statistics by size or message count, largest first, or
+// by name
+func Sort(stats []Stat, by string) error {
+	var less func(a, b Stat) bool
+	switch by {
+	case SortSize:
+		less = func(a, b Stat) bool { return a.Size > b.Size }
+	case SortMessages:
+		less = func(a, b Stat) bool { return a.Messages > b.Messages }
+	case SortName:
+		less = func(a, b Stat) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	default:
+		return fmt.Errorf("invalid sort order: %s (valid: %s, %s, %s)", by, SortSize, SortMessages, SortName)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Name < b.Name
+	})
+	return nil
+}
+
+// WriteTable writes the statistics as a table, with a size column when
+// sizes were collected
+func WriteTable(w io.Writer, stats []Stat, sizes bool) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if sizes {
+		fmt.Fprintln(table, "LABEL\tTYPE\tMESSAGES\tUNREAD\tSIZE\tAVERAGE")
+	} else {
+		fmt.Fprintln(table, "LABEL\tTYPE\tMESSAGES\tUNREAD")
+	}
+
+	for _, stat := range stats {
+		if !sizes {
+			fmt.Fprintf(table, "%s\t%s\t%d\t%d\n", stat.Name, stat.Type, stat.Messages, stat.Unread)
+			continue
+		}
+		average := int64(0)
+		if stat.Messages > 0 {
+			average = stat.Size / stat.Messages
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%s\t%s\n", stat.Name, stat.Type, stat.Messages, stat.Unread,
+			preview.FormatSize(stat.Size), preview.FormatSize(average))
+	}
+	return table.Flush()
+}
+
+// WriteJSON writes the statistics as indented JSON
+func WriteJSON(w io.Writer, stats []Stat) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
@@ -0,0 +1,113 @@
+package labelstats
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/cache"
+)
+
+func TestFromCache(t *testing.T) {
+	store, err := cache.Open(filepath.Join(t.TempDir(), cache.DefaultFileName))
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	defer store.Close()
+
+	err = store.Put(
+		cache.Metadata{ID: "1", Size: 1000, Labels: []string{"INBOX", "Label_1", "UNREAD"}},
+		cache.Metadata{ID: "2", Size: 3000, Labels: []string{"Label_1"}},
+		cache.Metadata{ID: "3", Size: 500, Labels: []string{"Label_9"}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to put entries: %v", err)
+	}
+
+	labels := []*gmail.Label{
+		{Id: "INBOX", Name: "INBOX", Type: "system"},
+		{Id: "Label_1", Name: "Receipts", Type: "user"},
+		{Id: "Label_2", Name: "Empty", Type: "user"},
+	}
+	stats, err := FromCache(labels, store)
+	if err != nil {
+		t.Fatalf("FromCache() error = %v", err)
+	}
+	if err := Sort(stats, SortSize); err != nil {
+		t.Fatalf("Sort() error = %v", err)
+	}
+
+	want := []Stat{
+		{ID: "Label_1", Name: "Receipts", Type: "user", Messages: 2, Unread: 1, Size: 4000},
+		{ID: "INBOX", Name: "INBOX", Type: "system", Messages: 1, Unread: 1, Size: 1000},
+		{ID: "UNREAD", Name: "UNREAD", Messages: 1, Unread: 1, Size: 1000},
+		{ID: "Label_9", Name: "Label_9", Messages: 1, Size: 500},
+		{ID: "Label_2", Name: "Empty", Type: "user"},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("Expected %d labels, got %+v", len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("stats[%d] = %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	stats := []Stat{
+		{Name: "b", Messages: 5, Size: 10},
+		{Name: "A", Messages: 1, Size: 90},
+		{Name: "c", Messages: 5, Size: 50},
+	}
+
+	tests := []struct {
+		by   string
+		want string
+	}{
+		{SortSize, "A,c,b"},
+		{SortMessages, "b,c,A"},
+		{SortName, "A,b,c"},
+	}
+	for _, tt := range tests {
+		if err := Sort(stats, tt.by); err != nil {
+			t.Fatalf("Sort(%s) error = %v", tt.by, err)
+		}
+		names := make([]string, len(stats))
+		for i, stat := range stats {
+			names[i] = stat.Name
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("Sort(%s) = %s, want %s", tt.by, got, tt.want)
+		}
+	}
+
+	if err := Sort(stats, "color"); err == nil {
+		t.Error("Expected an error for an invalid sort order")
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	stats := []Stat{{Name: "Receipts", Type: "user", Messages: 4, Unread: 1, Size: 4 * 1024 * 1024}}
+
+	var out bytes.Buffer
+	if err := WriteTable(&out, stats, true); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	for _, want := range []string{"LABEL", "AVERAGE", "Receipts", "4.0MB", "1.0MB"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected table to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := WriteTable(&out, stats, false); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	if strings.Contains(out.String(), "SIZE") {
+		t.Errorf("Expected no size column without sizes, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,303 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HistoryFileName is the run history appended to after each run
+const HistoryFileName = "runs.jsonl"
+
+// Regression thresholds comparing a run with the median of the runs before it
+const (
+	// ThroughputDropRatio flags a run exporting at less than this fraction of
+	// the usual rate
+	ThroughputDropRatio = 0.75
+	// FailureRateIncrease flags a run whose failure rate is this many
+	// percentage points above the usual rate
+	FailureRateIncrease = 5.0
+	// LatencyIncreaseRatio flags a run whose average API latency is this many
+	// times the usual latency
+	LatencyIncreaseRatio = 1.5
+)
+
+// Run is the summary of one run recorded in the run history
+type Run struct {
+	Operation       string    `json:"operation"`
+	StartTime       time.Time `json:"start_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Matched         int       `json:"matched"`
+	Exported        int       `json:"exported"`
+	Failed          int       `json:"failed"`
+	Skipped         int       `json:"skipped"`
+	Bytes           int64     `json:"bytes"`
+	EmailsPerSecond float64   `json:"emails_per_second"`
+	BytesPerSecond  float64   `json:"bytes_per_second"`
+	// FailureRate is the percentage of processed messages that failed
+	FailureRate float64 `json:"failure_rate"`
+
+	APICalls   int `json:"api_calls"`
+	APIErrors  int `json:"api_errors"`
+	APIRetries int `json:"api_retries"`
+	// APILatencySeconds is the average latency of all Gmail API calls
+	APILatencySeconds float64 `json:"api_latency_seconds"`
+	// RateLimited counts calls and messages refused by Gmail rate limits
+	RateLimited      int            `json:"rate_limited"`
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+}
+
+// RunSummary summarizes the collected metrics for the run history
+func (c *Collector) RunSummary() Run {
+	data := c.GetData()
+
+	run := Run{
+		Operation:        data.Operation,
+		StartTime:        data.StartTime,
+		DurationSeconds:  data.Duration.Seconds(),
+		Matched:          data.Emails.TotalMatched,
+		Exported:         data.Emails.TotalExported,
+		Failed:           data.Emails.TotalFailed,
+		Skipped:          data.Emails.TotalSkipped,
+		Bytes:            data.Emails.TotalSize,
+		EmailsPerSecond:  data.Performance.EmailsPerSecond,
+		BytesPerSecond:   data.Performance.BytesPerSecond,
+		FailedByCategory: data.Emails.FailedByCategory,
+		RateLimited:      data.Emails.FailedByCategory["rate_limit"],
+	}
+	if processed := run.Exported + run.Failed; processed > 0 {
+		run.FailureRate = float64(run.Failed) / float64(processed) * 100
+	}
+
+	var totalSeconds float64
+	for _, call := range data.APICalls {
+		run.APICalls += call.Calls
+		run.APIErrors += call.Errors
+		run.APIRetries += call.Retries
+		totalSeconds += call.TotalSeconds
+	}
+	if run.APICalls > 0 {
+		run.APILatencySeconds = totalSeconds / float64(run.APICalls)
+	}
+	for _, client := range data.Clients {
+		run.RateLimited += client.RateLimited
+	}
+
+	return run
+}
+
+// AppendHistory appends a run to the run history file, creating it if needed
+func AppendHistory(path string, run Run) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open run history: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to append to run history: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to append to run history: %w", err)
+	}
+
+	logrus.WithField("history", path).Debug("Appended run to history")
+	return nil
+}
+
+// LoadHistory reads the runs of a run history file in the order they were
+// recorded. Unreadable lines, such as one cut short by a crash, are skipped.
+func LoadHistory(path string) ([]Run, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open run history: %w", err)
+	}
+	defer file.Close()
+
+	var runs []Run
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var run Run
+		if err := json.Unmarshal([]byte(text), &run); err != nil {
+			logrus.WithError(err).WithField("line", line).Warn("Skipping unreadable run history entry")
+			continue
+		}
+		runs = append(runs, run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+
+	return runs, nil
+}
+
+// Trend compares the latest run with the median of the runs before it
+type Trend struct {
+	Latest   Run `json:"latest"`
+	Baseline Run `json:"baseline"`
+	// BaselineRuns is the number of earlier runs the baseline is taken from
+	BaselineRuns int `json:"baseline_runs"`
+
+	ThroughputChange float64 `json:"throughput_change_percent"`
+	FailureRateDelta float64 `json:"failure_rate_delta"`
+	LatencyChange    float64 `json:"latency_change_percent"`
+	// Regressions describes the thresholds the latest run crossed
+	Regressions []string `json:"regressions"`
+}
+
+// History is the run history report
+type History struct {
+	Runs  []Run  `json:"runs"`
+	Trend *Trend `json:"trend,omitempty"`
+}
+
+// BuildHistory reports the last runs of operation (all operations when
+// empty), at most last of them (all when 0), with the trend of the latest
+func BuildHistory(runs []Run, operation string, last int) *History {
+	history := &History{Runs: []Run{}}
+	for _, run := range runs {
+		if operation == "" || run.Operation == operation {
+			history.Runs = append(history.Runs, run)
+		}
+	}
+	if last > 0 && len(history.Runs) > last {
+		history.Runs = history.Runs[len(history.Runs)-last:]
+	}
+
+	if len(history.Runs) >= 2 {
+		history.Trend = buildTrend(history.Runs[len(history.Runs)-1], history.Runs[:len(history.Runs)-1])
+	}
+	return history
+}
+
+// buildTrend compares latest with the median of earlier
+func buildTrend(latest Run, earlier []Run) *Trend {
+	baseline := Run{
+		Operation:         latest.Operation,
+		EmailsPerSecond:   median(earlier, func(r Run) float64 { return r.EmailsPerSecond }),
+		BytesPerSecond:    median(earlier, func(r Run) float64 { return r.BytesPerSecond }),
+		FailureRate:       median(earlier, func(r Run) float64 { return r.FailureRate }),
+		APILatencySeconds: median(earlier, func(r Run) float64 { return r.APILatencySeconds }),
+		DurationSeconds:   median(earlier, func(r Run) float64 { return r.DurationSeconds }),
+	}
+
+	trend := &Trend{
+		Latest:           latest,
+		Baseline:         baseline,
+		BaselineRuns:     len(earlier),
+		ThroughputChange: percentChange(latest.EmailsPerSecond, baseline.EmailsPerSecond),
+		FailureRateDelta: latest.FailureRate - baseline.FailureRate,
+		LatencyChange:    percentChange(latest.APILatencySeconds, baseline.APILatencySeconds),
+		Regressions:      []string{},
+	}
+
+	if baseline.EmailsPerSecond > 0 && latest.EmailsPerSecond < baseline.EmailsPerSecond*ThroughputDropRatio {
+		trend.Regressions = append(trend.Regressions, fmt.Sprintf("throughput down %.0f%% (%.2f vs %.2f emails/sec)",
+			-trend.ThroughputChange, latest.EmailsPerSecond, baseline.EmailsPerSecond))
+	}
+	if trend.FailureRateDelta > FailureRateIncrease {
+		trend.Regressions = append(trend.Regressions, fmt.Sprintf("failure rate up %.1f points (%.1f%% vs %.1f%%)",
+			trend.FailureRateDelta, latest.FailureRate, baseline.FailureRate))
+	}
+	if baseline.APILatencySeconds > 0 && latest.APILatencySeconds > baseline.APILatencySeconds*LatencyIncreaseRatio {
+		trend.Regressions = append(trend.Regressions, fmt.Sprintf("API latency up %.0f%% (%.3fs vs %.3fs)",
+			trend.LatencyChange, latest.APILatencySeconds, baseline.APILatencySeconds))
+	}
+
+	return trend
+}
+
+// median returns the median of a field across runs
+func median(runs []Run, field func(Run) float64) float64 {
+	values := make([]float64, len(runs))
+	for i, run := range runs {
+		values[i] = field(run)
+	}
+	sort.Float64s(values)
+
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// percentChange returns the change from base to value in percent, or 0
+// without a base
+func percentChange(value, base float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (value - base) / base * 100
+}
+
+// WriteJSON writes the report as indented JSON
+func (h *History) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h)
+}
+
+// WriteText writes a table of the runs followed by the trend of the latest
+func (h *History) WriteText(w io.Writer) error {
+	if len(h.Runs) == 0 {
+		_, err := fmt.Fprintln(w, "No runs recorded")
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STARTED\tOPERATION\tDURATION\tEXPORTED\tFAILED\tFAIL %\tEMAILS/SEC\tBYTES/SEC\tAPI LATENCY\tRETRIES\tRATE LIMITED")
+	for _, run := range h.Runs {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%d\t%.1f\t%.2f\t%s\t%.3fs\t%d\t%d\n",
+			run.StartTime.Local().Format("2006-01-02 15:04"),
+			run.Operation,
+			time.Duration(run.DurationSeconds*float64(time.Second)).Round(time.Second),
+			run.Exported,
+			run.Failed,
+			run.FailureRate,
+			run.EmailsPerSecond,
+			FormatBytes(int64(run.BytesPerSecond)),
+			run.APILatencySeconds,
+			run.APIRetries,
+			run.RateLimited,
+		)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if h.Trend == nil {
+		return nil
+	}
+
+	trend := h.Trend
+	fmt.Fprintf(w, "\nLatest run compared with the median of the %d before it:\n", trend.BaselineRuns)
+	fmt.Fprintf(w, "  Throughput:   %+.0f%% (%.2f vs %.2f emails/sec)\n", trend.ThroughputChange, trend.Latest.EmailsPerSecond, trend.Baseline.EmailsPerSecond)
+	fmt.Fprintf(w, "  Failure rate: %+.1f points (%.1f%% vs %.1f%%)\n", trend.FailureRateDelta, trend.Latest.FailureRate, trend.Baseline.FailureRate)
+	fmt.Fprintf(w, "  API latency:  %+.0f%% (%.3fs vs %.3fs)\n", trend.LatencyChange, trend.Latest.APILatencySeconds, trend.Baseline.APILatencySeconds)
+
+	if len(trend.Regressions) == 0 {
+		_, err := fmt.Fprintln(w, "No regressions")
+		return err
+	}
+	for _, regression := range trend.Regressions {
+		fmt.Fprintf(w, "REGRESSION: %s\n", regression)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector_RunSummary(t *testing.T) {
+	collector := NewCollector("export")
+	collector.Start()
+	collector.RecordEmailsProcessed(9, 1)
+	collector.RecordFailure("m1", "rate_limit", "quota exceeded")
+	collector.RecordAPICall("messages.get", time.Second, nil)
+	collector.RecordAPICall("messages.get", 3*time.Second, nil)
+	collector.RecordRetry("messages.get")
+	collector.RecordClientCall("default", true, nil)
+
+	run := collector.RunSummary()
+
+	if run.Operation != "export" || run.Exported != 9 || run.Failed != 1 {
+		t.Errorf("Unexpected run counts: %+v", run)
+	}
+	if run.FailureRate != 10 {
+		t.Errorf("Expected failure rate 10, got %v", run.FailureRate)
+	}
+	if run.APICalls != 2 || run.APIRetries != 1 || run.APILatencySeconds != 2 {
+		t.Errorf("Unexpected API stats: calls %d, retries %d, latency %v", run.APICalls, run.APIRetries, run.APILatencySeconds)
+	}
+	if run.RateLimited != 2 {
+		t.Errorf("Expected 2 rate limited, got %d", run.RateLimited)
+	}
+}
+
+func TestHistory_AppendAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HistoryFileName)
+
+	for _, exported := range []int{10, 20} {
+		if err := AppendHistory(path, Run{Operation: "export", Exported: exported}); err != nil {
+			t.Fatalf("AppendHistory failed: %v", err)
+		}
+	}
+
+	// A line cut short by a crash is skipped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	file.WriteString(`{"operation":"exp`)
+	file.Close()
+
+	runs, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory failed: %v", err)
+	}
+	if len(runs) != 2 || runs[0].Exported != 10 || runs[1].Exported != 20 {
+		t.Errorf("Expected the two complete runs in order, got %+v", runs)
+	}
+}
+
+func TestBuildHistory_Trend(t *testing.T) {
+	runs := []Run{
+		{Operation: "export", EmailsPerSecond: 10, FailureRate: 1, APILatencySeconds: 0.2},
+		{Operation: "import", EmailsPerSecond: 1},
+		{Operation: "export", EmailsPerSecond: 12, FailureRate: 0, APILatencySeconds: 0.3},
+		{Operation: "export", EmailsPerSecond: 11, FailureRate: 2, APILatencySeconds: 0.2},
+		{Operation: "export", EmailsPerSecond: 5, FailureRate: 9, APILatencySeconds: 0.5},
+	}
+
+	history := BuildHistory(runs, "export", 0)
+	if len(history.Runs) != 4 {
+		t.Fatalf("Expected 4 export runs, got %d", len(history.Runs))
+	}
+
+	trend := history.Trend
+	if trend == nil {
+		t.Fatal("Expected a trend")
+	}
+	if trend.Baseline.EmailsPerSecond != 11 || trend.Baseline.FailureRate != 1 {
+		t.Errorf("Expected median baseline of 11 emails/sec and 1%% failures, got %+v", trend.Baseline)
+	}
+	if len(trend.Regressions) != 3 {
+		t.Errorf("Expected throughput, failure rate and latency regressions, got %v", trend.Regressions)
+	}
+
+	history = BuildHistory(runs, "export", 2)
+	if len(history.Runs) != 2 || history.Trend.BaselineRuns != 1 {
+		t.Errorf("Expected the last 2 runs with a baseline of 1, got %d runs", len(history.Runs))
+	}
+}
+
+func TestBuildHistory_NoRegression(t *testing.T) {
+	runs := []Run{
+		{Operation: "export", EmailsPerSecond: 10, APILatencySeconds: 0.2},
+		{Operation: "export", EmailsPerSecond: 9, APILatencySeconds: 0.25},
+	}
+
+	history := BuildHistory(runs, "", 0)
+	if len(history.Trend.Regressions) != 0 {
+		t.Errorf("Expected no regressions, got %v", history.Trend.Regressions)
+	}
+
+	var out bytes.Buffer
+	if err := history.WriteText(&out); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "No regressions") {
+		t.Errorf("Expected the report to state no regressions, got:\n%s", out.String())
+	}
+}
@@ -1,13 +1,18 @@
 package metrics
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
 )
 
 // Collector handles metrics collection and export
@@ -15,30 +20,116 @@ type Collector struct {
 	operation string
 	startTime time.Time
 	data      *Data
+	registry  *prometheus.Registry
+
+	// mu guards data and workers, which are recorded concurrently by workers
+	mu      sync.Mutex
+	workers map[int]*WorkerMetrics
 
 	// Prometheus metrics
-	emailsProcessed   prometheus.CounterVec
+	emailsProcessed   *prometheus.CounterVec
+	emailsSkipped     *prometheus.CounterVec
+	failures          *prometheus.CounterVec
+	emailsMatched     prometheus.Gauge
 	bytesProcessed    prometheus.Counter
 	operationDuration prometheus.Histogram
+	apiCallDuration   *prometheus.HistogramVec
+	apiRetries        *prometheus.CounterVec
+	workerEmails      *prometheus.CounterVec
+	workerBytes       *prometheus.CounterVec
+	concurrency       prometheus.Gauge
+	clientCalls       *prometheus.CounterVec
+	quotaUnits        *prometheus.CounterVec
+	connections       *prometheus.GaugeVec
 }
 
+// APILatencyBuckets are the histogram buckets (in seconds) used for Gmail API call latency
+var APILatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
 // Data represents the metrics data structure
 type Data struct {
-	Operation   string        `json:"operation"`
-	StartTime   time.Time     `json:"start_time"`
-	EndTime     *time.Time    `json:"end_time,omitempty"`
-	Duration    time.Duration `json:"duration_seconds"`
-	Emails      EmailMetrics  `json:"emails"`
-	Performance Performance   `json:"performance"`
-	Failures    []Failure     `json:"failures,omitempty"`
+	Operation   string                     `json:"operation"`
+	StartTime   time.Time                  `json:"start_time"`
+	EndTime     *time.Time                 `json:"end_time,omitempty"`
+	Duration    time.Duration              `json:"duration_seconds"`
+	Emails      EmailMetrics               `json:"emails"`
+	Performance Performance                `json:"performance"`
+	APICalls    map[string]*APICallMetrics `json:"api_calls,omitempty"`
+	Workers     []*WorkerMetrics           `json:"workers,omitempty"`
+	Concurrency *ConcurrencyMetrics        `json:"concurrency,omitempty"`
+	Clients     map[string]*ClientMetrics  `json:"clients,omitempty"`
+	Quota       *QuotaMetrics              `json:"quota,omitempty"`
+	Connections *ConnectionMetrics         `json:"connections,omitempty"`
+	Failures    []Failure                  `json:"failures,omitempty"`
+}
+
+// QuotaMetrics records the Gmail API quota units consumed by an operation
+type QuotaMetrics struct {
+	Units    int            `json:"units"`
+	ByMethod map[string]int `json:"by_method"`
+}
+
+// ConnectionMetrics records how many HTTP requests opened a new connection
+// and how many reused an idle or HTTP/2 one
+type ConnectionMetrics struct {
+	New        int64   `json:"new"`
+	Reused     int64   `json:"reused"`
+	ReuseRatio float64 `json:"reuse_ratio"`
+}
+
+// ClientMetrics records the Gmail API calls made with one OAuth client when
+// several are rotated
+type ClientMetrics struct {
+	Calls       int `json:"calls"`
+	RateLimited int `json:"rate_limited"`
+	Errors      int `json:"errors"`
+}
+
+// ConcurrencyMetrics records the worker concurrency chosen by adaptive mode
+type ConcurrencyMetrics struct {
+	Current     int `json:"current"`
+	Min         int `json:"min"`
+	Max         int `json:"max"`
+	Adjustments int `json:"adjustments"`
+}
+
+// APICallMetrics represents latency and retry statistics for a single Gmail API method
+type APICallMetrics struct {
+	Calls          int           `json:"calls"`
+	Errors         int           `json:"errors"`
+	Retries        int           `json:"retries"`
+	TotalSeconds   float64       `json:"total_seconds"`
+	AverageSeconds float64       `json:"average_seconds"`
+	MinSeconds     float64       `json:"min_seconds"`
+	MaxSeconds     float64       `json:"max_seconds"`
+	Buckets        []BucketCount `json:"buckets"`
+}
+
+// BucketCount is a cumulative histogram bucket: the number of observations <= UpperBound
+type BucketCount struct {
+	UpperBound float64 `json:"le"`
+	Count      int     `json:"count"`
+}
+
+// WorkerMetrics represents the throughput of a single worker
+type WorkerMetrics struct {
+	WorkerID        int     `json:"worker_id"`
+	Emails          int     `json:"emails"`
+	Failed          int     `json:"failed"`
+	Bytes           int64   `json:"bytes"`
+	BusySeconds     float64 `json:"busy_seconds"`
+	EmailsPerSecond float64 `json:"emails_per_second"`
 }
 
 // EmailMetrics represents email-related metrics
 type EmailMetrics struct {
-	TotalMatched  int   `json:"total_matched"`
-	TotalExported int   `json:"total_exported"`
-	TotalFailed   int   `json:"total_failed"`
-	TotalSize     int64 `json:"total_size_bytes"`
+	TotalMatched     int            `json:"total_matched"`
+	TotalExported    int            `json:"total_exported"`
+	TotalFailed      int            `json:"total_failed"`
+	TotalSkipped     int            `json:"total_skipped"`
+	SkippedByReason  map[string]int `json:"skipped_by_reason,omitempty"`
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+	TotalSize        int64          `json:"total_size_bytes"`
 }
 
 // Performance represents performance metrics
@@ -50,6 +141,7 @@ type Performance struct {
 // Failure represents a failed operation
 type Failure struct {
 	EmailID   string    `json:"email_id"`
+	Category  string    `json:"category"`
 	Error     string    `json:"error"`
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -67,52 +159,215 @@ func NewCollector(operation string) *Collector {
 		[]string{"operation", "status"},
 	)
 
+	emailsSkipped := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_emails_skipped_total",
+			Help: "Total number of emails deliberately skipped",
+		},
+		[]string{"operation", "reason"},
+	)
+
+	failures := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_failures_total",
+			Help: "Total number of failed emails by error category",
+		},
+		[]string{"operation", "category"},
+	)
+
+	emailsMatched := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        "gmail_exporter_emails_matched",
+			Help:        "Number of emails matched by the operation",
+			ConstLabels: prometheus.Labels{"operation": operation},
+		},
+	)
+
 	bytesProcessed := prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "gmail_exporter_bytes_total",
-			Help: "Total number of bytes processed",
+			Name:        "gmail_exporter_bytes_total",
+			Help:        "Total number of bytes processed",
+			ConstLabels: prometheus.Labels{"operation": operation},
 		},
 	)
 
 	operationDuration := prometheus.NewHistogram(
 		prometheus.HistogramOpts{
-			Name:    "gmail_exporter_duration_seconds",
-			Help:    "Time taken for operation",
-			Buckets: prometheus.DefBuckets,
+			Name:        "gmail_exporter_duration_seconds",
+			Help:        "Time taken for operation",
+			ConstLabels: prometheus.Labels{"operation": operation},
+			Buckets:     []float64{60, 300, 600, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+		},
+	)
+
+	apiCallDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gmail_exporter_api_call_duration_seconds",
+			Help:    "Latency of individual Gmail API calls",
+			Buckets: APILatencyBuckets,
 		},
+		[]string{"operation", "method"},
+	)
+
+	apiRetries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_api_retries_total",
+			Help: "Total number of retried Gmail API calls",
+		},
+		[]string{"operation", "method"},
+	)
+
+	workerEmails := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_worker_emails_total",
+			Help: "Total number of emails processed per worker",
+		},
+		[]string{"operation", "worker", "status"},
+	)
+
+	workerBytes := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_worker_bytes_total",
+			Help: "Total number of bytes processed per worker",
+		},
+		[]string{"operation", "worker"},
+	)
+
+	concurrency := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        "gmail_exporter_concurrency",
+			Help:        "Number of workers allowed to run at once",
+			ConstLabels: prometheus.Labels{"operation": operation},
+		},
+	)
+
+	clientCalls := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_client_api_calls_total",
+			Help: "Total number of Gmail API calls per OAuth client",
+		},
+		[]string{"operation", "client", "status"},
+	)
+
+	quotaUnits := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gmail_exporter_quota_units_total",
+			Help: "Total number of Gmail API quota units consumed",
+		},
+		[]string{"operation", "method"},
+	)
+
+	connections := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gmail_exporter_http_connections",
+			Help: "Number of HTTP requests sent on a new or a reused connection",
+		},
+		[]string{"operation", "reused"},
 	)
 
 	// Register metrics with the local registry
-	registry.MustRegister(emailsProcessed, bytesProcessed, operationDuration)
+	registry.MustRegister(emailsProcessed, emailsSkipped, failures, emailsMatched, bytesProcessed, operationDuration,
+		apiCallDuration, apiRetries, workerEmails, workerBytes, concurrency, clientCalls, quotaUnits, connections)
 
 	return &Collector{
 		operation: operation,
 		data: &Data{
 			Operation: operation,
 			Emails:    EmailMetrics{},
+			APICalls:  make(map[string]*APICallMetrics),
 			Failures:  make([]Failure, 0),
 		},
-		emailsProcessed:   *emailsProcessed,
+		registry:          registry,
+		workers:           make(map[int]*WorkerMetrics),
+		emailsProcessed:   emailsProcessed,
+		emailsSkipped:     emailsSkipped,
+		failures:          failures,
+		emailsMatched:     emailsMatched,
 		bytesProcessed:    bytesProcessed,
 		operationDuration: operationDuration,
+		apiCallDuration:   apiCallDuration,
+		apiRetries:        apiRetries,
+		workerEmails:      workerEmails,
+		workerBytes:       workerBytes,
+		concurrency:       concurrency,
+		clientCalls:       clientCalls,
+		quotaUnits:        quotaUnits,
+		connections:       connections,
 	}
 }
 
 // Start marks the beginning of an operation
 func (c *Collector) Start() {
+	c.mu.Lock()
 	c.startTime = time.Now()
 	c.data.StartTime = c.startTime
+	c.mu.Unlock()
+
 	logrus.WithField("operation", c.operation).Debug("Started metrics collection")
 }
 
-// RecordEmailsProcessed records the number of emails processed
+// AddExported increments the number of successfully processed emails.
+// It is safe to call from multiple workers as each email completes.
+func (c *Collector) AddExported(count int) {
+	c.mu.Lock()
+	c.data.Emails.TotalExported += count
+	c.mu.Unlock()
+
+	c.emailsProcessed.WithLabelValues(c.operation, "success").Add(float64(count))
+}
+
+// AddFailed increments the number of emails that failed to process.
+// It is safe to call from multiple workers as each email completes.
+func (c *Collector) AddFailed(count int) {
+	c.mu.Lock()
+	c.data.Emails.TotalFailed += count
+	c.mu.Unlock()
+
+	c.emailsProcessed.WithLabelValues(c.operation, "failed").Add(float64(count))
+}
+
+// AddSkipped records an email that was deliberately not processed, for the given reason.
+// It is safe to call from multiple workers as each email completes.
+func (c *Collector) AddSkipped(reason string) {
+	c.mu.Lock()
+	c.data.Emails.TotalSkipped++
+	if c.data.Emails.SkippedByReason == nil {
+		c.data.Emails.SkippedByReason = make(map[string]int)
+	}
+	c.data.Emails.SkippedByReason[reason]++
+	c.mu.Unlock()
+
+	c.emailsSkipped.WithLabelValues(c.operation, reason).Inc()
+}
+
+// AddBytes increments the number of bytes processed.
+// It is safe to call from multiple workers as each email completes.
+func (c *Collector) AddBytes(bytes int64) {
+	c.mu.Lock()
+	c.data.Emails.TotalSize += bytes
+	c.mu.Unlock()
+
+	c.bytesProcessed.Add(float64(bytes))
+}
+
+// RecordEmailsProcessed sets the total number of emails processed. Prefer
+// AddExported and AddFailed when counting live; both styles may be mixed since
+// the Prometheus counters only ever advance by the difference.
 func (c *Collector) RecordEmailsProcessed(exported, failed int) {
+	c.mu.Lock()
+	exportedDelta := exported - c.data.Emails.TotalExported
+	failedDelta := failed - c.data.Emails.TotalFailed
 	c.data.Emails.TotalExported = exported
 	c.data.Emails.TotalFailed = failed
+	c.mu.Unlock()
 
 	// Update Prometheus metrics
-	c.emailsProcessed.WithLabelValues(c.operation, "success").Add(float64(exported))
-	c.emailsProcessed.WithLabelValues(c.operation, "failed").Add(float64(failed))
+	if exportedDelta > 0 {
+		c.emailsProcessed.WithLabelValues(c.operation, "success").Add(float64(exportedDelta))
+	}
+	if failedDelta > 0 {
+		c.emailsProcessed.WithLabelValues(c.operation, "failed").Add(float64(failedDelta))
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"exported": exported,
@@ -120,16 +375,24 @@ func (c *Collector) RecordEmailsProcessed(exported, failed int) {
 	}).Debug("Recorded email processing metrics")
 }
 
-// RecordBytesProcessed records the number of bytes processed
+// RecordBytesProcessed sets the total number of bytes processed. Prefer
+// AddBytes when counting live.
 func (c *Collector) RecordBytesProcessed(bytes int64) {
+	c.mu.Lock()
+	delta := bytes - c.data.Emails.TotalSize
 	c.data.Emails.TotalSize = bytes
-	c.bytesProcessed.Add(float64(bytes))
+	c.mu.Unlock()
+
+	if delta > 0 {
+		c.bytesProcessed.Add(float64(delta))
+	}
 
 	logrus.WithField("bytes", bytes).Debug("Recorded bytes processed")
 }
 
 // RecordDuration records the operation duration
 func (c *Collector) RecordDuration(duration time.Duration) {
+	c.mu.Lock()
 	endTime := time.Now()
 	c.data.EndTime = &endTime
 	c.data.Duration = duration
@@ -140,41 +403,278 @@ func (c *Collector) RecordDuration(duration time.Duration) {
 		c.data.Performance.EmailsPerSecond = totalEmails / duration.Seconds()
 		c.data.Performance.BytesPerSecond = float64(c.data.Emails.TotalSize) / duration.Seconds()
 	}
+	c.mu.Unlock()
 
 	c.operationDuration.Observe(duration.Seconds())
 
 	logrus.WithField("duration", duration).Debug("Recorded operation duration")
 }
 
-// RecordFailure records a failed operation
-func (c *Collector) RecordFailure(emailID, errorMsg string) {
+// RecordFailure records a failed operation and its error category.
+// It is safe to call from multiple workers.
+func (c *Collector) RecordFailure(emailID, category, errorMsg string) {
 	failure := Failure{
 		EmailID:   emailID,
+		Category:  category,
 		Error:     errorMsg,
 		Timestamp: time.Now(),
 	}
+
+	c.mu.Lock()
 	c.data.Failures = append(c.data.Failures, failure)
+	if c.data.Emails.FailedByCategory == nil {
+		c.data.Emails.FailedByCategory = make(map[string]int)
+	}
+	c.data.Emails.FailedByCategory[category]++
+	c.mu.Unlock()
+
+	c.failures.WithLabelValues(c.operation, category).Inc()
 
 	logrus.WithFields(logrus.Fields{
 		"email_id": emailID,
+		"category": category,
 		"error":    errorMsg,
 	}).Debug("Recorded failure")
 }
 
+// Progress is a consistent point-in-time view of the email counters
+type Progress struct {
+	Matched   int
+	Exported  int
+	Failed    int
+	Skipped   int
+	Processed int
+	Bytes     int64
+}
+
+// Progress returns a snapshot of the email counters.
+// It is safe to call while workers are recording.
+func (c *Collector) Progress() Progress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Progress{
+		Matched:   c.data.Emails.TotalMatched,
+		Exported:  c.data.Emails.TotalExported,
+		Failed:    c.data.Emails.TotalFailed,
+		Skipped:   c.data.Emails.TotalSkipped,
+		Processed: c.data.Emails.TotalExported + c.data.Emails.TotalFailed + c.data.Emails.TotalSkipped,
+		Bytes:     c.data.Emails.TotalSize,
+	}
+}
+
+// Percent returns the share of matched emails processed so far
+func (p Progress) Percent() float64 {
+	if p.Matched == 0 {
+		return 0
+	}
+	return float64(p.Processed) / float64(p.Matched) * 100
+}
+
+// RecordAPICall records the latency and outcome of a single Gmail API call.
+// It is safe to call from multiple workers.
+func (c *Collector) RecordAPICall(method string, duration time.Duration, err error) {
+	seconds := duration.Seconds()
+
+	c.mu.Lock()
+	call := c.apiCallLocked(method)
+	if call.Calls == 0 || seconds < call.MinSeconds {
+		call.MinSeconds = seconds
+	}
+	call.Calls++
+	if err != nil {
+		call.Errors++
+	}
+	call.TotalSeconds += seconds
+	call.AverageSeconds = call.TotalSeconds / float64(call.Calls)
+	if seconds > call.MaxSeconds {
+		call.MaxSeconds = seconds
+	}
+	for i := range call.Buckets {
+		if seconds <= call.Buckets[i].UpperBound {
+			call.Buckets[i].Count++
+		}
+	}
+	c.mu.Unlock()
+
+	c.apiCallDuration.WithLabelValues(c.operation, method).Observe(seconds)
+}
+
+// RecordQuota records the quota units charged for a call to method.
+// It is safe to call from multiple workers.
+func (c *Collector) RecordQuota(method string, units int) {
+	c.mu.Lock()
+	if c.data.Quota == nil {
+		c.data.Quota = &QuotaMetrics{ByMethod: make(map[string]int)}
+	}
+	c.data.Quota.Units += units
+	c.data.Quota.ByMethod[method] += units
+	c.mu.Unlock()
+
+	c.quotaUnits.WithLabelValues(c.operation, method).Add(float64(units))
+}
+
+// RecordConnections records the number of HTTP requests of the operation
+// sent on new and on reused connections
+func (c *Collector) RecordConnections(newConns, reused int64) {
+	connections := &ConnectionMetrics{New: newConns, Reused: reused}
+	if total := newConns + reused; total > 0 {
+		connections.ReuseRatio = float64(reused) / float64(total)
+	}
+
+	c.mu.Lock()
+	c.data.Connections = connections
+	c.mu.Unlock()
+
+	c.connections.WithLabelValues(c.operation, "false").Set(float64(newConns))
+	c.connections.WithLabelValues(c.operation, "true").Set(float64(reused))
+}
+
+// RecordClientCall records the outcome of a Gmail API call made with the
+// named OAuth client. It is safe to call from multiple workers.
+func (c *Collector) RecordClientCall(client string, rateLimited bool, err error) {
+	status := "success"
+	switch {
+	case rateLimited:
+		status = "rate_limited"
+	case err != nil:
+		status = "error"
+	}
+
+	c.mu.Lock()
+	if c.data.Clients == nil {
+		c.data.Clients = make(map[string]*ClientMetrics)
+	}
+	stats, ok := c.data.Clients[client]
+	if !ok {
+		stats = &ClientMetrics{}
+		c.data.Clients[client] = stats
+	}
+	stats.Calls++
+	switch status {
+	case "rate_limited":
+		stats.RateLimited++
+	case "error":
+		stats.Errors++
+	}
+	c.mu.Unlock()
+
+	c.clientCalls.WithLabelValues(c.operation, client, status).Inc()
+}
+
+// RecordRetry records that a Gmail API call is being retried.
+// It is safe to call from multiple workers.
+func (c *Collector) RecordRetry(method string) {
+	c.mu.Lock()
+	c.apiCallLocked(method).Retries++
+	c.mu.Unlock()
+
+	c.apiRetries.WithLabelValues(c.operation, method).Inc()
+	logrus.WithField("method", method).Debug("Recorded API retry")
+}
+
+// apiCallLocked returns the statistics for method, creating them if needed. c.mu must be held.
+func (c *Collector) apiCallLocked(method string) *APICallMetrics {
+	call, ok := c.data.APICalls[method]
+	if !ok {
+		call = &APICallMetrics{Buckets: make([]BucketCount, len(APILatencyBuckets))}
+		for i, bound := range APILatencyBuckets {
+			call.Buckets[i].UpperBound = bound
+		}
+		c.data.APICalls[method] = call
+	}
+	return call
+}
+
+// RecordWorkerResult records one processed email for the given worker.
+// It is safe to call from multiple workers.
+func (c *Collector) RecordWorkerResult(workerID int, bytes int64, duration time.Duration, err error) {
+	c.mu.Lock()
+	worker, ok := c.workers[workerID]
+	if !ok {
+		worker = &WorkerMetrics{WorkerID: workerID}
+		c.workers[workerID] = worker
+		c.data.Workers = append(c.data.Workers, worker)
+		sort.Slice(c.data.Workers, func(i, j int) bool {
+			return c.data.Workers[i].WorkerID < c.data.Workers[j].WorkerID
+		})
+	}
+
+	status := "success"
+	if err != nil {
+		worker.Failed++
+		status = "failed"
+	} else {
+		worker.Emails++
+		worker.Bytes += bytes
+	}
+	worker.BusySeconds += duration.Seconds()
+	if worker.BusySeconds > 0 {
+		worker.EmailsPerSecond = float64(worker.Emails+worker.Failed) / worker.BusySeconds
+	}
+	c.mu.Unlock()
+
+	label := fmt.Sprintf("%d", workerID)
+	c.workerEmails.WithLabelValues(c.operation, label, status).Inc()
+	if err == nil {
+		c.workerBytes.WithLabelValues(c.operation, label).Add(float64(bytes))
+	}
+}
+
+// SetConcurrency records the number of workers allowed to run at once. The
+// first call sets the starting value; later calls count as adjustments.
+// It is safe to call from multiple workers.
+func (c *Collector) SetConcurrency(workers int) {
+	c.mu.Lock()
+	if c.data.Concurrency == nil {
+		c.data.Concurrency = &ConcurrencyMetrics{Min: workers, Max: workers}
+	} else {
+		c.data.Concurrency.Adjustments++
+	}
+	concurrency := c.data.Concurrency
+	concurrency.Current = workers
+	if workers < concurrency.Min {
+		concurrency.Min = workers
+	}
+	if workers > concurrency.Max {
+		concurrency.Max = workers
+	}
+	c.mu.Unlock()
+
+	c.concurrency.Set(float64(workers))
+}
+
+// AddMatched increments the total number of emails matched, for operations
+// that discover matches incrementally
+func (c *Collector) AddMatched(count int) {
+	c.mu.Lock()
+	c.data.Emails.TotalMatched += count
+	total := c.data.Emails.TotalMatched
+	c.mu.Unlock()
+
+	c.emailsMatched.Set(float64(total))
+}
+
 // SetTotalMatched sets the total number of emails matched
 func (c *Collector) SetTotalMatched(total int) {
+	c.mu.Lock()
 	c.data.Emails.TotalMatched = total
+	c.mu.Unlock()
+	c.emailsMatched.Set(float64(total))
 	logrus.WithField("total_matched", total).Debug("Set total matched emails")
 }
 
 // Save saves the metrics to a file in JSON format
 func (c *Collector) Save(filename string) error {
+	c.mu.Lock()
 	data, err := json.MarshalIndent(c.data, "", "  ")
+	c.mu.Unlock()
+
 	if err != nil {
 		return fmt.Errorf("failed to marshal metrics: %w", err)
 	}
 
-	if err := os.WriteFile(filename, data, 0o600); err != nil {
+	if err := atomicfile.WriteFile(filename, data, 0o600); err != nil {
 		return fmt.Errorf("failed to write metrics file: %w", err)
 	}
 
@@ -182,38 +682,22 @@ func (c *Collector) Save(filename string) error {
 	return nil
 }
 
-// SavePrometheus saves the metrics in Prometheus format
+// SavePrometheus saves the metrics in Prometheus text exposition format,
+// serialized from the collector's registry so every registered metric is included
 func (c *Collector) SavePrometheus(filename string) error {
-	// This is a simplified implementation
-	// In a real implementation, you would use the Prometheus registry to export metrics
-	prometheusData := fmt.Sprintf(`# HELP gmail_exporter_emails_total Total number of emails processed
-# TYPE gmail_exporter_emails_total counter
-gmail_exporter_emails_total{operation="%s",status="success"} %d
-gmail_exporter_emails_total{operation="%s",status="failed"} %d
-
-# HELP gmail_exporter_bytes_total Total number of bytes processed
-# TYPE gmail_exporter_bytes_total counter
-gmail_exporter_bytes_total %d
-
-# HELP gmail_exporter_duration_seconds Time taken for operation
-# TYPE gmail_exporter_duration_seconds histogram
-gmail_exporter_duration_seconds_bucket{operation="%s",le="300"} %d
-gmail_exporter_duration_seconds_bucket{operation="%s",le="600"} %d
-gmail_exporter_duration_seconds_bucket{operation="%s",le="+Inf"} %d
-gmail_exporter_duration_seconds_sum{operation="%s"} %.2f
-gmail_exporter_duration_seconds_count{operation="%s"} 1
-`,
-		c.operation, c.data.Emails.TotalExported,
-		c.operation, c.data.Emails.TotalFailed,
-		c.data.Emails.TotalSize,
-		c.operation, getBucketCount(c.data.Duration, 300*time.Second),
-		c.operation, getBucketCount(c.data.Duration, 600*time.Second),
-		c.operation, 1,
-		c.operation, c.data.Duration.Seconds(),
-		c.operation,
-	)
+	families, err := c.registry.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather Prometheus metrics: %w", err)
+	}
 
-	if err := os.WriteFile(filename, []byte(prometheusData), 0o600); err != nil {
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return fmt.Errorf("failed to encode Prometheus metrics: %w", err)
+		}
+	}
+
+	if err := atomicfile.WriteFile(filename, buf.Bytes(), 0o600); err != nil {
 		return fmt.Errorf("failed to write Prometheus metrics file: %w", err)
 	}
 
@@ -221,21 +705,90 @@ gmail_exporter_duration_seconds_count{operation="%s"} 1
 	return nil
 }
 
+// Registry returns the Prometheus registry holding this collector's metrics
+func (c *Collector) Registry() *prometheus.Registry {
+	return c.registry
+}
+
 // GetData returns the current metrics data
 func (c *Collector) GetData() *Data {
-	return c.data
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshotLocked()
 }
 
-// getBucketCount returns 1 if duration is less than or equal to bucket, 0 otherwise
-func getBucketCount(duration, bucket time.Duration) int {
-	if duration <= bucket {
-		return 1
+// snapshotLocked returns a deep copy of the metrics data. c.mu must be held.
+func (c *Collector) snapshotLocked() *Data {
+	snapshot := *c.data
+
+	if c.data.EndTime != nil {
+		endTime := *c.data.EndTime
+		snapshot.EndTime = &endTime
+	}
+
+	if c.data.Emails.SkippedByReason != nil {
+		snapshot.Emails.SkippedByReason = make(map[string]int, len(c.data.Emails.SkippedByReason))
+		for reason, count := range c.data.Emails.SkippedByReason {
+			snapshot.Emails.SkippedByReason[reason] = count
+		}
+	}
+
+	if c.data.Emails.FailedByCategory != nil {
+		snapshot.Emails.FailedByCategory = make(map[string]int, len(c.data.Emails.FailedByCategory))
+		for category, count := range c.data.Emails.FailedByCategory {
+			snapshot.Emails.FailedByCategory[category] = count
+		}
 	}
-	return 0
+
+	snapshot.APICalls = make(map[string]*APICallMetrics, len(c.data.APICalls))
+	for method, call := range c.data.APICalls {
+		callCopy := *call
+		callCopy.Buckets = append([]BucketCount(nil), call.Buckets...)
+		snapshot.APICalls[method] = &callCopy
+	}
+
+	if c.data.Clients != nil {
+		snapshot.Clients = make(map[string]*ClientMetrics, len(c.data.Clients))
+		for client, stats := range c.data.Clients {
+			statsCopy := *stats
+			snapshot.Clients[client] = &statsCopy
+		}
+	}
+
+	if c.data.Quota != nil {
+		quota := QuotaMetrics{Units: c.data.Quota.Units, ByMethod: make(map[string]int, len(c.data.Quota.ByMethod))}
+		for method, units := range c.data.Quota.ByMethod {
+			quota.ByMethod[method] = units
+		}
+		snapshot.Quota = &quota
+	}
+
+	snapshot.Workers = make([]*WorkerMetrics, 0, len(c.data.Workers))
+	for _, worker := range c.data.Workers {
+		workerCopy := *worker
+		snapshot.Workers = append(snapshot.Workers, &workerCopy)
+	}
+
+	if c.data.Concurrency != nil {
+		concurrency := *c.data.Concurrency
+		snapshot.Concurrency = &concurrency
+	}
+
+	if c.data.Connections != nil {
+		connections := *c.data.Connections
+		snapshot.Connections = &connections
+	}
+
+	snapshot.Failures = append(make([]Failure, 0, len(c.data.Failures)), c.data.Failures...)
+
+	return &snapshot
 }
 
 // Summary returns a human-readable summary of the metrics
 func (c *Collector) Summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.data.EndTime == nil {
 		return fmt.Sprintf("Operation '%s' in progress (started: %s)",
 			c.operation, c.data.StartTime.Format("2006-01-02 15:04:05"))
@@ -2,8 +2,10 @@ package metrics
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -109,7 +111,7 @@ func TestCollector_RecordFailure(t *testing.T) {
 	errorMsg := "test error message"
 
 	beforeRecord := time.Now()
-	collector.RecordFailure(emailID, errorMsg)
+	collector.RecordFailure(emailID, "network", errorMsg)
 	afterRecord := time.Now()
 
 	if len(collector.data.Failures) != 1 {
@@ -125,11 +127,237 @@ func TestCollector_RecordFailure(t *testing.T) {
 		t.Errorf("Expected error %s, got %s", errorMsg, failure.Error)
 	}
 
+	if failure.Category != "network" {
+		t.Errorf("Expected category network, got %s", failure.Category)
+	}
+
+	if collector.data.Emails.FailedByCategory["network"] != 1 {
+		t.Errorf("Expected 1 network failure, got %v", collector.data.Emails.FailedByCategory)
+	}
+
 	if failure.Timestamp.Before(beforeRecord) || failure.Timestamp.After(afterRecord) {
 		t.Error("Failure timestamp not set correctly")
 	}
 }
 
+func TestCollector_AddMethods(t *testing.T) {
+	collector := NewCollector("test")
+	collector.SetTotalMatched(200)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 10; w++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				collector.AddExported(1)
+				collector.AddBytes(100)
+				collector.RecordWorkerResult(workerID, 100, time.Millisecond, nil)
+			}
+			for i := 0; i < 5; i++ {
+				collector.AddFailed(1)
+				collector.RecordFailure("email", "unknown", "boom")
+			}
+			collector.Progress()
+		}(w)
+	}
+	wg.Wait()
+
+	progress := collector.Progress()
+	if progress.Exported != 100 {
+		t.Errorf("Expected 100 exported, got %d", progress.Exported)
+	}
+	if progress.Failed != 50 {
+		t.Errorf("Expected 50 failed, got %d", progress.Failed)
+	}
+	if progress.Processed != 150 {
+		t.Errorf("Expected 150 processed, got %d", progress.Processed)
+	}
+	if progress.Bytes != 10000 {
+		t.Errorf("Expected 10000 bytes, got %d", progress.Bytes)
+	}
+	if progress.Percent() != 75 {
+		t.Errorf("Expected 75%% complete, got %.1f", progress.Percent())
+	}
+
+	data := collector.GetData()
+	if len(data.Failures) != 50 {
+		t.Errorf("Expected 50 failures, got %d", len(data.Failures))
+	}
+	if len(data.Workers) != 10 {
+		t.Errorf("Expected 10 workers, got %d", len(data.Workers))
+	}
+}
+
+func TestCollector_AddSkipped(t *testing.T) {
+	collector := NewCollector("test")
+	collector.SetTotalMatched(4)
+	collector.AddExported(2)
+	collector.AddSkipped("size_out_of_bounds")
+	collector.AddSkipped("size_out_of_bounds")
+
+	data := collector.GetData()
+	if data.Emails.TotalSkipped != 2 {
+		t.Errorf("Expected 2 skipped, got %d", data.Emails.TotalSkipped)
+	}
+	if data.Emails.SkippedByReason["size_out_of_bounds"] != 2 {
+		t.Errorf("Expected 2 skipped by size, got %v", data.Emails.SkippedByReason)
+	}
+
+	// Skipped emails count towards progress
+	if percent := collector.Progress().Percent(); percent != 100 {
+		t.Errorf("Expected 100%% progress, got %.1f", percent)
+	}
+}
+
+func TestCollector_RecordEmailsProcessedAfterAdd(t *testing.T) {
+	collector := NewCollector("test")
+	collector.AddExported(3)
+
+	// Setting the total after live counting must not double count
+	collector.RecordEmailsProcessed(5, 0)
+
+	if collector.data.Emails.TotalExported != 5 {
+		t.Errorf("Expected 5 exported, got %d", collector.data.Emails.TotalExported)
+	}
+}
+
+func TestCollector_RecordAPICall(t *testing.T) {
+	collector := NewCollector("test")
+
+	collector.RecordAPICall("messages.get", 80*time.Millisecond, nil)
+	collector.RecordAPICall("messages.get", 400*time.Millisecond, nil)
+	collector.RecordAPICall("messages.get", 2*time.Second, errors.New("boom"))
+	collector.RecordRetry("messages.get")
+
+	call, ok := collector.data.APICalls["messages.get"]
+	if !ok {
+		t.Fatal("Expected messages.get API call metrics to be recorded")
+	}
+
+	if call.Calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", call.Calls)
+	}
+	if call.Errors != 1 {
+		t.Errorf("Expected 1 error, got %d", call.Errors)
+	}
+	if call.Retries != 1 {
+		t.Errorf("Expected 1 retry, got %d", call.Retries)
+	}
+	if call.MinSeconds != 0.08 {
+		t.Errorf("Expected min 0.08s, got %f", call.MinSeconds)
+	}
+	if call.MaxSeconds != 2 {
+		t.Errorf("Expected max 2s, got %f", call.MaxSeconds)
+	}
+
+	// Buckets are cumulative
+	expectedBuckets := map[float64]int{0.05: 0, 0.1: 1, 0.5: 2, 2.5: 3, 30: 3}
+	for _, bucket := range call.Buckets {
+		if expected, ok := expectedBuckets[bucket.UpperBound]; ok && bucket.Count != expected {
+			t.Errorf("Expected bucket le=%g to hold %d, got %d", bucket.UpperBound, expected, bucket.Count)
+		}
+	}
+}
+
+func TestCollector_RecordWorkerResult(t *testing.T) {
+	collector := NewCollector("test")
+
+	collector.RecordWorkerResult(1, 2048, time.Second, nil)
+	collector.RecordWorkerResult(0, 1024, time.Second, nil)
+	collector.RecordWorkerResult(1, 0, time.Second, errors.New("boom"))
+
+	if len(collector.data.Workers) != 2 {
+		t.Fatalf("Expected 2 workers, got %d", len(collector.data.Workers))
+	}
+
+	if collector.data.Workers[0].WorkerID != 0 {
+		t.Errorf("Expected workers sorted by ID, got first ID %d", collector.data.Workers[0].WorkerID)
+	}
+
+	worker := collector.data.Workers[1]
+	if worker.Emails != 1 || worker.Failed != 1 {
+		t.Errorf("Expected 1 exported and 1 failed, got %d and %d", worker.Emails, worker.Failed)
+	}
+	if worker.Bytes != 2048 {
+		t.Errorf("Expected 2048 bytes, got %d", worker.Bytes)
+	}
+	if worker.EmailsPerSecond != 1 {
+		t.Errorf("Expected 1 email/sec, got %f", worker.EmailsPerSecond)
+	}
+}
+
+func TestCollector_SetConcurrency(t *testing.T) {
+	collector := NewCollector("test")
+
+	collector.SetConcurrency(4)
+	collector.SetConcurrency(5)
+	collector.SetConcurrency(2)
+
+	concurrency := collector.GetData().Concurrency
+	if concurrency == nil {
+		t.Fatal("Expected concurrency metrics")
+	}
+	if concurrency.Current != 2 || concurrency.Min != 2 || concurrency.Max != 5 {
+		t.Errorf("Expected current 2, min 2, max 5, got %d, %d, %d", concurrency.Current, concurrency.Min, concurrency.Max)
+	}
+	if concurrency.Adjustments != 2 {
+		t.Errorf("Expected 2 adjustments, got %d", concurrency.Adjustments)
+	}
+}
+
+func TestCollector_RecordClientCall(t *testing.T) {
+	collector := NewCollector("test")
+
+	collector.RecordClientCall("default", false, nil)
+	collector.RecordClientCall("default", true, errors.New("quota"))
+	collector.RecordClientCall("second", false, errors.New("boom"))
+
+	clients := collector.GetData().Clients
+	if len(clients) != 2 {
+		t.Fatalf("Expected 2 clients, got %d", len(clients))
+	}
+	if stats := clients["default"]; stats.Calls != 2 || stats.RateLimited != 1 || stats.Errors != 0 {
+		t.Errorf("Unexpected default client metrics: %+v", stats)
+	}
+	if stats := clients["second"]; stats.Calls != 1 || stats.Errors != 1 {
+		t.Errorf("Unexpected second client metrics: %+v", stats)
+	}
+}
+
+func TestCollector_RecordQuota(t *testing.T) {
+	collector := NewCollector("test")
+
+	collector.RecordQuota("messages.get", 5)
+	collector.RecordQuota("messages.get", 5)
+	collector.RecordQuota("labels.list", 1)
+
+	quota := collector.GetData().Quota
+	if quota == nil || quota.Units != 11 {
+		t.Fatalf("Expected 11 quota units, got %+v", quota)
+	}
+	if quota.ByMethod["messages.get"] != 10 || quota.ByMethod["labels.list"] != 1 {
+		t.Errorf("Unexpected quota units by method: %v", quota.ByMethod)
+	}
+}
+
+func TestCollector_RecordConnections(t *testing.T) {
+	collector := NewCollector("test")
+	if collector.GetData().Connections != nil {
+		t.Fatal("Expected no connection metrics before they are recorded")
+	}
+
+	collector.RecordConnections(4, 96)
+
+	connections := collector.GetData().Connections
+	if connections == nil || connections.New != 4 || connections.Reused != 96 {
+		t.Fatalf("Unexpected connection metrics: %+v", connections)
+	}
+	if connections.ReuseRatio != 0.96 {
+		t.Errorf("Expected a reuse ratio of 0.96, got %v", connections.ReuseRatio)
+	}
+}
+
 func TestCollector_SetTotalMatched(t *testing.T) {
 	collector := NewCollector("test")
 
@@ -141,6 +369,17 @@ func TestCollector_SetTotalMatched(t *testing.T) {
 	}
 }
 
+func TestCollector_AddMatched(t *testing.T) {
+	collector := NewCollector("test")
+
+	collector.AddMatched(100)
+	collector.AddMatched(50)
+
+	if collector.data.Emails.TotalMatched != 150 {
+		t.Errorf("Expected total matched 150, got %d", collector.data.Emails.TotalMatched)
+	}
+}
+
 func TestCollector_Save(t *testing.T) {
 	// Create temporary directory
 	tempDir, err := os.MkdirTemp("", "metrics_test")
@@ -252,6 +491,42 @@ func TestCollector_SavePrometheus(t *testing.T) {
 	}
 }
 
+func TestCollector_SavePrometheus_APIMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+
+	collector := NewCollector("test")
+	collector.Start()
+	collector.RecordAPICall("messages.get", 200*time.Millisecond, nil)
+	collector.RecordRetry("messages.get")
+	collector.RecordWorkerResult(0, 1024, time.Second, nil)
+	collector.SetConcurrency(3)
+	collector.RecordDuration(time.Minute)
+
+	filename := filepath.Join(tempDir, "metrics.prom")
+	if err := collector.SavePrometheus(filename); err != nil {
+		t.Fatalf("Failed to save Prometheus metrics: %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read Prometheus metrics file: %v", err)
+	}
+	content := string(data)
+
+	expected := []string{
+		`gmail_exporter_api_call_duration_seconds_count{method="messages.get",operation="test"} 1`,
+		`gmail_exporter_api_retries_total{method="messages.get",operation="test"} 1`,
+		`gmail_exporter_worker_emails_total{operation="test",status="success",worker="0"} 1`,
+		`gmail_exporter_worker_bytes_total{operation="test",worker="0"} 1024`,
+		`gmail_exporter_concurrency{operation="test"} 3`,
+	}
+	for _, line := range expected {
+		if !contains(content, line) {
+			t.Errorf("Expected %q in Prometheus output", line)
+		}
+	}
+}
+
 func TestCollector_GetData(t *testing.T) {
 	collector := NewCollector("test")
 	collector.Start()
@@ -304,43 +579,6 @@ func TestCollector_Summary(t *testing.T) {
 	}
 }
 
-func TestGetBucketCount(t *testing.T) {
-	tests := []struct {
-		name     string
-		duration time.Duration
-		bucket   time.Duration
-		expected int
-	}{
-		{
-			name:     "duration less than bucket",
-			duration: 4 * time.Minute,
-			bucket:   5 * time.Minute,
-			expected: 1,
-		},
-		{
-			name:     "duration equal to bucket",
-			duration: 5 * time.Minute,
-			bucket:   5 * time.Minute,
-			expected: 1,
-		},
-		{
-			name:     "duration greater than bucket",
-			duration: 6 * time.Minute,
-			bucket:   5 * time.Minute,
-			expected: 0,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := getBucketCount(tt.duration, tt.bucket)
-			if result != tt.expected {
-				t.Errorf("getBucketCount() = %d, want %d", result, tt.expected)
-			}
-		})
-	}
-}
-
 // Helper function to check if a string contains a substring
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
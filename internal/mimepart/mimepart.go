@@ -0,0 +1,179 @@
+// Package mimepart parses RFC 822 messages into the MIME part tree that the
+// Gmail API returns for a message in the full format
+package mimepart
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"google.golang.org/api/gmail/v1"
+)
+
+// maxDepth bounds the nesting of multipart bodies
+const maxDepth = 10
+
+// headerDecoder decodes RFC 2047 encoded words in any charset known to the
+// WHATWG encoding index, such as iso-2022-jp, koi8-r and windows-1251
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// Parse parses a raw message into its Gmail API payload. The top-level
+// headers keep their order and spelling; those of subparts are sorted by
+// name. Bodies are decoded from their Content-Transfer-Encoding and base64url
+// encoded with padding, parts are numbered as Gmail numbers them, and
+// multipart bodies nested deeper than maxDepth are kept as single parts.
+// Only unreadable top-level headers are an error; a malformed body is kept
+// as far as it could be read.
+func Parse(raw []byte) (*gmail.MessagePart, error) {
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	headers, err := readHeaders(reader)
+	if err != nil {
+		return nil, err
+	}
+	return parsePart(headers, reader, "", 0), nil
+}
+
+// Header returns the value of the first header called name, ignoring case
+func Header(headers []*gmail.MessagePartHeader, name string) string {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// Data returns the decoded body of a part, whose base64url data may be
+// padded or not
+func Data(part *gmail.MessagePart) ([]byte, error) {
+	if part.Body == nil {
+		return nil, nil
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(part.Body.Data, "="))
+}
+
+// parsePart builds a part from its headers and body, numbering its child
+// parts after partID
+func parsePart(headers []*gmail.MessagePartHeader, body io.Reader, partID string, depth int) *gmail.MessagePart {
+	mediaType, params, err := mime.ParseMediaType(Header(headers, "Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	part := &gmail.MessagePart{
+		PartId:   partID,
+		MimeType: mediaType,
+		Headers:  headers,
+		Body:     &gmail.MessagePartBody{},
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxDepth {
+		reader := multipart.NewReader(body, params["boundary"])
+		for i := 0; ; i++ {
+			child, err := reader.NextRawPart()
+			if err != nil {
+				break
+			}
+			childID := strconv.Itoa(i)
+			if partID != "" {
+				childID = partID + "." + childID
+			}
+			part.Parts = append(part.Parts, parsePart(sortedHeaders(child.Header), child, childID, depth+1))
+		}
+		return part
+	}
+
+	content, err := io.ReadAll(DecodeBody(body, Header(headers, "Content-Transfer-Encoding")))
+	if err != nil && len(content) == 0 {
+		return part
+	}
+
+	_, dispositionParams, _ := mime.ParseMediaType(Header(headers, "Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	part.Filename = DecodeHeader(filename)
+	part.Body.Size = int64(len(content))
+	part.Body.Data = base64.URLEncoding.EncodeToString(content)
+	return part
+}
+
+// readHeaders reads headers in order up to the blank line ending them,
+// unfolding continued lines
+func readHeaders(reader *bufio.Reader) ([]*gmail.MessagePartHeader, error) {
+	var headers []*gmail.MessagePartHeader
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return headers, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(headers) == 0 {
+				return nil, fmt.Errorf("malformed header line %q", line)
+			}
+			last := headers[len(headers)-1]
+			last.Value += " " + strings.TrimSpace(line)
+		} else {
+			name, value, ok := strings.Cut(line, ":")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("malformed header line %q", line)
+			}
+			headers = append(headers, &gmail.MessagePartHeader{Name: name, Value: strings.TrimSpace(value)})
+		}
+		if err == io.EOF {
+			return headers, nil
+		}
+	}
+}
+
+// sortedHeaders returns the headers of a subpart, sorted by name
+func sortedHeaders(header map[string][]string) []*gmail.MessagePartHeader {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make([]*gmail.MessagePartHeader, 0, len(header))
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, &gmail.MessagePartHeader{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// DecodeBody decodes a part body by its Content-Transfer-Encoding
+func DecodeBody(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// DecodeHeader decodes the RFC 2047 encoded words of a header value in any
+// charset, returning the value unchanged if decoding fails
+func DecodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,124 @@
+package mimepart
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+const multipartMessage = "From: Alice <alice@example.com>\r\n" +
+	"Subject: Report\r\n" +
+	"Content-type: multipart/mixed;\r\n boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"caf=C3=A9\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>hi</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"=?UTF-8?Q?r=C3=A9sum=C3=A9.pdf?=\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0=\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	payload, err := Parse([]byte(multipartMessage))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if payload.MimeType != "multipart/mixed" || len(payload.Parts) != 2 {
+		t.Fatalf("Parse() = %s with %d parts", payload.MimeType, len(payload.Parts))
+	}
+	var names []string
+	for _, header := range payload.Headers {
+		names = append(names, header.Name)
+	}
+	if got := strings.Join(names, ","); got != "From,Subject,Content-type" {
+		t.Errorf("Top-level headers = %s, want them in message order", got)
+	}
+	if got := Header(payload.Headers, "content-type"); got != "multipart/mixed; boundary=outer" {
+		t.Errorf("Header() = %q, want the unfolded value", got)
+	}
+
+	tests := []struct {
+		name     string
+		part     *gmail.MessagePart
+		partID   string
+		mimeType string
+		filename string
+		data     string
+	}{
+		{"quoted-printable text", payload.Parts[0].Parts[0], "0.0", "text/plain", "", "café"},
+		{"html", payload.Parts[0].Parts[1], "0.1", "text/html", "", "<p>hi</p>"},
+		{"base64 attachment", payload.Parts[1], "1", "application/pdf", "résumé.pdf", "%PDF-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.part.PartId != tt.partID || tt.part.MimeType != tt.mimeType || tt.part.Filename != tt.filename {
+				t.Errorf("Part = %s %s %q, want %s %s %q", tt.part.PartId, tt.part.MimeType, tt.part.Filename, tt.partID, tt.mimeType, tt.filename)
+			}
+			data, err := Data(tt.part)
+			if err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if string(data) != tt.data || tt.part.Body.Size != int64(len(tt.data)) {
+				t.Errorf("Data() = %q (size %d), want %q", data, tt.part.Body.Size, tt.data)
+			}
+		})
+	}
+}
+
+func TestParse_Encoding(t *testing.T) {
+	payload, err := Parse([]byte("Subject: x\r\n\r\nab"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if payload.MimeType != "text/plain" || payload.Body.Data != "YWI=" {
+		t.Errorf("Parse() = %s %q, want text/plain with padded base64url data", payload.MimeType, payload.Body.Data)
+	}
+
+	unpadded := &gmail.MessagePart{Body: &gmail.MessagePartBody{Data: "YWI"}}
+	if data, err := Data(unpadded); err != nil || string(data) != "ab" {
+		t.Errorf("Data() = %q, %v, want unpadded data decoded", data, err)
+	}
+}
+
+func TestParse_Depth(t *testing.T) {
+	var message strings.Builder
+	message.WriteString("Content-Type: multipart/mixed; boundary=b0\r\n\r\n")
+	for i := 1; i <= maxDepth+2; i++ {
+		message.WriteString("--b" + strconv.Itoa(i-1) + "\r\nContent-Type: multipart/mixed; boundary=b" + strconv.Itoa(i) + "\r\n\r\n")
+	}
+
+	payload, err := Parse([]byte(message.String()))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	depth := 0
+	for part := payload; len(part.Parts) > 0; part = part.Parts[0] {
+		depth++
+	}
+	if depth != maxDepth {
+		t.Errorf("Parsed %d levels of parts, want %d", depth, maxDepth)
+	}
+}
+
+func TestParse_MalformedHeader(t *testing.T) {
+	if _, err := Parse([]byte(" folded first\r\n\r\nbody")); err == nil {
+		t.Error("Expected a message starting with a continued line to be rejected")
+	}
+}
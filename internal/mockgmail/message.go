@@ -0,0 +1,173 @@
+package mockgmail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// snippetLength is the length of the snippet of a message
+const snippetLength = 200
+
+// message is a message of the mailbox with its parsed MIME structure
+type message struct {
+	id        string
+	threadID  string
+	raw       []byte
+	date      time.Time
+	labels    []string
+	historyID uint64
+	payload   *gmail.MessagePart
+	snippet   string
+}
+
+// parseMessage parses an RFC 822 message, dated by its Date header or now
+func parseMessage(raw []byte) (*message, error) {
+	payload, err := mimepart.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &message{raw: raw, payload: payload, date: time.Now().Truncate(time.Millisecond)}
+	if date, err := mail.ParseDate(m.header("Date")); err == nil {
+		m.date = date
+	}
+	if text := m.text(); text != "" {
+		snippet := strings.Join(strings.Fields(text), " ")
+		if len(snippet) > snippetLength {
+			snippet = strings.ToValidUTF8(snippet[:snippetLength], "")
+		}
+		m.snippet = snippet
+	}
+	return m, nil
+}
+
+// decodeBase64 decodes the base64url encoded raw message of a request,
+// padded or not
+func decodeBase64(data string) ([]byte, error) {
+	if data == "" {
+		return nil, fmt.Errorf("the message has no raw content")
+	}
+	return base64.URLEncoding.DecodeString(data + strings.Repeat("=", (4-len(data)%4)%4))
+}
+
+// header returns the top-level header called name, decoding encoded words
+func (m *message) header(name string) string {
+	return mimepart.DecodeHeader(mimepart.Header(m.payload.Headers, name))
+}
+
+// text returns the first text/plain body of the message
+func (m *message) text() string {
+	var find func(part *gmail.MessagePart) string
+	find = func(part *gmail.MessagePart) string {
+		if part.MimeType == "text/plain" && part.Filename == "" && part.Body != nil {
+			data, _ := mimepart.Data(part)
+			return string(data)
+		}
+		for _, sub := range part.Parts {
+			if text := find(sub); text != "" {
+				return text
+			}
+		}
+		return ""
+	}
+	return find(m.payload)
+}
+
+// filenames returns the file names of the attachments of the message
+func (m *message) filenames() []string {
+	var names []string
+	var walk func(part *gmail.MessagePart)
+	walk = func(part *gmail.MessagePart) {
+		if part.Filename != "" {
+			names = append(names, part.Filename)
+		}
+		for _, sub := range part.Parts {
+			walk(sub)
+		}
+	}
+	walk(m.payload)
+	return names
+}
+
+// hasLabel reports whether the message has the label with id
+func (m *message) hasLabel(id string) bool {
+	return slices.Contains(m.labels, id)
+}
+
+// hasLabels reports whether the message has all the labels with ids
+func (m *message) hasLabels(ids []string) bool {
+	for _, id := range ids {
+		if !m.hasLabel(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// modify adds and removes labels, returning those actually added and removed
+func (m *message) modify(add, remove []string) (added, removed []string) {
+	for _, id := range add {
+		if !m.hasLabel(id) {
+			m.labels = append(m.labels, id)
+			added = append(added, id)
+		}
+	}
+	for _, id := range remove {
+		if i := slices.Index(m.labels, id); i >= 0 {
+			m.labels = slices.Delete(m.labels, i, i+1)
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(m.labels)
+	return added, removed
+}
+
+// ref returns the message as referred to by list and history responses
+func (m *message) ref() *gmail.Message {
+	return &gmail.Message{Id: m.id, ThreadId: m.threadID, LabelIds: slices.Clone(m.labels)}
+}
+
+// resource returns the message in format (full, metadata, minimal or raw),
+// metadata with only the headers named in metadataHeaders when given
+func (m *message) resource(format string, metadataHeaders []string) (*gmail.Message, error) {
+	resource := &gmail.Message{
+		Id:           m.id,
+		ThreadId:     m.threadID,
+		LabelIds:     m.labels,
+		Snippet:      m.snippet,
+		HistoryId:    m.historyID,
+		InternalDate: m.date.UnixMilli(),
+		SizeEstimate: int64(len(m.raw)),
+	}
+
+	switch format {
+	case "", "full":
+		resource.Payload = m.payload
+	case "metadata":
+		headers := m.payload.Headers
+		if len(metadataHeaders) > 0 {
+			headers = nil
+			for _, header := range m.payload.Headers {
+				if slices.ContainsFunc(metadataHeaders, func(name string) bool { return strings.EqualFold(name, header.Name) }) {
+					headers = append(headers, header)
+				}
+			}
+		}
+		resource.Payload = &gmail.MessagePart{MimeType: m.payload.MimeType, Headers: headers}
+	case "minimal":
+	case "raw":
+		resource.Raw = base64.URLEncoding.EncodeToString(m.raw)
+	default:
+		return nil, invalidArgument("Invalid format: %s", format)
+	}
+	return resource, nil
+}
@@ -0,0 +1,434 @@
+package mockgmail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+)
+
+// matcher reports whether a message of the mailbox matches a search term
+type matcher func(m *message, s *Server) bool
+
+// query is a parsed Gmail search query. It supports the operators built by
+// the tool's filters: from, to, cc, bcc, subject, label, in, is, category,
+// has:attachment, filename, after, before, newer_than, older_than, size,
+// larger, smaller and rfc822msgid, words and "quoted phrases", negation with
+// -, grouping with ( ) and { } and OR.
+type query struct {
+	match matcher
+	// spamTrash is set when the query searches spam or trash itself
+	spamTrash bool
+}
+
+// parseQuery parses a Gmail search query
+func parseQuery(q string) (*query, error) {
+	p := &queryParser{tokens: tokenize(q), query: &query{}}
+	match, err := p.all("")
+	if err != nil {
+		return nil, err
+	}
+	p.query.match = match
+	return p.query, nil
+}
+
+// tokenize splits a query into words, with quoted phrases kept whole, and
+// the grouping characters ( ) { }
+func tokenize(q string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	quoted := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			word.WriteRune(r)
+		case quoted:
+			word.WriteRune(r)
+		case unicode.IsSpace(r):
+			flush()
+		case strings.ContainsRune("(){}", r):
+			// A lone - before a group negates it
+			if word.String() == "-" {
+				word.Reset()
+				tokens = append(tokens, "-")
+			}
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// queryParser parses the tokens of a query
+type queryParser struct {
+	tokens []string
+	pos    int
+	query  *query
+}
+
+// next returns the next token, or "" at the end of the query
+func (p *queryParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+// peek returns the next token without consuming it
+func (p *queryParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// all parses terms up to end, all of which must match; terms joined by OR
+// match when either does
+func (p *queryParser) all(end string) (matcher, error) {
+	var terms []matcher
+	for {
+		token := p.peek()
+		if token == end {
+			p.next()
+			break
+		}
+		if token == "" {
+			return nil, fmt.Errorf("missing %q", end)
+		}
+		if token == "OR" {
+			p.next()
+			if len(terms) == 0 {
+				return nil, fmt.Errorf("OR without a term before it")
+			}
+			right, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			terms[len(terms)-1] = anyOf([]matcher{terms[len(terms)-1], right})
+			continue
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return allOf(terms), nil
+}
+
+// any parses terms up to end, any of which must match
+func (p *queryParser) any(end string) (matcher, error) {
+	var terms []matcher
+	for token := p.peek(); token != end; token = p.peek() {
+		if token == "" {
+			return nil, fmt.Errorf("missing %q", end)
+		}
+		if token == "OR" {
+			p.next()
+			continue
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	p.next()
+	return anyOf(terms), nil
+}
+
+// term parses one term, group or negated term
+func (p *queryParser) term() (matcher, error) {
+	token := p.next()
+	negated := false
+	if token == "-" {
+		negated, token = true, p.next()
+	} else if len(token) > 1 && token[0] == '-' {
+		negated, token = true, token[1:]
+	}
+
+	var match matcher
+	var err error
+	switch {
+	case token == "(":
+		match, err = p.all(")")
+	case token == "{":
+		match, err = p.any("}")
+	case token == ")" || token == "}" || token == "":
+		return nil, fmt.Errorf("unexpected %q", token)
+	case strings.HasSuffix(token, ":") && (p.peek() == "(" || p.peek() == "{"):
+		match, err = p.fieldGroup(strings.TrimSuffix(token, ":"), negated)
+	default:
+		match, err = p.operator(token, negated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if negated {
+		return not(match), nil
+	}
+	return match, nil
+}
+
+// fieldGroup parses the values of an operator grouped as field:(a b), all
+// of which must match, or field:{a b}, any of which must
+func (p *queryParser) fieldGroup(field string, negated bool) (matcher, error) {
+	open := p.next()
+	end := map[string]string{"(": ")", "{": "}"}[open]
+
+	var terms []matcher
+	for token := p.next(); token != end; token = p.next() {
+		if token == "" || token == "(" || token == "{" {
+			return nil, fmt.Errorf("unsupported group in %s:%s", field, open)
+		}
+		if token == "OR" {
+			continue
+		}
+		term, err := p.operator(field+":"+token, negated)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	if open == "{" {
+		return anyOf(terms), nil
+	}
+	return allOf(terms), nil
+}
+
+// operator parses a word, a quoted phrase or an operator:value term
+func (p *queryParser) operator(token string, negated bool) (matcher, error) {
+	name, value, ok := strings.Cut(token, ":")
+	if !ok || strings.HasPrefix(token, `"`) {
+		return contains(unquote(token), func(m *message) []string {
+			return []string{m.header("Subject"), m.header("From"), m.header("To"), m.header("Cc"), m.text()}
+		}), nil
+	}
+	value = unquote(value)
+	if value == "" {
+		return nil, fmt.Errorf("%s: has no value", name)
+	}
+
+	switch strings.ToLower(name) {
+	case "from", "subject", "cc", "bcc":
+		header := map[string]string{"from": "From", "subject": "Subject", "cc": "Cc", "bcc": "Bcc"}[strings.ToLower(name)]
+		return contains(value, func(m *message) []string { return []string{m.header(header)} }), nil
+	case "to":
+		return contains(value, func(m *message) []string { return []string{m.header("To"), m.header("Cc")} }), nil
+	case "rfc822msgid":
+		id := strings.Trim(value, "<>")
+		return func(m *message, _ *Server) bool { return strings.Trim(m.header("Message-ID"), "<>") == id }, nil
+	case "label":
+		return hasLabelNamed(value), nil
+	case "in":
+		return p.in(strings.ToLower(value), negated)
+	case "is":
+		id, ok := map[string]string{"unread": "UNREAD", "read": "UNREAD", "starred": "STARRED", "important": "IMPORTANT"}[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported is:%s", value)
+		}
+		if strings.EqualFold(value, "read") {
+			return not(hasLabelID(id)), nil
+		}
+		return hasLabelID(id), nil
+	case "category":
+		id, ok := map[string]string{
+			"primary": "CATEGORY_PERSONAL", "social": "CATEGORY_SOCIAL", "promotions": "CATEGORY_PROMOTIONS",
+			"updates": "CATEGORY_UPDATES", "forums": "CATEGORY_FORUMS",
+		}[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported category:%s", value)
+		}
+		return hasLabelID(id), nil
+	case "has":
+		if !strings.EqualFold(value, "attachment") {
+			return nil, fmt.Errorf("unsupported has:%s", value)
+		}
+		return func(m *message, _ *Server) bool { return len(m.filenames()) > 0 }, nil
+	case "filename":
+		return contains(value, func(m *message) []string { return m.filenames() }), nil
+	case "after", "before":
+		date, err := parseQueryDate(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %w", name, value, err)
+		}
+		if strings.EqualFold(name, "after") {
+			return func(m *message, _ *Server) bool { return !m.date.Before(date) }, nil
+		}
+		return func(m *message, _ *Server) bool { return m.date.Before(date) }, nil
+	case "newer_than", "older_than":
+		date, err := relativeDate(value, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %w", name, value, err)
+		}
+		if strings.EqualFold(name, "newer_than") {
+			return func(m *message, _ *Server) bool { return m.date.After(date) }, nil
+		}
+		return func(m *message, _ *Server) bool { return m.date.Before(date) }, nil
+	case "size", "larger", "smaller":
+		size, err := parseQuerySize(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %w", name, value, err)
+		}
+		if strings.EqualFold(name, "smaller") {
+			return func(m *message, _ *Server) bool { return int64(len(m.raw)) < size }, nil
+		}
+		return func(m *message, _ *Server) bool { return int64(len(m.raw)) > size }, nil
+	default:
+		return nil, fmt.Errorf("unsupported operator %s:", name)
+	}
+}
+
+// in parses in:value, which also lets a search into spam and trash
+func (p *queryParser) in(value string, negated bool) (matcher, error) {
+	switch value {
+	case "anywhere":
+		if !negated {
+			p.query.spamTrash = true
+		}
+		return func(*message, *Server) bool { return true }, nil
+	case "chats":
+		return hasLabelID("CHAT"), nil
+	case "drafts":
+		return hasLabelID("DRAFT"), nil
+	case "spam", "trash":
+		if !negated {
+			p.query.spamTrash = true
+		}
+		return hasLabelID(strings.ToUpper(value)), nil
+	default:
+		return hasLabelNamed(value), nil
+	}
+}
+
+// hasLabelID matches the messages with the label with id
+func hasLabelID(id string) matcher {
+	return func(m *message, _ *Server) bool { return m.hasLabel(id) }
+}
+
+// hasLabelNamed matches the messages with a label whose ID or search name
+// (see labels.SearchName) is name, ignoring case
+func hasLabelNamed(name string) matcher {
+	return func(m *message, s *Server) bool {
+		for _, id := range m.labels {
+			label := s.label(id)
+			if strings.EqualFold(id, name) || (label != nil && strings.EqualFold(labels.SearchName(label.Name), name)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// contains matches the messages with a field containing value, ignoring case
+func contains(value string, fields func(m *message) []string) matcher {
+	value = strings.ToLower(value)
+	return func(m *message, _ *Server) bool {
+		for _, field := range fields(m) {
+			if strings.Contains(strings.ToLower(field), value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func allOf(terms []matcher) matcher {
+	return func(m *message, s *Server) bool {
+		for _, term := range terms {
+			if !term(m, s) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func anyOf(terms []matcher) matcher {
+	return func(m *message, s *Server) bool {
+		for _, term := range terms {
+			if term(m, s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func not(term matcher) matcher {
+	return func(m *message, s *Server) bool { return !term(m, s) }
+}
+
+// unquote strips the quotes of a phrase
+func unquote(value string) string {
+	return strings.TrimSpace(strings.Trim(value, `"`))
+}
+
+// parseQueryDate parses the date of after: and before:, a day (read in UTC)
+// or epoch seconds
+func parseQueryDate(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range []string{"2006/1/2", "2006-1-2"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date")
+}
+
+// relativeDate returns the date a newer_than: or older_than: value, such as
+// 7d, 2m or 1y, goes back to from now
+func relativeDate(value string, now time.Time) (time.Time, error) {
+	if len(value) < 2 {
+		return time.Time{}, fmt.Errorf("invalid period")
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid period")
+	}
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "d":
+		return now.AddDate(0, 0, -n), nil
+	case "m":
+		return now.AddDate(0, -n, 0), nil
+	case "y":
+		return now.AddDate(-n, 0, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid period")
+	}
+}
+
+// parseQuerySize parses the size of size:, larger: and smaller:, in bytes or
+// with a K or M suffix
+func parseQuerySize(value string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(value), "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier, number = 1024, strings.TrimSuffix(number, "K")
+	case strings.HasSuffix(number, "M"):
+		multiplier, number = 1024*1024, strings.TrimSuffix(number, "M")
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size")
+	}
+	return n * multiplier, nil
+}
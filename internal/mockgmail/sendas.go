@@ -0,0 +1,101 @@
+package mockgmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// SendAs returns the send-as aliases of the mailbox, the primary address
+// first
+func (s *Server) SendAs() []*gmail.SendAs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aliases := make([]*gmail.SendAs, len(s.sendAs))
+	for i, alias := range s.sendAs {
+		copied := *alias
+		aliases[i] = &copied
+	}
+	return aliases
+}
+
+// alias returns the send-as alias of address
+func (s *Server) alias(address string) (*gmail.SendAs, error) {
+	for _, alias := range s.sendAs {
+		if strings.EqualFold(alias.SendAsEmail, address) {
+			return alias, nil
+		}
+	}
+	return nil, notFound("send-as alias " + address)
+}
+
+// createAlias adds the send-as alias of the request body. Aliases in the
+// domain of the mailbox are accepted at once; others wait for verification,
+// as Gmail sends a verification email to them.
+func (s *Server) createAlias(r *http.Request) (*gmail.SendAs, error) {
+	alias := &gmail.SendAs{}
+	if err := json.NewDecoder(r.Body).Decode(alias); err != nil {
+		return nil, invalidArgument("invalid send-as alias: %v", err)
+	}
+	if !strings.Contains(alias.SendAsEmail, "@") {
+		return nil, invalidArgument("invalid send-as address %q", alias.SendAsEmail)
+	}
+	if _, err := s.alias(alias.SendAsEmail); err == nil {
+		return nil, &apiError{http.StatusConflict, "alreadyExists", "Send-as alias " + alias.SendAsEmail + " already exists"}
+	}
+	if alias.SmtpMsa != nil && alias.SmtpMsa.Password == "" {
+		return nil, invalidArgument("smtpMsa.password is required")
+	}
+
+	alias.IsPrimary = false
+	alias.VerificationStatus = "pending"
+	if domain(alias.SendAsEmail) == domain(s.account) {
+		alias.VerificationStatus = "accepted"
+	}
+	if alias.SmtpMsa != nil {
+		// Gmail never returns the password
+		smtp := *alias.SmtpMsa
+		smtp.Password = ""
+		alias.SmtpMsa = &smtp
+	}
+	s.sendAs = append(s.sendAs, alias)
+	if alias.IsDefault {
+		s.setDefaultAlias(alias)
+	}
+	return alias, nil
+}
+
+// patchAlias applies the fields of the request body to the send-as alias of
+// address
+func (s *Server) patchAlias(r *http.Request, address string) (*gmail.SendAs, error) {
+	alias, err := s.alias(address)
+	if err != nil {
+		return nil, err
+	}
+	patched := *alias
+	if err := json.NewDecoder(r.Body).Decode(&patched); err != nil {
+		return nil, invalidArgument("invalid send-as alias: %v", err)
+	}
+	// Only the settings of an alias can change, not what it is
+	patched.SendAsEmail, patched.IsPrimary, patched.VerificationStatus = alias.SendAsEmail, alias.IsPrimary, alias.VerificationStatus
+	*alias = patched
+	if alias.IsDefault {
+		s.setDefaultAlias(alias)
+	}
+	return alias, nil
+}
+
+// setDefaultAlias makes alias the only default send-as alias
+func (s *Server) setDefaultAlias(alias *gmail.SendAs) {
+	for _, other := range s.sendAs {
+		other.IsDefault = other == alias
+	}
+}
+
+// domain returns the domain of an email address
+func domain(address string) string {
+	_, domain, _ := strings.Cut(strings.ToLower(address), "@")
+	return domain
+}
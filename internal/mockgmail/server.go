@@ -0,0 +1,583 @@
+// Package mockgmail serves the subset of the Gmail REST API used by
+// gmail-exporter from an in-memory mailbox, so that the whole tool can be run
+// end to end in CI and in offline demos with --api-endpoint.
+//
+// The server answers users.getProfile, labels (list, get, create), messages
+// (list, get, import, insert, modify, trash, untrash, delete), history.list,
+// the vacation settings and the send-as aliases (list, get, create, patch). Requests are not authenticated: any token is
+// accepted, such as TokenJSON. Searches support the operators built by the
+// tool's filters (see query.go) and reject any other operator.
+package mockgmail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultAccount is the email address of a mailbox created without one
+const DefaultAccount = "demo@example.com"
+
+// TokenJSON is an OAuth token that never expires, for pointing the tool at a
+// mock server through GMAIL_EXPORTER_TOKEN_JSON without logging in. It
+// carries its own OAuth client, so no credentials file is needed either.
+const TokenJSON = `{"access_token":"mock-access-token","token_type":"Bearer",` +
+	`"refresh_token":"mock-refresh-token","client_id":"mock-client.apps.googleusercontent.com","client_secret":"mock-secret"}`
+
+// maxPageSize is the largest page of messages and history returned
+const maxPageSize = 500
+
+// systemLabels are the labels every mailbox has
+var systemLabels = []string{
+	"INBOX", "SENT", "DRAFT", "SPAM", "TRASH", "UNREAD", "STARRED", "IMPORTANT", "CHAT",
+	"CATEGORY_PERSONAL", "CATEGORY_SOCIAL", "CATEGORY_PROMOTIONS", "CATEGORY_UPDATES", "CATEGORY_FORUMS",
+}
+
+// Server is an in-memory Gmail mailbox served over the Gmail REST API. It is
+// an http.Handler, for use with httptest.NewServer or http.ListenAndServe.
+type Server struct {
+	mu        sync.Mutex
+	account   string
+	messages  map[string]*message
+	labels    []*gmail.Label
+	history   []*gmail.History
+	historyID uint64
+	nextID    uint64
+	vacation  *gmail.VacationSettings
+	sendAs    []*gmail.SendAs
+}
+
+// Message is a message of the mailbox, as returned by Messages
+type Message struct {
+	ID           string
+	Raw          []byte
+	LabelIDs     []string
+	InternalDate time.Time
+}
+
+// New returns an empty mailbox of account (DefaultAccount when empty)
+func New(account string) *Server {
+	if account == "" {
+		account = DefaultAccount
+	}
+	s := &Server{
+		account:   account,
+		messages:  make(map[string]*message),
+		historyID: 1000,
+		nextID:    0x18e0000000000000,
+		vacation:  &gmail.VacationSettings{},
+	}
+	s.sendAs = []*gmail.SendAs{{SendAsEmail: account, IsPrimary: true, IsDefault: true}}
+	for _, id := range systemLabels {
+		s.labels = append(s.labels, &gmail.Label{Id: id, Name: id, Type: "system"})
+	}
+	return s
+}
+
+// Account returns the email address of the mailbox
+func (s *Server) Account() string {
+	return s.account
+}
+
+// CreateLabel creates a user label, or returns the ID of the label with
+// that name
+func (s *Server) CreateLabel(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if label := s.labelByName(name); label != nil {
+		return label.Id
+	}
+	return s.createLabel(&gmail.Label{Name: name}).Id
+}
+
+// AddMessage adds an RFC 822 message with the given label IDs and returns
+// its ID
+func (s *Server) AddMessage(raw []byte, labelIDs ...string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.addMessage(raw, labelIDs)
+	if err != nil {
+		return "", err
+	}
+	return m.id, nil
+}
+
+// LoadDir adds the .eml files under dir, such as an earlier export, to the
+// inbox and returns how many were added
+func (s *Server) LoadDir(dir string) (int, error) {
+	var added int
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".eml") {
+			return err
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := s.AddMessage(raw, "INBOX"); err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		added++
+		return nil
+	})
+	return added, err
+}
+
+// Messages returns the messages of the mailbox, newest first
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []Message
+	for _, m := range s.sorted() {
+		messages = append(messages, Message{
+			ID:           m.id,
+			Raw:          slices.Clone(m.raw),
+			LabelIDs:     slices.Clone(m.labels),
+			InternalDate: m.date,
+		})
+	}
+	return messages
+}
+
+// Vacation returns the vacation responder settings of the mailbox
+func (s *Server) Vacation() *gmail.VacationSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := *s.vacation
+	return &settings
+}
+
+// apiError is a Gmail API error answered to a request
+type apiError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func notFound(what string) *apiError {
+	return &apiError{http.StatusNotFound, "notFound", what + " not found"}
+}
+
+func invalidArgument(format string, args ...any) *apiError {
+	return &apiError{http.StatusBadRequest, "invalidArgument", fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP answers a Gmail API request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	response, err := s.serve(r)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = invalidArgument("%v", err)
+		}
+		w.WriteHeader(apiErr.code)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+			"code":    apiErr.code,
+			"message": apiErr.message,
+			"errors":  []map[string]string{{"reason": apiErr.reason, "message": apiErr.message}},
+		}})
+		return
+	}
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// serve routes a request, returning the response to encode as JSON or nil
+// for an empty one
+func (s *Server) serve(r *http.Request) (any, error) {
+	path, upload := strings.CutPrefix(r.URL.Path, "/upload")
+	rest, ok := strings.CutPrefix(path, "/gmail/v1/users/")
+	if !ok {
+		return nil, notFound("method " + r.URL.Path)
+	}
+	user, rest, _ := strings.Cut(rest, "/")
+	if user != "me" && !strings.EqualFold(user, s.account) {
+		return nil, &apiError{http.StatusForbidden, "forbidden", "Delegation denied for " + s.account}
+	}
+	query := r.URL.Query()
+	parts := strings.Split(rest, "/")
+
+	switch route := r.Method + " " + parts[0]; {
+	case route == "GET profile":
+		return s.profile(), nil
+	case route == "GET labels" && len(parts) == 1:
+		return &gmail.ListLabelsResponse{Labels: s.labels}, nil
+	case route == "GET labels":
+		return s.getLabel(parts[1])
+	case route == "POST labels":
+		return s.postLabel(r)
+	case route == "GET history":
+		return s.listHistory(query)
+	case route == "GET settings" && rest == "settings/vacation":
+		return s.vacation, nil
+	case route == "PUT settings" && rest == "settings/vacation":
+		settings := &gmail.VacationSettings{}
+		if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+			return nil, invalidArgument("invalid vacation settings: %v", err)
+		}
+		s.vacation = settings
+		return settings, nil
+	case route == "GET settings" && rest == "settings/sendAs":
+		return &gmail.ListSendAsResponse{SendAs: s.sendAs}, nil
+	case route == "GET settings" && len(parts) == 3 && parts[1] == "sendAs":
+		return s.alias(parts[2])
+	case route == "POST settings" && rest == "settings/sendAs":
+		return s.createAlias(r)
+	case (route == "PATCH settings" || route == "PUT settings") && len(parts) == 3 && parts[1] == "sendAs":
+		return s.patchAlias(r, parts[2])
+	case route == "GET messages" && len(parts) == 1:
+		return s.listMessages(query)
+	case route == "GET messages":
+		m, err := s.message(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return m.resource(query.Get("format"), query["metadataHeaders"])
+	case route == "POST messages" && (len(parts) == 1 || parts[1] == "import"):
+		return s.postMessage(r, upload)
+	case route == "POST messages" && len(parts) == 3:
+		return s.changeMessage(r, parts[1], parts[2])
+	case route == "DELETE messages" && len(parts) == 2:
+		m, err := s.message(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		delete(s.messages, m.id)
+		s.record(m, &gmail.History{MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: m.ref()}}})
+		return nil, nil
+	default:
+		return nil, notFound("method " + r.Method + " " + r.URL.Path)
+	}
+}
+
+// profile returns the profile of the mailbox
+func (s *Server) profile() *gmail.Profile {
+	return &gmail.Profile{
+		EmailAddress:  s.account,
+		MessagesTotal: int64(len(s.messages)),
+		ThreadsTotal:  int64(len(s.messages)),
+		HistoryId:     s.historyID,
+	}
+}
+
+// getLabel returns a label with its message counts
+func (s *Server) getLabel(id string) (*gmail.Label, error) {
+	label := s.label(id)
+	if label == nil {
+		return nil, notFound("label " + id)
+	}
+	counted := *label
+	for _, m := range s.messages {
+		if m.hasLabel(id) {
+			counted.MessagesTotal++
+			if m.hasLabel("UNREAD") {
+				counted.MessagesUnread++
+			}
+		}
+	}
+	counted.ThreadsTotal, counted.ThreadsUnread = counted.MessagesTotal, counted.MessagesUnread
+	return &counted, nil
+}
+
+// postLabel creates the label of the request body
+func (s *Server) postLabel(r *http.Request) (*gmail.Label, error) {
+	label := &gmail.Label{}
+	if err := json.NewDecoder(r.Body).Decode(label); err != nil {
+		return nil, invalidArgument("invalid label: %v", err)
+	}
+	if strings.TrimSpace(label.Name) == "" {
+		return nil, invalidArgument("label name is required")
+	}
+	if s.labelByName(label.Name) != nil {
+		return nil, &apiError{http.StatusConflict, "duplicate", "Label name exists or conflicts"}
+	}
+	return s.createLabel(label), nil
+}
+
+// createLabel adds a user label
+func (s *Server) createLabel(label *gmail.Label) *gmail.Label {
+	created := &gmail.Label{
+		Id:                    fmt.Sprintf("Label_%d", len(s.labels)-len(systemLabels)+1),
+		Name:                  label.Name,
+		Type:                  "user",
+		LabelListVisibility:   label.LabelListVisibility,
+		MessageListVisibility: label.MessageListVisibility,
+		Color:                 label.Color,
+	}
+	s.labels = append(s.labels, created)
+	return created
+}
+
+// label returns the label with id, or nil
+func (s *Server) label(id string) *gmail.Label {
+	for _, label := range s.labels {
+		if label.Id == id {
+			return label
+		}
+	}
+	return nil
+}
+
+// labelByName returns the label named name, ignoring case, or nil
+func (s *Server) labelByName(name string) *gmail.Label {
+	for _, label := range s.labels {
+		if strings.EqualFold(label.Name, name) {
+			return label
+		}
+	}
+	return nil
+}
+
+// checkLabels checks that every label ID exists
+func (s *Server) checkLabels(ids []string) error {
+	for _, id := range ids {
+		if s.label(id) == nil {
+			return invalidArgument("Invalid label: %s", id)
+		}
+	}
+	return nil
+}
+
+// message returns the message with id
+func (s *Server) message(id string) (*message, error) {
+	m, ok := s.messages[id]
+	if !ok {
+		return nil, notFound("message " + id)
+	}
+	return m, nil
+}
+
+// sorted returns the messages newest first, as Gmail lists them
+func (s *Server) sorted() []*message {
+	messages := make([]*message, 0, len(s.messages))
+	for _, m := range s.messages {
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].date.Equal(messages[j].date) {
+			return messages[i].date.After(messages[j].date)
+		}
+		return messages[i].id > messages[j].id
+	})
+	return messages
+}
+
+// listMessages returns a page of the messages matching the q and labelIds
+// parameters
+func (s *Server) listMessages(query map[string][]string) (*gmail.ListMessagesResponse, error) {
+	q, err := parseQuery(first(query["q"]))
+	if err != nil {
+		return nil, invalidArgument("Invalid query: %v", err)
+	}
+	includeSpamTrash := first(query["includeSpamTrash"]) == "true" || q.spamTrash
+
+	var matching []*gmail.Message
+	for _, m := range s.sorted() {
+		if !includeSpamTrash && (m.hasLabel("SPAM") || m.hasLabel("TRASH")) {
+			continue
+		}
+		if !m.hasLabels(query["labelIds"]) || !q.match(m, s) {
+			continue
+		}
+		matching = append(matching, &gmail.Message{Id: m.id, ThreadId: m.threadID})
+	}
+
+	offset, end, next, err := page(query, len(matching))
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.ListMessagesResponse{
+		Messages:           matching[offset:end],
+		NextPageToken:      next,
+		ResultSizeEstimate: int64(len(matching)),
+	}, nil
+}
+
+// listHistory returns a page of the changes after the startHistoryId
+// parameter
+func (s *Server) listHistory(query map[string][]string) (*gmail.ListHistoryResponse, error) {
+	start, err := strconv.ParseUint(first(query["startHistoryId"]), 10, 64)
+	if err != nil {
+		return nil, invalidArgument("startHistoryId is required")
+	}
+
+	var records []*gmail.History
+	for _, record := range s.history {
+		if record.Id > start {
+			records = append(records, record)
+		}
+	}
+
+	offset, end, next, err := page(query, len(records))
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.ListHistoryResponse{History: records[offset:end], NextPageToken: next, HistoryId: s.historyID}, nil
+}
+
+// page returns the bounds of the page of n results selected by the
+// maxResults and pageToken parameters, and the token of the next page
+func page(query map[string][]string, n int) (offset, end int, next string, err error) {
+	size := 100
+	if value := first(query["maxResults"]); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return 0, 0, "", invalidArgument("Invalid maxResults: %s", value)
+		}
+	}
+	size = min(size, maxPageSize)
+	if token := first(query["pageToken"]); token != "" {
+		offset, err = strconv.Atoi(token)
+		if err != nil || offset < 0 {
+			return 0, 0, "", invalidArgument("Invalid pageToken: %s", token)
+		}
+	}
+	offset = min(offset, n)
+	end = min(offset+size, n)
+	if end < n {
+		next = strconv.Itoa(end)
+	}
+	return offset, end, next, nil
+}
+
+// postMessage imports or inserts the message of the request: a JSON message
+// with its raw content, or an upload of the raw content with or without
+// JSON metadata
+func (s *Server) postMessage(r *http.Request, upload bool) (*gmail.Message, error) {
+	metadata := &gmail.Message{}
+	var raw []byte
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case !upload:
+		if err := json.NewDecoder(r.Body).Decode(metadata); err != nil {
+			return nil, invalidArgument("invalid message: %v", err)
+		}
+		decoded, err := decodeBase64(metadata.Raw)
+		if err != nil {
+			return nil, invalidArgument("invalid raw message: %v", err)
+		}
+		raw = decoded
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, invalidArgument("invalid upload: %v", err)
+		}
+		if err := json.NewDecoder(part).Decode(metadata); err != nil {
+			return nil, invalidArgument("invalid message metadata: %v", err)
+		}
+		if part, err = reader.NextPart(); err != nil {
+			return nil, invalidArgument("upload has no message: %v", err)
+		}
+		if raw, err = io.ReadAll(part); err != nil {
+			return nil, err
+		}
+	default:
+		var err error
+		if raw, err = io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := s.addMessage(raw, metadata.LabelIds)
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.Message{Id: m.id, ThreadId: m.threadID, LabelIds: m.labels}, nil
+}
+
+// addMessage parses and adds a message
+func (s *Server) addMessage(raw []byte, labelIDs []string) (*message, error) {
+	if err := s.checkLabels(labelIDs); err != nil {
+		return nil, err
+	}
+	m, err := parseMessage(raw)
+	if err != nil {
+		return nil, invalidArgument("Invalid message: %v", err)
+	}
+
+	s.nextID++
+	m.id = fmt.Sprintf("%016x", s.nextID)
+	m.threadID = m.id
+	m.labels = slices.Compact(slices.Sorted(slices.Values(labelIDs)))
+	s.messages[m.id] = m
+	s.record(m, &gmail.History{MessagesAdded: []*gmail.HistoryMessageAdded{{Message: m.ref()}}})
+	return m, nil
+}
+
+// changeMessage answers messages.modify, messages.trash and
+// messages.untrash
+func (s *Server) changeMessage(r *http.Request, id, action string) (*gmail.Message, error) {
+	m, err := s.message(id)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &gmail.ModifyMessageRequest{}
+	switch action {
+	case "modify":
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			return nil, invalidArgument("invalid modify request: %v", err)
+		}
+		if err := s.checkLabels(append(slices.Clone(request.AddLabelIds), request.RemoveLabelIds...)); err != nil {
+			return nil, err
+		}
+	case "trash":
+		request.AddLabelIds = []string{"TRASH"}
+	case "untrash":
+		request.RemoveLabelIds = []string{"TRASH"}
+	default:
+		return nil, notFound("method " + action)
+	}
+
+	added, removed := m.modify(request.AddLabelIds, request.RemoveLabelIds)
+	if len(added) > 0 {
+		s.record(m, &gmail.History{LabelsAdded: []*gmail.HistoryLabelAdded{{Message: m.ref(), LabelIds: added}}})
+	}
+	if len(removed) > 0 {
+		s.record(m, &gmail.History{LabelsRemoved: []*gmail.HistoryLabelRemoved{{Message: m.ref(), LabelIds: removed}}})
+	}
+	return &gmail.Message{Id: m.id, ThreadId: m.threadID, LabelIds: m.labels}, nil
+}
+
+// record adds a change of m to the history of the mailbox
+func (s *Server) record(m *message, change *gmail.History) {
+	s.historyID++
+	change.Id = s.historyID
+	change.Messages = []*gmail.Message{m.ref()}
+	m.historyID = s.historyID
+	s.history = append(s.history, change)
+}
+
+// first returns the first of values, or ""
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,263 @@
+package mockgmail
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const plainMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: demo@example.com\r\n" +
+	"Subject: Quarterly invoice\r\n" +
+	"Date: Mon, 01 Jan 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <invoice@example.com>\r\n" +
+	"\r\n" +
+	"Please find the invoice attached.\r\n"
+
+const multipartMessage = "From: Bob <bob@example.com>\r\n" +
+	"To: demo@example.com\r\n" +
+	"Subject: Holiday photos\r\n" +
+	"Date: Sat, 01 Jun 2024 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=C3=A9 by the beach\r\n" +
+	"--b1\r\n" +
+	"Content-Type: image/png; name=\"beach.png\"\r\n" +
+	"Content-Disposition: attachment; filename=\"beach.png\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--b1--\r\n"
+
+// newTestService serves a mailbox and returns a Gmail client of it
+func newTestService(t *testing.T, server *Server) *gmail.Service {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	service, err := gmail.NewService(context.Background(),
+		option.WithEndpoint(httpServer.URL+"/"), option.WithHTTPClient(httpServer.Client()))
+	if err != nil {
+		t.Fatalf("failed to create Gmail service: %v", err)
+	}
+	return service
+}
+
+func TestServer_ImportAndGet(t *testing.T) {
+	server := New("")
+	service := newTestService(t, server)
+
+	work, err := service.Users.Labels.Create("me", &gmail.Label{Name: "Clients/Acme Corp"}).Do()
+	if err != nil {
+		t.Fatalf("Labels.Create() error = %v", err)
+	}
+	if _, err := service.Users.Labels.Create("me", &gmail.Label{Name: "clients/acme corp"}).Do(); err == nil {
+		t.Error("Expected a duplicate label name to be rejected")
+	}
+
+	imported, err := service.Users.Messages.Import("me", &gmail.Message{
+		Raw:      base64.URLEncoding.EncodeToString([]byte(multipartMessage)),
+		LabelIds: []string{"INBOX", work.Id},
+	}).Do()
+	if err != nil {
+		t.Fatalf("Messages.Import() error = %v", err)
+	}
+
+	full, err := service.Users.Messages.Get("me", imported.Id).Do()
+	if err != nil {
+		t.Fatalf("Messages.Get() error = %v", err)
+	}
+	if full.Snippet != "Café by the beach" || full.InternalDate != 1717236000000 {
+		t.Errorf("Unexpected message: snippet %q, internal date %d", full.Snippet, full.InternalDate)
+	}
+	if len(full.Payload.Parts) != 2 || full.Payload.Parts[1].Filename != "beach.png" || full.Payload.Parts[1].PartId != "1" {
+		t.Fatalf("Unexpected parts %+v", full.Payload.Parts)
+	}
+
+	raw, err := service.Users.Messages.Get("me", imported.Id).Format("raw").Do()
+	if err != nil {
+		t.Fatalf("Messages.Get(raw) error = %v", err)
+	}
+	if data, _ := base64.URLEncoding.DecodeString(raw.Raw); string(data) != multipartMessage {
+		t.Errorf("Expected the raw message back, got %q", data)
+	}
+
+	metadata, err := service.Users.Messages.Get("me", imported.Id).Format("metadata").MetadataHeaders("Subject").Do()
+	if err != nil {
+		t.Fatalf("Messages.Get(metadata) error = %v", err)
+	}
+	if headers := metadata.Payload.Headers; len(headers) != 1 || headers[0].Value != "Holiday photos" {
+		t.Errorf("Expected only the Subject header, got %+v", headers)
+	}
+
+	label, err := service.Users.Labels.Get("me", work.Id).Do()
+	if err != nil || label.MessagesTotal != 1 {
+		t.Errorf("Expected one message in %s, got %+v (error %v)", work.Name, label, err)
+	}
+
+	_, err = service.Users.Messages.Get("me", "missing").Do()
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+func TestServer_ListMessages(t *testing.T) {
+	server := New("")
+	receipts := server.CreateLabel("Receipts")
+	invoice, _ := server.AddMessage([]byte(plainMessage), "INBOX", receipts)
+	photos, _ := server.AddMessage([]byte(multipartMessage), "INBOX", "UNREAD")
+	spam, _ := server.AddMessage([]byte(strings.Replace(plainMessage, "Quarterly", "Urgent", 1)), "SPAM")
+	service := newTestService(t, server)
+
+	tests := []struct {
+		query            string
+		includeSpamTrash bool
+		want             []string
+	}{
+		{"", false, []string{photos, invoice}},
+		{"", true, []string{photos, spam, invoice}},
+		{"invoice", false, []string{invoice}},
+		{"in:spam", false, []string{spam}},
+		{"from:bob@example.com", false, []string{photos}},
+		{"subject:(quarterly invoice)", false, []string{invoice}},
+		{"{from:alice from:bob}", false, []string{photos, invoice}},
+		{"label:receipts -is:unread", false, []string{invoice}},
+		{"has:attachment filename:png", false, []string{photos}},
+		{"after:2024/03/01", false, []string{photos}},
+		{"before:2024/03/01 -in:chats", false, []string{invoice}},
+		{"rfc822msgid:<invoice@example.com>", false, []string{invoice}},
+		{"size:100000", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, err := service.Users.Messages.List("me").Q(tt.query).IncludeSpamTrash(tt.includeSpamTrash).Do()
+			if err != nil {
+				t.Fatalf("Messages.List() error = %v", err)
+			}
+			var got []string
+			for _, m := range resp.Messages {
+				got = append(got, m.Id)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Messages.List(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	// Pages follow the newest first order
+	first, err := service.Users.Messages.List("me").MaxResults(1).Do()
+	if err != nil || len(first.Messages) != 1 || first.Messages[0].Id != photos || first.NextPageToken == "" {
+		t.Fatalf("Unexpected first page %+v (error %v)", first, err)
+	}
+	second, err := service.Users.Messages.List("me").MaxResults(1).PageToken(first.NextPageToken).Do()
+	if err != nil || len(second.Messages) != 1 || second.Messages[0].Id != invoice || second.NextPageToken != "" {
+		t.Errorf("Unexpected second page %+v (error %v)", second, err)
+	}
+
+	if _, err := service.Users.Messages.List("me").Q("list:dev.example.com").Do(); err == nil {
+		t.Error("Expected an unsupported operator to be rejected")
+	}
+}
+
+func TestServer_History(t *testing.T) {
+	server := New("")
+	service := newTestService(t, server)
+	id, _ := server.AddMessage([]byte(plainMessage), "INBOX", "UNREAD")
+
+	profile, err := service.Users.GetProfile("me").Do()
+	if err != nil || profile.EmailAddress != DefaultAccount || profile.MessagesTotal != 1 {
+		t.Fatalf("Unexpected profile %+v (error %v)", profile, err)
+	}
+
+	if _, err := service.Users.Messages.Modify("me", id, &gmail.ModifyMessageRequest{RemoveLabelIds: []string{"UNREAD"}}).Do(); err != nil {
+		t.Fatalf("Messages.Modify() error = %v", err)
+	}
+	if err := service.Users.Messages.Delete("me", id).Do(); err != nil {
+		t.Fatalf("Messages.Delete() error = %v", err)
+	}
+
+	history, err := service.Users.History.List("me").StartHistoryId(profile.HistoryId).Do()
+	if err != nil {
+		t.Fatalf("History.List() error = %v", err)
+	}
+	if len(history.History) != 2 || len(history.History[0].LabelsRemoved) != 1 || len(history.History[1].MessagesDeleted) != 1 {
+		t.Errorf("Unexpected history %+v", history.History)
+	}
+	if history.HistoryId != profile.HistoryId+2 {
+		t.Errorf("HistoryId = %d, want %d", history.HistoryId, profile.HistoryId+2)
+	}
+	if len(server.Messages()) != 0 {
+		t.Error("Expected the message to be deleted")
+	}
+}
+
+func TestServer_Vacation(t *testing.T) {
+	server := New("jane@example.com")
+	service := newTestService(t, server)
+
+	settings := &gmail.VacationSettings{EnableAutoReply: true, ResponseSubject: "Away"}
+	if _, err := service.Users.Settings.UpdateVacation("jane@example.com", settings).Do(); err != nil {
+		t.Fatalf("UpdateVacation() error = %v", err)
+	}
+	if got := server.Vacation(); !got.EnableAutoReply || got.ResponseSubject != "Away" {
+		t.Errorf("Vacation() = %+v", got)
+	}
+
+	if _, err := service.Users.GetProfile("someone@example.com").Do(); err == nil {
+		t.Error("Expected another user's mailbox to be refused")
+	}
+}
+
+func TestServer_SendAs(t *testing.T) {
+	server := New("jane@example.com")
+	service := newTestService(t, server)
+	sendAs := service.Users.Settings.SendAs
+
+	if _, err := sendAs.Patch("me", "jane@example.com", &gmail.SendAs{Signature: "<b>Jane</b>"}).Do(); err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	created, err := sendAs.Create("me", &gmail.SendAs{SendAsEmail: "jane@other.org", DisplayName: "Jane", IsDefault: true}).Do()
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if created.VerificationStatus != "pending" {
+		t.Errorf("VerificationStatus = %q, want pending for another domain", created.VerificationStatus)
+	}
+	if _, err := sendAs.Create("me", &gmail.SendAs{SendAsEmail: "JANE@other.org"}).Do(); !isStatus(err, http.StatusConflict) {
+		t.Errorf("Expected a second alias of the address to conflict, got %v", err)
+	}
+
+	list, err := sendAs.List("me").Do()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.SendAs) != 2 || list.SendAs[0].Signature != "<b>Jane</b>" || !list.SendAs[0].IsPrimary {
+		t.Fatalf("List() = %+v", list.SendAs)
+	}
+	if list.SendAs[0].IsDefault || !list.SendAs[1].IsDefault {
+		t.Error("Expected the created alias to become the only default")
+	}
+	if _, err := sendAs.Get("me", "nobody@example.com").Do(); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected an unknown alias not to be found, got %v", err)
+	}
+}
+
+// isStatus reports whether err is a Gmail API error with the HTTP status
+func isStatus(err error, status int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == status
+}
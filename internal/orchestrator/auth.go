@@ -0,0 +1,129 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationKey is the metadata key of the bearer token of a call
+const authorizationKey = "authorization"
+
+// bearerPrefix precedes the token in the authorization metadata
+const bearerPrefix = "Bearer "
+
+// TokenAuth returns server options that refuse calls without the bearer
+// token
+func TokenAuth(token string) []grpc.ServerOption {
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get(authorizationKey) {
+			given, ok := strings.CutPrefix(value, bearerPrefix)
+			if ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, request any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, request)
+		}),
+		grpc.ChainStreamInterceptor(func(server any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(stream.Context()); err != nil {
+				return err
+			}
+			return handler(server, stream)
+		}),
+	}
+}
+
+// tokenCredentials sends a bearer token with every call
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+// TokenCredentials returns call credentials sending token. Unless secure,
+// the token is also sent over connections without TLS, which only
+// loopback servers accept.
+func TokenCredentials(token string, secure bool) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token, secure: secure}
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{authorizationKey: bearerPrefix + c.token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// ServerTLS returns the transport credentials of a server with the
+// certificate of certFile and keyFile. With clientCAFile, clients must
+// present a certificate issued by one of its CAs.
+func ServerTLS(certFile, keyFile, clientCAFile string) (credentials.TransportCredentials, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		if config.ClientCAs, err = loadCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// ClientTLS returns the transport credentials of a client verifying the
+// server with the CAs of caFile (empty = the system CAs), presenting the
+// certificate of certFile and keyFile when given
+func ClientTLS(caFile, certFile, keyFile string) (credentials.TransportCredentials, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// loadCertPool returns a pool of the PEM certificates of path
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
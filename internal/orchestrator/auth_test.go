@@ -0,0 +1,64 @@
+package orchestrator
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+)
+
+func TestTokenAuth(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(TokenAuth("s3cret")...)
+	jobsv1.RegisterJobServiceServer(grpcServer, New(blockingRunner(make(chan struct{})), nil, Defaults{}, 1))
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"no token", "", codes.Unauthenticated},
+		{"wrong token", "guess", codes.Unauthenticated},
+		{"token", "s3cret", codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := []grpc.DialOption{
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			}
+			if tt.token != "" {
+				options = append(options, grpc.WithPerRPCCredentials(TokenCredentials(tt.token, false)))
+			}
+			conn, err := grpc.Dial("bufnet", options...)
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+			defer conn.Close()
+			client := jobsv1.NewJobServiceClient(conn)
+
+			_, err = client.GetJobStatus(context.Background(), &jobsv1.GetJobStatusRequest{JobId: "missing"})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("GetJobStatus() code = %v, want %v", got, tt.want)
+			}
+
+			stream, err := client.StreamProgress(context.Background(), &jobsv1.StreamProgressRequest{JobId: "missing"})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("StreamProgress() code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,596 @@
+// Package orchestrator serves the gRPC JobService, running exports as jobs
+// that orchestration systems start, watch and cancel
+package orchestrator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+// watchBuffer is the number of progress events buffered for each stream.
+// Events are dropped for streams that fall further behind; each event
+// carries the full job status, so later events catch them up.
+const watchBuffer = 64
+
+// persistInterval is how often the progress of a running job is saved to
+// the jobs database; state changes are saved at once
+const persistInterval = 10 * time.Second
+
+// Runner runs the export of a job, stopping early once ctx is cancelled
+type Runner func(ctx context.Context, request *jobsv1.StartExportRequest, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error)
+
+// Defaults are applied to requests that leave a setting empty
+type Defaults struct {
+	SearchScope string
+}
+
+// Server runs exports as jobs, at most a fixed number at a time. Jobs are
+// saved to the store, if any, so a restarted server can report them and
+// requeue those it had not finished.
+type Server struct {
+	jobsv1.UnimplementedJobServiceServer
+
+	run      Runner
+	store    *Store
+	defaults Defaults
+	slots    chan struct{}
+	now      func() time.Time
+
+	// saveMu orders the writes to the store, so an older status never
+	// replaces a newer one
+	saveMu sync.Mutex
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	running  sync.WaitGroup
+	stopping bool
+}
+
+// job is an export started through the server
+type job struct {
+	status   *jobsv1.Job
+	request  *jobsv1.StartExportRequest
+	cancel   context.CancelFunc
+	watchers map[chan *jobsv1.ProgressEvent]struct{}
+	saved    time.Time
+	// cancelled is set when CancelJob stopped the job, rather than Shutdown
+	cancelled bool
+}
+
+// New creates a server running at most maxJobs exports at a time, saving
+// its jobs to store (nil = keep them in memory only)
+func New(run Runner, store *Store, defaults Defaults, maxJobs int) *Server {
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+	return &Server{
+		run:      run,
+		store:    store,
+		defaults: defaults,
+		slots:    make(chan struct{}, maxJobs),
+		now:      time.Now,
+		jobs:     make(map[string]*job),
+	}
+}
+
+// Filters returns the filter configuration of a request
+func Filters(request *jobsv1.StartExportRequest, defaults Defaults) (*filters.Config, error) {
+	f := request.GetFilters()
+	config := &filters.Config{
+		From:          f.GetFrom(),
+		To:            f.GetTo(),
+		Subject:       f.GetSubject(),
+		IncludesWords: f.GetIncludesWords(),
+		ExcludesWords: f.GetExcludesWords(),
+		Labels:        f.GetLabels(),
+		SearchScope:   f.GetSearchScope(),
+		ExcludeChats:  !f.GetIncludeChats(),
+	}
+	if config.SearchScope == "" {
+		config.SearchScope = defaults.SearchScope
+	}
+
+	if f.GetDateAfter() != "" {
+		date, err := time.Parse("2006-01-02", f.GetDateAfter())
+		if err != nil {
+			return nil, fmt.Errorf("invalid date after (use YYYY-MM-DD): %w", err)
+		}
+		config.DateAfter = &date
+	}
+	if f.GetDateBefore() != "" {
+		date, err := time.Parse("2006-01-02", f.GetDateBefore())
+		if err != nil {
+			return nil, fmt.Errorf("invalid date before (use YYYY-MM-DD): %w", err)
+		}
+		config.DateBefore = &date
+	}
+	if f.GetHasAttachment() {
+		hasAttachment := true
+		config.HasAttachment = &hasAttachment
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// StartExport implements jobsv1.JobServiceServer
+func (s *Server) StartExport(ctx context.Context, request *jobsv1.StartExportRequest) (*jobsv1.Job, error) {
+	if request.GetOutputDir() == "" {
+		return nil, status.Error(codes.InvalidArgument, "output directory is required")
+	}
+	if request.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be >= 0")
+	}
+	filterConfig, err := Filters(request, s.defaults)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	j, jobCtx := newJob(&jobsv1.Job{
+		JobId:     id,
+		Account:   request.GetAccount(),
+		OutputDir: request.GetOutputDir(),
+		Query:     filterConfig.BuildGmailQuery(),
+		State:     jobsv1.JobState_JOB_STATE_QUEUED,
+		Progress:  &jobsv1.Progress{},
+		CreatedAt: timestamppb.New(s.now()),
+	}, request)
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		j.cancel()
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	// Two exports into one directory would overwrite each other's state
+	for _, other := range s.jobs {
+		if isActive(other.status.State) && sameDir(other.status.OutputDir, request.GetOutputDir()) {
+			s.mu.Unlock()
+			j.cancel()
+			return nil, status.Errorf(codes.FailedPrecondition, "job %s is already exporting into %s", other.status.JobId, request.GetOutputDir())
+		}
+	}
+	s.jobs[id] = j
+	s.running.Add(1)
+	snapshot := proto.Clone(j.status).(*jobsv1.Job)
+	s.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"job":     id,
+		"account": request.GetAccount(),
+		"query":   snapshot.Query,
+	}).Info("Queued export job")
+
+	s.save(j)
+	go s.export(jobCtx, j, filterConfig)
+
+	return snapshot, nil
+}
+
+// newJob returns a job of status and request. An active job gets the
+// context its export runs in, cancelled by CancelJob and Shutdown, and its
+// set of progress streams before it is added to s.jobs, where other calls
+// can find it.
+func newJob(status *jobsv1.Job, request *jobsv1.StartExportRequest) (*job, context.Context) {
+	j := &job{status: status, request: request}
+	if !isActive(status.State) {
+		return j, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.watchers = make(map[chan *jobsv1.ProgressEvent]struct{})
+	return j, ctx
+}
+
+// Restore loads the jobs of the store. Jobs that were queued or running
+// when the server stopped are queued again, an interrupted export resuming
+// where it stopped.
+func (s *Server) Restore() error {
+	statuses, requests, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+
+	for i, status := range statuses {
+		request := requests[i]
+		if status.Progress == nil {
+			status.Progress = &jobsv1.Progress{}
+		}
+
+		// Requeue the job before other calls can find it
+		active := isActive(status.State)
+		var filterConfig *filters.Config
+		var filterErr error
+		if active {
+			if status.State == jobsv1.JobState_JOB_STATE_RUNNING {
+				request.Resume = true
+			}
+			status.State = jobsv1.JobState_JOB_STATE_QUEUED
+			status.Restarts++
+			filterConfig, filterErr = Filters(request, s.defaults)
+		}
+		j, jobCtx := newJob(status, request)
+
+		s.mu.Lock()
+		s.jobs[status.JobId] = j
+		if active && filterErr == nil {
+			s.running.Add(1)
+		}
+		s.mu.Unlock()
+
+		switch {
+		case !active:
+			continue
+		case filterErr != nil:
+			j.cancel()
+			s.finish(j, nil, fmt.Errorf("invalid filters: %w", filterErr), false)
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"job":     status.JobId,
+			"account": status.Account,
+		}).Info("Requeued export job interrupted by a restart")
+		s.save(j)
+		go s.export(jobCtx, j, filterConfig)
+	}
+
+	return nil
+}
+
+// GetJobStatus implements jobsv1.JobServiceServer
+func (s *Server) GetJobStatus(ctx context.Context, request *jobsv1.GetJobStatusRequest) (*jobsv1.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[request.GetJobId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", request.GetJobId())
+	}
+	return proto.Clone(j.status).(*jobsv1.Job), nil
+}
+
+// ListJobs implements jobsv1.JobServiceServer
+func (s *Server) ListJobs(ctx context.Context, request *jobsv1.ListJobsRequest) (*jobsv1.ListJobsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := &jobsv1.ListJobsResponse{}
+	for _, j := range s.jobs {
+		if request.GetState() != jobsv1.JobState_JOB_STATE_UNSPECIFIED && j.status.State != request.GetState() {
+			continue
+		}
+		if request.GetAccount() != "" && j.status.Account != request.GetAccount() {
+			continue
+		}
+		response.Jobs = append(response.Jobs, proto.Clone(j.status).(*jobsv1.Job))
+	}
+
+	sort.SliceStable(response.Jobs, func(a, b int) bool {
+		return response.Jobs[a].CreatedAt.AsTime().Before(response.Jobs[b].CreatedAt.AsTime())
+	})
+	return response, nil
+}
+
+// CancelJob implements jobsv1.JobServiceServer. A queued job is cancelled
+// at once; a running job once the messages in flight are exported.
+func (s *Server) CancelJob(ctx context.Context, request *jobsv1.CancelJobRequest) (*jobsv1.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[request.GetJobId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", request.GetJobId())
+	}
+	if !isActive(j.status.State) {
+		return nil, status.Errorf(codes.FailedPrecondition, "job %s has already ended", request.GetJobId())
+	}
+
+	logrus.WithField("job", request.GetJobId()).Info("Cancelling export job")
+	j.cancelled = true
+	j.cancel()
+	return proto.Clone(j.status).(*jobsv1.Job), nil
+}
+
+// StreamProgress implements jobsv1.JobServiceServer
+func (s *Server) StreamProgress(request *jobsv1.StreamProgressRequest, stream jobsv1.JobService_StreamProgressServer) error {
+	s.mu.Lock()
+	j, ok := s.jobs[request.GetJobId()]
+	if !ok {
+		s.mu.Unlock()
+		return status.Errorf(codes.NotFound, "job %s not found", request.GetJobId())
+	}
+	current := &jobsv1.ProgressEvent{Job: proto.Clone(j.status).(*jobsv1.Job)}
+	var events chan *jobsv1.ProgressEvent
+	// A job interrupted by Shutdown stays active with its streams ended
+	if isActive(j.status.State) && j.watchers != nil {
+		events = make(chan *jobsv1.ProgressEvent, watchBuffer)
+		j.watchers[events] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	if err := stream.Send(current); err != nil || events == nil {
+		s.unwatch(j, events)
+		return err
+	}
+
+	last := current
+	for {
+		select {
+		case <-stream.Context().Done():
+			s.unwatch(j, events)
+			return stream.Context().Err()
+		case event, ok := <-events:
+			if !ok {
+				// The job ended; make sure its final status was sent
+				if isActive(last.Job.State) {
+					return stream.Send(&jobsv1.ProgressEvent{Job: s.snapshot(j)})
+				}
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				s.unwatch(j, events)
+				return err
+			}
+			last = event
+		}
+	}
+}
+
+// Shutdown stops the queued and running jobs and waits for them to end.
+// They stay queued or running in the store, so Restore requeues them.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	s.stopping = true
+	for _, j := range s.jobs {
+		if j.cancel != nil {
+			j.cancel()
+		}
+	}
+	s.mu.Unlock()
+
+	s.running.Wait()
+}
+
+// unwatch stops sending events of j to a stream
+func (s *Server) unwatch(j *job, events chan *jobsv1.ProgressEvent) {
+	if events == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(j.watchers, events)
+}
+
+// snapshot returns a copy of the status of j
+func (s *Server) snapshot(j *job) *jobsv1.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return proto.Clone(j.status).(*jobsv1.Job)
+}
+
+// export waits for a free slot, runs the export of j and records its outcome
+func (s *Server) export(ctx context.Context, j *job, filterConfig *filters.Config) {
+	defer s.running.Done()
+	defer j.cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		s.finish(j, nil, nil, true)
+		return
+	}
+
+	s.update(j, &jobsv1.ProgressEvent{}, func(status *jobsv1.Job) {
+		status.State = jobsv1.JobState_JOB_STATE_RUNNING
+		status.StartedAt = timestamppb.New(s.now())
+	})
+	s.save(j)
+	logrus.WithField("job", j.status.GetJobId()).Info("Starting export job")
+
+	result, err := s.run(ctx, j.request, filterConfig, &jobEvents{server: s, job: j})
+	s.finish(j, result, err, ctx.Err() != nil)
+}
+
+// finish records the outcome of j and ends its progress streams
+func (s *Server) finish(j *job, result *exporter.Result, err error, cancelled bool) {
+	defer s.save(j)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := j.status
+	interrupted := (cancelled || (result != nil && result.Cancelled)) && !j.cancelled && s.stopping
+	if !interrupted {
+		status.EndedAt = timestamppb.New(s.now())
+	}
+	switch {
+	case interrupted:
+		// Left queued or running for the next server to pick up
+	case cancelled || (result != nil && result.Cancelled):
+		status.State = jobsv1.JobState_JOB_STATE_CANCELLED
+	case err != nil:
+		status.State = jobsv1.JobState_JOB_STATE_FAILED
+	default:
+		status.State = jobsv1.JobState_JOB_STATE_SUCCEEDED
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if result != nil {
+		status.Progress.Matched = int32(result.TotalMatched)
+		status.Progress.Exported = int32(result.TotalExported)
+		status.Progress.Failed = int32(result.TotalFailed)
+		status.Progress.Skipped = int32(result.TotalSkipped)
+		status.Progress.Processed = int32(result.TotalExported + result.TotalFailed + result.TotalSkipped)
+		status.Progress.Bytes = result.TotalSize
+		status.FailedByCategory = make(map[string]int32, len(result.FailedByCategory))
+		for category, count := range result.FailedByCategory {
+			status.FailedByCategory[category] = int32(count)
+		}
+	}
+
+	if interrupted {
+		logrus.WithField("job", status.JobId).Info("Export job interrupted by shutdown")
+	} else {
+		logrus.WithFields(logrus.Fields{
+			"job":   status.JobId,
+			"state": status.State.String(),
+		}).Info("Export job ended")
+	}
+
+	s.publish(j, &jobsv1.ProgressEvent{})
+	for events := range j.watchers {
+		close(events)
+	}
+	j.watchers = nil
+}
+
+// save writes the status of j to the store
+func (s *Server) save(j *job) {
+	if s.store == nil {
+		return
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	status := proto.Clone(j.status).(*jobsv1.Job)
+	j.saved = s.now()
+	s.mu.Unlock()
+
+	if err := s.store.Put(status, j.request); err != nil {
+		logrus.WithError(err).WithField("job", status.JobId).Warn("Failed to save job")
+	}
+}
+
+// saveProgress saves the status of a running job if it was last saved more
+// than persistInterval ago
+func (s *Server) saveProgress(j *job) {
+	s.mu.Lock()
+	due := s.now().Sub(j.saved) >= persistInterval
+	s.mu.Unlock()
+
+	if due {
+		s.save(j)
+	}
+}
+
+// update changes the status of j and publishes event with the new status
+func (s *Server) update(j *job, event *jobsv1.ProgressEvent, change func(*jobsv1.Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change(j.status)
+	s.publish(j, event)
+}
+
+// publish sends event with the current status of j to its streams, dropping
+// it for streams whose buffer is full. The caller holds s.mu.
+func (s *Server) publish(j *job, event *jobsv1.ProgressEvent) {
+	event.Job = proto.Clone(j.status).(*jobsv1.Job)
+	for events := range j.watchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// jobEvents records the export events of a job
+type jobEvents struct {
+	server *Server
+	job    *job
+}
+
+// OnMessageExported implements exporter.Events
+func (e *jobEvents) OnMessageExported(event exporter.MessageEvent) {
+	e.server.update(e.job, &jobsv1.ProgressEvent{MessageId: event.MessageID, File: event.File}, func(status *jobsv1.Job) {
+		status.Progress = toProgress(event.Progress)
+	})
+	e.server.saveProgress(e.job)
+}
+
+// OnError implements exporter.Events
+func (e *jobEvents) OnError(event exporter.ErrorEvent) {
+	progressEvent := &jobsv1.ProgressEvent{MessageId: event.MessageID, Category: event.Category}
+	if event.Err != nil {
+		progressEvent.Error = event.Err.Error()
+	}
+	e.server.update(e.job, progressEvent, func(status *jobsv1.Job) {
+		status.Progress = toProgress(event.Progress)
+		if status.FailedByCategory == nil {
+			status.FailedByCategory = make(map[string]int32)
+		}
+		status.FailedByCategory[event.Category]++
+	})
+	e.server.saveProgress(e.job)
+}
+
+// OnStateSaved implements exporter.Events
+func (e *jobEvents) OnStateSaved(exporter.StateEvent) {}
+
+// toProgress converts exporter progress to its protobuf message
+func toProgress(progress metrics.Progress) *jobsv1.Progress {
+	return &jobsv1.Progress{
+		Matched:   int32(progress.Matched),
+		Exported:  int32(progress.Exported),
+		Failed:    int32(progress.Failed),
+		Skipped:   int32(progress.Skipped),
+		Processed: int32(progress.Processed),
+		Bytes:     progress.Bytes,
+	}
+}
+
+// isActive reports whether a job in state has not ended yet
+func isActive(state jobsv1.JobState) bool {
+	return state == jobsv1.JobState_JOB_STATE_QUEUED || state == jobsv1.JobState_JOB_STATE_RUNNING
+}
+
+// sameDir reports whether two output directories are the same
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,331 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+)
+
+// blockingRunner exports one message, fails another and then runs until
+// released or cancelled
+func blockingRunner(release chan struct{}) Runner {
+	return func(ctx context.Context, request *jobsv1.StartExportRequest, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error) {
+		events.OnMessageExported(exporter.MessageEvent{
+			MessageID: "m1",
+			File:      "m1.eml",
+			Progress:  metrics.Progress{Matched: 2, Exported: 1, Processed: 1},
+		})
+		events.OnError(exporter.ErrorEvent{
+			MessageID: "m2",
+			Category:  "not_found",
+			Err:       errors.New("gone"),
+			Progress:  metrics.Progress{Matched: 2, Exported: 1, Failed: 1, Processed: 2},
+		})
+
+		result := &exporter.Result{
+			TotalMatched:     2,
+			TotalExported:    1,
+			TotalFailed:      1,
+			FailedByCategory: map[string]int{"not_found": 1},
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			result.Cancelled = true
+		}
+		return result, nil
+	}
+}
+
+// newTestClient serves server over an in-memory connection
+func newTestClient(t *testing.T, server *Server) jobsv1.JobServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	jobsv1.RegisterJobServiceServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return jobsv1.NewJobServiceClient(conn)
+}
+
+// waitForState polls a job until it reaches state
+func waitForState(t *testing.T, client jobsv1.JobServiceClient, id string, state jobsv1.JobState) *jobsv1.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := client.GetJobStatus(context.Background(), &jobsv1.GetJobStatusRequest{JobId: id})
+		if err != nil {
+			t.Fatalf("GetJobStatus failed: %v", err)
+		}
+		if job.State == state {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not reach %s", id, state)
+	return nil
+}
+
+func TestServer_StreamProgress(t *testing.T) {
+	release := make(chan struct{})
+	client := newTestClient(t, New(blockingRunner(release), nil, Defaults{}, 1))
+	ctx := context.Background()
+
+	job, err := client.StartExport(ctx, &jobsv1.StartExportRequest{
+		Account:   "work",
+		OutputDir: t.TempDir(),
+		Filters:   &jobsv1.ExportFilters{From: "a@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	if job.Query != "from:a@example.com -in:chats" {
+		t.Errorf("Expected the Gmail query of the filters, got %q", job.Query)
+	}
+
+	waitForState(t, client, job.JobId, jobsv1.JobState_JOB_STATE_RUNNING)
+	stream, err := client.StreamProgress(ctx, &jobsv1.StreamProgressRequest{JobId: job.JobId})
+	if err != nil {
+		t.Fatalf("StreamProgress failed: %v", err)
+	}
+	close(release)
+
+	var last *jobsv1.ProgressEvent
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		last = event
+	}
+
+	if last == nil || last.Job.State != jobsv1.JobState_JOB_STATE_SUCCEEDED {
+		t.Fatalf("Expected the stream to end with the succeeded job, got %v", last)
+	}
+	if last.Job.Progress.Exported != 1 || last.Job.FailedByCategory["not_found"] != 1 {
+		t.Errorf("Expected the final progress of the export, got %v", last.Job)
+	}
+}
+
+func TestServer_CancelJob(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	client := newTestClient(t, New(blockingRunner(release), nil, Defaults{}, 1))
+	ctx := context.Background()
+
+	running, err := client.StartExport(ctx, &jobsv1.StartExportRequest{OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	waitForState(t, client, running.JobId, jobsv1.JobState_JOB_STATE_RUNNING)
+
+	// A second job waits for the only slot
+	queued, err := client.StartExport(ctx, &jobsv1.StartExportRequest{OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	if queued.State != jobsv1.JobState_JOB_STATE_QUEUED {
+		t.Errorf("Expected the second job to be queued, got %s", queued.State)
+	}
+
+	for _, id := range []string{queued.JobId, running.JobId} {
+		if _, err := client.CancelJob(ctx, &jobsv1.CancelJobRequest{JobId: id}); err != nil {
+			t.Fatalf("CancelJob failed: %v", err)
+		}
+		waitForState(t, client, id, jobsv1.JobState_JOB_STATE_CANCELLED)
+	}
+
+	_, err = client.CancelJob(ctx, &jobsv1.CancelJobRequest{JobId: running.JobId})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected cancelling an ended job to fail, got %v", err)
+	}
+}
+
+func TestServer_CancelJobWhileSaving(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), DefaultStoreFileName))
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+	defer store.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	server := New(blockingRunner(release), store, Defaults{}, 1)
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	// Hold the first save of the job, so it is listed before it is running
+	server.saveMu.Lock()
+	started := make(chan error, 1)
+	go func() {
+		_, err := client.StartExport(ctx, &jobsv1.StartExportRequest{OutputDir: t.TempDir()})
+		started <- err
+	}()
+
+	var id string
+	for deadline := time.Now().Add(5 * time.Second); id == "" && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		list, err := client.ListJobs(ctx, &jobsv1.ListJobsRequest{})
+		if err != nil {
+			t.Fatalf("ListJobs failed: %v", err)
+		}
+		if len(list.Jobs) > 0 {
+			id = list.Jobs[0].JobId
+		}
+	}
+	if id == "" {
+		server.saveMu.Unlock()
+		t.Fatal("Expected the job to be listed")
+	}
+
+	stream, err := client.StreamProgress(ctx, &jobsv1.StreamProgressRequest{JobId: id})
+	if err != nil {
+		t.Fatalf("StreamProgress failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Expected the current status of the listed job, got %v", err)
+	}
+	if _, err := client.CancelJob(ctx, &jobsv1.CancelJobRequest{JobId: id}); err != nil {
+		t.Fatalf("CancelJob failed: %v", err)
+	}
+	server.saveMu.Unlock()
+
+	if err := <-started; err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	waitForState(t, client, id, jobsv1.JobState_JOB_STATE_CANCELLED)
+}
+
+func TestServer_StartExportErrors(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	client := newTestClient(t, New(blockingRunner(release), nil, Defaults{}, 1))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		request *jobsv1.StartExportRequest
+		code    codes.Code
+	}{
+		{"missing output dir", &jobsv1.StartExportRequest{}, codes.InvalidArgument},
+		{"invalid date", &jobsv1.StartExportRequest{OutputDir: dir, Filters: &jobsv1.ExportFilters{DateAfter: "01/02/2024"}}, codes.InvalidArgument},
+		{"invalid scope", &jobsv1.StartExportRequest{OutputDir: dir, Filters: &jobsv1.ExportFilters{SearchScope: "archive"}}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.StartExport(ctx, tt.request); status.Code(err) != tt.code {
+				t.Errorf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	if _, err := client.StartExport(ctx, &jobsv1.StartExportRequest{OutputDir: dir}); err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	if _, err := client.StartExport(ctx, &jobsv1.StartExportRequest{OutputDir: dir}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected a second export into the same directory to be refused, got %v", err)
+	}
+
+	if _, err := client.GetJobStatus(ctx, &jobsv1.GetJobStatusRequest{JobId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown job to be not found, got %v", err)
+	}
+}
+
+func TestServer_Restore(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), DefaultStoreFileName))
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+	defer store.Close()
+
+	release := make(chan struct{})
+	first := New(blockingRunner(release), store, Defaults{}, 1)
+	client := newTestClient(t, first)
+	ctx := context.Background()
+
+	running, err := client.StartExport(ctx, &jobsv1.StartExportRequest{Account: "work", OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	waitForState(t, client, running.JobId, jobsv1.JobState_JOB_STATE_RUNNING)
+	queued, err := client.StartExport(ctx, &jobsv1.StartExportRequest{OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	first.Shutdown()
+
+	// The restarted server requeues both jobs, resuming the running one
+	var resumed []string
+	var mu sync.Mutex
+	runner := func(ctx context.Context, request *jobsv1.StartExportRequest, filterConfig *filters.Config, events exporter.Events) (*exporter.Result, error) {
+		if request.Resume {
+			mu.Lock()
+			resumed = append(resumed, request.Account)
+			mu.Unlock()
+		}
+		return blockingRunner(release)(ctx, request, filterConfig, events)
+	}
+	second := New(runner, store, Defaults{}, 2)
+	if err := second.Restore(); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	client = newTestClient(t, second)
+
+	for _, id := range []string{running.JobId, queued.JobId} {
+		job := waitForState(t, client, id, jobsv1.JobState_JOB_STATE_RUNNING)
+		if job.Restarts != 1 {
+			t.Errorf("Expected job %s to count a restart, got %d", id, job.Restarts)
+		}
+	}
+	list, err := client.ListJobs(ctx, &jobsv1.ListJobsRequest{State: jobsv1.JobState_JOB_STATE_RUNNING})
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(list.Jobs) != 2 || list.Jobs[0].JobId != running.JobId {
+		t.Errorf("Expected both jobs running, oldest first, got %v", list.Jobs)
+	}
+	list, err = client.ListJobs(ctx, &jobsv1.ListJobsRequest{Account: "work"})
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(list.Jobs) != 1 || list.Jobs[0].JobId != running.JobId {
+		t.Errorf("Expected only the job of the work account, got %v", list.Jobs)
+	}
+
+	close(release)
+	waitForState(t, client, running.JobId, jobsv1.JobState_JOB_STATE_SUCCEEDED)
+	waitForState(t, client, queued.JobId, jobsv1.JobState_JOB_STATE_SUCCEEDED)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(resumed) != 1 || resumed[0] != "work" {
+		t.Errorf("Expected only the interrupted running job to resume, got %v", resumed)
+	}
+}
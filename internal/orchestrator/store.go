@@ -0,0 +1,110 @@
+package orchestrator
+
+import (
+	"database/sql"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+	"github.com/octasoft-ltd/gmail-exporter/internal/sqlitedb"
+)
+
+// DefaultStoreFileName is the jobs database file name in the config directory
+const DefaultStoreFileName = "jobs.db"
+
+// storeSchema keeps each job as its status and the request that started it,
+// both as protobuf JSON, so it can be run again after a restart. The state,
+// account and creation time are columns of their own for queries.
+const storeSchema = `CREATE TABLE IF NOT EXISTS jobs (
+	id         TEXT PRIMARY KEY,
+	state      TEXT NOT NULL,
+	account    TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	job        TEXT NOT NULL,
+	request    TEXT NOT NULL
+)`
+
+// Store persists jobs across server restarts, backed by a SQLite database.
+// A nil store keeps nothing.
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens or creates the jobs database at path
+func OpenStore(path string) (*Store, error) {
+	db, err := sqlitedb.Open(path, storeSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open jobs database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Put stores a job, replacing its previous status
+func (s *Store) Put(job *jobsv1.Job, request *jobsv1.StartExportRequest) error {
+	if s == nil {
+		return nil
+	}
+
+	jobData, err := protojson.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job %s: %w", job.GetJobId(), err)
+	}
+	requestData, err := protojson.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request of job %s: %w", job.GetJobId(), err)
+	}
+
+	_, err = s.db.Exec(`INSERT INTO jobs (id, state, account, created_at, job, request)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET state = excluded.state, job = excluded.job, request = excluded.request`,
+		job.GetJobId(), job.GetState().String(), job.GetAccount(), job.GetCreatedAt().AsTime().UnixNano(),
+		string(jobData), string(requestData))
+	if err != nil {
+		return fmt.Errorf("failed to store job %s: %w", job.GetJobId(), err)
+	}
+	return nil
+}
+
+// Load returns the stored jobs with their requests, oldest first
+func (s *Store) Load() ([]*jobsv1.Job, []*jobsv1.StartExportRequest, error) {
+	if s == nil {
+		return nil, nil, nil
+	}
+
+	rows, err := s.db.Query(`SELECT id, job, request FROM jobs ORDER BY created_at, id`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*jobsv1.Job
+	var requests []*jobsv1.StartExportRequest
+	for rows.Next() {
+		var id, jobData, requestData string
+		if err := rows.Scan(&id, &jobData, &requestData); err != nil {
+			return nil, nil, fmt.Errorf("failed to read jobs: %w", err)
+		}
+		job, request := &jobsv1.Job{}, &jobsv1.StartExportRequest{}
+		if err := protojson.Unmarshal([]byte(jobData), job); err != nil {
+			return nil, nil, fmt.Errorf("failed to read job %s: %w", id, err)
+		}
+		if err := protojson.Unmarshal([]byte(requestData), request); err != nil {
+			return nil, nil, fmt.Errorf("failed to read request of job %s: %w", id, err)
+		}
+		jobs = append(jobs, job)
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	return jobs, requests, nil
+}
@@ -0,0 +1,76 @@
+package orchestrator
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	jobsv1 "github.com/octasoft-ltd/gmail-exporter/api/jobs/v1"
+)
+
+func TestStore_PutLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs", DefaultStoreFileName)
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	newer := &jobsv1.Job{JobId: "b", State: jobsv1.JobState_JOB_STATE_QUEUED, CreatedAt: timestamppb.New(created.Add(time.Minute))}
+	older := &jobsv1.Job{JobId: "z", State: jobsv1.JobState_JOB_STATE_RUNNING, CreatedAt: timestamppb.New(created)}
+	if err := store.Put(newer, &jobsv1.StartExportRequest{OutputDir: "/b"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put(older, &jobsv1.StartExportRequest{OutputDir: "/z"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// A later status replaces the stored one
+	older.State = jobsv1.JobState_JOB_STATE_SUCCEEDED
+	older.Progress = &jobsv1.Progress{Exported: 5}
+	if err := store.Put(older, &jobsv1.StartExportRequest{OutputDir: "/z", Filters: &jobsv1.ExportFilters{From: "a@example.com"}}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+	defer store.Close()
+
+	jobs, requests, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(jobs) != 2 || len(requests) != 2 {
+		t.Fatalf("Expected 2 jobs, got %d jobs and %d requests", len(jobs), len(requests))
+	}
+	if jobs[0].JobId != "z" || jobs[1].JobId != "b" {
+		t.Errorf("Expected jobs oldest first, got %s, %s", jobs[0].JobId, jobs[1].JobId)
+	}
+	if jobs[0].State != jobsv1.JobState_JOB_STATE_SUCCEEDED || jobs[0].Progress.GetExported() != 5 {
+		t.Errorf("Expected the latest status of job z, got %v", jobs[0])
+	}
+	if requests[0].GetFilters().GetFrom() != "a@example.com" || requests[1].GetOutputDir() != "/b" {
+		t.Errorf("Expected the stored requests, got %v and %v", requests[0], requests[1])
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	var store *Store
+	if err := store.Put(&jobsv1.Job{JobId: "a"}, &jobsv1.StartExportRequest{}); err != nil {
+		t.Errorf("Expected a nil store to ignore Put, got %v", err)
+	}
+	jobs, _, err := store.Load()
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("Expected a nil store to load nothing, got %v, %v", jobs, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Expected a nil store to close, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package outlook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Compound File Binary format constants, from [MS-CFB]
+const (
+	cfbHeaderSize   = 512
+	cfbDirEntrySize = 128
+	cfbHeaderDIFAT  = 109
+	cfbMaxRegSector = 0xFFFFFFFA
+	cfbEndOfChain   = 0xFFFFFFFE
+	cfbNoStream     = 0xFFFFFFFF
+	cfbTypeStorage  = 1
+	cfbTypeStream   = 2
+	cfbTypeRoot     = 5
+)
+
+// cfbSignature starts every compound file
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// dirEntry is a storage or stream of a compound file
+type dirEntry struct {
+	name  string
+	kind  byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+// compoundFile is an OLE compound file read into memory: a small file
+// system of storages (directories) and streams (files) inside one file
+type compoundFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     []byte
+	entries        []dirEntry
+}
+
+// parseCompoundFile reads the allocation tables and directory of a compound
+// file
+func parseCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < cfbHeaderSize || !bytes.Equal(data[:len(cfbSignature)], cfbSignature) {
+		return nil, fmt.Errorf("not an OLE compound file")
+	}
+
+	le := binary.LittleEndian
+	sectorShift := le.Uint16(data[0x1E:])
+	miniSectorShift := le.Uint16(data[0x20:])
+	if sectorShift < 7 || sectorShift > 16 || miniSectorShift >= sectorShift {
+		return nil, fmt.Errorf("invalid sector size")
+	}
+	c := &compoundFile{
+		data:           data,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniSectorShift,
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+
+	// The DIFAT lists the sectors of the FAT: 109 entries in the header,
+	// then chained DIFAT sectors whose last entry points to the next. The
+	// chain cannot be longer than the file or visit a sector twice.
+	fatSectors := make([]uint32, 0, cfbHeaderDIFAT)
+	for i := 0; i < cfbHeaderDIFAT; i++ {
+		if sector := le.Uint32(data[0x4C+4*i:]); sector <= cfbMaxRegSector {
+			fatSectors = append(fatSectors, sector)
+		}
+	}
+	fileSectors := len(data)/c.sectorSize - 1
+	seen := make(map[uint32]bool)
+	next := le.Uint32(data[0x44:])
+	for count := le.Uint32(data[0x48:]); count > 0 && next <= cfbMaxRegSector; count-- {
+		if seen[next] || len(seen) >= fileSectors {
+			return nil, fmt.Errorf("broken DIFAT chain at %d", next)
+		}
+		seen[next] = true
+		sector, err := c.sector(next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DIFAT: %w", err)
+		}
+		last := len(sector)/4 - 1
+		for i := 0; i < last; i++ {
+			if entry := le.Uint32(sector[4*i:]); entry <= cfbMaxRegSector {
+				fatSectors = append(fatSectors, entry)
+			}
+		}
+		next = le.Uint32(sector[4*last:])
+	}
+
+	for _, index := range fatSectors {
+		sector, err := c.sector(index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FAT: %w", err)
+		}
+		for i := 0; i < len(sector); i += 4 {
+			c.fat = append(c.fat, le.Uint32(sector[i:]))
+		}
+	}
+
+	directory, err := c.readChain(le.Uint32(data[0x30:]), c.fat, c.sector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+	for i := 0; i+cfbDirEntrySize <= len(directory); i += cfbDirEntrySize {
+		entry := parseDirEntry(directory[i : i+cfbDirEntrySize])
+		if c.sectorSize == 512 {
+			// Version 3 files only use the low half of the size
+			entry.size &= 0xFFFFFFFF
+		}
+		c.entries = append(c.entries, entry)
+	}
+	if len(c.entries) == 0 || c.entries[0].kind != cfbTypeRoot {
+		return nil, fmt.Errorf("missing root storage")
+	}
+
+	if miniFATStart := le.Uint32(data[0x3C:]); miniFATStart <= cfbMaxRegSector {
+		miniFAT, err := c.readChain(miniFATStart, c.fat, c.sector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mini FAT: %w", err)
+		}
+		for i := 0; i+4 <= len(miniFAT); i += 4 {
+			c.miniFAT = append(c.miniFAT, le.Uint32(miniFAT[i:]))
+		}
+	}
+
+	// The root entry's stream is the mini stream holding small streams
+	root := c.entries[0]
+	if root.start <= cfbMaxRegSector {
+		c.miniStream, err = c.readChain(root.start, c.fat, c.sector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mini stream: %w", err)
+		}
+		if uint64(len(c.miniStream)) > root.size {
+			c.miniStream = c.miniStream[:root.size]
+		}
+	}
+
+	return c, nil
+}
+
+// parseDirEntry decodes a 128 byte directory entry
+func parseDirEntry(raw []byte) dirEntry {
+	le := binary.LittleEndian
+	nameLength := int(le.Uint16(raw[64:]))
+	if nameLength > 64 {
+		nameLength = 64
+	}
+	units := make([]uint16, 0, nameLength/2)
+	for i := 0; i+1 < nameLength; i += 2 {
+		if unit := le.Uint16(raw[i:]); unit != 0 {
+			units = append(units, unit)
+		}
+	}
+
+	return dirEntry{
+		name:  string(utf16.Decode(units)),
+		kind:  raw[66],
+		left:  le.Uint32(raw[68:]),
+		right: le.Uint32(raw[72:]),
+		child: le.Uint32(raw[76:]),
+		start: le.Uint32(raw[116:]),
+		size:  le.Uint64(raw[120:]),
+	}
+}
+
+// sector returns a regular sector of the file
+func (c *compoundFile) sector(index uint32) ([]byte, error) {
+	offset := (int64(index) + 1) * int64(c.sectorSize)
+	if offset+int64(c.sectorSize) > int64(len(c.data)) {
+		return nil, fmt.Errorf("sector %d is outside the file", index)
+	}
+	return c.data[offset : offset+int64(c.sectorSize)], nil
+}
+
+// miniSector returns a sector of the mini stream
+func (c *compoundFile) miniSector(index uint32) ([]byte, error) {
+	offset := int64(index) * int64(c.miniSectorSize)
+	if offset+int64(c.miniSectorSize) > int64(len(c.miniStream)) {
+		return nil, fmt.Errorf("mini sector %d is outside the mini stream", index)
+	}
+	return c.miniStream[offset : offset+int64(c.miniSectorSize)], nil
+}
+
+// readChain concatenates the sectors of a chain in an allocation table,
+// failing on chains that loop or run off the table
+func (c *compoundFile) readChain(start uint32, table []uint32, read func(uint32) ([]byte, error)) ([]byte, error) {
+	var out []byte
+	for index, steps := start, 0; index != cfbEndOfChain; steps++ {
+		if int(index) >= len(table) || steps > len(table) {
+			return nil, fmt.Errorf("broken sector chain at %d", index)
+		}
+		sector, err := read(index)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sector...)
+		index = table[index]
+	}
+	return out, nil
+}
+
+// stream returns the contents of a stream entry
+func (c *compoundFile) stream(entry dirEntry) ([]byte, error) {
+	if entry.size == 0 {
+		return nil, nil
+	}
+
+	var data []byte
+	var err error
+	if entry.size < c.miniCutoff {
+		data, err = c.readChain(entry.start, c.miniFAT, c.miniSector)
+	} else {
+		data, err = c.readChain(entry.start, c.fat, c.sector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < entry.size {
+		return nil, fmt.Errorf("stream %s is truncated", entry.name)
+	}
+	return data[:entry.size], nil
+}
+
+// children returns the entries directly inside a storage, keyed by upper
+// case name. They are kept in a tree of left and right siblings under the
+// storage's child.
+func (c *compoundFile) children(storage uint32) map[string]uint32 {
+	children := make(map[string]uint32)
+	pending := []uint32{c.entries[storage].child}
+	for len(pending) > 0 {
+		index := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if index == cfbNoStream || int(index) >= len(c.entries) {
+			continue
+		}
+		entry := c.entries[index]
+		name := strings.ToUpper(entry.name)
+		if _, seen := children[name]; seen {
+			continue
+		}
+		children[name] = index
+		pending = append(pending, entry.left, entry.right)
+	}
+	return children
+}
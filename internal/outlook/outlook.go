@@ -0,0 +1,558 @@
+// Package outlook converts Outlook .msg files, single messages saved as OLE
+// compound files of MAPI properties, to RFC 822 messages
+package outlook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/net/html/charset"
+)
+
+// MAPI property IDs, from [MS-OXPROPS]
+const (
+	propTransportHeaders = 0x007D
+	propSubject          = 0x0037
+	propClientSubmitTime = 0x0039
+	propSentRepName      = 0x0042
+	propSentRepAddress   = 0x0065
+	propSenderName       = 0x0C1A
+	propSenderAddress    = 0x0C1F
+	propRecipientType    = 0x0C15
+	propDisplayTo        = 0x0E04
+	propDeliveryTime     = 0x0E06
+	propMessageFlags     = 0x0E07
+	propBody             = 0x1000
+	propHTML             = 0x1013
+	propMessageID        = 0x1035
+	propReferences       = 0x1039
+	propInReplyTo        = 0x1042
+	propFlagStatus       = 0x1090
+	propDisplayName      = 0x3001
+	propEmailAddress     = 0x3003
+	propAttachData       = 0x3701
+	propAttachFilename   = 0x3704
+	propAttachMethod     = 0x3705
+	propAttachLongName   = 0x3707
+	propAttachMIMETag    = 0x370E
+	propAttachContentID  = 0x3712
+	propSMTPAddress      = 0x39FE
+	propInternetCPID     = 0x3FDE
+	propMessageCodepage  = 0x3FFD
+	propSenderSMTP       = 0x5D01
+	propSentRepSMTP      = 0x5D02
+)
+
+// MAPI property types
+const (
+	typeLong    = 0x0003
+	typeObject  = 0x000D
+	typeString8 = 0x001E
+	typeUnicode = 0x001F
+	typeSysTime = 0x0040
+	typeBinary  = 0x0102
+)
+
+// Property values
+const (
+	recipientTo       = 1
+	recipientCc       = 2
+	recipientBcc      = 3
+	attachEmbedded    = 5
+	messageFlagRead   = 0x1
+	flagStatusFlagged = 2
+)
+
+// Storage and stream names of a .msg file
+const (
+	propertiesStream = "__PROPERTIES_VERSION1.0"
+	recipientPrefix  = "__RECIP_VERSION1.0_#"
+	attachmentPrefix = "__ATTACH_VERSION1.0_#"
+)
+
+// The fixed size header before the property entries of a properties
+// stream, which differs between the message, embedded messages and the
+// recipient and attachment storages
+const (
+	topHeaderSize      = 32
+	embeddedHeaderSize = 24
+	childHeaderSize    = 8
+)
+
+// codepages maps the Windows code pages of 8-bit string properties to
+// charset labels
+var codepages = map[int64]string{
+	874:   "windows-874",
+	932:   "shift_jis",
+	936:   "gbk",
+	949:   "euc-kr",
+	950:   "big5",
+	20127: "us-ascii",
+	20866: "koi8-r",
+	28591: "iso-8859-1",
+	28592: "iso-8859-2",
+	28605: "iso-8859-15",
+	50220: "iso-2022-jp",
+	51932: "euc-jp",
+	65001: "utf-8",
+}
+
+// Message is an Outlook message converted to RFC 822
+type Message struct {
+	Raw []byte
+	// Read and Flagged are the read and follow-up flag state in Outlook
+	Read    bool
+	Flagged bool
+}
+
+// Convert parses a .msg file and writes it as a MIME message: the original
+// internet headers when Outlook kept them, or headers rebuilt from the
+// sender and recipients, the plain text and HTML bodies, and the
+// attachments, with attached messages as message/rfc822 parts. Bodies only
+// stored as RTF are not converted.
+func Convert(data []byte) (*Message, error) {
+	file, err := parseCompoundFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root := newStorage(file, 0, topHeaderSize)
+	raw, err := root.rfc822()
+	if err != nil {
+		return nil, err
+	}
+
+	flags, _ := root.fixed(propMessageFlags)
+	status, _ := root.fixed(propFlagStatus)
+	return &Message{
+		Raw:     raw,
+		Read:    flags&messageFlagRead != 0,
+		Flagged: status == flagStatusFlagged,
+	}, nil
+}
+
+// storage is the set of MAPI properties stored in a storage of the file
+type storage struct {
+	file     *compoundFile
+	children map[string]uint32
+	fixedSet map[uint16]uint64
+	codepage string
+}
+
+// newStorage reads the properties of the storage at index, whose properties
+// stream starts with a header of headerSize bytes
+func newStorage(file *compoundFile, index uint32, headerSize int) *storage {
+	s := &storage{file: file, children: file.children(index), fixedSet: make(map[uint16]uint64)}
+
+	// Fixed size properties are 16 byte entries of the properties stream:
+	// the tag, flags and an 8 byte value
+	if data, ok := s.stream(propertiesStream); ok && len(data) > headerSize {
+		le := binary.LittleEndian
+		for entry := data[headerSize:]; len(entry) >= 16; entry = entry[16:] {
+			tag := le.Uint32(entry)
+			switch tag & 0xFFFF {
+			case typeLong:
+				s.fixedSet[uint16(tag>>16)] = uint64(le.Uint32(entry[8:]))
+			case typeSysTime:
+				s.fixedSet[uint16(tag>>16)] = le.Uint64(entry[8:])
+			}
+		}
+	}
+
+	s.codepage = "windows-1252"
+	for _, id := range []uint16{propInternetCPID, propMessageCodepage} {
+		if value, ok := s.fixed(id); ok {
+			if label, ok := codepages[int64(value)]; ok {
+				s.codepage = label
+			} else if value >= 1250 && value <= 1258 {
+				s.codepage = fmt.Sprintf("windows-%d", value)
+			}
+			break
+		}
+	}
+	return s
+}
+
+// stream returns a stream of the storage
+func (s *storage) stream(name string) ([]byte, bool) {
+	index, ok := s.children[name]
+	if !ok || s.file.entries[index].kind != cfbTypeStream {
+		return nil, false
+	}
+	data, err := s.file.stream(s.file.entries[index])
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// property returns a variable length property stream
+func (s *storage) property(id, kind uint16) ([]byte, bool) {
+	return s.stream(fmt.Sprintf("__SUBSTG1.0_%04X%04X", id, kind))
+}
+
+// fixed returns a fixed size property
+func (s *storage) fixed(id uint16) (uint64, bool) {
+	value, ok := s.fixedSet[id]
+	return value, ok
+}
+
+// text returns a string property, stored as UTF-16 or in the storage's
+// code page
+func (s *storage) text(id uint16) string {
+	if data, ok := s.property(id, typeUnicode); ok {
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			units = append(units, binary.LittleEndian.Uint16(data[i:]))
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	if data, ok := s.property(id, typeString8); ok {
+		data = bytes.TrimRight(data, "\x00")
+		if encoding, _ := charset.Lookup(s.codepage); encoding != nil {
+			if decoded, err := encoding.NewDecoder().Bytes(data); err == nil {
+				return string(decoded)
+			}
+		}
+		return string(data)
+	}
+	return ""
+}
+
+// date returns a date property, stored as a Windows FILETIME
+func (s *storage) date(id uint16) (time.Time, bool) {
+	value, ok := s.fixed(id)
+	if !ok || value == 0 {
+		return time.Time{}, false
+	}
+	// FILETIME counts 100ns intervals since 1601-01-01
+	const unixEpoch = 116444736000000000
+	return time.Unix(0, (int64(value)-unixEpoch)*100).UTC(), true
+}
+
+// substorages returns the storages whose names start with prefix, in order
+func (s *storage) substorages(prefix string, headerSize int) []*storage {
+	var names []string
+	for name, index := range s.children {
+		if strings.HasPrefix(name, prefix) && s.file.entries[index].kind == cfbTypeStorage {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	storages := make([]*storage, 0, len(names))
+	for _, name := range names {
+		storages = append(storages, newStorage(s.file, s.children[name], headerSize))
+	}
+	return storages
+}
+
+// rfc822 writes the message in the storage as a MIME message
+func (s *storage) rfc822() ([]byte, error) {
+	var out bytes.Buffer
+	if headers := s.text(propTransportHeaders); strings.TrimSpace(headers) != "" {
+		writeTransportHeaders(&out, headers)
+	} else {
+		s.writeHeaders(&out)
+	}
+	out.WriteString("MIME-Version: 1.0\r\n")
+
+	header, body, err := s.body()
+	if err != nil {
+		return nil, err
+	}
+	attachments := s.substorages(attachmentPrefix, childHeaderSize)
+	if len(attachments) == 0 {
+		writeHeader(&out, header)
+		out.Write(body)
+		return out.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&out)
+	fmt.Fprintf(&out, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mixed.Boundary())
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(body); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		if err := attachment.writeAttachment(mixed); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeTransportHeaders writes the internet headers Outlook kept for a
+// received message, without the MIME headers of the original body, which
+// is rebuilt from the message's properties
+func writeTransportHeaders(out *bytes.Buffer, headers string) {
+	skipping := false
+	for _, line := range strings.Split(strings.ReplaceAll(headers, "\r\n", "\n"), "\n") {
+		if line == "" {
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := strings.Cut(line, ":")
+			name = strings.ToLower(strings.TrimSpace(name))
+			skipping = name == "mime-version" || strings.HasPrefix(name, "content-")
+		}
+		if !skipping {
+			out.WriteString(line + "\r\n")
+		}
+	}
+}
+
+// writeHeaders writes headers rebuilt from the message's properties, for
+// messages Outlook did not keep the internet headers of, such as sent mail
+// and drafts
+func (s *storage) writeHeaders(out *bytes.Buffer) {
+	date, ok := s.date(propDeliveryTime)
+	if !ok {
+		date, ok = s.date(propClientSubmitTime)
+	}
+	if ok {
+		out.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	}
+
+	from := formatAddress(s.text(propSenderName), firstNonEmpty(s.text(propSenderSMTP), s.text(propSenderAddress)))
+	if from == "" {
+		from = formatAddress(s.text(propSentRepName), firstNonEmpty(s.text(propSentRepSMTP), s.text(propSentRepAddress)))
+	}
+	if from != "" {
+		out.WriteString("From: " + from + "\r\n")
+	}
+
+	recipients := map[uint64][]string{}
+	for _, recipient := range s.substorages(recipientPrefix, childHeaderSize) {
+		kind, ok := recipient.fixed(propRecipientType)
+		if !ok {
+			kind = recipientTo
+		}
+		address := formatAddress(recipient.text(propDisplayName), firstNonEmpty(recipient.text(propSMTPAddress), recipient.text(propEmailAddress)))
+		if address != "" {
+			recipients[kind] = append(recipients[kind], address)
+		}
+	}
+	if len(recipients[recipientTo]) == 0 {
+		if display := s.text(propDisplayTo); display != "" {
+			recipients[recipientTo] = []string{mime.QEncoding.Encode("utf-8", display)}
+		}
+	}
+	for _, field := range []struct {
+		name string
+		kind uint64
+	}{{"To", recipientTo}, {"Cc", recipientCc}, {"Bcc", recipientBcc}} {
+		if addresses := recipients[field.kind]; len(addresses) > 0 {
+			out.WriteString(field.name + ": " + strings.Join(addresses, ", ") + "\r\n")
+		}
+	}
+
+	if subject := s.text(propSubject); subject != "" {
+		out.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	}
+	for _, header := range []struct {
+		name string
+		id   uint16
+	}{{"Message-ID", propMessageID}, {"In-Reply-To", propInReplyTo}, {"References", propReferences}} {
+		if value := strings.TrimSpace(s.text(header.id)); value != "" {
+			out.WriteString(header.name + ": " + value + "\r\n")
+		}
+	}
+}
+
+// body returns the headers and body of the message text: multipart/alternative
+// when it has both a plain text and an HTML body
+func (s *storage) body() (textproto.MIMEHeader, []byte, error) {
+	text := s.text(propBody)
+	html, htmlCharset := s.html()
+
+	var out bytes.Buffer
+	switch {
+	case html != nil && text != "":
+		alternative := multipart.NewWriter(&out)
+		for _, part := range []struct {
+			mediaType string
+			charset   string
+			body      []byte
+		}{{"text/plain", "utf-8", []byte(text)}, {"text/html", htmlCharset, html}} {
+			writer, err := alternative.CreatePart(textHeader(part.mediaType, part.charset))
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := writeQuotedPrintable(writer, part.body); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := alternative.Close(); err != nil {
+			return nil, nil, err
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+		return header, out.Bytes(), nil
+	case html != nil:
+		err := writeQuotedPrintable(&out, html)
+		return textHeader("text/html", htmlCharset), out.Bytes(), err
+	default:
+		err := writeQuotedPrintable(&out, []byte(text))
+		return textHeader("text/plain", "utf-8"), out.Bytes(), err
+	}
+}
+
+// html returns the HTML body and its charset. Outlook stores it as bytes in
+// the message's internet code page, or sometimes as a string.
+func (s *storage) html() ([]byte, string) {
+	if data, ok := s.property(propHTML, typeBinary); ok && len(data) > 0 {
+		return bytes.TrimRight(data, "\x00"), s.codepage
+	}
+	if html := s.text(propHTML); html != "" {
+		return []byte(html), "utf-8"
+	}
+	return nil, ""
+}
+
+// writeAttachment writes an attachment as a part of the message
+func (s *storage) writeAttachment(mixed *multipart.Writer) error {
+	method, _ := s.fixed(propAttachMethod)
+	if method == attachEmbedded {
+		index, ok := s.children[fmt.Sprintf("__SUBSTG1.0_%04X%04X", propAttachData, typeObject)]
+		if !ok {
+			return nil
+		}
+		embedded, err := newStorage(s.file, index, embeddedHeaderSize).rfc822()
+		if err != nil {
+			return fmt.Errorf("failed to convert attached message: %w", err)
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "message/rfc822")
+		header.Set("Content-Disposition", "attachment")
+		part, err := mixed.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		_, err = part.Write(embedded)
+		return err
+	}
+
+	data, ok := s.property(propAttachData, typeBinary)
+	if !ok {
+		// Links and OLE objects have no content to carry over
+		return nil
+	}
+
+	name := firstNonEmpty(s.text(propAttachLongName), s.text(propAttachFilename), "attachment")
+	mediaType := s.text(propAttachMIMETag)
+	if mediaType == "" {
+		if ext := name[strings.LastIndex(name, ".")+1:]; ext != name {
+			mediaType = mime.TypeByExtension("." + ext)
+		}
+	}
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"name": name}))
+	disposition := "attachment"
+	if contentID := s.text(propAttachContentID); contentID != "" {
+		disposition = "inline"
+		header.Set("Content-ID", "<"+strings.Trim(contentID, "<>")+">")
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	return writeBase64(part, data)
+}
+
+// formatAddress formats an address header entry. Exchange addresses are
+// X.500 paths rather than email addresses, so only the name is kept.
+func formatAddress(name, address string) string {
+	name = strings.TrimSpace(name)
+	address = strings.TrimSpace(address)
+	if strings.Contains(address, "@") {
+		if name == address {
+			name = ""
+		}
+		return (&mail.Address{Name: name, Address: address}).String()
+	}
+	if name != "" {
+		return mime.QEncoding.Encode("utf-8", name) + " <>"
+	}
+	return ""
+}
+
+// textHeader returns the headers of a quoted-printable text part
+func textHeader(mediaType, charset string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": charset}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return header
+}
+
+// writeHeader writes part headers followed by the blank line that ends them
+func writeHeader(out *bytes.Buffer, header textproto.MIMEHeader) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			out.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	out.WriteString("\r\n")
+}
+
+// writeQuotedPrintable writes text quoted-printable encoded
+func writeQuotedPrintable(w io.Writer, data []byte) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write(data); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// writeBase64 writes data base64 encoded in 76 character lines
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := w.Write([]byte(encoded[:n] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
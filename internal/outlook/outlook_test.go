@@ -0,0 +1,405 @@
+package outlook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// node is a storage or stream of a test compound file
+type node struct {
+	name     string
+	data     []byte
+	children []*node
+	storage  bool
+}
+
+// buildCompoundFile writes a version 3 compound file, with streams under
+// 4096 bytes in the mini stream as Outlook writes them
+func buildCompoundFile(root *node) []byte {
+	const sectorSize, miniSize, perSector = 512, 64, 128
+	le := binary.LittleEndian
+
+	// Number the entries depth first, linking siblings as right children
+	var entries []*node
+	var add func(n *node) int
+	childOf := map[int]int{}
+	rightOf := map[int]int{}
+	add = func(n *node) int {
+		index := len(entries)
+		entries = append(entries, n)
+		previous := -1
+		for _, child := range n.children {
+			childIndex := add(child)
+			if previous < 0 {
+				childOf[index] = childIndex
+			} else {
+				rightOf[previous] = childIndex
+			}
+			previous = childIndex
+		}
+		return index
+	}
+	add(root)
+
+	var miniStream []byte
+	var miniFAT []uint32
+	var big [][]byte
+	starts := make([]uint32, len(entries))
+	for i, n := range entries {
+		if n.storage || i == 0 || len(n.data) == 0 {
+			continue
+		}
+		if len(n.data) < 4096 {
+			first := uint32(len(miniFAT))
+			count := (len(n.data) + miniSize - 1) / miniSize
+			for j := 0; j < count; j++ {
+				miniFAT = append(miniFAT, first+uint32(j)+1)
+			}
+			miniFAT[len(miniFAT)-1] = cfbEndOfChain
+			padded := make([]byte, count*miniSize)
+			copy(padded, n.data)
+			miniStream = append(miniStream, padded...)
+			starts[i] = first
+		} else {
+			big = append(big, n.data)
+		}
+	}
+
+	sectors := func(n int) int { return (n + sectorSize - 1) / sectorSize }
+	dirSectors := sectors(len(entries) * cfbDirEntrySize)
+	miniFATSectors := sectors(len(miniFAT) * 4)
+	miniStreamSectors := sectors(len(miniStream))
+	dataSectors := 0
+	for _, data := range big {
+		dataSectors += sectors(len(data))
+	}
+	other := dirSectors + miniFATSectors + miniStreamSectors + dataSectors
+	fatSectors := 1
+	for (other+fatSectors+perSector-1)/perSector > fatSectors {
+		fatSectors++
+	}
+
+	fat := make([]uint32, fatSectors*perSector)
+	for i := range fat {
+		fat[i] = cfbNoStream
+	}
+	next := 0
+	chain := func(count int) uint32 {
+		if count == 0 {
+			return cfbEndOfChain
+		}
+		first := next
+		for j := 0; j < count; j++ {
+			fat[next] = uint32(next + 1)
+			next++
+		}
+		fat[next-1] = cfbEndOfChain
+		return uint32(first)
+	}
+	for j := 0; j < fatSectors; j++ {
+		fat[next] = 0xFFFFFFFD
+		next++
+	}
+	dirStart := chain(dirSectors)
+	miniFATStart := chain(miniFATSectors)
+	miniStreamStart := chain(miniStreamSectors)
+	bigIndex := 0
+	for i, n := range entries {
+		if !n.storage && i != 0 && len(n.data) >= 4096 {
+			starts[i] = chain(sectors(len(big[bigIndex])))
+			bigIndex++
+		}
+	}
+	starts[0] = miniStreamStart
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], uint32(fatSectors))
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], miniFATStart)
+	le.PutUint32(header[0x40:], uint32(miniFATSectors))
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := 0; i < cfbHeaderDIFAT; i++ {
+		sector := uint32(cfbNoStream)
+		if i < fatSectors {
+			sector = uint32(i)
+		}
+		le.PutUint32(header[0x4C+4*i:], sector)
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	pad := func() {
+		if rest := out.Len() % sectorSize; rest != 0 {
+			out.Write(make([]byte, sectorSize-rest))
+		}
+	}
+	for _, entry := range fat {
+		binary.Write(&out, le, entry)
+	}
+	for i, n := range entries {
+		raw := make([]byte, cfbDirEntrySize)
+		units := utf16.Encode([]rune(n.name))
+		for j, unit := range units {
+			le.PutUint16(raw[2*j:], unit)
+		}
+		le.PutUint16(raw[64:], uint16(2*len(units)+2))
+		switch {
+		case i == 0:
+			raw[66] = cfbTypeRoot
+			le.PutUint64(raw[120:], uint64(len(miniStream)))
+		case n.storage:
+			raw[66] = cfbTypeStorage
+		default:
+			raw[66] = cfbTypeStream
+			le.PutUint64(raw[120:], uint64(len(n.data)))
+		}
+		le.PutUint32(raw[68:], cfbNoStream)
+		le.PutUint32(raw[72:], cfbNoStream)
+		le.PutUint32(raw[76:], cfbNoStream)
+		if right, ok := rightOf[i]; ok {
+			le.PutUint32(raw[72:], uint32(right))
+		}
+		if child, ok := childOf[i]; ok {
+			le.PutUint32(raw[76:], uint32(child))
+		}
+		le.PutUint32(raw[116:], starts[i])
+		out.Write(raw)
+	}
+	pad()
+	for _, entry := range miniFAT {
+		binary.Write(&out, le, entry)
+	}
+	pad()
+	out.Write(miniStream)
+	pad()
+	for _, data := range big {
+		out.Write(data)
+		pad()
+	}
+	return out.Bytes()
+}
+
+// unicode returns a UTF-16 string property stream
+func unicode(id uint16, value string) *node {
+	var data []byte
+	for _, unit := range utf16.Encode([]rune(value)) {
+		data = binary.LittleEndian.AppendUint16(data, unit)
+	}
+	return &node{name: fmt.Sprintf("__substg1.0_%04X001F", id), data: data}
+}
+
+// binaryProp returns a binary property stream
+func binaryProp(id uint16, data []byte) *node {
+	return &node{name: fmt.Sprintf("__substg1.0_%04X0102", id), data: data}
+}
+
+// properties returns a properties stream with fixed size values, keyed by
+// property tag
+func properties(headerSize int, values map[uint32]uint64) *node {
+	data := make([]byte, headerSize)
+	for tag, value := range values {
+		entry := make([]byte, 16)
+		binary.LittleEndian.PutUint32(entry, tag)
+		binary.LittleEndian.PutUint64(entry[8:], value)
+		data = append(data, entry...)
+	}
+	return &node{name: "__properties_version1.0", data: data}
+}
+
+// filetime converts a time to a Windows FILETIME
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + 116444736000000000)
+}
+
+func TestConvertSentMessage(t *testing.T) {
+	sent := time.Date(2023, 5, 4, 10, 30, 0, 0, time.UTC)
+	attachment := bytes.Repeat([]byte("report data "), 500)
+
+	file := buildCompoundFile(&node{name: "Root Entry", storage: true, children: []*node{
+		properties(topHeaderSize, map[uint32]uint64{
+			propClientSubmitTime<<16 | typeSysTime: filetime(sent),
+			propMessageFlags<<16 | typeLong:        messageFlagRead,
+			propFlagStatus<<16 | typeLong:          flagStatusFlagged,
+		}),
+		unicode(propSubject, "Quarterly report – Q1"),
+		unicode(propSenderName, "Alice Example"),
+		unicode(propSenderSMTP, "alice@example.com"),
+		unicode(propBody, "See attached."),
+		binaryProp(propHTML, []byte("<p>See attached.</p>")),
+		{name: "__recip_version1.0_#00000000", storage: true, children: []*node{
+			properties(childHeaderSize, map[uint32]uint64{propRecipientType<<16 | typeLong: recipientTo}),
+			unicode(propDisplayName, "Bob"),
+			unicode(propSMTPAddress, "bob@example.com"),
+		}},
+		{name: "__recip_version1.0_#00000001", storage: true, children: []*node{
+			properties(childHeaderSize, map[uint32]uint64{propRecipientType<<16 | typeLong: recipientCc}),
+			unicode(propDisplayName, "Carol"),
+			unicode(propEmailAddress, "/O=EXCHANGE/OU=FIRST/CN=RECIPIENTS/CN=CAROL"),
+		}},
+		{name: "__attach_version1.0_#00000000", storage: true, children: []*node{
+			properties(childHeaderSize, map[uint32]uint64{propAttachMethod<<16 | typeLong: 1}),
+			unicode(propAttachLongName, "report.csv"),
+			binaryProp(propAttachData, attachment),
+		}},
+	}})
+
+	message, err := Convert(file)
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if !message.Read || !message.Flagged {
+		t.Errorf("Expected a read, flagged message, got read=%v flagged=%v", message.Read, message.Flagged)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message.Raw))
+	if err != nil {
+		t.Fatalf("Expected a parseable message, got %v\n%s", err, message.Raw)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "Quarterly report – Q1" {
+		t.Errorf("Expected the subject, got %q", subject)
+	}
+	if from := parsed.Header.Get("From"); from != `"Alice Example" <alice@example.com>` {
+		t.Errorf("Expected the sender, got %q", from)
+	}
+	if to := parsed.Header.Get("To"); to != `"Bob" <bob@example.com>` {
+		t.Errorf("Expected the To recipient, got %q", to)
+	}
+	if cc := parsed.Header.Get("Cc"); cc != "Carol <>" {
+		t.Errorf("Expected the Exchange recipient by name, got %q", cc)
+	}
+	if date, err := parsed.Header.Date(); err != nil || !date.Equal(sent) {
+		t.Errorf("Expected the submit time as the date, got %v, %v", date, err)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("Expected multipart/mixed, got %q", mediaType)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	body, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(body.Header.Get("Content-Type")); mediaType != "multipart/alternative" {
+		t.Errorf("Expected the text and HTML bodies as alternatives, got %q", mediaType)
+	}
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.FileName() != "report.csv" {
+		t.Errorf("Expected the attachment name, got %q", part.FileName())
+	}
+	data, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	if !bytes.Equal(data, attachment) {
+		t.Errorf("Expected the attachment content to round trip, got %d bytes", len(data))
+	}
+}
+
+func TestConvertReceivedMessage(t *testing.T) {
+	headers := "Received: from mx.example.com\r\n" +
+		"From: Dave <dave@example.org>\r\n" +
+		"Subject: Hello\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative;\r\n\tboundary=\"old\"\r\n" +
+		"Message-ID: <abc@example.org>\r\n\r\n"
+
+	file := buildCompoundFile(&node{name: "Root Entry", storage: true, children: []*node{
+		properties(topHeaderSize, nil),
+		unicode(propTransportHeaders, headers),
+		unicode(propSubject, "Hello"),
+		unicode(propBody, "Hi there"),
+		{name: "__attach_version1.0_#00000000", storage: true, children: []*node{
+			properties(childHeaderSize, map[uint32]uint64{propAttachMethod<<16 | typeLong: attachEmbedded}),
+			{name: "__substg1.0_3701000D", storage: true, children: []*node{
+				properties(embeddedHeaderSize, nil),
+				unicode(propSubject, "Forwarded"),
+				unicode(propBody, "Inner body"),
+			}},
+		}},
+	}})
+
+	message, err := Convert(file)
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if message.Read {
+		t.Error("Expected an unread message")
+	}
+
+	raw := string(message.Raw)
+	for _, want := range []string{"Received: from mx.example.com\r\n", "Message-ID: <abc@example.org>\r\n", "Content-Type: message/rfc822", "Subject: Forwarded", "Inner body"} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("Expected %q in the message:\n%s", want, raw)
+		}
+	}
+	if strings.Contains(raw, `boundary="old"`) {
+		t.Errorf("Expected the original MIME headers to be dropped:\n%s", raw)
+	}
+	topHeaders, _, _ := strings.Cut(raw, "\r\n\r\n")
+	if strings.Count(topHeaders, "MIME-Version") != 1 {
+		t.Errorf("Expected a single MIME-Version header:\n%s", raw)
+	}
+}
+
+func TestConvertRejectsOtherFiles(t *testing.T) {
+	if _, err := Convert([]byte("From: a@example.com\r\n\r\nbody")); err == nil {
+		t.Error("Expected an error for a file that is not a compound file")
+	}
+}
+
+func TestParseCompoundFile_BrokenDIFAT(t *testing.T) {
+	valid := buildCompoundFile(&node{name: "Root Entry"})
+	le := binary.LittleEndian
+
+	tests := []struct {
+		name  string
+		patch func(data []byte)
+	}{
+		{
+			name: "sector pointing to itself",
+			patch: func(data []byte) {
+				// Point the last entry of the first sector back at it
+				le.PutUint32(data[cfbHeaderSize+cfbHeaderSize-4:], 0)
+			},
+		},
+		{
+			name: "two sectors pointing to each other",
+			patch: func(data []byte) {
+				le.PutUint32(data[cfbHeaderSize+cfbHeaderSize-4:], 1)
+				le.PutUint32(data[2*cfbHeaderSize+cfbHeaderSize-4:], 0)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte(nil), valid...)
+			le.PutUint32(data[0x44:], 0)
+			le.PutUint32(data[0x48:], 1<<30)
+			tt.patch(data)
+
+			if _, err := parseCompoundFile(data); err == nil || !strings.Contains(err.Error(), "DIFAT") {
+				t.Errorf("parseCompoundFile() error = %v, want a broken DIFAT chain", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Package preview lists the messages matching a filter query, one page at a
+// time, so that a filter can be checked before an export or cleanup
+package preview
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
+)
+
+// DefaultPageSize is the number of messages listed per page by default
+const DefaultPageSize = 25
+
+// Message is one listed message
+type Message struct {
+	ID      string    `json:"id"`
+	Date    time.Time `json:"date"`
+	From    string    `json:"from"`
+	Subject string    `json:"subject"`
+	Size    int64     `json:"size"`
+	Labels  []string  `json:"labels"`
+
+	// Headers holds the values of the additional headers listed, by the
+	// header name as requested
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Page is a page of listed messages. NextPageToken is empty on the last
+// page and Estimate is Gmail's estimate of the total number of matches.
+type Page struct {
+	Query         string    `json:"query"`
+	Messages      []Message `json:"messages"`
+	NextPageToken string    `json:"next_page_token,omitempty"`
+	Estimate      int64     `json:"result_size_estimate"`
+
+	// Headers are the additional headers listed for each message, in the
+	// order of the table columns
+	Headers []string `json:"headers,omitempty"`
+}
+
+// baseHeaders are the headers every listed message is fetched with
+var baseHeaders = []string{"Date", "From", "Subject"}
+
+// List returns the page of messages matching the filter that starts at
+// pageToken, or the first page when pageToken is empty. Only the Date, From
+// and Subject headers, and the additional headers, are fetched.
+func List(service *gmail.Service, filterConfig *filters.Config, pageSize int64, pageToken string, headers []string) (*Page, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	engine := retry.New(retry.Defaults())
+	query := filterConfig.BuildGmailQuery()
+
+	var resp *gmail.ListMessagesResponse
+	err := engine.Do(func() error {
+		req := service.Users.Messages.List("me").Q(query).MaxResults(pageSize).IncludeSpamTrash(filterConfig.IncludesSpamTrash())
+		if pageToken != "" {
+			req = req.PageToken(pageToken)
+		}
+		var callErr error
+		resp, callErr = req.Do()
+		return callErr
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	page := &Page{
+		Query:         query,
+		Messages:      make([]Message, 0, len(resp.Messages)),
+		NextPageToken: resp.NextPageToken,
+		Estimate:      resp.ResultSizeEstimate,
+		Headers:       headers,
+	}
+	if len(resp.Messages) == 0 {
+		return page, nil
+	}
+
+	var labels *gmail.ListLabelsResponse
+	err = engine.Do(func() error {
+		var callErr error
+		labels, callErr = service.Users.Labels.List("me").Do()
+		return callErr
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	labelNames := make(map[string]string, len(labels.Labels))
+	for _, label := range labels.Labels {
+		labelNames[label.Id] = label.Name
+	}
+
+	fetched := append(append([]string(nil), baseHeaders...), headers...)
+	for _, listed := range resp.Messages {
+		var message *gmail.Message
+		err := engine.Do(func() error {
+			var callErr error
+			message, callErr = service.Users.Messages.Get("me", listed.Id).
+				Format("metadata").
+				MetadataHeaders(fetched...).
+				Do()
+			return callErr
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message %s: %w", listed.Id, err)
+		}
+		page.Messages = append(page.Messages, fromMetadata(message, labelNames, headers))
+	}
+
+	return page, nil
+}
+
+// fromMetadata builds a listed message from a metadata format message,
+// naming its labels and recording the first value of each of headers. The
+// date is Gmail's internal date, which unlike the Date header is always set.
+func fromMetadata(message *gmail.Message, labelNames map[string]string, headers []string) Message {
+	listed := Message{
+		ID:     message.Id,
+		Date:   time.UnixMilli(message.InternalDate),
+		Size:   message.SizeEstimate,
+		Labels: make([]string, 0, len(message.LabelIds)),
+	}
+	if message.Payload != nil {
+		for _, header := range message.Payload.Headers {
+			switch strings.ToLower(header.Name) {
+			case "from":
+				listed.From = header.Value
+			case "subject":
+				listed.Subject = header.Value
+			}
+			for _, name := range headers {
+				if strings.EqualFold(header.Name, name) {
+					if _, ok := listed.Headers[name]; !ok {
+						if listed.Headers == nil {
+							listed.Headers = make(map[string]string)
+						}
+						listed.Headers[name] = header.Value
+					}
+				}
+			}
+		}
+	}
+	for _, id := range message.LabelIds {
+		if name, ok := labelNames[id]; ok {
+			listed.Labels = append(listed.Labels, name)
+		} else {
+			listed.Labels = append(listed.Labels, id)
+		}
+	}
+	sort.Strings(listed.Labels)
+	return listed
+}
+
+// WriteTable writes the page as a table, with a hint for fetching the next
+// page
+func WriteTable(w io.Writer, page *Page) error {
+	if len(page.Messages) == 0 {
+		_, err := fmt.Fprintln(w, "No matching messages")
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(table, "DATE\tFROM\tSUBJECT\tSIZE\tLABELS")
+	for _, name := range page.Headers {
+		fmt.Fprint(table, "\t"+strings.ToUpper(name))
+	}
+	fmt.Fprintln(table)
+	for _, message := range page.Messages {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s",
+			message.Date.Local().Format("2006-01-02 15:04"), Shorten(sender(message.From), 30),
+			Shorten(message.Subject, 50), FormatSize(message.Size), strings.Join(message.Labels, ","))
+		for _, name := range page.Headers {
+			fmt.Fprint(table, "\t"+Shorten(message.Headers[name], 40))
+		}
+		fmt.Fprintln(table)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nShowing %d of about %d matching messages\n", len(page.Messages), page.Estimate)
+	if page.NextPageToken != "" {
+		fmt.Fprintf(w, "Next page: --page-token %s\n", page.NextPageToken)
+	}
+	return nil
+}
+
+// WriteJSON writes the page as indented JSON
+func WriteJSON(w io.Writer, page *Page) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(page)
+}
+
+// sender returns the display name of a From header, or its address when it
+// has no name
+func sender(from string) string {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return from
+	}
+	if address.Name != "" {
+		return address.Name
+	}
+	return address.Address
+}
+
+// Shorten cuts text to at most max characters, marking the cut with "..."
+func Shorten(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// FormatSize formats a size in bytes for a table
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1024*1024*1024:
+		return fmt.Sprintf("%.1fGB", float64(size)/(1024*1024*1024))
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1fKB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
+
+// Count counts the messages matching the filter, stopping at limit. It
+// reports whether there are more than limit matches.
+func Count(service *gmail.Service, filterConfig *filters.Config, limit int) (int, bool, error) {
+	engine := retry.New(retry.Defaults())
+	query := filterConfig.BuildGmailQuery()
+
+	count, pageToken := 0, ""
+	for {
+		var resp *gmail.ListMessagesResponse
+		err := engine.Do(func() error {
+			req := service.Users.Messages.List("me").Q(query).MaxResults(500).IncludeSpamTrash(filterConfig.IncludesSpamTrash()).
+				Fields("messages/id", "nextPageToken")
+			if pageToken != "" {
+				req = req.PageToken(pageToken)
+			}
+			var callErr error
+			resp, callErr = req.Do()
+			return callErr
+		}, nil)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		count += len(resp.Messages)
+		if count > limit {
+			return limit, true, nil
+		}
+		if resp.NextPageToken == "" {
+			return count, false, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
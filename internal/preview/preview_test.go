@@ -0,0 +1,138 @@
+package preview
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestFromMetadata(t *testing.T) {
+	message := &gmail.Message{
+		Id:           "abc",
+		InternalDate: time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC).UnixMilli(),
+		SizeEstimate: 2048,
+		LabelIds:     []string{"INBOX", "Label_1"},
+		Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "From", Value: "Alice <alice@example.com>"},
+			{Name: "subject", Value: "Quarterly report"},
+		}},
+	}
+
+	listed := fromMetadata(message, map[string]string{"INBOX": "INBOX", "Label_1": "Work"}, nil)
+
+	if listed.ID != "abc" || listed.Size != 2048 {
+		t.Errorf("Expected id abc and size 2048, got %s and %d", listed.ID, listed.Size)
+	}
+	if !listed.Date.Equal(time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected the internal date, got %v", listed.Date)
+	}
+	if listed.From != "Alice <alice@example.com>" || listed.Subject != "Quarterly report" {
+		t.Errorf("Expected the From and Subject headers, got %q and %q", listed.From, listed.Subject)
+	}
+	if strings.Join(listed.Labels, ",") != "INBOX,Work" {
+		t.Errorf("Expected labels INBOX,Work, got %v", listed.Labels)
+	}
+}
+
+func TestFromMetadata_Headers(t *testing.T) {
+	message := &gmail.Message{
+		Id: "abc",
+		Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "From", Value: "Alice <alice@example.com>"},
+			{Name: "List-Id", Value: "<news.example.com>"},
+			{Name: "Received", Value: "from mx2"},
+			{Name: "Received", Value: "from mx1"},
+		}},
+	}
+
+	listed := fromMetadata(message, nil, []string{"list-id", "Received", "X-Mailer"})
+
+	if listed.Headers["list-id"] != "<news.example.com>" || listed.Headers["Received"] != "from mx2" {
+		t.Errorf("Expected the first value of each requested header, got %v", listed.Headers)
+	}
+	if _, ok := listed.Headers["X-Mailer"]; ok || len(listed.Headers) != 2 {
+		t.Errorf("Expected missing headers to be left out, got %v", listed.Headers)
+	}
+
+	var out bytes.Buffer
+	page := &Page{Messages: []Message{listed}, Headers: []string{"list-id"}}
+	if err := WriteTable(&out, page); err != nil {
+		t.Fatalf("WriteTable() failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "LIST-ID") || !strings.Contains(out.String(), "<news.example.com>") {
+		t.Errorf("Expected a column for the header, got:\n%s", out.String())
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	page := &Page{
+		Messages: []Message{{
+			Date:    time.Date(2024, 3, 1, 9, 30, 0, 0, time.Local),
+			From:    "Alice <alice@example.com>",
+			Subject: "Quarterly report",
+			Size:    1536,
+			Labels:  []string{"INBOX", "Work"},
+		}},
+		NextPageToken: "token-2",
+		Estimate:      40,
+	}
+
+	var out bytes.Buffer
+	if err := WriteTable(&out, page); err != nil {
+		t.Fatalf("WriteTable() failed: %v", err)
+	}
+
+	for _, want := range []string{"2024-03-01 09:30", "Alice", "Quarterly report", "1.5KB", "INBOX,Work",
+		"Showing 1 of about 40", "--page-token token-2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := WriteTable(&out, &Page{}); err != nil {
+		t.Fatalf("WriteTable() failed: %v", err)
+	}
+	if out.String() != "No matching messages\n" {
+		t.Errorf("Expected no matches message, got %q", out.String())
+	}
+}
+
+func TestShorten(t *testing.T) {
+	tests := []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly ten", 11, "exactly ten"},
+		{"a much longer subject", 10, "a much ..."},
+	}
+
+	for _, tt := range tests {
+		if got := Shorten(tt.text, tt.max); got != tt.want {
+			t.Errorf("Shorten(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{512, "512B"},
+		{1536, "1.5KB"},
+		{5 * 1024 * 1024, "5.0MB"},
+		{3 * 1024 * 1024 * 1024 / 2, "1.5GB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSize(tt.size); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
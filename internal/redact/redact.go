@@ -0,0 +1,155 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Built-in redaction rule names accepted by New
+const (
+	Emails      = "emails"
+	Phones      = "phones"
+	CreditCards = "credit-cards"
+)
+
+// rule replaces every match of pattern, optionally only when valid accepts it
+type rule struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string
+	valid       func(match string) bool
+}
+
+// builtins are the predefined rules. Credit cards are listed before phones
+// so long digit runs are not mistaken for phone numbers.
+var builtins = map[string]rule{
+	Emails: {
+		name:        Emails,
+		pattern:     regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+		replacement: "[REDACTED-EMAIL]",
+	},
+	CreditCards: {
+		name:        CreditCards,
+		pattern:     regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		replacement: "[REDACTED-CARD]",
+		valid:       luhnValid,
+	},
+	Phones: {
+		name:        Phones,
+		pattern:     regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{2,4}\)[\s.-]?|\b\d{2,4}[\s.-])?\b\d{3,4}[\s.-]\d{3,4}\b`),
+		replacement: "[REDACTED-PHONE]",
+	},
+}
+
+// builtinOrder is the order in which built-in rules are applied
+var builtinOrder = []string{Emails, CreditCards, Phones}
+
+// Redactor replaces personally identifiable information in text
+type Redactor struct {
+	rules []rule
+}
+
+// BuiltinNames returns the names of the built-in rules
+func BuiltinNames() []string {
+	names := append([]string(nil), builtinOrder...)
+	sort.Strings(names)
+	return names
+}
+
+// New creates a redactor from built-in rule names and custom regular
+// expressions. It returns nil if no rules are requested.
+func New(names []string, patterns []string) (*Redactor, error) {
+	requested := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := builtins[name]; !ok {
+			return nil, fmt.Errorf("unknown redaction rule: %s (valid: %s)", name, strings.Join(BuiltinNames(), ", "))
+		}
+		requested[name] = true
+	}
+
+	var rules []rule
+	for _, name := range builtinOrder {
+		if requested[name] {
+			rules = append(rules, builtins[name])
+		}
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		rules = append(rules, rule{name: "custom", pattern: compiled, replacement: "[REDACTED]"})
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	return &Redactor{rules: rules}, nil
+}
+
+// Redact returns s with every match replaced. A nil redactor returns s unchanged.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	for _, rl := range r.rules {
+		if rl.valid == nil {
+			s = rl.pattern.ReplaceAllLiteralString(s, rl.replacement)
+			continue
+		}
+		s = rl.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rl.valid(match) {
+				return rl.replacement
+			}
+			return match
+		})
+	}
+
+	return s
+}
+
+// RedactBytes is Redact for byte slices
+func (r *Redactor) RedactBytes(b []byte) []byte {
+	if r == nil {
+		return b
+	}
+	return []byte(r.Redact(string(b)))
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum := 0
+	double := false
+	digits := 0
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,73 @@
+package redact
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	r, err := New(nil, nil)
+	if err != nil || r != nil {
+		t.Errorf("Expected nil redactor without rules, got %v, %v", r, err)
+	}
+
+	if _, err := New([]string{"passports"}, nil); err == nil {
+		t.Error("Expected error for unknown rule")
+	}
+
+	if _, err := New(nil, []string{"("}); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+
+	if r, err := New([]string{" Emails "}, nil); err != nil || r == nil {
+		t.Errorf("Expected rule names to be case-insensitive, got %v, %v", r, err)
+	}
+}
+
+func TestRedactor_Redact(t *testing.T) {
+	r, err := New([]string{Emails, Phones, CreditCards}, []string{`ACME-\d+`})
+	if err != nil {
+		t.Fatalf("Failed to create redactor: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"email", "Contact alice.smith+news@mail.example.co.uk today", "Contact [REDACTED-EMAIL] today"},
+		{"phone", "Call +44 20 7946 0958 or (555) 123-4567", "Call [REDACTED-PHONE] or [REDACTED-PHONE]"},
+		{"valid card", "Card 4111 1111 1111 1111 expires", "Card [REDACTED-CARD] expires"},
+		{"card without spaces", "4111111111111111", "[REDACTED-CARD]"},
+		{"custom pattern", "Ticket ACME-12345 opened", "Ticket [REDACTED] opened"},
+		{"dashed phone", "Fax 555-123-4567.", "Fax [REDACTED-PHONE]."},
+		{"dates untouched", "Meeting on 2024-01-15 at 10:30", "Meeting on 2024-01-15 at 10:30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Redact(tt.in); got != tt.want {
+				t.Errorf("Redact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactor_Nil(t *testing.T) {
+	var r *Redactor
+	if got := r.Redact("alice@example.com"); got != "alice@example.com" {
+		t.Errorf("Expected nil redactor to leave input unchanged, got %q", got)
+	}
+	if got := string(r.RedactBytes([]byte("x"))); got != "x" {
+		t.Errorf("Expected nil redactor to leave bytes unchanged, got %q", got)
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	if !luhnValid("4111 1111 1111 1111") {
+		t.Error("Expected test Visa number to be valid")
+	}
+	if luhnValid("4111 1111 1111 1112") {
+		t.Error("Expected bad checksum to be invalid")
+	}
+	if luhnValid("0000") {
+		t.Error("Expected short numbers to be invalid")
+	}
+}
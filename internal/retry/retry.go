@@ -0,0 +1,223 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+)
+
+// Policy is how failures of one category are retried
+type Policy struct {
+	// Retries is the number of attempts after the first (0 = never retry)
+	Retries int `json:"retries" mapstructure:"retries"`
+	// Backoff is the wait before the first retry, doubled for each retry
+	// after it up to MaxBackoff (0 = no cap)
+	Backoff    time.Duration `json:"backoff" mapstructure:"backoff"`
+	MaxBackoff time.Duration `json:"max_backoff,omitempty" mapstructure:"max_backoff"`
+}
+
+// Policies maps failure categories to their retry policy. Failures of
+// categories without a policy are not retried.
+type Policies map[failure.Category]Policy
+
+// Defaults retries rate limits and server errors three times, starting at
+// one second
+func Defaults() Policies {
+	return Policies{
+		failure.RateLimit: {Retries: 3, Backoff: time.Second},
+		failure.Server:    {Retries: 3, Backoff: time.Second},
+	}
+}
+
+// categories lists the failure categories a policy can be set for
+var categories = []failure.Category{
+	failure.Auth, failure.RateLimit, failure.NotFound, failure.Network,
+	failure.Disk, failure.Server, failure.Unknown,
+}
+
+// Parse builds policies from settings keyed by category name, as read from
+// the retry section of the config file
+func Parse(settings map[string]Policy) (Policies, error) {
+	policies := make(Policies, len(settings))
+	for name, policy := range settings {
+		category := failure.Category(strings.ToLower(name))
+		if !isCategory(category) {
+			valid := make([]string, len(categories))
+			for i, c := range categories {
+				valid[i] = string(c)
+			}
+			return nil, fmt.Errorf("invalid retry category: %s (valid: %s)", name, strings.Join(valid, ", "))
+		}
+		if policy.Retries < 0 || policy.Backoff < 0 || policy.MaxBackoff < 0 {
+			return nil, fmt.Errorf("retry policy for %s must not be negative", category)
+		}
+		policies[category] = policy
+	}
+	return policies, nil
+}
+
+// isCategory reports whether category is a known failure category
+func isCategory(category failure.Category) bool {
+	for _, c := range categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns p with the policies of overrides replacing those of the
+// same categories
+func (p Policies) Merge(overrides Policies) Policies {
+	merged := make(Policies, len(p)+len(overrides))
+	for category, policy := range p {
+		merged[category] = policy
+	}
+	for category, policy := range overrides {
+		merged[category] = policy
+	}
+	return merged
+}
+
+// Delayer is implemented by errors that say how long to wait before trying
+// again, such as responses with a Retry-After header
+type Delayer interface {
+	RetryDelay() time.Duration
+}
+
+// Engine runs calls, retrying their failures under the policy of the
+// failure's category. A nil engine uses the default policies.
+type Engine struct {
+	policies Policies
+	sleep    func(time.Duration)
+	reauth   *reauthState
+}
+
+// reauthState runs an engine's reauth function once for all the calls
+// rejected by the same token
+type reauthState struct {
+	mu     sync.Mutex
+	reauth func() error
+	// generation counts successful reauths; a call rejected with the token
+	// of an older generation is retried without another reauth
+	generation uint64
+	// err is the error of a failed reauth, after which none is tried again
+	err error
+}
+
+// New creates an engine applying policies
+func New(policies Policies) *Engine {
+	return &Engine{policies: policies, sleep: time.Sleep}
+}
+
+// SetReauth makes the engine call reauth when the API rejects a call as
+// unauthorized, such as when the access token expires mid-run, and retry
+// the call once reauth succeeds. Calls rejected at the same time share one
+// reauth; once it fails, rejected calls fail without another.
+func (e *Engine) SetReauth(reauth func() error) {
+	e.reauth = &reauthState{reauth: reauth}
+}
+
+// Do runs call until it succeeds or the policy of its failure is exhausted,
+// returning the last error. onRetry, if non-nil, is called before each wait
+// so the retry can be recorded.
+func (e *Engine) Do(call func() error, onRetry func(err error, attempt int, wait time.Duration)) error {
+	policies, sleep := Defaults(), time.Sleep
+	var reauth *reauthState
+	if e != nil {
+		policies, sleep, reauth = e.policies, e.sleep, e.reauth
+	}
+
+	reauthed := false
+	for attempt := 0; ; attempt++ {
+		generation := reauth.current()
+		err := call()
+		if err == nil {
+			return nil
+		}
+
+		// The retry with a refreshed token does not count against the policy
+		if reauth != nil && !reauthed && isUnauthorized(err) {
+			reauthed = true
+			if reauthErr := reauth.run(generation); reauthErr != nil {
+				return fmt.Errorf("%w (reauthentication failed: %v)", err, reauthErr)
+			}
+			attempt--
+			continue
+		}
+
+		policy, ok := policies[failure.Categorize(err)]
+		if !ok || attempt >= policy.Retries {
+			return err
+		}
+
+		wait := policy.wait(attempt)
+		var delayer Delayer
+		if errors.As(err, &delayer) && delayer.RetryDelay() > wait {
+			wait = delayer.RetryDelay()
+		}
+
+		if onRetry != nil {
+			onRetry(err, attempt, wait)
+		}
+		sleep(wait)
+	}
+}
+
+// current returns the number of successful reauths so far
+func (r *reauthState) current() uint64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// run reauthenticates after a call made at generation was rejected, unless
+// another call has done so since. An error means the call cannot succeed.
+func (r *reauthState) run(generation uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.generation != generation {
+		return nil
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if err := r.reauth(); err != nil {
+		r.err = err
+		return err
+	}
+	r.generation++
+	return nil
+}
+
+// isUnauthorized reports whether err is an API rejection of the credentials
+// (HTTP 401), which a token refresh may cure
+func isUnauthorized(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized
+}
+
+// wait returns the backoff before retry attempt+1
+func (p Policy) wait(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 0; i < attempt; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,231 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+)
+
+// delayError is a failure asking for a wait before the next attempt
+type delayError struct {
+	delay time.Duration
+}
+
+func (e *delayError) Error() string             { return "throttled" }
+func (e *delayError) HTTPStatusCode() int       { return 429 }
+func (e *delayError) RetryDelay() time.Duration { return e.delay }
+
+// run runs a call failing with err on every attempt, returning the number
+// of attempts and the waits between them
+func run(engine *Engine, err error) (int, []time.Duration) {
+	var waits []time.Duration
+	engine.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	attempts := 0
+	_ = engine.Do(func() error {
+		attempts++
+		return err
+	}, nil)
+	return attempts, waits
+}
+
+func TestEngine_Defaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"rate limited", &googleapi.Error{Code: 429}, true},
+		{"server error", &googleapi.Error{Code: 503}, true},
+		{"wrapped server error", fmt.Errorf("failed: %w", &googleapi.Error{Code: 500}), true},
+		{
+			"user rate limit exceeded",
+			&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}},
+			true,
+		},
+		{"forbidden", &googleapi.Error{Code: 403}, false},
+		{"not found", &googleapi.Error{Code: 404}, false},
+		{"non API error", errors.New("disk full"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts, _ := run(New(Defaults()), tt.err)
+			if retried := attempts > 1; retried != tt.expected {
+				t.Errorf("Expected retried = %v, got %d attempts", tt.expected, attempts)
+			}
+		})
+	}
+}
+
+func TestEngine_Backoff(t *testing.T) {
+	engine := New(Policies{
+		failure.Server: {Retries: 4, Backoff: time.Second, MaxBackoff: 5 * time.Second},
+	})
+
+	attempts, waits := run(engine, &googleapi.Error{Code: 500})
+	if attempts != 5 {
+		t.Errorf("Expected 5 attempts, got %d", attempts)
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	if fmt.Sprint(waits) != fmt.Sprint(expected) {
+		t.Errorf("Expected waits %v, got %v", expected, waits)
+	}
+}
+
+func TestEngine_RetryDelay(t *testing.T) {
+	engine := New(Policies{failure.RateLimit: {Retries: 1, Backoff: time.Second}})
+
+	_, waits := run(engine, &delayError{delay: 30 * time.Second})
+	if len(waits) != 1 || waits[0] != 30*time.Second {
+		t.Errorf("Expected the requested 30s wait, got %v", waits)
+	}
+}
+
+func TestEngine_Succeeds(t *testing.T) {
+	engine := New(Defaults())
+	engine.sleep = func(time.Duration) {}
+
+	attempts := 0
+	var retries []int
+	err := engine.Do(func() error {
+		attempts++
+		if attempts < 3 {
+			return &googleapi.Error{Code: 503}
+		}
+		return nil
+	}, func(err error, attempt int, wait time.Duration) {
+		retries = append(retries, attempt)
+	})
+
+	if err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	if attempts != 3 || len(retries) != 2 {
+		t.Errorf("Expected 3 attempts and 2 retries, got %d and %v", attempts, retries)
+	}
+}
+
+func TestEngine_Reauth(t *testing.T) {
+	engine := New(Defaults())
+	var valid atomic.Bool
+	var reauths atomic.Int32
+	engine.SetReauth(func() error {
+		reauths.Add(1)
+		valid.Store(true)
+		return nil
+	})
+
+	// Every worker is rejected by the expired token before any reauth
+	const workers = 8
+	var rejected sync.WaitGroup
+	rejected.Add(workers)
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			first := true
+			errs <- engine.Do(func() error {
+				if first {
+					first = false
+					rejected.Done()
+					rejected.Wait()
+				}
+				if !valid.Load() {
+					return &googleapi.Error{Code: 401}
+				}
+				return nil
+			}, nil)
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected the call to succeed after reauthenticating, got %v", err)
+		}
+	}
+	if got := reauths.Load(); got != 1 {
+		t.Errorf("Expected one reauth for all workers, got %d", got)
+	}
+}
+
+func TestEngine_ReauthFails(t *testing.T) {
+	engine := New(Defaults())
+	reauths := 0
+	engine.SetReauth(func() error {
+		reauths++
+		return errors.New("invalid_grant")
+	})
+
+	for i := 0; i < 3; i++ {
+		attempts, _ := run(engine, &googleapi.Error{Code: 401})
+		if attempts != 1 {
+			t.Errorf("Expected no retry when reauthenticating fails, got %d attempts", attempts)
+		}
+	}
+	if reauths != 1 {
+		t.Errorf("Expected a failed reauth not to be tried again, got %d", reauths)
+	}
+
+	err := engine.Do(func() error { return &googleapi.Error{Code: 401} }, nil)
+	if failure.Categorize(err) != failure.Auth {
+		t.Errorf("Expected the failure to stay an auth failure, got %v", err)
+	}
+
+	// Forbidden is not cured by a refresh
+	forbidden := New(Defaults())
+	reauths = 0
+	forbidden.SetReauth(func() error {
+		reauths++
+		return nil
+	})
+	if attempts, _ := run(forbidden, &googleapi.Error{Code: 403}); attempts != 1 || reauths != 0 {
+		t.Errorf("Expected no reauth for a forbidden call, got %d attempts and %d reauths", attempts, reauths)
+	}
+}
+
+func TestParse(t *testing.T) {
+	policies, err := Parse(map[string]Policy{
+		"Rate_Limit": {Retries: 5, Backoff: 2 * time.Second},
+		"not_found":  {Retries: 0},
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if policies[failure.RateLimit].Retries != 5 {
+		t.Errorf("Expected 5 rate limit retries, got %+v", policies)
+	}
+
+	if _, err := Parse(map[string]Policy{"teapot": {Retries: 1}}); err == nil {
+		t.Error("Expected an unknown category to be rejected")
+	}
+	if _, err := Parse(map[string]Policy{"server": {Retries: -1}}); err == nil {
+		t.Error("Expected negative retries to be rejected")
+	}
+}
+
+func TestPolicies_Merge(t *testing.T) {
+	merged := Defaults().Merge(Policies{
+		failure.Server:  {Retries: 0},
+		failure.Network: {Retries: 2, Backoff: time.Second},
+	})
+
+	if merged[failure.Server].Retries != 0 {
+		t.Errorf("Expected server retries to be overridden, got %+v", merged[failure.Server])
+	}
+	if merged[failure.RateLimit].Retries != 3 {
+		t.Errorf("Expected the default rate limit policy to be kept, got %+v", merged[failure.RateLimit])
+	}
+	if _, ok := merged[failure.Network]; !ok {
+		t.Error("Expected a network policy to be added")
+	}
+	if Defaults()[failure.Server].Retries != 3 {
+		t.Error("Expected Merge to leave the defaults unchanged")
+	}
+}
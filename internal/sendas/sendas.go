@@ -0,0 +1,278 @@
+// Package sendas exports the send-as addresses of a mailbox, with their
+// display names, reply-to addresses and HTML signatures, and applies them
+// to another mailbox, so that users moved to a new account keep their
+// signatures.
+package sendas
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// Version identifies the format of an export file
+const Version = "gmail-exporter-send-as/v1"
+
+// DefaultFileName is the name of an export file
+const DefaultFileName = "send_as.json"
+
+// Results of applying a send-as address to a mailbox
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionSkipped   = "skipped"
+	ActionFailed    = "failed"
+)
+
+// Export is the send-as configuration of a mailbox
+type Export struct {
+	Version    string          `json:"version"`
+	Account    string          `json:"account"`
+	ExportedAt time.Time       `json:"exported_at"`
+	SendAs     []*gmail.SendAs `json:"send_as"`
+}
+
+// Fetch returns the send-as configuration of the mailbox of service
+func Fetch(service *gmail.Service) (*Export, error) {
+	profile, err := service.Users.GetProfile("me").Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	list, err := service.Users.Settings.SendAs.List("me").Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list send-as addresses: %w", err)
+	}
+
+	return &Export{
+		Version:    Version,
+		Account:    profile.EmailAddress,
+		ExportedAt: time.Now().UTC(),
+		SendAs:     list.SendAs,
+	}, nil
+}
+
+// Save writes the export to path
+func (e *Export) Save(path string) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode send-as export: %w", err)
+	}
+	if err := atomicfile.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write send-as export: %w", err)
+	}
+	return nil
+}
+
+// Load reads an export written by Save
+func Load(path string) (*Export, error) {
+	var export Export
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		export = Export{}
+		return json.Unmarshal(data, &export)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read send-as export: %w", err)
+	}
+	if export.Version != Version {
+		return nil, fmt.Errorf("%s is not a send-as export (version %q, want %q)", path, export.Version, Version)
+	}
+	return &export, nil
+}
+
+// Signatures returns how many send-as addresses of the export have a
+// signature
+func (e *Export) Signatures() int {
+	var count int
+	for _, alias := range e.SendAs {
+		if alias.Signature != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Options control how an export is applied to a mailbox
+type Options struct {
+	// DryRun reports what would change without changing anything
+	DryRun bool
+	// Delegated is set for a service account with domain-wide delegation.
+	// Gmail lets only those create send-as addresses and change any but the
+	// primary address.
+	Delegated bool
+}
+
+// Result is the outcome of applying one send-as address of an export
+type Result struct {
+	// Source is the address in the export
+	Source string `json:"source"`
+	// Address is the address in the destination mailbox: its primary
+	// address for the primary address of the export
+	Address string `json:"address"`
+	Action  string `json:"action"`
+	// Changed lists the settings created or updated
+	Changed []string `json:"changed,omitempty"`
+	// VerificationStatus is Gmail's verification of an address other than
+	// the primary one, "pending" until its owner follows the link Gmail
+	// sent to it
+	VerificationStatus string `json:"verification_status,omitempty"`
+	Detail             string `json:"detail,omitempty"`
+}
+
+// Import applies the send-as addresses of export to the mailbox of
+// service: the primary address of the export to the primary address of
+// the mailbox, and every other address to the same address of the mailbox,
+// which is created if missing. A failure to apply one address is reported
+// in its result and the others are still applied.
+func Import(service *gmail.Service, export *Export, options Options) ([]Result, error) {
+	list, err := service.Users.Settings.SendAs.List("me").Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list send-as addresses: %w", err)
+	}
+	var primary *gmail.SendAs
+	existing := make(map[string]*gmail.SendAs)
+	for _, alias := range list.SendAs {
+		existing[strings.ToLower(alias.SendAsEmail)] = alias
+		if alias.IsPrimary {
+			primary = alias
+		}
+	}
+
+	var results []Result
+	for _, source := range export.SendAs {
+		target := existing[strings.ToLower(source.SendAsEmail)]
+		if source.IsPrimary {
+			target = primary
+		}
+
+		var result Result
+		if target != nil {
+			result = update(service, source, target, options)
+		} else {
+			result = create(service, source, options)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// update applies the settings of source to the existing address target
+func update(service *gmail.Service, source, target *gmail.SendAs, options Options) Result {
+	result := Result{
+		Source:             source.SendAsEmail,
+		Address:            target.SendAsEmail,
+		Action:             ActionUnchanged,
+		VerificationStatus: target.VerificationStatus,
+	}
+
+	patch := &gmail.SendAs{}
+	if source.DisplayName != target.DisplayName {
+		patch.DisplayName = source.DisplayName
+		patch.ForceSendFields = append(patch.ForceSendFields, "DisplayName")
+		result.Changed = append(result.Changed, "display name")
+	}
+	if source.ReplyToAddress != target.ReplyToAddress {
+		patch.ReplyToAddress = source.ReplyToAddress
+		patch.ForceSendFields = append(patch.ForceSendFields, "ReplyToAddress")
+		result.Changed = append(result.Changed, "reply-to address")
+	}
+	if source.Signature != target.Signature {
+		patch.Signature = source.Signature
+		patch.ForceSendFields = append(patch.ForceSendFields, "Signature")
+		result.Changed = append(result.Changed, "signature")
+	}
+	// Gmail only makes an address the default, moving the default away
+	// from the others
+	if source.IsDefault && !target.IsDefault {
+		patch.IsDefault = true
+		result.Changed = append(result.Changed, "default")
+	}
+
+	switch {
+	case len(result.Changed) == 0:
+		return result
+	case !target.IsPrimary && !options.Delegated:
+		result.Action = ActionSkipped
+		result.Detail = "changing an address other than the primary one needs a service account with domain-wide delegation"
+		return result
+	}
+
+	result.Action = ActionUpdated
+	if options.DryRun {
+		return result
+	}
+	if _, err := service.Users.Settings.SendAs.Patch("me", target.SendAsEmail, patch).Do(); err != nil {
+		result.Action = ActionFailed
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// create adds source as a send-as address of the mailbox
+func create(service *gmail.Service, source *gmail.SendAs, options Options) Result {
+	result := Result{Source: source.SendAsEmail, Address: source.SendAsEmail, Action: ActionSkipped}
+
+	switch {
+	case !options.Delegated:
+		result.Detail = "creating a send-as address needs a service account with domain-wide delegation"
+		return result
+	case source.SmtpMsa != nil:
+		// Gmail never returns the password of the SMTP server
+		result.Detail = fmt.Sprintf("sends through %s, whose password is not exported; add it in Gmail's settings", source.SmtpMsa.Host)
+		return result
+	}
+
+	alias := &gmail.SendAs{
+		SendAsEmail:    source.SendAsEmail,
+		DisplayName:    source.DisplayName,
+		ReplyToAddress: source.ReplyToAddress,
+		Signature:      source.Signature,
+		IsDefault:      source.IsDefault,
+		TreatAsAlias:   source.TreatAsAlias,
+	}
+	result.Action = ActionCreated
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{"display name", alias.DisplayName != ""},
+		{"reply-to address", alias.ReplyToAddress != ""},
+		{"signature", alias.Signature != ""},
+		{"default", alias.IsDefault},
+	} {
+		if setting.set {
+			result.Changed = append(result.Changed, setting.name)
+		}
+	}
+	if options.DryRun {
+		return result
+	}
+
+	created, err := service.Users.Settings.SendAs.Create("me", alias).Do()
+	if err != nil {
+		result.Action = ActionFailed
+		result.Detail = err.Error()
+		return result
+	}
+	result.VerificationStatus = created.VerificationStatus
+	if created.VerificationStatus == "pending" {
+		result.Detail = "Gmail sent a verification email to " + created.SendAsEmail
+	}
+	return result
+}
+
+// Failed returns how many results are failures
+func Failed(results []Result) int {
+	var count int
+	for _, result := range results {
+		if result.Action == ActionFailed {
+			count++
+		}
+	}
+	return count
+}
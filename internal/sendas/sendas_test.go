@@ -0,0 +1,151 @@
+package sendas
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mockgmail"
+)
+
+// newService serves a mailbox and returns a Gmail client of it
+func newService(t *testing.T, mailbox *mockgmail.Server) *gmail.Service {
+	t.Helper()
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+
+	service, err := gmail.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("failed to create Gmail service: %v", err)
+	}
+	return service
+}
+
+// exportSource exports a mailbox with a signature on its primary address,
+// an alias and an alias sending through an SMTP server
+func exportSource(t *testing.T) *Export {
+	t.Helper()
+	service := newService(t, mockgmail.New("jane@old.example"))
+	sendAs := service.Users.Settings.SendAs
+
+	if _, err := sendAs.Patch("me", "jane@old.example", &gmail.SendAs{DisplayName: "Jane Doe", Signature: "<b>Jane</b>"}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	aliases := []*gmail.SendAs{
+		{SendAsEmail: "sales@new.example", DisplayName: "Sales", Signature: "<i>Sales</i>", IsDefault: true, TreatAsAlias: true},
+		{SendAsEmail: "jane@relay.example", SmtpMsa: &gmail.SmtpMsa{Host: "smtp.relay.example", Port: 587, Username: "jane", Password: "secret"}},
+	}
+	for _, alias := range aliases {
+		if _, err := sendAs.Create("me", alias).Do(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	export, err := Fetch(service)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	return export
+}
+
+func TestExport_SaveAndLoad(t *testing.T) {
+	export := exportSource(t)
+	if export.Account != "jane@old.example" || len(export.SendAs) != 3 || export.Signatures() != 2 {
+		t.Fatalf("Fetch() = %+v", export)
+	}
+
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+	if err := export.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.SendAs[0].Signature != "<b>Jane</b>" || loaded.SendAs[2].SmtpMsa.Host != "smtp.relay.example" {
+		t.Errorf("Load() = %+v", loaded.SendAs)
+	}
+	if loaded.SendAs[2].SmtpMsa.Password != "" {
+		t.Error("Expected no SMTP password in the export")
+	}
+
+	other := filepath.Join(t.TempDir(), "other.json")
+	if err := (&Export{Version: "something/v2"}).Save(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(other); err == nil {
+		t.Error("Expected a file of another version to be rejected")
+	}
+}
+
+func TestImport(t *testing.T) {
+	export := exportSource(t)
+
+	tests := []struct {
+		name    string
+		options Options
+		want    []string
+		aliases int
+	}{
+		{"dry run", Options{DryRun: true, Delegated: true}, []string{ActionUpdated, ActionCreated, ActionSkipped}, 1},
+		{"oauth", Options{}, []string{ActionUpdated, ActionSkipped, ActionSkipped}, 1},
+		{"delegated", Options{Delegated: true}, []string{ActionUpdated, ActionCreated, ActionSkipped}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailbox := mockgmail.New("jane@new.example")
+			service := newService(t, mailbox)
+
+			results, err := Import(service, export, tt.options)
+			if err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("Import() = %+v, want %v", results, tt.want)
+			}
+			for i, action := range tt.want {
+				if results[i].Action != action {
+					t.Errorf("Result %d = %+v, want %s", i, results[i], action)
+				}
+			}
+			if results[0].Address != "jane@new.example" {
+				t.Errorf("Expected the primary address to map to the destination's, got %s", results[0].Address)
+			}
+			if Failed(results) != 0 {
+				t.Errorf("Expected no failures, got %+v", results)
+			}
+
+			aliases := mailbox.SendAs()
+			if len(aliases) != tt.aliases {
+				t.Fatalf("SendAs() = %+v, want %d addresses", aliases, tt.aliases)
+			}
+			if tt.options.DryRun {
+				if aliases[0].Signature != "" {
+					t.Error("Expected a dry run to change nothing")
+				}
+				return
+			}
+			if aliases[0].Signature != "<b>Jane</b>" || aliases[0].DisplayName != "Jane Doe" {
+				t.Errorf("Expected the primary signature to be imported, got %+v", aliases[0])
+			}
+
+			// A second run finds nothing to do
+			again, err := Import(service, export, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again[0].Action != ActionUnchanged {
+				t.Errorf("Expected the primary address to be unchanged on a second run, got %+v", again[0])
+			}
+			if tt.options.Delegated && again[1].Action != ActionUnchanged {
+				t.Errorf("Expected the created alias to be unchanged on a second run, got %+v", again[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Package signing provides the Ed25519 signatures and hash chains that make
+// tampering with audit logs and custody manifests detectable. Keys are PEM
+// files as written by:
+//
+//	openssl genpkey -algorithm ed25519 -out signing.pem
+//	openssl pkey -in signing.pem -pubout -out signing.pub
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// Algorithm is the public key signature algorithm
+const Algorithm = "ed25519"
+
+// LoadPrivateKey reads a PKCS #8 PEM Ed25519 private key
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid signing key %s: not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// LoadPublicKey reads a PKIX PEM Ed25519 public key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key %s: not an Ed25519 key", path)
+	}
+	return public, nil
+}
+
+// readPEM reads the first PEM block of path, which must be of blockType
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("invalid key file %s: want a PEM %s", path, blockType)
+	}
+	return block, nil
+}
+
+// KeyID identifies a public key by the start of its SHA-256 fingerprint
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign returns the base64 Ed25519 signature of data
+func Sign(key ed25519.PrivateKey, data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+}
+
+// Verify checks a base64 Ed25519 signature of data
+func Verify(key ed25519.PublicKey, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// Hash returns the hex SHA-256 of data. A record holding the hash of the
+// record before it hashes into a chain that any edit, insertion or removal
+// breaks.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
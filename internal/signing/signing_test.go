@@ -0,0 +1,92 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKeysAndSign(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := writeKeyPair(t, t.TempDir(), public, private)
+
+	loadedPrivate, err := LoadPrivateKey(privatePath)
+	if err != nil {
+		t.Fatalf("LoadPrivateKey() error = %v", err)
+	}
+	loadedPublic, err := LoadPublicKey(publicPath)
+	if err != nil {
+		t.Fatalf("LoadPublicKey() error = %v", err)
+	}
+	if KeyID(loadedPublic) != KeyID(public) || len(KeyID(public)) != 16 {
+		t.Errorf("KeyID() = %q, want the fingerprint of the generated key", KeyID(loadedPublic))
+	}
+
+	signature := Sign(loadedPrivate, []byte("record"))
+	if err := Verify(loadedPublic, []byte("record"), signature); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := Verify(loadedPublic, []byte("edited"), signature); err == nil {
+		t.Error("Expected Verify() to reject edited data")
+	}
+	if err := Verify(loadedPublic, []byte("record"), "not base64!"); err == nil {
+		t.Error("Expected Verify() to reject a malformed signature")
+	}
+}
+
+func TestLoadKey_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := writeKeyPair(t, dir, public, private)
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPrivateKey(publicPath); err == nil {
+		t.Error("Expected LoadPrivateKey() to reject a public key")
+	}
+	if _, err := LoadPublicKey(privatePath); err == nil {
+		t.Error("Expected LoadPublicKey() to reject a private key")
+	}
+	if _, err := LoadPrivateKey(garbage); err == nil {
+		t.Error("Expected LoadPrivateKey() to reject a file without PEM")
+	}
+	if _, err := LoadPublicKey(filepath.Join(dir, "missing.pub")); err == nil {
+		t.Error("Expected LoadPublicKey() to fail on a missing file")
+	}
+}
+
+// writeKeyPair writes a PEM key pair to dir and returns the file paths
+func writeKeyPair(t *testing.T, dir string, public ed25519.PublicKey, private ed25519.PrivateKey) (string, string) {
+	t.Helper()
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, "signing.pem")
+	publicPath := filepath.Join(dir, "signing.pub")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return privatePath, publicPath
+}
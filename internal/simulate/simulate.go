@@ -0,0 +1,436 @@
+// Package simulate fabricates a Gmail mailbox behind an in-process HTTP
+// transport, failing some requests on purpose, so that metrics dashboards,
+// retry scripts and notification hooks can be tested without a Gmail
+// account. No request leaves the process.
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// Failures injected into message requests
+const (
+	// FailureRateLimit answers 429 for one to four attempts, so some
+	// messages recover on retry and some run out of retries
+	FailureRateLimit = "rate_limit"
+	// FailureServer answers 503 for one to four attempts
+	FailureServer = "server"
+	// FailureNotFound answers 404, as for a message deleted since the search
+	FailureNotFound = "not_found"
+)
+
+// Failures lists the supported failures
+var Failures = []string{FailureRateLimit, FailureServer, FailureNotFound}
+
+// Defaults of the simulated mailbox
+const (
+	DefaultMessages    = 100
+	DefaultFailureRate = 0.05
+)
+
+// Account is the email address of the simulated mailbox
+const Account = "simulated@example.com"
+
+// maxFailedAttempts is the most attempts a rate limit or server failure
+// fails before the message is served
+const maxFailedAttempts = 4
+
+// Config describes the simulated mailbox
+type Config struct {
+	// Messages is the number of fabricated messages, spread over the last
+	// two years (0 = DefaultMessages)
+	Messages int `json:"messages,omitempty"`
+	// FailureRate is the fraction of messages whose requests fail
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	// Failures are the failures injected, picked at random for each failing
+	// message (default: all)
+	Failures []string `json:"failures,omitempty"`
+	// Seed makes the mailbox and its failures repeatable
+	Seed uint64 `json:"seed,omitempty"`
+	// Latency is added to every request
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+// Validate checks the simulation settings and fills in the defaults
+func (c *Config) Validate() error {
+	if c.Messages < 0 {
+		return fmt.Errorf("simulated messages must be >= 0")
+	}
+	if c.Messages == 0 {
+		c.Messages = DefaultMessages
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return fmt.Errorf("simulated failure rate must be between 0 and 1")
+	}
+	if len(c.Failures) == 0 {
+		c.Failures = Failures
+	}
+	for _, failure := range c.Failures {
+		if !slices.Contains(Failures, failure) {
+			return fmt.Errorf("invalid simulated failure: %s (valid: %s)", failure, strings.Join(Failures, ", "))
+		}
+	}
+	if c.Latency < 0 {
+		return fmt.Errorf("simulated latency must be >= 0")
+	}
+	return nil
+}
+
+// NewGmailService returns a Gmail service backed by a simulated mailbox
+func NewGmailService(config Config) (*gmail.Service, error) {
+	transport, err := NewTransport(config, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return gmail.NewService(context.Background(), option.WithHTTPClient(&http.Client{Transport: transport}))
+}
+
+// Transport answers Gmail API requests from a simulated mailbox whose
+// newest message is dated now
+type Transport struct {
+	config   Config
+	messages []message
+	byID     map[string]int
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+// message is a fabricated message and the failure injected into it
+type message struct {
+	id           string
+	date         time.Time
+	labels       []string
+	headers      []*gmail.MessagePartHeader
+	body         string
+	failure      string
+	failAttempts int
+}
+
+// userLabels are the user labels of the simulated mailbox
+var userLabels = map[string]string{
+	"Label_1": "Simulated/Receipts",
+	"Label_2": "Simulated/Newsletters",
+	"Label_3": "Simulated/Projects",
+}
+
+var senders = []string{"Alice Example", "Bob Example", "Billing", "Newsletter", "Support", "Carol Example"}
+
+var subjects = []string{"Invoice", "Weekly update", "Meeting notes", "Your order has shipped", "Re: Project plan", "Reminder"}
+
+// NewTransport fabricates the mailbox of config, with its newest message
+// dated now
+func NewTransport(config Config, now time.Time) (*Transport, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	t := &Transport{
+		config:   config,
+		messages: make([]message, config.Messages),
+		byID:     make(map[string]int, config.Messages),
+		attempts: make(map[string]int),
+	}
+	spacing := 2 * 365 * 24 * time.Hour / time.Duration(config.Messages)
+	for i := range t.messages {
+		t.messages[i] = fabricate(config, i, now.Add(-time.Duration(i)*spacing).Truncate(time.Second))
+		t.byID[t.messages[i].id] = i
+	}
+	return t, nil
+}
+
+// fabricate makes the message at index i of the mailbox, newest first
+func fabricate(config Config, i int, date time.Time) message {
+	rng := rand.New(rand.NewPCG(config.Seed, uint64(i)))
+	m := message{id: fmt.Sprintf("%016x", 0x18f0000000000000+uint64(i)), date: date}
+
+	if rng.Float64() < 0.7 {
+		m.labels = append(m.labels, "INBOX")
+	}
+	if rng.Float64() < 0.3 {
+		m.labels = append(m.labels, "UNREAD")
+	}
+	if rng.Float64() < 0.05 {
+		m.labels = append(m.labels, "STARRED")
+	}
+	if rng.Float64() < 0.4 {
+		m.labels = append(m.labels, fmt.Sprintf("Label_%d", 1+rng.IntN(len(userLabels))))
+	}
+
+	sender := senders[rng.IntN(len(senders))]
+	address := strings.ToLower(strings.ReplaceAll(sender, " ", ".")) + "@example.com"
+	m.headers = []*gmail.MessagePartHeader{
+		{Name: "From", Value: fmt.Sprintf("%s <%s>", sender, address)},
+		{Name: "To", Value: Account},
+		{Name: "Subject", Value: fmt.Sprintf("%s #%d", subjects[rng.IntN(len(subjects))], i+1)},
+		{Name: "Date", Value: date.Format(time.RFC1123Z)},
+		{Name: "Message-ID", Value: "<" + m.id + "@simulated.invalid>"},
+		{Name: "MIME-Version", Value: "1.0"},
+		{Name: "Content-Type", Value: "text/plain; charset=UTF-8"},
+	}
+
+	var body strings.Builder
+	for lines := 5 + rng.IntN(200); lines > 0; lines-- {
+		body.WriteString("This message was fabricated by the gmail-exporter simulation.\r\n")
+	}
+	m.body = body.String()
+
+	if rng.Float64() < config.FailureRate {
+		m.failure = config.Failures[rng.IntN(len(config.Failures))]
+		m.failAttempts = 1 + rng.IntN(maxFailedAttempts)
+	}
+	return m
+}
+
+// raw returns the RFC 822 message
+func (m *message) raw() string {
+	var raw strings.Builder
+	for _, header := range m.headers {
+		raw.WriteString(header.Name + ": " + header.Value + "\r\n")
+	}
+	raw.WriteString("\r\n" + m.body)
+	return raw.String()
+}
+
+// RoundTrip answers a Gmail API request from the simulated mailbox
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	if t.config.Latency > 0 {
+		select {
+		case <-time.After(t.config.Latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/gmail/v1/users/me/")
+	query := req.URL.Query()
+	switch {
+	case req.Method != http.MethodGet:
+		return errorResponse(req, http.StatusMethodNotAllowed, "", "the simulated mailbox is read-only")
+	case path == "profile":
+		return jsonResponse(req, &gmail.Profile{EmailAddress: Account, MessagesTotal: int64(len(t.messages))})
+	case path == "labels":
+		return jsonResponse(req, t.listLabels())
+	case path == "messages":
+		return jsonResponse(req, t.listMessages(query))
+	case strings.HasPrefix(path, "messages/"):
+		return t.getMessage(req, strings.TrimPrefix(path, "messages/"), query)
+	default:
+		return errorResponse(req, http.StatusNotFound, "notFound", "not simulated: "+req.URL.Path)
+	}
+}
+
+// listLabels returns the system and user labels of the mailbox
+func (t *Transport) listLabels() *gmail.ListLabelsResponse {
+	response := &gmail.ListLabelsResponse{}
+	for _, id := range []string{"INBOX", "SENT", "TRASH", "SPAM", "UNREAD", "STARRED", "IMPORTANT"} {
+		response.Labels = append(response.Labels, &gmail.Label{Id: id, Name: id, Type: "system"})
+	}
+	for i := 1; i <= len(userLabels); i++ {
+		id := fmt.Sprintf("Label_%d", i)
+		response.Labels = append(response.Labels, &gmail.Label{Id: id, Name: userLabels[id], Type: "user"})
+	}
+	return response
+}
+
+// listMessages returns a page of the messages matching the after:, before:,
+// newer_than: and older_than: terms of the query; other terms are ignored
+func (t *Transport) listMessages(query map[string][]string) *gmail.ListMessagesResponse {
+	after, before := dateRange(first(query["q"]), t.messages)
+
+	var matching []*gmail.Message
+	for _, m := range t.messages {
+		if !m.date.Before(after) && m.date.Before(before) {
+			matching = append(matching, &gmail.Message{Id: m.id, ThreadId: m.id})
+		}
+	}
+
+	pageSize := 100
+	if size, err := strconv.Atoi(first(query["maxResults"])); err == nil && size > 0 {
+		pageSize = size
+	}
+	offset, _ := strconv.Atoi(first(query["pageToken"]))
+	offset = min(offset, len(matching))
+	end := min(offset+pageSize, len(matching))
+
+	response := &gmail.ListMessagesResponse{Messages: matching[offset:end], ResultSizeEstimate: int64(len(matching))}
+	if end < len(matching) {
+		response.NextPageToken = strconv.Itoa(end)
+	}
+	return response
+}
+
+// dateRange returns the date range selected by the date terms of query
+func dateRange(query string, messages []message) (after, before time.Time) {
+	before = time.Now().AddDate(100, 0, 0)
+	if len(messages) > 0 {
+		before = messages[0].date.Add(time.Second)
+	}
+	newest := before
+
+	for _, term := range strings.Fields(query) {
+		name, value, ok := strings.Cut(term, ":")
+		if !ok {
+			continue
+		}
+		var date time.Time
+		switch name {
+		case "after", "before":
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				date = time.Unix(seconds, 0)
+			} else if parsed, err := time.Parse("2006/01/02", value); err == nil {
+				date = parsed
+			} else {
+				continue
+			}
+		case "newer_than", "older_than":
+			days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+			if err != nil {
+				continue
+			}
+			date = newest.AddDate(0, 0, -days)
+		default:
+			continue
+		}
+		if name == "after" || name == "newer_than" {
+			after = date
+		} else {
+			before = date
+		}
+	}
+	return after, before
+}
+
+// getMessage answers messages.get in the requested format, failing the
+// request when a failure is injected into the message
+func (t *Transport) getMessage(req *http.Request, id string, query map[string][]string) (*http.Response, error) {
+	index, ok := t.byID[id]
+	if !ok {
+		return errorResponse(req, http.StatusNotFound, "notFound", "Requested entity was not found.")
+	}
+	m := &t.messages[index]
+
+	t.mu.Lock()
+	t.attempts[id]++
+	attempt := t.attempts[id]
+	t.mu.Unlock()
+
+	switch {
+	case m.failure == FailureNotFound:
+		return errorResponse(req, http.StatusNotFound, "notFound", "Requested entity was not found.")
+	case m.failure == FailureRateLimit && attempt <= m.failAttempts:
+		return errorResponse(req, http.StatusTooManyRequests, "rateLimitExceeded", "Too many concurrent requests for user.")
+	case m.failure == FailureServer && attempt <= m.failAttempts:
+		return errorResponse(req, http.StatusServiceUnavailable, "backendError", "The service is currently unavailable.")
+	}
+
+	raw := m.raw()
+	response := &gmail.Message{
+		Id:           m.id,
+		ThreadId:     m.id,
+		LabelIds:     m.labels,
+		SizeEstimate: int64(len(raw)),
+		InternalDate: m.date.UnixMilli(),
+		HistoryId:    uint64(index + 1),
+		Snippet:      strings.TrimSpace(strings.SplitN(m.body, "\r\n", 2)[0]),
+	}
+
+	switch first(query["format"]) {
+	case "raw":
+		response.Raw = base64.URLEncoding.EncodeToString([]byte(raw))
+	case "metadata":
+		response.Payload = &gmail.MessagePart{Headers: selectHeaders(m.headers, query["metadataHeaders"])}
+	case "minimal":
+	default:
+		response.Payload = &gmail.MessagePart{
+			MimeType: "text/plain",
+			Headers:  m.headers,
+			Body: &gmail.MessagePartBody{
+				Size: int64(len(m.body)),
+				Data: base64.URLEncoding.EncodeToString([]byte(m.body)),
+			},
+		}
+	}
+	return jsonResponse(req, response)
+}
+
+// selectHeaders returns the headers named in names, or all without names
+func selectHeaders(headers []*gmail.MessagePartHeader, names []string) []*gmail.MessagePartHeader {
+	if len(names) == 0 {
+		return headers
+	}
+	var selected []*gmail.MessagePartHeader
+	for _, header := range headers {
+		for _, name := range names {
+			if strings.EqualFold(header.Name, name) {
+				selected = append(selected, header)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// first returns the first of values, or ""
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// jsonResponse answers req with value as JSON
+func jsonResponse(req *http.Request, value any) (*http.Response, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return response(req, http.StatusOK, data), nil
+}
+
+// errorResponse answers req with a Gmail API error
+func errorResponse(req *http.Request, code int, reason, text string) (*http.Response, error) {
+	body := map[string]any{"error": map[string]any{
+		"code":    code,
+		"message": text,
+		"errors":  []map[string]string{{"reason": reason, "message": text}},
+	}}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return response(req, code, data), nil
+}
+
+// response builds an HTTP response to req
+func response(req *http.Request, code int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    code,
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json; charset=UTF-8"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
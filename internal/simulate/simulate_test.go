@@ -0,0 +1,145 @@
+package simulate
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// newService returns a Gmail service backed by a simulated mailbox
+func newService(t *testing.T, config Config) (*gmail.Service, *Transport) {
+	t.Helper()
+	transport, err := NewTransport(config, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, transport
+}
+
+func TestListMessages(t *testing.T) {
+	service, _ := newService(t, Config{Messages: 250})
+
+	var ids []string
+	err := service.Users.Messages.List("me").MaxResults(100).Pages(context.Background(), func(page *gmail.ListMessagesResponse) error {
+		for _, message := range page.Messages {
+			ids = append(ids, message.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(ids) != 250 || ids[0] == ids[249] {
+		t.Fatalf("Expected 250 distinct messages over three pages, got %d", len(ids))
+	}
+
+	// The messages span two years, so the last year holds about half
+	resp, err := service.Users.Messages.List("me").Q("after:2023/05/01 before:2024/05/02").MaxResults(500).Do()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if n := len(resp.Messages); n < 120 || n > 130 {
+		t.Errorf("Expected about half the messages in the last year, got %d", n)
+	}
+}
+
+func TestGetMessage(t *testing.T) {
+	service, _ := newService(t, Config{Messages: 10, Seed: 7})
+	list, err := service.Users.Messages.List("me").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := list.Messages[0].Id
+
+	raw, err := service.Users.Messages.Get("me", id).Format("raw").Do()
+	if err != nil {
+		t.Fatalf("Get(raw) error = %v", err)
+	}
+	data, err := base64.URLEncoding.DecodeString(raw.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Message-ID: <"+id+"@simulated.invalid>") || int64(len(data)) != raw.SizeEstimate {
+		t.Errorf("Unexpected raw message %q", data)
+	}
+
+	metadata, err := service.Users.Messages.Get("me", id).Format("metadata").MetadataHeaders("Subject").Do()
+	if err != nil {
+		t.Fatalf("Get(metadata) error = %v", err)
+	}
+	if headers := metadata.Payload.Headers; len(headers) != 1 || headers[0].Name != "Subject" {
+		t.Errorf("Expected only the Subject header, got %+v", headers)
+	}
+
+	// The same seed fabricates the same mailbox
+	again, _ := newService(t, Config{Messages: 10, Seed: 7})
+	other, err := again.Users.Messages.Get("me", id).Format("raw").Do()
+	if err != nil || other.Raw != raw.Raw {
+		t.Errorf("Expected the same message from the same seed, got error %v", err)
+	}
+}
+
+func TestInjectedFailures(t *testing.T) {
+	service, transport := newService(t, Config{Messages: 200, FailureRate: 1, Failures: []string{FailureRateLimit}})
+
+	for _, m := range transport.messages {
+		var failed int
+		for {
+			_, err := service.Users.Messages.Get("me", m.id).Format("minimal").Do()
+			if err == nil {
+				break
+			}
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+				t.Fatalf("Expected a rate limit error, got %v", err)
+			}
+			failed++
+		}
+		if failed != m.failAttempts || failed < 1 || failed > maxFailedAttempts {
+			t.Fatalf("Expected %d failed attempts, got %d", m.failAttempts, failed)
+		}
+	}
+
+	service, _ = newService(t, Config{Messages: 5, FailureRate: 1, Failures: []string{FailureNotFound}})
+	list, err := service.Users.Messages.List("me").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Users.Messages.Get("me", list.Messages[0].Id).Do()
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	config := Config{}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if config.Messages != DefaultMessages || len(config.Failures) != len(Failures) {
+		t.Errorf("Expected the defaults, got %+v", config)
+	}
+
+	for _, invalid := range []Config{
+		{Messages: -1},
+		{FailureRate: 1.5},
+		{Failures: []string{"disk"}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
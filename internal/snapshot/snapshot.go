@@ -0,0 +1,212 @@
+// Package snapshot manages versioned backups: each export run writes a new
+// timestamped snapshot directory, messages already in the previous snapshot
+// are hardlinked instead of downloaded again, and old snapshots are pruned
+// by daily, weekly and monthly retention.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+// NameLayout is the time layout of snapshot directory names, in UTC. It has
+// no colons so the names are valid on Windows.
+const NameLayout = "2006-01-02T150405Z"
+
+// Snapshot is a snapshot directory of a backup
+type Snapshot struct {
+	Name string    `json:"name"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+
+	// Exported is set when the snapshot recorded exported messages, which
+	// the next snapshot can link against, itself or in the subdirectories of
+	// a snapshot of several accounts
+	Exported bool `json:"exported"`
+}
+
+// List returns the snapshots in baseDir, oldest first. Entries whose name is
+// not a snapshot time are ignored, and a missing baseDir has no snapshots.
+func List(baseDir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(baseDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := time.Parse(NameLayout, entry.Name())
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(baseDir, entry.Name())
+		snapshots = append(snapshots, Snapshot{
+			Name:     entry.Name(),
+			Path:     path,
+			Time:     t,
+			Exported: hasExports(path),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// hasExports reports whether the snapshot at path recorded exported
+// messages, itself or, for a snapshot of several accounts, in an account
+// subdirectory
+func hasExports(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, exporter.ProcessedEmailsFile)); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(filepath.Join(path, "*", exporter.ProcessedEmailsFile))
+	return len(matches) > 0
+}
+
+// New creates the snapshot directory for a run started at now and returns
+// it with the newest earlier snapshot that recorded exported messages, or ""
+// when there is none
+func New(baseDir string, now time.Time) (dir, previous string, err error) {
+	snapshots, err := List(baseDir)
+	if err != nil {
+		return "", "", err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Exported {
+			previous = snapshots[i].Path
+			break
+		}
+	}
+
+	dir = filepath.Join(baseDir, now.UTC().Format(NameLayout))
+	if err := os.MkdirAll(baseDir, 0o750); err != nil {
+		return "", "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", "", fmt.Errorf("snapshot %s already exists", filepath.Base(dir))
+		}
+		return "", "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return dir, previous, nil
+}
+
+// Policy is how many snapshots to keep: the newest snapshot of each of the
+// last Daily days, Weekly ISO weeks and Monthly months that have snapshots,
+// in UTC like the snapshot names. Only snapshots that recorded exported
+// messages count, so failed runs never take the place of a good backup. A
+// snapshot kept by one rule may also be the one another rule keeps.
+type Policy struct {
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+}
+
+// Validate checks that the policy keeps something
+func (p Policy) Validate() error {
+	if p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 {
+		return fmt.Errorf("retention counts cannot be negative")
+	}
+	if p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 {
+		return fmt.Errorf("no retention given: set --keep-daily, --keep-weekly or --keep-monthly")
+	}
+	return nil
+}
+
+// Select splits snapshots, oldest first, into those the policy keeps and
+// those it removes. The newest snapshot, which may be of a run still going,
+// and the newest snapshot that recorded exported messages are always kept.
+func (p Policy) Select(snapshots []Snapshot) (keep, remove []Snapshot) {
+	kept := make(map[string]bool)
+	if len(snapshots) > 0 {
+		kept[snapshots[len(snapshots)-1].Name] = true
+	}
+	var exported []Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Exported {
+			exported = append(exported, snapshot)
+		}
+	}
+	if len(exported) > 0 {
+		kept[exported[len(exported)-1].Name] = true
+	}
+
+	rules := []struct {
+		count  int
+		period func(time.Time) string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, rule := range rules {
+		periods := make(map[string]bool)
+		for i := len(exported) - 1; i >= 0 && len(periods) < rule.count; i-- {
+			period := rule.period(exported[i].Time.UTC())
+			if periods[period] {
+				continue
+			}
+			periods[period] = true
+			kept[exported[i].Name] = true
+		}
+	}
+
+	for _, snapshot := range snapshots {
+		if kept[snapshot.Name] {
+			keep = append(keep, snapshot)
+		} else {
+			remove = append(remove, snapshot)
+		}
+	}
+	return keep, remove
+}
+
+// Prune removes the snapshots in baseDir the policy does not keep, and
+// returns the snapshots kept and removed. Snapshots under legal hold are
+// kept whatever the policy. With dryRun nothing is removed.
+func Prune(baseDir string, policy Policy, dryRun bool) (kept, removed []Snapshot, err error) {
+	if err := policy.Validate(); err != nil {
+		return nil, nil, err
+	}
+	snapshots, err := List(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept, remove := policy.Select(snapshots)
+	for _, snapshot := range remove {
+		hold, err := custody.CheckHold(snapshot.Path)
+		if err != nil {
+			return kept, removed, err
+		}
+		if hold != nil {
+			kept = append(kept, snapshot)
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(snapshot.Path); err != nil {
+				return kept, removed, fmt.Errorf("failed to remove snapshot %s: %w", snapshot.Name, err)
+			}
+		}
+		removed = append(removed, snapshot)
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Time.Before(kept[j].Time) })
+	return kept, removed, nil
+}
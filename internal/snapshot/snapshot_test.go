@@ -0,0 +1,183 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+	"github.com/octasoft-ltd/gmail-exporter/internal/exporter"
+)
+
+// makeSnapshots creates a snapshot directory for each time, recording
+// exported messages in all of them
+func makeSnapshots(t *testing.T, base string, times ...time.Time) {
+	t.Helper()
+	for _, at := range times {
+		dir := filepath.Join(base, at.UTC().Format(NameLayout))
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, exporter.ProcessedEmailsFile), []byte("[]"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func names(snapshots []Snapshot) string {
+	list := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		list = append(list, snapshot.Name)
+	}
+	return strings.Join(list, " ")
+}
+
+func day(year int, month time.Month, d, hour int) time.Time {
+	return time.Date(year, month, d, hour, 0, 0, 0, time.UTC)
+}
+
+func TestNew(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "backup")
+
+	dir, previous, err := New(base, day(2024, 3, 1, 2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if previous != "" || filepath.Base(dir) != "2024-03-01T020000Z" {
+		t.Errorf("Expected a first snapshot with no previous one, got %s, %q", dir, previous)
+	}
+
+	// A snapshot without processed emails, such as one that failed early,
+	// is not linked against
+	if err := os.WriteFile(filepath.Join(dir, exporter.ProcessedEmailsFile), []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	empty, _, err := New(base, day(2024, 3, 2, 2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(base, "not-a-snapshot"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	_, previous, err = New(base, day(2024, 3, 3, 2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if previous != dir {
+		t.Errorf("Expected the previous snapshot %s, got %q (not %s)", dir, previous, empty)
+	}
+
+	if _, _, err := New(base, day(2024, 3, 3, 2)); err == nil {
+		t.Error("Expected an error for an existing snapshot")
+	}
+
+	snapshots, err := List(base)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := names(snapshots); got != "2024-03-01T020000Z 2024-03-02T020000Z 2024-03-03T020000Z" {
+		t.Errorf("List() = %s", got)
+	}
+}
+
+func TestPolicySelect(t *testing.T) {
+	var snapshots []Snapshot
+	// Two snapshots a day from 2024-01-01 to 2024-03-31
+	for at := day(2024, 1, 1, 1); at.Before(day(2024, 4, 1, 0)); at = at.Add(12 * time.Hour) {
+		snapshots = append(snapshots, Snapshot{Name: at.Format(NameLayout), Time: at, Exported: true})
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{"daily", Policy{Daily: 3}, "2024-03-29T130000Z 2024-03-30T130000Z 2024-03-31T130000Z"},
+		// 2024-03-31 is a Sunday, closing ISO week 13
+		{"weekly", Policy{Weekly: 2}, "2024-03-24T130000Z 2024-03-31T130000Z"},
+		{"monthly", Policy{Monthly: 2}, "2024-02-29T130000Z 2024-03-31T130000Z"},
+		{"combined", Policy{Daily: 2, Weekly: 2, Monthly: 3},
+			"2024-01-31T130000Z 2024-02-29T130000Z 2024-03-24T130000Z 2024-03-30T130000Z 2024-03-31T130000Z"},
+		{"more than there are", Policy{Monthly: 12}, "2024-01-31T130000Z 2024-02-29T130000Z 2024-03-31T130000Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove := tt.policy.Select(snapshots)
+			if got := names(keep); got != tt.want {
+				t.Errorf("Select() kept %s, want %s", got, tt.want)
+			}
+			if len(keep)+len(remove) != len(snapshots) {
+				t.Errorf("Expected every snapshot to be kept or removed, got %d + %d of %d", len(keep), len(remove), len(snapshots))
+			}
+		})
+	}
+}
+
+func TestPolicySelect_Failed(t *testing.T) {
+	snapshots := []Snapshot{
+		{Name: "2024-03-01T020000Z", Time: day(2024, 3, 1, 2), Exported: true},
+		{Name: "2024-03-02T020000Z", Time: day(2024, 3, 2, 2)},
+		{Name: "2024-03-03T020000Z", Time: day(2024, 3, 3, 2)},
+		{Name: "2024-03-04T020000Z", Time: day(2024, 3, 4, 2)},
+	}
+
+	// Failed runs neither count towards the days kept nor push the only
+	// good backup out
+	keep, remove := Policy{Daily: 2}.Select(snapshots)
+	if got := names(keep); got != "2024-03-01T020000Z 2024-03-04T020000Z" {
+		t.Errorf("Select() kept %s, want the good backup and the newest snapshot", got)
+	}
+	if got := names(remove); got != "2024-03-02T020000Z 2024-03-03T020000Z" {
+		t.Errorf("Select() removed %s, want the older failed runs", got)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	if err := (Policy{}).Validate(); err == nil {
+		t.Error("Expected an error for a policy keeping nothing")
+	}
+	if err := (Policy{Daily: -1, Weekly: 1}).Validate(); err == nil {
+		t.Error("Expected an error for a negative count")
+	}
+	if err := (Policy{Weekly: 4}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	base := t.TempDir()
+	makeSnapshots(t, base, day(2024, 5, 1, 3), day(2024, 5, 2, 3), day(2024, 5, 3, 3), day(2024, 5, 4, 3))
+
+	// The oldest snapshot is under legal hold
+	held := filepath.Join(base, day(2024, 5, 1, 3).Format(NameLayout))
+	if err := custody.PlaceHold(held, custody.Hold{Operator: "counsel"}); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := Policy{Daily: 2}
+	kept, removed, err := Prune(base, policy, true)
+	if err != nil {
+		t.Fatalf("Prune() dry run error = %v", err)
+	}
+	if names(removed) != "2024-05-02T030000Z" {
+		t.Errorf("Expected only the unheld old snapshot to be removed, got %s", names(removed))
+	}
+	if _, err := os.Stat(filepath.Join(base, "2024-05-02T030000Z")); err != nil {
+		t.Error("Expected a dry run to leave the snapshot in place")
+	}
+
+	kept, removed, err = Prune(base, policy, false)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if names(kept) != "2024-05-01T030000Z 2024-05-03T030000Z 2024-05-04T030000Z" || len(removed) != 1 {
+		t.Errorf("Prune() kept %s, removed %s", names(kept), names(removed))
+	}
+	if _, err := os.Stat(filepath.Join(base, "2024-05-02T030000Z")); !os.IsNotExist(err) {
+		t.Error("Expected the pruned snapshot to be removed")
+	}
+}
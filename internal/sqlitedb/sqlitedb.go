@@ -0,0 +1,49 @@
+// Package sqlitedb opens the SQLite databases of the exporter, such as the
+// jobs database of the server, export catalogs and sync archives, with a
+// pure-Go driver so that builds need no cgo
+package sqlitedb
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// busyTimeout is how long, in milliseconds, a statement waits for another
+// process holding a lock on the database
+const busyTimeout = 5000
+
+// Open opens or creates the SQLite database at path, creating its directory,
+// and applies schema unless it is empty. Statements share one connection, so callers in
+// several goroutines never lock each other out.
+func Open(path, schema string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, busyTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if schema == "" {
+		return db, nil
+	}
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize database %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to restrict database %s: %w", path, err)
+	}
+	return db, nil
+}
@@ -0,0 +1,46 @@
+package sqlitedb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "test.db")
+	db, err := Open(path, `CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT)`)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO kv VALUES ('a', 'b')`); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The schema is applied again without losing rows
+	db, err = Open(path, `CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT)`)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	var value string
+	if err := db.QueryRow(`SELECT value FROM kv WHERE key = 'a'`).Scan(&value); err != nil || value != "b" {
+		t.Errorf("Expected the stored row, got %q, %v", value, err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected a private database file, got %v, %v", info.Mode(), err)
+	}
+}
+
+func TestOpen_NotADatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	if err := os.WriteFile(path, []byte("not a database, just some text that is long enough"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, `CREATE TABLE IF NOT EXISTS kv (key TEXT)`); err == nil {
+		t.Error("Expected an error for a file that is not a database")
+	}
+}
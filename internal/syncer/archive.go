@@ -0,0 +1,258 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+)
+
+// Archive formats
+const (
+	FormatMaildir = "maildir"
+	FormatMbox    = "mbox"
+	FormatSQLite  = "sqlite"
+)
+
+// mboxFileName is the mbox archive file name inside an archive directory
+const mboxFileName = "archive.mbox"
+
+// sqliteFileName is the SQLite archive file name inside an archive directory
+const sqliteFileName = "archive.db"
+
+// archive stores messages locally. Implementations fill in the location
+// fields of the entries they are given.
+type archive interface {
+	add(e *entry, raw []byte, date time.Time, labelNames []string) error
+	remove(e *entry) error
+	relabel(e *entry, labels, labelNames []string) error
+	// flush applies any pending changes, given every remaining entry
+	flush(entries func() ([]*entry, error)) ([]*entry, error)
+	close() error
+}
+
+// newArchive returns the archive for format rooted at dir
+func newArchive(format, dir string) (archive, error) {
+	switch format {
+	case FormatMaildir:
+		return newMaildir(dir)
+	case FormatMbox:
+		return &mboxArchive{path: filepath.Join(dir, mboxFileName)}, nil
+	case FormatSQLite:
+		return newSQLiteArchive(filepath.Join(dir, sqliteFileName))
+	default:
+		return nil, fmt.Errorf("invalid archive format: %s (valid: maildir, mbox, sqlite)", format)
+	}
+}
+
+// maildir stores one file per message in the cur directory, with read,
+// starred and draft state carried in the file name flags
+type maildir struct {
+	dir string
+}
+
+// newMaildir creates the maildir directory structure if needed
+func newMaildir(dir string) (*maildir, error) {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return &maildir{dir: dir}, nil
+}
+
+// add writes a message into tmp and moves it into cur, as maildir requires
+func (m *maildir) add(e *entry, raw []byte, date time.Time, _ []string) error {
+	base := fmt.Sprintf("%d.%s.gmail-exporter", date.Unix(), e.ID)
+	tmpPath := filepath.Join(m.dir, "tmp", base)
+
+	if err := os.WriteFile(tmpPath, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	e.File = base + ":2," + maildirFlags(e.Labels)
+	if err := os.Rename(tmpPath, filepath.Join(m.dir, "cur", e.File)); err != nil {
+		return fmt.Errorf("failed to move message into maildir: %w", err)
+	}
+
+	return nil
+}
+
+// remove deletes a message file
+func (m *maildir) remove(e *entry) error {
+	err := os.Remove(filepath.Join(m.dir, "cur", e.File))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+// relabel renames a message file to carry the flags of its new labels
+func (m *maildir) relabel(e *entry, labels, _ []string) error {
+	e.Labels = labels
+
+	base, _, _ := strings.Cut(e.File, ":2,")
+	file := base + ":2," + maildirFlags(labels)
+	if file != e.File {
+		if err := os.Rename(filepath.Join(m.dir, "cur", e.File), filepath.Join(m.dir, "cur", file)); err != nil {
+			return fmt.Errorf("failed to update message flags: %w", err)
+		}
+		e.File = file
+	}
+
+	return nil
+}
+
+// flush has nothing to do, as maildir changes are applied immediately
+func (m *maildir) flush(func() ([]*entry, error)) ([]*entry, error) {
+	return nil, nil
+}
+
+// close has nothing to release
+func (m *maildir) close() error {
+	return nil
+}
+
+// maildirFlags returns the maildir info flags for a set of Gmail labels, in
+// the required ASCII order
+func maildirFlags(labels []string) string {
+	has := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		has[label] = true
+	}
+
+	var flags []byte
+	if has["DRAFT"] {
+		flags = append(flags, 'D')
+	}
+	if has["STARRED"] {
+		flags = append(flags, 'F')
+	}
+	if !has["UNREAD"] {
+		flags = append(flags, 'S')
+	}
+	if has["TRASH"] {
+		flags = append(flags, 'T')
+	}
+	return string(flags)
+}
+
+// mboxArchive appends messages to a single mbox file. Each message carries an
+// X-Gmail-Labels header. Deletions are applied by rewriting the file once per
+// sync pass; label changes are recorded in the index only.
+type mboxArchive struct {
+	path    string
+	removed int
+}
+
+// add appends a message to the mbox file
+func (m *mboxArchive) add(e *entry, raw []byte, date time.Time, labelNames []string) error {
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open mbox archive: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat mbox archive: %w", err)
+	}
+
+	data := mboxMessage(e.ID, raw, labelNames, date)
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to append to mbox archive: %w", err)
+	}
+
+	e.Start = info.Size()
+	e.End = e.Start + int64(len(data))
+	return nil
+}
+
+// remove marks the mbox file for rewriting
+func (m *mboxArchive) remove(*entry) error {
+	m.removed++
+	return nil
+}
+
+// relabel records the new labels; the stored X-Gmail-Labels header keeps the
+// labels the message had when it was archived
+func (m *mboxArchive) relabel(e *entry, labels, _ []string) error {
+	e.Labels = labels
+	return nil
+}
+
+// flush rewrites the mbox file without deleted messages and returns the
+// entries with their new offsets
+func (m *mboxArchive) flush(entries func() ([]*entry, error)) ([]*entry, error) {
+	if m.removed == 0 {
+		return nil, nil
+	}
+
+	kept, err := entries()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Start < kept[j].Start })
+
+	src, err := os.Open(m.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mbox archive: %w", err)
+	}
+	defer src.Close()
+
+	var offset int64
+	err = atomicfile.Write(m.path, 0o600, atomicfile.Options{Sync: true}, func(dst io.Writer) error {
+		for _, e := range kept {
+			length := e.End - e.Start
+			if _, err := io.Copy(dst, io.NewSectionReader(src, e.Start, length)); err != nil {
+				return err
+			}
+			e.Start = offset
+			e.End = offset + length
+			offset = e.End
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite mbox archive: %w", err)
+	}
+
+	m.removed = 0
+	return kept, nil
+}
+
+// close has nothing to release, as each change opens the file anew
+func (m *mboxArchive) close() error {
+	return nil
+}
+
+// mboxMessage formats a message for an mbox file: a "From " separator line,
+// an X-Gmail-Labels header and the message with mboxrd quoting
+func mboxMessage(id string, raw []byte, labels []string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From %s@gmail-exporter %s\n", id, date.UTC().Format(time.ANSIC))
+	if len(labels) > 0 {
+		fmt.Fprintf(&buf, "X-Gmail-Labels: %s\n", strings.Join(labels, ","))
+	}
+
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			buf.WriteByte('>')
+		}
+		buf.Write(line)
+	}
+
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
@@ -0,0 +1,206 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// IndexFileName is the sync index file name inside an archive directory
+const IndexFileName = ".sync.db"
+
+var (
+	entriesBucket = []byte("entries")
+	pendingBucket = []byte("pending")
+	metaBucket    = []byte("meta")
+	historyKey    = []byte("history_id")
+	formatKey     = []byte("format")
+)
+
+// entry records where a synced message lives in the local archive
+type entry struct {
+	ID     string   `json:"id"`
+	Labels []string `json:"labels,omitempty"`
+
+	// File is the maildir file name of the message
+	File string `json:"file,omitempty"`
+	// Start and End are the byte offsets of the message in an mbox archive
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+}
+
+// index maps Gmail message IDs to archive locations and remembers the
+// History API checkpoint, backed by a bbolt database
+type index struct {
+	db *bolt.DB
+}
+
+// openIndex opens or creates the sync index at path
+func openIndex(path string) (*index, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sync index: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{entriesBucket, pendingBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize sync index: %w", err)
+	}
+
+	return &index{db: db}, nil
+}
+
+// Close closes the underlying database
+func (x *index) Close() error {
+	return x.db.Close()
+}
+
+// historyID returns the History API checkpoint, or 0 before the first sync
+func (x *index) historyID() (uint64, error) {
+	var id uint64
+	err := x.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(metaBucket).Get(historyKey)
+		if data == nil {
+			return nil
+		}
+		var err error
+		id, err = strconv.ParseUint(string(data), 10, 64)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read history ID: %w", err)
+	}
+	return id, nil
+}
+
+// setHistoryID stores the History API checkpoint
+func (x *index) setHistoryID(id uint64) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(historyKey, []byte(strconv.FormatUint(id, 10)))
+	})
+}
+
+// format returns the archive format the index was created for
+func (x *index) format() (string, error) {
+	var format string
+	err := x.db.View(func(tx *bolt.Tx) error {
+		format = string(tx.Bucket(metaBucket).Get(formatKey))
+		return nil
+	})
+	return format, err
+}
+
+// setFormat records the archive format
+func (x *index) setFormat(format string) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(formatKey, []byte(format))
+	})
+}
+
+// get returns the entry for a message ID, or nil if it is not archived
+func (x *index) get(id string) (*entry, error) {
+	var e *entry
+	err := x.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(entriesBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		e = &entry{}
+		return json.Unmarshal(data, e)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index entry for %s: %w", id, err)
+	}
+	return e, nil
+}
+
+// put stores or replaces entries in a single transaction
+func (x *index) put(entries ...*entry) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		for _, e := range entries {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("failed to marshal index entry for %s: %w", e.ID, err)
+			}
+			if err := bucket.Put([]byte(e.ID), data); err != nil {
+				return fmt.Errorf("failed to store index entry for %s: %w", e.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// remove deletes the entry for a message ID
+func (x *index) remove(id string) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).Delete([]byte(id))
+	})
+}
+
+// all returns every entry in the index
+func (x *index) all() ([]*entry, error) {
+	var entries []*entry
+	err := x.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(_, data []byte) error {
+			e := &entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync index: %w", err)
+	}
+	return entries, nil
+}
+
+// pending returns message IDs whose download failed and must be retried
+func (x *index) pending() ([]string, error) {
+	var ids []string
+	err := x.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(id, _ []byte) error {
+			ids = append(ids, string(id))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending messages: %w", err)
+	}
+	return ids, nil
+}
+
+// setPending marks or clears a message ID for retry
+func (x *index) setPending(id string, pending bool) error {
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pendingBucket)
+		if pending {
+			return bucket.Put([]byte(id), []byte("1"))
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// count returns the number of archived messages
+func (x *index) count() (int, error) {
+	var count int
+	err := x.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(entriesBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,97 @@
+package syncer
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+	"github.com/octasoft-ltd/gmail-exporter/internal/sqlitedb"
+)
+
+// sqliteSchema is the messages table of a SQLite archive: the raw message
+// with its sender, subject and date for queries, and its labels, kept up to
+// date, as a JSON array of display names
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS messages (
+	id        TEXT PRIMARY KEY,
+	date      TEXT NOT NULL,
+	date_unix INTEGER NOT NULL,
+	sender    TEXT NOT NULL,
+	subject   TEXT NOT NULL,
+	labels    TEXT NOT NULL,
+	size      INTEGER NOT NULL,
+	raw       BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_date ON messages (date_unix)`
+
+// sqliteArchive stores each message as a row of a SQLite database, so that
+// deletions and label changes are applied in place
+type sqliteArchive struct {
+	db *sql.DB
+}
+
+// newSQLiteArchive opens or creates the SQLite archive at path
+func newSQLiteArchive(path string) (*sqliteArchive, error) {
+	db, err := sqlitedb.Open(path, sqliteSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite archive: %w", err)
+	}
+	return &sqliteArchive{db: db}, nil
+}
+
+// add stores a message, replacing any earlier copy
+func (a *sqliteArchive) add(e *entry, raw []byte, date time.Time, labelNames []string) error {
+	labels, err := json.Marshal(labelNames)
+	if err != nil {
+		return fmt.Errorf("failed to marshal labels: %w", err)
+	}
+
+	var sender, subject string
+	if message, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		sender = mimepart.DecodeHeader(message.Header.Get("From"))
+		subject = mimepart.DecodeHeader(message.Header.Get("Subject"))
+	}
+
+	_, err = a.db.Exec(`INSERT OR REPLACE INTO messages (id, date, date_unix, sender, subject, labels, size, raw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, date.UTC().Format(time.RFC3339), date.Unix(), sender, subject, string(labels), len(raw), raw)
+	if err != nil {
+		return fmt.Errorf("failed to store message: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a message
+func (a *sqliteArchive) remove(e *entry) error {
+	if _, err := a.db.Exec(`DELETE FROM messages WHERE id = ?`, e.ID); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+// relabel replaces the labels of a message
+func (a *sqliteArchive) relabel(e *entry, labels, labelNames []string) error {
+	e.Labels = labels
+
+	names, err := json.Marshal(labelNames)
+	if err != nil {
+		return fmt.Errorf("failed to marshal labels: %w", err)
+	}
+	if _, err := a.db.Exec(`UPDATE messages SET labels = ? WHERE id = ?`, string(names), e.ID); err != nil {
+		return fmt.Errorf("failed to update message labels: %w", err)
+	}
+	return nil
+}
+
+// flush has nothing to do, as changes are applied immediately
+func (a *sqliteArchive) flush(func() ([]*entry, error)) ([]*entry, error) {
+	return nil, nil
+}
+
+// close closes the database
+func (a *sqliteArchive) close() error {
+	return a.db.Close()
+}
@@ -0,0 +1,719 @@
+package syncer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/auth"
+	"github.com/octasoft-ltd/gmail-exporter/internal/failure"
+	"github.com/octasoft-ltd/gmail-exporter/internal/labels"
+	"github.com/octasoft-ltd/gmail-exporter/internal/metrics"
+	"github.com/octasoft-ltd/gmail-exporter/internal/retry"
+)
+
+// indexBatchSize is how many newly archived messages are indexed per
+// transaction
+const indexBatchSize = 100
+
+// Config represents the sync configuration
+type Config struct {
+	CredentialsFile  string        `json:"credentials_file"`
+	TokenFile        string        `json:"token_file"`
+	AuthMode         string        `json:"auth_mode"`
+	ArchiveDir       string        `json:"archive_dir"`
+	Format           string        `json:"format"`
+	IncludeSpamTrash bool          `json:"include_spam_trash"`
+	ParallelWorkers  int           `json:"parallel_workers"`
+	Interval         time.Duration `json:"interval"`
+
+	// Retry overrides the retry policies of failure categories
+	Retry retry.Policies `json:"retry,omitempty"`
+}
+
+// Result represents the outcome of one sync pass
+type Result struct {
+	FullSync  bool          `json:"full_sync"`
+	Added     int           `json:"added"`
+	Deleted   int           `json:"deleted"`
+	Relabeled int           `json:"relabeled"`
+	Failed    int           `json:"failed"`
+	Archived  int           `json:"archived"`
+	HistoryID uint64        `json:"history_id"`
+	Duration  time.Duration `json:"duration"`
+	Failures  []Failure     `json:"failures,omitempty"`
+
+	// FailedByCategory counts failures by error category (auth, rate_limit, ...)
+	FailedByCategory map[string]int `json:"failed_by_category,omitempty"`
+}
+
+// Failure represents a message that could not be synced
+type Failure struct {
+	MessageID string    `json:"message_id"`
+	Category  string    `json:"category"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Syncer keeps a local archive in step with a Gmail mailbox
+type Syncer struct {
+	config        *Config
+	authenticator *auth.Authenticator
+	gmailService  *gmail.Service
+	index         *index
+	archive       archive
+	metrics       *metrics.Collector
+	retry         *retry.Engine
+	labels        *labels.Cache
+
+	// labelNames maps label IDs to display names for the current pass
+	labelNames map[string]string
+}
+
+// change accumulates what the History API reported for one message
+type change struct {
+	added   bool
+	deleted bool
+	labels  []string
+	// relabeled is set when labels holds the message's latest labels
+	relabeled bool
+}
+
+// New creates a new syncer instance
+func New(config *Config) (*Syncer, error) {
+	// Validate configuration
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Get Gmail service
+	authenticator, gmailService, err := auth.NewGmailService(config.AuthMode, auth.AccessReadonly, config.CredentialsFile, config.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	s, err := newSyncer(config, gmailService)
+	if err != nil {
+		return nil, err
+	}
+	s.authenticator = authenticator
+	if authenticator != nil {
+		s.retry.SetReauth(authenticator.Reauthenticate)
+	}
+
+	return s, nil
+}
+
+// newSyncer opens the archive and its index for an existing Gmail service
+func newSyncer(config *Config, gmailService *gmail.Service) (*Syncer, error) {
+	if err := os.MkdirAll(config.ArchiveDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	idx, err := openIndex(filepath.Join(config.ArchiveDir, IndexFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	// An archive keeps the format it was created with
+	format, err := idx.format()
+	if err == nil && format == "" {
+		err = idx.setFormat(config.Format)
+	} else if err == nil && format != config.Format {
+		err = fmt.Errorf("archive was created in %s format, not %s", format, config.Format)
+	}
+	if err != nil {
+		_ = idx.Close()
+		return nil, err
+	}
+
+	arch, err := newArchive(config.Format, config.ArchiveDir)
+	if err != nil {
+		_ = idx.Close()
+		return nil, err
+	}
+
+	s := &Syncer{
+		config:       config,
+		gmailService: gmailService,
+		index:        idx,
+		archive:      arch,
+		metrics:      metrics.NewCollector("sync"),
+		retry:        retry.New(retry.Defaults().Merge(config.Retry)),
+	}
+	s.labels = labels.New(s.listLabels, nil, labels.DefaultTTL)
+	return s, nil
+}
+
+// Close releases the sync index
+func (s *Syncer) Close() error {
+	err := s.archive.close()
+	if indexErr := s.index.Close(); err == nil {
+		err = indexErr
+	}
+	return err
+}
+
+// Run syncs once, or repeatedly every Interval until ctx is cancelled.
+// report is called after every pass. Errors end a one-shot run; in
+// continuous mode they are logged and retried at the next interval unless
+// re-authentication is needed.
+func (s *Syncer) Run(ctx context.Context, report func(*Result)) error {
+	for {
+		result, err := s.Sync()
+		switch {
+		case err == nil:
+			report(result)
+		case s.config.Interval == 0 || failure.Categorize(err) == failure.Auth:
+			return err
+		default:
+			logrus.WithError(err).Error("Sync pass failed, retrying at the next interval")
+		}
+
+		if s.config.Interval == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.config.Interval):
+		}
+	}
+}
+
+// Sync performs one sync pass: a full listing on the first run, then only
+// the changes reported by the History API since the previous pass
+func (s *Syncer) Sync() (*Result, error) {
+	startTime := time.Now()
+	s.metrics.Start()
+
+	historyID, err := s.index.historyID()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadLabelNames(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Failures: make([]Failure, 0)}
+
+	if historyID != 0 {
+		err = s.incrementalSync(historyID, result)
+
+		// Gmail keeps history for a limited time; start over when it has expired
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			logrus.WithField("history_id", historyID).Warn("Sync history expired, running a full sync")
+			historyID = 0
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if historyID == 0 {
+		result.FullSync = true
+		if err := s.fullSync(result); err != nil {
+			return nil, err
+		}
+	}
+
+	// Apply pending archive changes such as mbox deletions
+	updated, err := s.archive.flush(s.index.all)
+	if err != nil {
+		return nil, err
+	}
+	if len(updated) > 0 {
+		if err := s.index.put(updated...); err != nil {
+			return nil, fmt.Errorf("failed to update sync index: %w", err)
+		}
+	}
+
+	if err := s.index.setHistoryID(result.HistoryID); err != nil {
+		return nil, fmt.Errorf("failed to save history ID: %w", err)
+	}
+
+	result.Archived, _ = s.index.count()
+	result.Duration = time.Since(startTime)
+	s.metrics.RecordDuration(result.Duration)
+	if err := s.metrics.Save(filepath.Join(s.config.ArchiveDir, "sync_metrics.json")); err != nil {
+		logrus.WithError(err).Warn("Failed to save metrics")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"full_sync": result.FullSync,
+		"added":     result.Added,
+		"deleted":   result.Deleted,
+		"relabeled": result.Relabeled,
+		"failed":    result.Failed,
+		"archived":  result.Archived,
+		"duration":  result.Duration,
+	}).Info("Sync pass completed")
+
+	return result, nil
+}
+
+// fullSync lists every message in the mailbox, downloads the ones missing
+// locally and removes local messages no longer in Gmail
+func (s *Syncer) fullSync(result *Result) error {
+	// Take the checkpoint before listing so changes made meanwhile are picked
+	// up by the next pass
+	var profile *gmail.Profile
+	err := s.callAPI("users.getProfile", func() error {
+		var callErr error
+		profile, callErr = s.gmailService.Users.GetProfile("me").Do()
+		return callErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get mailbox profile: %w", err)
+	}
+	result.HistoryID = profile.HistoryId
+
+	remote := make(map[string]bool)
+	pageToken := ""
+	for {
+		req := s.gmailService.Users.Messages.List("me").Q("-in:chats").
+			IncludeSpamTrash(s.config.IncludeSpamTrash).MaxResults(500)
+		if pageToken != "" {
+			req = req.PageToken(pageToken)
+		}
+
+		var resp *gmail.ListMessagesResponse
+		err := s.callAPI("messages.list", func() error {
+			var callErr error
+			resp, callErr = req.Do()
+			return callErr
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		for _, message := range resp.Messages {
+			remote[message.Id] = true
+		}
+
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	local, err := s.index.all()
+	if err != nil {
+		return err
+	}
+
+	archived := make(map[string]bool, len(local))
+	for _, e := range local {
+		archived[e.ID] = true
+		if !remote[e.ID] {
+			s.removeMessage(e, result)
+		}
+	}
+
+	var missing []string
+	for id := range remote {
+		if !archived[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"remote":  len(remote),
+		"local":   len(local),
+		"missing": len(missing),
+	}).Info("Full sync listing complete")
+
+	return s.downloadMessages(missing, result)
+}
+
+// incrementalSync applies the changes reported by the History API since
+// historyID, along with downloads left pending by earlier passes
+func (s *Syncer) incrementalSync(historyID uint64, result *Result) error {
+	changes := make(map[string]*change)
+	var order []string
+	changeFor := func(id string) *change {
+		c, ok := changes[id]
+		if !ok {
+			c = &change{}
+			changes[id] = c
+			order = append(order, id)
+		}
+		return c
+	}
+
+	result.HistoryID = historyID
+	pageToken := ""
+	for {
+		req := s.gmailService.Users.History.List("me").StartHistoryId(historyID).MaxResults(500)
+		if pageToken != "" {
+			req = req.PageToken(pageToken)
+		}
+
+		var resp *gmail.ListHistoryResponse
+		err := s.callAPI("history.list", func() error {
+			var callErr error
+			resp, callErr = req.Do()
+			return callErr
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list history: %w", err)
+		}
+
+		for _, record := range resp.History {
+			for _, added := range record.MessagesAdded {
+				c := changeFor(added.Message.Id)
+				c.added, c.deleted = true, false
+			}
+			for _, deleted := range record.MessagesDeleted {
+				changeFor(deleted.Message.Id).deleted = true
+			}
+			for _, labeled := range record.LabelsAdded {
+				c := changeFor(labeled.Message.Id)
+				c.labels, c.relabeled = labeled.Message.LabelIds, true
+			}
+			for _, unlabeled := range record.LabelsRemoved {
+				c := changeFor(unlabeled.Message.Id)
+				c.labels, c.relabeled = unlabeled.Message.LabelIds, true
+			}
+		}
+
+		if resp.HistoryId > result.HistoryID {
+			result.HistoryID = resp.HistoryId
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	var download []string
+	for _, id := range order {
+		c := changes[id]
+
+		e, err := s.index.get(id)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case e != nil && (c.deleted || (c.relabeled && !s.mirrored(c.labels))):
+			s.removeMessage(e, result)
+		case e != nil && c.relabeled:
+			s.relabelMessage(e, c.labels, result)
+		case e == nil && !c.deleted && (c.added || c.relabeled):
+			download = append(download, id)
+		}
+	}
+
+	pending, err := s.index.pending()
+	if err != nil {
+		return err
+	}
+	download = append(download, pending...)
+
+	logrus.WithFields(logrus.Fields{
+		"changes":  len(changes),
+		"download": len(download),
+	}).Info("Collected mailbox changes")
+
+	return s.downloadMessages(download, result)
+}
+
+// mirrored reports whether a message with these labels belongs in the archive
+func (s *Syncer) mirrored(labels []string) bool {
+	for _, label := range labels {
+		switch label {
+		case "CHAT":
+			return false
+		case "SPAM", "TRASH":
+			if !s.config.IncludeSpamTrash {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// removeMessage deletes a message from the archive and the index
+func (s *Syncer) removeMessage(e *entry, result *Result) {
+	if err := s.archive.remove(e); err != nil {
+		s.recordFailure(result, e.ID, err)
+		return
+	}
+	if err := s.index.remove(e.ID); err != nil {
+		s.recordFailure(result, e.ID, err)
+		return
+	}
+	result.Deleted++
+}
+
+// relabelMessage records new labels for an archived message
+func (s *Syncer) relabelMessage(e *entry, labels []string, result *Result) {
+	if err := s.archive.relabel(e, labels, s.labelNamesOf(labels)); err != nil {
+		s.recordFailure(result, e.ID, err)
+		return
+	}
+	if err := s.index.put(e); err != nil {
+		s.recordFailure(result, e.ID, err)
+		return
+	}
+	result.Relabeled++
+}
+
+// fetchResult is a downloaded message or the error fetching it
+type fetchResult struct {
+	id      string
+	message *gmail.Message
+	err     error
+}
+
+// downloadMessages fetches messages in parallel and adds them to the archive.
+// Messages that fail are kept pending and retried on the next pass.
+func (s *Syncer) downloadMessages(ids []string, result *Result) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.metrics.AddMatched(len(ids))
+
+	workers := s.config.ParallelWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	jobs := make(chan string)
+	results := make(chan fetchResult, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				message, err := s.fetchMessage(id)
+				results <- fetchResult{id: id, message: message, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, id := range ids {
+			jobs <- id
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var batch []*entry
+	flushBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.index.put(batch...); err != nil {
+			return fmt.Errorf("failed to update sync index: %w", err)
+		}
+		for _, e := range batch {
+			_ = s.index.setPending(e.ID, false)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	var indexErr error
+	for res := range results {
+		if indexErr != nil {
+			continue // drain the workers
+		}
+
+		var apiErr *googleapi.Error
+		switch {
+		case errors.As(res.err, &apiErr) && apiErr.Code == http.StatusNotFound:
+			// Deleted since the change was reported
+			_ = s.index.setPending(res.id, false)
+			continue
+		case res.err != nil:
+			s.recordFailure(result, res.id, res.err)
+			_ = s.index.setPending(res.id, true)
+			continue
+		case !s.mirrored(res.message.LabelIds):
+			_ = s.index.setPending(res.id, false)
+			continue
+		}
+
+		e, size, err := s.storeMessage(res.message)
+		if err != nil {
+			s.recordFailure(result, res.id, err)
+			_ = s.index.setPending(res.id, true)
+			continue
+		}
+
+		result.Added++
+		s.metrics.AddExported(1)
+		s.metrics.AddBytes(size)
+
+		if batch = append(batch, e); len(batch) >= indexBatchSize {
+			indexErr = flushBatch()
+		}
+	}
+
+	if indexErr != nil {
+		return indexErr
+	}
+	return flushBatch()
+}
+
+// fetchMessage downloads a message in raw format
+func (s *Syncer) fetchMessage(id string) (*gmail.Message, error) {
+	var message *gmail.Message
+	err := s.callAPI("messages.get", func() error {
+		var callErr error
+		message, callErr = s.gmailService.Users.Messages.Get("me", id).Format("raw").Do()
+		return callErr
+	})
+	return message, err
+}
+
+// storeMessage adds a downloaded message to the archive
+func (s *Syncer) storeMessage(message *gmail.Message) (*entry, int64, error) {
+	raw, err := base64.URLEncoding.DecodeString(padBase64(message.Raw))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode message: %w", err)
+	}
+
+	e := &entry{ID: message.Id, Labels: message.LabelIds}
+	if err := s.archive.add(e, raw, time.UnixMilli(message.InternalDate), s.labelNamesOf(message.LabelIds)); err != nil {
+		return nil, 0, err
+	}
+
+	return e, int64(len(raw)), nil
+}
+
+// labelNamesOf returns the display names of label IDs, keeping the IDs of
+// labels without one
+func (s *Syncer) labelNamesOf(ids []string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name, ok := s.labelNames[id]; ok {
+			id = name
+		}
+		names = append(names, id)
+	}
+	return names
+}
+
+// loadLabelNames fetches the display names of the mailbox labels, reusing
+// those of recent passes
+func (s *Syncer) loadLabelNames() error {
+	names, err := s.labels.Names()
+	if err != nil {
+		return err
+	}
+	s.labelNames = names
+	return nil
+}
+
+// listLabels fetches the labels of the mailbox for the label cache
+func (s *Syncer) listLabels() ([]*gmail.Label, error) {
+	var resp *gmail.ListLabelsResponse
+	err := s.callAPI("labels.list", func() error {
+		var callErr error
+		resp, callErr = s.gmailService.Users.Labels.List("me").Do()
+		return callErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Labels, nil
+}
+
+// recordFailure records a message that could not be synced
+func (s *Syncer) recordFailure(result *Result, id string, err error) {
+	category := string(failure.Categorize(err))
+
+	result.Failed++
+	if result.FailedByCategory == nil {
+		result.FailedByCategory = make(map[string]int)
+	}
+	result.FailedByCategory[category]++
+	result.Failures = append(result.Failures, Failure{
+		MessageID: id,
+		Category:  category,
+		Error:     err.Error(),
+		Timestamp: time.Now(),
+	})
+
+	s.metrics.AddFailed(1)
+	s.metrics.RecordFailure(id, category, err.Error())
+	logrus.WithError(err).WithField("message_id", id).Error("Failed to sync message")
+}
+
+// callAPI runs a single Gmail API call, recording its latency and retrying
+// failures under the retry policy of their category
+func (s *Syncer) callAPI(method string, call func() error) error {
+	return s.retry.Do(func() error {
+		start := time.Now()
+		err := call()
+		s.metrics.RecordAPICall(method, time.Since(start), err)
+		return err
+	}, func(err error, attempt int, wait time.Duration) {
+		s.metrics.RecordRetry(method)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"method":  method,
+			"attempt": attempt + 1,
+			"backoff": wait,
+		}).Debug("Retrying Gmail API call")
+	})
+}
+
+// padBase64 restores the padding Gmail omits from base64url data
+func padBase64(data string) string {
+	if n := len(data) % 4; n != 0 {
+		data += strings.Repeat("=", 4-n)
+	}
+	return data
+}
+
+// validateConfig validates the sync configuration
+func validateConfig(config *Config) error {
+	if err := auth.ValidateMode(config.AuthMode); err != nil {
+		return err
+	}
+
+	if config.ArchiveDir == "" {
+		return fmt.Errorf("archive directory is required")
+	}
+
+	switch config.Format {
+	case "":
+		config.Format = FormatMaildir
+	case FormatMaildir, FormatMbox, FormatSQLite:
+	default:
+		return fmt.Errorf("invalid format: %s (valid: maildir, mbox, sqlite)", config.Format)
+	}
+
+	if config.ParallelWorkers < 0 {
+		return fmt.Errorf("parallel workers must be >= 0")
+	}
+
+	if config.Interval < 0 {
+		return fmt.Errorf("interval must be >= 0")
+	}
+
+	return nil
+}
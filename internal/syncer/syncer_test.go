@@ -0,0 +1,323 @@
+package syncer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeMailbox serves the subset of the Gmail API used by the syncer
+type fakeMailbox struct {
+	mu        sync.Mutex
+	messages  map[string][]string // ID -> label IDs
+	historyID uint64
+	history   []*gmail.History
+}
+
+func (f *fakeMailbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/")
+	var response any
+
+	switch {
+	case path == "profile":
+		response = &gmail.Profile{HistoryId: f.historyID}
+	case path == "labels":
+		response = &gmail.ListLabelsResponse{Labels: []*gmail.Label{{Id: "Label_1", Name: "Work"}}}
+	case path == "history":
+		response = &gmail.ListHistoryResponse{History: f.history, HistoryId: f.historyID}
+	case path == "messages":
+		list := &gmail.ListMessagesResponse{}
+		for id := range f.messages {
+			list.Messages = append(list.Messages, &gmail.Message{Id: id})
+		}
+		response = list
+	case strings.HasPrefix(path, "messages/"):
+		id := strings.TrimPrefix(path, "messages/")
+		labels, ok := f.messages[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":404,"message":"Not Found"}}`))
+			return
+		}
+		raw := "Subject: " + id + "\r\n\r\nFrom the body of " + id + "\r\n"
+		response = &gmail.Message{
+			Id:           id,
+			LabelIds:     labels,
+			InternalDate: 1700000000000,
+			Raw:          base64.RawURLEncoding.EncodeToString([]byte(raw)),
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newTestSyncer(t *testing.T, mailbox *fakeMailbox, format string) (*Syncer, string) {
+	t.Helper()
+
+	server := httptest.NewServer(mailbox)
+	t.Cleanup(server.Close)
+
+	service, err := gmail.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("failed to create Gmail service: %v", err)
+	}
+
+	dir := t.TempDir()
+	config := &Config{ArchiveDir: dir, Format: format, ParallelWorkers: 2}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+
+	s, err := newSyncer(config, service)
+	if err != nil {
+		t.Fatalf("newSyncer() error = %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s, dir
+}
+
+func maildirFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cur"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSync_Maildir(t *testing.T) {
+	mailbox := &fakeMailbox{
+		messages: map[string][]string{
+			"m1": {"INBOX", "UNREAD"},
+			"m2": {"Label_1"},
+			"c1": {"CHAT"},
+		},
+		historyID: 100,
+	}
+	s, dir := newTestSyncer(t, mailbox, FormatMaildir)
+
+	// First pass lists everything
+	result, err := s.Sync()
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !result.FullSync || result.Added != 2 || result.HistoryID != 100 {
+		t.Fatalf("first pass = %+v", result)
+	}
+
+	want := []string{
+		"1700000000.m1.gmail-exporter:2,",
+		"1700000000.m2.gmail-exporter:2,S",
+	}
+	if got := maildirFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+
+	// Second pass applies only the reported changes
+	mailbox.mu.Lock()
+	delete(mailbox.messages, "m1")
+	mailbox.messages["m2"] = []string{"Label_1", "STARRED"}
+	mailbox.messages["m3"] = []string{"INBOX"}
+	mailbox.history = []*gmail.History{
+		{MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: &gmail.Message{Id: "m1"}}}},
+		{LabelsAdded: []*gmail.HistoryLabelAdded{{Message: &gmail.Message{Id: "m2", LabelIds: []string{"Label_1", "STARRED"}}}}},
+		{MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "m3"}}}},
+	}
+	mailbox.historyID = 105
+	mailbox.mu.Unlock()
+
+	result, err = s.Sync()
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.FullSync || result.Added != 1 || result.Deleted != 1 || result.Relabeled != 1 || result.HistoryID != 105 {
+		t.Fatalf("second pass = %+v", result)
+	}
+
+	want = []string{
+		"1700000000.m2.gmail-exporter:2,FS",
+		"1700000000.m3.gmail-exporter:2,S",
+	}
+	if got := maildirFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+
+	// Moving a message to the trash removes it from the archive
+	mailbox.mu.Lock()
+	mailbox.history = []*gmail.History{
+		{LabelsAdded: []*gmail.HistoryLabelAdded{{Message: &gmail.Message{Id: "m3", LabelIds: []string{"TRASH"}}}}},
+	}
+	mailbox.mu.Unlock()
+
+	result, err = s.Sync()
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Deleted != 1 || result.Archived != 1 {
+		t.Errorf("third pass = %+v", result)
+	}
+}
+
+func TestSync_Mbox(t *testing.T) {
+	mailbox := &fakeMailbox{
+		messages:  map[string][]string{"m1": {"INBOX"}, "m2": {"Label_1"}},
+		historyID: 100,
+	}
+	s, dir := newTestSyncer(t, mailbox, FormatMbox)
+
+	if _, err := s.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	mailbox.mu.Lock()
+	delete(mailbox.messages, "m1")
+	mailbox.history = []*gmail.History{
+		{MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: &gmail.Message{Id: "m1"}}}},
+	}
+	mailbox.mu.Unlock()
+
+	if _, err := s.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, mboxFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	if strings.Contains(content, "Subject: m1") {
+		t.Error("deleted message still in mbox")
+	}
+	if !strings.Contains(content, "X-Gmail-Labels: Work\nSubject: m2\n\n>From the body of m2\n") {
+		t.Errorf("unexpected mbox content:\n%s", content)
+	}
+
+	// Offsets in the index still point at the rewritten message
+	e, err := s.index.get("m2")
+	if err != nil || e == nil {
+		t.Fatalf("index entry missing: %v", err)
+	}
+	if e.Start != 0 || e.End != int64(len(data)) {
+		t.Errorf("entry offsets = [%d,%d), file size %d", e.Start, e.End, len(data))
+	}
+}
+
+func TestSync_SQLite(t *testing.T) {
+	mailbox := &fakeMailbox{
+		messages:  map[string][]string{"m1": {"INBOX"}, "m2": {"INBOX"}},
+		historyID: 100,
+	}
+	s, _ := newTestSyncer(t, mailbox, FormatSQLite)
+
+	if _, err := s.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	mailbox.mu.Lock()
+	delete(mailbox.messages, "m1")
+	mailbox.messages["m2"] = []string{"INBOX", "Label_1"}
+	mailbox.history = []*gmail.History{
+		{MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: &gmail.Message{Id: "m1"}}}},
+		{LabelsAdded: []*gmail.HistoryLabelAdded{{Message: &gmail.Message{Id: "m2", LabelIds: []string{"INBOX", "Label_1"}}}}},
+	}
+	mailbox.historyID = 105
+	mailbox.mu.Unlock()
+
+	result, err := s.Sync()
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Deleted != 1 || result.Relabeled != 1 {
+		t.Fatalf("second pass = %+v", result)
+	}
+
+	// Deletions and label changes are applied to the rows in place
+	db := s.archive.(*sqliteArchive).db
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected one message left, got %d, %v", count, err)
+	}
+	var subject, labels, date string
+	var raw []byte
+	err = db.QueryRow(`SELECT subject, labels, date, raw FROM messages WHERE id = 'm2'`).Scan(&subject, &labels, &date, &raw)
+	if err != nil {
+		t.Fatalf("Failed to read m2: %v", err)
+	}
+	if subject != "m2" || labels != `["INBOX","Work"]` || date != "2023-11-14T22:13:20Z" {
+		t.Errorf("m2 = %q %s %s, want its subject, new label names and date", subject, labels, date)
+	}
+	if !strings.Contains(string(raw), "From the body of m2") {
+		t.Errorf("Expected the raw message, got %q", raw)
+	}
+}
+
+func TestNewSyncer_FormatMismatch(t *testing.T) {
+	mailbox := &fakeMailbox{historyID: 1}
+	s, dir := newTestSyncer(t, mailbox, FormatMaildir)
+	_ = s.Close()
+
+	config := &Config{ArchiveDir: dir, Format: FormatMbox}
+	if _, err := newSyncer(config, s.gmailService); err == nil {
+		t.Error("expected error when reopening an archive in a different format")
+	}
+}
+
+func TestMboxMessage(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := string(mboxMessage("abc", []byte("Subject: x\r\n\r\nFrom me\r\n>From you"), []string{"INBOX", "Work"}, date))
+	want := "From abc@gmail-exporter Tue Jan  2 03:04:05 2024\n" +
+		"X-Gmail-Labels: INBOX,Work\n" +
+		"Subject: x\n\n>From me\n>>From you\n\n"
+	if got != want {
+		t.Errorf("mboxMessage() = %q, want %q", got, want)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"defaults", Config{ArchiveDir: "archive"}, false},
+		{"missing dir", Config{}, true},
+		{"bad format", Config{ArchiveDir: "archive", Format: "pst"}, true},
+		{"negative interval", Config{ArchiveDir: "archive", Interval: -time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(&tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package triage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Indicator types
+const (
+	IndicatorURL    = "url"
+	IndicatorDomain = "domain"
+	IndicatorIPv4   = "ipv4"
+	IndicatorIPv6   = "ipv6"
+	IndicatorSHA256 = "sha256"
+)
+
+// Indicator is an indicator of compromise found in one or more messages
+type Indicator struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// Name is the attachment file name of a file hash
+	Name string `json:"name,omitempty"`
+	// Messages lists the IDs of the messages the indicator was found in
+	Messages  []string  `json:"messages"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+}
+
+// IOCSet collects the indicators of a set of messages
+type IOCSet struct {
+	indicators map[string]*Indicator
+}
+
+// NewIOCSet creates an empty indicator set
+func NewIOCSet() *IOCSet {
+	return &IOCSet{indicators: make(map[string]*Indicator)}
+}
+
+// ExtractIOCs walks the eml files of an export directory and collects the
+// URLs, domains and IP addresses and the attachment hashes they contain
+func ExtractIOCs(dir string) (*IOCSet, error) {
+	set := NewIOCSet()
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.ToLower(filepath.Ext(path)) != ".eml" {
+			return nil
+		}
+
+		// Messages copied into several label directories are read once
+		id := strings.TrimSuffix(d.Name(), filepath.Ext(path))
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := set.Add(id, raw); err != nil {
+			logrus.WithError(err).WithField("file", path).Warn("Skipping unreadable message")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan export directory: %w", err)
+	}
+
+	return set, nil
+}
+
+// Add collects the indicators of a raw RFC 822 message: URLs in its text
+// parts and their hosts, the domains of its sender addresses, the public
+// addresses of the servers it was relayed through and the SHA-256 of each
+// attachment
+func (s *IOCSet) Add(id string, raw []byte) error {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	date, _ := parsed.Header.Date()
+	add := func(kind, value, name string) {
+		s.add(kind, value, name, id, date.UTC())
+	}
+
+	for _, field := range []string{"From", "Reply-To", "Return-Path"} {
+		addresses, err := parsed.Header.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if at := strings.LastIndexByte(address.Address, '@'); at >= 0 {
+				add(IndicatorDomain, strings.ToLower(address.Address[at+1:]), "")
+			}
+		}
+	}
+
+	for _, received := range parsed.Header["Received"] {
+		for _, match := range bracketIPPattern.FindAllStringSubmatch(received, -1) {
+			if ip := net.ParseIP(match[1]); ip != nil && isPublicIP(ip) {
+				add(ipIndicatorType(ip), ip.String(), "")
+			}
+		}
+	}
+
+	walkParts(parsed.Header, parsed.Body, 0, func(part bodyPart) {
+		if part.attachment {
+			sum := sha256.Sum256(part.content)
+			add(IndicatorSHA256, hex.EncodeToString(sum[:]), part.filename)
+			return
+		}
+		if !strings.HasPrefix(part.mediaType, "text/") {
+			return
+		}
+
+		for _, link := range findURLs(part.content) {
+			add(IndicatorURL, link, "")
+			host := urlHost(link)
+			if ip := net.ParseIP(host); ip != nil {
+				add(ipIndicatorType(ip), ip.String(), "")
+			} else if host != "" {
+				add(IndicatorDomain, host, "")
+			}
+		}
+	})
+
+	return nil
+}
+
+// add records an indicator found in message id
+func (s *IOCSet) add(kind, value, name, id string, date time.Time) {
+	key := kind + "\x00" + value
+	indicator, ok := s.indicators[key]
+	if !ok {
+		indicator = &Indicator{Type: kind, Value: value, Name: name, FirstSeen: date}
+		s.indicators[key] = indicator
+	}
+	if indicator.Name == "" {
+		indicator.Name = name
+	}
+	if !date.IsZero() && (indicator.FirstSeen.IsZero() || date.Before(indicator.FirstSeen)) {
+		indicator.FirstSeen = date
+	}
+	if n := len(indicator.Messages); n == 0 || indicator.Messages[n-1] != id {
+		indicator.Messages = append(indicator.Messages, id)
+	}
+}
+
+// Indicators returns the indicators ordered by type and value
+func (s *IOCSet) Indicators() []Indicator {
+	indicators := make([]Indicator, 0, len(s.indicators))
+	for _, indicator := range s.indicators {
+		indicators = append(indicators, *indicator)
+	}
+	sort.Slice(indicators, func(i, j int) bool {
+		if indicators[i].Type != indicators[j].Type {
+			return indicators[i].Type < indicators[j].Type
+		}
+		return indicators[i].Value < indicators[j].Value
+	})
+	return indicators
+}
+
+// isPublicIP reports whether ip is a routable public address
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast()
+}
+
+// ipIndicatorType returns the indicator type of ip
+func ipIndicatorType(ip net.IP) string {
+	if ip.To4() != nil {
+		return IndicatorIPv4
+	}
+	return IndicatorIPv6
+}
+
+// iocCSVHeader is the header row of the CSV indicator list
+var iocCSVHeader = []string{"type", "value", "name", "messages", "first_seen", "message_ids"}
+
+// WriteCSV writes a row per indicator
+func (s *IOCSet) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(iocCSVHeader); err != nil {
+		return err
+	}
+
+	for _, indicator := range s.Indicators() {
+		firstSeen := ""
+		if !indicator.FirstSeen.IsZero() {
+			firstSeen = indicator.FirstSeen.Format(time.RFC3339)
+		}
+		row := []string{
+			indicator.Type,
+			indicator.Value,
+			indicator.Name,
+			strconv.Itoa(len(indicator.Messages)),
+			firstSeen,
+			strings.Join(indicator.Messages, " "),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// stixNamespace seeds the deterministic identifiers of STIX objects, so the
+// same indicator keeps its ID across runs
+const stixNamespace = "gmail-exporter/ioc"
+
+// stixBundle is a STIX 2.1 bundle
+type stixBundle struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Objects []stixIndicator `json:"objects"`
+}
+
+// stixIndicator is a STIX 2.1 indicator object
+type stixIndicator struct {
+	Type          string   `json:"type"`
+	SpecVersion   string   `json:"spec_version"`
+	ID            string   `json:"id"`
+	Created       string   `json:"created"`
+	Modified      string   `json:"modified"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	IndicatorType []string `json:"indicator_types"`
+	Pattern       string   `json:"pattern"`
+	PatternType   string   `json:"pattern_type"`
+	ValidFrom     string   `json:"valid_from"`
+}
+
+// WriteSTIX writes the indicators as a STIX 2.1 bundle created at now
+func (s *IOCSet) WriteSTIX(w io.Writer, now time.Time) error {
+	timestamp := now.UTC().Format("2006-01-02T15:04:05.000Z")
+	bundle := stixBundle{Type: "bundle", ID: "bundle--" + stixUUID(timestamp), Objects: []stixIndicator{}}
+
+	for _, indicator := range s.Indicators() {
+		validFrom := timestamp
+		if !indicator.FirstSeen.IsZero() {
+			validFrom = indicator.FirstSeen.Format("2006-01-02T15:04:05.000Z")
+		}
+
+		object := stixIndicator{
+			Type:          "indicator",
+			SpecVersion:   "2.1",
+			ID:            "indicator--" + stixUUID(indicator.Type+"\x00"+indicator.Value),
+			Created:       timestamp,
+			Modified:      timestamp,
+			Name:          fmt.Sprintf("%s %s", indicator.Type, indicator.Value),
+			IndicatorType: []string{"suspicious-activity"},
+			Pattern:       stixPattern(indicator),
+			PatternType:   "stix",
+			ValidFrom:     validFrom,
+		}
+		if indicator.Name != "" {
+			object.Description = "Attachment " + indicator.Name
+		}
+		bundle.Objects = append(bundle.Objects, object)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bundle)
+}
+
+// stixPattern returns the STIX pattern matching an indicator
+func stixPattern(indicator Indicator) string {
+	value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(indicator.Value)
+	switch indicator.Type {
+	case IndicatorURL:
+		return fmt.Sprintf("[url:value = '%s']", value)
+	case IndicatorDomain:
+		return fmt.Sprintf("[domain-name:value = '%s']", value)
+	case IndicatorIPv4:
+		return fmt.Sprintf("[ipv4-addr:value = '%s']", value)
+	case IndicatorIPv6:
+		return fmt.Sprintf("[ipv6-addr:value = '%s']", value)
+	default:
+		return fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", value)
+	}
+}
+
+// stixUUID returns a name-based (version 5 style) UUID for name
+func stixUUID(name string) string {
+	sum := sha1.Sum([]byte(stixNamespace + "\x00" + name))
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,141 @@
+package triage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const attachmentMessage = "Received: from relay.example.net ([198.51.100.20]) by mx.google.com\r\n" +
+	"Received: from laptop ([192.168.1.10]) by relay.example.net\r\n" +
+	"From: Invoices <billing@invoices.example>\r\n" +
+	"Subject: Invoice\r\n" +
+	"Date: Tue, 5 Mar 2024 09:00:00 +0000\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"mix\"\r\n" +
+	"\r\n" +
+	"--mix\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Pay at http://203.0.113.50/pay or https://pay.invoices.example/now\r\n" +
+	"--mix\r\n" +
+	"Content-Type: application/octet-stream; name=\"invoice.exe\"\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.exe\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8=\r\n" +
+	"--mix--\r\n"
+
+func TestIOCSet_Add(t *testing.T) {
+	set := NewIOCSet()
+	if err := set.Add("m1", []byte(attachmentMessage)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	found := make(map[string]Indicator)
+	for _, indicator := range set.Indicators() {
+		found[indicator.Type+" "+indicator.Value] = indicator
+	}
+
+	expected := []string{
+		"domain invoices.example",
+		"domain pay.invoices.example",
+		"ipv4 198.51.100.20",
+		"ipv4 203.0.113.50",
+		"url http://203.0.113.50/pay",
+		"url https://pay.invoices.example/now",
+		// SHA-256 of "hello"
+		"sha256 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}
+	for _, key := range expected {
+		if _, ok := found[key]; !ok {
+			t.Errorf("Expected indicator %q, got %v", key, set.Indicators())
+		}
+	}
+	if _, ok := found["ipv4 192.168.1.10"]; ok {
+		t.Error("Expected private relay addresses to be left out")
+	}
+	if hash := found["sha256 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"]; hash.Name != "invoice.exe" {
+		t.Errorf("Expected attachment name, got %q", hash.Name)
+	}
+}
+
+func TestExtractIOCs_CountsMessages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"m1.eml", "m2.eml", filepath.Join("Label", "m1.eml")} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(attachmentMessage), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, err := ExtractIOCs(dir)
+	if err != nil {
+		t.Fatalf("ExtractIOCs failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := set.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows[1:] {
+		if row[3] != "2" {
+			t.Errorf("Expected %s %s in 2 messages, got %s", row[0], row[1], row[3])
+		}
+	}
+}
+
+func TestWriteSTIX(t *testing.T) {
+	set := NewIOCSet()
+	if err := set.Add("m1", []byte(attachmentMessage)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := set.WriteSTIX(&buf, time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("WriteSTIX failed: %v", err)
+	}
+
+	var bundle stixBundle
+	if err := json.Unmarshal(buf.Bytes(), &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Type != "bundle" || len(bundle.Objects) != len(set.Indicators()) {
+		t.Fatalf("Unexpected bundle: %+v", bundle)
+	}
+
+	patterns := make(map[string]bool)
+	for _, object := range bundle.Objects {
+		if !strings.HasPrefix(object.ID, "indicator--") || object.SpecVersion != "2.1" {
+			t.Errorf("Unexpected indicator object: %+v", object)
+		}
+		patterns[object.Pattern] = true
+	}
+	if !patterns["[domain-name:value = 'invoices.example']"] {
+		t.Errorf("Expected domain pattern, got %v", patterns)
+	}
+	if !patterns["[file:hashes.'SHA-256' = '2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824']"] {
+		t.Errorf("Expected file hash pattern, got %v", patterns)
+	}
+}
+
+func TestStixUUID(t *testing.T) {
+	id := stixUUID("url\x00http://a.example")
+	if id != stixUUID("url\x00http://a.example") {
+		t.Error("Expected identifiers to be stable")
+	}
+	if len(id) != 36 || id[14] != '5' {
+		t.Errorf("Expected a version 5 UUID, got %s", id)
+	}
+}
@@ -0,0 +1,291 @@
+package triage
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/mimepart"
+)
+
+// ReportFileName is the triage report written to the export directory
+const ReportFileName = "triage_report.json"
+
+// maxPartDepth bounds the nesting of multipart bodies that are searched for
+// URLs
+const maxPartDepth = 10
+
+// Message is the security triage of a single message
+type Message struct {
+	ID         string    `json:"id"`
+	File       string    `json:"file"`
+	Date       time.Time `json:"date"`
+	From       string    `json:"from"`
+	ReturnPath string    `json:"return_path,omitempty"`
+	Subject    string    `json:"subject"`
+
+	// SPF, DKIM and DMARC hold the results reported by the receiving server
+	// (pass, fail, softfail, neutral, none, ...), or "" when not reported
+	SPF   string `json:"spf"`
+	DKIM  string `json:"dkim"`
+	DMARC string `json:"dmarc"`
+
+	// SenderIP is the address of the server that handed the message to the
+	// receiving server
+	SenderIP string   `json:"sender_ip,omitempty"`
+	URLs     []string `json:"urls"`
+}
+
+// Summary counts the authentication results across the report
+type Summary struct {
+	Messages int            `json:"messages"`
+	SPF      map[string]int `json:"spf"`
+	DKIM     map[string]int `json:"dkim"`
+	DMARC    map[string]int `json:"dmarc"`
+	// SenderIPs and URLs count the messages each sender IP and URL host
+	// appears in
+	SenderIPs map[string]int `json:"sender_ips"`
+	URLHosts  map[string]int `json:"url_hosts"`
+}
+
+// Report is the triage report of an export
+type Report struct {
+	Generated time.Time `json:"generated"`
+	Summary   Summary   `json:"summary"`
+	Messages  []Message `json:"messages"`
+}
+
+// NewReport creates an empty report
+func NewReport() *Report {
+	return &Report{
+		Generated: time.Now().UTC(),
+		Summary: Summary{
+			SPF:       make(map[string]int),
+			DKIM:      make(map[string]int),
+			DMARC:     make(map[string]int),
+			SenderIPs: make(map[string]int),
+			URLHosts:  make(map[string]int),
+		},
+		Messages: []Message{},
+	}
+}
+
+// Add adds a message to the report
+func (r *Report) Add(message Message) {
+	r.Messages = append(r.Messages, message)
+	r.Summary.Messages++
+	r.Summary.SPF[resultOrNone(message.SPF)]++
+	r.Summary.DKIM[resultOrNone(message.DKIM)]++
+	r.Summary.DMARC[resultOrNone(message.DMARC)]++
+	if message.SenderIP != "" {
+		r.Summary.SenderIPs[message.SenderIP]++
+	}
+
+	hosts := make(map[string]bool)
+	for _, link := range message.URLs {
+		if host := urlHost(link); host != "" && !hosts[host] {
+			hosts[host] = true
+			r.Summary.URLHosts[host]++
+		}
+	}
+}
+
+// resultOrNone returns result, or "none" when the server reported none
+func resultOrNone(result string) string {
+	if result == "" {
+		return "none"
+	}
+	return result
+}
+
+// Analyze triages a raw RFC 822 message
+func Analyze(id, file string, raw []byte) (Message, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Message{}, err
+	}
+
+	header := parsed.Header
+	date, _ := header.Date()
+	result := Message{
+		ID:         id,
+		File:       file,
+		Date:       date.UTC(),
+		From:       mimepart.DecodeHeader(header.Get("From")),
+		ReturnPath: strings.Trim(strings.TrimSpace(header.Get("Return-Path")), "<>"),
+		Subject:    mimepart.DecodeHeader(header.Get("Subject")),
+		SenderIP:   senderIP(header),
+		URLs:       []string{},
+	}
+
+	// The topmost Authentication-Results header was added by the receiving
+	// server; lower ones may have been forged by the sender
+	if results := header["Authentication-Results"]; len(results) > 0 {
+		result.SPF = authResult(results[0], "spf")
+		result.DKIM = authResult(results[0], "dkim")
+		result.DMARC = authResult(results[0], "dmarc")
+	}
+	if result.SPF == "" {
+		result.SPF = receivedSPF(header.Get("Received-SPF"))
+	}
+
+	seen := make(map[string]bool)
+	walkParts(header, parsed.Body, 0, func(part bodyPart) {
+		if part.attachment || !strings.HasPrefix(part.mediaType, "text/") {
+			return
+		}
+		for _, link := range findURLs(part.content) {
+			if !seen[link] {
+				seen[link] = true
+				result.URLs = append(result.URLs, link)
+			}
+		}
+	})
+
+	return result, nil
+}
+
+// authResultPattern matches a method result such as "dkim=pass" in an
+// Authentication-Results header
+var authResultPattern = regexp.MustCompile(`(?i)\b(spf|dkim|dmarc)\s*=\s*([a-z]+)`)
+
+// authResult returns the result of method in an Authentication-Results
+// header. When a method is reported several times, as with several DKIM
+// signatures, a pass wins.
+func authResult(header, method string) string {
+	var result string
+	for _, match := range authResultPattern.FindAllStringSubmatch(header, -1) {
+		if !strings.EqualFold(match[1], method) {
+			continue
+		}
+		value := strings.ToLower(match[2])
+		if result == "" || value == "pass" {
+			result = value
+		}
+	}
+	return result
+}
+
+// receivedSPF returns the result of a Received-SPF header
+func receivedSPF(header string) string {
+	fields := strings.Fields(header)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// clientIPPattern matches the client-ip of a Received-SPF header
+var clientIPPattern = regexp.MustCompile(`(?i)client-ip=([0-9a-f.:]+)`)
+
+// bracketIPPattern matches the bracketed address in a Received header
+var bracketIPPattern = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f.:]+)\]`)
+
+// senderIP returns the address of the server that delivered the message to
+// the receiving server, from Received-SPF or the topmost Received header
+// naming a public address
+func senderIP(header mail.Header) string {
+	if match := clientIPPattern.FindStringSubmatch(header.Get("Received-SPF")); match != nil {
+		if ip := net.ParseIP(match[1]); ip != nil {
+			return ip.String()
+		}
+	}
+
+	for _, received := range header["Received"] {
+		for _, match := range bracketIPPattern.FindAllStringSubmatch(received, -1) {
+			ip := net.ParseIP(match[1])
+			if ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() {
+				return ip.String()
+			}
+		}
+	}
+	return ""
+}
+
+// urlPattern matches http(s) URLs in a message body
+var urlPattern = regexp.MustCompile(`(?i)https?://[^\s"'<>()\[\]{}]+`)
+
+// findURLs returns the URLs in a text body. Links in HTML attributes may
+// have their ampersands escaped.
+func findURLs(content []byte) []string {
+	var links []string
+	for _, link := range urlPattern.FindAllString(string(content), -1) {
+		links = append(links, strings.TrimRight(strings.ReplaceAll(link, "&amp;", "&"), ".,;:!?"))
+	}
+	return links
+}
+
+// bodyPart is a leaf part of a message body, decoded from its transfer
+// encoding
+type bodyPart struct {
+	mediaType  string
+	filename   string
+	attachment bool
+	content    []byte
+}
+
+// partHeader is the subset of a part header used to walk a body
+type partHeader interface {
+	Get(key string) string
+}
+
+// walkParts calls visit for each leaf part of a message or part body
+func walkParts(header partHeader, body io.Reader, depth int, visit func(bodyPart)) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxPartDepth {
+			return
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				return
+			}
+			walkParts(part.Header, part, depth+1, visit)
+		}
+	}
+
+	content, err := io.ReadAll(mimepart.DecodeBody(body, header.Get("Content-Transfer-Encoding")))
+	if err != nil && len(content) == 0 {
+		return
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	visit(bodyPart{
+		mediaType:  mediaType,
+		filename:   mimepart.DecodeHeader(filename),
+		attachment: disposition == "attachment" || filename != "",
+		content:    content,
+	})
+}
+
+// urlHost returns the lower-cased host of a URL found in a body
+func urlHost(link string) string {
+	rest := link[strings.Index(link, "://")+3:]
+	if end := strings.IndexAny(rest, "/?#"); end >= 0 {
+		rest = rest[:end]
+	}
+	if at := strings.LastIndexByte(rest, '@'); at >= 0 {
+		rest = rest[at+1:]
+	}
+	if host, _, err := net.SplitHostPort(rest); err == nil {
+		rest = host
+	}
+	return strings.ToLower(rest)
+}
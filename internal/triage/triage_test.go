@@ -0,0 +1,122 @@
+package triage
+
+import (
+	"testing"
+)
+
+const phishingMessage = "Return-Path: <bounce@mailer.example.net>\r\n" +
+	"Received: from mailer.example.net (mailer.example.net. [203.0.113.7])\r\n" +
+	"        by mx.google.com with ESMTPS id abc123\r\n" +
+	"        for <victim@gmail.com>; Mon, 4 Mar 2024 10:00:00 -0800 (PST)\r\n" +
+	"Received: from internal ([10.0.0.5]) by mailer.example.net\r\n" +
+	"Authentication-Results: mx.google.com;\r\n" +
+	"       dkim=fail header.i=@bank.example header.s=s1;\r\n" +
+	"       spf=softfail (google.com: domain of transitioning bounce@mailer.example.net does not designate 203.0.113.7 as permitted sender) smtp.mailfrom=bounce@mailer.example.net;\r\n" +
+	"       dmarc=fail (p=REJECT sp=REJECT dis=QUARANTINE) header.from=bank.example\r\n" +
+	"Authentication-Results: forged.example; spf=pass; dkim=pass; dmarc=pass\r\n" +
+	"From: \"Your Bank\" <security@bank.example>\r\n" +
+	"Subject: =?UTF-8?Q?Verify_your_account?=\r\n" +
+	"Date: Mon, 4 Mar 2024 10:00:00 -0800\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"alt\"\r\n" +
+	"\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Log in at https://bank.example.login-check.example/verify?id=1. Thanks\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PGEgaHJlZj0iaHR0cHM6Ly9iYW5rLmV4YW1wbGUubG9naW4tY2hlY2suZXhhbXBsZS92ZXJpZnk/\r\n" +
+	"aWQ9MSZhbXA7dD0yIj5WZXJpZnk8L2E+\r\n" +
+	"--alt--\r\n"
+
+func TestAnalyze(t *testing.T) {
+	message, err := Analyze("18c0000000000001", "18c0000000000001.eml", []byte(phishingMessage))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	if message.SPF != "softfail" || message.DKIM != "fail" || message.DMARC != "fail" {
+		t.Errorf("Expected results of the topmost header, got spf=%s dkim=%s dmarc=%s", message.SPF, message.DKIM, message.DMARC)
+	}
+	if message.SenderIP != "203.0.113.7" {
+		t.Errorf("Expected sender IP 203.0.113.7, got %q", message.SenderIP)
+	}
+	if message.Subject != "Verify your account" {
+		t.Errorf("Expected decoded subject, got %q", message.Subject)
+	}
+	if message.ReturnPath != "bounce@mailer.example.net" {
+		t.Errorf("Unexpected return path %q", message.ReturnPath)
+	}
+
+	expected := []string{
+		"https://bank.example.login-check.example/verify?id=1",
+		"https://bank.example.login-check.example/verify?id=1&t=2",
+	}
+	if len(message.URLs) != len(expected) {
+		t.Fatalf("Expected URLs %v, got %v", expected, message.URLs)
+	}
+	for i, link := range expected {
+		if message.URLs[i] != link {
+			t.Errorf("URL %d = %q, want %q", i, message.URLs[i], link)
+		}
+	}
+}
+
+func TestAuthResult(t *testing.T) {
+	header := "mx.google.com; dkim=fail header.i=@a.example; dkim=pass header.i=@b.example; spf=none"
+
+	if result := authResult(header, "dkim"); result != "pass" {
+		t.Errorf("Expected a passing signature to win, got %q", result)
+	}
+	if result := authResult(header, "spf"); result != "none" {
+		t.Errorf("Expected spf none, got %q", result)
+	}
+	if result := authResult(header, "dmarc"); result != "" {
+		t.Errorf("Expected no dmarc result, got %q", result)
+	}
+}
+
+func TestAnalyze_ReceivedSPF(t *testing.T) {
+	raw := "Received-SPF: fail (domain does not designate sender) client-ip=198.51.100.9;\r\n" +
+		"From: a@example.com\r\n" +
+		"Subject: Hi\r\n" +
+		"\r\n" +
+		"No links here\r\n"
+
+	message, err := Analyze("1", "1.eml", []byte(raw))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if message.SPF != "fail" || message.SenderIP != "198.51.100.9" {
+		t.Errorf("Expected Received-SPF fallback, got spf=%s ip=%s", message.SPF, message.SenderIP)
+	}
+	if len(message.URLs) != 0 {
+		t.Errorf("Expected no URLs, got %v", message.URLs)
+	}
+}
+
+func TestReport_Add(t *testing.T) {
+	report := NewReport()
+	report.Add(Message{SPF: "pass", DKIM: "pass", DMARC: "pass", SenderIP: "203.0.113.7",
+		URLs: []string{"https://a.example/1", "https://A.example:443/2", "http://user@b.example?q"}})
+	report.Add(Message{SPF: "fail", SenderIP: "203.0.113.7"})
+
+	if report.Summary.Messages != 2 {
+		t.Errorf("Expected 2 messages, got %d", report.Summary.Messages)
+	}
+	if report.Summary.SPF["pass"] != 1 || report.Summary.SPF["fail"] != 1 {
+		t.Errorf("Unexpected SPF counts: %v", report.Summary.SPF)
+	}
+	if report.Summary.DKIM["none"] != 1 {
+		t.Errorf("Expected unreported DKIM counted as none, got %v", report.Summary.DKIM)
+	}
+	if report.Summary.SenderIPs["203.0.113.7"] != 2 {
+		t.Errorf("Unexpected sender IP counts: %v", report.Summary.SenderIPs)
+	}
+	if report.Summary.URLHosts["a.example"] != 1 || report.Summary.URLHosts["b.example"] != 1 {
+		t.Errorf("Unexpected URL host counts: %v", report.Summary.URLHosts)
+	}
+}
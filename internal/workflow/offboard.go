@@ -0,0 +1,115 @@
+package workflow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+)
+
+// OffboardManifestFileName is the name of the compliance manifest of an
+// offboarding, written next to the state file
+const OffboardManifestFileName = "offboarding_manifest.json"
+
+// OffboardManifestVersion identifies the offboarding manifest layout
+const OffboardManifestVersion = "gmail-exporter-offboarding/v1"
+
+// Offboard describes the offboarding of a departing user's mailbox, kept in
+// the state so that a resumed run offboards the same user
+type Offboard struct {
+	User    string `json:"user"`
+	Name    string `json:"name"`
+	Manager string `json:"manager,omitempty"`
+	// ImportLabel is the label of the manager's account the mail is filed
+	// under, and ImportLabels the labels selected for import (all if empty)
+	ImportLabel  string     `json:"import_label,omitempty"`
+	ImportLabels []string   `json:"import_labels,omitempty"`
+	AutoReply    *AutoReply `json:"auto_reply,omitempty"`
+
+	Imported int `json:"imported"`
+}
+
+// AutoReply is the vacation responder set on the departing user's account
+type AutoReply struct {
+	Subject string     `json:"subject"`
+	Message string     `json:"message"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// OffboardManifest is the signed compliance record of an offboarding. It
+// ties the steps taken to the custody manifest of the exported messages.
+type OffboardManifest struct {
+	Version         string `json:"version"`
+	ExporterVersion string `json:"exporter_version"`
+	Operator        string `json:"operator"`
+	Offboard
+	Query     string `json:"query"`
+	ExportDir string `json:"export_dir"`
+	Exported  int    `json:"exported"`
+	// CustodyManifest and CustodyManifestSHA256 identify the signed custody
+	// manifest of the export
+	CustodyManifest       string      `json:"custody_manifest"`
+	CustodyManifestSHA256 string      `json:"custody_manifest_sha256"`
+	Steps                 []StepState `json:"steps"`
+	CreatedAt             time.Time   `json:"created_at"`
+
+	// Signatures are made with custody.Sign and custody.SignEd25519, as
+	// those of custody manifests
+	custody.Signatures
+}
+
+// NewOffboardManifest records the offboarding of state, whose export step
+// wrote a custody manifest, and the steps run before the manifest step
+func NewOffboardManifest(state *State, exporterVersion string, now time.Time) (*OffboardManifest, error) {
+	if state.Offboard == nil {
+		return nil, fmt.Errorf("workflow state has no offboarding")
+	}
+
+	path := filepath.Join(state.Artifacts.ExportDir, custody.ManifestFileName)
+	custodyManifest, err := custody.LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read custody manifest: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	manifest := &OffboardManifest{
+		Version:               OffboardManifestVersion,
+		ExporterVersion:       exporterVersion,
+		Operator:              custodyManifest.Operator,
+		Offboard:              *state.Offboard,
+		Query:                 custodyManifest.Query,
+		ExportDir:             state.Artifacts.ExportDir,
+		Exported:              len(custodyManifest.Messages),
+		CustodyManifest:       path,
+		CustodyManifestSHA256: hex.EncodeToString(sum[:]),
+		CreatedAt:             now.UTC(),
+	}
+	for _, step := range state.Steps {
+		if step.Name != StepManifest {
+			manifest.Steps = append(manifest.Steps, step)
+		}
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest to path
+func (m *OffboardManifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal offboarding manifest: %w", err)
+	}
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write offboarding manifest: %w", err)
+	}
+	return nil
+}
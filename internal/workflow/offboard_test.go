@@ -0,0 +1,99 @@
+package workflow
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/custody"
+)
+
+func TestNewOffboardManifest(t *testing.T) {
+	dir := t.TempDir()
+	exportDir := filepath.Join(dir, "exports")
+	if err := os.MkdirAll(exportDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	custodyManifest := &custody.Manifest{
+		Version:  custody.ManifestVersion,
+		Operator: "hr-admin",
+		Query:    "after:2020/01/01",
+		Messages: []custody.Record{{ID: "m1"}, {ID: "m2"}},
+	}
+	custodyPath := filepath.Join(exportDir, custody.ManifestFileName)
+	if err := custodyManifest.Save(custodyPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(custodyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+
+	state := NewState(filepath.Join(dir, StateFileName), []string{StepExport, StepImport, StepAutoReply, StepManifest})
+	state.Artifacts.ExportDir = exportDir
+	state.Offboard = &Offboard{User: "jane@example.com", Name: "jane", Manager: "boss@example.com", ImportLabel: "Offboarded/jane", Imported: 2}
+
+	manifest, err := NewOffboardManifest(state, "1.2.3", time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("NewOffboardManifest() error = %v", err)
+	}
+	if manifest.Operator != "hr-admin" || manifest.Query != "after:2020/01/01" || manifest.Exported != 2 || manifest.User != "jane@example.com" {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+	if manifest.CustodyManifestSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("CustodyManifestSHA256 = %s, want the checksum of the custody manifest", manifest.CustodyManifestSHA256)
+	}
+	if len(manifest.Steps) != 3 {
+		t.Errorf("Expected the steps before the manifest step, got %+v", manifest.Steps)
+	}
+
+	if _, err := NewOffboardManifest(NewState(state.Path(), nil), "1.2.3", time.Now()); err == nil {
+		t.Error("Expected an error without an offboarding")
+	}
+}
+
+func TestOffboardManifestSignature(t *testing.T) {
+	key := []byte("secret")
+	manifest := &OffboardManifest{Version: OffboardManifestVersion, Offboard: Offboard{User: "jane@example.com"}}
+	if err := custody.Sign(manifest, key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := custody.Verify(manifest, key); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	manifest.Imported = 10
+	if err := custody.Verify(manifest, key); err == nil {
+		t.Error("Expected a tampered manifest to fail verification")
+	}
+	if err := custody.Verify(manifest, []byte("other")); err == nil {
+		t.Error("Expected verification with another key to fail")
+	}
+}
+
+func TestOffboardManifestEd25519(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &OffboardManifest{Version: OffboardManifestVersion, Offboard: Offboard{User: "jane@example.com"}}
+	if err := custody.SignEd25519(manifest, private); err != nil {
+		t.Fatalf("SignEd25519() error = %v", err)
+	}
+	if err := custody.Sign(manifest, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := custody.VerifyEd25519(manifest, public); err != nil {
+		t.Errorf("VerifyEd25519() error = %v", err)
+	}
+
+	manifest.Imported = 10
+	if err := custody.VerifyEd25519(manifest, public); err == nil {
+		t.Error("Expected a tampered manifest to fail verification")
+	}
+}
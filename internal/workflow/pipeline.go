@@ -0,0 +1,140 @@
+package workflow
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/hooks"
+)
+
+// Notification conditions
+const (
+	NotifyAlways  = "always"
+	NotifySuccess = "success"
+	NotifyFailure = "failure"
+)
+
+// Pipeline is a workflow defined in a file, so that a migration can be
+// reviewed before it runs and repeated exactly
+type Pipeline struct {
+	Name string `mapstructure:"name"`
+	// Steps are the steps to run, in order; all of them by default
+	Steps []string `mapstructure:"steps"`
+
+	OutputDir       string `mapstructure:"output_dir"`
+	StateFile       string `mapstructure:"state_file"`
+	ParallelWorkers int    `mapstructure:"parallel_workers"`
+	Limit           int    `mapstructure:"limit"`
+
+	// Filters are export filter settings, named as in presets
+	Filters map[string]any `mapstructure:"filters"`
+
+	Import struct {
+		Credentials string `mapstructure:"credentials"`
+		Token       string `mapstructure:"token"`
+	} `mapstructure:"import"`
+
+	Cleanup struct {
+		Action string `mapstructure:"action"`
+		DryRun bool   `mapstructure:"dry_run"`
+		// Yes skips the typed confirmation of a delete
+		Yes bool `mapstructure:"yes"`
+	} `mapstructure:"cleanup"`
+
+	Notifications []Notification `mapstructure:"notifications"`
+}
+
+// Notification is a command or URL told the outcome of the pipeline
+type Notification struct {
+	hooks.Hook `mapstructure:",squash"`
+	// On is when to notify: always (the default), success or failure
+	On string `mapstructure:"on"`
+}
+
+// stepOrder is the order steps run in
+var stepOrder = []string{StepExport, StepImport, StepCleanup}
+
+// Validate checks the pipeline and fills in its defaults
+func (p *Pipeline) Validate() error {
+	if len(p.Steps) == 0 {
+		p.Steps = slices.Clone(stepOrder)
+	}
+	last := -1
+	for _, step := range p.Steps {
+		index := slices.Index(stepOrder, step)
+		if index < 0 {
+			return fmt.Errorf("unknown step %q (valid: %s)", step, strings.Join(stepOrder, ", "))
+		}
+		if index <= last {
+			return fmt.Errorf("steps must be listed once each, in the order %s", strings.Join(stepOrder, ", "))
+		}
+		last = index
+	}
+	if p.Steps[0] != StepExport {
+		return fmt.Errorf("the first step must be %s, which produces the files later steps use", StepExport)
+	}
+
+	if slices.Contains(p.Steps, StepCleanup) {
+		if p.Cleanup.Action == "" {
+			p.Cleanup.Action = "archive"
+		}
+		if p.Cleanup.Action != "archive" && p.Cleanup.Action != "delete" {
+			return fmt.Errorf("invalid cleanup action: %s (valid: archive, delete)", p.Cleanup.Action)
+		}
+	}
+
+	all := make([]hooks.Hook, 0, len(p.Notifications))
+	for i := range p.Notifications {
+		notification := &p.Notifications[i]
+		notification.Event = hooks.EventRun
+		if notification.On == "" {
+			notification.On = NotifyAlways
+		}
+		if notification.On != NotifyAlways && notification.On != NotifySuccess && notification.On != NotifyFailure {
+			return fmt.Errorf("notification %d: invalid on %q (valid: %s, %s, %s)", i+1, notification.On, NotifyAlways, NotifySuccess, NotifyFailure)
+		}
+		all = append(all, notification.Hook)
+	}
+	return hooks.Validate(all)
+}
+
+// NotificationHooks returns the hooks of the notifications due for a run
+// that ended with err
+func (p *Pipeline) NotificationHooks(err error) []hooks.Hook {
+	var due []hooks.Hook
+	for _, notification := range p.Notifications {
+		if (notification.On == NotifySuccess && err != nil) || (notification.On == NotifyFailure && err == nil) {
+			continue
+		}
+		due = append(due, notification.Hook)
+	}
+	return due
+}
+
+// Outcome is what notifications are told about a finished pipeline
+type Outcome struct {
+	Event     string      `json:"event"`
+	Pipeline  string      `json:"pipeline"`
+	Succeeded bool        `json:"succeeded"`
+	Error     string      `json:"error,omitempty"`
+	Steps     []StepState `json:"steps"`
+	Artifacts Artifacts   `json:"artifacts"`
+	StateFile string      `json:"state_file"`
+}
+
+// NewOutcome describes a pipeline run that ended with err
+func NewOutcome(name string, state *State, err error) Outcome {
+	outcome := Outcome{
+		Event:     "workflow",
+		Pipeline:  name,
+		Succeeded: err == nil,
+		Steps:     state.Steps,
+		Artifacts: state.Artifacts,
+		StateFile: state.Path(),
+	}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	return outcome
+}
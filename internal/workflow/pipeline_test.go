@@ -0,0 +1,74 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/hooks"
+)
+
+func TestPipelineValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []string
+		action  string
+		wantErr bool
+	}{
+		{"all steps by default", nil, "", false},
+		{"export and cleanup", []string{StepExport, StepCleanup}, "delete", false},
+		{"unknown step", []string{StepExport, "forward"}, "", true},
+		{"out of order", []string{StepExport, StepCleanup, StepImport}, "", true},
+		{"repeated step", []string{StepExport, StepExport}, "", true},
+		{"no export", []string{StepImport}, "", true},
+		{"invalid action", []string{StepExport, StepCleanup}, "shred", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := &Pipeline{Steps: tt.steps}
+			pipeline.Cleanup.Action = tt.action
+			err := pipeline.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	pipeline := &Pipeline{}
+	if err := pipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline.Steps) != 3 || pipeline.Cleanup.Action != "archive" {
+		t.Errorf("Expected all steps with the archive action, got %v and %q", pipeline.Steps, pipeline.Cleanup.Action)
+	}
+}
+
+func TestPipelineNotificationHooks(t *testing.T) {
+	pipeline := &Pipeline{Notifications: []Notification{
+		{Hook: hooks.Hook{Name: "always", Command: "true"}},
+		{Hook: hooks.Hook{Name: "ok", Command: "true"}, On: NotifySuccess},
+		{Hook: hooks.Hook{Name: "failed", Command: "true"}, On: NotifyFailure},
+	}}
+	if err := pipeline.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	names := func(due []hooks.Hook) string {
+		var s string
+		for _, hook := range due {
+			s += hook.Name + " "
+		}
+		return s
+	}
+	if got := names(pipeline.NotificationHooks(nil)); got != "always ok " {
+		t.Errorf("Expected the always and success hooks, got %q", got)
+	}
+	if got := names(pipeline.NotificationHooks(errors.New("failed"))); got != "always failed " {
+		t.Errorf("Expected the always and failure hooks, got %q", got)
+	}
+
+	pipeline.Notifications[0].On = "sometimes"
+	if err := pipeline.Validate(); err == nil {
+		t.Error("Expected an invalid on to fail validation")
+	}
+}
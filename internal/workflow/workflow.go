@@ -0,0 +1,208 @@
+// Package workflow runs the export, import and cleanup steps of a workflow,
+// recording each step and the files it produced in a state file so that a
+// failed run resumes at the step that failed
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/octasoft-ltd/gmail-exporter/internal/atomicfile"
+	"github.com/octasoft-ltd/gmail-exporter/internal/filters"
+)
+
+// StateFileName is the name of the state file, written next to the export
+// directory by default
+const StateFileName = "workflow_state.json"
+
+// Step names
+const (
+	StepExport    = "export"
+	StepImport    = "import"
+	StepCleanup   = "cleanup"
+	StepAutoReply = "auto_reply"
+	StepManifest  = "manifest"
+)
+
+// Step statuses
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// StepState is the progress of one step
+type StepState struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Artifacts are the files the steps produce and later steps and resumed
+// runs use
+type Artifacts struct {
+	ExportDir string `json:"export_dir,omitempty"`
+	// FilterFile lists the exported messages, for the cleanup step
+	FilterFile string `json:"filter_file,omitempty"`
+	// MappingFile records which exported files were imported, so a resumed
+	// import skips them
+	MappingFile string `json:"mapping_file,omitempty"`
+	// ManifestFile is the compliance manifest of an offboarding
+	ManifestFile string `json:"manifest_file,omitempty"`
+}
+
+// State is the progress of a workflow
+type State struct {
+	Created   time.Time   `json:"created"`
+	Updated   time.Time   `json:"updated"`
+	Steps     []StepState `json:"steps"`
+	Artifacts Artifacts   `json:"artifacts"`
+	// Filter selects the exported messages, kept so that a resumed export
+	// matches the same messages
+	Filter *filters.Config `json:"filter,omitempty"`
+	// Offboard is set for the offboard workflow
+	Offboard *Offboard `json:"offboard,omitempty"`
+
+	path string
+}
+
+// NewState returns the state of a workflow of the named steps, none of
+// them run, saved to path
+func NewState(path string, steps []string) *State {
+	state := &State{Created: time.Now(), path: path}
+	for _, name := range steps {
+		state.Steps = append(state.Steps, StepState{Name: name, Status: StatusPending})
+	}
+	return state
+}
+
+// Load reads the state saved at path. The error wraps os.ErrNotExist when
+// there is none.
+func Load(path string) (*State, error) {
+	state := &State{path: path}
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		*state = State{path: path}
+		return json.Unmarshal(data, state)
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no workflow state at %s: %w", path, err)
+		}
+		return nil, fmt.Errorf("failed to read workflow state: %w", err)
+	}
+	return state, nil
+}
+
+// Path returns the file the state is saved to
+func (s *State) Path() string {
+	return s.path
+}
+
+// Save writes the state to its file
+func (s *State) Save() error {
+	s.Updated = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow state: %w", err)
+	}
+	if err := atomicfile.WriteFile(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write workflow state: %w", err)
+	}
+	return nil
+}
+
+// Step returns the progress of the named step, or nil when the workflow
+// has no such step
+func (s *State) Step(name string) *StepState {
+	for i := range s.Steps {
+		if s.Steps[i].Name == name {
+			return &s.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Next returns the name of the first step not done, or "" when all are
+func (s *State) Next() string {
+	for _, step := range s.Steps {
+		if step.Status != StatusDone {
+			return step.Name
+		}
+	}
+	return ""
+}
+
+// Matches reports whether the state is of a workflow of the named steps
+func (s *State) Matches(steps []string) bool {
+	if len(s.Steps) != len(steps) {
+		return false
+	}
+	for i, name := range steps {
+		if s.Steps[i].Name != name {
+			return false
+		}
+	}
+	return true
+}
+
+// Step is a step to run. Run may record artifacts in the state; resumed
+// tells it that an earlier run of the step was interrupted or failed, so
+// it should continue that run's work.
+type Step struct {
+	Name string
+	Run  func(state *State, resumed bool) error
+	// DryRun marks a step that only simulates its work. It stays pending
+	// when it succeeds, to run for real later.
+	DryRun bool
+}
+
+// Run runs the steps not yet done in order, saving the state before and
+// after each, and stops at the first step that fails
+func Run(state *State, steps []Step) error {
+	for _, step := range steps {
+		progress := state.Step(step.Name)
+		if progress == nil {
+			return fmt.Errorf("workflow state has no %s step", step.Name)
+		}
+		if progress.Status == StatusDone {
+			logrus.WithField("step", step.Name).Info("Skipping workflow step completed by a previous run")
+			continue
+		}
+
+		resumed := progress.Status == StatusRunning || progress.Status == StatusFailed
+		started := time.Now()
+		progress.Status, progress.Error, progress.Started, progress.Finished = StatusRunning, "", &started, nil
+		if err := state.Save(); err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{"step": step.Name, "resumed": resumed}).Info("Running workflow step")
+		runErr := step.Run(state, resumed)
+
+		finished := time.Now()
+		progress.Finished = &finished
+		switch {
+		case runErr != nil:
+			progress.Status, progress.Error = StatusFailed, runErr.Error()
+		case step.DryRun:
+			progress.Status, progress.Started, progress.Finished = StatusPending, nil, nil
+		default:
+			progress.Status = StatusDone
+		}
+		if err := state.Save(); err != nil {
+			return err
+		}
+		if runErr != nil {
+			return fmt.Errorf("workflow step %s failed: %w", step.Name, runErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var steps = []string{StepExport, StepImport, StepCleanup}
+
+func TestRunResumesAtFailedStep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StateFileName)
+	state := NewState(path, steps)
+
+	var ran []string
+	failImport := true
+	run := func(name string) Step {
+		return Step{Name: name, Run: func(state *State, resumed bool) error {
+			ran = append(ran, name)
+			switch name {
+			case StepExport:
+				state.Artifacts.ExportDir = "exports"
+			case StepImport:
+				if failImport {
+					return errors.New("quota exceeded")
+				}
+				if !resumed {
+					t.Error("Expected the import to be resumed")
+				}
+			}
+			return nil
+		}}
+	}
+	workflowSteps := []Step{run(StepExport), run(StepImport), run(StepCleanup)}
+
+	if err := Run(state, workflowSteps); err == nil {
+		t.Fatal("Expected the import failure to be returned")
+	}
+
+	saved, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := saved.Next(); got != StepImport {
+		t.Errorf("Next() = %q, want %q", got, StepImport)
+	}
+	if step := saved.Step(StepImport); step.Status != StatusFailed || step.Error != "quota exceeded" {
+		t.Errorf("Expected the failed import to be recorded, got %+v", step)
+	}
+	if saved.Artifacts.ExportDir != "exports" {
+		t.Errorf("Expected the export dir artifact, got %q", saved.Artifacts.ExportDir)
+	}
+
+	ran, failImport = nil, false
+	if err := Run(saved, workflowSteps); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(ran) != 2 || ran[0] != StepImport || ran[1] != StepCleanup {
+		t.Errorf("Expected import and cleanup to run, got %v", ran)
+	}
+	if got := saved.Next(); got != "" {
+		t.Errorf("Next() = %q, want all steps done", got)
+	}
+}
+
+func TestRunDryRunStepStaysPending(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), StateFileName), []string{StepCleanup})
+
+	err := Run(state, []Step{{Name: StepCleanup, DryRun: true, Run: func(*State, bool) error { return nil }}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if step := state.Step(StepCleanup); step.Status != StatusPending {
+		t.Errorf("Expected the dry run to leave the step pending, got %s", step.Status)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), StateFileName))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	state := NewState("", steps)
+	if !state.Matches(steps) {
+		t.Error("Expected the state to match its own steps")
+	}
+	if state.Matches([]string{StepExport, StepImport}) {
+		t.Error("Expected a different step list not to match")
+	}
+}